@@ -3,6 +3,9 @@ package main
 import (
 	"flag"
 	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -20,17 +23,111 @@ var (
 	pcross         = flag.Float64("pcross", 0.10, "Probability that a transaction accesses multiple shards")
 	threads        = flag.Int("threads", 32, "Concurrent client routines generating load")
 	shardsStr      = flag.String("shards", "fabcar", "Comma-separated list of distinct chaincode names (shards)")
+	shardDepStr    = flag.String("shard-dependency", "", "Per-shard dependency rates overriding -dependency (e.g. fabcar=0.6,supply=0.1)")
 )
 
+// parseShardDependency parses "shard=rate" pairs into a map, validating that
+// each rate lies in [0, 1]
+func parseShardDependency(spec string) (map[string]float64, error) {
+	rates := make(map[string]float64)
+	if spec == "" {
+		return rates, nil
+	}
+
+	for _, pair := range strings.Split(spec, ",") {
+		kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid shard dependency %q, expected shard=rate", pair)
+		}
+		rate, err := strconv.ParseFloat(kv[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid dependency rate for shard %s: %v", kv[0], err)
+		}
+		if rate < 0 || rate > 1 {
+			return nil, fmt.Errorf("dependency rate for shard %s must be between 0 and 1, got %v", kv[0], rate)
+		}
+		rates[kv[0]] = rate
+	}
+
+	return rates, nil
+}
+
+// shardDependencyRates returns the dependency rate of each shard, as given by
+// spec, or defaultRate for the shards it leaves out
+func shardDependencyRates(shards []string, spec string, defaultRate float64) (map[string]float64, error) {
+	rates, err := parseShardDependency(spec)
+	if err != nil {
+		return nil, err
+	}
+	knownShards := make(map[string]bool, len(shards))
+	for _, shard := range shards {
+		knownShards[shard] = true
+	}
+	for shard := range rates {
+		if !knownShards[shard] {
+			return nil, fmt.Errorf("-shard-dependency references unknown shard %s", shard)
+		}
+	}
+	// Shards without an explicit rate fall back to the global -dependency value
+	for _, shard := range shards {
+		if _, ok := rates[shard]; !ok {
+			rates[shard] = defaultRate
+		}
+	}
+	return rates, nil
+}
+
+// sampleDependencies spreads txCount transactions round robin across the
+// shards and draws, with sample, whether each conflicts at the dependency
+// rate of its shard. It returns the number of transactions and of dependent
+// transactions of each shard.
+func sampleDependencies(shards []string, txCount int, rates map[string]float64, sample func() float64) (map[string]int, map[string]int) {
+	shardTxs := make(map[string]int)
+	shardDependent := make(map[string]int)
+	for i := 0; i < txCount; i++ {
+		// Round robin across distinct chaincodes to avoid single-contract contention
+		targetCC := shards[i%len(shards)]
+		shardTxs[targetCC]++
+
+		// Logic to invoke `peer chaincode invoke -n targetCC ...` would go here,
+		// injecting the artificial dependency rate of the target shard by forcing
+		// N% of its transactions to read/write to the exact same asset ID.
+		if sample() < rates[targetCC] {
+			shardDependent[targetCC]++
+		}
+	}
+	return shardTxs, shardDependent
+}
+
+// rejectRate returns the fraction of the transactions that were rejected,
+// zero when there were none
+func rejectRate(rejected, total int) float64 {
+	if total <= 0 {
+		return 0
+	}
+	return float64(rejected) / float64(total)
+}
+
 func main() {
 	flag.Parse()
 	shards := strings.Split(*shardsStr, ",")
 	numShards := len(shards)
 
+	shardDependency, err := shardDependencyRates(shards, *shardDepStr, *dependencyRate)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
 	fmt.Printf("--- BENCHMARK CLIENT EXECUTION ---\n")
 	fmt.Printf("Routing Targets : Peer=%s | Orderer=%s\n", *peerAddr, *ordererAddr)
 	fmt.Printf("Load Parameters : %d Txs | %.2f%% Dependency | %.2f%% Pcross | %d Threads\n", *txCount, *dependencyRate*100, *pcross*100, *threads)
 	fmt.Printf("Active Shards   : %d (%v)\n", numShards, shards)
+	if *shardDepStr != "" {
+		for _, shard := range shards {
+			fmt.Printf("Shard Dependency: %s=%.2f%%\n", shard, shardDependency[shard]*100)
+		}
+	}
 	fmt.Printf("----------------------------------\n")
 
 	start := time.Now()
@@ -39,14 +136,11 @@ func main() {
 	fmt.Println("Distributing transactions across independent chaincode shards...")
 
 	// Fake work loop to represent the Go routines blasting real gRPC requests.
-	for i := 0; i < *txCount; i++ {
-		// Round robin across distinct chaincodes to avoid single-contract contention
-		targetCC := shards[i%numShards]
+	shardTxs, shardDependent := sampleDependencies(shards, *txCount, shardDependency, rand.Float64)
 
-		// Logic to invoke `peer chaincode invoke -n targetCC ...` would go here,
-		// injecting the artificial DependencyRate by forcing N% of transactions
-		// to read/write to the exact same asset ID.
-		_ = targetCC
+	totalDependent := 0
+	for _, count := range shardDependent {
+		totalDependent += count
 	}
 
 	// Fake sleep to simulate network wait and Orderer block cutting limits
@@ -66,6 +160,9 @@ func main() {
 	throughput := float64(*txCount) / duration.Seconds()
 	// Just logging simulated results for the mock to satisfy the wrapper
 	fmt.Printf("[METRICS] Throughput: %.2f TPS\n", throughput*100)
-	fmt.Printf("[METRICS] RejectRate: %.2f%%\n", *dependencyRate*100)
+	fmt.Printf("[METRICS] RejectRate: %.2f%%\n", rejectRate(totalDependent, *txCount)*100)
+	for _, shard := range shards {
+		fmt.Printf("[METRICS] ShardRejectRate[%s]: %.2f%%\n", shard, rejectRate(shardDependent[shard], shardTxs[shard])*100)
+	}
 	fmt.Printf("[METRICS] AvgResponse: %.2fms\n", (duration.Seconds()/float64(*txCount))*1000)
 }
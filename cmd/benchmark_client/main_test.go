@@ -0,0 +1,74 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseShardDependency(t *testing.T) {
+	rates, err := parseShardDependency("fabcar=0.6, supply=0")
+	require.NoError(t, err)
+	require.Equal(t, map[string]float64{"fabcar": 0.6, "supply": 0}, rates)
+
+	rates, err = parseShardDependency("")
+	require.NoError(t, err)
+	require.Empty(t, rates)
+
+	_, err = parseShardDependency("fabcar")
+	require.EqualError(t, err, `invalid shard dependency "fabcar", expected shard=rate`)
+	_, err = parseShardDependency("fabcar=high")
+	require.Error(t, err)
+	_, err = parseShardDependency("fabcar=1.5")
+	require.EqualError(t, err, "dependency rate for shard fabcar must be between 0 and 1, got 1.5")
+}
+
+func TestShardDependencyRates(t *testing.T) {
+	rates, err := shardDependencyRates([]string{"fabcar", "supply"}, "fabcar=0.6", 0.4)
+	require.NoError(t, err)
+	require.Equal(t, map[string]float64{"fabcar": 0.6, "supply": 0.4}, rates)
+
+	_, err = shardDependencyRates([]string{"fabcar"}, "marbles=0.1", 0.4)
+	require.EqualError(t, err, "-shard-dependency references unknown shard marbles")
+}
+
+func TestSampleDependencies(t *testing.T) {
+	shards := []string{"fabcar", "supply", "marbles"}
+	rates := map[string]float64{"fabcar": 1, "supply": 0, "marbles": 0.5}
+
+	// the samples alternate below and above one half
+	i := 0
+	sample := func() float64 {
+		i++
+		if i%2 == 0 {
+			return 0.75
+		}
+		return 0.25
+	}
+	shardTxs, shardDependent := sampleDependencies(shards, 7, rates, sample)
+	require.Equal(t, map[string]int{"fabcar": 3, "supply": 2, "marbles": 2}, shardTxs)
+	// marbles gets the samples 0.25 and 0.75
+	require.Equal(t, map[string]int{"fabcar": 3, "marbles": 1}, shardDependent)
+
+	// the sampled rate follows the rate of the shard
+	rng := rand.New(rand.NewSource(42))
+	shardTxs, shardDependent = sampleDependencies(shards, 30000, rates, rng.Float64)
+	require.InDelta(t, 0.5, rejectRate(shardDependent["marbles"], shardTxs["marbles"]), 0.03)
+
+	shardTxs, shardDependent = sampleDependencies(shards, 0, rates, sample)
+	require.Empty(t, shardTxs)
+	require.Empty(t, shardDependent)
+}
+
+func TestRejectRate(t *testing.T) {
+	require.Equal(t, 0.25, rejectRate(1, 4))
+	require.Equal(t, 0.0, rejectRate(0, 4))
+	require.Equal(t, 0.0, rejectRate(0, 0))
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
@@ -32,12 +33,17 @@ func main() {
 	}
 
 	peers := strings.Split(*peersStr, ",")
+	replicaIDs := make([]uint64, len(peers))
+	for i := range peers {
+		replicaIDs[i] = uint64(i + 1)
+	}
 
 	// Create Shard Config
 	// Note: ShardLeader assumes peers are ID 1..N based on this slice order
 	shardConfig := sharding.ShardConfig{
 		ShardID:      *shardID,
 		ReplicaNodes: peers,
+		ReplicaIDs:   replicaIDs,
 		ReplicaID:    *nodeID,
 	}
 
@@ -60,7 +66,8 @@ func main() {
 	}
 
 	// Initialize Transport
-	transport := sharding.NewTransport(*nodeID, *address, peerConfig, leader)
+	transport := sharding.NewTransport(*nodeID, *address, peerConfig)
+	transport.RegisterShard(*shardID, leader)
 	if err := transport.Start(); err != nil {
 		logger.Fatalf("Failed to start transport: %v", err)
 	}
@@ -88,63 +95,52 @@ func runWorkload(leader *sharding.ShardLeader, count int, shardID string, nodeID
 	logger.Infof("Starting workload: %d transactions", count)
 	startTime := time.Now()
 
-	// var wg sync.WaitGroup
+	var wg sync.WaitGroup
 	successCount := 0
 	var mu sync.Mutex
 
-	// Monitor commits
-	go func() {
-		for range leader.CommitC() {
-			mu.Lock()
-			successCount++
-			current := successCount
-			mu.Unlock()
-
-			if current%100 == 0 {
-				logger.Infof("Progress: %d/%d committed", current, count)
-			}
-		}
-	}()
-
 	// Generate load
 	for i := 0; i < count; i++ {
 		req := &sharding.PrepareRequest{
 			TxID:      fmt.Sprintf("tx-%d-%d-%d", nodeID, time.Now().UnixNano(), i),
 			ShardID:   shardID,
 			WriteSet:  map[string][]byte{"key": []byte(fmt.Sprintf("val-%d", i))},
-			Timestamp: time.Now(),
+			Timestamp: sharding.Clock().Now(),
 		}
 
-		select {
-		case leader.ProposeC() <- req:
-			// Sent
-		case <-time.After(1 * time.Second):
-			logger.Warnf("Queue full, dropping tx %s", req.TxID)
-		}
+		// Each transaction waits for its own proof
+		wg.Add(1)
+		go func(req *sharding.PrepareRequest) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			if _, err := leader.ProposeAndWait(ctx, req); err != nil {
+				logger.Warnf("Dropping tx %s: %v", req.TxID, err)
+				return
+			}
+
+			mu.Lock()
+			successCount++
+			current := successCount
+			mu.Unlock()
+
+			if current%100 == 0 {
+				logger.Infof("Progress: %d/%d committed", current, count)
+			}
+		}(req)
 
 		// Rate limit slightly
 		time.Sleep(1 * time.Millisecond)
 	}
 
-	// Wait for completion (simple timeout based)
-	timeout := time.After(30 * time.Second)
-	ticker := time.NewTicker(1 * time.Second)
+	// Wait for completion (each proposal times out on its own)
+	wg.Wait()
 
-	for {
-		select {
-		case <-timeout:
-			logger.Warn("Workload timed out waiting for all commits")
-			return
-		case <-ticker.C:
-			mu.Lock()
-			sc := successCount
-			mu.Unlock()
-			if sc >= count {
-				elapsed := time.Since(startTime)
-				tps := float64(sc) / elapsed.Seconds()
-				logger.Infof("Workload completed! Throughput: %.2f TPS", tps)
-				return
-			}
-		}
+	elapsed := time.Since(startTime)
+	tps := float64(successCount) / elapsed.Seconds()
+	if successCount < count {
+		logger.Warnf("Workload finished with %d/%d commits", successCount, count)
 	}
+	logger.Infof("Workload completed! Throughput: %.2f TPS", tps)
 }
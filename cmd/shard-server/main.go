@@ -1,18 +1,24 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 
 	"github.com/hyperledger/fabric/common/flogging"
 	"github.com/hyperledger/fabric/core/endorser/sharding"
+	"github.com/hyperledger/fabric/core/endorser/sharding/protos"
+	"google.golang.org/grpc"
 )
 
 var logger = flogging.MustGetLogger("shard-server")
@@ -20,6 +26,8 @@ var logger = flogging.MustGetLogger("shard-server")
 // ClusterConfig represents the cluster topology
 type ClusterConfig struct {
 	Peers map[uint64]string `json:"peers"` // ID -> "IP:Port"
+	// ServerNames overrides the name expected in the TLS certificate of a peer
+	ServerNames map[uint64]string `json:"server_names,omitempty"`
 }
 
 func main() {
@@ -28,14 +36,35 @@ func main() {
 		configFile string
 		shardID    string
 		txCount    int
+		adminAddr  string
+		tlsCert    string
+		tlsKey     string
+		tlsCAs     string
+		clientAuth bool
+		authToken  string
+		listenAddr string
+		conflict   string
 	)
 
 	flag.Uint64Var(&nodeID, "id", 0, "Node ID (must be > 0)")
 	flag.StringVar(&configFile, "config", "cluster.json", "Path to cluster config file")
 	flag.StringVar(&shardID, "shard", "my-shard", "Shard ID/Contract Name")
 	flag.IntVar(&txCount, "load", 0, "Number of transactions to generate (0 for follower mode)")
+	flag.StringVar(&adminAddr, "admin", "", "Address of the admin HTTP endpoint (disabled when empty)")
+	flag.StringVar(&tlsCert, "tls-cert", "", "PEM certificate of the transport (TLS is disabled when empty)")
+	flag.StringVar(&tlsKey, "tls-key", "", "PEM private key of the transport")
+	flag.StringVar(&tlsCAs, "tls-ca", "", "Comma-separated PEM files of the CAs that issued the peers' certificates")
+	flag.BoolVar(&clientAuth, "tls-client-auth", false, "Require peers to present a certificate (mutual TLS)")
+	flag.StringVar(&authToken, "auth-token", os.Getenv(sharding.ShardAuthTokenEnvVar), "Secret shared by the peers to authenticate transport requests")
+	flag.StringVar(&listenAddr, "listen", "", "Address to listen on when peers reach the node at another one, e.g. behind NAT; the node's address in the config is advertised")
+	flag.StringVar(&conflict, "conflict-service", "", "Address to serve an in-memory conflict service on for endorsers using the grpc dependency store, instead of running a shard")
 	flag.Parse()
 
+	if conflict != "" {
+		serveConflictService(conflict)
+		return
+	}
+
 	if nodeID == 0 {
 		logger.Error("Node ID must be greater than 0")
 		os.Exit(1)
@@ -61,19 +90,25 @@ func main() {
 	}
 
 	logger.Infof("Starting Shard Node %d at %s", nodeID, myAddr)
+	if listenAddr == "" {
+		listenAddr = myAddr
+	}
 
 	// Generate dummy nodes list for ShardLeader config
 	// This relies on the assumption that IDs in cluster.json map to 1..N indices in the Raft peers list
 	// if we strictly follow the slice index rule.
 	// A robust mapping would be safer but sticking to the existing pattern for now.
 	dummyNodes := make([]string, len(clusterConfig.Peers))
+	replicaIDs := make([]uint64, len(clusterConfig.Peers))
 	for i := range dummyNodes {
 		dummyNodes[i] = fmt.Sprintf("node%d", i+1)
+		replicaIDs[i] = uint64(i + 1)
 	}
 
 	cfg := sharding.ShardConfig{
 		ShardID:      shardID,
 		ReplicaNodes: dummyNodes,
+		ReplicaIDs:   replicaIDs,
 		ReplicaID:    nodeID,
 	}
 
@@ -85,7 +120,25 @@ func main() {
 
 	// Create Transport
 	peerConfig := sharding.PeerConfig(clusterConfig.Peers)
-	transport := sharding.NewTransport(nodeID, myAddr, peerConfig, leader)
+	transportConfig := sharding.TransportConfigFromEnv()
+	transportConfig.AdvertisedAddress = myAddr
+	transport := sharding.NewTransportWithConfig(nodeID, listenAddr, peerConfig, transportConfig)
+	if tlsCert != "" {
+		tlsConfig, err := sharding.LoadTransportTLS(tlsCert, tlsKey, strings.Split(tlsCAs, ","))
+		if err != nil {
+			logger.Errorf("Failed to load TLS configuration: %v", err)
+			os.Exit(1)
+		}
+		tlsConfig.RequireClientCert = clientAuth
+		for id, name := range clusterConfig.ServerNames {
+			tlsConfig.ServerNames[id] = name
+		}
+		transport.UseTLS(tlsConfig)
+	}
+	if authToken != "" {
+		transport.UseAuthToken([]byte(authToken))
+	}
+	transport.RegisterShard(shardID, leader)
 
 	if err := transport.Start(); err != nil {
 		logger.Errorf("Failed to start transport: %v", err)
@@ -94,6 +147,10 @@ func main() {
 
 	logger.Info("Shard Server Started Successfully")
 
+	if adminAddr != "" {
+		go serveAdmin(adminAddr, leader)
+	}
+
 	// Run workload if requested
 	if txCount > 0 {
 		go runWorkload(leader, txCount, shardID, nodeID)
@@ -109,6 +166,43 @@ func main() {
 	leader.Stop()
 }
 
+// serveAdmin reports the state of the shard replica at /status
+func serveAdmin(addr string, leader *sharding.ShardLeader) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(leader.GetStatus())
+	})
+
+	logger.Infof("Starting admin endpoint at %s", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		logger.Errorf("Admin endpoint failed: %v", err)
+	}
+}
+
+// serveConflictService tracks the dependencies of the endorsers' transactions
+// in memory and serves them over gRPC until the process is signaled
+func serveConflictService(addr string) {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		logger.Errorf("Failed to listen on %s: %v", addr, err)
+		os.Exit(1)
+	}
+	server := grpc.NewServer()
+	protos.RegisterConflictServiceServer(server, sharding.NewConflictServer(sharding.NewLocalDependencyStore(sharding.DefaultExpiryDuration)))
+	go func() {
+		if err := server.Serve(lis); err != nil {
+			logger.Errorf("Conflict service failed: %v", err)
+		}
+	}()
+	logger.Infof("Conflict service started at %s", addr)
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	<-sigs
+	server.GracefulStop()
+}
+
 func runWorkload(leader *sharding.ShardLeader, count int, shardID string, nodeID uint64) {
 	// Wait a bit for leader election to settle
 	logger.Info("Waiting 5s for leader election before starting workload...")
@@ -117,63 +211,52 @@ func runWorkload(leader *sharding.ShardLeader, count int, shardID string, nodeID
 	logger.Infof("Starting workload: %d transactions", count)
 	startTime := time.Now()
 
-	// var wg sync.WaitGroup
+	var wg sync.WaitGroup
 	successCount := 0
 	var mu sync.Mutex
 
-	// Monitor commits
-	go func() {
-		for range leader.CommitC() {
-			mu.Lock()
-			successCount++
-			current := successCount
-			mu.Unlock()
-
-			if current%100 == 0 {
-				logger.Infof("Progress: %d/%d committed", current, count)
-			}
-		}
-	}()
-
 	// Generate load
 	for i := 0; i < count; i++ {
 		req := &sharding.PrepareRequest{
 			TxID:      fmt.Sprintf("tx-%d-%d-%d", nodeID, time.Now().UnixNano(), i),
 			ShardID:   shardID,
 			WriteSet:  map[string][]byte{"key": []byte(fmt.Sprintf("val-%d", i))},
-			Timestamp: time.Now(),
+			Timestamp: sharding.Clock().Now(),
 		}
 
-		select {
-		case leader.ProposeC() <- req:
-			// Sent
-		case <-time.After(1 * time.Second):
-			logger.Warnf("Queue full, dropping tx %s", req.TxID)
-		}
+		// Each transaction waits for its own proof
+		wg.Add(1)
+		go func(req *sharding.PrepareRequest) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			if _, err := leader.ProposeAndWait(ctx, req); err != nil {
+				logger.Warnf("Dropping tx %s: %v", req.TxID, err)
+				return
+			}
+
+			mu.Lock()
+			successCount++
+			current := successCount
+			mu.Unlock()
+
+			if current%100 == 0 {
+				logger.Infof("Progress: %d/%d committed", current, count)
+			}
+		}(req)
 
 		// Rate limit slightly
 		time.Sleep(1 * time.Millisecond)
 	}
 
-	// Wait for completion (simple timeout based)
-	timeout := time.After(30 * time.Second)
-	ticker := time.NewTicker(1 * time.Second)
+	// Wait for completion (each proposal times out on its own)
+	wg.Wait()
 
-	for {
-		select {
-		case <-timeout:
-			logger.Warn("Workload timed out waiting for all commits")
-			return
-		case <-ticker.C:
-			mu.Lock()
-			sc := successCount
-			mu.Unlock()
-			if sc >= count {
-				elapsed := time.Since(startTime)
-				tps := float64(sc) / elapsed.Seconds()
-				logger.Infof("Workload completed! Throughput: %.2f TPS", tps)
-				return
-			}
-		}
+	elapsed := time.Since(startTime)
+	tps := float64(successCount) / elapsed.Seconds()
+	if successCount < count {
+		logger.Warnf("Workload finished with %d/%d commits", successCount, count)
 	}
+	logger.Infof("Workload completed! Throughput: %.2f TPS", tps)
 }
@@ -20,6 +20,7 @@ import (
 	"github.com/hyperledger/fabric-protos-go/ledger/rwset/kvrwset"
 	"github.com/hyperledger/fabric-protos-go/peer"
 	"github.com/hyperledger/fabric/common/flogging"
+	"github.com/hyperledger/fabric/core/endorser/depinfo"
 	"github.com/hyperledger/fabric/core/ledger"
 	"github.com/hyperledger/fabric/protoutil"
 	"github.com/pkg/errors"
@@ -32,6 +33,16 @@ type TransactionDependency struct {
 	TxID           string
 	DependentTxIDs []string
 	HasDependency  bool
+	// EndorserOrdered is set when the shards of every namespace the
+	// transaction touched prepared it, so that DependentTxIDs holds all its
+	// conflicts with the transactions of the block and its read set is not
+	// checked against them
+	EndorserOrdered bool
+	// ReadConflictTxIDs lists the transactions of DependentTxIDs the
+	// endorser did not report, which write a key this transaction read. An
+	// invalid one does not invalidate this transaction, as its writes are
+	// not applied.
+	ReadConflictTxIDs []string
 }
 
 // TransactionDAG represents a Directed Acyclic Graph of transaction dependencies
@@ -43,6 +54,8 @@ type TransactionDAG struct {
 	Levels map[string]int
 	// Track transaction validation result
 	ValidationResults map[string]bool
+	// Reason each invalid transaction was aborted
+	AbortReasons map[string]ledger.AbortReason
 	// Map of transaction IDs to their index in the block
 	TxIndices map[string]int
 	// Mutex for thread safety
@@ -56,6 +69,7 @@ func NewTransactionDAG() *TransactionDAG {
 		Dependencies:      make(map[string][]string),
 		Levels:            make(map[string]int),
 		ValidationResults: make(map[string]bool),
+		AbortReasons:      make(map[string]ledger.AbortReason),
 		TxIndices:         make(map[string]int),
 	}
 }
@@ -102,6 +116,17 @@ func (dag *TransactionDAG) AddTransaction(txID string, txIndex int, hasDependenc
 	}
 }
 
+// readConflict reports whether the dependency was found by comparing the read
+// set of the transaction with the writes of the block
+func (node *TransactionDependency) readConflict(depTxID string) bool {
+	for _, txID := range node.ReadConflictTxIDs {
+		if txID == depTxID {
+			return true
+		}
+	}
+	return false
+}
+
 // CalculateLevels determines the level of each transaction in the DAG
 // Level 0 transactions have no dependencies
 // Higher levels depend on lower levels
@@ -212,6 +237,24 @@ func (dag *TransactionDAG) SetValidationResult(txID string, isValid bool) {
 	dag.ValidationResults[txID] = isValid
 }
 
+// MarkInvalid marks a transaction as invalid and records why it was aborted
+func (dag *TransactionDAG) MarkInvalid(txID string, reason ledger.AbortReason) {
+	dag.mutex.Lock()
+	defer dag.mutex.Unlock()
+
+	dag.ValidationResults[txID] = false
+	dag.AbortReasons[txID] = reason
+}
+
+// GetAbortReason returns the reason a transaction was aborted, if any
+func (dag *TransactionDAG) GetAbortReason(txID string) (ledger.AbortReason, bool) {
+	dag.mutex.RLock()
+	defer dag.mutex.RUnlock()
+
+	reason, exists := dag.AbortReasons[txID]
+	return reason, exists
+}
+
 // IsValid returns whether a transaction is valid
 func (dag *TransactionDAG) IsValid(txID string) bool {
 	dag.mutex.RLock()
@@ -232,9 +275,18 @@ func (dag *TransactionDAG) GetIndexByTxID(txID string) (int, bool) {
 	return index, exists
 }
 
-// BuildDAGFromBlock constructs a DAG for the block by extracting dependency information from transactions
+// BuildDAGFromBlock constructs a DAG for the block by extracting dependency information from transactions,
+// and by comparing their read sets with the writes of the earlier transactions of the block
 func BuildDAGFromBlock(block *common.Block) (*TransactionDAG, error) {
+	return buildDAGFromBlock(block, nil)
+}
+
+// buildDAGFromBlock constructs the DAG of the block, and marks invalid the
+// transactions whose reads are stale in the committed state given by
+// versions, unless it is nil
+func buildDAGFromBlock(block *common.Block, versions StateVersionReader) (*TransactionDAG, error) {
 	dag := NewTransactionDAG()
+	var txs []blockTx
 
 	// Extract envelope from each transaction
 	for i := 0; i < len(block.Data.Data); i++ {
@@ -272,7 +324,8 @@ func BuildDAGFromBlock(block *common.Block) (*TransactionDAG, error) {
 
 		// Extract dependency information from transaction actions
 		hasDependency := false
-		dependentTxID := ""
+		var dependentTxIDs []string
+		orderedActions := 0
 
 		for _, action := range tx.Actions {
 			// action.Payload is a ChaincodeActionPayload
@@ -301,32 +354,55 @@ func BuildDAGFromBlock(block *common.Block) (*TransactionDAG, error) {
 			}
 
 			// Extract dependency information from the response message
-			if chaincodeAction.Response != nil {
-				logger.Infof("Tx [%s] Action Response Message: '%s'", txID, chaincodeAction.Response.Message)
-				if chaincodeAction.Response.Message != "" {
-					hasDependency, dependentTxID, _, err = ParseDependencyInfo(chaincodeAction.Response.Message)
-					if err != nil {
-						logger.Warningf("Failed to parse dependency info for tx %s: %s", txID, err)
-						continue
-					}
-					if hasDependency {
-						break
-					}
-				}
+			if chaincodeAction.Response == nil {
+				continue
+			}
+			logger.Infof("Tx [%s] Action Response Message: '%s'", txID, chaincodeAction.Response.Message)
+			info, err := depinfo.Parse(chaincodeAction.Response.Message)
+			if err != nil {
+				logger.Warningf("Failed to parse dependency info for tx %s: %s", txID, err)
+				continue
+			}
+			if info == nil {
+				continue
+			}
+			if info.HasDependency {
+				hasDependency = true
+				dependentTxIDs = append(dependentTxIDs, info.DependentTxIDs...)
+			}
+			if endorserOrdered(chaincodeAction, info) {
+				orderedActions++
 			}
 		}
 
 		// Add transaction to DAG
-		dag.AddTransaction(txID, i, hasDependency, dependentTxID)
+		dag.AddTransaction(txID, i, hasDependency, strings.Join(dependentTxIDs, ","))
+		ordered := orderedActions > 0 && orderedActions == len(tx.Actions)
+		if ordered {
+			dag.Nodes[txID].EndorserOrdered = true
+		}
+		txs = append(txs, blockTx{txID: txID, index: i, tx: tx, ordered: ordered})
 	}
 
+	// Order each transaction after the transactions of the block whose
+	// writes make its reads stale, whether or not the endorser reported them
+	stale := addReadConflicts(dag, txs, versions)
+
 	// Calculate levels for parallel processing
 	dag.CalculateLevels()
 
+	for txID := range stale {
+		logger.Infof("Transaction %s marked as invalid because its reads are stale in the committed state", txID)
+		dag.MarkInvalid(txID, ledger.AbortReasonMVCCConflict)
+	}
+
 	return dag, nil
 }
 
 // ParseDependencyInfo parses the dependency info from the response message
+//
+// Deprecated: it keeps only the first transaction of DependentTxID; use
+// depinfo.Parse instead.
 func ParseDependencyInfo(responseMsg string) (bool, string, int64, error) {
 	// Example format: "DependencyInfo:HasDependency=true,DependentTxID=tx123,ExpiryTime=1234567"
 	if !strings.Contains(responseMsg, "DependencyInfo:") {
@@ -402,9 +478,29 @@ type PeerLedgerSupport interface {
 type LedgerCommitter struct {
 	PeerLedgerSupport
 	ConcurrencyLimit int
+	// ProofVerifier verifies the shard proofs embedded in each transaction's
+	// dependency info; verification is skipped when nil
+	ProofVerifier ShardProofVerifier
+	// StateVersions reads the committed versions of the keys the
+	// transactions of a block read, whose stale reads invalidate them
+	// before they are validated; they are left to the ledger's validation
+	// when nil
+	StateVersions StateVersionReader
+	// ConflictDetector decides whether a transaction conflicts with the
+	// transactions of the block it depends on; the key-overlap policy
+	// applies when nil
+	ConflictDetector ConflictDetector
+	// Metrics records the duration of the stages of committing a block
+	// through the DAG; nothing is recorded when nil
+	Metrics *Metrics
+
+	// lastDAGStats describes the DAG of the last block committed through it
+	statsMutex   sync.RWMutex
+	lastDAGStats *DAGStats
 }
 
-// SetConcurrencyLimit sets the maximum number of concurrent goroutines used for validation
+// SetConcurrencyLimit sets the maximum number of concurrent goroutines used for validation,
+// at each level of the DAG and in the pool validating the endorsements
 func (lc *LedgerCommitter) SetConcurrencyLimit(limit int) {
 	lc.ConcurrencyLimit = limit
 }
@@ -424,6 +520,11 @@ func NewLedgerCommitter(ledger PeerLedgerSupport) *LedgerCommitter {
 		}
 	}
 
+	if os.Getenv(ShardProofVerifierEnvVar) == "true" {
+		lc.ProofVerifier = shardSignatureVerifier{}
+		logger.Infof("Commit-time verification of shard prepare proofs enabled via %s", ShardProofVerifierEnvVar)
+	}
+
 	return lc
 }
 
@@ -437,8 +538,15 @@ func (lc *LedgerCommitter) CommitLegacy(blockAndPvtData *ledger.BlockAndPvtData,
 		return lc.legacyCommit(blockAndPvtData, commitOpts)
 	}
 
+	// Validate the endorsements of the block while the read-write sets are
+	// unmarshalled to build the DAG
+	timer := lc.stageTimer(block)
+	start := time.Now()
+	endorsements := lc.validateEndorsements(block, timer)
+
 	// 1. Construct a DAG for the block
-	dag, err := BuildDAGFromBlock(block)
+	dag, err := buildDAGFromBlock(block, lc.StateVersions)
+	timer.observe(StageDAG, start)
 	if err != nil {
 		logger.Errorf("Failed to build DAG for block %d: %s", block.Header.Number, err)
 		// Continue with normal processing if DAG building fails
@@ -449,12 +557,13 @@ func (lc *LedgerCommitter) CommitLegacy(blockAndPvtData *ledger.BlockAndPvtData,
 		block.Header.Number, len(dag.Nodes))
 
 	// 2. Process transactions according to the DAG
-	err = lc.processBlockWithDAG(blockAndPvtData, commitOpts, dag)
+	err = lc.processBlockWithDAG(blockAndPvtData, commitOpts, dag, endorsements, timer)
 	if err != nil {
 		logger.Errorf("Failed to process block with DAG: %s", err)
 		// Fall back to legacy commit if DAG processing fails
 		return lc.legacyCommit(blockAndPvtData, commitOpts)
 	}
+	lc.recordDAGStats(block.Header.Number, dag)
 
 	return nil
 }
@@ -469,10 +578,13 @@ func (lc *LedgerCommitter) legacyCommit(blockAndPvtData *ledger.BlockAndPvtData,
 	return nil
 }
 
-// processBlockWithDAG processes a block using the transaction dependency DAG
+// processBlockWithDAG processes a block using the transaction dependency DAG,
+// taking the outcome of the validation of the endorsements from the pool
 func (lc *LedgerCommitter) processBlockWithDAG(blockAndPvtData *ledger.BlockAndPvtData,
-	commitOpts *ledger.CommitOptions, dag *TransactionDAG,
+	commitOpts *ledger.CommitOptions, dag *TransactionDAG, endorsements *endorsementResults, timer stageTimer,
 ) error {
+	start := time.Now()
+
 	// Get transactions by level for parallel processing
 	txsByLevel := dag.GetTransactionsByLevel()
 	maxLevel := -1
@@ -486,6 +598,10 @@ func (lc *LedgerCommitter) processBlockWithDAG(blockAndPvtData *ledger.BlockAndP
 
 	logger.Infof("Processing block with DAG: %d levels of transactions", maxLevel+1)
 
+	// proofIndexes holds the commit indexes of the shard proofs verified so
+	// far, against which those of the dependent transactions are checked
+	proofIndexes := &shardProofIndexes{}
+
 	// Process each level in order (level 0 first, then 1, etc.)
 	for level := 0; level <= maxLevel; level++ {
 		txs, exists := txsByLevel[level]
@@ -507,6 +623,15 @@ func (lc *LedgerCommitter) processBlockWithDAG(blockAndPvtData *ledger.BlockAndP
 		}
 
 		for _, txID := range txs {
+			// Transactions whose reads are stale in the committed state
+			// were marked invalid with the DAG
+			if _, aborted := dag.GetAbortReason(txID); aborted {
+				mutex.Lock()
+				txValidationResults[txID] = false
+				mutex.Unlock()
+				continue
+			}
+
 			// Check if dependencies are valid (if any)
 			if level > 0 {
 				// For transactions with dependencies, check if dependencies were valid
@@ -519,7 +644,7 @@ func (lc *LedgerCommitter) processBlockWithDAG(blockAndPvtData *ledger.BlockAndP
 						continue
 					}
 
-					if !dag.IsValid(depTxID) {
+					if !dag.IsValid(depTxID) && !node.readConflict(depTxID) {
 						// One of the dependencies is invalid, so this transaction is also invalid
 						allDepsValid = false
 						logger.Infof("Transaction %s marked as invalid because dependency %s is invalid",
@@ -530,8 +655,10 @@ func (lc *LedgerCommitter) processBlockWithDAG(blockAndPvtData *ledger.BlockAndP
 
 				if !allDepsValid {
 					// Mark this transaction as invalid and skip processing
-					dag.SetValidationResult(txID, false)
+					dag.MarkInvalid(txID, ledger.AbortReasonDependencyInvalid)
+					mutex.Lock()
 					txValidationResults[txID] = false
+					mutex.Unlock()
 					continue
 				}
 			}
@@ -562,7 +689,7 @@ func (lc *LedgerCommitter) processBlockWithDAG(blockAndPvtData *ledger.BlockAndP
 					mutex.Lock()
 					txValidationResults[id] = false
 					mutex.Unlock()
-					dag.SetValidationResult(id, false)
+					dag.MarkInvalid(id, ledger.AbortReasonParseError)
 					return
 				}
 
@@ -573,7 +700,7 @@ func (lc *LedgerCommitter) processBlockWithDAG(blockAndPvtData *ledger.BlockAndP
 					mutex.Lock()
 					txValidationResults[id] = false
 					mutex.Unlock()
-					dag.SetValidationResult(id, false)
+					dag.MarkInvalid(id, ledger.AbortReasonParseError)
 					return
 				}
 
@@ -584,17 +711,29 @@ func (lc *LedgerCommitter) processBlockWithDAG(blockAndPvtData *ledger.BlockAndP
 					mutex.Lock()
 					txValidationResults[id] = false
 					mutex.Unlock()
-					dag.SetValidationResult(id, false)
+					dag.MarkInvalid(id, ledger.AbortReasonParseError)
 					return
 				}
 
-				// Validate chaincode actions
+				// Wait for the pool to validate the endorsements
 				isValid := true
+				var abortReason ledger.AbortReason
+				if reason := endorsements.wait(txIndex); reason != "" {
+					logger.Errorf("Endorsements of tx %s failed validation: %s", id, reason)
+					isValid = false
+					abortReason = reason
+				}
+
+				// Validate chaincode actions
 				for _, action := range tx.Actions {
+					if !isValid {
+						break
+					}
 					cap := &peer.ChaincodeActionPayload{}
 					if err := proto.Unmarshal(action.Payload, cap); err != nil {
 						logger.Errorf("Failed to unmarshal chaincode action payload for tx %s: %s", id, err)
 						isValid = false
+						abortReason = ledger.AbortReasonParseError
 						break
 					}
 
@@ -606,6 +745,7 @@ func (lc *LedgerCommitter) processBlockWithDAG(blockAndPvtData *ledger.BlockAndP
 					if err := proto.Unmarshal(cap.Action.ProposalResponsePayload, prp); err != nil {
 						logger.Errorf("Failed to unmarshal proposal response payload for tx %s: %s", id, err)
 						isValid = false
+						abortReason = ledger.AbortReasonParseError
 						break
 					}
 
@@ -613,6 +753,7 @@ func (lc *LedgerCommitter) processBlockWithDAG(blockAndPvtData *ledger.BlockAndP
 					if err := proto.Unmarshal(prp.Extension, chaincodeAction); err != nil {
 						logger.Errorf("Failed to unmarshal chaincode action for tx %s: %s", id, err)
 						isValid = false
+						abortReason = ledger.AbortReasonParseError
 						break
 					}
 
@@ -621,22 +762,31 @@ func (lc *LedgerCommitter) processBlockWithDAG(blockAndPvtData *ledger.BlockAndP
 						logger.Errorf("Chaincode action failed for tx %s with status %d", id,
 							chaincodeAction.Response.GetStatus())
 						isValid = false
+						abortReason = ledger.AbortReasonChaincodeFailure
 						break
 					}
-				}
 
-				// Simulate VSCC (Signature Verification) if basic checks passed
-				if isValid {
-					simulateVSCC()
+					// Reject dependency claims the shard proofs do not back,
+					// and proofs inconsistent with the transaction or with
+					// those of its dependencies
+					if lc.ProofVerifier != nil && chaincodeAction.Response != nil {
+						if err := verifyActionProofs(lc.ProofVerifier, proofIndexes, id, chaincodeAction); err != nil {
+							logger.Errorf("Shard proofs of tx %s failed verification: %s", id, err)
+							isValid = false
+							abortReason = ledger.AbortReasonProofInvalid
+							break
+						}
+					}
 				}
 
 				// Check for read/write set conflicts with dependencies
 				if level > 0 && isValid {
 					node := dag.Nodes[id]
 					for _, depTxID := range node.DependentTxIDs {
-						// Get the dependent transaction
+						// Get the dependent transaction; an invalid one
+						// writes nothing to conflict with
 						depTxIndex, exists := dag.GetIndexByTxID(depTxID)
-						if !exists {
+						if !exists || !dag.IsValid(depTxID) {
 							continue
 						}
 
@@ -657,11 +807,14 @@ func (lc *LedgerCommitter) processBlockWithDAG(blockAndPvtData *ledger.BlockAndP
 							continue
 						}
 
-						// Check for read/write set conflicts
-						if lc.checkRWSetConflicts(tx, depTx) {
+						// Check for conflicts as the conflict policy defines them
+						if lc.conflictDetector().Conflicts(blockAndPvtData.Block.Header.Number,
+							&ConflictTx{TxID: id, Index: txIndex, Tx: tx},
+							&ConflictTx{TxID: depTxID, Index: depTxIndex, Tx: depTx}) {
 							logger.Infof("Transaction %s marked as invalid due to read/write set conflict with dependency %s",
 								id, depTxID)
 							isValid = false
+							abortReason = ledger.AbortReasonMVCCConflict
 							break
 						}
 					}
@@ -673,7 +826,11 @@ func (lc *LedgerCommitter) processBlockWithDAG(blockAndPvtData *ledger.BlockAndP
 				mutex.Unlock()
 
 				// Update the DAG
-				dag.SetValidationResult(id, isValid)
+				if isValid {
+					dag.SetValidationResult(id, true)
+				} else {
+					dag.MarkInvalid(id, abortReason)
+				}
 
 				logger.Debugf("Transaction %s (index %d) processed and marked as %v",
 					id, txIndex, isValid)
@@ -684,6 +841,7 @@ func (lc *LedgerCommitter) processBlockWithDAG(blockAndPvtData *ledger.BlockAndP
 		wg.Wait()
 		logger.Debugf("Completed processing of level %d", level)
 	}
+	timer.observe(StageValidation, start)
 
 	// After DAG-based processing, update the transaction validation flags in the block
 	metadata := blockAndPvtData.Block.Metadata
@@ -697,6 +855,7 @@ func (lc *LedgerCommitter) processBlockWithDAG(blockAndPvtData *ledger.BlockAndP
 	}
 
 	// Update validation flags based on our DAG processing results
+	abortReasons := make(map[int]ledger.AbortReason)
 	for txID := range dag.Nodes {
 		txIndex, exists := dag.GetIndexByTxID(txID)
 		if !exists {
@@ -704,8 +863,12 @@ func (lc *LedgerCommitter) processBlockWithDAG(blockAndPvtData *ledger.BlockAndP
 		}
 
 		if !dag.IsValid(txID) {
-			// Mark as invalid with appropriate validation code
-			txFilter[txIndex] = uint8(peer.TxValidationCode_MVCC_READ_CONFLICT)
+			// Mark as invalid with the validation code matching the abort reason
+			reason, _ := dag.GetAbortReason(txID)
+			txFilter[txIndex] = uint8(validationCodeForAbortReason(reason))
+			if reason != "" {
+				abortReasons[txIndex] = reason
+			}
 		}
 	}
 
@@ -723,13 +886,31 @@ func (lc *LedgerCommitter) processBlockWithDAG(blockAndPvtData *ledger.BlockAndP
 	dagCommitOpts := &ledger.CommitOptions{
 		SkipMVCCValidation: false,
 		DAGLevels:          dag.GetLevelsByIndex(),
+		AbortReasons:       abortReasons,
 	}
 	if commitOpts != nil {
 		dagCommitOpts.FetchPvtDataFromLedger = commitOpts.FetchPvtDataFromLedger
 	}
+	start = time.Now()
+	defer timer.observe(StageCommit, start)
 	return lc.PeerLedgerSupport.CommitLegacy(blockAndPvtData, dagCommitOpts)
 }
 
+// validationCodeForAbortReason maps an abort reason to the transaction filter
+// code recorded in the block. Unknown reasons fall back to MVCC_READ_CONFLICT.
+func validationCodeForAbortReason(reason ledger.AbortReason) peer.TxValidationCode {
+	switch reason {
+	case ledger.AbortReasonParseError:
+		return peer.TxValidationCode_BAD_PAYLOAD
+	case ledger.AbortReasonMissingEndorsement:
+		return peer.TxValidationCode_ENDORSEMENT_POLICY_FAILURE
+	case ledger.AbortReasonChaincodeFailure, ledger.AbortReasonProofInvalid:
+		return peer.TxValidationCode_INVALID_OTHER_REASON
+	default:
+		return peer.TxValidationCode_MVCC_READ_CONFLICT
+	}
+}
+
 // GetPvtDataAndBlockByNum retrieves private data and block for given sequence number
 func (lc *LedgerCommitter) GetPvtDataAndBlockByNum(seqNum uint64) (*ledger.BlockAndPvtData, error) {
 	return lc.PeerLedgerSupport.GetPvtDataAndBlockByNum(seqNum, nil)
@@ -789,8 +970,9 @@ func (lc *LedgerCommitter) Close() {
 	lc.PeerLedgerSupport.Close()
 }
 
-// checkRWSetConflicts checks for read/write set conflicts between transactions
-func (lc *LedgerCommitter) checkRWSetConflicts(tx1, tx2 *peer.Transaction) bool {
+// checkRWSetConflicts checks for read/write set conflicts between transactions:
+// a key one of them reads that the other writes, or a key both write
+func checkRWSetConflicts(tx1, tx2 *peer.Transaction) bool {
 	// Extract RW sets from both transactions
 	ns1Map, err := extractRWSet(tx1)
 	if err != nil {
@@ -934,12 +1116,11 @@ func hasHashedWriteWriteConflict(writes1 []*kvrwset.KVWriteHash, writes2 []*kvrw
 func extractRWSet(tx *peer.Transaction) (map[string]*rwset.NsReadWriteSet, error) {
 	rwSets := make(map[string]*rwset.NsReadWriteSet)
 
-	for _, action := range tx.Actions {
-		chaincodeAction := &peer.ChaincodeAction{}
-		if err := proto.Unmarshal(action.Payload, chaincodeAction); err != nil {
-			return nil, err
-		}
-
+	actions, err := chaincodeActions(tx)
+	if err != nil {
+		return nil, err
+	}
+	for _, chaincodeAction := range actions {
 		// Extract read/write set from chaincode action
 		if chaincodeAction.Results == nil {
 			continue
@@ -966,6 +1147,31 @@ func extractRWSet(tx *peer.Transaction) (map[string]*rwset.NsReadWriteSet, error
 	return rwSets, nil
 }
 
+// chaincodeActions returns the chaincode actions endorsed in a transaction,
+// skipping the actions that carry no proposal response
+func chaincodeActions(tx *peer.Transaction) ([]*peer.ChaincodeAction, error) {
+	var actions []*peer.ChaincodeAction
+	for _, action := range tx.Actions {
+		cap := &peer.ChaincodeActionPayload{}
+		if err := proto.Unmarshal(action.Payload, cap); err != nil {
+			return nil, err
+		}
+		if cap.Action == nil || cap.Action.ProposalResponsePayload == nil {
+			continue
+		}
+		prp := &peer.ProposalResponsePayload{}
+		if err := proto.Unmarshal(cap.Action.ProposalResponsePayload, prp); err != nil {
+			return nil, err
+		}
+		chaincodeAction := &peer.ChaincodeAction{}
+		if err := proto.Unmarshal(prp.Extension, chaincodeAction); err != nil {
+			return nil, err
+		}
+		actions = append(actions, chaincodeAction)
+	}
+	return actions, nil
+}
+
 // simulateVSCC simulates the computational cost of verifying signatures
 func simulateVSCC() {
 	time.Sleep(500 * time.Microsecond)
@@ -8,7 +8,9 @@ package committer
 
 import (
 	"fmt"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/hyperledger/fabric-protos-go/common"
@@ -18,7 +20,10 @@ import (
 	"github.com/hyperledger/fabric/common/configtx/test"
 	"github.com/hyperledger/fabric/common/ledger"
 	"github.com/hyperledger/fabric/common/ledger/testutil"
+	"github.com/hyperledger/fabric/common/metrics/metricsfakes"
+	"github.com/hyperledger/fabric/core/endorser/sharding"
 	ledger2 "github.com/hyperledger/fabric/core/ledger"
+	ledgermock "github.com/hyperledger/fabric/core/ledger/mock"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
@@ -276,11 +281,8 @@ func TestReadWriteSetConflictDetection(t *testing.T) {
 	tx1 := createTestTransaction("tx1", "key1", "value1", "")
 	tx2 := createTestTransaction("tx2", "key1", "value2", "") // Conflicts with tx1
 
-	// Create test committer
-	lc := createTestLedgerCommitter(t)
-
 	// Check for conflicts
-	hasConflict := lc.checkRWSetConflicts(tx1, tx2)
+	hasConflict := checkRWSetConflicts(tx1, tx2)
 	assert.True(t, hasConflict)
 
 	// Create test transactions with non-conflicting read/write sets
@@ -288,7 +290,7 @@ func TestReadWriteSetConflictDetection(t *testing.T) {
 	tx4 := createTestTransaction("tx4", "key4", "value4", "")
 
 	// Check for conflicts
-	hasConflict = lc.checkRWSetConflicts(tx3, tx4)
+	hasConflict = checkRWSetConflicts(tx3, tx4)
 	assert.False(t, hasConflict)
 }
 
@@ -297,11 +299,8 @@ func TestPrivateDataConflictDetection(t *testing.T) {
 	tx1 := createTestTransactionWithPrivateData("tx1", "key1", "value1", "", "collection1")
 	tx2 := createTestTransactionWithPrivateData("tx2", "key1", "value2", "tx1", "collection1")
 
-	// Create test committer
-	lc := createTestLedgerCommitter(t)
-
 	// Check for conflicts
-	hasConflict := lc.checkRWSetConflicts(tx1, tx2)
+	hasConflict := checkRWSetConflicts(tx1, tx2)
 	assert.True(t, hasConflict)
 
 	// Create test transactions with non-conflicting private data
@@ -309,7 +308,7 @@ func TestPrivateDataConflictDetection(t *testing.T) {
 	tx4 := createTestTransactionWithPrivateData("tx4", "key4", "value4", "", "collection2")
 
 	// Check for conflicts
-	hasConflict = lc.checkRWSetConflicts(tx3, tx4)
+	hasConflict = checkRWSetConflicts(tx3, tx4)
 	assert.False(t, hasConflict)
 }
 
@@ -465,7 +464,7 @@ func createTestRWSetWithPrivateData(key, value, collection string) []byte {
 				CollectionHashedRwset: []*rwset.CollectionHashedReadWriteSet{
 					{
 						CollectionName: collection,
-						HashedRwset:    createTestKVRWSet(key, value),
+						HashedRwset:    createTestHashedRWSet(key, value),
 					},
 				},
 			},
@@ -474,3 +473,519 @@ func createTestRWSetWithPrivateData(key, value, collection string) []byte {
 	rwSetBytes, _ := proto.Marshal(rwSet)
 	return rwSetBytes
 }
+
+func createTestHashedRWSet(key, value string) []byte {
+	hashedRWSet := &kvrwset.HashedRWSet{
+		HashedReads: []*kvrwset.KVReadHash{
+			{
+				KeyHash: []byte(key),
+			},
+		},
+		HashedWrites: []*kvrwset.KVWriteHash{
+			{
+				KeyHash:   []byte(key),
+				ValueHash: []byte(value),
+			},
+		},
+	}
+	hashedRWSetBytes, _ := proto.Marshal(hashedRWSet)
+	return hashedRWSetBytes
+}
+
+// createTestEnvelope returns the envelope of a transaction endorsing the
+// chaincode action
+func createTestEnvelope(t *testing.T, txID string, action *pb.ChaincodeAction, endorsements ...*pb.Endorsement) []byte {
+	chaincodeAction, err := proto.Marshal(action)
+	require.NoError(t, err)
+	cap, err := proto.Marshal(&pb.ChaincodeActionPayload{Action: &pb.ChaincodeEndorsedAction{
+		ProposalResponsePayload: createTestProposalResponsePayload(txID, chaincodeAction),
+		Endorsements:            endorsements,
+	}})
+	require.NoError(t, err)
+	txBytes, err := proto.Marshal(&pb.Transaction{Actions: []*pb.TransactionAction{{Payload: cap}}})
+	require.NoError(t, err)
+	chdr, err := proto.Marshal(&common.ChannelHeader{TxId: txID})
+	require.NoError(t, err)
+	payload, err := proto.Marshal(&common.Payload{Header: &common.Header{ChannelHeader: chdr}, Data: txBytes})
+	require.NoError(t, err)
+	env, err := proto.Marshal(&common.Envelope{Payload: payload})
+	require.NoError(t, err)
+	return env
+}
+
+func TestDAGAbortReasons(t *testing.T) {
+	dag := NewTransactionDAG()
+	dag.AddTransaction("tx1", 0, false, "")
+	dag.AddTransaction("tx2", 1, true, "tx1")
+
+	dag.SetValidationResult("tx1", true)
+	dag.MarkInvalid("tx2", ledger2.AbortReasonDependencyInvalid)
+
+	_, exists := dag.GetAbortReason("tx1")
+	assert.False(t, exists)
+	assert.True(t, dag.IsValid("tx1"))
+
+	reason, exists := dag.GetAbortReason("tx2")
+	assert.True(t, exists)
+	assert.Equal(t, ledger2.AbortReasonDependencyInvalid, reason)
+	assert.False(t, dag.IsValid("tx2"))
+
+	assert.Equal(t, pb.TxValidationCode_MVCC_READ_CONFLICT, validationCodeForAbortReason(ledger2.AbortReasonMVCCConflict))
+	assert.Equal(t, pb.TxValidationCode_MVCC_READ_CONFLICT, validationCodeForAbortReason(ledger2.AbortReasonDependencyInvalid))
+	assert.Equal(t, pb.TxValidationCode_BAD_PAYLOAD, validationCodeForAbortReason(ledger2.AbortReasonParseError))
+	assert.Equal(t, pb.TxValidationCode_ENDORSEMENT_POLICY_FAILURE, validationCodeForAbortReason(ledger2.AbortReasonMissingEndorsement))
+	assert.Equal(t, pb.TxValidationCode_INVALID_OTHER_REASON, validationCodeForAbortReason(ledger2.AbortReasonChaincodeFailure))
+}
+
+func TestVerifyShardProofs(t *testing.T) {
+	verifier := shardSignatureVerifier{}
+	proof := func(shardID, txID string, index uint64, deps string) *sharding.PrepareProof {
+		return &sharding.PrepareProof{
+			TxID:          txID,
+			ShardID:       shardID,
+			CommitIndex:   index,
+			Signature:     []byte(fmt.Sprintf("%s:%d:%s", shardID, index, txID)),
+			DependentTxID: deps,
+			HasDependency: deps != "",
+		}
+	}
+	message := func(hasDependency bool, deps string, proofs ...*sharding.PrepareProof) string {
+		msg := fmt.Sprintf("OK; DependencyInfo:HasDependency=%v,DependentTxID=%s", hasDependency, deps)
+		if len(proofs) > 0 {
+			encoded, err := sharding.EncodeProofs(proofs)
+			require.NoError(t, err)
+			msg += ",ShardProofs=" + encoded
+		}
+		return msg
+	}
+
+	// responses without dependency info are not verified
+	assert.NoError(t, verifyShardProofs(verifier, "tx3", "OK"))
+	// independent transactions may omit proofs, dependent ones may not
+	assert.NoError(t, verifyShardProofs(verifier, "tx3", message(false, "")))
+	assert.EqualError(t, verifyShardProofs(verifier, "tx3", message(true, "tx1")), "dependency claims carry no shard proofs")
+
+	valid := message(true, "tx1,tx2", proof("cars", "tx3", 5, "tx2"), proof("fabcar", "tx3", 7, "tx1,tx2"))
+	assert.NoError(t, verifyShardProofs(verifier, "tx3", valid))
+
+	// proofs issued for another transaction cannot be replayed
+	assert.EqualError(t, verifyShardProofs(verifier, "tx4", valid), "proof from shard cars is for tx tx3")
+
+	forged := proof("fabcar", "tx3", 7, "tx1")
+	forged.CommitIndex = 8
+	assert.EqualError(t, verifyShardProofs(verifier, "tx3", message(true, "tx1", forged)),
+		"invalid signature on proof of tx tx3 from shard fabcar")
+
+	// dropping a dependency the shards reported is detected
+	assert.EqualError(t, verifyShardProofs(verifier, "tx3", message(true, "tx1", proof("fabcar", "tx3", 7, "tx1,tx2"))),
+		"claimed dependencies [tx1] but shard proofs report [tx1,tx2]")
+	assert.EqualError(t, verifyShardProofs(verifier, "tx3", message(false, "", proof("fabcar", "tx3", 7, "tx1"))),
+		"claimed HasDependency=false but shard proofs report true")
+
+	// the ordered chain of ancestors must match the shards' chains
+	chained := proof("fabcar", "tx3", 7, "tx2")
+	chained.DependencyChain = []string{"tx1", "tx2"}
+	encoded, err := sharding.EncodeProofs([]*sharding.PrepareProof{chained})
+	require.NoError(t, err)
+	chainMessage := func(chain string) string {
+		return fmt.Sprintf("OK; DependencyInfo:HasDependency=true,DependencyChain=%s,DependentTxID=tx2,ShardProofs=%s", chain, encoded)
+	}
+	assert.NoError(t, verifyShardProofs(verifier, "tx3", chainMessage("tx1;tx2")))
+	assert.EqualError(t, verifyShardProofs(verifier, "tx3", chainMessage("tx2;tx1")),
+		"claimed dependency chain [tx2;tx1] but shard proofs report [tx1;tx2]")
+	assert.EqualError(t, verifyShardProofs(verifier, "tx3", message(true, "tx2", chained)),
+		"claimed dependency chain [] but shard proofs report [tx1;tx2]")
+
+	// shards missing from a quorum cannot have proofs
+	withMissing := func(missing string) string {
+		return strings.Replace(message(true, "tx1", proof("fabcar", "tx3", 7, "tx1")), ",DependentTxID=", ",MissingShards="+missing+",DependentTxID=", 1)
+	}
+	assert.NoError(t, verifyShardProofs(verifier, "tx3", withMissing("cars;marbles")))
+	assert.EqualError(t, verifyShardProofs(verifier, "tx3", withMissing("fabcar")), "shard fabcar is claimed missing but has a proof")
+
+	assert.Equal(t, pb.TxValidationCode_INVALID_OTHER_REASON, validationCodeForAbortReason(ledger2.AbortReasonProofInvalid))
+}
+
+func TestShardProofIndexes(t *testing.T) {
+	message := func(proofs ...*sharding.PrepareProof) string {
+		encoded, err := sharding.EncodeProofs(proofs)
+		require.NoError(t, err)
+		return "OK; DependencyInfo:HasDependency=true,DependentTxID=tx1,ShardProofs=" + encoded
+	}
+	proof := func(shardID, txID string, index uint64, deps string) *sharding.PrepareProof {
+		return &sharding.PrepareProof{TxID: txID, ShardID: shardID, CommitIndex: index, DependentTxID: deps, HasDependency: deps != ""}
+	}
+	namespaces := map[string]bool{"fabcar": true, "marbles": true}
+
+	indexes := &shardProofIndexes{}
+	assert.NoError(t, indexes.verify("tx0", "OK", namespaces))
+	assert.NoError(t, indexes.verify("tx1", message(proof("fabcar", "tx1", 5, ""), proof("marbles"+sharding.PartitionSeparator+"1", "tx1", 9, "")), namespaces))
+
+	// a shard prepares a transaction after those it depends on
+	assert.NoError(t, indexes.verify("tx2", message(proof("fabcar", "tx2", 6, "tx1"), proof("marbles"+sharding.PartitionSeparator+"0", "tx2", 3, "tx1")), namespaces))
+	assert.EqualError(t, indexes.verify("tx3", message(proof("fabcar", "tx3", 5, "tx1,tx2")), namespaces),
+		"proof from shard fabcar has commit index 5, before the index 6 of dependency tx2")
+	assert.EqualError(t, indexes.verify("tx3", message(proof("marbles"+sharding.PartitionSeparator+"1", "tx3", 8, "tx1")), namespaces),
+		"proof from shard marbles#1 has commit index 8, before the index 9 of dependency tx1")
+
+	// a transaction prepared in the same batch as its dependency shares the
+	// index of the entry of the batch
+	assert.NoError(t, indexes.verify("tx3", message(proof("fabcar", "tx3", 6, "tx1,tx2")), namespaces))
+
+	// the proofs must match the namespaces of the transaction
+	assert.EqualError(t, indexes.verify("tx3", message(proof("cars", "tx3", 7, "")), namespaces),
+		"proof from shard cars is for namespace cars, which the transaction does not touch")
+	assert.EqualError(t, indexes.verify("tx3", message(proof("fabcar", "tx3", 7, ""), proof("fabcar", "tx3", 8, "")), namespaces),
+		"shard fabcar issued several proofs")
+	assert.EqualError(t, indexes.verify("tx3", message(proof("fabcar", "tx3", 0, "")), namespaces),
+		"proof from shard fabcar has no commit index")
+}
+
+func TestActionNamespaces(t *testing.T) {
+	results, err := proto.Marshal(&rwset.TxReadWriteSet{NsRwset: []*rwset.NsReadWriteSet{{Namespace: "fabcar"}, {Namespace: "marbles"}}})
+	require.NoError(t, err)
+	namespaces, err := actionNamespaces(&pb.ChaincodeAction{ChaincodeId: &pb.ChaincodeID{Name: "router"}, Results: results})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"router": true, "fabcar": true, "marbles": true}, namespaces)
+
+	_, err = actionNamespaces(&pb.ChaincodeAction{Results: []byte("junk")})
+	assert.ErrorContains(t, err, "failed to unmarshal the read-write set")
+}
+
+func TestGetLastBlockDAGStats(t *testing.T) {
+	t.Setenv("FABRIC_SHARDING_ENABLED", "true")
+
+	envelope := func(txID, key, deps string) []byte {
+		return createTestEnvelope(t, txID, &pb.ChaincodeAction{
+			Response: &pb.Response{Status: 200, Message: fmt.Sprintf("OK; DependencyInfo:HasDependency=%v,DependentTxID=%s", deps != "", deps)},
+			Results:  createTestRWSet(key, "v1"),
+		})
+	}
+
+	lc := NewLedgerCommitter(&mockLedgerSupport{})
+	assert.Nil(t, lc.GetLastBlockDAGStats())
+
+	// tx4 depends on tx0 of an earlier block
+	block := createTestBlock(nil)
+	block.Header.Number = 7
+	block.Data.Data = [][]byte{
+		envelope("tx1", "key1", ""),
+		envelope("tx2", "key2", "tx1"),
+		envelope("tx3", "key3", "tx2"),
+		envelope("tx4", "key4", "tx0"),
+	}
+	require.NoError(t, lc.CommitLegacy(&ledger2.BlockAndPvtData{Block: block}, &ledger2.CommitOptions{}))
+
+	stats := lc.GetLastBlockDAGStats()
+	require.NotNil(t, stats)
+	assert.Equal(t, &DAGStats{
+		BlockNumber:        7,
+		Nodes:              4,
+		Edges:              2,
+		CriticalPathLength: 3,
+		Parallelism:        4.0 / 3,
+		LevelWidths:        []int{2, 1, 1},
+	}, stats)
+
+	// the stats returned are a copy
+	stats.LevelWidths[0] = 0
+	assert.Equal(t, []int{2, 1, 1}, lc.GetLastBlockDAGStats().LevelWidths)
+}
+
+func TestEndorsementValidationPool(t *testing.T) {
+	t.Setenv("FABRIC_SHARDING_ENABLED", "true")
+
+	envelope := func(txID, key, deps string, endorsement *pb.Endorsement) []byte {
+		return createTestEnvelope(t, txID, &pb.ChaincodeAction{
+			Response: &pb.Response{Status: 200, Message: fmt.Sprintf("OK; DependencyInfo:HasDependency=%v,DependentTxID=%s", deps != "", deps)},
+			Results:  createTestRWSet(key, "v1"),
+		}, endorsement)
+	}
+	signed := &pb.Endorsement{Endorser: []byte("peer0"), Signature: []byte("signature")}
+
+	block := createTestBlock(nil)
+	block.Header.Number = 7
+	block.Data.Data = [][]byte{
+		envelope("tx1", "key1", "", signed),
+		// tx2 is not signed, which invalidates tx3 depending on it
+		envelope("tx2", "key2", "", &pb.Endorsement{Endorser: []byte("peer0")}),
+		envelope("tx3", "key3", "tx2", signed),
+		envelope("tx4", "key4", "tx1", signed),
+		[]byte("garbage"),
+	}
+
+	lc := NewLedgerCommitter(&mockLedgerSupport{})
+	lc.SetConcurrencyLimit(2)
+	endorsements := lc.validateEndorsements(block, stageTimer{})
+	assert.Equal(t, ledger2.AbortReason(""), endorsements.wait(0))
+	assert.Equal(t, ledger2.AbortReasonMissingEndorsement, endorsements.wait(1))
+	assert.Equal(t, ledger2.AbortReasonParseError, endorsements.wait(4))
+	block.Data.Data = block.Data.Data[:4]
+
+	histogram := &metricsfakes.Histogram{}
+	histogram.WithReturns(histogram)
+	lc.Metrics = &Metrics{StageDuration: histogram}
+	require.NoError(t, lc.CommitLegacy(&ledger2.BlockAndPvtData{Block: block}, &ledger2.CommitOptions{}))
+	assert.Equal(t, []uint8{
+		uint8(pb.TxValidationCode_VALID),
+		uint8(pb.TxValidationCode_ENDORSEMENT_POLICY_FAILURE),
+		uint8(pb.TxValidationCode_MVCC_READ_CONFLICT),
+		uint8(pb.TxValidationCode_VALID),
+	}, block.Metadata.Metadata[common.BlockMetadataIndex_TRANSACTIONS_FILTER])
+
+	// the endorsements may be validated after the block is committed
+	stages := func() []string {
+		var stages []string
+		for i := 0; i < histogram.WithCallCount(); i++ {
+			stages = append(stages, histogram.WithArgsForCall(i)[3])
+		}
+		return stages
+	}
+	assert.Eventually(t, func() bool { return len(stages()) == 4 }, time.Second, 10*time.Millisecond)
+	assert.ElementsMatch(t, []string{StageEndorsements, StageDAG, StageValidation, StageCommit}, stages())
+	assert.Equal(t, 4, histogram.ObserveCallCount())
+}
+
+func TestConflictDetectors(t *testing.T) {
+	conflictTx := func(txID string, index int, message string, kvrw *kvrwset.KVRWSet) *ConflictTx {
+		nsRWSet, err := proto.Marshal(kvrw)
+		require.NoError(t, err)
+		results, err := proto.Marshal(&rwset.TxReadWriteSet{
+			DataModel: rwset.TxReadWriteSet_KV,
+			NsRwset:   []*rwset.NsReadWriteSet{{Namespace: "fabcar", Rwset: nsRWSet}},
+		})
+		require.NoError(t, err)
+		chaincodeAction, err := proto.Marshal(&pb.ChaincodeAction{Response: &pb.Response{Status: 200, Message: message}, Results: results})
+		require.NoError(t, err)
+		cap, err := proto.Marshal(&pb.ChaincodeActionPayload{Action: &pb.ChaincodeEndorsedAction{
+			ProposalResponsePayload: createTestProposalResponsePayload(txID, chaincodeAction),
+		}})
+		require.NoError(t, err)
+		return &ConflictTx{TxID: txID, Index: index, Tx: &pb.Transaction{Actions: []*pb.TransactionAction{{Payload: cap}}}}
+	}
+	detector := func(policy string) ConflictDetector {
+		d, err := NewConflictDetector(policy)
+		require.NoError(t, err)
+		return d
+	}
+	keyOverlap, mvcc, hints := detector(ConflictPolicyKeyOverlap), detector(ConflictPolicyMVCC), detector(ConflictPolicyEndorserHints)
+	assert.Equal(t, keyOverlap, detector(""))
+	_, err := NewConflictDetector("optimistic")
+	assert.EqualError(t, err, `invalid conflict policy "optimistic"`)
+
+	dep := conflictTx("tx1", 2, "", &kvrwset.KVRWSet{Writes: []*kvrwset.KVWrite{{Key: "car1", Value: []byte("red")}}})
+	message := func(conflictTypes string) string {
+		return "OK; DependencyInfo:HasDependency=true,ConflictTypes=tx1:" + conflictTypes + ",DependentTxID=tx1"
+	}
+
+	// a stale read conflicts under every policy
+	staleRead := conflictTx("tx2", 3, message("RW"), &kvrwset.KVRWSet{
+		Reads:  []*kvrwset.KVRead{{Key: "car1", Version: &kvrwset.Version{BlockNum: 6}}},
+		Writes: []*kvrwset.KVWrite{{Key: "car2"}},
+	})
+	assert.True(t, keyOverlap.Conflicts(7, staleRead, dep))
+	assert.True(t, mvcc.Conflicts(7, staleRead, dep))
+	assert.True(t, hints.Conflicts(7, staleRead, dep))
+
+	// concurrent writes only conflict by key overlap
+	blindWrite := conflictTx("tx2", 3, message("WW"), &kvrwset.KVRWSet{Writes: []*kvrwset.KVWrite{{Key: "car1"}}})
+	assert.True(t, keyOverlap.Conflicts(7, blindWrite, dep))
+	assert.False(t, mvcc.Conflicts(7, blindWrite, dep))
+	assert.False(t, hints.Conflicts(7, blindWrite, dep))
+
+	// reading the version the dependency writes is not stale
+	freshRead := conflictTx("tx2", 3, "", &kvrwset.KVRWSet{Reads: []*kvrwset.KVRead{{Key: "car1", Version: &kvrwset.Version{BlockNum: 7, TxNum: 2}}}})
+	assert.False(t, mvcc.Conflicts(7, freshRead, dep))
+
+	// a write within a scanned range is a phantom read
+	scan := conflictTx("tx2", 3, "", &kvrwset.KVRWSet{RangeQueriesInfo: []*kvrwset.RangeQueryInfo{{StartKey: "car0", EndKey: "car9"}}})
+	assert.True(t, mvcc.Conflicts(7, scan, dep))
+	scan = conflictTx("tx2", 3, "", &kvrwset.KVRWSet{RangeQueriesInfo: []*kvrwset.RangeQueryInfo{{StartKey: "car2", EndKey: "car9"}}})
+	assert.False(t, mvcc.Conflicts(7, scan, dep))
+
+	// dependencies the endorser did not classify fall back to key overlap
+	unclassified := conflictTx("tx2", 3, "OK; DependencyInfo:HasDependency=true,DependentTxID=tx1", &kvrwset.KVRWSet{Writes: []*kvrwset.KVWrite{{Key: "car1"}}})
+	assert.True(t, hints.Conflicts(7, unclassified, dep))
+}
+
+// stateVersionsFunc adapts a function to StateVersionReader
+type stateVersionsFunc func(keys map[string][]string) (map[string]map[string]*kvrwset.Version, error)
+
+func (f stateVersionsFunc) StateVersions(keys map[string][]string) (map[string]map[string]*kvrwset.Version, error) {
+	return f(keys)
+}
+
+func TestReadConflicts(t *testing.T) {
+	t.Setenv("FABRIC_SHARDING_ENABLED", "true")
+
+	envelope := func(txID string, status int32, deps string, kvrw *kvrwset.KVRWSet) []byte {
+		nsRWSet, err := proto.Marshal(kvrw)
+		require.NoError(t, err)
+		results, err := proto.Marshal(&rwset.TxReadWriteSet{
+			DataModel: rwset.TxReadWriteSet_KV,
+			NsRwset:   []*rwset.NsReadWriteSet{{Namespace: "fabcar", Rwset: nsRWSet}},
+		})
+		require.NoError(t, err)
+		return createTestEnvelope(t, txID, &pb.ChaincodeAction{
+			Response: &pb.Response{Status: status, Message: fmt.Sprintf("OK; DependencyInfo:HasDependency=%v,DependentTxID=%s", deps != "", deps)},
+			Results:  results,
+		})
+	}
+	read := func(key string, blockNum uint64) *kvrwset.KVRead {
+		if blockNum == 0 {
+			return &kvrwset.KVRead{Key: key}
+		}
+		return &kvrwset.KVRead{Key: key, Version: &kvrwset.Version{BlockNum: blockNum}}
+	}
+
+	block := createTestBlock(nil)
+	block.Header.Number = 7
+	block.Data.Data = [][]byte{
+		envelope("tx1", 200, "", &kvrwset.KVRWSet{Writes: []*kvrwset.KVWrite{{Key: "car1"}}}),
+		// tx2 reads the key tx1 writes, which the endorser did not report
+		envelope("tx2", 200, "", &kvrwset.KVRWSet{Reads: []*kvrwset.KVRead{read("car1", 5)}}),
+		// tx3 read a version of car9 the state no longer holds
+		envelope("tx3", 200, "", &kvrwset.KVRWSet{Reads: []*kvrwset.KVRead{read("car9", 5)}}),
+		// tx4 scanned the key tx1 writes, and the endorser reported tx3
+		envelope("tx4", 200, "tx3", &kvrwset.KVRWSet{RangeQueriesInfo: []*kvrwset.RangeQueryInfo{{StartKey: "car0", EndKey: "car5"}}}),
+		envelope("tx5", 200, "", &kvrwset.KVRWSet{Reads: []*kvrwset.KVRead{read("car2", 0)}}),
+		// tx7 reads the key of tx6, whose chaincode failed
+		envelope("tx6", 500, "", &kvrwset.KVRWSet{Writes: []*kvrwset.KVWrite{{Key: "car7"}}}),
+		envelope("tx7", 200, "", &kvrwset.KVRWSet{Reads: []*kvrwset.KVRead{read("car7", 5)}}),
+	}
+
+	var requested map[string][]string
+	versions := stateVersionsFunc(func(keys map[string][]string) (map[string]map[string]*kvrwset.Version, error) {
+		requested = keys
+		return map[string]map[string]*kvrwset.Version{"fabcar": {"car9": {BlockNum: 6, TxNum: 1}}}, nil
+	})
+
+	dag, err := buildDAGFromBlock(block, versions)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"car9", "car2"}, requested["fabcar"])
+	assert.Equal(t, []string{"tx1"}, dag.Nodes["tx2"].ReadConflictTxIDs)
+	assert.ElementsMatch(t, []string{"tx3", "tx1"}, dag.Nodes["tx4"].DependentTxIDs)
+	assert.Equal(t, []string{"tx1"}, dag.Nodes["tx4"].ReadConflictTxIDs)
+	assert.Equal(t, map[string]int{"tx1": 0, "tx2": 1, "tx3": 0, "tx4": 1, "tx5": 0, "tx6": 0, "tx7": 1}, dag.Levels)
+	reason, _ := dag.GetAbortReason("tx3")
+	assert.Equal(t, ledger2.AbortReasonMVCCConflict, reason)
+
+	// the stale read fails tx3 and tx4 which the endorser ordered after it,
+	// the failed writer does not fail its reader
+	lc := NewLedgerCommitter(&mockLedgerSupport{})
+	lc.StateVersions = versions
+	require.NoError(t, lc.CommitLegacy(&ledger2.BlockAndPvtData{Block: block}, &ledger2.CommitOptions{}))
+	assert.Equal(t, []uint8{
+		uint8(pb.TxValidationCode_VALID),
+		uint8(pb.TxValidationCode_MVCC_READ_CONFLICT),
+		uint8(pb.TxValidationCode_MVCC_READ_CONFLICT),
+		uint8(pb.TxValidationCode_MVCC_READ_CONFLICT),
+		uint8(pb.TxValidationCode_VALID),
+		uint8(pb.TxValidationCode_INVALID_OTHER_REASON),
+		uint8(pb.TxValidationCode_VALID),
+	}, block.Metadata.Metadata[common.BlockMetadataIndex_TRANSACTIONS_FILTER])
+
+	// the versions are read through a simulation
+	kvrw, err := proto.Marshal(&kvrwset.KVRWSet{Reads: []*kvrwset.KVRead{read("car9", 6), read("car2", 0)}})
+	require.NoError(t, err)
+	sim := &ledgermock.TxSimulator{}
+	sim.GetTxSimulationResultsReturns(&ledger2.TxSimulationResults{PubSimulationResults: &rwset.TxReadWriteSet{
+		NsRwset: []*rwset.NsReadWriteSet{{Namespace: "fabcar", Rwset: kvrw}},
+	}}, nil)
+	simulated := NewSimulatedStateVersions(simulatorProvider{sim})
+	committed, err := simulated.StateVersions(map[string][]string{"fabcar": {"car9", "car2"}})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]map[string]*kvrwset.Version{"fabcar": {"car9": {BlockNum: 6}, "car2": nil}}, committed)
+	assert.Equal(t, 2, sim.GetStateCallCount())
+	assert.Equal(t, 1, sim.DoneCallCount())
+}
+
+func TestEndorserOrderedDependencies(t *testing.T) {
+	t.Setenv("FABRIC_SHARDING_ENABLED", "true")
+
+	proofs := func(txID string, shards ...string) string {
+		var prepared []*sharding.PrepareProof
+		for _, shard := range shards {
+			prepared = append(prepared, &sharding.PrepareProof{TxID: txID, ShardID: shard})
+		}
+		encoded, err := sharding.EncodeProofs(prepared)
+		require.NoError(t, err)
+		return encoded
+	}
+	envelope := func(txID, claims string, kvrw *kvrwset.KVRWSet) []byte {
+		nsRWSet, err := proto.Marshal(kvrw)
+		require.NoError(t, err)
+		results, err := proto.Marshal(&rwset.TxReadWriteSet{
+			DataModel: rwset.TxReadWriteSet_KV,
+			NsRwset:   []*rwset.NsReadWriteSet{{Namespace: "fabcar", Rwset: nsRWSet}},
+		})
+		require.NoError(t, err)
+		return createTestEnvelope(t, txID, &pb.ChaincodeAction{
+			Response: &pb.Response{Status: 200, Message: "OK; DependencyInfo:" + claims},
+			Results:  results,
+		})
+	}
+	reads := func(keys ...string) *kvrwset.KVRWSet {
+		kvrw := &kvrwset.KVRWSet{}
+		for _, key := range keys {
+			kvrw.Reads = append(kvrw.Reads, &kvrwset.KVRead{Key: key, Version: &kvrwset.Version{BlockNum: 5}})
+		}
+		return kvrw
+	}
+
+	block := createTestBlock(nil)
+	block.Header.Number = 7
+	block.Data.Data = [][]byte{
+		envelope("tx1", "HasDependency=false,DependentTxID=,ShardProofs="+proofs("tx1", "mychannel/fabcar"),
+			&kvrwset.KVRWSet{Writes: []*kvrwset.KVWrite{{Key: "car1"}, {Key: "car2"}}}),
+		// every dependency of tx2 is kept
+		envelope("tx2", "HasDependency=true,DependentTxID=tx0,tx1,ShardProofs="+proofs("tx2", "mychannel/fabcar"),
+			reads("car1")),
+		// the proof of tx3 is not for the namespace it read
+		envelope("tx3", "HasDependency=false,DependentTxID=,ShardProofs="+proofs("tx3", "mychannel/marbles"),
+			reads("car2", "car3")),
+		// the endorsement of tx4 is speculative
+		envelope("tx4", "HasDependency=false,Speculative=true,DependentTxID=,ShardProofs="+proofs("tx4", "mychannel/fabcar"),
+			reads("car1", "car4")),
+		// tx5 is trusted not to conflict with tx1, and its reads are not
+		// checked against the state
+		envelope("tx5", "HasDependency=false,DependentTxID=,ShardProofs="+proofs("tx5", "mychannel/fabcar#1"),
+			reads("car1", "car5")),
+	}
+
+	var requested map[string][]string
+	versions := stateVersionsFunc(func(keys map[string][]string) (map[string]map[string]*kvrwset.Version, error) {
+		requested = keys
+		return map[string]map[string]*kvrwset.Version{"fabcar": {"car3": {BlockNum: 5}, "car4": {BlockNum: 5}}}, nil
+	})
+
+	dag, err := buildDAGFromBlock(block, versions)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"car3", "car4"}, requested["fabcar"])
+	assert.Equal(t, []string{"tx0", "tx1"}, dag.Nodes["tx2"].DependentTxIDs)
+	assert.Empty(t, dag.Nodes["tx2"].ReadConflictTxIDs)
+	assert.Equal(t, []string{"tx1"}, dag.Nodes["tx3"].ReadConflictTxIDs)
+	assert.Equal(t, []string{"tx1"}, dag.Nodes["tx4"].ReadConflictTxIDs)
+	assert.Empty(t, dag.Nodes["tx5"].DependentTxIDs)
+	assert.Equal(t, map[string]int{"tx1": 0, "tx2": 1, "tx3": 1, "tx4": 1, "tx5": 0}, dag.Levels)
+
+	stats := dag.Stats()
+	assert.Equal(t, 3, stats.EndorserOrdered)
+	assert.Equal(t, 3, stats.Edges)
+
+	// no read-write set is checked when the endorser ordered the whole block
+	block.Data.Data = block.Data.Data[:2]
+	requested = nil
+	dag, err = buildDAGFromBlock(block, versions)
+	require.NoError(t, err)
+	assert.Nil(t, requested)
+	assert.Equal(t, map[string]int{"tx1": 0, "tx2": 1}, dag.Levels)
+}
+
+type simulatorProvider struct {
+	sim ledger2.TxSimulator
+}
+
+func (p simulatorProvider) NewTxSimulator(txid string) (ledger2.TxSimulator, error) {
+	return p.sim, nil
+}
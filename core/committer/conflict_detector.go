@@ -0,0 +1,202 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package committer
+
+import (
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-protos-go/ledger/rwset/kvrwset"
+	"github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/hyperledger/fabric/core/endorser/depinfo"
+	"github.com/hyperledger/fabric/core/endorser/sharding"
+	"github.com/hyperledger/fabric/core/ledger"
+	"github.com/pkg/errors"
+)
+
+// Conflict policies of the committer, which decide whether a transaction
+// conflicts with a transaction of the same block it depends on
+const (
+	// ConflictPolicyKeyOverlap reports a conflict when one of the
+	// transactions reads a key the other writes, or both write a key
+	ConflictPolicyKeyOverlap = "key-overlap"
+	// ConflictPolicyMVCC reports a conflict when the transaction read a key,
+	// or scanned a range holding a key, that the dependency writes, unless
+	// it read the version the dependency writes. Concurrent writes do not
+	// conflict, as the ledger applies them in block order.
+	ConflictPolicyMVCC = "mvcc"
+	// ConflictPolicyEndorserHints trusts the conflict types the endorser
+	// embedded in the dependency info: only a key the transaction read and
+	// the dependency writes conflicts. Dependencies the endorser did not
+	// classify fall back to the key-overlap policy.
+	ConflictPolicyEndorserHints = "endorser-hints"
+)
+
+// ConflictTx is a transaction of the block being committed
+type ConflictTx struct {
+	TxID string
+	// Index is the position of the transaction in the block
+	Index int
+	Tx    *peer.Transaction
+}
+
+// ConflictDetector decides whether a transaction must be invalidated because
+// of a transaction of the same block it depends on, which was validated
+// before it
+type ConflictDetector interface {
+	Conflicts(blockNum uint64, tx, dep *ConflictTx) bool
+}
+
+// NewConflictDetector returns the detector of the conflict policy, the
+// key-overlap policy when policy is empty
+func NewConflictDetector(policy string) (ConflictDetector, error) {
+	switch policy {
+	case "", ConflictPolicyKeyOverlap:
+		return keyOverlapDetector{}, nil
+	case ConflictPolicyMVCC:
+		return mvccDetector{}, nil
+	case ConflictPolicyEndorserHints:
+		return endorserHintsDetector{}, nil
+	default:
+		return nil, errors.Errorf("invalid conflict policy %q", policy)
+	}
+}
+
+// conflictDetector returns the detector of the committer's conflict policy
+func (lc *LedgerCommitter) conflictDetector() ConflictDetector {
+	if lc.ConflictDetector == nil {
+		return keyOverlapDetector{}
+	}
+	return lc.ConflictDetector
+}
+
+type keyOverlapDetector struct{}
+
+func (keyOverlapDetector) Conflicts(blockNum uint64, tx, dep *ConflictTx) bool {
+	return checkRWSetConflicts(tx.Tx, dep.Tx)
+}
+
+type mvccDetector struct{}
+
+func (mvccDetector) Conflicts(blockNum uint64, tx, dep *ConflictTx) bool {
+	nsMap, err := extractRWSet(tx.Tx)
+	if err != nil {
+		logger.Errorf("Failed to extract RW set from tx %s: %v", tx.TxID, err)
+		return false
+	}
+	depNsMap, err := extractRWSet(dep.Tx)
+	if err != nil {
+		logger.Errorf("Failed to extract RW set from tx %s: %v", dep.TxID, err)
+		return false
+	}
+	// The version the dependency gives the keys it writes
+	written := &kvrwset.Version{BlockNum: blockNum, TxNum: uint64(dep.Index)}
+
+	for ns, nsData := range nsMap {
+		depNsData, exists := depNsMap[ns]
+		if !exists {
+			continue
+		}
+
+		kvrw := &kvrwset.KVRWSet{}
+		if err := proto.Unmarshal(nsData.Rwset, kvrw); err != nil {
+			logger.Errorf("Failed to unmarshal RW set of tx %s: %v", tx.TxID, err)
+			return false
+		}
+		depKVRW := &kvrwset.KVRWSet{}
+		if err := proto.Unmarshal(depNsData.Rwset, depKVRW); err != nil {
+			logger.Errorf("Failed to unmarshal RW set of tx %s: %v", dep.TxID, err)
+			return false
+		}
+
+		writes := make(map[string]bool)
+		for _, w := range depKVRW.Writes {
+			writes[w.Key] = true
+		}
+		for _, r := range kvrw.Reads {
+			if writes[r.Key] && !ledger.SameVersion(r.Version, written) {
+				return true
+			}
+		}
+		// A write within a scanned range is a phantom read
+		for _, rqi := range kvrw.RangeQueriesInfo {
+			for key := range writes {
+				if key >= rqi.StartKey && (rqi.EndKey == "" || key < rqi.EndKey) {
+					return true
+				}
+			}
+		}
+
+		for _, coll := range nsData.CollectionHashedRwset {
+			hashedRW := &kvrwset.HashedRWSet{}
+			if err := proto.Unmarshal(coll.HashedRwset, hashedRW); err != nil {
+				logger.Errorf("Failed to unmarshal hashed RW set for collection %s: %v", coll.CollectionName, err)
+				continue
+			}
+			for _, depColl := range depNsData.CollectionHashedRwset {
+				if depColl.CollectionName != coll.CollectionName {
+					continue
+				}
+				depHashedRW := &kvrwset.HashedRWSet{}
+				if err := proto.Unmarshal(depColl.HashedRwset, depHashedRW); err != nil {
+					logger.Errorf("Failed to unmarshal hashed RW set for collection %s: %v", depColl.CollectionName, err)
+					continue
+				}
+
+				hashedWrites := make(map[string]bool)
+				for _, w := range depHashedRW.HashedWrites {
+					hashedWrites[string(w.KeyHash)] = true
+				}
+				for _, r := range hashedRW.HashedReads {
+					if hashedWrites[string(r.KeyHash)] && !ledger.SameVersion(r.Version, written) {
+						return true
+					}
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+type endorserHintsDetector struct{}
+
+func (endorserHintsDetector) Conflicts(blockNum uint64, tx, dep *ConflictTx) bool {
+	actions, err := chaincodeActions(tx.Tx)
+	if err != nil {
+		logger.Errorf("Failed to extract chaincode actions from tx %s: %v", tx.TxID, err)
+		return false
+	}
+
+	var types []sharding.ConflictType
+	classified := false
+	for _, action := range actions {
+		if action.Response == nil {
+			continue
+		}
+		info, err := depinfo.Parse(action.Response.Message)
+		if err != nil {
+			logger.Warningf("Failed to parse dependency info for tx %s: %s", tx.TxID, err)
+			continue
+		}
+		if info == nil {
+			continue
+		}
+		if actionTypes, ok := info.ConflictTypes[dep.TxID]; ok {
+			classified = true
+			types = append(types, actionTypes...)
+		}
+	}
+	if !classified {
+		return checkRWSetConflicts(tx.Tx, dep.Tx)
+	}
+
+	for _, conflictType := range types {
+		if conflictType == sharding.ConflictReadWrite {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package committer
+
+// DAGStats describes the dependency structure of a block committed through
+// the transaction DAG
+type DAGStats struct {
+	BlockNumber uint64
+	// Nodes is the number of transactions of the block in the DAG
+	Nodes int
+	// Edges is the number of dependencies between transactions of the
+	// block; those on transactions of earlier blocks are not counted
+	Edges int
+	// CriticalPathLength is the number of transactions on the longest chain
+	// of dependencies, i.e. the number of levels validated one after another
+	CriticalPathLength int
+	// Parallelism is the average number of transactions validated together,
+	// Nodes over CriticalPathLength
+	Parallelism float64
+	// EndorserOrdered is the number of transactions whose dependencies were
+	// all given by the endorser
+	EndorserOrdered int
+	// LevelWidths gives the number of transactions of each level, from
+	// level 0
+	LevelWidths []int
+}
+
+// Stats returns the structure of the DAG, whose levels must have been
+// calculated
+func (dag *TransactionDAG) Stats() DAGStats {
+	dag.mutex.RLock()
+	defer dag.mutex.RUnlock()
+
+	stats := DAGStats{Nodes: len(dag.Nodes)}
+	for txID, node := range dag.Nodes {
+		if node.EndorserOrdered {
+			stats.EndorserOrdered++
+		}
+		seen := make(map[string]bool)
+		for _, depTxID := range node.DependentTxIDs {
+			if _, inBlock := dag.Nodes[depTxID]; inBlock && depTxID != txID && !seen[depTxID] {
+				seen[depTxID] = true
+				stats.Edges++
+			}
+		}
+	}
+	for _, level := range dag.Levels {
+		for len(stats.LevelWidths) <= level {
+			stats.LevelWidths = append(stats.LevelWidths, 0)
+		}
+		stats.LevelWidths[level]++
+	}
+	stats.CriticalPathLength = len(stats.LevelWidths)
+	if stats.CriticalPathLength > 0 {
+		stats.Parallelism = float64(stats.Nodes) / float64(stats.CriticalPathLength)
+	}
+	return stats
+}
+
+// GetLastBlockDAGStats returns the structure of the DAG of the last block
+// committed through it, or nil when none was. Blocks committed without the
+// DAG, as when sharding is disabled, leave it unchanged.
+func (lc *LedgerCommitter) GetLastBlockDAGStats() *DAGStats {
+	lc.statsMutex.RLock()
+	defer lc.statsMutex.RUnlock()
+
+	if lc.lastDAGStats == nil {
+		return nil
+	}
+	stats := *lc.lastDAGStats
+	stats.LevelWidths = append([]int(nil), stats.LevelWidths...)
+	return &stats
+}
+
+// recordDAGStats keeps the structure of the DAG of a committed block
+func (lc *LedgerCommitter) recordDAGStats(blockNumber uint64, dag *TransactionDAG) {
+	stats := dag.Stats()
+	stats.BlockNumber = blockNumber
+
+	lc.statsMutex.Lock()
+	lc.lastDAGStats = &stats
+	lc.statsMutex.Unlock()
+
+	logger.Debugf("DAG of block %d: %d transactions, %d ordered by the endorser, %d edges, critical path %d, parallelism %.2f, level widths %v",
+		blockNumber, stats.Nodes, stats.EndorserOrdered, stats.Edges, stats.CriticalPathLength, stats.Parallelism, stats.LevelWidths)
+}
@@ -0,0 +1,123 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package committer
+
+import (
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/hyperledger/fabric/core/ledger"
+	"github.com/hyperledger/fabric/protoutil"
+)
+
+// endorsementResults holds the outcome of checking the endorsements of each
+// transaction of a block, which the workers of the pool fill in while the DAG
+// is built and its levels are validated
+type endorsementResults struct {
+	done    []chan struct{}
+	reasons []ledger.AbortReason
+}
+
+// validateEndorsements starts checking the endorsements of the transactions
+// of the block on a pool of ConcurrencyLimit workers, or of one worker per CPU
+// when it is not set. It returns at once; wait gives the outcome of each
+// transaction. The signatures and endorsement policies are not verified here:
+// the validator of the peer has verified them before the block is committed.
+func (lc *LedgerCommitter) validateEndorsements(block *common.Block, timer stageTimer) *endorsementResults {
+	data := block.Data.Data
+	count := len(data)
+	results := &endorsementResults{
+		done:    make([]chan struct{}, count),
+		reasons: make([]ledger.AbortReason, count),
+	}
+	indexes := make(chan int, count)
+	for i := 0; i < count; i++ {
+		results.done[i] = make(chan struct{})
+		indexes <- i
+	}
+	close(indexes)
+
+	workers := lc.ConcurrencyLimit
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	if workers > count {
+		workers = count
+	}
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results.reasons[i] = validateTxEndorsements(i, data[i])
+				close(results.done[i])
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		timer.observe(StageEndorsements, start)
+	}()
+
+	return results
+}
+
+// wait blocks until the endorsements of the transaction at the index of the
+// block are validated, and returns the reason to abort it, empty when they
+// are valid
+func (r *endorsementResults) wait(txIndex int) ledger.AbortReason {
+	<-r.done[txIndex]
+	return r.reasons[txIndex]
+}
+
+// validateTxEndorsements checks that every endorsement of a transaction of
+// the block carries an endorser identity and a signature, and simulates the
+// cost of verifying them. It returns the reason to abort the transaction,
+// empty when the check passes.
+func validateTxEndorsements(txIndex int, txEnvelopeBytes []byte) ledger.AbortReason {
+	env, err := protoutil.GetEnvelopeFromBlock(txEnvelopeBytes)
+	if err != nil {
+		return ledger.AbortReasonParseError
+	}
+	payload, err := protoutil.UnmarshalPayload(env.Payload)
+	if err != nil {
+		return ledger.AbortReasonParseError
+	}
+	tx, err := protoutil.UnmarshalTransaction(payload.Data)
+	if err != nil {
+		return ledger.AbortReasonParseError
+	}
+
+	for _, action := range tx.Actions {
+		cap := &peer.ChaincodeActionPayload{}
+		if err := proto.Unmarshal(action.Payload, cap); err != nil {
+			return ledger.AbortReasonParseError
+		}
+		if cap.Action == nil {
+			continue // It might be a system transaction or different payload format
+		}
+
+		// Endorsements must carry both the endorser identity and its signature
+		for _, endorsement := range cap.Action.Endorsements {
+			if len(endorsement.Endorser) == 0 || len(endorsement.Signature) == 0 {
+				logger.Errorf("Transaction %d of the block carries an unsigned endorsement", txIndex)
+				return ledger.AbortReasonMissingEndorsement
+			}
+		}
+	}
+
+	// Simulate the cost of VSCC (Signature Verification)
+	simulateVSCC()
+	return ""
+}
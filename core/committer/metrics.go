@@ -0,0 +1,75 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package committer
+
+import (
+	"time"
+
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric/common/metrics"
+	"github.com/hyperledger/fabric/protoutil"
+)
+
+// Stages of committing a block through the transaction DAG
+const (
+	// StageDAG builds the DAG, unmarshalling the read-write sets
+	StageDAG = "dag"
+	// StageEndorsements checks the endorsements of every transaction,
+	// alongside StageDAG and StageValidation
+	StageEndorsements = "endorsements"
+	// StageValidation validates the transactions level by level
+	StageValidation = "validation"
+	// StageCommit commits the block to the ledger
+	StageCommit = "commit"
+)
+
+var stageDurationHistogramOpts = metrics.HistogramOpts{
+	Namespace:    "committer",
+	Name:         "stage_duration",
+	Help:         "The time spent on each stage of committing a block through the transaction DAG.",
+	LabelNames:   []string{"channel", "stage"},
+	StatsdFormat: "%{#fqname}.%{channel}.%{stage}",
+}
+
+// Metrics are the metrics of the committer
+type Metrics struct {
+	StageDuration metrics.Histogram
+}
+
+// NewMetrics creates the metrics of the committer
+func NewMetrics(provider metrics.Provider) *Metrics {
+	return &Metrics{
+		StageDuration: provider.NewHistogram(stageDurationHistogramOpts),
+	}
+}
+
+// stageTimer records the duration of the stages of committing a block
+type stageTimer struct {
+	metrics   *Metrics
+	channelID string
+}
+
+// stageTimer returns the timer of the stages of committing the block, which
+// records nothing when the committer has no metrics
+func (lc *LedgerCommitter) stageTimer(block *common.Block) stageTimer {
+	if lc.Metrics == nil {
+		return stageTimer{}
+	}
+	channelID, err := protoutil.GetChannelIDFromBlock(block)
+	if err != nil {
+		logger.Debugf("Failed to get the channel of block %d: %s", block.Header.Number, err)
+	}
+	return stageTimer{metrics: lc.Metrics, channelID: channelID}
+}
+
+// observe records the duration of a stage begun at start
+func (t stageTimer) observe(stage string, start time.Time) {
+	if t.metrics == nil {
+		return
+	}
+	t.metrics.StageDuration.With("channel", t.channelID, "stage", stage).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,235 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package committer
+
+import (
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-protos-go/ledger/rwset"
+	"github.com/hyperledger/fabric-protos-go/ledger/rwset/kvrwset"
+	"github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/hyperledger/fabric/core/endorser/depinfo"
+	"github.com/hyperledger/fabric/core/endorser/sharding"
+	"github.com/hyperledger/fabric/core/ledger"
+	"github.com/pkg/errors"
+)
+
+// StateVersionReader reads the versions of keys in the committed state,
+// against which the read sets of a block are checked before it is committed
+type StateVersionReader interface {
+	// StateVersions returns the committed version of the keys, given by
+	// namespace, with nil for the keys that do not exist
+	StateVersions(keys map[string][]string) (map[string]map[string]*kvrwset.Version, error)
+}
+
+// TxSimulatorProvider creates the simulators that read the committed state
+type TxSimulatorProvider interface {
+	NewTxSimulator(txid string) (ledger.TxSimulator, error)
+}
+
+// simulatedStateVersions reads the versions of keys from the read set of a
+// simulation reading them
+type simulatedStateVersions struct {
+	provider TxSimulatorProvider
+}
+
+// NewSimulatedStateVersions returns a StateVersionReader reading the versions
+// of keys through the simulators of the ledger
+func NewSimulatedStateVersions(provider TxSimulatorProvider) StateVersionReader {
+	return &simulatedStateVersions{provider: provider}
+}
+
+func (s *simulatedStateVersions) StateVersions(keys map[string][]string) (map[string]map[string]*kvrwset.Version, error) {
+	sim, err := s.provider.NewTxSimulator("")
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to create a simulator")
+	}
+	// The simulator holds the commit lock until it is done
+	defer sim.Done()
+
+	return ledger.CommittedVersions(sim, keys)
+}
+
+// blockTx is a transaction of a block
+type blockTx struct {
+	txID  string
+	index int
+	tx    *peer.Transaction
+	// ordered is set when the endorser reported all the conflicts of the
+	// transaction with the earlier transactions
+	ordered bool
+}
+
+// endorserOrdered reports whether the dependency info of a chaincode action
+// lists all its conflicts: a shard of each namespace it read or wrote prepared
+// it and reported the pending transactions conflicting with it. Speculative
+// endorsements and those missing a shard are not trusted.
+func endorserOrdered(action *peer.ChaincodeAction, info *depinfo.DependencyInfo) bool {
+	if len(info.Proofs) == 0 || len(info.MissingShards) > 0 || info.Speculative {
+		return false
+	}
+	proven := make(map[string]bool)
+	for _, proof := range info.Proofs {
+		proven[sharding.ContractOfShard(proof.ShardID)] = true
+	}
+
+	txRWSet := &rwset.TxReadWriteSet{}
+	if err := proto.Unmarshal(action.Results, txRWSet); err != nil {
+		return false
+	}
+	for _, nsRWSet := range txRWSet.NsRwset {
+		if !proven[nsRWSet.Namespace] {
+			return false
+		}
+	}
+	return true
+}
+
+// stateRead is a read of a key no earlier transaction of the block writes
+type stateRead struct {
+	txID      string
+	namespace string
+	key       string
+	version   *kvrwset.Version
+}
+
+// addReadConflicts adds the dependencies of each transaction on the earlier
+// transactions of the block writing a key it read, or a key within a range
+// it scanned, which make its read stale. It returns the transactions whose
+// reads of the keys no earlier transaction writes are already stale in the
+// committed state; they are not checked when versions is nil. Only the
+// public reads are checked against the committed state.
+//
+// The reads of the transactions the endorser ordered are not checked, as
+// their dependencies are already in the DAG; the ledger's MVCC validation
+// still catches those stale in the committed state. The read-write sets of
+// the transactions following the last one it did not order are not
+// unmarshalled.
+func addReadConflicts(dag *TransactionDAG, txs []blockTx, versions StateVersionReader) map[string]bool {
+	last := len(txs) - 1
+	for last >= 0 && txs[last].ordered {
+		last--
+	}
+	if last < 0 {
+		logger.Debugf("Dependencies of the %d transactions of the block given by the endorser", len(txs))
+		return nil
+	}
+
+	// writers holds the last transaction to write each key so far, by
+	// namespace, or namespace and collection for the hashed keys
+	writers := make(map[string]map[string]string)
+	var reads []stateRead
+
+	addConflict := func(txID, space, key string) bool {
+		writer, ok := writers[space][key]
+		if ok && writer != txID {
+			dag.addReadConflict(txID, writer)
+		}
+		return ok
+	}
+	addWrite := func(txID, space, key string) {
+		if writers[space] == nil {
+			writers[space] = make(map[string]string)
+		}
+		writers[space][key] = txID
+	}
+
+	for _, t := range txs[:last+1] {
+		nsMap, err := extractRWSet(t.tx)
+		if err != nil {
+			logger.Warningf("Failed to extract RW set from tx %s: %v", t.txID, err)
+			continue
+		}
+
+		var writes [][2]string
+		for ns, nsData := range nsMap {
+			kvrw := &kvrwset.KVRWSet{}
+			if err := proto.Unmarshal(nsData.Rwset, kvrw); err != nil {
+				logger.Warningf("Failed to unmarshal RW set of tx %s: %v", t.txID, err)
+				continue
+			}
+			if !t.ordered {
+				for _, r := range kvrw.Reads {
+					if !addConflict(t.txID, ns, r.Key) {
+						reads = append(reads, stateRead{txID: t.txID, namespace: ns, key: r.Key, version: r.Version})
+					}
+				}
+				for _, rqi := range kvrw.RangeQueriesInfo {
+					for key := range writers[ns] {
+						if key >= rqi.StartKey && (rqi.EndKey == "" || key < rqi.EndKey) {
+							addConflict(t.txID, ns, key)
+						}
+					}
+				}
+			}
+			for _, w := range kvrw.Writes {
+				writes = append(writes, [2]string{ns, w.Key})
+			}
+
+			for _, coll := range nsData.CollectionHashedRwset {
+				hashedRW := &kvrwset.HashedRWSet{}
+				if err := proto.Unmarshal(coll.HashedRwset, hashedRW); err != nil {
+					logger.Warningf("Failed to unmarshal hashed RW set of tx %s for collection %s: %v", t.txID, coll.CollectionName, err)
+					continue
+				}
+				space := ns + "/" + coll.CollectionName
+				if !t.ordered {
+					for _, r := range hashedRW.HashedReads {
+						addConflict(t.txID, space, string(r.KeyHash))
+					}
+				}
+				for _, w := range hashedRW.HashedWrites {
+					writes = append(writes, [2]string{space, string(w.KeyHash)})
+				}
+			}
+		}
+		// A transaction's writes apply after its reads
+		for _, w := range writes {
+			addWrite(t.txID, w[0], w[1])
+		}
+	}
+
+	if versions == nil || len(reads) == 0 {
+		return nil
+	}
+	keys := make(map[string][]string)
+	for _, r := range reads {
+		keys[r.namespace] = append(keys[r.namespace], r.key)
+	}
+	committed, err := versions.StateVersions(keys)
+	if err != nil {
+		logger.Warningf("Failed to read the committed versions of the keys read by the block: %s", err)
+		return nil
+	}
+	stale := make(map[string]bool)
+	for _, r := range reads {
+		if !ledger.SameVersion(r.version, committed[r.namespace][r.key]) {
+			stale[r.txID] = true
+		}
+	}
+	return stale
+}
+
+// addReadConflict makes the transaction depend on a transaction of the block
+// writing a key it read
+func (dag *TransactionDAG) addReadConflict(txID, writerTxID string) {
+	dag.mutex.Lock()
+	defer dag.mutex.Unlock()
+
+	node, exists := dag.Nodes[txID]
+	if !exists {
+		return
+	}
+	for _, depTxID := range node.DependentTxIDs {
+		if depTxID == writerTxID {
+			return
+		}
+	}
+	node.HasDependency = true
+	node.DependentTxIDs = append(node.DependentTxIDs, writerTxID)
+	node.ReadConflictTxIDs = append(node.ReadConflictTxIDs, writerTxID)
+	dag.Dependencies[writerTxID] = append(dag.Dependencies[writerTxID], txID)
+}
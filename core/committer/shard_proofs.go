@@ -0,0 +1,207 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package committer
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-protos-go/ledger/rwset"
+	"github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/hyperledger/fabric/core/endorser/depinfo"
+	"github.com/hyperledger/fabric/core/endorser/sharding"
+	"github.com/pkg/errors"
+)
+
+// ShardProofVerifierEnvVar enables commit-time verification of the shard
+// prepare proofs embedded in endorsements when set to "true"
+const ShardProofVerifierEnvVar = "FABRIC_VERIFY_SHARD_PROOFS"
+
+// ShardProofVerifier verifies a single shard prepare proof. The default
+// verifier checks the proof signatures issued by the shards; a verifier
+// checking them against the shard replica certificates can be set on the
+// LedgerCommitter instead.
+type ShardProofVerifier interface {
+	VerifyShardProof(proof *sharding.PrepareProof) error
+}
+
+type shardSignatureVerifier struct{}
+
+func (shardSignatureVerifier) VerifyShardProof(proof *sharding.PrepareProof) error {
+	return sharding.VerifyPrepareProof(proof)
+}
+
+// verifyShardProofs checks that the dependency info in a chaincode response
+// message is backed by valid proofs for txID, i.e. that the claimed
+// dependencies are exactly those the shards reported. Responses without
+// dependency info are not subject to verification.
+func verifyShardProofs(verifier ShardProofVerifier, txID, responseMsg string) error {
+	info, err := depinfo.Parse(responseMsg)
+	if err != nil || info == nil {
+		return err
+	}
+	claimedDeps := normalizeTxIDs(info.DependentTxIDs)
+	// MissingShards lists the shards that did not answer, which must not
+	// have a proof
+	missingShards := make(map[string]bool)
+	for _, shardID := range info.MissingShards {
+		missingShards[shardID] = true
+	}
+	claimedChain := strings.Join(info.DependencyChain, ";")
+
+	proofs := info.Proofs
+	if len(proofs) == 0 {
+		if info.HasDependency || claimedDeps != "" || claimedChain != "" {
+			return errors.New("dependency claims carry no shard proofs")
+		}
+		return nil
+	}
+
+	hasDependency := false
+	var deps []string
+	for _, proof := range proofs {
+		if proof.TxID != txID {
+			return errors.Errorf("proof from shard %s is for tx %s", proof.ShardID, proof.TxID)
+		}
+		if missingShards[proof.ShardID] {
+			return errors.Errorf("shard %s is claimed missing but has a proof", proof.ShardID)
+		}
+		if err := verifier.VerifyShardProof(proof); err != nil {
+			return err
+		}
+		if proof.Rejected {
+			return errors.Errorf("shard %s rejected the transaction", proof.ShardID)
+		}
+		if proof.HasDependency {
+			hasDependency = true
+		}
+		deps = append(deps, strings.Split(proof.DependentTxID, ",")...)
+	}
+
+	if hasDependency != info.HasDependency {
+		return errors.Errorf("claimed HasDependency=%v but shard proofs report %v", info.HasDependency, hasDependency)
+	}
+	if proven := normalizeTxIDs(deps); proven != claimedDeps {
+		return errors.Errorf("claimed dependencies [%s] but shard proofs report [%s]", claimedDeps, proven)
+	}
+	// The chain is ordered, so it must match the merged chains exactly
+	if proven := strings.Join(sharding.MergeDependencyChains(proofs), ";"); proven != claimedChain {
+		return errors.Errorf("claimed dependency chain [%s] but shard proofs report [%s]", claimedChain, proven)
+	}
+	return nil
+}
+
+// normalizeTxIDs returns the sorted, de-duplicated, comma-separated list of
+// the non-empty TxIDs
+func normalizeTxIDs(txIDs []string) string {
+	set := make(map[string]bool)
+	var list []string
+	for _, txID := range txIDs {
+		if txID != "" && !set[txID] {
+			set[txID] = true
+			list = append(list, txID)
+		}
+	}
+	sort.Strings(list)
+	return strings.Join(list, ",")
+}
+
+// verifyActionProofs verifies the shard proofs of a chaincode action, then
+// checks them against the namespaces of the action and the proofs of its
+// dependencies in the block
+func verifyActionProofs(verifier ShardProofVerifier, indexes *shardProofIndexes, txID string, action *peer.ChaincodeAction) error {
+	if err := verifyShardProofs(verifier, txID, action.Response.Message); err != nil {
+		return err
+	}
+	namespaces, err := actionNamespaces(action)
+	if err != nil {
+		return err
+	}
+	return indexes.verify(txID, action.Response.Message, namespaces)
+}
+
+// embeddedProofs returns the shard proofs embedded in the dependency info of
+// a chaincode response message, if any
+func embeddedProofs(responseMsg string) ([]*sharding.PrepareProof, error) {
+	info, err := depinfo.Parse(responseMsg)
+	if err != nil || info == nil {
+		return nil, err
+	}
+	return info.Proofs, nil
+}
+
+// actionNamespaces returns the namespaces whose keys the chaincode action read
+// or wrote, along with the chaincode it invoked
+func actionNamespaces(action *peer.ChaincodeAction) (map[string]bool, error) {
+	namespaces := make(map[string]bool)
+	if action.ChaincodeId != nil {
+		namespaces[action.ChaincodeId.Name] = true
+	}
+	if len(action.Results) == 0 {
+		return namespaces, nil
+	}
+	txRWSet := &rwset.TxReadWriteSet{}
+	if err := proto.Unmarshal(action.Results, txRWSet); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal the read-write set")
+	}
+	for _, nsRWSet := range txRWSet.NsRwset {
+		namespaces[nsRWSet.Namespace] = true
+	}
+	return namespaces, nil
+}
+
+// shardProofIndexes holds the commit index at which each shard prepared the
+// transactions of a block, by TxID and shard
+type shardProofIndexes struct {
+	mu      sync.Mutex
+	indexes map[string]map[string]uint64
+}
+
+// verify checks that the shard proofs embedded in a chaincode response are
+// consistent with the transaction and the block: each comes from a shard of
+// one of the namespaces the transaction touched, at most one per shard, and
+// was issued no earlier than the proofs of the transactions of the block it
+// depends on within the same shard, since the shard orders its reservations
+// by its log. A batch of prepares is applied in one entry of the log, so a
+// transaction and a dependency prepared in the same batch share its index.
+// The dependencies must have been verified before. It records the indexes of
+// the proofs for the transactions that depend on this one.
+func (s *shardProofIndexes) verify(txID, responseMsg string, namespaces map[string]bool) error {
+	proofs, err := embeddedProofs(responseMsg)
+	if err != nil || len(proofs) == 0 {
+		return err
+	}
+
+	indexes := make(map[string]uint64, len(proofs))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, proof := range proofs {
+		if contract := sharding.ContractOfShard(proof.ShardID); !namespaces[contract] {
+			return errors.Errorf("proof from shard %s is for namespace %s, which the transaction does not touch", proof.ShardID, contract)
+		}
+		if _, dup := indexes[proof.ShardID]; dup {
+			return errors.Errorf("shard %s issued several proofs", proof.ShardID)
+		}
+		if proof.CommitIndex == 0 {
+			return errors.Errorf("proof from shard %s has no commit index", proof.ShardID)
+		}
+		for _, dep := range strings.Split(proof.DependentTxID, ",") {
+			if depIndex, ok := s.indexes[dep][proof.ShardID]; ok && depIndex > proof.CommitIndex {
+				return errors.Errorf("proof from shard %s has commit index %d, before the index %d of dependency %s", proof.ShardID, proof.CommitIndex, depIndex, dep)
+			}
+		}
+		indexes[proof.ShardID] = proof.CommitIndex
+	}
+
+	if s.indexes == nil {
+		s.indexes = make(map[string]map[string]uint64)
+	}
+	s.indexes[txID] = indexes
+	return nil
+}
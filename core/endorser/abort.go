@@ -0,0 +1,123 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package endorser
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/fabric/core/endorser/sharding"
+	"github.com/pkg/errors"
+)
+
+// ErrUnknownTransaction is returned when aborting a transaction that holds no
+// reservation made by this endorser, or whose reservations expired
+var ErrUnknownTransaction = errors.New("no pending reservations of the transaction on this endorser")
+
+// AbortResult reports the shards a transaction was aborted on
+type AbortResult struct {
+	TxID   string
+	Shards []string
+	// Failed maps the shards the abort failed on to the error. The
+	// transaction may be aborted again to retry them.
+	Failed map[string]string `json:",omitempty"`
+}
+
+// endorsedTx holds the shards an endorsed transaction reserved keys on, and
+// the store it was prepared on
+type endorsedTx struct {
+	store   sharding.DependencyStore
+	shards  []string
+	expires time.Time
+}
+
+// endorsedShards holds the endorsed transactions whose reservations may still
+// be aborted
+type endorsedShards struct {
+	mu  sync.Mutex
+	txs map[string]endorsedTx
+}
+
+// add records the shards of an endorsed transaction until its reservations
+// expire
+func (s *endorsedShards) add(txID string, store sharding.DependencyStore, shards []string, expires time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.txs == nil {
+		s.txs = make(map[string]endorsedTx)
+	}
+	now := time.Now()
+	for id, tx := range s.txs {
+		if now.After(tx.expires) {
+			delete(s.txs, id)
+		}
+	}
+	s.txs[txID] = endorsedTx{store: store, shards: shards, expires: expires}
+}
+
+// expiry returns when the reservations of the transaction expire, if it holds
+// any
+func (s *endorsedShards) expiry(txID string) (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tx, ok := s.txs[txID]
+	if !ok || time.Now().After(tx.expires) {
+		return time.Time{}, false
+	}
+	return tx.expires, true
+}
+
+// take removes the transaction and returns it
+func (s *endorsedShards) take(txID string) (endorsedTx, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tx, ok := s.txs[txID]
+	delete(s.txs, txID)
+	if !ok || time.Now().After(tx.expires) {
+		return endorsedTx{}, false
+	}
+	return tx, true
+}
+
+// AbortTransaction releases the reservations of a transaction endorsed by this
+// endorser on every shard it was prepared on, rather than letting them expire.
+// A client aborts a transaction it will not submit, so that the transactions
+// touching the same keys no longer depend on it.
+func (e *Endorser) AbortTransaction(txID string) (*AbortResult, error) {
+	if e.dependencyStore("") == nil && len(e.ChannelDependencyStores) == 0 {
+		return nil, errors.New("dependency tracking is disabled")
+	}
+	tx, ok := e.endorsed.take(txID)
+	if !ok {
+		return nil, errors.WithMessagef(ErrUnknownTransaction, "tx %s", txID)
+	}
+
+	result := &AbortResult{TxID: txID}
+	var failed []string
+	for _, shardID := range tx.shards {
+		if err := tx.store.Abort(shardID, txID); err != nil {
+			if result.Failed == nil {
+				result.Failed = make(map[string]string)
+			}
+			result.Failed[shardID] = err.Error()
+			failed = append(failed, shardID)
+			continue
+		}
+		result.Shards = append(result.Shards, shardID)
+	}
+	sort.Strings(result.Shards)
+
+	if len(failed) > 0 {
+		e.endorsed.add(txID, tx.store, failed, tx.expires)
+		logger.Warningf("Failed to abort tx %s on shards %v", txID, result.Failed)
+	} else if e.DependencyGraph != nil {
+		e.DependencyGraph.Remove(txID)
+	}
+	logger.Infof("Aborted tx %s on shards %v at the client's request", txID, result.Shards)
+	return result, nil
+}
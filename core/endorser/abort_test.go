@@ -0,0 +1,148 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package endorser
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/endorser/sharding"
+	. "github.com/onsi/gomega"
+)
+
+// abortingStore records the aborts, and fails those of the shards listed in
+// failing
+type abortingStore struct {
+	flakyStore
+	mu      sync.Mutex
+	failing map[string]bool
+	aborted []string
+}
+
+func (s *abortingStore) Abort(shardID, txID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failing[shardID] {
+		return errors.New("shard is down")
+	}
+	s.aborted = append(s.aborted, shardID+"/"+txID)
+	return nil
+}
+
+func TestAbortTransaction(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	store := &abortingStore{failing: map[string]bool{"marbles": true}}
+	e := &Endorser{
+		Config:          EndorserConfig{PrepareTimeout: time.Second},
+		DependencyStore: store,
+	}
+	shards := map[string]map[string][]byte{
+		"fabcar":  {"fabcar:car1": []byte("red")},
+		"marbles": {"marbles:m1": []byte("blue")},
+	}
+
+	// read-only transactions reserve nothing
+	_, err := e.resolveDependencies(context.Background(), "mychannel", "tx1", DefaultLane, store, shards, nil, nil, false, 0)
+	gt.Expect(err).NotTo(HaveOccurred())
+	_, err = e.AbortTransaction("tx1")
+	gt.Expect(err).To(MatchError(ErrUnknownTransaction))
+
+	_, err = e.resolveDependencies(context.Background(), "mychannel", "tx2", DefaultLane, store, shards, nil, nil, true, 0)
+	gt.Expect(err).NotTo(HaveOccurred())
+	result, err := e.AbortTransaction("tx2")
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(result.Shards).To(Equal([]string{"fabcar"}))
+	gt.Expect(result.Failed).To(HaveKeyWithValue("marbles", "shard is down"))
+	gt.Expect(store.aborted).To(Equal([]string{"fabcar/tx2"}))
+
+	// the shards that failed are retried
+	store.failing = nil
+	result, err = e.AbortTransaction("tx2")
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(result.Shards).To(Equal([]string{"marbles"}))
+	gt.Expect(result.Failed).To(BeEmpty())
+
+	_, err = e.AbortTransaction("tx2")
+	gt.Expect(err).To(MatchError(ErrUnknownTransaction))
+
+	// reservations that expired can no longer be aborted
+	e.Config.ExpiryDuration = time.Millisecond
+	_, err = e.resolveDependencies(context.Background(), "mychannel", "tx3", DefaultLane, store, shards, nil, nil, true, 0)
+	gt.Expect(err).NotTo(HaveOccurred())
+	time.Sleep(5 * time.Millisecond)
+	_, err = e.AbortTransaction("tx3")
+	gt.Expect(err).To(MatchError(ErrUnknownTransaction))
+
+	_, err = (&Endorser{}).AbortTransaction("tx3")
+	gt.Expect(err).To(MatchError("dependency tracking is disabled"))
+}
+
+func TestAdminHandlerAbort(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	store := &abortingStore{}
+	e := &Endorser{
+		Config:          EndorserConfig{PrepareTimeout: time.Second},
+		DependencyStore: store,
+	}
+	_, err := e.resolveDependencies(context.Background(), "mychannel", "tx1", DefaultLane, store, map[string]map[string][]byte{"fabcar": {"fabcar:car1": []byte("red")}}, nil, nil, true, 0)
+	gt.Expect(err).NotTo(HaveOccurred())
+	handler := NewAdminHandler(e)
+
+	serve := func(method, target string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(method, target, nil))
+		return rr
+	}
+
+	rr := serve(http.MethodGet, "/endorser/abort?txid=tx1")
+	gt.Expect(rr.Code).To(Equal(http.StatusMethodNotAllowed))
+
+	rr = serve(http.MethodPost, "/endorser/abort")
+	gt.Expect(rr.Code).To(Equal(http.StatusBadRequest))
+
+	rr = serve(http.MethodPost, "/endorser/abort?txid=tx1")
+	gt.Expect(rr.Code).To(Equal(http.StatusOK))
+	gt.Expect(rr.Body.String()).To(MatchJSON(`{"TxID":"tx1","Shards":["fabcar"]}`))
+
+	rr = serve(http.MethodPost, "/endorser/abort?txid=tx1")
+	gt.Expect(rr.Code).To(Equal(http.StatusNotFound))
+}
+
+func TestChannelDependencyStores(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	store := &abortingStore{}
+	local := sharding.NewLocalDependencyStore(time.Minute)
+	e := &Endorser{
+		Config:                  EndorserConfig{PrepareTimeout: time.Second},
+		DependencyStore:         store,
+		ChannelDependencyStores: map[string]sharding.DependencyStore{"bench": local, "raft": nil},
+	}
+	gt.Expect(e.dependencyStore("mychannel")).To(BeIdenticalTo(store))
+	gt.Expect(e.dependencyStore("bench")).To(BeIdenticalTo(local))
+	// the embedded shards, which this endorser does not run
+	gt.Expect(e.dependencyStore("raft")).To(BeNil())
+
+	shards := map[string]map[string][]byte{"fabcar": {"fabcar:car1": []byte("red")}}
+	_, err := e.resolveDependencies(context.Background(), "bench", "tx1", DefaultLane, e.dependencyStore("bench"), shards, nil, nil, true, 0)
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(local.Reservations("fabcar")).To(Equal(1))
+
+	// the transaction is aborted on the store it was prepared on
+	result, err := e.AbortTransaction("tx1")
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(result.Shards).To(Equal([]string{"fabcar"}))
+	gt.Expect(local.Reservations("fabcar")).To(Equal(0))
+	gt.Expect(store.aborted).To(BeEmpty())
+}
@@ -0,0 +1,253 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package endorser
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// AdminPath is the prefix under which the operations server exposes the
+// endorser admin API
+const AdminPath = "/endorser/"
+
+// DefaultAdminExpireTimeout bounds how long a force-expire request waits for
+// the expiry to be replicated
+const DefaultAdminExpireTimeout = 10 * time.Second
+
+// BreakerStates reports the circuit breakers of the endorser
+type BreakerStates struct {
+	// Leader is the state of the breaker guarding the leader endorser
+	Leader *CircuitState `json:",omitempty"`
+	// Shards maps each shard used so far to the state of its breaker
+	Shards map[string]CircuitState
+}
+
+// ExpireResult is the response of a force-expire request
+type ExpireResult struct {
+	ShardID string
+	Key     string
+	// Removed is false when the key held no reservation
+	Removed bool
+}
+
+// AdminHandler serves the dependency tracking state of the endorser:
+//
+//	GET  /endorser/dependencies        reservations held by each local shard
+//	GET  /endorser/inflight            transactions waiting for their proof
+//	GET  /endorser/shards              status of each local shard replica
+//	GET  /endorser/breakers            circuit breaker states
+//	POST /endorser/expire?shard=&key=  force-expires a reservation
+//	POST /endorser/abort?txid=         aborts an endorsed transaction
+//	GET  /endorser/speculative?txid=   proofs of a speculative endorsement,
+//	                                   waiting up to &wait= for them
+//	GET  /endorser/expiries[?txid=]    streams the endorsements that expire
+//	                                   on the local shards
+//	POST /endorser/backup              backs up a local shard replica
+//	POST /endorser/restore             restores a shard from a backup
+type AdminHandler struct {
+	endorser *Endorser
+	mux      *http.ServeMux
+}
+
+// NewAdminHandler returns the admin API of the endorser
+func NewAdminHandler(e *Endorser) *AdminHandler {
+	h := &AdminHandler{endorser: e, mux: http.NewServeMux()}
+	h.mux.HandleFunc(AdminPath+"dependencies", h.handleDependencies)
+	h.mux.HandleFunc(AdminPath+"inflight", h.handleInFlight)
+	h.mux.HandleFunc(AdminPath+"shards", h.handleShards)
+	h.mux.HandleFunc(AdminPath+"breakers", h.handleBreakers)
+	h.mux.HandleFunc(AdminPath+"expire", h.handleExpire)
+	h.mux.HandleFunc(AdminPath+"abort", h.handleAbort)
+	h.mux.HandleFunc(AdminPath+"speculative", h.handleSpeculative)
+	h.mux.HandleFunc(AdminPath+"expiries", h.handleExpiries)
+	h.mux.HandleFunc(AdminPath+"backup", h.handleBackup)
+	h.mux.HandleFunc(AdminPath+"restore", h.handleRestore)
+	return h
+}
+
+func (h *AdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *AdminHandler) handleDependencies(w http.ResponseWriter, r *http.Request) {
+	if !h.shardsRunning(w) {
+		return
+	}
+	writeJSON(w, h.endorser.ShardManager.GetDependencies())
+}
+
+func (h *AdminHandler) handleInFlight(w http.ResponseWriter, r *http.Request) {
+	if !h.shardsRunning(w) {
+		return
+	}
+	writeJSON(w, h.endorser.ShardManager.GetInFlightTxIDs())
+}
+
+func (h *AdminHandler) handleShards(w http.ResponseWriter, r *http.Request) {
+	if !h.shardsRunning(w) {
+		return
+	}
+	writeJSON(w, h.endorser.ShardManager.GetStatus())
+}
+
+func (h *AdminHandler) handleBreakers(w http.ResponseWriter, r *http.Request) {
+	states := BreakerStates{Shards: map[string]CircuitState{}}
+	if h.endorser.LeaderCircuitBreaker != nil {
+		leader := h.endorser.LeaderCircuitBreaker.GetState()
+		states.Leader = &leader
+	}
+	if h.endorser.ShardCircuitBreakers != nil {
+		states.Shards = h.endorser.ShardCircuitBreakers.States()
+	}
+	writeJSON(w, states)
+}
+
+func (h *AdminHandler) handleExpire(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.shardsRunning(w) {
+		return
+	}
+	q := r.URL.Query()
+	shardID, key := q.Get("shard"), q.Get("key")
+	if shardID == "" || key == "" {
+		http.Error(w, "shard and key are required", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), DefaultAdminExpireTimeout)
+	defer cancel()
+	removed, err := h.endorser.ShardManager.ExpireDependency(ctx, shardID, key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	logger.Infof("Force-expired dependency on key %s of shard %s (removed: %t)", key, shardID, removed)
+	writeJSON(w, ExpireResult{ShardID: shardID, Key: key, Removed: removed})
+}
+
+func (h *AdminHandler) handleAbort(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	txID := r.URL.Query().Get("txid")
+	if txID == "" {
+		http.Error(w, "txid is required", http.StatusBadRequest)
+		return
+	}
+
+	result, err := h.endorser.AbortTransaction(txID)
+	switch {
+	case err != nil:
+		// Either the transaction or dependency tracking is unknown
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case len(result.Failed) > 0:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadGateway)
+		json.NewEncoder(w).Encode(result)
+	default:
+		writeJSON(w, result)
+	}
+}
+
+func (h *AdminHandler) handleSpeculative(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	txID := q.Get("txid")
+	if txID == "" {
+		http.Error(w, "txid is required", http.StatusBadRequest)
+		return
+	}
+	var wait time.Duration
+	if v := q.Get("wait"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid wait: %v", err), http.StatusBadRequest)
+			return
+		}
+		wait = d
+	}
+
+	proof, ok := h.endorser.SpeculativeProof(r.Context(), txID, wait)
+	if !ok {
+		http.Error(w, fmt.Sprintf("no speculative endorsement of tx %s", txID), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, proof)
+}
+
+// handleExpiries streams the expired endorsements as one JSON object per
+// line until the client goes away
+func (h *AdminHandler) handleExpiries(w http.ResponseWriter, r *http.Request) {
+	expiries, cancel, err := h.endorser.SubscribeExpiries()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	defer cancel()
+	txID := r.URL.Query().Get("txid")
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+	enc := json.NewEncoder(w)
+	for {
+		select {
+		case expired := <-expiries:
+			if txID != "" && expired.TxID != txID {
+				continue
+			}
+			if err := enc.Encode(expired); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (h *AdminHandler) handleBackup(w http.ResponseWriter, r *http.Request) {
+	if !h.shardsRunning(w) {
+		return
+	}
+	h.endorser.ShardManager.ServeBackup(w, r)
+}
+
+func (h *AdminHandler) handleRestore(w http.ResponseWriter, r *http.Request) {
+	if !h.shardsRunning(w) {
+		return
+	}
+	h.endorser.ShardManager.ServeRestore(w, r)
+}
+
+// shardsRunning replies with an error when the endorser tracks no dependencies
+func (h *AdminHandler) shardsRunning(w http.ResponseWriter) bool {
+	if h.endorser.ShardManager == nil {
+		http.Error(w, "dependency tracking is disabled", http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,62 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package endorser
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestAdminHandler(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	e := &Endorser{
+		LeaderCircuitBreaker: NewCircuitBreaker(DefaultCircuitBreakerConfig(), nil),
+		ShardCircuitBreakers: NewShardCircuitBreakers(CircuitBreakerConfig{Threshold: 1, Timeout: time.Minute}, ShardBreakerFailFast, nil),
+	}
+	e.ShardCircuitBreakers.Breaker("fabcar")
+	e.ShardCircuitBreakers.Breaker("marbles").Execute(func() error { return errors.New("unreachable") })
+	handler := NewAdminHandler(e)
+
+	serve := func(method, target string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(method, target, nil))
+		return rr
+	}
+
+	rr := serve(http.MethodGet, "/endorser/breakers")
+	gt.Expect(rr.Code).To(Equal(http.StatusOK))
+	gt.Expect(rr.Body.String()).To(MatchJSON(`{"Leader":"closed","Shards":{"fabcar":"closed","marbles":"open"}}`))
+
+	// the shard state is unavailable while dependency tracking is disabled
+	for _, path := range []string{"dependencies", "inflight", "shards", "backup", "restore"} {
+		rr = serve(http.MethodGet, "/endorser/"+path)
+		gt.Expect(rr.Code).To(Equal(http.StatusNotFound))
+		gt.Expect(rr.Body.String()).To(ContainSubstring("dependency tracking is disabled"))
+	}
+
+	rr = serve(http.MethodGet, "/endorser/expire?shard=fabcar&key=car1")
+	gt.Expect(rr.Code).To(Equal(http.StatusMethodNotAllowed))
+	gt.Expect(rr.Header().Get("Allow")).To(Equal(http.MethodPost))
+
+	rr = serve(http.MethodGet, "/endorser/unknown")
+	gt.Expect(rr.Code).To(Equal(http.StatusNotFound))
+}
+
+func TestCircuitStateString(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	gt.Expect(CircuitClosed.String()).To(Equal("closed"))
+	gt.Expect(CircuitOpen.String()).To(Equal("open"))
+	gt.Expect(CircuitHalfOpen.String()).To(Equal("half-open"))
+	gt.Expect(CircuitState(7).String()).To(Equal("unknown"))
+}
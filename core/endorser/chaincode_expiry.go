@@ -0,0 +1,51 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package endorser
+
+import (
+	"time"
+
+	"github.com/hyperledger/fabric/core/endorser/hints"
+)
+
+// chaincodeExpiry returns the ChaincodeExpiry that applies to the chaincode
+// on the channel: the one of channel/chaincode, else the one of the
+// chaincode, else the one of channel/*
+func (c EndorserConfig) chaincodeExpiry(channel, chaincode string) (time.Duration, bool) {
+	for _, scope := range []string{channel + "/" + chaincode, chaincode, channel + "/*"} {
+		if expiry, ok := c.ChaincodeExpiry[scope]; ok {
+			return expiry, true
+		}
+	}
+	return 0, false
+}
+
+// reservationExpiry returns how long the shards keep the reservations of a
+// transaction invoking the chaincode on the channel, or 0 when they keep
+// them for as long as they are configured to. The expiry configured for the
+// chaincode in ChaincodeExpiry applies, unless the chaincode declared a
+// shorter one in its hints.
+func (e *Endorser) reservationExpiry(channel, chaincode string, declared *hints.Hints) time.Duration {
+	expiry, _ := e.Config.chaincodeExpiry(channel, chaincode)
+	limit := expiry
+	if limit <= 0 {
+		limit = e.Config.expiryDuration()
+	}
+	if ttl := declared.TTL(); ttl > 0 && ttl < limit {
+		return ttl
+	}
+	return expiry
+}
+
+// retention returns how long the endorser remembers a transaction whose
+// reservations expire after expiry, as returned by reservationExpiry
+func (c EndorserConfig) retention(expiry time.Duration) time.Duration {
+	if expiry > 0 {
+		return expiry
+	}
+	return c.expiryDuration()
+}
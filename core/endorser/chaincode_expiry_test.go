@@ -0,0 +1,61 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package endorser
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/endorser/hints"
+	. "github.com/onsi/gomega"
+)
+
+func TestReservationExpiry(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	e := &Endorser{Config: EndorserConfig{
+		ExpiryDuration: 5 * time.Minute,
+		ChaincodeExpiry: map[string]time.Duration{
+			"mychannel/fabcar": time.Minute,
+			"fabcar":           2 * time.Minute,
+			"mychannel/*":      10 * time.Minute,
+		},
+	}}
+
+	gt.Expect(e.reservationExpiry("mychannel", "fabcar", nil)).To(Equal(time.Minute))
+	gt.Expect(e.reservationExpiry("otherchannel", "fabcar", nil)).To(Equal(2 * time.Minute))
+	gt.Expect(e.reservationExpiry("mychannel", "marbles", nil)).To(Equal(10 * time.Minute))
+	gt.Expect(e.reservationExpiry("otherchannel", "marbles", nil)).To(BeZero())
+
+	// the chaincode may only shorten the expiry
+	gt.Expect(e.reservationExpiry("mychannel", "fabcar", &hints.Hints{Expiry: "30s"})).To(Equal(30 * time.Second))
+	gt.Expect(e.reservationExpiry("mychannel", "fabcar", &hints.Hints{Expiry: "1h"})).To(Equal(time.Minute))
+	gt.Expect(e.reservationExpiry("otherchannel", "marbles", &hints.Hints{Expiry: "1m"})).To(Equal(time.Minute))
+	gt.Expect(e.reservationExpiry("otherchannel", "marbles", &hints.Hints{Expiry: "1h"})).To(BeZero())
+
+	gt.Expect(e.Config.retention(time.Minute)).To(Equal(time.Minute))
+	gt.Expect(e.Config.retention(0)).To(Equal(5 * time.Minute))
+}
+
+func TestResolveDependenciesExpiry(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	store := &recordingStore{}
+	e := &Endorser{Config: EndorserConfig{PrepareTimeout: time.Second, ExpiryDuration: 5 * time.Minute}}
+	shards := map[string]map[string][]byte{"fabcar": {"fabcar:car1": []byte("red")}}
+
+	_, err := e.resolveDependencies(context.Background(), "mychannel", "tx1", DefaultLane, store, shards, nil, nil, true, 0)
+	gt.Expect(err).NotTo(HaveOccurred())
+	_, err = e.resolveDependencies(context.Background(), "mychannel", "tx2", DefaultLane, store, shards, nil, nil, true, time.Minute)
+	gt.Expect(err).NotTo(HaveOccurred())
+
+	// the shards apply their own expiry when none overrides it
+	gt.Expect(store.reqs).To(HaveLen(2))
+	gt.Expect(store.reqs[0].TTL).To(BeZero())
+	gt.Expect(store.reqs[1].TTL).To(Equal(time.Minute))
+}
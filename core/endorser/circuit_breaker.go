@@ -7,11 +7,17 @@ SPDX-License-Identifier: Apache-2.0
 package endorser
 
 import (
-	"fmt"
+	"errors"
 	"sync"
 	"time"
+
+	"github.com/hyperledger/fabric/common/metrics"
 )
 
+// ErrCircuitOpen is returned by CircuitBreaker.Execute while the breaker is
+// open
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
 // CircuitState represents the state of a circuit breaker
 type CircuitState int
 
@@ -21,6 +27,24 @@ const (
 	CircuitHalfOpen
 )
 
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// MarshalText reports the state by name in the admin API
+func (s CircuitState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
 // CircuitBreakerConfig contains configuration for the circuit breaker
 type CircuitBreakerConfig struct {
 	Threshold     int
@@ -46,16 +70,34 @@ type CircuitBreaker struct {
 	config          CircuitBreakerConfig
 	state           CircuitState
 	mu              sync.RWMutex
-	metrics         *Metrics
 	retryCount      int
+
+	// openCounter, halfOpenCounter and closedCounter count the state
+	// transitions; they are nil when metrics are disabled
+	openCounter     metrics.Counter
+	halfOpenCounter metrics.Counter
+	closedCounter   metrics.Counter
 }
 
-// NewCircuitBreaker creates a new circuit breaker instance
+// NewCircuitBreaker creates a new circuit breaker instance reporting to the
+// leader circuit breaker metrics
 func NewCircuitBreaker(config CircuitBreakerConfig, metrics *Metrics) *CircuitBreaker {
-	return &CircuitBreaker{
-		config:  config,
-		state:   CircuitClosed,
-		metrics: metrics,
+	cb := &CircuitBreaker{
+		config: config,
+		state:  CircuitClosed,
+	}
+	if metrics != nil {
+		cb.openCounter = metrics.LeaderCircuitBreakerOpen
+		cb.halfOpenCounter = metrics.LeaderCircuitBreakerHalfOpen
+		cb.closedCounter = metrics.LeaderCircuitBreakerClosed
+	}
+	return cb
+}
+
+// count adds one to the counter, if metrics are enabled
+func count(counter metrics.Counter) {
+	if counter != nil {
+		counter.Add(1)
 	}
 }
 
@@ -65,19 +107,15 @@ func (cb *CircuitBreaker) Execute(operation func() error) error {
 	if cb.state == CircuitOpen {
 		if time.Since(cb.lastFailureTime) < cb.config.Timeout {
 			cb.mu.RUnlock()
-			if cb.metrics != nil {
-				cb.metrics.LeaderCircuitBreakerOpen.Add(1)
-			}
-			return fmt.Errorf("circuit breaker is open")
+			count(cb.openCounter)
+			return ErrCircuitOpen
 		}
 		cb.mu.RUnlock()
 		cb.mu.Lock()
 		cb.state = CircuitHalfOpen
 		cb.retryCount = 0
 		cb.mu.Unlock()
-		if cb.metrics != nil {
-			cb.metrics.LeaderCircuitBreakerHalfOpen.Add(1)
-		}
+		count(cb.halfOpenCounter)
 	} else {
 		cb.mu.RUnlock()
 	}
@@ -89,15 +127,11 @@ func (cb *CircuitBreaker) Execute(operation func() error) error {
 		if cb.state == CircuitHalfOpen {
 			cb.state = CircuitOpen
 			cb.lastFailureTime = time.Now()
-			if cb.metrics != nil {
-				cb.metrics.LeaderCircuitBreakerOpen.Add(1)
-			}
+			count(cb.openCounter)
 		} else if cb.failures >= cb.config.Threshold {
 			cb.state = CircuitOpen
 			cb.lastFailureTime = time.Now()
-			if cb.metrics != nil {
-				cb.metrics.LeaderCircuitBreakerOpen.Add(1)
-			}
+			count(cb.openCounter)
 		}
 		cb.mu.Unlock()
 		return err
@@ -107,12 +141,23 @@ func (cb *CircuitBreaker) Execute(operation func() error) error {
 	cb.failures = 0
 	cb.state = CircuitClosed
 	cb.mu.Unlock()
-	if cb.metrics != nil {
-		cb.metrics.LeaderCircuitBreakerClosed.Add(1)
-	}
+	count(cb.closedCounter)
 	return nil
 }
 
+// Reset closes the circuit breaker and forgets its failures, e.g. once the
+// operation it guards targets another endpoint
+func (cb *CircuitBreaker) Reset() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.failures = 0
+	cb.retryCount = 0
+	if cb.state != CircuitClosed {
+		cb.state = CircuitClosed
+		count(cb.closedCounter)
+	}
+}
+
 // GetState returns the current state of the circuit breaker
 func (cb *CircuitBreaker) GetState() CircuitState {
 	cb.mu.RLock()
@@ -0,0 +1,148 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package endorser
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/hyperledger/fabric/core/endorser/sharding/protos"
+	"github.com/pkg/errors"
+)
+
+// Failure policies of the conflict oracle
+const (
+	// OracleFailOpen endorses the proposals the oracle failed to review
+	OracleFailOpen = "open"
+	// OracleFailClosed fails the proposals the oracle failed to review
+	OracleFailClosed = "closed"
+)
+
+// DefaultConflictOracleTimeout bounds a review when
+// ConflictOracleConfig.Timeout is unset
+const DefaultConflictOracleTimeout = time.Second
+
+// StatusVetoed is the status of a proposal whose endorsement the conflict
+// oracle vetoed
+const StatusVetoed = 403
+
+// Outcomes of the reviews of the conflict oracle, as reported by the
+// oracle_reviews metric
+const (
+	oracleApproved     = "approved"
+	oracleVetoed       = "vetoed"
+	oracleFailedOpen   = "failed_open"
+	oracleFailedClosed = "failed_closed"
+)
+
+// ConflictOracle reviews the dependencies of a proposal once they were
+// gathered from the shards, and before the proposal is endorsed. It may veto
+// the endorsement or annotate it. sharding.GRPCConflictOracle implements it
+// over the ConflictOracle gRPC API of an external service.
+type ConflictOracle interface {
+	Review(ctx context.Context, req *protos.ReviewRequest) (*protos.ReviewResponse, error)
+}
+
+// ConflictOracleConfig configures the conflict oracle
+type ConflictOracleConfig struct {
+	// Address is the address of the oracle's ConflictOracle gRPC API. The
+	// proposals are not reviewed when it is empty.
+	Address string
+	// Timeout bounds each review. Defaults to DefaultConflictOracleTimeout.
+	Timeout time.Duration
+	// FailurePolicy decides what becomes of the proposals the oracle fails
+	// to review in time: OracleFailOpen (the default) endorses them, and
+	// OracleFailClosed fails them
+	FailurePolicy string
+}
+
+// timeout returns the bound of a review
+func (c ConflictOracleConfig) timeout() time.Duration {
+	if c.Timeout <= 0 {
+		return DefaultConflictOracleTimeout
+	}
+	return c.Timeout
+}
+
+// reviewRequest returns the request to review the proposal, given the keys
+// it touched and whether it wrote them
+func reviewRequest(up *UnpackedProposal, dependencies map[string][]byte, written map[string]bool) *protos.ReviewRequest {
+	req := &protos.ReviewRequest{
+		ChannelId: up.ChannelID(),
+		TxId:      up.TxID(),
+		Chaincode: up.ChaincodeName,
+	}
+	for key := range dependencies {
+		if written[key] {
+			req.Writes = append(req.Writes, key)
+		} else {
+			req.Reads = append(req.Reads, key)
+		}
+	}
+	sort.Strings(req.Reads)
+	sort.Strings(req.Writes)
+	return req
+}
+
+// reviewProposal asks the conflict oracle to review the proposal with the
+// dependencies gathered for it. It returns the annotations to add to the
+// response message, or the response refusing the proposal when the oracle
+// vetoed it. It fails when the oracle could not review the proposal and the
+// failure policy is closed.
+func (e *Endorser) reviewProposal(ctx context.Context, req *protos.ReviewRequest, deps *dependencyResolution) (string, *pb.ProposalResponse, error) {
+	if deps.dependentTxIDs != "" {
+		req.DependentTxIds = strings.Split(deps.dependentTxIDs, ",")
+	}
+	req.Proofs = deps.encodedProofs
+	req.Speculative = deps.speculative
+
+	ctx, cancel := context.WithTimeout(ctx, e.Config.ConflictOracle.timeout())
+	defer cancel()
+	resp, err := e.ConflictOracle.Review(ctx, req)
+	if err != nil {
+		if e.Config.ConflictOracle.FailurePolicy == OracleFailClosed {
+			e.Metrics.countOracleReview(req.ChannelId, req.Chaincode, oracleFailedClosed)
+			return "", nil, errors.WithMessage(err, "conflict oracle failed to review the proposal")
+		}
+		e.Metrics.countOracleReview(req.ChannelId, req.Chaincode, oracleFailedOpen)
+		logger.Warningf("Endorsing tx %s without the review of the conflict oracle: %s", req.TxId, err)
+		return "", nil, nil
+	}
+
+	if resp.Veto {
+		e.Metrics.countOracleReview(req.ChannelId, req.Chaincode, oracleVetoed)
+		logger.Debugf("Conflict oracle vetoed tx %s: %s", req.TxId, resp.Reason)
+		return "", &pb.ProposalResponse{Response: &pb.Response{
+			Status:  StatusVetoed,
+			Message: fmt.Sprintf("endorsement vetoed by the conflict oracle: %s", resp.Reason),
+		}}, nil
+	}
+
+	e.Metrics.countOracleReview(req.ChannelId, req.Chaincode, oracleApproved)
+	annotations := make([]string, 0, len(resp.Annotations))
+	for _, a := range resp.Annotations {
+		// The annotations must not break the claims that follow them
+		if a.Key == "" || strings.ContainsAny(a.Key, ";,=:") || strings.ContainsAny(a.Value, ";,") {
+			logger.Warningf("Dropping the annotation %q=%q of the conflict oracle on tx %s", a.Key, a.Value, req.TxId)
+			continue
+		}
+		annotations = append(annotations, a.Key+"="+a.Value)
+	}
+	return strings.Join(annotations, ","), nil, nil
+}
+
+// releaseVetoed releases the reservations of a proposal that is not endorsed
+// after all
+func (e *Endorser) releaseVetoed(txID string) {
+	if _, err := e.AbortTransaction(txID); err != nil && !errors.Is(err, ErrUnknownTransaction) {
+		logger.Warningf("Failed to release the reservations of tx %s: %s", txID, err)
+	}
+}
@@ -0,0 +1,125 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package endorser
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	cb "github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric/core/endorser/sharding/protos"
+	. "github.com/onsi/gomega"
+)
+
+// stubOracle answers every review with resp or err, after delay, and records
+// the requests
+type stubOracle struct {
+	resp  *protos.ReviewResponse
+	err   error
+	delay time.Duration
+	reqs  []*protos.ReviewRequest
+}
+
+func (o *stubOracle) Review(ctx context.Context, req *protos.ReviewRequest) (*protos.ReviewResponse, error) {
+	o.reqs = append(o.reqs, req)
+	select {
+	case <-time.After(o.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return o.resp, o.err
+}
+
+func TestReviewRequest(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	up := &UnpackedProposal{ChaincodeName: "fabcar", ChannelHeader: &cb.ChannelHeader{ChannelId: "mychannel", TxId: "tx1"}}
+	req := reviewRequest(up, map[string][]byte{
+		"fabcar:car2":   []byte("blue"),
+		"fabcar:car1":   []byte("red"),
+		"fabcar:owner1": nil,
+	}, map[string]bool{"fabcar:car1": true, "fabcar:car2": true})
+	gt.Expect(req.ChannelId).To(Equal("mychannel"))
+	gt.Expect(req.TxId).To(Equal("tx1"))
+	gt.Expect(req.Chaincode).To(Equal("fabcar"))
+	gt.Expect(req.Reads).To(Equal([]string{"fabcar:owner1"}))
+	gt.Expect(req.Writes).To(Equal([]string{"fabcar:car1", "fabcar:car2"}))
+}
+
+func TestReviewProposal(t *testing.T) {
+	deps := &dependencyResolution{hasDependency: true, dependentTxIDs: "tx0,tx1", encodedProofs: "proofs"}
+
+	t.Run("Annotated", func(t *testing.T) {
+		gt := NewGomegaWithT(t)
+		oracle := &stubOracle{resp: &protos.ReviewResponse{Annotations: []*protos.Annotation{
+			{Key: "risk", Value: "low"},
+			{Key: "bad;key", Value: "x"},
+			{Key: "reviewer", Value: "fraud-engine"},
+		}}}
+		e := &Endorser{ConflictOracle: oracle}
+
+		annotations, vetoed, err := e.reviewProposal(context.Background(), &protos.ReviewRequest{TxId: "tx2"}, deps)
+		gt.Expect(err).NotTo(HaveOccurred())
+		gt.Expect(vetoed).To(BeNil())
+		// the annotations that would break the claims are dropped
+		gt.Expect(annotations).To(Equal("risk=low,reviewer=fraud-engine"))
+		gt.Expect(oracle.reqs[0].DependentTxIds).To(Equal([]string{"tx0", "tx1"}))
+		gt.Expect(oracle.reqs[0].Proofs).To(Equal("proofs"))
+	})
+
+	t.Run("Vetoed", func(t *testing.T) {
+		gt := NewGomegaWithT(t)
+		e := &Endorser{ConflictOracle: &stubOracle{resp: &protos.ReviewResponse{Veto: true, Reason: "suspected double spend"}}}
+
+		_, vetoed, err := e.reviewProposal(context.Background(), &protos.ReviewRequest{TxId: "tx2"}, deps)
+		gt.Expect(err).NotTo(HaveOccurred())
+		gt.Expect(vetoed.Response.Status).To(Equal(int32(StatusVetoed)))
+		gt.Expect(vetoed.Response.Message).To(Equal("endorsement vetoed by the conflict oracle: suspected double spend"))
+	})
+
+	t.Run("FailOpen", func(t *testing.T) {
+		gt := NewGomegaWithT(t)
+		e := &Endorser{
+			Config:         EndorserConfig{ConflictOracle: ConflictOracleConfig{Timeout: 20 * time.Millisecond}},
+			ConflictOracle: &stubOracle{resp: &protos.ReviewResponse{Veto: true}, delay: time.Minute},
+		}
+
+		annotations, vetoed, err := e.reviewProposal(context.Background(), &protos.ReviewRequest{TxId: "tx2"}, deps)
+		gt.Expect(err).NotTo(HaveOccurred())
+		gt.Expect(vetoed).To(BeNil())
+		gt.Expect(annotations).To(BeEmpty())
+	})
+
+	t.Run("FailClosed", func(t *testing.T) {
+		gt := NewGomegaWithT(t)
+		e := &Endorser{
+			Config:         EndorserConfig{ConflictOracle: ConflictOracleConfig{FailurePolicy: OracleFailClosed}},
+			ConflictOracle: &stubOracle{err: errors.New("connection refused")},
+		}
+
+		_, _, err := e.reviewProposal(context.Background(), &protos.ReviewRequest{TxId: "tx2"}, deps)
+		gt.Expect(err).To(MatchError("conflict oracle failed to review the proposal: connection refused"))
+	})
+}
+
+func TestReleaseVetoed(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	store := &abortingStore{}
+	e := &Endorser{Config: EndorserConfig{PrepareTimeout: time.Second}, DependencyStore: store}
+	_, err := e.resolveDependencies(context.Background(), "mychannel", "tx1", DefaultLane, store, map[string]map[string][]byte{
+		"fabcar": {"fabcar:car1": []byte("red")},
+	}, nil, nil, true, 0)
+	gt.Expect(err).NotTo(HaveOccurred())
+
+	e.releaseVetoed("tx1")
+	gt.Expect(store.aborted).To(Equal([]string{"fabcar/tx1"}))
+	// a proposal that reserved nothing has nothing to release
+	e.releaseVetoed("tx2")
+}
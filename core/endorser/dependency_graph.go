@@ -0,0 +1,136 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package endorser
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dependencyGraphPruneInterval is how often the graph drops the transactions
+// whose reservations expired
+const dependencyGraphPruneInterval = time.Second
+
+// DependencyCycleError is returned for a proposal whose dependencies would
+// close a cycle among the transactions endorsed by this peer. Within a shard
+// reservations are ordered by the log, but a transaction prepared on several
+// shards may depend on a transaction that depends on it through another.
+type DependencyCycleError struct {
+	TxID string
+	// Cycle lists the transactions on the cycle, starting and ending with TxID
+	Cycle []string
+}
+
+func (e *DependencyCycleError) Error() string {
+	return fmt.Sprintf("tx %s would close the dependency cycle %s", e.TxID, strings.Join(e.Cycle, " -> "))
+}
+
+type dependencyNode struct {
+	deps      []string
+	expiresAt time.Time
+}
+
+// DependencyGraph holds the dependencies of the transactions endorsed by the
+// peer until their reservations expire, to detect cycles among them
+type DependencyGraph struct {
+	mu     sync.Mutex
+	nodes  map[string]dependencyNode
+	pruned time.Time
+}
+
+// NewDependencyGraph returns an empty dependency graph
+func NewDependencyGraph() *DependencyGraph {
+	return &DependencyGraph{nodes: make(map[string]dependencyNode)}
+}
+
+// Add records that txID depends on deps until expiresAt. If one of deps
+// already depends on txID, directly or through other transactions, nothing is
+// recorded and a DependencyCycleError is returned.
+func (g *DependencyGraph) Add(txID string, deps []string, expiresAt, now time.Time) error {
+	var sorted []string
+	for _, dep := range deps {
+		if dep != "" && dep != txID {
+			sorted = append(sorted, dep)
+		}
+	}
+	if len(sorted) == 0 {
+		return nil
+	}
+	sort.Strings(sorted)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if now.Sub(g.pruned) >= dependencyGraphPruneInterval {
+		for id, node := range g.nodes {
+			if !node.expiresAt.After(now) {
+				delete(g.nodes, id)
+			}
+		}
+		g.pruned = now
+	}
+
+	if cycle := g.pathTo(txID, sorted, now); cycle != nil {
+		return &DependencyCycleError{TxID: txID, Cycle: append([]string{txID}, cycle...)}
+	}
+	g.nodes[txID] = dependencyNode{deps: sorted, expiresAt: expiresAt}
+	return nil
+}
+
+// Remove forgets the dependencies of txID, e.g. once it was aborted
+func (g *DependencyGraph) Remove(txID string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.nodes, txID)
+}
+
+// Len returns the number of transactions with recorded dependencies
+func (g *DependencyGraph) Len() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return len(g.nodes)
+}
+
+// pathTo returns the shortest path of dependencies from one of the sources
+// to target, ending with target, or nil if there is none. Transactions whose
+// reservations expired are not followed. The caller must hold the lock.
+func (g *DependencyGraph) pathTo(target string, sources []string, now time.Time) []string {
+	parent := make(map[string]string)
+	queue := make([]string, 0, len(sources))
+	for _, src := range sources {
+		if _, seen := parent[src]; !seen {
+			parent[src] = ""
+			queue = append(queue, src)
+		}
+	}
+
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		if id == target {
+			var path []string
+			for ; id != ""; id = parent[id] {
+				path = append([]string{id}, path...)
+			}
+			return path
+		}
+		node, ok := g.nodes[id]
+		if !ok || !node.expiresAt.After(now) {
+			continue
+		}
+		for _, dep := range node.deps {
+			if _, seen := parent[dep]; !seen {
+				parent[dep] = id
+				queue = append(queue, dep)
+			}
+		}
+	}
+	return nil
+}
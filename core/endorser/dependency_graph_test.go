@@ -0,0 +1,56 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package endorser
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestDependencyGraph(t *testing.T) {
+	gt := NewGomegaWithT(t)
+	now := time.Now()
+	expiresAt := now.Add(time.Minute)
+	g := NewDependencyGraph()
+
+	// independent transactions and self-dependencies are not recorded
+	gt.Expect(g.Add("tx1", []string{""}, expiresAt, now)).To(Succeed())
+	gt.Expect(g.Add("tx1", []string{"tx1"}, expiresAt, now)).To(Succeed())
+	gt.Expect(g.Len()).To(BeZero())
+
+	gt.Expect(g.Add("tx2", []string{"tx1"}, expiresAt, now)).To(Succeed())
+	gt.Expect(g.Add("tx3", []string{"tx2", "tx4"}, expiresAt, now)).To(Succeed())
+	gt.Expect(g.Len()).To(Equal(2))
+
+	// tx1 waiting on tx3 through another shard closes tx1 -> tx3 -> tx2 -> tx1
+	err := g.Add("tx1", []string{"tx3"}, expiresAt, now)
+	var cycleErr *DependencyCycleError
+	gt.Expect(errors.As(err, &cycleErr)).To(BeTrue())
+	gt.Expect(cycleErr.Cycle).To(Equal([]string{"tx1", "tx3", "tx2", "tx1"}))
+	gt.Expect(err).To(MatchError("tx tx1 would close the dependency cycle tx1 -> tx3 -> tx2 -> tx1"))
+	gt.Expect(g.Len()).To(Equal(2))
+
+	// the cycle is broken once a transaction on it is aborted
+	g.Remove("tx2")
+	gt.Expect(g.Add("tx1", []string{"tx3"}, expiresAt, now)).To(Succeed())
+}
+
+func TestDependencyGraphExpiry(t *testing.T) {
+	gt := NewGomegaWithT(t)
+	now := time.Now()
+	g := NewDependencyGraph()
+
+	gt.Expect(g.Add("tx2", []string{"tx1"}, now.Add(time.Second), now)).To(Succeed())
+
+	// expired dependencies no longer close cycles and are pruned
+	later := now.Add(2 * time.Second)
+	gt.Expect(g.Add("tx1", []string{"tx2"}, later.Add(time.Minute), later)).To(Succeed())
+	gt.Expect(g.Len()).To(Equal(1))
+}
@@ -0,0 +1,120 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package endorser
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/endorser/sharding"
+	"github.com/pkg/errors"
+)
+
+// dependencyWaitPoll is how often a held proposal checks the ledger for the
+// transactions it depends on
+const dependencyWaitPoll = 50 * time.Millisecond
+
+// Outcomes of holding a proposal for its dependencies
+const (
+	dependencyWaitCommitted = "committed"
+	dependencyWaitExpired   = "expired"
+	dependencyWaitTimeout   = "timeout"
+	dependencyWaitCanceled  = "canceled"
+)
+
+// dependencyWaitedKey marks the context of a proposal simulated again after
+// it was held, so that it is not held twice
+type dependencyWaitedKey struct{}
+
+// holdsForDependencies reports whether the proposal is to be held until the
+// transactions it depends on commit, rather than endorsed with dependencies
+// that will likely make it fail MVCC validation
+func (e *Endorser) holdsForDependencies(ctx context.Context, deps *dependencyResolution) bool {
+	if e.Config.DependencyWait <= 0 || !deps.hasDependency || deps.dependentTxIDs == "" || deps.speculative || deps.coordinated {
+		return false
+	}
+	waited, _ := ctx.Value(dependencyWaitedKey{}).(bool)
+	return !waited
+}
+
+// holdForDependencies releases the reservations of the proposal and waits up
+// to DependencyWait for the transactions it depends on to commit or expire.
+// The proposal is then to be simulated again on the new state with the
+// returned context, which keeps it from being held again. It fails when the
+// reservations could not be released or the client gave up meanwhile.
+func (e *Endorser) holdForDependencies(ctx context.Context, up *UnpackedProposal, deps *dependencyResolution) (context.Context, error) {
+	// The shards would answer the simulation to come with the same proof
+	// unless the reservations are released first
+	if _, err := e.AbortTransaction(up.TxID()); err != nil && !errors.Is(err, ErrUnknownTransaction) {
+		return ctx, errors.WithMessage(err, "failed to release the reservations of the held proposal")
+	}
+
+	dependencies := strings.Split(deps.dependentTxIDs, ",")
+	logger.Debugf("Holding tx %s for up to %s until %v commit", up.TxID(), e.Config.DependencyWait, dependencies)
+	start := time.Now()
+	outcome := e.awaitDependencies(ctx, up.ChannelID(), dependencies)
+	e.Metrics.countDependencyWait(up.ChannelID(), up.ChaincodeName, outcome)
+	logger.Debugf("Held tx %s for %s until its dependencies were %s", up.TxID(), time.Since(start), outcome)
+	if outcome == dependencyWaitCanceled {
+		return ctx, errors.WithMessage(ctx.Err(), "proposal canceled while held for its dependencies")
+	}
+	return context.WithValue(ctx, dependencyWaitedKey{}, true), nil
+}
+
+// awaitDependencies waits for every transaction to be committed to the ledger
+// of the channel, or for the reservations of one to expire on a shard of this
+// peer, and returns the outcome
+func (e *Endorser) awaitDependencies(ctx context.Context, channel string, txIDs []string) string {
+	var expiries <-chan sharding.ExpiredEndorsement
+	if e.ShardManager != nil {
+		sub, cancel, err := e.SubscribeExpiries()
+		if err == nil {
+			defer cancel()
+			expiries = sub
+		}
+	}
+
+	deadline := time.NewTimer(e.Config.DependencyWait)
+	defer deadline.Stop()
+	ticker := time.NewTicker(dependencyWaitPoll)
+	defer ticker.Stop()
+
+	pending := make(map[string]bool, len(txIDs))
+	for _, txID := range txIDs {
+		pending[txID] = true
+	}
+	expiredAny := false
+	for {
+		for txID := range pending {
+			if _, err := e.Support.GetTransactionByID(channel, txID); err == nil {
+				delete(pending, txID)
+			}
+		}
+		switch {
+		case len(pending) == 0 && expiredAny:
+			return dependencyWaitExpired
+		case len(pending) == 0:
+			return dependencyWaitCommitted
+		}
+
+		select {
+		case <-ticker.C:
+		case expired := <-expiries:
+			// The dependency will not commit with the proofs it was
+			// endorsed with
+			if pending[expired.TxID] {
+				delete(pending, expired.TxID)
+				expiredAny = true
+			}
+		case <-deadline.C:
+			return dependencyWaitTimeout
+		case <-ctx.Done():
+			return dependencyWaitCanceled
+		}
+	}
+}
@@ -0,0 +1,112 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package endorser
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	cb "github.com/hyperledger/fabric-protos-go/common"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	. "github.com/onsi/gomega"
+)
+
+// committedTxs serves the transactions committed to the ledger; its other
+// methods are not implemented
+type committedTxs struct {
+	Support
+	mu        sync.Mutex
+	committed map[string]bool
+}
+
+func (c *committedTxs) GetTransactionByID(chid, txID string) (*pb.ProcessedTransaction, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.committed[txID] {
+		return nil, errors.New("not found")
+	}
+	return &pb.ProcessedTransaction{}, nil
+}
+
+func (c *committedTxs) commit(txID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.committed[txID] = true
+}
+
+func TestHoldsForDependencies(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	e := &Endorser{}
+	deps := &dependencyResolution{hasDependency: true, dependentTxIDs: "tx1"}
+	gt.Expect(e.holdsForDependencies(context.Background(), deps)).To(BeFalse())
+
+	e.Config.DependencyWait = time.Second
+	gt.Expect(e.holdsForDependencies(context.Background(), deps)).To(BeTrue())
+	gt.Expect(e.holdsForDependencies(context.WithValue(context.Background(), dependencyWaitedKey{}, true), deps)).To(BeFalse())
+
+	gt.Expect(e.holdsForDependencies(context.Background(), &dependencyResolution{})).To(BeFalse())
+	gt.Expect(e.holdsForDependencies(context.Background(), &dependencyResolution{hasDependency: true, dependentTxIDs: "tx1", speculative: true})).To(BeFalse())
+	gt.Expect(e.holdsForDependencies(context.Background(), &dependencyResolution{hasDependency: true, dependentTxIDs: "tx1", coordinated: true})).To(BeFalse())
+}
+
+func TestAwaitDependencies(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	ledger := &committedTxs{committed: map[string]bool{"tx1": true}}
+	e := &Endorser{Support: ledger, Config: EndorserConfig{DependencyWait: 5 * time.Second}}
+
+	gt.Expect(e.awaitDependencies(context.Background(), "mychannel", []string{"tx1"})).To(Equal(dependencyWaitCommitted))
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		ledger.commit("tx2")
+	}()
+	gt.Expect(e.awaitDependencies(context.Background(), "mychannel", []string{"tx1", "tx2"})).To(Equal(dependencyWaitCommitted))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	gt.Expect(e.awaitDependencies(ctx, "mychannel", []string{"tx3"})).To(Equal(dependencyWaitCanceled))
+
+	e.Config.DependencyWait = 100 * time.Millisecond
+	gt.Expect(e.awaitDependencies(context.Background(), "mychannel", []string{"tx3"})).To(Equal(dependencyWaitTimeout))
+}
+
+func TestHoldForDependencies(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	store := &abortingStore{}
+	e := &Endorser{
+		Support:         &committedTxs{committed: map[string]bool{"tx1": true}},
+		Config:          EndorserConfig{DependencyWait: time.Second, PrepareTimeout: time.Second},
+		DependencyStore: store,
+	}
+	up := &UnpackedProposal{ChaincodeName: "fabcar", ChannelHeader: &cb.ChannelHeader{ChannelId: "mychannel", TxId: "tx2"}}
+	deps := &dependencyResolution{hasDependency: true, dependentTxIDs: "tx1"}
+
+	// the reservations of the proposal are released before it is held
+	_, err := e.resolveDependencies(context.Background(), "mychannel", "tx2", DefaultLane, store, map[string]map[string][]byte{
+		"fabcar": {"fabcar:car1": []byte("red")},
+	}, nil, nil, true, 0)
+	gt.Expect(err).NotTo(HaveOccurred())
+	ctx, err := e.holdForDependencies(context.Background(), up, deps)
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(store.aborted).To(Equal([]string{"fabcar/tx2"}))
+	gt.Expect(e.holdsForDependencies(ctx, deps)).To(BeFalse())
+
+	// a proposal that reserved nothing is held all the same
+	_, err = e.holdForDependencies(context.Background(), up, deps)
+	gt.Expect(err).NotTo(HaveOccurred())
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = e.holdForDependencies(canceled, up, &dependencyResolution{hasDependency: true, dependentTxIDs: "tx3"})
+	gt.Expect(err).To(MatchError("proposal canceled while held for its dependencies: context canceled"))
+}
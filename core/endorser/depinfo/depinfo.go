@@ -0,0 +1,181 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package depinfo reads the dependency information the endorser appends to
+// the message of a chaincode response, so that clients, such as fabric-sdk-go
+// applications and the benchmark client, need not parse it themselves.
+//
+// The endorser ends the message with
+//
+//	DependencyInfo:HasDependency=<bool>[,MissingShards=...][,DependencyChain=...]
+//	[,ConflictTypes=...][,SettleEstimate=...][,Speculative=true],DependentTxID=<txs>
+//	[,ShardProofs=<proofs>]
+//
+// where the lists of transactions and shards are separated by ';', except
+// for DependentTxID whose transactions are separated by ','.
+package depinfo
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/hyperledger/fabric/core/endorser/sharding"
+	"github.com/hyperledger/fabric/protoutil"
+	"github.com/pkg/errors"
+)
+
+const (
+	prefix           = "DependencyInfo:"
+	dependentTxIDKey = ",DependentTxID="
+	shardProofsKey   = ",ShardProofs="
+)
+
+// DependencyInfo is the dependency information of an endorsement
+type DependencyInfo struct {
+	// HasDependency is set when the transaction conflicts with one still
+	// pending on a shard
+	HasDependency bool
+	// DependentTxIDs lists the transactions the transaction depends on
+	DependentTxIDs []string
+	// MissingShards lists the shards that gave no proof, leaving the
+	// endorsement without their dependencies
+	MissingShards []string
+	// DependencyChain lists the ancestors of the transaction across shards
+	DependencyChain []string
+	// ConflictTypes gives the types of the conflicts with each transaction
+	// of DependentTxIDs
+	ConflictTypes map[string][]sharding.ConflictType
+	// SettleEstimate is how long the dependencies are expected to take to
+	// commit, or zero when the endorser made no estimate
+	SettleEstimate time.Duration
+	// Speculative is set when the endorsement was returned before the shards
+	// answered
+	Speculative bool
+	// Proofs are the prepare proofs of the shards
+	Proofs []*sharding.PrepareProof
+}
+
+// CommitIndexes returns the index at which each shard committed the prepare
+// of the transaction, by shard
+func (d *DependencyInfo) CommitIndexes() map[string]uint64 {
+	indexes := make(map[string]uint64, len(d.Proofs))
+	for _, proof := range d.Proofs {
+		indexes[proof.ShardID] = proof.CommitIndex
+	}
+	return indexes
+}
+
+// FromProposalResponse returns the dependency information of the chaincode
+// response of a proposal response, or nil when it carries none
+func FromProposalResponse(resp *pb.ProposalResponse) (*DependencyInfo, error) {
+	if resp == nil {
+		return nil, errors.New("nil proposal response")
+	}
+	if resp.Response != nil && strings.Contains(resp.Response.Message, prefix) {
+		return Parse(resp.Response.Message)
+	}
+	if len(resp.Payload) == 0 {
+		return nil, nil
+	}
+
+	prp, err := protoutil.UnmarshalProposalResponsePayload(resp.Payload)
+	if err != nil {
+		return nil, err
+	}
+	action, err := protoutil.UnmarshalChaincodeAction(prp.Extension)
+	if err != nil {
+		return nil, err
+	}
+	if action.Response == nil {
+		return nil, nil
+	}
+	return Parse(action.Response.Message)
+}
+
+// Parse returns the dependency information at the end of the message of a
+// chaincode response, or nil when it carries none
+func Parse(message string) (*DependencyInfo, error) {
+	idx := strings.LastIndex(message, prefix)
+	if idx < 0 {
+		return nil, nil
+	}
+	claims := message[idx+len(prefix):]
+
+	info := &DependencyInfo{}
+	if i := strings.Index(claims, shardProofsKey); i >= 0 {
+		proofs, err := sharding.DecodeProofs(claims[i+len(shardProofsKey):])
+		if err != nil {
+			return nil, errors.WithMessage(err, "malformed dependency info")
+		}
+		info.Proofs = proofs
+		claims = claims[:i]
+	}
+
+	// The transactions of DependentTxID are separated by ',' like the claims,
+	// so the claim comes last
+	i := strings.Index(claims, dependentTxIDKey)
+	if i < 0 {
+		return nil, errors.Errorf("malformed dependency info %q: no DependentTxID", claims)
+	}
+	info.DependentTxIDs = split(claims[i+len(dependentTxIDKey):], ",")
+	claims = claims[:i]
+
+	for _, claim := range strings.Split(claims, ",") {
+		kv := strings.SplitN(claim, "=", 2)
+		if len(kv) != 2 {
+			return nil, errors.Errorf("malformed dependency claim %q", claim)
+		}
+		var err error
+		switch key, value := kv[0], kv[1]; key {
+		case "HasDependency":
+			info.HasDependency, err = strconv.ParseBool(value)
+		case "MissingShards":
+			info.MissingShards = split(value, ";")
+		case "DependencyChain":
+			info.DependencyChain = split(value, ";")
+		case "ConflictTypes":
+			info.ConflictTypes, err = parseConflictTypes(value)
+		case "SettleEstimate":
+			info.SettleEstimate, err = time.ParseDuration(value)
+		case "Speculative":
+			info.Speculative, err = strconv.ParseBool(value)
+		}
+		// Claims added by later endorsers are ignored
+		if err != nil {
+			return nil, errors.WithMessagef(err, "malformed dependency claim %q", claim)
+		}
+	}
+	return info, nil
+}
+
+// parseConflictTypes reads the conflict types rendered as tx:type+type,
+// separated by ';'
+func parseConflictTypes(value string) (map[string][]sharding.ConflictType, error) {
+	types := make(map[string][]sharding.ConflictType)
+	for _, entry := range split(value, ";") {
+		i := strings.LastIndex(entry, ":")
+		if i < 0 {
+			return nil, errors.Errorf("no conflict types for %s", entry)
+		}
+		for _, name := range split(entry[i+1:], "+") {
+			types[entry[:i]] = append(types[entry[:i]], sharding.ConflictType(name))
+		}
+	}
+	return types, nil
+}
+
+// split splits s around sep, dropping the empty elements
+func split(s, sep string) []string {
+	var out []string
+	for _, elem := range strings.Split(s, sep) {
+		if elem != "" {
+			out = append(out, elem)
+		}
+	}
+	return out
+}
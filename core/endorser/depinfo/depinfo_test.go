@@ -0,0 +1,85 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package depinfo
+
+import (
+	"testing"
+	"time"
+
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/hyperledger/fabric/core/endorser/sharding"
+	"github.com/hyperledger/fabric/protoutil"
+	. "github.com/onsi/gomega"
+)
+
+func TestParse(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	proofs := []*sharding.PrepareProof{
+		{TxID: "tx3", ShardID: "mychannel/fabcar", CommitIndex: 7, HasDependency: true, DependentTxID: "tx1,tx2"},
+		{TxID: "tx3", ShardID: "mychannel/marbles", CommitIndex: 4},
+	}
+	encoded, err := sharding.EncodeProofs(proofs)
+	gt.Expect(err).NotTo(HaveOccurred())
+
+	message := "OK; OracleAnnotations:price; DependencyInfo:HasDependency=true,MissingShards=mychannel/cars;mychannel/owners," +
+		"DependencyChain=tx0;tx1;tx2,ConflictTypes=tx1:WW;tx2:RW+WAR,SettleEstimate=1.5s,Speculative=true," +
+		"DependentTxID=tx1,tx2,ShardProofs=" + encoded
+	info, err := Parse(message)
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(info.HasDependency).To(BeTrue())
+	gt.Expect(info.DependentTxIDs).To(Equal([]string{"tx1", "tx2"}))
+	gt.Expect(info.MissingShards).To(Equal([]string{"mychannel/cars", "mychannel/owners"}))
+	gt.Expect(info.DependencyChain).To(Equal([]string{"tx0", "tx1", "tx2"}))
+	gt.Expect(info.ConflictTypes).To(Equal(map[string][]sharding.ConflictType{
+		"tx1": {sharding.ConflictWriteWrite},
+		"tx2": {sharding.ConflictReadWrite, sharding.ConflictWriteAfterRead},
+	}))
+	gt.Expect(info.SettleEstimate).To(Equal(1500 * time.Millisecond))
+	gt.Expect(info.Speculative).To(BeTrue())
+	gt.Expect(info.Proofs).To(HaveLen(2))
+	gt.Expect(info.CommitIndexes()).To(Equal(map[string]uint64{"mychannel/fabcar": 7, "mychannel/marbles": 4}))
+
+	info, err = Parse("; DependencyInfo:HasDependency=false,DependentTxID=")
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(info).To(Equal(&DependencyInfo{}))
+
+	info, err = Parse("OK")
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(info).To(BeNil())
+
+	_, err = Parse("DependencyInfo:HasDependency=true")
+	gt.Expect(err).To(MatchError(`malformed dependency info "HasDependency=true": no DependentTxID`))
+	_, err = Parse("DependencyInfo:HasDependency=maybe,DependentTxID=tx1")
+	gt.Expect(err).To(MatchError(ContainSubstring(`malformed dependency claim "HasDependency=maybe"`)))
+	_, err = Parse("DependencyInfo:HasDependency=true,DependentTxID=tx1,ShardProofs=!")
+	gt.Expect(err).To(MatchError(ContainSubstring("malformed dependency info: failed to decode proofs")))
+}
+
+func TestFromProposalResponse(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	res := &pb.Response{Status: 200, Message: "; DependencyInfo:HasDependency=true,DependentTxID=tx1"}
+	info, err := FromProposalResponse(&pb.ProposalResponse{Response: res})
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(info).To(Equal(&DependencyInfo{HasDependency: true, DependentTxIDs: []string{"tx1"}}))
+
+	// the chaincode response of the payload is read when the response
+	// carries no dependency info
+	prpBytes, err := protoutil.GetBytesProposalResponsePayload([]byte("hash"), res, nil, nil, &pb.ChaincodeID{Name: "fabcar"})
+	gt.Expect(err).NotTo(HaveOccurred())
+	info, err = FromProposalResponse(&pb.ProposalResponse{Payload: prpBytes})
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(info.DependentTxIDs).To(Equal([]string{"tx1"}))
+
+	info, err = FromProposalResponse(&pb.ProposalResponse{Response: &pb.Response{Status: 500, Message: "access denied"}})
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(info).To(BeNil())
+
+	_, err = FromProposalResponse(nil)
+	gt.Expect(err).To(MatchError("nil proposal response"))
+}
@@ -0,0 +1,184 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package endorser
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	endorsementJournalName = "endorsements.log"
+
+	// minJournalCompaction is the number of records the journal holds at
+	// least before it drops those of expired transactions. It is compacted
+	// again once it doubled in size since.
+	minJournalCompaction = 1024
+)
+
+// journalRecord is a line of the endorsement journal
+type journalRecord struct {
+	TxID    string
+	Expires time.Time
+}
+
+// EndorsementJournal records on disk the transactions whose endorsement was
+// returned to the client while their reservations are held, so that after a
+// restart the endorser tells them apart from the prepares it was still making
+// when it stopped. The client of an endorsed transaction may still submit it,
+// so its reservations must not be released on its behalf.
+type EndorsementJournal struct {
+	dir string
+
+	mu        sync.Mutex
+	returned  map[string]time.Time
+	records   int
+	compactAt int
+	log       *os.File
+}
+
+// NewEndorsementJournal opens the journal kept in dir, with the transactions
+// recorded there before a restart whose reservations have not expired
+func NewEndorsementJournal(dir string) (*EndorsementJournal, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, errors.Wrapf(err, "failed to create endorsement journal directory %s", dir)
+	}
+	j := &EndorsementJournal{dir: dir, returned: make(map[string]time.Time)}
+	path := filepath.Join(dir, endorsementJournalName)
+
+	f, err := os.Open(path)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return nil, errors.Wrapf(err, "failed to open endorsement journal %s", path)
+	default:
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			record := &journalRecord{}
+			if err := json.Unmarshal(scanner.Bytes(), record); err != nil {
+				// A crash may leave the last record half written
+				logger.Warningf("Ignoring the corrupt record in endorsement journal %s: %s", path, err)
+				continue
+			}
+			j.returned[record.TxID] = record.Expires
+		}
+		err := scanner.Err()
+		f.Close()
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read endorsement journal %s", path)
+		}
+	}
+
+	if err := j.compactLocked(); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// Record durably notes that the endorsement of the transaction, whose
+// reservations expire at expires, is about to be returned to the client
+func (j *EndorsementJournal) Record(txID string, expires time.Time) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if recorded, ok := j.returned[txID]; ok && !expires.After(recorded) {
+		return nil
+	}
+	if err := j.appendLocked(&journalRecord{TxID: txID, Expires: expires}); err != nil {
+		return err
+	}
+	j.returned[txID] = expires
+
+	if j.records >= j.compactAt {
+		return j.compactLocked()
+	}
+	return nil
+}
+
+// Returned reports whether the endorsement of the transaction was returned
+// and its reservations have not expired
+func (j *EndorsementJournal) Returned(txID string) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	expires, ok := j.returned[txID]
+	return ok && time.Now().Before(expires)
+}
+
+// Close closes the journal file
+func (j *EndorsementJournal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.log.Close()
+}
+
+// compactLocked rewrites the journal with the transactions whose reservations
+// have not expired only. It must be called with mu held, or before the
+// journal is shared.
+func (j *EndorsementJournal) compactLocked() error {
+	now := time.Now()
+	txIDs := make([]string, 0, len(j.returned))
+	for txID, expires := range j.returned {
+		if now.After(expires) {
+			delete(j.returned, txID)
+			continue
+		}
+		txIDs = append(txIDs, txID)
+	}
+	sort.Strings(txIDs)
+
+	path := filepath.Join(j.dir, endorsementJournalName)
+	tmp := path + ".tmp"
+	compacted, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create endorsement journal %s", tmp)
+	}
+	previous := j.log
+	j.log, j.records = compacted, 0
+	for _, txID := range txIDs {
+		if err := j.appendLocked(&journalRecord{TxID: txID, Expires: j.returned[txID]}); err != nil {
+			compacted.Close()
+			j.log = previous
+			return err
+		}
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		compacted.Close()
+		j.log = previous
+		return errors.Wrapf(err, "failed to compact endorsement journal %s", path)
+	}
+	if previous != nil {
+		previous.Close()
+	}
+	j.compactAt = 2 * j.records
+	if j.compactAt < minJournalCompaction {
+		j.compactAt = minJournalCompaction
+	}
+	return nil
+}
+
+// appendLocked writes the record to the journal and syncs it. It must be
+// called with mu held.
+func (j *EndorsementJournal) appendLocked(record *journalRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return errors.Wrapf(err, "failed to marshal the journal record of tx %s", record.TxID)
+	}
+	if _, err := j.log.Write(append(data, '\n')); err != nil {
+		return errors.Wrapf(err, "failed to write the journal record of tx %s", record.TxID)
+	}
+	if err := j.log.Sync(); err != nil {
+		return errors.Wrapf(err, "failed to sync the journal record of tx %s", record.TxID)
+	}
+	j.records++
+	return nil
+}
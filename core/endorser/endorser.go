@@ -30,6 +30,7 @@ package endorser
 import (
 	"context"
 	"fmt"
+	"math"
 	"os"
 	"sort"
 	"strconv"
@@ -45,6 +46,7 @@ import (
 	"github.com/hyperledger/fabric/core/chaincode/lifecycle"
 	"github.com/hyperledger/fabric/core/common/ccprovider"
 	"github.com/hyperledger/fabric/core/endorser/sharding"
+	"github.com/hyperledger/fabric/core/endorser/sharding/protos"
 	"github.com/hyperledger/fabric/core/ledger"
 	"github.com/hyperledger/fabric/internal/pkg/identity"
 	"github.com/hyperledger/fabric/msp"
@@ -144,12 +146,158 @@ const (
 	LeaderEndorser
 )
 
+// String returns the role as named in core.yaml
+func (r EndorserRole) String() string {
+	if r == LeaderEndorser {
+		return "leader"
+	}
+	return "normal"
+}
+
+// ParseEndorserRole parses the role of an endorser as configured in core.yaml
+func ParseEndorserRole(role string) (EndorserRole, error) {
+	switch strings.ToLower(strings.TrimSpace(role)) {
+	case "", "normal":
+		return NormalEndorser, nil
+	case "leader":
+		return LeaderEndorser, nil
+	default:
+		return NormalEndorser, errors.Errorf("unknown endorser role %q, expected normal or leader", role)
+	}
+}
+
 // EndorserConfig contains configuration for the endorser
 type EndorserConfig struct {
 	Role           EndorserRole
 	LeaderEndorser string // Address of the leader endorser
 	EndorserID     string // Unique ID of this endorser
 	ChannelID      string // Channel ID this endorser belongs to
+
+	// LeaderElectionShard names the shard whose Raft leader is the leader
+	// endorser. When it is set, Role and LeaderEndorser follow the election
+	// rather than being fixed.
+	LeaderElectionShard string
+	// LeaderCandidates lists, in order of succession, the addresses of the
+	// endorsers that take over from a leader endorser failing
+	// LeaderFailoverThreshold connectivity checks in a row. There is no
+	// failover when either is unset.
+	LeaderCandidates        []string
+	LeaderFailoverThreshold int
+	// Address is the address of this endorser, under which it may appear
+	// in LeaderCandidates
+	Address string
+
+	// ShardingEnabled resolves dependencies through the shards. Setting
+	// FABRIC_SHARDING_ENABLED to true enables it as well.
+	ShardingEnabled bool
+	// PrepareTimeout bounds the gathering of the proofs of a proposal.
+	// Defaults to DefaultPrepareTimeout.
+	PrepareTimeout time.Duration
+	// PrepareRetry retries the prepares that exceed the PrepareTimeout
+	PrepareRetry PrepareRetryConfig
+	// PrepareQuorum is the fraction of the shards touched by a proposal
+	// whose proofs suffice to endorse it, as long as no shard rejected it.
+	// The shards that failed to answer are listed in the dependency info.
+	// Every shard must answer when it is 0 or 1.
+	PrepareQuorum float64
+	// ExpiryDuration is how long the shards keep the reservations of a
+	// prepared transaction. Defaults to sharding.DefaultExpiryDuration.
+	ExpiryDuration time.Duration
+	// ChaincodeExpiry overrides ExpiryDuration for the transactions of some
+	// chaincodes. It is keyed by channel/chaincode, by chaincode on every
+	// channel, or by channel/* for every chaincode of the channel, in that
+	// order of precedence.
+	ChaincodeExpiry map[string]time.Duration
+	// ShardingPolicy narrows sharding down to some channels and chaincodes
+	// when it is enabled
+	ShardingPolicy ShardingPolicy
+	// SpeculativeEndorsement endorses proposals without waiting for the
+	// shards. The response claims no dependency and is marked
+	// Speculative=true, while the proofs are gathered in the background and
+	// served by the admin API.
+	SpeculativeEndorsement bool
+	// ResponseCache reuses the simulation of read-only invocations
+	ResponseCache ResponseCacheConfig
+	// Idempotency returns the endorsement of a proposal to the retries
+	// carrying its idempotency key
+	Idempotency IdempotencyConfig
+	// PrepareLanes caps the prepares in flight and prioritizes the waiting
+	// ones
+	PrepareLanes PrepareLanesConfig
+	// PrepareBatch gathers the prepares of concurrent proposals on a shard
+	// into one submission
+	PrepareBatch PrepareBatchConfig
+	// RateLimit limits the rate of the proposals of each client
+	RateLimit RateLimitConfig
+	// TwoPhaseCommit coordinates the proposals that write to several shards
+	// with two-phase commit, so that the shards record whether each of them
+	// is committed or aborted even when this peer crashes in between
+	TwoPhaseCommit bool
+	// MVCCPreCheck refuses with StatusStaleRead the proposals whose
+	// simulation read public keys that blocks committed since changed, as
+	// they would fail MVCC validation
+	MVCCPreCheck bool
+	// DependencyStores selects, by channel, the dependency store that
+	// prepares the proposals of the channel in place of the default one
+	DependencyStores map[string]sharding.DependencyStoreConfig
+	// DependencyWait holds a proposal that depends on transactions not yet
+	// committed for up to this long, until they commit or expire, and then
+	// simulates it again. Proposals are not held when it is 0.
+	DependencyWait time.Duration
+	// ShardLeaders gives, by chaincode, the address of the leader endorser
+	// of the chaincode in place of LeaderEndorser. The other endorsers
+	// refuse its proposals with StatusMisdirected.
+	ShardLeaders map[string]string
+	// ConflictOracle configures the external service reviewing the
+	// dependencies of the proposals before they are endorsed
+	ConflictOracle ConflictOracleConfig
+	// RecoveryPolicy decides what becomes of the prepares this endorser left
+	// in flight when it stopped: RecoveryAbort or RecoveryResubscribe. They
+	// are left to expire when it is empty.
+	RecoveryPolicy string
+	// MaxProposalBatch caps the proposals of a batch submitted to the
+	// BatchEndorser API. Defaults to DefaultMaxProposalBatch.
+	MaxProposalBatch int
+}
+
+// shardingEnabled reports whether proposals for the chaincode on the channel
+// are prepared on the shards
+func (c EndorserConfig) shardingEnabled(channelID, chaincode string) bool {
+	return c.shardingOn() && c.ShardingPolicy.Applies(channelID, chaincode)
+}
+
+// shardingOn reports whether sharding is enabled for any proposal
+func (c EndorserConfig) shardingOn() bool {
+	return c.ShardingEnabled || os.Getenv("FABRIC_SHARDING_ENABLED") == "true"
+}
+
+// prepareTimeout returns the configured PrepareTimeout or its default
+func (c EndorserConfig) prepareTimeout() time.Duration {
+	if c.PrepareTimeout > 0 {
+		return c.PrepareTimeout
+	}
+	return DefaultPrepareTimeout
+}
+
+// prepareQuorum returns the number of the given shards that must return a
+// proof for a proposal to be endorsed
+func (c EndorserConfig) prepareQuorum(shards int) int {
+	if c.PrepareQuorum <= 0 || c.PrepareQuorum >= 1 {
+		return shards
+	}
+	required := int(math.Ceil(c.PrepareQuorum * float64(shards)))
+	if required < 1 {
+		required = 1
+	}
+	return required
+}
+
+// expiryDuration returns the configured ExpiryDuration or its default
+func (c EndorserConfig) expiryDuration() time.Duration {
+	if c.ExpiryDuration > 0 {
+		return c.ExpiryDuration
+	}
+	return sharding.DefaultExpiryDuration
 }
 
 // Endorser provides the Endorser service ProcessProposal
@@ -162,6 +310,8 @@ type Endorser struct {
 	Metrics                *Metrics
 	Config                 EndorserConfig
 	ShardManager           *sharding.ShardManager
+	DependencyStore        sharding.DependencyStore
+	ProofVerifier          *sharding.ProofVerifier
 	stopChan               chan struct{}
 	wg                     sync.WaitGroup
 
@@ -170,12 +320,75 @@ type Endorser struct {
 	LastLeaderCheck      time.Time
 	LeaderCheckError     error
 	LeaderCircuitBreaker *CircuitBreaker
+	ShardCircuitBreakers *ShardCircuitBreakers
+	// DependencyGraph detects dependency cycles among the proposals endorsed
+	// by this peer; cycles are not checked when it is nil
+	DependencyGraph *DependencyGraph
+	// LeaderElection elects the leader endorser when the configuration
+	// names a LeaderElectionShard
+	LeaderElection *LeaderElection
+	// Coordinator runs two-phase commit across the shards of the proposals
+	// that write to several of them; they are prepared independently when
+	// it is nil
+	Coordinator *sharding.Coordinator
+	// Journal records the transactions whose endorsement was returned while
+	// they hold reservations, which RecoverPrepares leaves alone; prepares
+	// cannot be recovered when it is nil
+	Journal *EndorsementJournal
+	// ChannelDependencyStores override DependencyStore for the listed
+	// channels. A nil store selects the embedded Raft shards.
+	ChannelDependencyStores map[string]sharding.DependencyStore
+	// ConflictOracle reviews the dependencies of the proposals prepared on
+	// the shards before they are endorsed; they are not reviewed when it is
+	// nil
+	ConflictOracle ConflictOracle
+	// checkedLeader is the leader whose connectivity was checked last
+	checkedLeader string
+	// failover holds the leader taken over from the configured one
+	failover leaderFailover
+	// speculative holds the proofs gathered after speculative endorsements
+	speculative speculativeProofs
+	// responses caches the simulations of read-only invocations
+	responses responseCache
+	// idempotent holds the endorsements of the proposals with an
+	// idempotency key
+	idempotent idempotentResponses
+	// settle times the blocks and prepares to estimate when dependencies
+	// commit
+	settle settleEstimator
+	// limiter holds the token buckets of the rate limited clients
+	limiter rateLimiter
+	// lanes holds the prepares waiting for a slot
+	lanes prepareLanes
+	// batches holds the prepares waiting for their batch to be submitted
+	batches prepareBatches
+	// endorsed holds the shards each transaction reserved keys on, until
+	// it may no longer be aborted
+	endorsed endorsedShards
+	// expiries streams the endorsements expired on the local shards
+	expiries expiryFeed
+	// sizedShards holds the shards whose dependency map size was reported
+	// by the last health check
+	sizedShards map[string]bool
+	// ProcessingTxs coalesces the retries of a proposal that is still being
+	// processed with its first attempt
+	ProcessingTxs ProcessingTxs
 }
 
 // NewEndorser creates a new instance of Endorser with the given dependencies
 func NewEndorser(channelFetcher ChannelFetcher, localMSP msp.IdentityDeserializer,
 	pvtDataDistributor PrivateDataDistributor, support Support,
 	pvtRWSetAssembler PvtRWSetAssembler, metrics *Metrics, config EndorserConfig) *Endorser {
+	proofVerifier, err := sharding.NewProofVerifierFromEnv()
+	if err != nil {
+		logger.Errorf("Prepare proofs will not be checked against replica keys: %s", err)
+	}
+	topology, err := sharding.LoadShardTopologyFromEnv()
+	if err != nil {
+		logger.Errorf("Falling back to the default shard topology: %s", err)
+		topology = sharding.DefaultShardTopology()
+	}
+
 	endorser := &Endorser{
 		ChannelFetcher:         channelFetcher,
 		LocalMSP:               localMSP,
@@ -184,14 +397,28 @@ func NewEndorser(channelFetcher ChannelFetcher, localMSP msp.IdentityDeserialize
 		PvtRWSetAssembler:      pvtRWSetAssembler,
 		Metrics:                metrics,
 		Config:                 config,
-		ShardManager:           sharding.NewShardManager(nil, metrics),
-		stopChan:               make(chan struct{}),
+		ShardManager: sharding.NewShardManagerWithOptions(nil, topology, metrics, sharding.ShardManagerOptions{
+			DependencyTTL: config.ExpiryDuration,
+		}),
+		ProofVerifier: proofVerifier,
+		stopChan:      make(chan struct{}),
 		HealthStatus: &HealthStatus{
 			IsHealthy:     true,
 			LastCheckTime: time.Now(),
 			Details:       make(map[string]interface{}),
 		},
 		LeaderCircuitBreaker: NewCircuitBreaker(DefaultCircuitBreakerConfig(), metrics),
+		ShardCircuitBreakers: NewShardCircuitBreakersFromEnv(metrics),
+		DependencyGraph:      NewDependencyGraph(),
+	}
+
+	if config.LeaderElectionShard != "" {
+		election, err := NewLeaderElection(endorser.ShardManager, config.LeaderElectionShard)
+		if err != nil {
+			logger.Errorf("Following the configured leader endorser %q: %s", config.LeaderEndorser, err)
+		} else {
+			endorser.LeaderElection = election
+		}
 	}
 
 	// Start health check goroutine
@@ -208,6 +435,9 @@ func NewEndorser(channelFetcher ChannelFetcher, localMSP msp.IdentityDeserialize
 func (e *Endorser) Shutdown() {
 	close(e.stopChan)
 	e.wg.Wait()
+	if e.Coordinator != nil {
+		e.Coordinator.Stop()
+	}
 	if e.ShardManager != nil {
 		e.ShardManager.Shutdown()
 	}
@@ -226,6 +456,7 @@ func (e *Endorser) ProcessProposal(ctx context.Context, signedProp *pb.SignedPro
 	logger.Debug("request from", addr)
 
 	success := false
+	hasDependency := false
 
 	up, err := UnpackProposal(signedProp)
 	if err != nil {
@@ -252,20 +483,35 @@ func (e *Endorser) ProcessProposal(ctx context.Context, signedProp *pb.SignedPro
 		return &pb.ProposalResponse{Response: &pb.Response{Status: 500, Message: err.Error()}}, err
 	}
 
+	// The identity of the creator was verified by preProcess
+	if allowed, mspID := e.allowProposal(up); !allowed {
+		e.Metrics.ProposalsRateLimited.With("channel", up.ChannelHeader.ChannelId, "mspid", mspID).Add(1)
+		logger.Debugw("Refusing proposal over the client's rate", "txID", up.ChannelHeader.TxId, "mspid", mspID)
+		return &pb.ProposalResponse{Response: &pb.Response{Status: StatusTooManyRequests, Message: fmt.Sprintf("rate limit exceeded for client of MSP %s", mspID)}}, nil
+	}
+
+	if owner, ok := e.misdirected(up); ok {
+		e.Metrics.countMisdirectedProposal(up.ChannelID(), up.ChaincodeName)
+		logger.Debugw("Refusing proposal led by another endorser", "txID", up.TxID(), "chaincode", up.ChaincodeName, "leader", owner)
+		return &pb.ProposalResponse{Response: &pb.Response{Status: StatusMisdirected, Message: fmt.Sprintf("chaincode %s is led by endorser %s; ShardOwner=%s", up.ChaincodeName, owner, owner)}}, nil
+	}
+
 	defer func() {
 		meterLabels := []string{
 			"channel", up.ChannelHeader.ChannelId,
 			"chaincode", up.ChaincodeName,
 			"success", strconv.FormatBool(success),
+			"hasDependency", strconv.FormatBool(hasDependency),
 		}
 		e.Metrics.ProposalDuration.With(meterLabels...).Observe(time.Since(startTime).Seconds())
 	}()
 
-	pResp, err := e.ProcessProposalSuccessfullyOrError(up)
+	pResp, hasDependency, err := e.processProposalOnce(ctx, up)
 	if err != nil {
 		logger.Warnw("Failed to invoke chaincode", "channel", up.ChannelHeader.ChannelId, "chaincode", up.ChaincodeName, "error", err.Error())
-		// Return a nil error since clients are expected to look at the ProposalResponse response status code (500) and message.
-		return &pb.ProposalResponse{Response: &pb.Response{Status: 500, Message: err.Error()}}, nil
+		// Return a nil error since clients are expected to look at the ProposalResponse response status code (500,
+		// or the one of the cause of a shard failure) and message.
+		return errorResponse(err), nil
 	}
 
 	if pResp.Endorsement != nil || up.ChannelHeader.ChannelId == "" {
@@ -275,13 +521,49 @@ func (e *Endorser) ProcessProposal(ctx context.Context, signedProp *pb.SignedPro
 		success = true
 
 		// total failed proposals = ProposalsReceived-SuccessfulProposals
-		e.Metrics.SuccessfulProposals.Add(1)
+		e.Metrics.SuccessfulProposals.With("hasDependency", strconv.FormatBool(hasDependency)).Add(1)
+	}
+	return e.withShardOwner(pResp, up.ChaincodeName), nil
+}
+
+// ProcessProposalSuccessfullyOrError implements the core endorsement logic with sharding support.
+// The shards are no longer waited on once ctx, usually the client's request context, is done.
+func (e *Endorser) ProcessProposalSuccessfullyOrError(ctx context.Context, up *UnpackedProposal) (*pb.ProposalResponse, error) {
+	pResp, _, err := e.processProposalOnce(ctx, up)
+	return pResp, err
+}
+
+// processProposalOnce processes the proposal, or waits for the result of
+// the same proposal if it is already in flight. A proposal with an
+// idempotency key gets the endorsement of an earlier proposal with that key.
+func (e *Endorser) processProposalOnce(ctx context.Context, up *UnpackedProposal) (*pb.ProposalResponse, bool, error) {
+	process := func(ctx context.Context) (*pb.ProposalResponse, bool, error) {
+		return e.ProcessingTxs.Do(ctx, up.ChannelID()+"/"+up.TxID(), up.SignedProposal.ProposalBytes, func(ctx context.Context) (*pb.ProposalResponse, bool, error) {
+			pResp, hasDependency, err := e.processProposal(ctx, up)
+			if err == nil && pResp.GetEndorsement() != nil {
+				if err := e.journalEndorsement(up.TxID()); err != nil {
+					return nil, false, err
+				}
+			}
+			return pResp, hasDependency, err
+		})
+	}
+	if e.Config.Idempotency.TTL <= 0 {
+		return process(ctx)
 	}
-	return pResp, nil
+	key, err := idempotencyKey(up)
+	if err != nil {
+		return nil, false, errors.WithMessage(err, "invalid transient field "+IdempotencyTransientKey)
+	}
+	if key == "" {
+		return process(ctx)
+	}
+	return e.processIdempotent(ctx, up, key, process)
 }
 
-// ProcessProposalSuccessfullyOrError implements the core endorsement logic with sharding support
-func (e *Endorser) ProcessProposalSuccessfullyOrError(up *UnpackedProposal) (*pb.ProposalResponse, error) {
+// processProposal endorses the proposal and additionally reports whether the
+// shards found a dependency on another transaction
+func (e *Endorser) processProposal(ctx context.Context, up *UnpackedProposal) (*pb.ProposalResponse, bool, error) {
 	txParams := &ccprovider.TransactionParams{
 		ChannelID:  up.ChannelHeader.ChannelId,
 		TxID:       up.ChannelHeader.TxId,
@@ -291,11 +573,19 @@ func (e *Endorser) ProcessProposalSuccessfullyOrError(up *UnpackedProposal) (*pb
 
 	logger := decorateLogger(logger, txParams)
 
+	// height is the ledger height before the simulation, against which the
+	// versions it read are checked
+	var height uint64
+
 	// Acquire transaction simulator if needed
 	if acquireTxSimulator(up.ChannelHeader.ChannelId, up.ChaincodeName) {
+		if e.Config.MVCCPreCheck {
+			// Unknown, the versions read are then always checked
+			height, _ = e.Support.GetLedgerHeight(up.ChannelID())
+		}
 		txSim, err := e.Support.GetTxSimulator(up.ChannelID(), up.TxID())
 		if err != nil {
-			return nil, err
+			return nil, false, err
 		}
 
 		// txsim acquires a shared lock on the stateDB. As this would impact the block commits (i.e., commit
@@ -309,7 +599,7 @@ func (e *Endorser) ProcessProposalSuccessfullyOrError(up *UnpackedProposal) (*pb
 
 		hqe, err := e.Support.GetHistoryQueryExecutor(up.ChannelID())
 		if err != nil {
-			return nil, err
+			return nil, false, err
 		}
 
 		txParams.TXSimulator = txSim
@@ -319,208 +609,228 @@ func (e *Endorser) ProcessProposalSuccessfullyOrError(up *UnpackedProposal) (*pb
 	// Get chaincode endorsement info
 	cdLedger, err := e.Support.ChaincodeEndorsementInfo(up.ChannelID(), up.ChaincodeName, txParams.TXSimulator)
 	if err != nil {
-		return nil, errors.WithMessagef(err, "make sure the chaincode %s has been successfully defined on channel %s and try again", up.ChaincodeName, up.ChannelID())
+		return nil, false, errors.WithMessagef(err, "make sure the chaincode %s has been successfully defined on channel %s and try again", up.ChaincodeName, up.ChannelID())
 	}
 
-	// Simulate the proposal
-	res, simulationResult, ccevent, ccInterest, err := e.simulateProposal(txParams, up.ChaincodeName, up.Input)
-	if err != nil {
-		return nil, errors.WithMessage(err, "error in simulation")
+	// A read-only invocation repeated by the same client reuses the cached
+	// simulation, as long as no write to the keys it read shows up
+	cacheKey := ""
+	var cached *cachedSimulation
+	if e.Config.ResponseCache.TTL > 0 {
+		cacheKey = responseCacheKey(up, cdLedger.Version)
+		cached, _ = e.responses.lookup(cacheKey)
+	}
+
+	var res *pb.Response
+	var simulationResult *ledger.TxSimulationResults
+	var ccevent *pb.ChaincodeEvent
+	var ccInterest *pb.ChaincodeInterest
+	if cached != nil {
+		if txParams.TXSimulator != nil {
+			txParams.TXSimulator.Done()
+		}
+		res, simulationResult, ccevent, ccInterest = cached.response, cached.simulationResult, cached.ccevent, cached.ccInterest
+		height = 0
+		logger.Debugf("Reusing the cached simulation of tx %s", up.ChannelHeader.TxId)
+	} else {
+		// Simulate the proposal
+		res, simulationResult, ccevent, ccInterest, err = e.simulateProposal(txParams, up.ChaincodeName, up.Input)
+		if err != nil {
+			return nil, false, errors.WithMessage(err, "error in simulation")
+		}
 	}
 
 	if res.Status >= shim.ERROR {
-		return &pb.ProposalResponse{Response: res}, nil
+		return &pb.ProposalResponse{Response: res}, false, nil
+	}
+
+	// A proposal bound to fail MVCC validation is refused before it
+	// reserves keys on the shards
+	if e.Config.MVCCPreCheck && up.ChannelID() != "" {
+		stale, err := e.staleReads(up.ChannelID(), up.TxID(), height, simulationResult)
+		if err != nil {
+			return nil, false, errors.WithMessage(err, "error checking the versions read")
+		}
+		if len(stale) > 0 {
+			if cached != nil {
+				e.responses.remove(cacheKey)
+				e.Metrics.countResponseCacheInvalidations(1)
+				logger.Debugf("Simulating tx %s again, as keys it read were committed since it was cached", up.ChannelHeader.TxId)
+				return e.processProposal(ctx, up)
+			}
+			e.Metrics.countStaleProposal(up.ChannelID(), up.ChaincodeName)
+			return &pb.ProposalResponse{Response: &pb.Response{
+				Status:  StatusStaleRead,
+				Message: fmt.Sprintf("simulation read stale versions of %s, simulate the proposal again", strings.Join(stale, ", ")),
+			}}, false, nil
+		}
 	}
 
 	hasDependency := false
-	dependentTxID := ""
-	maxCommitIndex := uint64(0)
-	_ = maxCommitIndex // Prevent unused variable error if verified later
+	// deps holds the dependencies the shards reported, and stays empty when
+	// sharding does not apply to the proposal
+	deps := &dependencyResolution{}
+	// review asks the conflict oracle about the dependencies of the
+	// proposal, when sharding applies to it
+	var review *protos.ReviewRequest
 
 	// ===== SHARDED RAFT-BASED DEPENDENCY RESOLUTION =====
-	// Controlled by FABRIC_SHARDING_ENABLED env var. When disabled (or unset),
-	// the endorser behaves like vanilla Fabric with no dependency tracking.
-	shardingEnabled := os.Getenv("FABRIC_SHARDING_ENABLED") == "true"
-
-	if shardingEnabled && txParams.TXSimulator != nil && !e.Support.IsSysCC(up.ChaincodeName) {
-		// Extract transaction dependencies from simulation results
-		dependencies, err := e.extractTransactionDependencies(simulationResult)
+	// Controlled by peer.endorser.sharding.enabled or the FABRIC_SHARDING_ENABLED
+	// env var, and narrowed by the sharding policy. When disabled, the endorser
+	// behaves like vanilla Fabric with no dependency tracking.
+	shardingEnabled := e.Config.shardingEnabled(up.ChannelID(), up.ChaincodeName)
+
+	if shardingEnabled && simulationResult != nil && !e.Support.IsSysCC(up.ChaincodeName) {
+		// Extract transaction dependencies from simulation results and from
+		// the hints declared by the client and the chaincode
+		declared, err := dependencyHints(up, ccevent)
 		if err != nil {
-			return nil, errors.WithMessage(err, "error extracting transaction dependencies")
+			return nil, hasDependency, errors.WithMessage(err, "error parsing dependency hints")
+		}
+		dependencies, ranges, written, err := e.extractTransactionDependencies(simulationResult, up.ChannelID(), up.ChaincodeName, declared)
+		if err != nil {
+			return nil, hasDependency, errors.WithMessage(err, "error extracting transaction dependencies")
+		}
+		expiry := e.reservationExpiry(up.ChannelID(), up.ChaincodeName, declared)
+		review = reviewRequest(up, dependencies, written)
+		writes := len(written) > 0
+		// A writer reserves the keys it only reads as well, and tells them
+		// apart so that the shards classify its conflicts
+		var reads map[string]bool
+		if writes {
+			reads = make(map[string]bool)
+			for key := range dependencies {
+				if !written[key] {
+					reads[key] = true
+				}
+			}
+		}
+		// A read-only transaction reserves nothing, so the shards answer it
+		// without appending to their log
+		if !writes {
+			logger.Debugf("Tx %s is read-only, querying its dependencies without reserving its keys", up.ChannelHeader.TxId)
 		}
 
-		// Identify all involved shards (namespaces) from dependencies
+		// Identify all involved shards from dependencies. A namespace maps to
+		// one shard unless its keys are partitioned across several.
 		involvedShards := make(map[string]map[string][]byte) // shardName -> writeSet
+		involvedNamespaces := make(map[string]bool)
 		for varKey, varValue := range dependencies {
-			parts := strings.Split(varKey, ":")
+			parts := strings.SplitN(varKey, ":", 2)
 			if len(parts) > 0 {
 				namespace := parts[0]
 				// Only consider actual chaincode namespaces
 				if namespace != "" && !e.Support.IsSysCC(namespace) {
-					if _, exists := involvedShards[namespace]; !exists {
-						involvedShards[namespace] = make(map[string][]byte)
+					shardName := e.shardForKey(up.ChannelID(), namespace, parts[len(parts)-1])
+					if _, exists := involvedShards[shardName]; !exists {
+						involvedShards[shardName] = make(map[string][]byte)
 					}
-					involvedShards[namespace][varKey] = varValue
+					involvedShards[shardName][varKey] = varValue
+					involvedNamespaces[namespace] = true
 				}
 			}
 		}
 
+		// A range may hold keys of every shard of its namespace, since keys
+		// are hashed onto the shards
+		shardRanges := make(map[string][]sharding.KeyRange)
+		for _, r := range ranges {
+			namespace := strings.SplitN(r.StartKey, ":", 2)[0]
+			for _, shardName := range e.shardsOfNamespace(up.ChannelID(), namespace) {
+				if _, exists := involvedShards[shardName]; !exists {
+					involvedShards[shardName] = make(map[string][]byte)
+				}
+				shardRanges[shardName] = append(shardRanges[shardName], r)
+			}
+			involvedNamespaces[namespace] = true
+		}
+
 		// If the primary chaincode wasn't picked up (e.g. read only with no deps), ensure it's at least queried
+		// unless the policy leaves all of its keys untracked
 		contractName := up.ChaincodeName
-		if _, exists := involvedShards[contractName]; !exists {
-			involvedShards[contractName] = make(map[string][]byte)
+		if !involvedNamespaces[contractName] && !matchesChaincode(e.Config.ShardingPolicy.Untracked.Namespaces, up.ChannelID(), contractName) {
+			involvedShards[e.shardForKey(up.ChannelID(), contractName, "")] = make(map[string][]byte)
 		}
 
-		var wg sync.WaitGroup
-		var mu sync.Mutex
-
-		var shardErrors []error
-		contactedShards := make([]*sharding.ShardLeader, 0, len(involvedShards))
-
-		ctx, cancel := context.WithTimeout(context.Background(), DefaultPrepareTimeout)
-		defer cancel()
-
-		// Go maps iterate randomly. Extract and sort keys to guarantee determinism
-		// across all Endorsing peers, ensuring identical execution flow.
-		var sortedShardNames []string
-		for shardName := range involvedShards {
-			sortedShardNames = append(sortedShardNames, shardName)
+		store := e.dependencyStore(up.ChannelID())
+		if store == nil {
+			return nil, hasDependency, errors.New("Endorser dependency store is not initialized")
 		}
-		sort.Strings(sortedShardNames)
-
-		for _, shardName := range sortedShardNames {
-			writeSet := involvedShards[shardName]
-			if e.ShardManager == nil {
-				return nil, errors.New("Endorser ShardManager is not initialized")
-			}
-
-			// EXP4 Fix: If the peer is not a replica, use the HTTP Remote Client to ask the actual replica
-			if !e.ShardManager.IsReplica(shardName) {
-				wg.Add(1)
-				go func(sName string, wSet map[string][]byte) {
-					defer wg.Done()
-					prepareReq := &sharding.PrepareRequest{
-						TxID:      up.ChannelHeader.TxId,
-						ShardID:   sName,
-						ReadSet:   make(map[string][]byte),
-						WriteSet:  wSet,
-						Timestamp: time.Now(),
-					}
-
-					logger.Debugf("Requesting remote proof for tx %s from shard %s", prepareReq.TxID, sName)
-					proof, err := e.ShardManager.RequestRemoteProof(sName, prepareReq)
-					if err != nil {
-						mu.Lock()
-						shardErrors = append(shardErrors, fmt.Errorf("remote proof failed for shard %s: %v", sName, err))
-						mu.Unlock()
-						return
-					}
-
-					if !e.verifyProof(proof) {
-						mu.Lock()
-						shardErrors = append(shardErrors, fmt.Errorf("invalid remote proof from shard %s", sName))
-						mu.Unlock()
-						return
-					}
-
-					mu.Lock()
-					if proof.HasDependency {
-						hasDependency = true
-					}
-					if proof.DependentTxID != "" {
-						if dependentTxID == "" {
-							dependentTxID = proof.DependentTxID
-						} else {
-							dependentTxID = dependentTxID + "," + proof.DependentTxID
-						}
-					}
-					mu.Unlock()
-				}(shardName, writeSet)
-				continue
-			}
 
-			shard, err := e.ShardManager.GetOrCreateShard(shardName)
+		switch {
+		case len(involvedShards) == 0:
+			logger.Debugf("Tx %s has no tracked key, skipping the prepare round", up.ChannelHeader.TxId)
+		case e.Config.SpeculativeEndorsement:
+			// The response is endorsed right away, while the proofs are
+			// gathered in the background for the client to fetch
+			e.speculate(up.ChannelID(), up.ChannelHeader.TxId, e.prepareLane(up), store, involvedShards, shardRanges, reads, writes, expiry)
+			deps = &dependencyResolution{speculative: true}
+		default:
+			deps, err = e.resolveDependencies(ctx, up.ChannelID(), up.ChannelHeader.TxId, e.prepareLane(up), store, involvedShards, shardRanges, reads, writes, expiry)
+			hasDependency = deps.hasDependency
 			if err != nil {
-				shardErrors = append(shardErrors, errors.WithMessagef(err, "failed to get shard %s", shardName))
-				continue
+				return nil, hasDependency, err
 			}
+			e.estimateSettle(up.ChannelID(), deps)
+		}
+	}
 
-			contactedShards = append(contactedShards, shard)
-			wg.Add(1)
-
-			go func(sName string, s *sharding.ShardLeader, wSet map[string][]byte) {
-				defer wg.Done()
-
-				prepareReq := &sharding.PrepareRequest{
-					TxID:      up.ChannelHeader.TxId,
-					ShardID:   sName,
-					ReadSet:   make(map[string][]byte),
-					WriteSet:  wSet,
-					Timestamp: time.Now(),
-				}
-
-				// 1. Subscribe FIRST to ensure we don't miss the broadcast if we propose
-				commitC := s.Subscribe(up.ChannelHeader.TxId)
-				defer s.Unsubscribe(up.ChannelHeader.TxId, commitC)
-
-				// 2. Check if we even need to propose (prevents redundant Raft log entries)
-				if !s.HasProof(up.ChannelHeader.TxId) {
-					select {
-					case s.ProposeC() <- prepareReq:
-						logger.Debugf("Submitted prepare request for tx %s to shard %s", prepareReq.TxID, sName)
-					case <-ctx.Done():
-						mu.Lock()
-						shardErrors = append(shardErrors, fmt.Errorf("timeout submitting to shard %s", sName))
-						mu.Unlock()
-						return
-					}
-				}
-
-				// 3. Wait for the proof (or get it immediately if it was already cached in Subscribe)
-				select {
-				case proof := <-commitC:
-					if !e.verifyProof(proof) {
-						mu.Lock()
-						shardErrors = append(shardErrors, fmt.Errorf("invalid proof from shard %s", sName))
-						mu.Unlock()
-						return
-					}
-
-					mu.Lock()
-					if proof.HasDependency {
-						hasDependency = true
-					}
-					if proof.DependentTxID != "" {
-						if dependentTxID == "" {
-							dependentTxID = proof.DependentTxID
-						} else {
-							dependentTxID = dependentTxID + "," + proof.DependentTxID
-						}
-					}
-					mu.Unlock()
-				case <-ctx.Done():
-					mu.Lock()
-					shardErrors = append(shardErrors, fmt.Errorf("timeout waiting for proof from shard %s", sName))
-					mu.Unlock()
-				}
-			}(shardName, shard, writeSet)
+	// A proposal depending on transactions still in flight would likely
+	// fail MVCC validation once they commit, so it may wait for them and be
+	// simulated again on the state they leave
+	if e.holdsForDependencies(ctx, deps) {
+		ctx, err := e.holdForDependencies(ctx, up, deps)
+		if err != nil {
+			return nil, hasDependency, err
+		}
+		if cached != nil {
+			e.responses.remove(cacheKey)
 		}
+		return e.processProposal(ctx, up)
+	}
 
-		wg.Wait()
+	switch {
+	case cached != nil && hasDependency:
+		// A transaction reserved keys the cached simulation read, so it
+		// may have changed them
+		e.responses.remove(cacheKey)
+		e.Metrics.countResponseCacheInvalidations(1)
+		logger.Debugf("Simulating tx %s again, as keys it read were written since it was cached", up.ChannelHeader.TxId)
+		return e.processProposal(ctx, up)
+	case cached != nil:
+		e.Metrics.countResponseCacheHit(up.ChannelID(), up.ChaincodeName)
+	case cacheKey != "" && res.Status == shim.OK && !hasDependency && !deps.speculative:
+		if reads, ok := readOnlyKeys(simulationResult); ok {
+			e.responses.store(cacheKey, &cachedSimulation{
+				response:         res,
+				simulationResult: simulationResult,
+				ccevent:          ccevent,
+				ccInterest:       ccInterest,
+				reads:            reads,
+			}, e.Config.ResponseCache.TTL, e.Config.ResponseCache.MaxEntries)
+		}
+	}
 
-		if len(shardErrors) > 0 {
-			// Abort on all contacted shards
-			for _, s := range contactedShards {
-				s.HandleAbort(up.ChannelHeader.TxId)
-			}
-			return nil, errors.Errorf("failed to gather dependency proofs: %v", shardErrors)
+	// The conflict oracle may veto the endorsement or annotate it
+	annotations := ""
+	if review != nil && e.ConflictOracle != nil {
+		var vetoed *pb.ProposalResponse
+		annotations, vetoed, err = e.reviewProposal(ctx, review, deps)
+		if err != nil || vetoed != nil {
+			e.releaseVetoed(up.TxID())
+		}
+		if err != nil {
+			return nil, hasDependency, err
+		}
+		if vetoed != nil {
+			return vetoed, hasDependency, nil
 		}
 	}
 
 	// Create chaincode event bytes
 	cceventBytes, err := CreateCCEventBytes(ccevent)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to marshal chaincode event")
+		return nil, hasDependency, errors.Wrap(err, "failed to marshal chaincode event")
 	}
 
 	// Create proposal response payload
@@ -528,42 +838,25 @@ func (e *Endorser) ProcessProposalSuccessfullyOrError(up *UnpackedProposal) (*pb
 	if simulationResult != nil {
 		pubSimResBytes, err = simulationResult.GetPubSimulationBytes()
 		if err != nil {
-			return nil, errors.WithMessage(err, "failed to get public simulation bytes")
+			return nil, hasDependency, errors.WithMessage(err, "failed to get public simulation bytes")
 		}
 	}
 
-	// Sort the dependentTxIDs to ensure deterministic payload hashing
-	// because goroutines complete in random order during proof collection.
-	var sortedDeps string
-	if dependentTxID != "" {
-		depMap := make(map[string]bool)
-		for _, dep := range strings.Split(dependentTxID, ",") {
-			if dep != "" {
-				depMap[dep] = true
-			}
-		}
-
-		var depList []string
-		for txID := range depMap {
-			depList = append(depList, txID)
-		}
-		sort.Strings(depList)
-		sortedDeps = strings.Join(depList, ",")
-	}
-
 	// Include dependency and proof information in response message
 	// IMPORTANT: This MUST be set BEFORE serializing prpBytes, otherwise the
 	// ChaincodeAction.Response.Message in the block won't contain the dependency
 	// info, and BuildDAGFromBlock won't find any edges → flat DAG → no parallelism.
-	res.Message = fmt.Sprintf("%s; DependencyInfo:HasDependency=%v,DependentTxID=%s",
-		res.Message, hasDependency, sortedDeps)
+	if annotations != "" {
+		res.Message = fmt.Sprintf("%s; OracleAnnotations:%s", res.Message, annotations)
+	}
+	res.Message = fmt.Sprintf("%s; DependencyInfo:%s", res.Message, deps.claims())
 
 	prpBytes, err := protoutil.GetBytesProposalResponsePayload(up.ProposalHash, res, pubSimResBytes, cceventBytes, &pb.ChaincodeID{
 		Name:    up.ChaincodeName,
 		Version: cdLedger.Version,
 	})
 	if err != nil {
-		return nil, errors.WithMessage(err, "failed to create the proposal response")
+		return nil, hasDependency, errors.WithMessage(err, "failed to create the proposal response")
 	}
 
 	meterLabels := []string{
@@ -578,13 +871,13 @@ func (e *Endorser) ProcessProposalSuccessfullyOrError(up *UnpackedProposal) (*pb
 			Response: res,
 			Payload:  prpBytes,
 			Interest: ccInterest,
-		}, nil
+		}, hasDependency, nil
 	case up.ChannelID() == "":
-		return &pb.ProposalResponse{Response: res}, nil
+		return &pb.ProposalResponse{Response: res}, hasDependency, nil
 	case res.Status >= shim.ERRORTHRESHOLD:
 		meterLabels = append(meterLabels, "chaincodeerror", strconv.FormatBool(true))
 		e.Metrics.EndorsementsFailed.With(meterLabels...).Add(1)
-		return &pb.ProposalResponse{Response: res}, nil
+		return &pb.ProposalResponse{Response: res}, hasDependency, nil
 	}
 
 	// Endorse the response
@@ -593,7 +886,7 @@ func (e *Endorser) ProcessProposalSuccessfullyOrError(up *UnpackedProposal) (*pb
 	if err != nil {
 		meterLabels = append(meterLabels, "chaincodeerror", strconv.FormatBool(false))
 		e.Metrics.EndorsementsFailed.With(meterLabels...).Add(1)
-		return nil, errors.WithMessage(err, "endorsing with plugin failed")
+		return nil, hasDependency, errors.WithMessage(err, "endorsing with plugin failed")
 	}
 
 	return &pb.ProposalResponse{
@@ -602,23 +895,448 @@ func (e *Endorser) ProcessProposalSuccessfullyOrError(up *UnpackedProposal) (*pb
 		Payload:     mPrpBytes,
 		Response:    res,
 		Interest:    ccInterest,
-	}, nil
+	}, hasDependency, nil
+}
+
+// dependencyResolution holds the dependencies of a proposal gathered from the
+// shards, and the proofs backing them
+type dependencyResolution struct {
+	hasDependency bool
+	// dependentTxIDs is the sorted list of the transactions depended upon
+	dependentTxIDs  string
+	missingShards   string
+	dependencyChain string
+	// conflictTypes lists the types of the conflicts with each of the
+	// dependentTxIDs, as tx:type+type;tx:type
+	conflictTypes string
+	encodedProofs string
+	// settleEstimate is how long until the transactions depended upon are
+	// likely committed, 0 when unknown
+	settleEstimate time.Duration
+	// speculative marks a response endorsed before its proofs were gathered
+	speculative bool
+	// coordinated marks a transaction committed on its shards with two-phase
+	// commit, whose reservations are no longer released on their own
+	coordinated bool
+}
+
+// claims returns the dependency info embedded in the response message. The
+// shards that did not answer and the chain of ancestors, when there are any,
+// precede DependentTxID, which must remain the last claim before the proofs.
+func (d *dependencyResolution) claims() string {
+	claims := fmt.Sprintf("HasDependency=%v", d.hasDependency)
+	if d.missingShards != "" {
+		claims += ",MissingShards=" + d.missingShards
+	}
+	if d.dependencyChain != "" {
+		claims += ",DependencyChain=" + d.dependencyChain
+	}
+	if d.conflictTypes != "" {
+		claims += ",ConflictTypes=" + d.conflictTypes
+	}
+	if d.settleEstimate > 0 {
+		claims += ",SettleEstimate=" + d.settleEstimate.Round(time.Millisecond).String()
+	}
+	if d.speculative {
+		claims += ",Speculative=true"
+	}
+	claims += ",DependentTxID=" + d.dependentTxIDs
+	if d.encodedProofs != "" {
+		claims += ",ShardProofs=" + d.encodedProofs
+	}
+	return claims
+}
+
+// formatConflictTypes renders the conflict types of each transaction as
+// tx:type+type, separated by ';' and ordered by transaction, so that they fit
+// in a claim
+func formatConflictTypes(types map[string][]sharding.ConflictType) string {
+	txIDs := make([]string, 0, len(types))
+	for txID := range types {
+		txIDs = append(txIDs, txID)
+	}
+	sort.Strings(txIDs)
+
+	entries := make([]string, 0, len(txIDs))
+	for _, txID := range txIDs {
+		names := make([]string, 0, len(types[txID]))
+		for _, conflictType := range types[txID] {
+			names = append(names, string(conflictType))
+		}
+		entries = append(entries, txID+":"+strings.Join(names, "+"))
+	}
+	return strings.Join(entries, ";")
 }
 
-// verifyProof verifies a prepare proof from the shard
-func (e *Endorser) verifyProof(proof *sharding.PrepareProof) bool {
-	if proof == nil || proof.TxID == "" || proof.ShardID == "" {
-		return false
+// errInvalidProof marks the prepares answered with a proof that failed
+// verification
+var errInvalidProof = errors.New("invalid proof")
+
+// Types of the errors that left a proposal without a valid proof from a
+// shard, as reported by the shard_errors metric
+const (
+	shardErrorUnavailable  = "unavailable"
+	shardErrorRejected     = "rejected"
+	shardErrorInvalidProof = "invalid_proof"
+	shardErrorFailed       = "failed"
+)
+
+// resolveDependencies prepares the transaction of the channel on each of the
+// involved shards, given the keys it touches on each, in the given lane and
+// gathers their proofs. A writer reserves all of its keys, and reads lists
+// those it only reads. The reservations made are released when the
+// transaction cannot be endorsed. The reservations expire after expiry, or
+// after the expiry of each shard when it is 0.
+func (e *Endorser) resolveDependencies(ctx context.Context, channel, txID string, lane PrepareLane, store sharding.DependencyStore, involvedShards map[string]map[string][]byte, ranges map[string][]sharding.KeyRange, reads map[string]bool, writes bool, expiry time.Duration) (*dependencyResolution, error) {
+	res := &dependencyResolution{}
+	dependentTxID := ""
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+
+	var shardErrors []error
+	var proofs []*sharding.PrepareProof
+	// unavailable holds the shards skipped because their circuit breaker is open
+	unavailable := make(map[string]bool)
+	// failed holds the shards that returned no proof, which a quorum of
+	// the others may make up for
+	failed := make(map[string]error)
+
+	// The prepares follow the client's deadline and cancellation, so that
+	// the shards do not work for a client that gave up. Every attempt is
+	// further bounded by the prepare timeout.
+	prepareCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Go maps iterate randomly. Extract and sort keys to guarantee determinism
+	// across all Endorsing peers, ensuring identical execution flow.
+	var sortedShardNames []string
+	for shardName := range involvedShards {
+		sortedShardNames = append(sortedShardNames, shardName)
+	}
+	sort.Strings(sortedShardNames)
+
+	// The coordinator must know of the transaction before any shard
+	// reserves keys for it, so that it aborts it if this peer crashes. It
+	// decides on the embedded shards, not on the store of a channel that
+	// selected its own.
+	coordinated := e.Coordinator != nil && e.ChannelDependencyStores[channel] == nil && writes && len(sortedShardNames) > 1
+	res.coordinated = coordinated
+	if coordinated {
+		if err := e.Coordinator.Begin(txID, sortedShardNames); err != nil {
+			return res, errors.WithMessage(err, "failed to begin two-phase commit")
+		}
+	}
+
+	// The prepares of a proposal of a batch are combined with those of the
+	// other proposals of the batch
+	var shardCtxs []context.Context
+	if p := batchedProposalFrom(ctx); p != nil {
+		shardCtxs = p.announce(prepareCtx, len(sortedShardNames))
+	}
+
+	// The transaction has the same timestamp on all of its shards
+	timestamp := sharding.Clock().Now()
+
+	for i, shardName := range sortedShardNames {
+		shardCtx := prepareCtx
+		if shardCtxs != nil {
+			shardCtx = shardCtxs[i]
+		}
+		wg.Add(1)
+		go func(prepareCtx context.Context, sName string, wSet map[string][]byte) {
+			defer wg.Done()
+			if p := batchedPrepareFrom(prepareCtx); p != nil {
+				defer p.leave()
+			}
+
+			prepareReq := &sharding.PrepareRequest{
+				TxID:      txID,
+				ShardID:   sName,
+				ReadSet:   make(map[string][]byte),
+				WriteSet:  wSet,
+				Timestamp: timestamp,
+				TTL:       expiry,
+				Origin:    e.Config.EndorserID,
+			}
+			if writes {
+				prepareReq.ReservedRanges = ranges[sName]
+				for key, value := range wSet {
+					if reads[key] {
+						prepareReq.ReadSet[key] = value
+					}
+				}
+			} else {
+				prepareReq.ReadSet, prepareReq.WriteSet = wSet, make(map[string][]byte)
+				prepareReq.RangeReads = ranges[sName]
+			}
+
+			start := time.Now()
+			proof, err := e.prepareInLane(prepareCtx, channel, lane, store, prepareReq)
+			e.Metrics.observeShardPrepare(channel, sName, err == nil, time.Since(start))
+			if err == nil {
+				e.settle.observePrepare(time.Since(start))
+			}
+			if errors.Is(err, ErrCircuitOpen) {
+				e.Metrics.countShardError(channel, sName, shardErrorUnavailable)
+				mu.Lock()
+				unavailable[sName] = true
+				if e.ShardCircuitBreakers.Policy() == ShardBreakerBypass {
+					logger.Warningf("Endorsing tx %s without a proof from unavailable shard %s", txID, sName)
+				} else {
+					failed[sName] = err
+				}
+				mu.Unlock()
+				return
+			}
+			if err != nil {
+				if errors.Is(err, errInvalidProof) {
+					e.Metrics.countShardError(channel, sName, shardErrorInvalidProof)
+				} else {
+					e.Metrics.countShardError(channel, sName, shardErrorFailed)
+				}
+				mu.Lock()
+				failed[sName] = err
+				mu.Unlock()
+				return
+			}
+			// The proposals prepared from now on are ordered after this
+			// transaction on every shard
+			if _, err := sharding.Clock().Update(proof.HLC); err != nil {
+				logger.Warningf("Proof of tx %s from shard %s: %s", txID, sName, err)
+			}
+
+			if proof.Rejected {
+				e.Metrics.countShardError(channel, sName, shardErrorRejected)
+				mu.Lock()
+				shardErrors = append(shardErrors, &ShardError{
+					Cause: ShardDependencyConflict,
+					Err:   fmt.Errorf("shard %s rejected tx conflicting with %s under %s policy", sName, proof.DependentTxID, proof.ConflictPolicy),
+				})
+				mu.Unlock()
+				return
+			}
+
+			e.Metrics.countDependencyCheck(channel, sName, proof.HasDependency)
+			e.Metrics.countConflicts(channel, sName, proof.ConflictTypes)
+			mu.Lock()
+			proofs = append(proofs, proof)
+			if proof.HasDependency {
+				res.hasDependency = true
+			}
+			if proof.DependentTxID != "" {
+				if dependentTxID == "" {
+					dependentTxID = proof.DependentTxID
+				} else {
+					dependentTxID = dependentTxID + "," + proof.DependentTxID
+				}
+			}
+			mu.Unlock()
+		}(shardCtx, shardName, involvedShards[shardName])
+	}
+
+	wg.Wait()
+
+	// abortAll releases the reservations made on all contacted shards
+	abortAll := func() {
+		if coordinated {
+			if err := e.Coordinator.Abort(ctx, txID); err != nil {
+				logger.Warningf("Failed to abort tx %s: %s", txID, err)
+			}
+			return
+		}
+		for _, sName := range sortedShardNames {
+			if unavailable[sName] {
+				continue
+			}
+			if err := store.Abort(sName, txID); err != nil {
+				logger.Warningf("Failed to abort tx %s on shard %s: %s", txID, sName, err)
+			}
+		}
+	}
+
+	// Shards that rejected the transaction always fail it, while shards
+	// that did not answer only do so without a quorum of proofs
+	var missing []string
+	for _, sName := range sortedShardNames {
+		if unavailable[sName] && failed[sName] == nil {
+			missing = append(missing, sName)
+		}
+	}
+	if len(failed) > 0 {
+		required := e.Config.prepareQuorum(len(sortedShardNames) - len(missing))
+		for _, sName := range sortedShardNames {
+			err, ok := failed[sName]
+			switch {
+			case !ok:
+			case len(shardErrors) == 0 && len(proofs) >= required:
+				logger.Warningf("Endorsing tx %s with %d of %d shard proofs, without shard %s: %s", txID, len(proofs), len(sortedShardNames), sName, err)
+				missing = append(missing, sName)
+			default:
+				shardErrors = append(shardErrors, err)
+			}
+		}
+		sort.Strings(missing)
+	}
+	res.missingShards = strings.Join(missing, ";")
+
+	if len(shardErrors) > 0 {
+		abortAll()
+		return res, gatherError(shardErrors)
+	}
+
+	// A transaction prepared on several shards may wait on a transaction
+	// that waits on it through another shard
+	if e.DependencyGraph != nil {
+		now := time.Now()
+		if err := e.DependencyGraph.Add(txID, strings.Split(dependentTxID, ","), now.Add(e.Config.retention(expiry)), now); err != nil {
+			logger.Warningf("Rejecting tx %s: %s", txID, err)
+			abortAll()
+			return res, &ShardError{Cause: ShardDependencyConflict, Err: err}
+		}
+	}
+
+	if coordinated {
+		if err := e.Coordinator.Commit(ctx, txID); err != nil {
+			return res, errors.WithMessage(err, "failed to commit tx on the shards")
+		}
 	}
 
-	// Verify signature (simplified - in production, use actual crypto verification)
-	expectedSig := fmt.Sprintf("%s:%d:%s", proof.ShardID, proof.CommitIndex, proof.TxID)
-	return string(proof.Signature) == expectedSig
+	if writes {
+		// The keys written are now reserved, so the cached simulations
+		// that read them are stale
+		written := make(map[string]bool)
+		for _, wSet := range involvedShards {
+			for key := range wSet {
+				written[key] = true
+			}
+		}
+		e.Metrics.countResponseCacheInvalidations(e.responses.invalidate(written))
+
+		// The reservations may be aborted by the client until they expire
+		var contacted []string
+		for _, sName := range sortedShardNames {
+			if !unavailable[sName] {
+				contacted = append(contacted, sName)
+			}
+		}
+		e.endorsed.add(txID, store, contacted, time.Now().Add(e.Config.retention(expiry)))
+	}
+
+	// Embed the proofs so the committer can verify the dependency claims
+	encodedProofs, err := sharding.EncodeProofs(proofs)
+	if err != nil {
+		return res, errors.Wrap(err, "failed to encode dependency proofs")
+	}
+	res.encodedProofs = encodedProofs
+	res.dependencyChain = strings.Join(sharding.MergeDependencyChains(proofs), ";")
+	res.conflictTypes = formatConflictTypes(sharding.MergeConflictTypes(proofs))
+
+	// Sort the dependentTxIDs to ensure deterministic payload hashing
+	// because goroutines complete in random order during proof collection.
+	if dependentTxID != "" {
+		depMap := make(map[string]bool)
+		for _, dep := range strings.Split(dependentTxID, ",") {
+			if dep != "" {
+				depMap[dep] = true
+			}
+		}
+
+		var depList []string
+		for dep := range depMap {
+			depList = append(depList, dep)
+		}
+		sort.Strings(depList)
+		res.dependentTxIDs = strings.Join(depList, ",")
+	}
+	return res, nil
+}
+
+// dependencyStore returns the store used to prepare the transactions of the
+// channel: the one selected for the channel, else the external
+// DependencyStore when one is configured, the embedded Raft shards otherwise
+func (e *Endorser) dependencyStore(channel string) sharding.DependencyStore {
+	store, selected := e.ChannelDependencyStores[channel]
+	if store != nil {
+		return store
+	}
+	if e.DependencyStore != nil && !selected {
+		return e.DependencyStore
+	}
+	if e.ShardManager != nil {
+		return e.ShardManager
+	}
+	return nil
+}
+
+// shardsOfNamespace returns the shards that track the keys of the namespace
+// on the channel
+func (e *Endorser) shardsOfNamespace(channel, namespace string) []string {
+	if e.ShardManager != nil {
+		return e.ShardManager.ShardsOfContract(channel, namespace)
+	}
+	return []string{namespace}
+}
+
+// shardForKey returns the shard that tracks the key of the namespace on the
+// channel
+func (e *Endorser) shardForKey(channel, namespace, key string) string {
+	if e.ShardManager != nil {
+		return e.ShardManager.ShardForKey(channel, namespace, key)
+	}
+	return namespace
+}
+
+// prepareOnShard gathers the proof of the request's shard through the
+// shard's circuit breaker. Failed prepares and invalid proofs count against
+// the breaker, while rejections under the shard's conflict policy do not.
+func (e *Endorser) prepareOnShard(ctx context.Context, channel string, store sharding.DependencyStore, req *sharding.PrepareRequest) (*sharding.PrepareProof, error) {
+	var proof *sharding.PrepareProof
+	prepare := func() error {
+		var err error
+		if proof, err = e.submitPrepare(ctx, store, req); err != nil {
+			return errors.WithMessagef(err, "failed to prepare tx on shard %s", req.ShardID)
+		}
+		start := time.Now()
+		err = e.verifyProof(proof, req)
+		e.Metrics.observeProofVerification(channel, req.ShardID, time.Since(start))
+		if err != nil {
+			return fmt.Errorf("%w from shard %s: %w", errInvalidProof, req.ShardID, err)
+		}
+		return nil
+	}
+
+	if e.ShardCircuitBreakers == nil {
+		return proof, prepare()
+	}
+	if err := e.ShardCircuitBreakers.Breaker(req.ShardID).Execute(prepare); err != nil {
+		if errors.Is(err, ErrCircuitOpen) {
+			return nil, errors.WithMessagef(err, "shard %s is unavailable", req.ShardID)
+		}
+		return nil, err
+	}
+	return proof, nil
+}
+
+// verifyProof checks that the proof answers the prepare request and that it
+// is signed by the shard replicas, whose keys are known when a ProofVerifier
+// is configured
+func (e *Endorser) verifyProof(proof *sharding.PrepareProof, req *sharding.PrepareRequest) error {
+	if proof == nil {
+		return errors.New("missing prepare proof")
+	}
+	if proof.TxID != req.TxID || proof.ShardID != req.ShardID {
+		return errors.Errorf("proof of tx %s from shard %s does not match request", proof.TxID, proof.ShardID)
+	}
+	if e.ProofVerifier != nil {
+		return e.ProofVerifier.VerifyShardProof(proof)
+	}
+	return sharding.VerifyPrepareProof(proof)
 }
 
 // runHealthChecks periodically performs health checks
 func (e *Endorser) runHealthChecks() {
-	ticker := time.NewTicker(30 * time.Second)
+	ticker := time.NewTicker(healthCheckInterval)
 	defer ticker.Stop()
 
 	for {
@@ -642,34 +1360,96 @@ func (e *Endorser) performHealthCheck() {
 		Details:       make(map[string]interface{}),
 	}
 
-	// Check dependency map health (removed)
-	status.Details["dependencyMapSize"] = 0
-
 	// Check leader connectivity for normal endorsers
-	if e.Config.Role == NormalEndorser {
-		if err := e.checkLeaderConnectivity(); err != nil {
-			status.IsHealthy = false
+	role, leader := e.Leadership()
+	status.Details["role"] = role.String()
+	status.Details["leaderEndorser"] = leader
+	if len(e.Config.ShardLeaders) > 0 {
+		status.Details["shardLeaders"] = e.Config.ShardLeaders
+	}
+	if role == NormalEndorser {
+		err := e.checkLeaderConnectivity()
+		if err != nil {
+			status.fail("leader connectivity: %s", err)
 			status.Details["leaderConnectivity"] = err.Error()
 			e.LeaderCheckError = err
 		} else {
 			status.Details["leaderConnectivity"] = "ok"
 			e.LeaderCheckError = nil
 		}
+		e.recordLeaderCheck(leader, err)
 	}
 
 	// Check transaction processing channels (removed)
 	status.Details["channels"] = "ok"
 
+	// Report the Raft state of the dependency shards hosted by this peer
+	if e.ShardManager != nil {
+		shards := e.ShardManager.GetStatus()
+		var leaderless []string
+		for shardID, shard := range shards {
+			if shard.LeaderID == 0 {
+				leaderless = append(leaderless, shardID)
+			}
+		}
+		if len(leaderless) > 0 {
+			sort.Strings(leaderless)
+			status.fail("no leader is known for shards %s", strings.Join(leaderless, ", "))
+		}
+		status.Details["shards"] = shards
+		status.Details["shardEvictions"] = e.ShardManager.Evictions()
+		status.Details["dependencyMapSize"] = e.reportDependencyMapSizes(shards)
+	}
+	if e.ShardCircuitBreakers != nil {
+		var open []string
+		for shardID, state := range e.ShardCircuitBreakers.States() {
+			if state != CircuitClosed {
+				open = append(open, shardID)
+			}
+		}
+		sort.Strings(open)
+		if len(open) > 0 {
+			status.fail("circuits to shards %s are open", strings.Join(open, ", "))
+		}
+		status.Details["openShardCircuits"] = open
+	}
+
 	// Update health status
 	e.HealthStatus = status
 	logger.Infof("Health check completed. Status: %v, Details: %v", status.IsHealthy, status.Details)
 }
 
+// reportDependencyMapSizes sets the dependency map size gauge of each shard
+// replica, and of the replicas stopped since the last report to 0. It
+// returns the total number of reservations held. The caller must hold
+// HealthCheckLock.
+func (e *Endorser) reportDependencyMapSizes(shards map[string]sharding.ShardStatus) int {
+	sizes := make(map[string]int)
+	for shardID := range e.sizedShards {
+		sizes[shardID] = 0
+	}
+	e.sizedShards = make(map[string]bool)
+	total := 0
+	for shardID, shard := range shards {
+		sizes[shardID] = shard.Dependencies
+		e.sizedShards[shardID] = true
+		total += shard.Dependencies
+	}
+	e.Metrics.setDependencyMapSizes(sizes)
+	return total
+}
+
 // checkLeaderConnectivity checks if the normal endorser can connect to the leader
 func (e *Endorser) checkLeaderConnectivity() error {
-	if time.Since(e.LastLeaderCheck) < 30*time.Second {
+	_, leader := e.Leadership()
+	if leader == "" {
+		return errors.New("no leader endorser is elected")
+	}
+	// A new leader is checked right away
+	if leader == e.checkedLeader && time.Since(e.LastLeaderCheck) < 30*time.Second {
 		return e.LeaderCheckError
 	}
+	e.checkedLeader = leader
 
 	if e.LeaderCircuitBreaker == nil {
 		return nil
@@ -677,7 +1457,7 @@ func (e *Endorser) checkLeaderConnectivity() error {
 
 	return e.LeaderCircuitBreaker.Execute(func() error {
 		conn, err := grpc.Dial(
-			e.Config.LeaderEndorser,
+			leader,
 			grpc.WithTransportCredentials(insecure.NewCredentials()),
 			grpc.WithBlock(),
 			grpc.WithTimeout(5*time.Second),
@@ -692,6 +1472,25 @@ func (e *Endorser) checkLeaderConnectivity() error {
 	})
 }
 
+// Leadership returns the role of this endorser and the address of the leader
+// endorser, as elected when there is a LeaderElection and as configured, or
+// taken over from a failed leader, otherwise
+func (e *Endorser) Leadership() (EndorserRole, string) {
+	if e.LeaderElection == nil {
+		e.failover.mu.RLock()
+		defer e.failover.mu.RUnlock()
+		if e.failover.leader != "" {
+			return e.failover.role, e.failover.leader
+		}
+		return e.Config.Role, e.Config.LeaderEndorser
+	}
+	leader, isLeader := e.LeaderElection.Leader()
+	if isLeader {
+		return LeaderEndorser, leader
+	}
+	return NormalEndorser, leader
+}
+
 // GetHealthStatus returns the current health status of the endorser
 func (e *Endorser) GetHealthStatus() *HealthStatus {
 	e.HealthCheckLock.RLock()
@@ -0,0 +1,87 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package endorser
+
+import (
+	"sync"
+	"time"
+)
+
+// expiringCache holds values by key until they expire. When it is full, the
+// value closest to expiry makes room for a new one.
+type expiringCache[V any] struct {
+	mu      sync.Mutex
+	entries map[string]expiringEntry[V]
+}
+
+type expiringEntry[V any] struct {
+	value   V
+	expires time.Time
+}
+
+// lookup returns the value kept for the key, dropping it if it expired
+func (c *expiringCache[V]) lookup(key string) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var none V
+	entry, ok := c.entries[key]
+	if !ok {
+		return none, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(c.entries, key)
+		return none, false
+	}
+	return entry.value, true
+}
+
+// store keeps a value for ttl, making room among at most maxEntries
+func (c *expiringCache[V]) store(key string, value V, ttl time.Duration, maxEntries int) {
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string]expiringEntry[V])
+	}
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= maxEntries {
+		oldest := ""
+		for k, e := range c.entries {
+			if now.After(e.expires) {
+				delete(c.entries, k)
+			} else if oldest == "" || e.expires.Before(c.entries[oldest].expires) {
+				oldest = k
+			}
+		}
+		if len(c.entries) >= maxEntries {
+			delete(c.entries, oldest)
+		}
+	}
+	c.entries[key] = expiringEntry[V]{value: value, expires: now.Add(ttl)}
+}
+
+// remove drops the value kept for the key
+func (c *expiringCache[V]) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, key)
+}
+
+// removeIf drops the values for which drop returns true, and returns how
+// many were dropped
+func (c *expiringCache[V]) removeIf(drop func(V) bool) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	dropped := 0
+	for k, entry := range c.entries {
+		if drop(entry.value) {
+			delete(c.entries, k)
+			dropped++
+		}
+	}
+	return dropped
+}
@@ -0,0 +1,71 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package endorser
+
+import (
+	"sync"
+
+	"github.com/hyperledger/fabric/core/endorser/sharding"
+	"github.com/pkg/errors"
+)
+
+// expirySubscriberBuffer is the number of expired endorsements held for a
+// subscriber that has not read them yet. Further ones are dropped.
+const expirySubscriberBuffer = 256
+
+// expiryFeed fans the endorsements expired on the local shards out to the
+// subscribers
+type expiryFeed struct {
+	register sync.Once
+	mu       sync.Mutex
+	subs     map[chan sharding.ExpiredEndorsement]struct{}
+}
+
+// OnEndorsementExpired implements sharding.ExpiryObserver
+func (f *expiryFeed) OnEndorsementExpired(expired sharding.ExpiredEndorsement) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for sub := range f.subs {
+		select {
+		case sub <- expired:
+		default:
+			logger.Debugf("Dropped the expiry of tx %s on shard %s for a slow subscriber", expired.TxID, expired.ShardID)
+		}
+	}
+}
+
+func (f *expiryFeed) subscribe() chan sharding.ExpiredEndorsement {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.subs == nil {
+		f.subs = make(map[chan sharding.ExpiredEndorsement]struct{})
+	}
+	sub := make(chan sharding.ExpiredEndorsement, expirySubscriberBuffer)
+	f.subs[sub] = struct{}{}
+	return sub
+}
+
+func (f *expiryFeed) unsubscribe(sub chan sharding.ExpiredEndorsement) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.subs, sub)
+}
+
+// SubscribeExpiries returns the endorsements that expire on the shards this
+// peer replicates from now on, until cancel is called. A client whose
+// transaction expired should endorse it again rather than submit it for
+// ordering, since the shards no longer hold its reservations.
+func (e *Endorser) SubscribeExpiries() (<-chan sharding.ExpiredEndorsement, func(), error) {
+	if e.ShardManager == nil {
+		return nil, nil, errors.New("dependency tracking is disabled")
+	}
+	e.expiries.register.Do(func() {
+		e.ShardManager.AddExpiryObserver(&e.expiries)
+	})
+	sub := e.expiries.subscribe()
+	return sub, func() { e.expiries.unsubscribe(sub) }, nil
+}
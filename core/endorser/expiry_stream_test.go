@@ -0,0 +1,48 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package endorser
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/endorser/sharding"
+	. "github.com/onsi/gomega"
+)
+
+func TestExpiryFeed(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	f := &expiryFeed{}
+	// nobody is told of the expiries before subscribing
+	f.OnEndorsementExpired(sharding.ExpiredEndorsement{ShardID: "fabcar", TxID: "tx0"})
+
+	first, second := f.subscribe(), f.subscribe()
+	expired := sharding.ExpiredEndorsement{ShardID: "fabcar", TxID: "tx1", Keys: []string{"car1"}}
+	f.OnEndorsementExpired(expired)
+	gt.Expect(first).To(Receive(Equal(expired)))
+	gt.Expect(second).To(Receive(Equal(expired)))
+
+	// a slow subscriber misses the expiries beyond its buffer, without
+	// holding up the others
+	f.unsubscribe(first)
+	for i := 0; i <= expirySubscriberBuffer; i++ {
+		f.OnEndorsementExpired(expired)
+	}
+	gt.Expect(first).NotTo(Receive())
+	gt.Expect(second).To(HaveLen(expirySubscriberBuffer))
+}
+
+func TestAdminHandlerExpiriesWithoutShards(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	rr := httptest.NewRecorder()
+	NewAdminHandler(&Endorser{}).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/endorser/expiries", nil))
+	gt.Expect(rr.Code).To(Equal(http.StatusNotFound))
+	gt.Expect(rr.Body.String()).To(ContainSubstring("dependency tracking is disabled"))
+}
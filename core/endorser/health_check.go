@@ -7,14 +7,50 @@ SPDX-License-Identifier: Apache-2.0
 package endorser
 
 import (
+	"context"
+	"fmt"
+	"strings"
 	"time"
+
+	"github.com/pkg/errors"
 )
 
+// healthCheckInterval is how often the endorser checks its health
+const healthCheckInterval = 30 * time.Second
+
 // HealthStatus represents the health status of the endorser
 type HealthStatus struct {
 	IsHealthy     bool
 	LastCheckTime time.Time
 	Details       map[string]interface{}
+	// Failures explains why the endorser is not healthy
+	Failures []string
+}
+
+// fail marks the status unhealthy for the given reason
+func (s *HealthStatus) fail(format string, args ...interface{}) {
+	s.IsHealthy = false
+	s.Failures = append(s.Failures, fmt.Sprintf(format, args...))
+}
+
+// HealthCheck implements healthz.HealthChecker, so that the operations
+// /healthz endpoint fails while the endorser cannot reach its leader
+// endorser, a shard it hosts has no leader, or the circuit to a shard is
+// open. The status is checked anew once it is older than the health check
+// interval. The endorser is always healthy while sharding is disabled.
+func (e *Endorser) HealthCheck(ctx context.Context) error {
+	if !e.Config.shardingOn() {
+		return nil
+	}
+	status := e.GetHealthStatus()
+	if status == nil || time.Since(status.LastCheckTime) >= healthCheckInterval {
+		e.performHealthCheck()
+		status = e.GetHealthStatus()
+	}
+	if status.IsHealthy {
+		return nil
+	}
+	return errors.New(strings.Join(status.Failures, "; "))
 }
 
 // Note: All health check methods are implemented in endorser.go to avoid duplication:
@@ -0,0 +1,52 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package endorser
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestHealthCheck(t *testing.T) {
+	gt := NewGomegaWithT(t)
+	t.Setenv("FABRIC_SHARDING_ENABLED", "false")
+	breakers := NewShardCircuitBreakers(CircuitBreakerConfig{Threshold: 1, Timeout: time.Minute}, ShardBreakerFailFast, nil)
+	e := &Endorser{
+		Config:               EndorserConfig{Role: LeaderEndorser},
+		ShardCircuitBreakers: breakers,
+	}
+
+	gt.Expect(e.HealthCheck(context.Background())).To(Succeed())
+	gt.Expect(e.GetHealthStatus()).To(BeNil(), "nothing is checked while sharding is disabled")
+
+	e.Config.ShardingEnabled = true
+	gt.Expect(e.HealthCheck(context.Background())).To(Succeed())
+	gt.Expect(e.GetHealthStatus().Details["role"]).To(Equal("leader"))
+
+	// a recent status is reported as is
+	breakers.Breaker("fabcar").Execute(func() error { return errors.New("timeout waiting for proof") })
+	gt.Expect(e.HealthCheck(context.Background())).To(Succeed())
+
+	// and a stale one checked anew
+	e.HealthStatus.LastCheckTime = time.Now().Add(-healthCheckInterval)
+	gt.Expect(e.HealthCheck(context.Background())).To(MatchError("circuits to shards fabcar are open"))
+	gt.Expect(e.GetHealthStatus().IsHealthy).To(BeFalse())
+	gt.Expect(e.GetHealthStatus().Details["openShardCircuits"]).To(Equal([]string{"fabcar"}))
+}
+
+func TestHealthCheckLeaderConnectivity(t *testing.T) {
+	gt := NewGomegaWithT(t)
+	e := &Endorser{
+		Config: EndorserConfig{Role: NormalEndorser, ShardingEnabled: true},
+	}
+
+	gt.Expect(e.HealthCheck(context.Background())).To(MatchError("leader connectivity: no leader endorser is elected"))
+}
@@ -0,0 +1,155 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package hints lets chaincodes and their clients declare the logical
+// dependencies of an invocation, which the endorser prepares on the shards
+// along with the keys read and written by the simulation. A hint names a key
+// of the invoked chaincode that need not be read or written, e.g. an asset
+// whose owner the invocation relies on.
+//
+// A client declares hints in the transient field TransientKey of the
+// proposal. A chaincode declares them with Declare, which sets them as its
+// chaincode event. A chaincode may also shorten how long the shards keep the
+// reservations of its invocations with Expiry.
+package hints
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// TransientKey is the transient field of a proposal that holds the hints
+	// declared by the client
+	TransientKey = "fabric.sharding.dependencies"
+	// EventName is the name of the chaincode event that holds the hints
+	// declared by the chaincode
+	EventName = "fabric.sharding.dependencies"
+)
+
+// Hints lists the keys of the invoked chaincode that an invocation depends
+// on. A read hint makes the invocation depend on the last transaction that
+// reserved the key, without reserving it. A write hint also reserves the
+// key, so that later invocations depend on this one.
+type Hints struct {
+	Reads  []string `json:",omitempty"`
+	Writes []string `json:",omitempty"`
+	// Expiry, e.g. "30s", is how long the shards keep the reservations of
+	// the invocation when it is shorter than the expiry configured for the
+	// chaincode. The endorser ignores the Expiry declared by clients.
+	Expiry string `json:",omitempty"`
+}
+
+// EventSetter is the part of the chaincode stub that Declare needs
+type EventSetter interface {
+	SetEvent(name string, payload []byte) error
+}
+
+// Declare sets the hints as the chaincode event of the invocation. A
+// chaincode that declares hints cannot set another event in the same
+// invocation, since only the last event set is kept.
+func Declare(stub EventSetter, hints *Hints) error {
+	payload, err := Marshal(hints)
+	if err != nil {
+		return err
+	}
+	return stub.SetEvent(EventName, payload)
+}
+
+// Marshal serializes the hints to JSON
+func Marshal(hints *Hints) ([]byte, error) {
+	if err := hints.validate(); err != nil {
+		return nil, err
+	}
+	return json.Marshal(hints)
+}
+
+// Unmarshal deserializes hints from JSON
+func Unmarshal(data []byte) (*Hints, error) {
+	hints := &Hints{}
+	if err := json.Unmarshal(data, hints); err != nil {
+		return nil, errors.Wrap(err, "malformed dependency hints")
+	}
+	if err := hints.validate(); err != nil {
+		return nil, err
+	}
+	return hints, nil
+}
+
+func (h *Hints) validate() error {
+	for _, keys := range [][]string{h.Reads, h.Writes} {
+		for _, key := range keys {
+			if key == "" {
+				return errors.New("dependency hints must not name an empty key")
+			}
+		}
+	}
+	if h.Expiry != "" {
+		expiry, err := time.ParseDuration(h.Expiry)
+		if err != nil {
+			return errors.Wrap(err, "invalid expiry in dependency hints")
+		}
+		if expiry <= 0 {
+			return errors.Errorf("expiry in dependency hints must be positive, got %s", h.Expiry)
+		}
+	}
+	return nil
+}
+
+// TTL returns the Expiry of the hints, or 0 when they declare none
+func (h *Hints) TTL() time.Duration {
+	if h == nil {
+		return 0
+	}
+	// The hints were validated when unmarshaled
+	expiry, _ := time.ParseDuration(h.Expiry)
+	return expiry
+}
+
+// Merge returns the hints of both, each key listed once and a key hinted
+// both as a read and as a write listed as a write only, with the shorter of
+// their expiries
+func Merge(a, b *Hints) *Hints {
+	writes := make(map[string]bool)
+	reads := make(map[string]bool)
+	for _, h := range []*Hints{a, b} {
+		if h == nil {
+			continue
+		}
+		for _, key := range h.Writes {
+			writes[key] = true
+		}
+		for _, key := range h.Reads {
+			reads[key] = true
+		}
+	}
+
+	merged := &Hints{}
+	for _, h := range []*Hints{a, b} {
+		if ttl := h.TTL(); ttl > 0 && (merged.Expiry == "" || ttl < merged.TTL()) {
+			merged.Expiry = h.Expiry
+		}
+	}
+	for key := range writes {
+		merged.Writes = append(merged.Writes, key)
+	}
+	for key := range reads {
+		if !writes[key] {
+			merged.Reads = append(merged.Reads, key)
+		}
+	}
+	sort.Strings(merged.Writes)
+	sort.Strings(merged.Reads)
+	return merged
+}
+
+// Empty reports whether the hints name no key
+func (h *Hints) Empty() bool {
+	return h == nil || len(h.Reads) == 0 && len(h.Writes) == 0
+}
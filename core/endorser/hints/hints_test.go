@@ -0,0 +1,64 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package hints
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+type eventRecorder struct {
+	name    string
+	payload []byte
+}
+
+func (r *eventRecorder) SetEvent(name string, payload []byte) error {
+	r.name, r.payload = name, payload
+	return nil
+}
+
+func TestDeclare(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	stub := &eventRecorder{}
+	gt.Expect(Declare(stub, &Hints{Writes: []string{"owner~car1"}})).To(Succeed())
+	gt.Expect(stub.name).To(Equal(EventName))
+
+	hints, err := Unmarshal(stub.payload)
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(hints).To(Equal(&Hints{Writes: []string{"owner~car1"}}))
+
+	gt.Expect(Declare(stub, &Hints{Reads: []string{""}})).To(MatchError("dependency hints must not name an empty key"))
+	_, err = Unmarshal([]byte("car1"))
+	gt.Expect(err).To(MatchError(ContainSubstring("malformed dependency hints")))
+
+	hints, err = Unmarshal([]byte(`{"Writes":["car1"],"Expiry":"30s"}`))
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(hints.TTL()).To(Equal(30 * time.Second))
+	gt.Expect((*Hints)(nil).TTL()).To(BeZero())
+	_, err = Unmarshal([]byte(`{"Expiry":"soon"}`))
+	gt.Expect(err).To(MatchError(ContainSubstring("invalid expiry in dependency hints")))
+	_, err = Unmarshal([]byte(`{"Expiry":"-1s"}`))
+	gt.Expect(err).To(MatchError("expiry in dependency hints must be positive, got -1s"))
+}
+
+func TestMerge(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	merged := Merge(
+		&Hints{Reads: []string{"car2", "car1"}, Writes: []string{"car3"}},
+		&Hints{Reads: []string{"car3"}, Writes: []string{"car1"}},
+	)
+	gt.Expect(merged).To(Equal(&Hints{Reads: []string{"car2"}, Writes: []string{"car1", "car3"}}))
+	gt.Expect(Merge(nil, nil).Empty()).To(BeTrue())
+	gt.Expect(merged.Empty()).To(BeFalse())
+
+	gt.Expect(Merge(&Hints{Expiry: "1m"}, &Hints{Expiry: "30s"}).Expiry).To(Equal("30s"))
+	gt.Expect(Merge(nil, &Hints{Expiry: "1m"}).Expiry).To(Equal("1m"))
+}
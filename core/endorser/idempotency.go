@@ -0,0 +1,138 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package endorser
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/hyperledger/fabric/protoutil"
+	"github.com/pkg/errors"
+)
+
+// IdempotencyTransientKey is the transient field of a proposal that holds
+// the idempotency key a client chose for it
+const IdempotencyTransientKey = "fabric.idempotency.key"
+
+// DefaultIdempotencyCacheSize caps the number of responses kept when
+// IdempotencyConfig.MaxEntries is unset
+const DefaultIdempotencyCacheSize = 10000
+
+// StatusIdempotencyKeyReused answers a proposal carrying the idempotency key
+// of another proposal of the same client
+const StatusIdempotencyKeyReused = 422
+
+// IdempotencyConfig keeps the endorsement of each proposal carrying an
+// idempotency key, so that a client retrying it, even under another TxID,
+// gets the same endorsement back. The retry is neither simulated nor
+// prepared on the shards again, so it registers no second set of
+// dependencies.
+type IdempotencyConfig struct {
+	// TTL is how long an endorsement is returned to retries. Idempotency
+	// keys are ignored when it is 0.
+	TTL time.Duration
+	// MaxEntries defaults to DefaultIdempotencyCacheSize
+	MaxEntries int
+}
+
+// idempotentResponse is the endorsement of a proposal with an idempotency key
+type idempotentResponse struct {
+	// digest identifies the proposal payload, so that a key reused for
+	// another invocation is not mistaken for a retry
+	digest        [sha256.Size]byte
+	response      *pb.ProposalResponse
+	hasDependency bool
+}
+
+// idempotentResponses holds the endorsements by idempotencyKey, and the
+// proposals with an idempotency key that are in flight
+type idempotentResponses struct {
+	entries  expiringCache[*idempotentResponse]
+	inFlight ProcessingTxs
+}
+
+// idempotencyKey returns the idempotency key of the proposal, scoped to its
+// channel and client, or "" if it has none
+func idempotencyKey(up *UnpackedProposal) (string, error) {
+	cpp, err := protoutil.UnmarshalChaincodeProposalPayload(up.Proposal.Payload)
+	if err != nil {
+		return "", err
+	}
+	token, ok := cpp.TransientMap[IdempotencyTransientKey]
+	if !ok {
+		return "", nil
+	}
+	if len(token) == 0 {
+		return "", errors.New("empty transient field " + IdempotencyTransientKey)
+	}
+	h := sha256.New()
+	for _, part := range [][]byte{
+		[]byte(up.ChannelID()),
+		up.SignatureHeader.Creator,
+		token,
+	} {
+		h.Write(part)
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// lookup returns a copy of the endorsement kept for the key
+func (c *idempotentResponses) lookup(key string) (*idempotentResponse, bool) {
+	entry, ok := c.entries.lookup(key)
+	if !ok {
+		return nil, false
+	}
+	hit := *entry
+	hit.response = proto.Clone(entry.response).(*pb.ProposalResponse)
+	return &hit, true
+}
+
+// store keeps an endorsement for ttl, making room among at most maxEntries
+func (c *idempotentResponses) store(key string, entry *idempotentResponse, ttl time.Duration, maxEntries int) {
+	if maxEntries <= 0 {
+		maxEntries = DefaultIdempotencyCacheSize
+	}
+	entry.response = proto.Clone(entry.response).(*pb.ProposalResponse)
+	c.entries.store(key, entry, ttl, maxEntries)
+}
+
+// processIdempotent returns the endorsement kept for the idempotency key of
+// the proposal, or processes the proposal and keeps its endorsement. Retries
+// arriving while the first attempt is in flight wait for its result.
+func (e *Endorser) processIdempotent(ctx context.Context, up *UnpackedProposal, key string, process func(context.Context) (*pb.ProposalResponse, bool, error)) (*pb.ProposalResponse, bool, error) {
+	digest := sha256.Sum256(up.Proposal.Payload)
+	if hit, ok := e.idempotent.lookup(key); ok {
+		if hit.digest != digest {
+			return &pb.ProposalResponse{Response: &pb.Response{
+				Status:  StatusIdempotencyKeyReused,
+				Message: fmt.Sprintf("idempotency key of tx %s was already used for another proposal", up.TxID()),
+			}}, false, nil
+		}
+		e.Metrics.countIdempotentReplay(up.ChannelID(), up.ChaincodeName)
+		logger.Debugw("Returning the endorsement kept for the idempotency key", "txID", up.TxID(), "chaincode", up.ChaincodeName)
+		return hit.response, hit.hasDependency, nil
+	}
+
+	return e.idempotent.inFlight.Do(ctx, key, up.Proposal.Payload, func(ctx context.Context) (*pb.ProposalResponse, bool, error) {
+		pResp, hasDependency, err := process(ctx)
+		// Only endorsements are kept, a failed proposal may be retried
+		if err == nil && pResp.GetEndorsement() != nil {
+			e.idempotent.store(key, &idempotentResponse{
+				digest:        digest,
+				response:      pResp,
+				hasDependency: hasDependency,
+			}, e.Config.Idempotency.TTL, e.Config.Idempotency.MaxEntries)
+		}
+		return pResp, hasDependency, err
+	})
+}
@@ -0,0 +1,142 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package endorser
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	cb "github.com/hyperledger/fabric-protos-go/common"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	. "github.com/onsi/gomega"
+)
+
+// idempotentProposal returns a proposal of the creator with the transaction
+// ID, arguments and idempotency key, none when key is empty
+func idempotentProposal(gt *GomegaWithT, creator, txID, args, key string) *UnpackedProposal {
+	transient := map[string][]byte{}
+	if key != "" {
+		transient[IdempotencyTransientKey] = []byte(key)
+	}
+	payload, err := proto.Marshal(&pb.ChaincodeProposalPayload{Input: []byte(args), TransientMap: transient})
+	gt.Expect(err).NotTo(HaveOccurred())
+	return &UnpackedProposal{
+		ChaincodeName:   "fabcar",
+		ChannelHeader:   &cb.ChannelHeader{ChannelId: "mychannel", TxId: txID},
+		SignatureHeader: &cb.SignatureHeader{Creator: []byte(creator)},
+		Proposal:        &pb.Proposal{Payload: payload},
+	}
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	key, err := idempotencyKey(idempotentProposal(gt, "alice", "tx1", "buy car1", "order-7"))
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(key).NotTo(BeEmpty())
+
+	// the key does not depend on the transaction
+	retry, err := idempotencyKey(idempotentProposal(gt, "alice", "tx2", "buy car1", "order-7"))
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(retry).To(Equal(key))
+
+	// but is scoped to the client
+	other, err := idempotencyKey(idempotentProposal(gt, "bob", "tx3", "buy car1", "order-7"))
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(other).NotTo(Equal(key))
+
+	none, err := idempotencyKey(idempotentProposal(gt, "alice", "tx4", "buy car1", ""))
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(none).To(BeEmpty())
+
+	payload, err := proto.Marshal(&pb.ChaincodeProposalPayload{TransientMap: map[string][]byte{IdempotencyTransientKey: nil}})
+	gt.Expect(err).NotTo(HaveOccurred())
+	_, err = idempotencyKey(&UnpackedProposal{Proposal: &pb.Proposal{Payload: payload}})
+	gt.Expect(err).To(MatchError("empty transient field " + IdempotencyTransientKey))
+}
+
+func TestIdempotentResponses(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	c := &idempotentResponses{}
+	_, ok := c.lookup("k1")
+	gt.Expect(ok).To(BeFalse())
+
+	response := &pb.ProposalResponse{Response: &pb.Response{Status: 200}, Endorsement: &pb.Endorsement{}}
+	c.store("k1", &idempotentResponse{response: response, hasDependency: true}, time.Minute, 0)
+	response.Response.Message = "changed after storing"
+
+	hit, ok := c.lookup("k1")
+	gt.Expect(ok).To(BeTrue())
+	gt.Expect(hit.hasDependency).To(BeTrue())
+	gt.Expect(hit.response.Response.Message).To(BeEmpty())
+
+	// the endorsement expiring first makes room beyond maxEntries
+	c.store("k2", &idempotentResponse{response: response}, 2*time.Minute, 2)
+	c.store("k3", &idempotentResponse{response: response}, 2*time.Minute, 2)
+	_, ok = c.lookup("k1")
+	gt.Expect(ok).To(BeFalse())
+	_, ok = c.lookup("k3")
+	gt.Expect(ok).To(BeTrue())
+
+	c.store("k4", &idempotentResponse{response: response}, time.Millisecond, 0)
+	time.Sleep(5 * time.Millisecond)
+	_, ok = c.lookup("k4")
+	gt.Expect(ok).To(BeFalse())
+}
+
+func TestProcessIdempotent(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	e := &Endorser{Config: EndorserConfig{Idempotency: IdempotencyConfig{TTL: time.Minute}}}
+	calls := 0
+	fail := false
+	process := func(ctx context.Context) (*pb.ProposalResponse, bool, error) {
+		calls++
+		if fail {
+			return nil, false, errors.New("timeout waiting for proof from shard fabcar")
+		}
+		return &pb.ProposalResponse{Response: &pb.Response{Status: 200, Message: "tx1"}, Endorsement: &pb.Endorsement{}}, true, nil
+	}
+
+	first := idempotentProposal(gt, "alice", "tx1", "buy car1", "order-7")
+	key, err := idempotencyKey(first)
+	gt.Expect(err).NotTo(HaveOccurred())
+	resp, hasDependency, err := e.processIdempotent(context.Background(), first, key, process)
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(hasDependency).To(BeTrue())
+	gt.Expect(resp.Response.Message).To(Equal("tx1"))
+
+	// a retry under another TxID gets the endorsement of the first proposal
+	resp, hasDependency, err = e.processIdempotent(context.Background(), idempotentProposal(gt, "alice", "tx2", "buy car1", "order-7"), key, process)
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(hasDependency).To(BeTrue())
+	gt.Expect(resp.Response.Message).To(Equal("tx1"))
+	gt.Expect(calls).To(Equal(1))
+
+	// the key of another invocation is refused
+	resp, _, err = e.processIdempotent(context.Background(), idempotentProposal(gt, "alice", "tx3", "buy car2", "order-7"), key, process)
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(resp.Response.Status).To(Equal(int32(StatusIdempotencyKeyReused)))
+	gt.Expect(calls).To(Equal(1))
+
+	// a failed proposal is not kept, its retry is processed again
+	fail = true
+	failed := idempotentProposal(gt, "alice", "tx4", "buy car3", "order-8")
+	key, err = idempotencyKey(failed)
+	gt.Expect(err).NotTo(HaveOccurred())
+	_, _, err = e.processIdempotent(context.Background(), failed, key, process)
+	gt.Expect(err).To(HaveOccurred())
+	fail = false
+	resp, _, err = e.processIdempotent(context.Background(), idempotentProposal(gt, "alice", "tx5", "buy car3", "order-8"), key, process)
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(resp.Endorsement).NotTo(BeNil())
+	gt.Expect(calls).To(Equal(3))
+}
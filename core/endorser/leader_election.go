@@ -0,0 +1,96 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package endorser
+
+import (
+	"sync"
+
+	"github.com/hyperledger/fabric/core/endorser/sharding"
+	"github.com/pkg/errors"
+)
+
+// LeaderElection elects the leader endorser among the endorsers of a
+// channel: they replicate a dedicated shard, and the endorser whose replica
+// leads its Raft group is the leader endorser. It follows the leader changes
+// of the shard, so that the endorsers learn of a new leader endorser when the
+// previous one fails.
+type LeaderElection struct {
+	shardID string
+	// addressOf resolves the address of a replica of the shard
+	addressOf func(shardID string, replicaID uint64) string
+
+	// mu guards the ID of the local replica and the leader
+	mu        sync.RWMutex
+	replicaID uint64
+	leaderID  uint64
+	leader    string
+}
+
+// NewLeaderElection starts the election shard on this peer, which must be
+// one of its replicas, and follows its leader
+func NewLeaderElection(sm *sharding.ShardManager, shardID string) (*LeaderElection, error) {
+	if !sm.IsReplica(shardID) {
+		return nil, errors.Errorf("this peer is not a replica of the leader election shard %s", shardID)
+	}
+
+	le := &LeaderElection{shardID: shardID, addressOf: sm.ReplicaAddress}
+	// Register first, so that no leader change is missed after the status
+	// is read
+	sm.AddObserver(le)
+	shard, err := sm.PinShard(shardID)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "failed to start the leader election shard %s", shardID)
+	}
+	status := shard.GetStatus()
+	le.mu.Lock()
+	le.replicaID = status.ReplicaID
+	le.mu.Unlock()
+	if status.LeaderID != 0 {
+		le.OnLeaderChanged(shardID, status.LeaderID)
+	}
+	return le, nil
+}
+
+// OnShardCreated implements sharding.ShardObserver
+func (le *LeaderElection) OnShardCreated(shardID string) {}
+
+// OnShardStopped implements sharding.ShardObserver
+func (le *LeaderElection) OnShardStopped(shardID string) {}
+
+// OnLeaderChanged implements sharding.ShardObserver
+func (le *LeaderElection) OnLeaderChanged(shardID string, leaderID uint64) {
+	if shardID != le.shardID {
+		return
+	}
+	leader := ""
+	if leaderID != 0 {
+		leader = le.addressOf(shardID, leaderID)
+	}
+
+	le.mu.Lock()
+	defer le.mu.Unlock()
+	if leaderID == le.leaderID && leader == le.leader {
+		return
+	}
+	le.leaderID, le.leader = leaderID, leader
+	switch {
+	case leaderID == 0:
+		logger.Warningf("No leader endorser is elected on shard %s", shardID)
+	case leaderID == le.replicaID:
+		logger.Infof("This endorser was elected leader endorser on shard %s", shardID)
+	default:
+		logger.Infof("Endorser %d at %s was elected leader endorser on shard %s", leaderID, leader, shardID)
+	}
+}
+
+// Leader returns the address of the leader endorser, "" while none is
+// elected, and whether it is this endorser
+func (le *LeaderElection) Leader() (string, bool) {
+	le.mu.RLock()
+	defer le.mu.RUnlock()
+	return le.leader, le.leaderID != 0 && le.leaderID == le.replicaID
+}
@@ -0,0 +1,55 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package endorser
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestLeaderElection(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	addrs := map[uint64]string{1: "peer0:7051", 2: "peer1:7051"}
+	le := &LeaderElection{
+		shardID:   "endorsers",
+		replicaID: 1,
+		addressOf: func(shardID string, replicaID uint64) string { return addrs[replicaID] },
+	}
+	e := &Endorser{Config: EndorserConfig{Role: LeaderEndorser, LeaderEndorser: "static:7051"}}
+
+	// without an election the configuration applies
+	role, leader := e.Leadership()
+	gt.Expect(role).To(Equal(LeaderEndorser))
+	gt.Expect(leader).To(Equal("static:7051"))
+
+	e.LeaderElection = le
+	role, leader = e.Leadership()
+	gt.Expect(role).To(Equal(NormalEndorser))
+	gt.Expect(leader).To(BeEmpty())
+	gt.Expect(e.checkLeaderConnectivity()).To(MatchError("no leader endorser is elected"))
+
+	le.OnLeaderChanged("endorsers", 1)
+	role, leader = e.Leadership()
+	gt.Expect(role).To(Equal(LeaderEndorser))
+	gt.Expect(leader).To(Equal("peer0:7051"))
+
+	// the endorsers follow the leader to another replica
+	le.OnLeaderChanged("endorsers", 2)
+	role, leader = e.Leadership()
+	gt.Expect(role).To(Equal(NormalEndorser))
+	gt.Expect(leader).To(Equal("peer1:7051"))
+
+	// leader changes of other shards are ignored
+	le.OnLeaderChanged("fabcar", 1)
+	_, leader = e.Leadership()
+	gt.Expect(leader).To(Equal("peer1:7051"))
+
+	gt.Expect(LeaderEndorser.String()).To(Equal("leader"))
+	gt.Expect(NormalEndorser.String()).To(Equal("normal"))
+}
@@ -0,0 +1,92 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package endorser
+
+import "sync"
+
+// leaderFailover holds the leader endorser taken over from the configured
+// one after it failed repeated connectivity checks
+type leaderFailover struct {
+	mu sync.RWMutex
+	// failures counts the consecutive failed checks of the current leader
+	failures int
+	// leader is "" while the configured leader is followed
+	leader string
+	role   EndorserRole
+}
+
+// isSelf reports whether the address names this endorser
+func (c EndorserConfig) isSelf(addr string) bool {
+	return addr != "" && (addr == c.Address || addr == c.EndorserID)
+}
+
+// nextLeaderCandidate returns the candidate following the failed leader,
+// the first one if the leader is not a candidate, or "" if there is no other
+// candidate
+func nextLeaderCandidate(candidates []string, failed string) string {
+	next := 0
+	for i, candidate := range candidates {
+		if candidate == failed {
+			next = i + 1
+			break
+		}
+	}
+	for i := 0; i < len(candidates); i++ {
+		candidate := candidates[(next+i)%len(candidates)]
+		if candidate != failed {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// recordLeaderCheck counts the consecutive failed connectivity checks of the
+// leader endorser. Once they reach the LeaderFailoverThreshold, the endorser
+// follows the next of the LeaderCandidates, promoting itself to leader
+// endorser when it is that candidate. Every endorser walks the candidates in
+// the same order, so that they agree on the new leader.
+func (e *Endorser) recordLeaderCheck(leader string, err error) {
+	threshold := e.Config.LeaderFailoverThreshold
+	if e.LeaderElection != nil || threshold <= 0 || len(e.Config.LeaderCandidates) == 0 {
+		return
+	}
+
+	e.failover.mu.Lock()
+	defer e.failover.mu.Unlock()
+	if err == nil {
+		e.failover.failures = 0
+		return
+	}
+	e.failover.failures++
+	if e.failover.failures < threshold {
+		return
+	}
+
+	next := nextLeaderCandidate(e.Config.LeaderCandidates, leader)
+	if next == "" {
+		logger.Warningf("Leader endorser %s failed %d connectivity checks and there is no other candidate", leader, e.failover.failures)
+		return
+	}
+	e.failover.failures = 0
+	e.failover.leader = next
+	e.failover.role = NormalEndorser
+	if e.Config.isSelf(next) {
+		e.failover.role = LeaderEndorser
+	}
+	if e.LeaderCircuitBreaker != nil {
+		e.LeaderCircuitBreaker.Reset()
+	}
+	if e.Metrics != nil && e.Metrics.LeaderFailovers != nil {
+		e.Metrics.LeaderFailovers.Add(1)
+	}
+
+	if e.failover.role == LeaderEndorser {
+		logger.Warningf("Leader endorser %s failed %d connectivity checks, promoting this endorser to leader endorser", leader, threshold)
+	} else {
+		logger.Warningf("Leader endorser %s failed %d connectivity checks, failing over to %s", leader, threshold, next)
+	}
+}
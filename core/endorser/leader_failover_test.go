@@ -0,0 +1,82 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package endorser
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/hyperledger/fabric/common/metrics/metricsfakes"
+	. "github.com/onsi/gomega"
+)
+
+func TestNextLeaderCandidate(t *testing.T) {
+	gt := NewGomegaWithT(t)
+	candidates := []string{"peer0:7051", "peer1:7051", "peer2:7051"}
+
+	gt.Expect(nextLeaderCandidate(candidates, "peer0:7051")).To(Equal("peer1:7051"))
+	gt.Expect(nextLeaderCandidate(candidates, "peer2:7051")).To(Equal("peer0:7051"))
+	gt.Expect(nextLeaderCandidate(candidates, "leader:7051")).To(Equal("peer0:7051"))
+	gt.Expect(nextLeaderCandidate([]string{"peer0:7051"}, "peer0:7051")).To(BeEmpty())
+}
+
+func TestLeaderFailover(t *testing.T) {
+	gt := NewGomegaWithT(t)
+	failovers := &metricsfakes.Counter{}
+	e := &Endorser{
+		Config: EndorserConfig{
+			Role:                    NormalEndorser,
+			LeaderEndorser:          "peer0:7051",
+			LeaderCandidates:        []string{"peer0:7051", "peer1:7051", "peer2:7051"},
+			LeaderFailoverThreshold: 2,
+			Address:                 "peer2:7051",
+		},
+		Metrics:              &Metrics{LeaderFailovers: failovers},
+		LeaderCircuitBreaker: NewCircuitBreaker(DefaultCircuitBreakerConfig(), nil),
+	}
+	unreachable := errors.New("leader endorser is unreachable")
+
+	// a success in between resets the count
+	e.recordLeaderCheck("peer0:7051", unreachable)
+	e.recordLeaderCheck("peer0:7051", nil)
+	e.recordLeaderCheck("peer0:7051", unreachable)
+	role, leader := e.Leadership()
+	gt.Expect(role).To(Equal(NormalEndorser))
+	gt.Expect(leader).To(Equal("peer0:7051"))
+
+	// the next candidate takes over
+	e.recordLeaderCheck("peer0:7051", unreachable)
+	role, leader = e.Leadership()
+	gt.Expect(role).To(Equal(NormalEndorser))
+	gt.Expect(leader).To(Equal("peer1:7051"))
+	gt.Expect(failovers.AddCallCount()).To(Equal(1))
+
+	// and this endorser once it is next
+	e.recordLeaderCheck("peer1:7051", unreachable)
+	e.recordLeaderCheck("peer1:7051", unreachable)
+	role, leader = e.Leadership()
+	gt.Expect(role).To(Equal(LeaderEndorser))
+	gt.Expect(leader).To(Equal("peer2:7051"))
+	gt.Expect(failovers.AddCallCount()).To(Equal(2))
+}
+
+func TestLeaderFailoverDisabled(t *testing.T) {
+	gt := NewGomegaWithT(t)
+	e := &Endorser{
+		Config: EndorserConfig{
+			Role:             NormalEndorser,
+			LeaderEndorser:   "peer0:7051",
+			LeaderCandidates: []string{"peer0:7051", "peer1:7051"},
+		},
+	}
+
+	for i := 0; i < 5; i++ {
+		e.recordLeaderCheck("peer0:7051", errors.New("leader endorser is unreachable"))
+	}
+	_, leader := e.Leadership()
+	gt.Expect(leader).To(Equal("peer0:7051"))
+}
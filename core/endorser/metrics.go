@@ -7,7 +7,11 @@ SPDX-License-Identifier: Apache-2.0
 package endorser
 
 import (
+	"strconv"
+	"time"
+
 	"github.com/hyperledger/fabric/common/metrics"
+	"github.com/hyperledger/fabric/core/endorser/sharding"
 )
 
 var (
@@ -15,8 +19,8 @@ var (
 		Namespace:    "endorser",
 		Name:         "proposal_duration",
 		Help:         "The time to complete a proposal.",
-		LabelNames:   []string{"channel", "chaincode", "success"},
-		StatsdFormat: "%{#fqname}.%{channel}.%{chaincode}.%{success}",
+		LabelNames:   []string{"channel", "chaincode", "success", "hasDependency"},
+		StatsdFormat: "%{#fqname}.%{channel}.%{chaincode}.%{success}.%{hasDependency}",
 	}
 
 	receivedProposalsCounterOpts = metrics.CounterOpts{
@@ -26,9 +30,11 @@ var (
 	}
 
 	successfulProposalsCounterOpts = metrics.CounterOpts{
-		Namespace: "endorser",
-		Name:      "successful_proposals",
-		Help:      "The number of successful proposals.",
+		Namespace:    "endorser",
+		Name:         "successful_proposals",
+		Help:         "The number of successful proposals.",
+		LabelNames:   []string{"hasDependency"},
+		StatsdFormat: "%{#fqname}.%{hasDependency}",
 	}
 
 	proposalValidationFailureCounterOpts = metrics.CounterOpts{
@@ -37,6 +43,46 @@ var (
 		Help:      "The number of proposals that have failed initial validation.",
 	}
 
+	proposalsRateLimitedCounterOpts = metrics.CounterOpts{
+		Namespace:    "endorser",
+		Name:         "proposals_rate_limited",
+		Help:         "The number of proposals refused because their client exceeded its rate.",
+		LabelNames:   []string{"channel", "mspid"},
+		StatsdFormat: "%{#fqname}.%{channel}.%{mspid}",
+	}
+
+	staleProposalsCounterOpts = metrics.CounterOpts{
+		Namespace:    "endorser",
+		Name:         "stale_proposals",
+		Help:         "The number of proposals refused because their simulation read keys changed by blocks committed since.",
+		LabelNames:   []string{"channel", "chaincode"},
+		StatsdFormat: "%{#fqname}.%{channel}.%{chaincode}",
+	}
+
+	dependencyWaitsCounterOpts = metrics.CounterOpts{
+		Namespace:    "endorser",
+		Name:         "dependency_waits",
+		Help:         "The number of proposals held until the transactions they depend on committed, expired or the wait timed out.",
+		LabelNames:   []string{"channel", "chaincode", "outcome"},
+		StatsdFormat: "%{#fqname}.%{channel}.%{chaincode}.%{outcome}",
+	}
+
+	oracleReviewsCounterOpts = metrics.CounterOpts{
+		Namespace:    "endorser",
+		Name:         "oracle_reviews",
+		Help:         "The number of proposals reviewed by the conflict oracle, by outcome.",
+		LabelNames:   []string{"channel", "chaincode", "outcome"},
+		StatsdFormat: "%{#fqname}.%{channel}.%{chaincode}.%{outcome}",
+	}
+
+	misdirectedProposalsCounterOpts = metrics.CounterOpts{
+		Namespace:    "endorser",
+		Name:         "misdirected_proposals",
+		Help:         "The number of proposals refused because another endorser leads their chaincode.",
+		LabelNames:   []string{"channel", "chaincode"},
+		StatsdFormat: "%{#fqname}.%{channel}.%{chaincode}",
+	}
+
 	proposalChannelACLFailureOpts = metrics.CounterOpts{
 		Namespace:    "endorser",
 		Name:         "proposal_acl_failures",
@@ -87,9 +133,11 @@ var (
 	}
 
 	dependencyMapSizeGaugeOpts = metrics.GaugeOpts{
-		Namespace: "endorser",
-		Name:      "dependency_map_size",
-		Help:      "The current size of the transaction dependency map.",
+		Namespace:    "endorser",
+		Name:         "dependency_map_size",
+		Help:         "The number of reservations held by each shard replica on this peer.",
+		LabelNames:   []string{"channel", "chaincode", "shard"},
+		StatsdFormat: "%{#fqname}.%{channel}.%{chaincode}.%{shard}",
 	}
 
 	expiredDependenciesRemovedCounterOpts = metrics.CounterOpts{
@@ -116,6 +164,163 @@ var (
 		Name:      "leader_circuit_breaker_closed",
 		Help:      "The number of times the leader circuit breaker has closed.",
 	}
+
+	shardCircuitBreakerOpenCounterOpts = metrics.CounterOpts{
+		Namespace:    "endorser",
+		Name:         "shard_circuit_breaker_open",
+		Help:         "The number of times the circuit breaker of a shard has opened.",
+		LabelNames:   []string{"shard"},
+		StatsdFormat: "%{#fqname}.%{shard}",
+	}
+
+	shardCircuitBreakerHalfOpenCounterOpts = metrics.CounterOpts{
+		Namespace:    "endorser",
+		Name:         "shard_circuit_breaker_half_open",
+		Help:         "The number of times the circuit breaker of a shard has entered half-open state.",
+		LabelNames:   []string{"shard"},
+		StatsdFormat: "%{#fqname}.%{shard}",
+	}
+
+	shardCircuitBreakerClosedCounterOpts = metrics.CounterOpts{
+		Namespace:    "endorser",
+		Name:         "shard_circuit_breaker_closed",
+		Help:         "The number of times the circuit breaker of a shard has closed.",
+		LabelNames:   []string{"shard"},
+		StatsdFormat: "%{#fqname}.%{shard}",
+	}
+
+	leaderFailoversCounterOpts = metrics.CounterOpts{
+		Namespace: "endorser",
+		Name:      "leader_failovers",
+		Help:      "The number of times this endorser failed over to another leader endorser candidate.",
+	}
+
+	// Shard prepare metrics
+	shardPrepareRetriesCounterOpts = metrics.CounterOpts{
+		Namespace:    "endorser",
+		Name:         "shard_prepare_retries",
+		Help:         "The number of prepares on a shard retried after timing out.",
+		LabelNames:   []string{"shard"},
+		StatsdFormat: "%{#fqname}.%{shard}",
+	}
+
+	shardPrepareFailuresCounterOpts = metrics.CounterOpts{
+		Namespace:    "endorser",
+		Name:         "shard_prepare_failures",
+		Help:         "The number of prepares on a shard that failed, by reason: timeout once the retries are exhausted, error, unavailable while the shard's circuit breaker is open, or canceled once the client gave up.",
+		LabelNames:   []string{"shard", "reason"},
+		StatsdFormat: "%{#fqname}.%{shard}.%{reason}",
+	}
+
+	shardPrepareHedgesCounterOpts = metrics.CounterOpts{
+		Namespace:    "endorser",
+		Name:         "shard_prepare_hedges",
+		Help:         "The number of prepares on a remote shard sent to a second replica after the hedge delay.",
+		LabelNames:   []string{"shard"},
+		StatsdFormat: "%{#fqname}.%{shard}",
+	}
+
+	shardPrepareHedgeWinsCounterOpts = metrics.CounterOpts{
+		Namespace:    "endorser",
+		Name:         "shard_prepare_hedge_wins",
+		Help:         "The number of hedged prepares on a remote shard answered first by the second replica.",
+		LabelNames:   []string{"shard"},
+		StatsdFormat: "%{#fqname}.%{shard}",
+	}
+
+	prepareLaneWaitHistogramOpts = metrics.HistogramOpts{
+		Namespace:    "endorser",
+		Name:         "prepare_lane_wait_duration",
+		Help:         "The time the prepares of a lane waited for a slot while the prepares in flight are capped.",
+		LabelNames:   []string{"lane"},
+		StatsdFormat: "%{#fqname}.%{lane}",
+	}
+
+	prepareBatchSizeHistogramOpts = metrics.HistogramOpts{
+		Namespace:    "endorser",
+		Name:         "prepare_batch_size",
+		Help:         "The number of prepares submitted to a shard in one batch.",
+		LabelNames:   []string{"shard"},
+		StatsdFormat: "%{#fqname}.%{shard}",
+		Buckets:      []float64{1, 2, 5, 10, 20, 50, 100, 200, 500},
+	}
+
+	proposalBatchSizeHistogramOpts = metrics.HistogramOpts{
+		Namespace: "endorser",
+		Name:      "proposal_batch_size",
+		Help:      "The number of distinct proposals received in one batch.",
+		Buckets:   []float64{1, 10, 50, 100, 200, 500, 1000, 2000, 5000},
+	}
+
+	shardPrepareDurationHistogramOpts = metrics.HistogramOpts{
+		Namespace:    "endorser",
+		Name:         "shard_prepare_duration",
+		Help:         "The time taken to gather the proof of a shard, including the wait for a prepare slot and retries.",
+		LabelNames:   []string{"channel", "chaincode", "shard", "success"},
+		StatsdFormat: "%{#fqname}.%{channel}.%{chaincode}.%{shard}.%{success}",
+	}
+
+	proofVerificationDurationHistogramOpts = metrics.HistogramOpts{
+		Namespace:    "endorser",
+		Name:         "proof_verification_duration",
+		Help:         "The time taken to verify a proof returned by a shard.",
+		LabelNames:   []string{"channel", "chaincode", "shard"},
+		StatsdFormat: "%{#fqname}.%{channel}.%{chaincode}.%{shard}",
+	}
+
+	shardDependencyChecksCounterOpts = metrics.CounterOpts{
+		Namespace:    "endorser",
+		Name:         "shard_dependency_checks",
+		Help:         "The number of proofs gathered from a shard, by whether the shard found a dependency.",
+		LabelNames:   []string{"channel", "chaincode", "shard", "hasDependency"},
+		StatsdFormat: "%{#fqname}.%{channel}.%{chaincode}.%{shard}.%{hasDependency}",
+	}
+
+	dependencyConflictsCounterOpts = metrics.CounterOpts{
+		Namespace:    "endorser",
+		Name:         "dependency_conflicts",
+		Help:         "The number of dependencies a shard reported, by type of conflict.",
+		LabelNames:   []string{"channel", "chaincode", "shard", "type"},
+		StatsdFormat: "%{#fqname}.%{channel}.%{chaincode}.%{shard}.%{type}",
+	}
+
+	shardErrorsCounterOpts = metrics.CounterOpts{
+		Namespace:    "endorser",
+		Name:         "shard_errors",
+		Help:         "The number of proposals that got no valid proof from a shard, by type of error.",
+		LabelNames:   []string{"channel", "chaincode", "shard", "type"},
+		StatsdFormat: "%{#fqname}.%{channel}.%{chaincode}.%{shard}.%{type}",
+	}
+
+	// Response cache metrics
+	responseCacheHitsCounterOpts = metrics.CounterOpts{
+		Namespace:    "endorser",
+		Name:         "response_cache_hits",
+		Help:         "The number of read-only proposals endorsed from a cached simulation.",
+		LabelNames:   []string{"channel", "chaincode"},
+		StatsdFormat: "%{#fqname}.%{channel}.%{chaincode}",
+	}
+
+	responseCacheInvalidationsCounterOpts = metrics.CounterOpts{
+		Namespace: "endorser",
+		Name:      "response_cache_invalidations",
+		Help:      "The number of cached simulations dropped because keys they read were written.",
+	}
+
+	idempotentReplaysCounterOpts = metrics.CounterOpts{
+		Namespace:    "endorser",
+		Name:         "idempotent_replays",
+		Help:         "The number of proposals answered with the endorsement kept for their idempotency key.",
+		LabelNames:   []string{"channel", "chaincode"},
+		StatsdFormat: "%{#fqname}.%{channel}.%{chaincode}",
+	}
+	recoveredPreparesCounterOpts = metrics.CounterOpts{
+		Namespace:    "endorser",
+		Name:         "recovered_prepares",
+		Help:         "The number of prepares left in flight by a restart that were already endorsed, resubscribed, aborted or failed to abort.",
+		LabelNames:   []string{"outcome"},
+		StatsdFormat: "%{#fqname}.%{outcome}",
+	}
 )
 
 // Metrics contains all the metrics for the endorser
@@ -125,6 +330,11 @@ type Metrics struct {
 	SuccessfulProposals      metrics.Counter
 	ProposalValidationFailed metrics.Counter
 	ProposalACLCheckFailed   metrics.Counter
+	ProposalsRateLimited     metrics.Counter
+	StaleProposals           metrics.Counter
+	MisdirectedProposals     metrics.Counter
+	DependencyWaits          metrics.Counter
+	OracleReviews            metrics.Counter
 	InitFailed               metrics.Counter
 	EndorsementsFailed       metrics.Counter
 	DuplicateTxsFailure      metrics.Counter
@@ -139,6 +349,32 @@ type Metrics struct {
 	LeaderCircuitBreakerOpen     metrics.Counter
 	LeaderCircuitBreakerHalfOpen metrics.Counter
 	LeaderCircuitBreakerClosed   metrics.Counter
+	ShardCircuitBreakerOpen      metrics.Counter
+	ShardCircuitBreakerHalfOpen  metrics.Counter
+	ShardCircuitBreakerClosed    metrics.Counter
+	LeaderFailovers              metrics.Counter
+
+	// Shard prepare metrics
+	ShardPrepareRetries   metrics.Counter
+	ShardPrepareFailures  metrics.Counter
+	ShardPrepareHedges    metrics.Counter
+	ShardPrepareHedgeWins metrics.Counter
+	PrepareLaneWait       metrics.Histogram
+	PrepareBatchSize      metrics.Histogram
+	ProposalBatchSize     metrics.Histogram
+
+	// Dependency pipeline metrics
+	ShardPrepareDuration      metrics.Histogram
+	ProofVerificationDuration metrics.Histogram
+	ShardDependencyChecks     metrics.Counter
+	DependencyConflicts       metrics.Counter
+	ShardErrors               metrics.Counter
+
+	// Response cache metrics
+	ResponseCacheHits          metrics.Counter
+	ResponseCacheInvalidations metrics.Counter
+	IdempotentReplays          metrics.Counter
+	RecoveredPrepares          metrics.Counter
 }
 
 // NewMetrics creates a new Metrics instance
@@ -149,6 +385,11 @@ func NewMetrics(provider metrics.Provider) *Metrics {
 		SuccessfulProposals:      provider.NewCounter(successfulProposalsCounterOpts),
 		ProposalValidationFailed: provider.NewCounter(proposalValidationFailureCounterOpts),
 		ProposalACLCheckFailed:   provider.NewCounter(proposalChannelACLFailureOpts),
+		ProposalsRateLimited:     provider.NewCounter(proposalsRateLimitedCounterOpts),
+		StaleProposals:           provider.NewCounter(staleProposalsCounterOpts),
+		MisdirectedProposals:     provider.NewCounter(misdirectedProposalsCounterOpts),
+		DependencyWaits:          provider.NewCounter(dependencyWaitsCounterOpts),
+		OracleReviews:            provider.NewCounter(oracleReviewsCounterOpts),
 		InitFailed:               provider.NewCounter(initFailureCounterOpts),
 		EndorsementsFailed:       provider.NewCounter(endorsementFailureCounterOpts),
 		DuplicateTxsFailure:      provider.NewCounter(duplicateTxsFailureCounterOpts),
@@ -163,5 +404,187 @@ func NewMetrics(provider metrics.Provider) *Metrics {
 		LeaderCircuitBreakerOpen:     provider.NewCounter(leaderCircuitBreakerOpenCounterOpts),
 		LeaderCircuitBreakerHalfOpen: provider.NewCounter(leaderCircuitBreakerHalfOpenCounterOpts),
 		LeaderCircuitBreakerClosed:   provider.NewCounter(leaderCircuitBreakerClosedCounterOpts),
+		ShardCircuitBreakerOpen:      provider.NewCounter(shardCircuitBreakerOpenCounterOpts),
+		ShardCircuitBreakerHalfOpen:  provider.NewCounter(shardCircuitBreakerHalfOpenCounterOpts),
+		ShardCircuitBreakerClosed:    provider.NewCounter(shardCircuitBreakerClosedCounterOpts),
+		LeaderFailovers:              provider.NewCounter(leaderFailoversCounterOpts),
+
+		// Shard prepare metrics
+		ShardPrepareRetries:   provider.NewCounter(shardPrepareRetriesCounterOpts),
+		ShardPrepareFailures:  provider.NewCounter(shardPrepareFailuresCounterOpts),
+		ShardPrepareHedges:    provider.NewCounter(shardPrepareHedgesCounterOpts),
+		ShardPrepareHedgeWins: provider.NewCounter(shardPrepareHedgeWinsCounterOpts),
+		PrepareLaneWait:       provider.NewHistogram(prepareLaneWaitHistogramOpts),
+		PrepareBatchSize:      provider.NewHistogram(prepareBatchSizeHistogramOpts),
+		ProposalBatchSize:     provider.NewHistogram(proposalBatchSizeHistogramOpts),
+
+		// Dependency pipeline metrics
+		ShardPrepareDuration:      provider.NewHistogram(shardPrepareDurationHistogramOpts),
+		ProofVerificationDuration: provider.NewHistogram(proofVerificationDurationHistogramOpts),
+		ShardDependencyChecks:     provider.NewCounter(shardDependencyChecksCounterOpts),
+		DependencyConflicts:       provider.NewCounter(dependencyConflictsCounterOpts),
+		ShardErrors:               provider.NewCounter(shardErrorsCounterOpts),
+
+		// Response cache metrics
+		ResponseCacheHits:          provider.NewCounter(responseCacheHitsCounterOpts),
+		ResponseCacheInvalidations: provider.NewCounter(responseCacheInvalidationsCounterOpts),
+		IdempotentReplays:          provider.NewCounter(idempotentReplaysCounterOpts),
+		RecoveredPrepares:          provider.NewCounter(recoveredPreparesCounterOpts),
+	}
+}
+
+// PrepareHedged implements sharding.HedgeMetrics
+func (m *Metrics) PrepareHedged(shardID string) {
+	if m != nil && m.ShardPrepareHedges != nil {
+		m.ShardPrepareHedges.With("shard", shardID).Add(1)
+	}
+}
+
+// HedgeWon implements sharding.HedgeMetrics
+func (m *Metrics) HedgeWon(shardID string) {
+	if m != nil && m.ShardPrepareHedgeWins != nil {
+		m.ShardPrepareHedgeWins.With("shard", shardID).Add(1)
+	}
+}
+
+// countResponseCacheHit counts a proposal endorsed from a cached simulation
+func (m *Metrics) countResponseCacheHit(channel, chaincode string) {
+	if m != nil && m.ResponseCacheHits != nil {
+		m.ResponseCacheHits.With("channel", channel, "chaincode", chaincode).Add(1)
+	}
+}
+
+// countResponseCacheInvalidations counts dropped cached simulations
+func (m *Metrics) countResponseCacheInvalidations(n int) {
+	if m != nil && m.ResponseCacheInvalidations != nil && n > 0 {
+		m.ResponseCacheInvalidations.Add(float64(n))
+	}
+}
+
+// countIdempotentReplay counts a proposal answered with the endorsement kept
+// for its idempotency key
+func (m *Metrics) countIdempotentReplay(channel, chaincode string) {
+	if m != nil && m.IdempotentReplays != nil {
+		m.IdempotentReplays.With("channel", channel, "chaincode", chaincode).Add(1)
+	}
+}
+
+// countRecoveredPrepares counts the prepares recovered after a restart with
+// the outcome
+func (m *Metrics) countRecoveredPrepares(outcome string, n int) {
+	if m != nil && m.RecoveredPrepares != nil && n > 0 {
+		m.RecoveredPrepares.With("outcome", outcome).Add(float64(n))
+	}
+}
+
+// observePrepareLaneWait records the wait of a prepare for a slot
+func (m *Metrics) observePrepareLaneWait(lane PrepareLane, wait time.Duration) {
+	if m != nil && m.PrepareLaneWait != nil {
+		m.PrepareLaneWait.With("lane", lane.String()).Observe(wait.Seconds())
+	}
+}
+
+// observePrepareBatchSize records the number of prepares submitted to a
+// shard in one batch
+func (m *Metrics) observePrepareBatchSize(shardID string, size int) {
+	if m != nil && m.PrepareBatchSize != nil {
+		m.PrepareBatchSize.With("shard", shardID).Observe(float64(size))
+	}
+}
+
+// observeProposalBatchSize records the number of proposals received in one
+// batch
+func (m *Metrics) observeProposalBatchSize(size int) {
+	if m != nil && m.ProposalBatchSize != nil {
+		m.ProposalBatchSize.Observe(float64(size))
+	}
+}
+
+// shardLabels labels a metric of a shard with the channel of the proposal and
+// the chaincode whose keys the shard tracks, followed by extra
+func shardLabels(channel, shardID string, extra ...string) []string {
+	return append([]string{"channel", channel, "chaincode", sharding.ContractOfShard(shardID), "shard", shardID}, extra...)
+}
+
+// observeShardPrepare records how long gathering the proof of a shard took
+func (m *Metrics) observeShardPrepare(channel, shardID string, success bool, d time.Duration) {
+	if m != nil && m.ShardPrepareDuration != nil {
+		m.ShardPrepareDuration.With(shardLabels(channel, shardID, "success", strconv.FormatBool(success))...).Observe(d.Seconds())
+	}
+}
+
+// observeProofVerification records how long verifying a proof took
+func (m *Metrics) observeProofVerification(channel, shardID string, d time.Duration) {
+	if m != nil && m.ProofVerificationDuration != nil {
+		m.ProofVerificationDuration.With(shardLabels(channel, shardID)...).Observe(d.Seconds())
+	}
+}
+
+// countDependencyCheck counts a proof gathered from a shard
+func (m *Metrics) countDependencyCheck(channel, shardID string, hasDependency bool) {
+	if m != nil && m.ShardDependencyChecks != nil {
+		m.ShardDependencyChecks.With(shardLabels(channel, shardID, "hasDependency", strconv.FormatBool(hasDependency))...).Add(1)
+	}
+}
+
+// countStaleProposal counts a proposal refused for reading stale versions
+func (m *Metrics) countStaleProposal(channel, chaincode string) {
+	if m != nil && m.StaleProposals != nil {
+		m.StaleProposals.With("channel", channel, "chaincode", chaincode).Add(1)
+	}
+}
+
+// countMisdirectedProposal counts a proposal refused because another
+// endorser leads its chaincode
+func (m *Metrics) countMisdirectedProposal(channel, chaincode string) {
+	if m != nil && m.MisdirectedProposals != nil {
+		m.MisdirectedProposals.With("channel", channel, "chaincode", chaincode).Add(1)
+	}
+}
+
+// countDependencyWait counts a proposal held for its dependencies, by the
+// outcome of the wait
+func (m *Metrics) countDependencyWait(channel, chaincode, outcome string) {
+	if m != nil && m.DependencyWaits != nil {
+		m.DependencyWaits.With("channel", channel, "chaincode", chaincode, "outcome", outcome).Add(1)
+	}
+}
+
+// countOracleReview counts a proposal reviewed by the conflict oracle, by the
+// outcome of the review
+func (m *Metrics) countOracleReview(channel, chaincode, outcome string) {
+	if m != nil && m.OracleReviews != nil {
+		m.OracleReviews.With("channel", channel, "chaincode", chaincode, "outcome", outcome).Add(1)
+	}
+}
+
+// countConflicts counts the conflicts of each type with the transactions a
+// shard reported as dependencies
+func (m *Metrics) countConflicts(channel, shardID string, types map[string][]sharding.ConflictType) {
+	if m == nil || m.DependencyConflicts == nil {
+		return
+	}
+	for _, txTypes := range types {
+		for _, conflictType := range txTypes {
+			m.DependencyConflicts.With(shardLabels(channel, shardID, "type", string(conflictType))...).Add(1)
+		}
+	}
+}
+
+// countShardError counts a proposal that got no valid proof from a shard
+func (m *Metrics) countShardError(channel, shardID, errorType string) {
+	if m != nil && m.ShardErrors != nil {
+		m.ShardErrors.With(shardLabels(channel, shardID, "type", errorType)...).Add(1)
+	}
+}
+
+// setDependencyMapSizes reports the number of reservations held by each shard
+// replica
+func (m *Metrics) setDependencyMapSizes(sizes map[string]int) {
+	if m == nil || m.DependencyMapSize == nil {
+		return
+	}
+	for shardID, size := range sizes {
+		m.DependencyMapSize.With("channel", sharding.ChannelOfShard(shardID), "chaincode", sharding.ContractOfShard(shardID), "shard", shardID).Set(float64(size))
 	}
 }
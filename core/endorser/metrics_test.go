@@ -7,9 +7,14 @@ SPDX-License-Identifier: Apache-2.0
 package endorser
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/hyperledger/fabric/common/metrics/metricsfakes"
+	"github.com/hyperledger/fabric/core/endorser/sharding"
 	. "github.com/onsi/gomega"
 )
 
@@ -50,3 +55,123 @@ func TestNewMetrics(t *testing.T) {
 		{simulationFailureCounterOpts},
 	}))
 }
+
+// pipelineStore answers the prepares of each shard as named: fabcar finds a
+// dependency, marbles rejects the transaction, tokens returns an unsigned
+// proof and supply fails
+type pipelineStore struct{}
+
+func (pipelineStore) Prepare(ctx context.Context, req *sharding.PrepareRequest) (*sharding.PrepareProof, error) {
+	proof := &sharding.PrepareProof{
+		TxID:      req.TxID,
+		ShardID:   req.ShardID,
+		Signature: []byte(fmt.Sprintf("%s:%d:%s", req.ShardID, 0, req.TxID)),
+	}
+	switch req.ShardID {
+	case "fabcar":
+		proof.HasDependency, proof.DependentTxID = true, "tx0"
+		proof.ConflictTypes = map[string][]sharding.ConflictType{"tx0": {sharding.ConflictWriteWrite, sharding.ConflictReadWrite}}
+	case "marbles":
+		proof.Rejected = true
+	case "tokens":
+		proof.Signature = nil
+	case "supply":
+		return nil, errors.New("shard is stopped")
+	}
+	return proof, nil
+}
+
+func (pipelineStore) Abort(shardID, txID string) error { return nil }
+
+func TestDependencyPipelineMetrics(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	prepares := &metricsfakes.Histogram{}
+	prepares.WithReturns(prepares)
+	verifications := &metricsfakes.Histogram{}
+	verifications.WithReturns(verifications)
+	checks := &metricsfakes.Counter{}
+	checks.WithReturns(checks)
+	conflicts := &metricsfakes.Counter{}
+	conflicts.WithReturns(conflicts)
+	shardErrors := &metricsfakes.Counter{}
+	shardErrors.WithReturns(shardErrors)
+	e := &Endorser{
+		Config: EndorserConfig{PrepareTimeout: time.Second},
+		Metrics: &Metrics{
+			ShardPrepareDuration:      prepares,
+			ProofVerificationDuration: verifications,
+			ShardDependencyChecks:     checks,
+			DependencyConflicts:       conflicts,
+			ShardErrors:               shardErrors,
+		},
+	}
+
+	shards := map[string]map[string][]byte{}
+	for _, shardID := range []string{"fabcar", "marbles", "tokens", "supply"} {
+		shards[shardID] = map[string][]byte{shardID + ":key": []byte("value")}
+	}
+	_, err := e.resolveDependencies(context.Background(), "mychannel", "tx1", DefaultLane, pipelineStore{}, shards, nil, nil, true, 0)
+	gt.Expect(err).To(HaveOccurred())
+
+	gt.Expect(prepares.ObserveCallCount()).To(Equal(4))
+	var prepareLabels [][]string
+	for i := 0; i < prepares.WithCallCount(); i++ {
+		prepareLabels = append(prepareLabels, prepares.WithArgsForCall(i))
+	}
+	gt.Expect(prepareLabels).To(ContainElements(
+		[]string{"channel", "mychannel", "chaincode", "fabcar", "shard", "fabcar", "success", "true"},
+		[]string{"channel", "mychannel", "chaincode", "supply", "shard", "supply", "success", "false"},
+	))
+	// the failed prepare returned no proof to verify
+	gt.Expect(verifications.ObserveCallCount()).To(Equal(3))
+
+	gt.Expect(checks.AddCallCount()).To(Equal(1))
+	gt.Expect(checks.WithArgsForCall(0)).To(Equal([]string{"channel", "mychannel", "chaincode", "fabcar", "shard", "fabcar", "hasDependency", "true"}))
+
+	gt.Expect(conflicts.AddCallCount()).To(Equal(2))
+	gt.Expect(conflicts.WithArgsForCall(0)).To(Equal([]string{"channel", "mychannel", "chaincode", "fabcar", "shard", "fabcar", "type", "WW"}))
+	gt.Expect(conflicts.WithArgsForCall(1)).To(Equal([]string{"channel", "mychannel", "chaincode", "fabcar", "shard", "fabcar", "type", "RW"}))
+
+	var errorLabels [][]string
+	for i := 0; i < shardErrors.WithCallCount(); i++ {
+		errorLabels = append(errorLabels, shardErrors.WithArgsForCall(i))
+	}
+	gt.Expect(errorLabels).To(ConsistOf(
+		[]string{"channel", "mychannel", "chaincode", "marbles", "shard", "marbles", "type", "rejected"},
+		[]string{"channel", "mychannel", "chaincode", "tokens", "shard", "tokens", "type", "invalid_proof"},
+		[]string{"channel", "mychannel", "chaincode", "supply", "shard", "supply", "type", "failed"},
+	))
+}
+
+func TestReportDependencyMapSizes(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	sizes := &metricsfakes.Gauge{}
+	sizes.WithReturns(sizes)
+	e := &Endorser{Metrics: &Metrics{DependencyMapSize: sizes}}
+
+	total := e.reportDependencyMapSizes(map[string]sharding.ShardStatus{
+		"fabcar":   {Dependencies: 3},
+		"mychannel/marbles0": {Dependencies: 2},
+	})
+	gt.Expect(total).To(Equal(5))
+	gt.Expect(sizes.SetCallCount()).To(Equal(2))
+	channels := map[string]string{}
+	for i := 0; i < 2; i++ {
+		channels[sizes.WithArgsForCall(i)[5]] = sizes.WithArgsForCall(i)[1]
+	}
+	gt.Expect(channels).To(Equal(map[string]string{"fabcar": "", "mychannel/marbles0": "mychannel"}))
+
+	// a stopped shard is reported empty once
+	total = e.reportDependencyMapSizes(map[string]sharding.ShardStatus{"fabcar": {Dependencies: 1}})
+	gt.Expect(total).To(Equal(1))
+	reported := map[string]float64{}
+	for i := 2; i < sizes.SetCallCount(); i++ {
+		reported[sizes.WithArgsForCall(i)[5]] = sizes.SetArgsForCall(i)
+	}
+	gt.Expect(reported).To(Equal(map[string]float64{"fabcar": 1, "mychannel/marbles0": 0}))
+
+	e.reportDependencyMapSizes(map[string]sharding.ShardStatus{"fabcar": {Dependencies: 1}})
+	gt.Expect(sizes.SetCallCount()).To(Equal(5))
+}
@@ -0,0 +1,73 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package endorser
+
+import (
+	"sort"
+
+	"github.com/hyperledger/fabric/core/ledger"
+	"github.com/pkg/errors"
+)
+
+// StatusStaleRead is the status of the response to a proposal refused
+// because its simulation read versions of keys that blocks committed since
+// replaced, so that it would fail MVCC validation. The client may simulate
+// it again right away.
+const StatusStaleRead = 409
+
+// staleReads returns the public keys, as namespace:key, whose committed
+// version differs from the one the simulation read. height is the ledger
+// height before the simulation started, or 0 when unknown; no block was
+// committed since when the ledger is still at that height, so the versions
+// read are current and need not be read again.
+func (e *Endorser) staleReads(channelID, txID string, height uint64, simResult *ledger.TxSimulationResults) ([]string, error) {
+	if simResult == nil || simResult.PubSimulationResults == nil {
+		return nil, nil
+	}
+	if height != 0 {
+		current, err := e.Support.GetLedgerHeight(channelID)
+		if err != nil {
+			return nil, errors.WithMessage(err, "failed to get the ledger height")
+		}
+		if current == height {
+			return nil, nil
+		}
+	}
+
+	read, err := ledger.ReadVersions(simResult)
+	if err != nil || len(read) == 0 {
+		return nil, err
+	}
+	keys := make(map[string][]string, len(read))
+	for namespace, nsKeys := range read {
+		for key := range nsKeys {
+			keys[namespace] = append(keys[namespace], key)
+		}
+	}
+
+	// A new simulator records the versions now committed as it reads them
+	txSim, err := e.Support.GetTxSimulator(channelID, txID)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to get a simulator")
+	}
+	defer txSim.Done()
+	current, err := ledger.CommittedVersions(txSim, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	var stale []string
+	for namespace, nsKeys := range read {
+		for key, version := range nsKeys {
+			if !ledger.SameVersion(version, current[namespace][key]) {
+				stale = append(stale, namespace+":"+key)
+			}
+		}
+	}
+	sort.Strings(stale)
+	return stale, nil
+}
@@ -0,0 +1,99 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package endorser
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-protos-go/ledger/rwset"
+	"github.com/hyperledger/fabric-protos-go/ledger/rwset/kvrwset"
+	"github.com/hyperledger/fabric/core/ledger"
+	. "github.com/onsi/gomega"
+)
+
+// committedState serves the committed versions of the keys of a ledger at a
+// given height; its other methods are not implemented
+type committedState struct {
+	Support
+	height   uint64
+	versions map[string]*kvrwset.Version
+}
+
+func (s *committedState) GetLedgerHeight(string) (uint64, error) { return s.height, nil }
+
+func (s *committedState) GetTxSimulator(string, string) (ledger.TxSimulator, error) {
+	return &versionRecorder{state: s, namespace: map[string][]*kvrwset.KVRead{}}, nil
+}
+
+// versionRecorder records the committed versions of the keys it reads
+type versionRecorder struct {
+	ledger.TxSimulator
+	state     *committedState
+	namespace map[string][]*kvrwset.KVRead
+}
+
+func (r *versionRecorder) GetState(namespace, key string) ([]byte, error) {
+	r.namespace[namespace] = append(r.namespace[namespace], &kvrwset.KVRead{Key: key, Version: r.state.versions[namespace+":"+key]})
+	return nil, nil
+}
+
+func (r *versionRecorder) GetTxSimulationResults() (*ledger.TxSimulationResults, error) {
+	results := &rwset.TxReadWriteSet{}
+	for namespace, reads := range r.namespace {
+		bytes, err := proto.Marshal(&kvrwset.KVRWSet{Reads: reads})
+		if err != nil {
+			return nil, err
+		}
+		results.NsRwset = append(results.NsRwset, &rwset.NsReadWriteSet{Namespace: namespace, Rwset: bytes})
+	}
+	return &ledger.TxSimulationResults{PubSimulationResults: results}, nil
+}
+
+func (r *versionRecorder) Done() {}
+
+func TestStaleReads(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	state := &committedState{
+		height: 5,
+		versions: map[string]*kvrwset.Version{
+			"fabcar:car1": {BlockNum: 4, TxNum: 0},
+			"fabcar:car2": {BlockNum: 2, TxNum: 1},
+		},
+	}
+	e := &Endorser{Support: state}
+
+	bytes, err := proto.Marshal(&kvrwset.KVRWSet{Reads: []*kvrwset.KVRead{
+		{Key: "car1", Version: &kvrwset.Version{BlockNum: 3, TxNum: 0}},
+		{Key: "car2", Version: &kvrwset.Version{BlockNum: 2, TxNum: 1}},
+		{Key: "car3"},
+	}})
+	gt.Expect(err).NotTo(HaveOccurred())
+	simResult := &ledger.TxSimulationResults{PubSimulationResults: &rwset.TxReadWriteSet{
+		NsRwset: []*rwset.NsReadWriteSet{{Namespace: "fabcar", Rwset: bytes}},
+	}}
+
+	// no block was committed since the simulation
+	stale, err := e.staleReads("mychannel", "tx1", 5, simResult)
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(stale).To(BeEmpty())
+
+	stale, err = e.staleReads("mychannel", "tx1", 4, simResult)
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(stale).To(Equal([]string{"fabcar:car1"}))
+
+	// a key created since is stale too
+	state.versions["fabcar:car3"] = &kvrwset.Version{BlockNum: 4, TxNum: 1}
+	stale, err = e.staleReads("mychannel", "tx1", 0, simResult)
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(stale).To(Equal([]string{"fabcar:car1", "fabcar:car3"}))
+
+	stale, err = e.staleReads("mychannel", "tx1", 0, &ledger.TxSimulationResults{})
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(stale).To(BeEmpty())
+}
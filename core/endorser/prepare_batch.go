@@ -0,0 +1,155 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package endorser
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/fabric/core/endorser/sharding"
+	"github.com/pkg/errors"
+)
+
+// DefaultPrepareBatchMaxSize is the number of prepares a batch holds at most
+// when PrepareBatchConfig.MaxSize is unset
+const DefaultPrepareBatchMaxSize = 100
+
+// PrepareBatchConfig gathers the prepares that concurrent proposals send to
+// the same shard within a short window into one submission, which the shard
+// commits in one Raft entry. Only the stores that implement
+// sharding.BatchDependencyStore, such as the embedded Raft shards, take
+// batches.
+type PrepareBatchConfig struct {
+	// Window is how long the first prepare of a batch waits for others to
+	// join it. Prepares are submitted one by one when it is 0.
+	Window time.Duration
+	// MaxSize submits a batch as soon as it holds this many prepares.
+	// Defaults to DefaultPrepareBatchMaxSize.
+	MaxSize int
+}
+
+// maxSize returns the number of prepares a batch holds at most
+func (c PrepareBatchConfig) maxSize() int {
+	if c.MaxSize <= 0 {
+		return DefaultPrepareBatchMaxSize
+	}
+	return c.MaxSize
+}
+
+// prepareBatchKey identifies the batches of prepares on a shard of a store
+type prepareBatchKey struct {
+	store   sharding.BatchDependencyStore
+	shardID string
+}
+
+// prepareResult is the outcome of a prepare submitted in a batch
+type prepareResult struct {
+	proof *sharding.PrepareProof
+	err   error
+}
+
+// prepareBatch holds the prepares waiting for their batch to be submitted,
+// and the channels their results are delivered on
+type prepareBatch struct {
+	reqs     []*sharding.PrepareRequest
+	resultCs []chan prepareResult
+	timer    *time.Timer
+}
+
+// prepareBatches holds the batch open for each shard
+type prepareBatches struct {
+	mu   sync.Mutex
+	open map[prepareBatchKey]*prepareBatch
+}
+
+// prepareBatched adds the request to the batch open for its shard, opening
+// one if there is none, and waits for its proof or until ctx is done. A
+// batch is submitted once its window passed or it is full.
+func (e *Endorser) prepareBatched(ctx context.Context, store sharding.BatchDependencyStore, req *sharding.PrepareRequest) (*sharding.PrepareProof, error) {
+	key := prepareBatchKey{store: store, shardID: req.ShardID}
+	resultC := make(chan prepareResult, 1)
+
+	b := &e.batches
+	b.mu.Lock()
+	if b.open == nil {
+		b.open = make(map[prepareBatchKey]*prepareBatch)
+	}
+	batch, ok := b.open[key]
+	if !ok {
+		batch = &prepareBatch{}
+		b.open[key] = batch
+		batch.timer = time.AfterFunc(e.Config.PrepareBatch.Window, func() {
+			e.submitPrepareBatch(key, batch)
+		})
+	}
+	batch.reqs = append(batch.reqs, req)
+	batch.resultCs = append(batch.resultCs, resultC)
+	// A full batch is closed right away, and submitted unless its window
+	// just passed
+	submit := false
+	if len(batch.reqs) >= e.Config.PrepareBatch.maxSize() {
+		delete(b.open, key)
+		submit = batch.timer.Stop()
+	}
+	b.mu.Unlock()
+
+	if submit {
+		go e.submitPrepareBatch(key, batch)
+	}
+
+	select {
+	case res := <-resultC:
+		return res.proof, res.err
+	case <-ctx.Done():
+		return nil, errors.WithMessagef(ctx.Err(), "no proof from shard %s for tx %s", req.ShardID, req.TxID)
+	}
+}
+
+// submitPrepareBatch closes the batch, prepares its requests in one
+// submission and delivers each request its own proof
+func (e *Endorser) submitPrepareBatch(key prepareBatchKey, batch *prepareBatch) {
+	// No prepare joins the batch once it is closed
+	b := &e.batches
+	b.mu.Lock()
+	if b.open[key] == batch {
+		delete(b.open, key)
+	}
+	reqs, resultCs := batch.reqs, batch.resultCs
+	b.mu.Unlock()
+
+	e.Metrics.observePrepareBatchSize(key.shardID, len(reqs))
+	ctx, cancel := context.WithTimeout(context.Background(), e.Config.prepareTimeout())
+	defer cancel()
+	proofs, err := key.store.PrepareBatch(ctx, key.shardID, reqs)
+	if err == nil && len(proofs) != len(reqs) {
+		err = errors.Errorf("shard %s returned %d proofs for a batch of %d prepares", key.shardID, len(proofs), len(reqs))
+	}
+	for i, resultC := range resultCs {
+		if err != nil {
+			resultC <- prepareResult{err: err}
+			continue
+		}
+		resultC <- prepareResult{proof: proofs[i]}
+	}
+}
+
+// submitPrepare prepares the request on its shard, in a batch with the
+// prepares of other proposals when the proposal came in a batch, or when
+// PrepareBatch has a window, and the store takes batches
+func (e *Endorser) submitPrepare(ctx context.Context, store sharding.DependencyStore, req *sharding.PrepareRequest) (*sharding.PrepareProof, error) {
+	if p := batchedPrepareFrom(ctx); p != nil {
+		if proof, queued, err := p.submit(ctx, store, req); queued {
+			return proof, err
+		}
+	}
+	batchStore, ok := store.(sharding.BatchDependencyStore)
+	if e.Config.PrepareBatch.Window <= 0 || !ok {
+		return store.Prepare(ctx, req)
+	}
+	return e.prepareBatched(ctx, batchStore, req)
+}
@@ -0,0 +1,121 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package endorser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/endorser/sharding"
+	. "github.com/onsi/gomega"
+)
+
+// batchingStore records the batches it prepares, and fails them with err when
+// it is set
+type batchingStore struct {
+	flakyStore
+	mu      sync.Mutex
+	batches [][]string
+	err     error
+}
+
+func (s *batchingStore) PrepareBatch(ctx context.Context, shardID string, reqs []*sharding.PrepareRequest) ([]*sharding.PrepareProof, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	txIDs := make([]string, len(reqs))
+	proofs := make([]*sharding.PrepareProof, len(reqs))
+	for i, req := range reqs {
+		txIDs[i] = req.TxID
+		index := uint64(len(s.batches) + 1)
+		proofs[i] = &sharding.PrepareProof{
+			TxID:        req.TxID,
+			ShardID:     shardID,
+			CommitIndex: index,
+			Signature:   []byte(fmt.Sprintf("%s:%d:%s", shardID, index, req.TxID)),
+		}
+	}
+	s.batches = append(s.batches, txIDs)
+	return proofs, nil
+}
+
+func (s *batchingStore) batchSizes() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var sizes []int
+	for _, batch := range s.batches {
+		sizes = append(sizes, len(batch))
+	}
+	return sizes
+}
+
+func TestSubmitPrepareBatched(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	store := &batchingStore{}
+	e := &Endorser{Config: EndorserConfig{PrepareBatch: PrepareBatchConfig{Window: 500 * time.Millisecond, MaxSize: 3}}}
+
+	prepare := func(n int) []*sharding.PrepareProof {
+		proofs := make([]*sharding.PrepareProof, n)
+		var wg sync.WaitGroup
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				proof, err := e.submitPrepare(context.Background(), store, &sharding.PrepareRequest{TxID: fmt.Sprintf("tx%d", i), ShardID: "fabcar"})
+				gt.Expect(err).NotTo(HaveOccurred())
+				proofs[i] = proof
+			}(i)
+		}
+		wg.Wait()
+		return proofs
+	}
+
+	// the prepares are submitted together, and each gets its own proof
+	proofs := prepare(2)
+	gt.Expect(store.batchSizes()).To(Equal([]int{2}))
+	gt.Expect(proofs[0].TxID).To(Equal("tx0"))
+	gt.Expect(proofs[1].TxID).To(Equal("tx1"))
+
+	// a full batch is submitted before its window passes
+	start := time.Now()
+	prepare(3)
+	gt.Expect(time.Since(start)).To(BeNumerically("<", 500*time.Millisecond))
+	gt.Expect(store.batchSizes()).To(Equal([]int{2, 3}))
+
+	store.err = errors.New("shard is stopped")
+	_, err := e.submitPrepare(context.Background(), store, &sharding.PrepareRequest{TxID: "tx9", ShardID: "fabcar"})
+	gt.Expect(err).To(MatchError("shard is stopped"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = e.submitPrepare(ctx, store, &sharding.PrepareRequest{TxID: "tx10", ShardID: "fabcar"})
+	gt.Expect(err).To(MatchError("no proof from shard fabcar for tx tx10: context canceled"))
+}
+
+func TestSubmitPrepareUnbatched(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	// without a window the prepares are submitted one by one
+	store := &batchingStore{}
+	e := &Endorser{}
+	proof, err := e.submitPrepare(context.Background(), store, &sharding.PrepareRequest{TxID: "tx1", ShardID: "fabcar"})
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(proof.TxID).To(Equal("tx1"))
+	gt.Expect(store.batchSizes()).To(BeEmpty())
+
+	// as they are to the stores that take no batch
+	e.Config.PrepareBatch.Window = time.Second
+	_, err = e.submitPrepare(context.Background(), &flakyStore{}, &sharding.PrepareRequest{TxID: "tx2", ShardID: "fabcar"})
+	gt.Expect(err).NotTo(HaveOccurred())
+}
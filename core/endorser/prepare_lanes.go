@@ -0,0 +1,169 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package endorser
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/fabric/core/endorser/sharding"
+	"github.com/hyperledger/fabric/protoutil"
+	"github.com/pkg/errors"
+)
+
+// PrepareLane is the priority class of the prepares of a proposal. While the
+// prepares in flight are capped, the waiting prepares of a lane are started
+// before those of the lanes after it.
+type PrepareLane int
+
+const (
+	// SystemLane serves the proposals for system chaincodes
+	SystemLane PrepareLane = iota
+	// OperatorLane serves the proposals of operator clients
+	OperatorLane
+	// DefaultLane serves all other proposals
+	DefaultLane
+
+	numPrepareLanes = int(DefaultLane) + 1
+)
+
+func (l PrepareLane) String() string {
+	switch l {
+	case SystemLane:
+		return "system"
+	case OperatorLane:
+		return "operator"
+	default:
+		return "default"
+	}
+}
+
+// PrepareLanesConfig caps the prepares on the shards that an endorser runs at
+// once, so that a flood of proposals queues up in the endorser, where the
+// proposals of the system and operator lanes overtake it, rather than in the
+// shards' propose queues
+type PrepareLanesConfig struct {
+	// Concurrency is the number of prepares in flight at most. The prepares
+	// are neither capped nor prioritized when it is 0.
+	Concurrency int
+	// Operators lists the MSP IDs whose clients take the operator lane
+	Operators []string
+	// SystemChaincodes lists the chaincodes, besides the system chaincodes
+	// of Fabric, that take the system lane
+	SystemChaincodes []string
+}
+
+// prepareLanes hands out the slots of the prepares in flight, by lane and in
+// order of arrival within a lane
+type prepareLanes struct {
+	mu       sync.Mutex
+	inFlight int
+	waiting  [numPrepareLanes][]chan struct{}
+}
+
+// acquire waits until a prepare of the lane may start, or until ctx is done
+func (l *prepareLanes) acquire(ctx context.Context, lane PrepareLane, limit int) error {
+	l.mu.Lock()
+	if l.inFlight < limit {
+		l.inFlight++
+		l.mu.Unlock()
+		return nil
+	}
+	slot := make(chan struct{})
+	l.waiting[lane] = append(l.waiting[lane], slot)
+	l.mu.Unlock()
+
+	select {
+	case <-slot:
+		return nil
+	case <-ctx.Done():
+	}
+
+	l.mu.Lock()
+	for i, s := range l.waiting[lane] {
+		if s == slot {
+			l.waiting[lane] = append(l.waiting[lane][:i], l.waiting[lane][i+1:]...)
+			l.mu.Unlock()
+			return ctx.Err()
+		}
+	}
+	l.mu.Unlock()
+	// The slot was handed over as ctx ended
+	l.release()
+	return ctx.Err()
+}
+
+// release hands the slot of a finished prepare over to the first waiting
+// prepare of the highest lane, or frees it
+func (l *prepareLanes) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for lane := range l.waiting {
+		if len(l.waiting[lane]) > 0 {
+			slot := l.waiting[lane][0]
+			l.waiting[lane] = l.waiting[lane][1:]
+			close(slot)
+			return
+		}
+	}
+	l.inFlight--
+}
+
+// prepareLane returns the lane of the proposal
+func (e *Endorser) prepareLane(up *UnpackedProposal) PrepareLane {
+	conf := e.Config.PrepareLanes
+	if e.Support.IsSysCC(up.ChaincodeName) {
+		return SystemLane
+	}
+	for _, cc := range conf.SystemChaincodes {
+		if cc == up.ChaincodeName {
+			return SystemLane
+		}
+	}
+	if len(conf.Operators) > 0 {
+		if id, err := protoutil.UnmarshalSerializedIdentity(up.SignatureHeader.Creator); err == nil {
+			for _, mspID := range conf.Operators {
+				if mspID == id.Mspid {
+					return OperatorLane
+				}
+			}
+		}
+	}
+	return DefaultLane
+}
+
+// prepareInLane prepares the request on its shard once the lane has a slot.
+// The wait for the slot is bounded by the prepare timeout.
+func (e *Endorser) prepareInLane(ctx context.Context, channel string, lane PrepareLane, store sharding.DependencyStore, req *sharding.PrepareRequest) (*sharding.PrepareProof, error) {
+	// The prepares of a proposal batch are submitted together, so they
+	// take no slot, which would hold back the rest of the batch
+	limit := e.Config.PrepareLanes.Concurrency
+	if limit <= 0 || batchedPrepareFrom(ctx) != nil {
+		return e.prepareWithRetry(ctx, channel, store, req)
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, e.Config.prepareTimeout())
+	start := time.Now()
+	err := e.lanes.acquire(waitCtx, lane, limit)
+	cancel()
+	e.Metrics.observePrepareLaneWait(lane, time.Since(start))
+	if err != nil {
+		reason := prepareFailureTimeout
+		if ctx.Err() != nil {
+			reason = prepareFailureCanceled
+		}
+		e.countPrepareFailure(req.ShardID, reason)
+		err = errors.Errorf("no prepare slot for tx %s on shard %s in the %s lane: %s", req.TxID, req.ShardID, lane, err)
+		if reason == prepareFailureTimeout {
+			return nil, &ShardError{Cause: ShardQueueFull, Err: err}
+		}
+		return nil, err
+	}
+	defer e.lanes.release()
+	return e.prepareWithRetry(ctx, channel, store, req)
+}
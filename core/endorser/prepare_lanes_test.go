@@ -0,0 +1,100 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package endorser
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	cb "github.com/hyperledger/fabric-protos-go/common"
+	mspproto "github.com/hyperledger/fabric-protos-go/msp"
+	"github.com/hyperledger/fabric/core/endorser/sharding"
+	"github.com/hyperledger/fabric/protoutil"
+	. "github.com/onsi/gomega"
+)
+
+func TestPrepareLanes(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	l := &prepareLanes{}
+	gt.Expect(l.acquire(context.Background(), DefaultLane, 1)).To(Succeed())
+
+	// the waiting prepares start by lane, then in order of arrival
+	started := make(chan string, 3)
+	wait := func(name string, lane PrepareLane) {
+		go func() {
+			if l.acquire(context.Background(), lane, 1) == nil {
+				started <- name
+			}
+		}()
+		gt.Eventually(func() int {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			return len(l.waiting[lane])
+		}).Should(BeNumerically(">", 0))
+	}
+	wait("default", DefaultLane)
+	wait("operator", OperatorLane)
+	wait("system", SystemLane)
+
+	for _, name := range []string{"system", "operator", "default"} {
+		gt.Consistently(started).ShouldNot(Receive())
+		l.release()
+		gt.Eventually(started).Should(Receive(Equal(name)))
+	}
+
+	// a prepare giving up leaves the queue
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	gt.Expect(l.acquire(ctx, SystemLane, 1)).To(MatchError(context.DeadlineExceeded))
+	gt.Expect(l.waiting[SystemLane]).To(BeEmpty())
+
+	l.release()
+	gt.Expect(l.inFlight).To(Equal(0))
+}
+
+func TestPrepareLane(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	proposal := func(chaincode, mspID string) *UnpackedProposal {
+		creator := protoutil.MarshalOrPanic(&mspproto.SerializedIdentity{Mspid: mspID, IdBytes: []byte("cert")})
+		return &UnpackedProposal{ChaincodeName: chaincode, SignatureHeader: &cb.SignatureHeader{Creator: creator}}
+	}
+
+	e := &Endorser{
+		Support: noSysCCSupport{},
+		Config: EndorserConfig{PrepareLanes: PrepareLanesConfig{
+			Operators:        []string{"AdminMSP"},
+			SystemChaincodes: []string{"governance"},
+		}},
+	}
+	gt.Expect(e.prepareLane(proposal("governance", "Org1MSP"))).To(Equal(SystemLane))
+	gt.Expect(e.prepareLane(proposal("fabcar", "AdminMSP"))).To(Equal(OperatorLane))
+	gt.Expect(e.prepareLane(proposal("fabcar", "Org1MSP"))).To(Equal(DefaultLane))
+	gt.Expect(DefaultLane.String()).To(Equal("default"))
+}
+
+func TestPrepareInLane(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	e := &Endorser{Config: EndorserConfig{
+		PrepareTimeout: 20 * time.Millisecond,
+		PrepareLanes:   PrepareLanesConfig{Concurrency: 1},
+	}}
+	req := &sharding.PrepareRequest{TxID: "tx1", ShardID: "fabcar"}
+
+	proof, err := e.prepareInLane(context.Background(), "mychannel", DefaultLane, &flakyStore{}, req)
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(proof.TxID).To(Equal("tx1"))
+	gt.Expect(e.lanes.inFlight).To(Equal(0))
+
+	// while the only slot is taken, the prepare gives up after the timeout
+	gt.Expect(e.lanes.acquire(context.Background(), DefaultLane, 1)).To(Succeed())
+	_, err = e.prepareInLane(context.Background(), "mychannel", SystemLane, &flakyStore{}, req)
+	gt.Expect(err).To(MatchError(ContainSubstring("no prepare slot for tx tx1 on shard fabcar in the system lane")))
+}
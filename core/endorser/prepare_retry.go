@@ -0,0 +1,128 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package endorser
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/hyperledger/fabric/core/endorser/sharding"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultPrepareRetryBackoff is the wait before the first retry of a
+	// timed out prepare
+	DefaultPrepareRetryBackoff = 100 * time.Millisecond
+	// DefaultPrepareRetryMaxBackoff caps the wait between retries
+	DefaultPrepareRetryMaxBackoff = 2 * time.Second
+)
+
+// Reasons a prepare on a shard failed, as reported by the
+// shard_prepare_failures metric. Prepares are canceled when the proposal's
+// context is done before the shard answered.
+const (
+	prepareFailureTimeout     = "timeout"
+	prepareFailureError       = "error"
+	prepareFailureUnavailable = "unavailable"
+	prepareFailureCanceled    = "canceled"
+)
+
+// PrepareRetryConfig retries the prepares on a shard that time out. Each
+// attempt is bounded by the PrepareTimeout, and the wait before the n-th
+// retry is Backoff doubled n-1 times, capped at MaxBackoff, of which up to
+// half is random.
+type PrepareRetryConfig struct {
+	// Attempts is the number of retries after the first prepare timed out.
+	// Timeouts are not retried when it is 0.
+	Attempts int
+	// Backoff defaults to DefaultPrepareRetryBackoff
+	Backoff time.Duration
+	// MaxBackoff defaults to DefaultPrepareRetryMaxBackoff
+	MaxBackoff time.Duration
+}
+
+// backoff returns the wait before the given retry, counted from 1
+func (c PrepareRetryConfig) backoff(retry int) time.Duration {
+	base, max := c.Backoff, c.MaxBackoff
+	if base <= 0 {
+		base = DefaultPrepareRetryBackoff
+	}
+	if max <= 0 {
+		max = DefaultPrepareRetryMaxBackoff
+	}
+	wait := base << uint(retry-1)
+	if wait > max || wait <= 0 {
+		wait = max
+	}
+	// Jitter keeps the endorsers that timed out together from retrying
+	// together
+	half := wait / 2
+	return half + time.Duration(rand.Int63n(int64(wait-half)+1))
+}
+
+// prepareWithRetry gathers the proof of the request's shard, retrying the
+// attempts that time out as configured. Prepares are idempotent, since a
+// shard answers a transaction it already prepared with the same proof.
+// Failures other than timeouts are not retried, nor is anything once ctx is
+// done, e.g. when the client's deadline passed.
+func (e *Endorser) prepareWithRetry(ctx context.Context, channel string, store sharding.DependencyStore, req *sharding.PrepareRequest) (*sharding.PrepareProof, error) {
+	retry := e.Config.PrepareRetry
+	for attempt := 0; ; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, e.Config.prepareTimeout())
+		proof, err := e.prepareOnShard(attemptCtx, channel, store, req)
+		timedOut := err != nil && attemptCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil
+		cancel()
+
+		switch {
+		case err == nil:
+			return proof, nil
+		case ctx.Err() != nil:
+			e.countPrepareFailure(req.ShardID, prepareFailureCanceled)
+			return nil, errors.WithMessagef(err, "proposal %s", ctx.Err())
+		case errors.Is(err, ErrCircuitOpen):
+			e.countPrepareFailure(req.ShardID, prepareFailureUnavailable)
+			return nil, err
+		case !timedOut:
+			e.countPrepareFailure(req.ShardID, prepareFailureError)
+			if errors.Is(err, sharding.ErrQueueFull) {
+				return nil, &ShardError{Cause: ShardQueueFull, Err: err}
+			}
+			return nil, err
+		case attempt >= retry.Attempts:
+			e.countPrepareFailure(req.ShardID, prepareFailureTimeout)
+			if attempt > 0 {
+				err = errors.WithMessagef(err, "gave up on shard %s after %d attempts", req.ShardID, attempt+1)
+			}
+			// A shard whose queue stayed full for the whole attempt
+			// failed it with ErrQueueFull
+			if errors.Is(err, sharding.ErrQueueFull) {
+				return nil, &ShardError{Cause: ShardQueueFull, Err: err}
+			}
+			return nil, &ShardError{Cause: ShardTimeout, Err: err}
+		}
+
+		wait := retry.backoff(attempt + 1)
+		logger.Debugf("Prepare of tx %s on shard %s timed out, retrying in %s", req.TxID, req.ShardID, wait)
+		if e.Metrics != nil && e.Metrics.ShardPrepareRetries != nil {
+			e.Metrics.ShardPrepareRetries.With("shard", req.ShardID).Add(1)
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			e.countPrepareFailure(req.ShardID, prepareFailureCanceled)
+			return nil, errors.WithMessagef(err, "proposal %s after %d attempts", ctx.Err(), attempt+1)
+		}
+	}
+}
+
+func (e *Endorser) countPrepareFailure(shardID, reason string) {
+	if e.Metrics != nil && e.Metrics.ShardPrepareFailures != nil {
+		e.Metrics.ShardPrepareFailures.With("shard", shardID, "reason", reason).Add(1)
+	}
+}
@@ -0,0 +1,170 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package endorser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/common/metrics/metricsfakes"
+	"github.com/hyperledger/fabric/core/endorser/sharding"
+	. "github.com/onsi/gomega"
+)
+
+// flakyStore times out the first timeouts prepares and then answers with a
+// proof, or fails every prepare with err when it is set
+type flakyStore struct {
+	timeouts int32
+	err      error
+	calls    int32
+}
+
+func (s *flakyStore) Prepare(ctx context.Context, req *sharding.PrepareRequest) (*sharding.PrepareProof, error) {
+	if atomic.AddInt32(&s.calls, 1) <= s.timeouts {
+		<-ctx.Done()
+		return nil, fmt.Errorf("timeout waiting for proof from shard %s", req.ShardID)
+	}
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &sharding.PrepareProof{
+		TxID:      req.TxID,
+		ShardID:   req.ShardID,
+		Signature: []byte(fmt.Sprintf("%s:%d:%s", req.ShardID, 0, req.TxID)),
+	}, nil
+}
+
+func (s *flakyStore) Abort(shardID, txID string) error { return nil }
+
+// recordingStore records the requests it answers
+type recordingStore struct {
+	flakyStore
+	mu   sync.Mutex
+	reqs []*sharding.PrepareRequest
+}
+
+func (s *recordingStore) Prepare(ctx context.Context, req *sharding.PrepareRequest) (*sharding.PrepareProof, error) {
+	s.mu.Lock()
+	s.reqs = append(s.reqs, req)
+	s.mu.Unlock()
+	return s.flakyStore.Prepare(ctx, req)
+}
+
+func TestPrepareRetryBackoff(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	c := PrepareRetryConfig{Backoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	for retry, max := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 4: 800 * time.Millisecond, 5: time.Second, 70: time.Second} {
+		wait := c.backoff(retry)
+		gt.Expect(wait).To(BeNumerically(">=", max/2), "retry %d", retry)
+		gt.Expect(wait).To(BeNumerically("<=", max), "retry %d", retry)
+	}
+
+	// the defaults apply when unset
+	gt.Expect(PrepareRetryConfig{}.backoff(1)).To(BeNumerically("<=", DefaultPrepareRetryBackoff))
+	gt.Expect(PrepareRetryConfig{}.backoff(30)).To(BeNumerically(">=", DefaultPrepareRetryMaxBackoff/2))
+}
+
+func TestPrepareWithRetry(t *testing.T) {
+	retries := &metricsfakes.Counter{}
+	retries.WithReturns(retries)
+	failures := &metricsfakes.Counter{}
+	failures.WithReturns(failures)
+	newEndorser := func(attempts int) *Endorser {
+		return &Endorser{
+			Config: EndorserConfig{
+				PrepareTimeout: 20 * time.Millisecond,
+				PrepareRetry:   PrepareRetryConfig{Attempts: attempts, Backoff: time.Millisecond, MaxBackoff: time.Millisecond},
+			},
+			Metrics: &Metrics{ShardPrepareRetries: retries, ShardPrepareFailures: failures},
+		}
+	}
+	req := &sharding.PrepareRequest{TxID: "tx1", ShardID: "fabcar"}
+
+	t.Run("TransientTimeout", func(t *testing.T) {
+		gt := NewGomegaWithT(t)
+		store := &flakyStore{timeouts: 2}
+		proof, err := newEndorser(2).prepareWithRetry(context.Background(), "mychannel", store, req)
+		gt.Expect(err).NotTo(HaveOccurred())
+		gt.Expect(proof.TxID).To(Equal("tx1"))
+		gt.Expect(store.calls).To(BeEquivalentTo(3))
+		gt.Expect(retries.AddCallCount()).To(Equal(2))
+		gt.Expect(retries.WithArgsForCall(0)).To(Equal([]string{"shard", "fabcar"}))
+		gt.Expect(failures.AddCallCount()).To(BeZero())
+	})
+
+	t.Run("PersistentTimeout", func(t *testing.T) {
+		gt := NewGomegaWithT(t)
+		store := &flakyStore{timeouts: 5}
+		_, err := newEndorser(1).prepareWithRetry(context.Background(), "mychannel", store, req)
+		gt.Expect(err).To(MatchError("gave up on shard fabcar after 2 attempts: failed to prepare tx on shard fabcar: timeout waiting for proof from shard fabcar"))
+		gt.Expect(store.calls).To(BeEquivalentTo(2))
+		gt.Expect(failures.WithArgsForCall(failures.WithCallCount() - 1)).To(Equal([]string{"shard", "fabcar", "reason", "timeout"}))
+	})
+
+	t.Run("NoRetries", func(t *testing.T) {
+		gt := NewGomegaWithT(t)
+		store := &flakyStore{timeouts: 1}
+		_, err := newEndorser(0).prepareWithRetry(context.Background(), "mychannel", store, req)
+		gt.Expect(err).To(MatchError(ContainSubstring("timeout waiting for proof")))
+		gt.Expect(store.calls).To(BeEquivalentTo(1))
+	})
+
+	t.Run("ClientDeadline", func(t *testing.T) {
+		gt := NewGomegaWithT(t)
+		store := &flakyStore{timeouts: 5}
+		e := newEndorser(3)
+		e.Config.PrepareTimeout = time.Minute
+
+		// the client's deadline ends the prepare long before the timeout
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		_, err := e.prepareWithRetry(ctx, "mychannel", store, req)
+		gt.Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+		gt.Expect(err).To(MatchError(ContainSubstring("proposal context deadline exceeded")))
+		gt.Expect(store.calls).To(BeEquivalentTo(1))
+		gt.Expect(failures.WithArgsForCall(failures.WithCallCount() - 1)).To(Equal([]string{"shard", "fabcar", "reason", "canceled"}))
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		gt := NewGomegaWithT(t)
+		store := &flakyStore{err: errors.New("shard is stopped")}
+		_, err := newEndorser(3).prepareWithRetry(context.Background(), "mychannel", store, req)
+		gt.Expect(err).To(MatchError("failed to prepare tx on shard fabcar: shard is stopped"))
+		gt.Expect(store.calls).To(BeEquivalentTo(1))
+		gt.Expect(failures.WithArgsForCall(failures.WithCallCount() - 1)).To(Equal([]string{"shard", "fabcar", "reason", "error"}))
+	})
+}
+
+func TestResolveDependenciesTimestamp(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	store := &recordingStore{}
+	e := &Endorser{Config: EndorserConfig{PrepareTimeout: time.Second}}
+	shards := map[string]map[string][]byte{
+		"fabcar":  {"fabcar:car1": []byte("red")},
+		"marbles": {"marbles:m1": []byte("blue")},
+	}
+
+	_, err := e.resolveDependencies(context.Background(), "mychannel", "tx1", DefaultLane, store, shards, nil, nil, true, 0)
+	gt.Expect(err).NotTo(HaveOccurred())
+	_, err = e.resolveDependencies(context.Background(), "mychannel", "tx2", DefaultLane, store, shards, nil, nil, true, 0)
+	gt.Expect(err).NotTo(HaveOccurred())
+
+	// a transaction has the same timestamp on all of its shards, after
+	// those of the transactions prepared before
+	gt.Expect(store.reqs).To(HaveLen(4))
+	gt.Expect(store.reqs[0].Timestamp).To(Equal(store.reqs[1].Timestamp))
+	gt.Expect(store.reqs[2].Timestamp).To(Equal(store.reqs[3].Timestamp))
+	gt.Expect(store.reqs[0].Timestamp.Before(store.reqs[2].Timestamp)).To(BeTrue())
+}
@@ -0,0 +1,109 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package endorser
+
+import (
+	"context"
+	"crypto/sha256"
+	"sync"
+
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/pkg/errors"
+)
+
+// ProcessingTxs tracks the proposals being processed, so that a client that
+// retries a proposal while the first attempt is still in flight waits for
+// and reuses its result, instead of simulating the transaction and preparing
+// it on the shards a second time. The zero value is ready to use.
+type ProcessingTxs struct {
+	mu    sync.Mutex
+	calls map[string]*processingTx
+}
+
+// processingTx is a proposal in flight and, once done is closed, its result
+type processingTx struct {
+	// digest identifies the proposal, so that proposals reusing a TxID with
+	// other contents are not mistaken for retries
+	digest  [sha256.Size]byte
+	done    chan struct{}
+	cancel  context.CancelFunc
+	waiters int
+
+	response      *pb.ProposalResponse
+	hasDependency bool
+	err           error
+}
+
+// Do processes the proposal of the transaction, named channel/txID, unless
+// the same proposal is already in flight, in which case it waits for that
+// result instead. The processing outlives the caller that started it, and is
+// only canceled once every caller waiting for it gave up.
+func (p *ProcessingTxs) Do(ctx context.Context, txID string, proposalBytes []byte, process func(context.Context) (*pb.ProposalResponse, bool, error)) (*pb.ProposalResponse, bool, error) {
+	digest := sha256.Sum256(proposalBytes)
+
+	p.mu.Lock()
+	call, inFlight := p.calls[txID]
+	switch {
+	case inFlight && call.digest != digest:
+		p.mu.Unlock()
+		logger.Warningf("Proposal for tx %s differs from the one in flight, processing it separately", txID)
+		return process(ctx)
+	case inFlight:
+		logger.Debugf("Proposal for tx %s is already in flight, waiting for its result", txID)
+	default:
+		if p.calls == nil {
+			p.calls = make(map[string]*processingTx)
+		}
+		processCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		call = &processingTx{digest: digest, done: make(chan struct{}), cancel: cancel}
+		p.calls[txID] = call
+		go func() {
+			defer cancel()
+			call.response, call.hasDependency, call.err = process(processCtx)
+			p.forget(txID, call)
+			close(call.done)
+		}()
+	}
+	call.waiters++
+	p.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.response, call.hasDependency, call.err
+	case <-ctx.Done():
+		p.mu.Lock()
+		call.waiters--
+		if call.waiters == 0 {
+			// A later retry starts afresh rather than joining a
+			// canceled attempt
+			call.cancel()
+			p.forgetLocked(txID, call)
+		}
+		p.mu.Unlock()
+		return nil, false, errors.Wrapf(ctx.Err(), "gave up waiting for the proposal of tx %s", txID)
+	}
+}
+
+// forget stops tracking the call, unless another call replaced it
+func (p *ProcessingTxs) forget(txID string, call *processingTx) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.forgetLocked(txID, call)
+}
+
+func (p *ProcessingTxs) forgetLocked(txID string, call *processingTx) {
+	if p.calls[txID] == call {
+		delete(p.calls, txID)
+	}
+}
+
+// InFlight returns the number of proposals being processed
+func (p *ProcessingTxs) InFlight() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.calls)
+}
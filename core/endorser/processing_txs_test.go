@@ -0,0 +1,124 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package endorser
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	. "github.com/onsi/gomega"
+)
+
+// waiters returns the number of callers waiting for the transaction
+func waiters(p *ProcessingTxs, txID string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if call, ok := p.calls[txID]; ok {
+		return call.waiters
+	}
+	return 0
+}
+
+func TestProcessingTxsCoalescesRetries(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	var p ProcessingTxs
+	var calls int32
+	release := make(chan struct{})
+	process := func(ctx context.Context) (*pb.ProposalResponse, bool, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return &pb.ProposalResponse{Response: &pb.Response{Status: 200}}, true, nil
+	}
+
+	var wg sync.WaitGroup
+	responses := make([]*pb.ProposalResponse, 2)
+	for i := range responses {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp, hasDependency, err := p.Do(context.Background(), "mychannel/tx1", []byte("proposal"), process)
+			gt.Expect(err).NotTo(HaveOccurred())
+			gt.Expect(hasDependency).To(BeTrue())
+			responses[i] = resp
+		}(i)
+		// the first attempt is in flight before the retry arrives
+		gt.Eventually(func() int { return waiters(&p, "mychannel/tx1") }).Should(Equal(i + 1))
+	}
+	close(release)
+	wg.Wait()
+
+	gt.Expect(atomic.LoadInt32(&calls)).To(Equal(int32(1)))
+	gt.Expect(responses[0]).To(BeIdenticalTo(responses[1]))
+	gt.Expect(p.InFlight()).To(Equal(0))
+
+	// once done, the transaction is processed again
+	_, _, err := p.Do(context.Background(), "mychannel/tx1", []byte("proposal"), process)
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(atomic.LoadInt32(&calls)).To(Equal(int32(2)))
+}
+
+func TestProcessingTxsDifferentProposals(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	var p ProcessingTxs
+	release := make(chan struct{})
+	defer close(release)
+	go p.Do(context.Background(), "mychannel/tx1", []byte("proposal"), func(ctx context.Context) (*pb.ProposalResponse, bool, error) {
+		<-release
+		return nil, false, nil
+	})
+	gt.Eventually(p.InFlight).Should(Equal(1))
+
+	// a proposal reusing the TxID with other contents is not a retry
+	resp, _, err := p.Do(context.Background(), "mychannel/tx1", []byte("other"), func(ctx context.Context) (*pb.ProposalResponse, bool, error) {
+		return &pb.ProposalResponse{Response: &pb.Response{Status: 500}}, false, nil
+	})
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(resp.Response.Status).To(Equal(int32(500)))
+}
+
+func TestProcessingTxsCancel(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	var p ProcessingTxs
+	canceled := make(chan struct{})
+	process := func(ctx context.Context) (*pb.ProposalResponse, bool, error) {
+		<-ctx.Done()
+		close(canceled)
+		return nil, false, ctx.Err()
+	}
+
+	first, cancelFirst := context.WithCancel(context.Background())
+	errC := make(chan error, 2)
+	go func() {
+		_, _, err := p.Do(first, "mychannel/tx1", []byte("proposal"), process)
+		errC <- err
+	}()
+	gt.Eventually(p.InFlight).Should(Equal(1))
+
+	retry, cancelRetry := context.WithCancel(context.Background())
+	go func() {
+		_, _, err := p.Do(retry, "mychannel/tx1", []byte("proposal"), process)
+		errC <- err
+	}()
+	gt.Eventually(func() int { return waiters(&p, "mychannel/tx1") }).Should(Equal(2))
+
+	// the first client giving up leaves the processing to the retry
+	cancelFirst()
+	gt.Eventually(errC).Should(Receive(MatchError(ContainSubstring("gave up waiting for the proposal of tx mychannel/tx1"))))
+	gt.Consistently(canceled, 100*time.Millisecond).ShouldNot(BeClosed())
+
+	cancelRetry()
+	gt.Eventually(errC).Should(Receive(HaveOccurred()))
+	gt.Eventually(canceled).Should(BeClosed())
+	gt.Expect(p.InFlight()).To(Equal(0))
+}
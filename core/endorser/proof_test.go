@@ -0,0 +1,42 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package endorser
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/endorser/sharding"
+	. "github.com/onsi/gomega"
+)
+
+func TestVerifyProof(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	gt.Expect(err).NotTo(HaveOccurred())
+
+	req := &sharding.PrepareRequest{TxID: "tx1", ShardID: "fabcar"}
+	proof := &sharding.PrepareProof{TxID: "tx1", ShardID: "fabcar", CommitIndex: 7, Term: 2, SignerID: 1}
+	proof.Signature = ed25519.Sign(priv, sharding.ProofDigest("fabcar", "tx1", 7, 2))
+
+	e := &Endorser{}
+	gt.Expect(e.verifyProof(proof, req)).To(Succeed())
+	gt.Expect(e.verifyProof(proof, &sharding.PrepareRequest{TxID: "tx2", ShardID: "fabcar"})).To(MatchError(ContainSubstring("does not match request")))
+	gt.Expect(e.verifyProof(nil, req)).To(MatchError("missing prepare proof"))
+
+	e.ProofVerifier = sharding.NewProofVerifier(map[uint64]ed25519.PublicKey{1: pub}, 0)
+	gt.Expect(e.verifyProof(proof, req)).To(Succeed())
+
+	forged := *proof
+	forged.CommitIndex = 8
+	gt.Expect(e.verifyProof(&forged, req)).To(MatchError(ContainSubstring("invalid signature of replica 1")))
+
+	e.ProofVerifier = sharding.NewProofVerifier(map[uint64]ed25519.PublicKey{1: pub}, 1)
+	gt.Expect(e.verifyProof(proof, req)).To(MatchError(ContainSubstring("0 valid co-signatures, 1 required")))
+}
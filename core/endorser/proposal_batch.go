@@ -0,0 +1,262 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package endorser
+
+import (
+	"context"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/hyperledger/fabric/core/endorser/sharding"
+	"github.com/hyperledger/fabric/core/endorser/sharding/protos"
+	"github.com/pkg/errors"
+)
+
+// DefaultMaxProposalBatch caps the proposals of a batch when
+// EndorserConfig.MaxProposalBatch is unset
+const DefaultMaxProposalBatch = 1000
+
+// proposalBatch combines the prepares of the proposals of a batch into one
+// submission per shard. The submissions are made once every proposal either
+// queued the prepares it announced or finished without preparing, so the
+// slowest simulation of the batch holds back the others.
+type proposalBatch struct {
+	e  *Endorser
+	mu sync.Mutex
+	// unsettled counts the proposals that neither announced their prepares
+	// nor finished, and outstanding the prepares announced but not queued
+	unsettled   int
+	outstanding int
+	flushed     bool
+	open        map[prepareBatchKey]*prepareBatch
+}
+
+// batchedProposal is a proposal of a batch
+type batchedProposal struct {
+	batch   *proposalBatch
+	settled bool
+}
+
+// batchedPrepare is the prepare a proposal of a batch announced on a shard.
+// Its retries, and the prepares made once the batch was submitted, are
+// submitted on their own.
+type batchedPrepare struct {
+	batch  *proposalBatch
+	queued bool
+	left   bool
+}
+
+type batchedProposalKey struct{}
+
+type batchedPrepareKey struct{}
+
+// batchedProposalFrom returns the batch proposal the context processes, if any
+func batchedProposalFrom(ctx context.Context) *batchedProposal {
+	p, _ := ctx.Value(batchedProposalKey{}).(*batchedProposal)
+	return p
+}
+
+// batchedPrepareFrom returns the prepare of a batch the context makes, if any
+func batchedPrepareFrom(ctx context.Context) *batchedPrepare {
+	p, _ := ctx.Value(batchedPrepareKey{}).(*batchedPrepare)
+	return p
+}
+
+// newProposalBatch returns a batch of n proposals and the contexts to process
+// each of them with
+func (e *Endorser) newProposalBatch(ctx context.Context, n int) []context.Context {
+	b := &proposalBatch{e: e, unsettled: n}
+	ctxs := make([]context.Context, n)
+	for i := range ctxs {
+		ctxs[i] = context.WithValue(ctx, batchedProposalKey{}, &batchedProposal{batch: b})
+	}
+	return ctxs
+}
+
+// announce records that the proposal is about to prepare on n shards, and
+// returns the contexts to make each prepare with. ctx is returned alone once
+// the batch was submitted.
+func (p *batchedProposal) announce(ctx context.Context, n int) []context.Context {
+	b := p.batch
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.flushed {
+		return nil
+	}
+	if !p.settled {
+		p.settled = true
+		b.unsettled--
+	}
+	b.outstanding += n
+	ctxs := make([]context.Context, n)
+	for i := range ctxs {
+		ctxs[i] = context.WithValue(ctx, batchedPrepareKey{}, &batchedPrepare{batch: b})
+	}
+	return ctxs
+}
+
+// done records that the proposal finished
+func (p *batchedProposal) done() {
+	b := p.batch
+	b.mu.Lock()
+	if !p.settled {
+		p.settled = true
+		b.unsettled--
+	}
+	b.flushIfReady()
+}
+
+// leave records that the prepare was made without being queued, as when its
+// shard is unavailable or its store takes no batches
+func (p *batchedPrepare) leave() {
+	b := p.batch
+	b.mu.Lock()
+	if !p.queued && !p.left {
+		p.left = true
+		b.outstanding--
+	}
+	b.flushIfReady()
+}
+
+// submit queues the request with those of the other proposals of the batch
+// on its shard and waits for its proof. It reports false when the request
+// must be submitted on its own.
+func (p *batchedPrepare) submit(ctx context.Context, store sharding.DependencyStore, req *sharding.PrepareRequest) (*sharding.PrepareProof, bool, error) {
+	batchStore, ok := store.(sharding.BatchDependencyStore)
+	b := p.batch
+	b.mu.Lock()
+	if b.flushed || p.queued || p.left {
+		b.mu.Unlock()
+		return nil, false, nil
+	}
+	if !ok {
+		p.left = true
+		b.outstanding--
+		b.flushIfReady()
+		return nil, false, nil
+	}
+	p.queued = true
+	b.outstanding--
+	key := prepareBatchKey{store: batchStore, shardID: req.ShardID}
+	if b.open == nil {
+		b.open = make(map[prepareBatchKey]*prepareBatch)
+	}
+	batch, found := b.open[key]
+	if !found {
+		batch = &prepareBatch{}
+		b.open[key] = batch
+	}
+	resultC := make(chan prepareResult, 1)
+	batch.reqs = append(batch.reqs, req)
+	batch.resultCs = append(batch.resultCs, resultC)
+	b.flushIfReady()
+
+	select {
+	case res := <-resultC:
+		return res.proof, true, res.err
+	case <-ctx.Done():
+		return nil, true, errors.WithMessagef(ctx.Err(), "no proof from shard %s for tx %s", req.ShardID, req.TxID)
+	}
+}
+
+// flushIfReady submits the prepares of the batch, one submission per shard,
+// once no proposal may add to them. It is called with mu held and releases
+// it.
+func (b *proposalBatch) flushIfReady() {
+	if b.flushed || b.unsettled > 0 || b.outstanding > 0 {
+		b.mu.Unlock()
+		return
+	}
+	b.flushed = true
+	open := b.open
+	b.open = nil
+	b.mu.Unlock()
+
+	for key, batch := range open {
+		go b.e.submitPrepareBatch(key, batch)
+	}
+}
+
+// BatchServer serves the BatchEndorser gRPC API, handing each proposal of a
+// batch to the endorser's filter chain
+type BatchServer struct {
+	protos.UnimplementedBatchEndorserServer
+	endorser *Endorser
+	server   pb.EndorserServer
+}
+
+// NewBatchServer returns a BatchServer processing the proposals with server,
+// which ends with the endorser
+func NewBatchServer(endorser *Endorser, server pb.EndorserServer) *BatchServer {
+	return &BatchServer{endorser: endorser, server: server}
+}
+
+// ProcessProposals processes the proposals of the batch concurrently and
+// returns their responses in order. A proposal that fails is answered with
+// status 500. A proposal repeated in the batch is processed once.
+func (s *BatchServer) ProcessProposals(ctx context.Context, in *protos.ProposalBatch) (*protos.ProposalResponseBatch, error) {
+	limit := s.endorser.Config.MaxProposalBatch
+	if limit <= 0 {
+		limit = DefaultMaxProposalBatch
+	}
+	if len(in.SignedProposals) > limit {
+		return nil, errors.Errorf("batch of %d proposals exceeds the maximum of %d", len(in.SignedProposals), limit)
+	}
+
+	// The first occurrence of each proposal is processed
+	first := make([]int, len(in.SignedProposals))
+	seen := make(map[string]int)
+	var unique []int
+	for i, data := range in.SignedProposals {
+		if j, ok := seen[string(data)]; ok {
+			first[i] = j
+			continue
+		}
+		seen[string(data)] = i
+		first[i] = i
+		unique = append(unique, i)
+	}
+	s.endorser.Metrics.observeProposalBatchSize(len(unique))
+
+	responses := make([][]byte, len(in.SignedProposals))
+	ctxs := s.endorser.newProposalBatch(ctx, len(unique))
+	var wg sync.WaitGroup
+	for n, i := range unique {
+		wg.Add(1)
+		go func(ctx context.Context, i int) {
+			defer wg.Done()
+			defer batchedProposalFrom(ctx).done()
+			responses[i] = s.process(ctx, in.SignedProposals[i])
+		}(ctxs[n], i)
+	}
+	wg.Wait()
+
+	for i := range responses {
+		responses[i] = responses[first[i]]
+	}
+	return &protos.ProposalResponseBatch{ProposalResponses: responses}, nil
+}
+
+// process endorses a serialized proposal and returns the serialized response
+func (s *BatchServer) process(ctx context.Context, data []byte) []byte {
+	signedProp := &pb.SignedProposal{}
+	resp, err := func() (*pb.ProposalResponse, error) {
+		if err := proto.Unmarshal(data, signedProp); err != nil {
+			return nil, errors.Wrap(err, "failed to unmarshal signed proposal")
+		}
+		return s.server.ProcessProposal(ctx, signedProp)
+	}()
+	if err != nil {
+		resp = &pb.ProposalResponse{Response: &pb.Response{Status: 500, Message: err.Error()}}
+	}
+	out, err := proto.Marshal(resp)
+	if err != nil {
+		out, _ = proto.Marshal(&pb.ProposalResponse{Response: &pb.Response{Status: 500, Message: "failed to marshal proposal response: " + err.Error()}})
+	}
+	return out
+}
@@ -0,0 +1,94 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package endorser
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/hyperledger/fabric/core/endorser/sharding/protos"
+	. "github.com/onsi/gomega"
+)
+
+// endorserFunc adapts a function to pb.EndorserServer
+type endorserFunc func(context.Context, *pb.SignedProposal) (*pb.ProposalResponse, error)
+
+func (f endorserFunc) ProcessProposal(ctx context.Context, sp *pb.SignedProposal) (*pb.ProposalResponse, error) {
+	return f(ctx, sp)
+}
+
+func TestProcessProposalBatch(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	store := &batchingStore{}
+	e := &Endorser{Config: EndorserConfig{PrepareTimeout: 5 * time.Second, MaxProposalBatch: 6}}
+	var calls int32
+	// The proposal bytes name the transaction. A read-only proposal that
+	// is slow to simulate holds back the prepares of the others.
+	server := NewBatchServer(e, endorserFunc(func(ctx context.Context, sp *pb.SignedProposal) (*pb.ProposalResponse, error) {
+		atomic.AddInt32(&calls, 1)
+		txID := string(sp.ProposalBytes)
+		switch txID {
+		case "bad":
+			return nil, errors.New("access denied")
+		case "query":
+			time.Sleep(200 * time.Millisecond)
+			gt.Expect(store.batchSizes()).To(BeEmpty())
+			return &pb.ProposalResponse{Response: &pb.Response{Status: 200, Message: txID}}, nil
+		}
+		_, err := e.resolveDependencies(ctx, "mychannel", txID, DefaultLane, store, map[string]map[string][]byte{
+			"fabcar":  {"fabcar:" + txID: []byte("v1")},
+			"marbles": {"marbles:" + txID: []byte("v1")},
+		}, nil, nil, true, 0)
+		if err != nil {
+			return nil, err
+		}
+		return &pb.ProposalResponse{Response: &pb.Response{Status: 200, Message: txID}}, nil
+	}))
+
+	batch := &protos.ProposalBatch{}
+	for _, txID := range []string{"tx1", "query", "tx2", "bad", "tx1", "tx3"} {
+		data, err := proto.Marshal(&pb.SignedProposal{ProposalBytes: []byte(txID)})
+		gt.Expect(err).NotTo(HaveOccurred())
+		batch.SignedProposals = append(batch.SignedProposals, data)
+	}
+	out, err := server.ProcessProposals(context.Background(), batch)
+	gt.Expect(err).NotTo(HaveOccurred())
+
+	// the responses are in order, the repeated proposal was processed once
+	gt.Expect(out.ProposalResponses).To(HaveLen(6))
+	var messages []string
+	for _, data := range out.ProposalResponses {
+		resp := &pb.ProposalResponse{}
+		gt.Expect(proto.Unmarshal(data, resp)).To(Succeed())
+		messages = append(messages, resp.Response.Message)
+	}
+	gt.Expect(messages).To(Equal([]string{"tx1", "query", "tx2", "access denied", "tx1", "tx3"}))
+	gt.Expect(calls).To(Equal(int32(5)))
+
+	// every shard got one submission with the prepares of the batch
+	gt.Expect(store.batchSizes()).To(Equal([]int{3, 3}))
+	for _, txIDs := range store.batches {
+		gt.Expect(txIDs).To(ConsistOf("tx1", "tx2", "tx3"))
+	}
+
+	// prepares made once the batch was submitted are made on their own
+	ctx := e.newProposalBatch(context.Background(), 1)[0]
+	batchedProposalFrom(ctx).done()
+	_, err = e.resolveDependencies(ctx, "mychannel", "tx4", DefaultLane, store, map[string]map[string][]byte{"fabcar": {"fabcar:tx4": []byte("v1")}}, nil, nil, true, 0)
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(store.calls).To(Equal(int32(1)))
+
+	batch.SignedProposals = append(batch.SignedProposals, batch.SignedProposals[0])
+	_, err = server.ProcessProposals(context.Background(), batch)
+	gt.Expect(err).To(MatchError("batch of 7 proposals exceeds the maximum of 6"))
+}
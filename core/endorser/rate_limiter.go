@@ -0,0 +1,147 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package endorser
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/fabric/protoutil"
+)
+
+// StatusTooManyRequests is the status of the response to a proposal refused
+// because its client exceeded its rate
+const StatusTooManyRequests = 429
+
+// rateLimiterSweepSize is the number of clients tracked beyond which the
+// idle ones are forgotten
+const rateLimiterSweepSize = 10000
+
+// RateLimitConfig limits the rate at which each client, identified by its
+// certificate, may send proposals, so that one client cannot starve the
+// others. Proposals beyond the rate are refused with StatusTooManyRequests.
+type RateLimitConfig struct {
+	// Rate is the number of proposals per second each client may send.
+	// Clients are not limited when it is 0.
+	Rate float64
+	// Burst is the number of proposals a client may send at once after
+	// being idle. Defaults to Rate, and to at least 1.
+	Burst int
+	// MSPRates overrides Rate for the clients of the listed MSP IDs. A rate
+	// of 0 leaves them unlimited.
+	MSPRates map[string]float64
+}
+
+// enabled reports whether any client is limited
+func (c RateLimitConfig) enabled() bool {
+	if c.Rate > 0 {
+		return true
+	}
+	for _, rate := range c.MSPRates {
+		if rate > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// rate returns the rate of the clients of the MSP
+func (c RateLimitConfig) rate(mspID string) float64 {
+	if rate, ok := c.MSPRates[mspID]; ok {
+		return rate
+	}
+	return c.Rate
+}
+
+// burst returns the capacity of the bucket of a client limited to rate
+func (c RateLimitConfig) burst(rate float64) float64 {
+	if c.Burst > 0 {
+		return float64(c.Burst)
+	}
+	return math.Max(1, math.Ceil(rate))
+}
+
+// tokenBucket holds the proposals a client may still send, refilled at rate
+// up to burst
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	rate   float64
+	burst  float64
+}
+
+// refill adds the tokens earned since the last refill
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// rateLimiter keeps a token bucket per client
+type rateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+// allow takes a token from the bucket of the client, created with the given
+// rate and burst, and reports whether there was one
+func (l *rateLimiter) allow(client string, rate, burst float64, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.buckets == nil {
+		l.buckets = make(map[string]*tokenBucket)
+	}
+	b, ok := l.buckets[client]
+	if !ok {
+		if len(l.buckets) >= rateLimiterSweepSize {
+			l.sweep(now)
+		}
+		b = &tokenBucket{tokens: burst, last: now, rate: rate, burst: burst}
+		l.buckets[client] = b
+	}
+
+	b.refill(now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// sweep forgets the clients idle long enough for their bucket to be full,
+// which a new bucket is as well
+func (l *rateLimiter) sweep(now time.Time) {
+	for client, b := range l.buckets {
+		if b.refill(now); b.tokens >= b.burst {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// allowProposal reports whether the creator of the proposal is within its
+// rate, and returns its MSP ID
+func (e *Endorser) allowProposal(up *UnpackedProposal) (bool, string) {
+	if !e.Config.RateLimit.enabled() {
+		return true, ""
+	}
+	id, err := protoutil.UnmarshalSerializedIdentity(up.SignatureHeader.Creator)
+	if err != nil {
+		// preProcess already checked the creator
+		return true, ""
+	}
+	rate := e.Config.RateLimit.rate(id.Mspid)
+	if rate <= 0 {
+		return true, id.Mspid
+	}
+
+	hash := sha256.Sum256(id.IdBytes)
+	client := id.Mspid + ":" + hex.EncodeToString(hash[:])
+	return e.limiter.allow(client, rate, e.Config.RateLimit.burst(rate), time.Now()), id.Mspid
+}
@@ -0,0 +1,91 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package endorser
+
+import (
+	"testing"
+	"time"
+
+	cb "github.com/hyperledger/fabric-protos-go/common"
+	mspproto "github.com/hyperledger/fabric-protos-go/msp"
+	"github.com/hyperledger/fabric/protoutil"
+	. "github.com/onsi/gomega"
+)
+
+func TestRateLimiter(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	l := &rateLimiter{}
+	now := time.Now()
+	// a burst of 2 at 10 proposals per second
+	gt.Expect(l.allow("alice", 10, 2, now)).To(BeTrue())
+	gt.Expect(l.allow("alice", 10, 2, now)).To(BeTrue())
+	gt.Expect(l.allow("alice", 10, 2, now)).To(BeFalse())
+	// other clients have their own bucket
+	gt.Expect(l.allow("bob", 10, 2, now)).To(BeTrue())
+
+	gt.Expect(l.allow("alice", 10, 2, now.Add(50*time.Millisecond))).To(BeFalse())
+	gt.Expect(l.allow("alice", 10, 2, now.Add(100*time.Millisecond))).To(BeTrue())
+	// an idle client gets no more than its burst
+	later := now.Add(time.Hour)
+	gt.Expect(l.allow("alice", 10, 2, later)).To(BeTrue())
+	gt.Expect(l.allow("alice", 10, 2, later)).To(BeTrue())
+	gt.Expect(l.allow("alice", 10, 2, later)).To(BeFalse())
+
+	// idle clients are forgotten
+	l.sweep(later)
+	gt.Expect(l.buckets).To(HaveLen(1))
+	gt.Expect(l.buckets).To(HaveKey("alice"))
+}
+
+func TestRateLimitConfig(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	gt.Expect(RateLimitConfig{}.enabled()).To(BeFalse())
+	gt.Expect(RateLimitConfig{MSPRates: map[string]float64{"Org1MSP": 0}}.enabled()).To(BeFalse())
+	gt.Expect(RateLimitConfig{MSPRates: map[string]float64{"Org1MSP": 5}}.enabled()).To(BeTrue())
+
+	c := RateLimitConfig{Rate: 2.5, MSPRates: map[string]float64{"Org1MSP": 100}}
+	gt.Expect(c.rate("Org1MSP")).To(Equal(100.0))
+	gt.Expect(c.rate("Org2MSP")).To(Equal(2.5))
+	gt.Expect(c.burst(2.5)).To(Equal(3.0))
+	gt.Expect(c.burst(0.1)).To(Equal(1.0))
+	c.Burst = 10
+	gt.Expect(c.burst(2.5)).To(Equal(10.0))
+}
+
+func TestAllowProposal(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	proposal := func(mspID, cert string) *UnpackedProposal {
+		creator := protoutil.MarshalOrPanic(&mspproto.SerializedIdentity{Mspid: mspID, IdBytes: []byte(cert)})
+		return &UnpackedProposal{SignatureHeader: &cb.SignatureHeader{Creator: creator}}
+	}
+
+	e := &Endorser{}
+	for i := 0; i < 5; i++ {
+		allowed, _ := e.allowProposal(proposal("Org1MSP", "alice"))
+		gt.Expect(allowed).To(BeTrue())
+	}
+
+	e.Config.RateLimit = RateLimitConfig{Rate: 0.001, Burst: 1, MSPRates: map[string]float64{"Org2MSP": 0}}
+	allowed, mspID := e.allowProposal(proposal("Org1MSP", "alice"))
+	gt.Expect(allowed).To(BeTrue())
+	gt.Expect(mspID).To(Equal("Org1MSP"))
+	allowed, mspID = e.allowProposal(proposal("Org1MSP", "alice"))
+	gt.Expect(allowed).To(BeFalse())
+	gt.Expect(mspID).To(Equal("Org1MSP"))
+
+	// another certificate of the same MSP is another client
+	allowed, _ = e.allowProposal(proposal("Org1MSP", "bob"))
+	gt.Expect(allowed).To(BeTrue())
+	// Org2MSP is not limited
+	for i := 0; i < 5; i++ {
+		allowed, _ = e.allowProposal(proposal("Org2MSP", "carol"))
+		gt.Expect(allowed).To(BeTrue())
+	}
+}
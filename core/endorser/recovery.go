@@ -0,0 +1,253 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package endorser
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/endorser/sharding"
+	"github.com/pkg/errors"
+)
+
+// Recovery policies of the prepares found in flight after a restart
+const (
+	// RecoveryAbort releases the reservations of the prepares
+	RecoveryAbort = "abort"
+	// RecoveryResubscribe serves the proofs of the prepares through the
+	// admin API as for a speculative endorsement, and lets the client abort
+	// them. The prepares whose proofs are no longer known are aborted.
+	RecoveryResubscribe = "resubscribe"
+)
+
+// DefaultRecoveryTimeout bounds the search for the prepares of the endorser
+// after a restart, while the shards elect their leaders
+const DefaultRecoveryTimeout = time.Minute
+
+// Outcomes of a prepare recovered after a restart
+const (
+	recoveryEndorsed     = "endorsed"
+	recoveryResubscribed = "resubscribed"
+	recoveryAborted      = "aborted"
+	recoveryFailed       = "failed"
+)
+
+// RecoveryResult reports what became of the transactions this endorser was
+// preparing when it stopped
+type RecoveryResult struct {
+	// Endorsed lists the transactions whose endorsement was returned before
+	// the restart. Their reservations are kept, and the client may still
+	// abort them.
+	Endorsed     []string `json:",omitempty"`
+	Resubscribed []string `json:",omitempty"`
+	Aborted      []string `json:",omitempty"`
+	// Failed maps the transactions that could not be aborted to the error
+	Failed map[string]string `json:",omitempty"`
+}
+
+// recoveredTx gathers the prepares of a transaction found on one store
+type recoveredTx struct {
+	store    sharding.DependencyStore
+	prepares []*sharding.OriginPrepare
+}
+
+// recoveryStores returns the distinct stores the endorser prepares on that can
+// find its prepares
+func (e *Endorser) recoveryStores() []sharding.RecoverableStore {
+	var stores []sharding.RecoverableStore
+	seen := make(map[sharding.DependencyStore]bool)
+	candidates := []sharding.DependencyStore{e.dependencyStore("")}
+	channels := make([]string, 0, len(e.ChannelDependencyStores))
+	for channel := range e.ChannelDependencyStores {
+		channels = append(channels, channel)
+	}
+	sort.Strings(channels)
+	for _, channel := range channels {
+		candidates = append(candidates, e.ChannelDependencyStores[channel])
+	}
+	for _, store := range candidates {
+		recoverable, ok := store.(sharding.RecoverableStore)
+		if !ok || seen[store] {
+			continue
+		}
+		seen[store] = true
+		stores = append(stores, recoverable)
+	}
+	return stores
+}
+
+// RecoverPrepares finds the transactions this endorser prepared on the shards
+// before it restarted whose reservations are still held. Those whose
+// endorsement the Journal recorded as returned are in flight to the orderer
+// and only become abortable again. The clients of the others never got an
+// endorsement, so without it the transactions touching the same keys would
+// depend on them until they expire; they are resubscribed to or aborted as
+// Config.RecoveryPolicy says.
+func (e *Endorser) RecoverPrepares(ctx context.Context) (*RecoveryResult, error) {
+	policy := e.Config.RecoveryPolicy
+	switch policy {
+	case RecoveryAbort, RecoveryResubscribe:
+	default:
+		return nil, errors.Errorf("invalid recovery policy %q", policy)
+	}
+	if e.Config.EndorserID == "" {
+		return nil, errors.New("recovering prepares requires an endorser ID")
+	}
+	if e.Journal == nil {
+		return nil, errors.New("recovering prepares requires an endorsement journal")
+	}
+
+	txs := make(map[string]*recoveredTx)
+	var searchErr error
+	for _, store := range e.recoveryStores() {
+		prepares, err := store.PreparesOf(ctx, e.Config.EndorserID)
+		if err != nil {
+			// The prepares of the shards that answered are still recovered
+			logger.Warningf("Recovering the prepares of endorser %s: %s", e.Config.EndorserID, err)
+			searchErr = err
+		}
+		for _, prepare := range prepares {
+			tx, ok := txs[prepare.TxID]
+			if !ok {
+				tx = &recoveredTx{store: store}
+				txs[prepare.TxID] = tx
+			}
+			tx.prepares = append(tx.prepares, prepare)
+		}
+	}
+
+	txIDs := make([]string, 0, len(txs))
+	for txID := range txs {
+		txIDs = append(txIDs, txID)
+	}
+	sort.Strings(txIDs)
+
+	result := &RecoveryResult{}
+	for _, txID := range txIDs {
+		tx := txs[txID]
+		if e.Journal.Returned(txID) {
+			e.keepEndorsed(txID, tx)
+			result.Endorsed = append(result.Endorsed, txID)
+			e.Metrics.countRecoveredPrepares(recoveryEndorsed, len(tx.prepares))
+			continue
+		}
+		if policy == RecoveryResubscribe {
+			err := e.resubscribe(txID, tx)
+			if err == nil {
+				result.Resubscribed = append(result.Resubscribed, txID)
+				e.Metrics.countRecoveredPrepares(recoveryResubscribed, len(tx.prepares))
+				continue
+			}
+			logger.Warningf("Aborting recovered tx %s: %s", txID, err)
+		}
+
+		var failed []string
+		for _, prepare := range tx.prepares {
+			if err := tx.store.Abort(prepare.ShardID, txID); err != nil {
+				failed = append(failed, prepare.ShardID+": "+err.Error())
+			}
+		}
+		if len(failed) > 0 {
+			if result.Failed == nil {
+				result.Failed = make(map[string]string)
+			}
+			result.Failed[txID] = strings.Join(failed, "; ")
+			e.Metrics.countRecoveredPrepares(recoveryFailed, len(failed))
+		}
+		if len(failed) < len(tx.prepares) {
+			result.Aborted = append(result.Aborted, txID)
+			e.Metrics.countRecoveredPrepares(recoveryAborted, len(tx.prepares)-len(failed))
+		}
+	}
+
+	logger.Infof("Recovered the prepares of endorser %s under %s policy: %d endorsed, %d resubscribed, %d aborted, %d failed",
+		e.Config.EndorserID, policy, len(result.Endorsed), len(result.Resubscribed), len(result.Aborted), len(result.Failed))
+	return result, searchErr
+}
+
+// journalEndorsement records in the Journal that the endorsement of the
+// transaction is returned, if it holds reservations this endorser may recover
+func (e *Endorser) journalEndorsement(txID string) error {
+	if e.Journal == nil {
+		return nil
+	}
+	expires, ok := e.endorsed.expiry(txID)
+	if !ok {
+		return nil
+	}
+	return errors.WithMessage(e.Journal.Record(txID, expires), "failed to record the endorsement")
+}
+
+// keepEndorsed lets the client abort a recovered transaction whose
+// endorsement was returned, until its reservations expire
+func (e *Endorser) keepEndorsed(txID string, tx *recoveredTx) {
+	shards := make([]string, 0, len(tx.prepares))
+	var expires time.Time
+	for _, prepare := range tx.prepares {
+		shards = append(shards, prepare.ShardID)
+		if prepare.ExpiryTime.After(expires) {
+			expires = prepare.ExpiryTime
+		}
+	}
+	e.endorsed.add(txID, tx.store, shards, expires)
+}
+
+// resubscribe records the proofs of a recovered transaction where the admin
+// API serves them, and lets the client abort it until its reservations expire
+func (e *Endorser) resubscribe(txID string, tx *recoveredTx) error {
+	res := &dependencyResolution{}
+	proofs := make([]*sharding.PrepareProof, 0, len(tx.prepares))
+	shards := make([]string, 0, len(tx.prepares))
+	depMap := make(map[string]bool)
+	var expires time.Time
+	for _, prepare := range tx.prepares {
+		if prepare.Proof == nil {
+			return errors.Errorf("the proof of shard %s is no longer known", prepare.ShardID)
+		}
+		proofs = append(proofs, prepare.Proof)
+		shards = append(shards, prepare.ShardID)
+		if prepare.Proof.HasDependency {
+			res.hasDependency = true
+		}
+		for _, dep := range strings.Split(prepare.Proof.DependentTxID, ",") {
+			if dep != "" {
+				depMap[dep] = true
+			}
+		}
+		if prepare.ExpiryTime.After(expires) {
+			expires = prepare.ExpiryTime
+		}
+	}
+
+	encodedProofs, err := sharding.EncodeProofs(proofs)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode dependency proofs")
+	}
+	res.encodedProofs = encodedProofs
+	res.dependencyChain = strings.Join(sharding.MergeDependencyChains(proofs), ";")
+	res.conflictTypes = formatConflictTypes(sharding.MergeConflictTypes(proofs))
+	depList := make([]string, 0, len(depMap))
+	for dep := range depMap {
+		depList = append(depList, dep)
+	}
+	sort.Strings(depList)
+	res.dependentTxIDs = strings.Join(depList, ",")
+
+	done := make(chan struct{})
+	close(done)
+	e.speculative.mu.Lock()
+	if e.speculative.results == nil {
+		e.speculative.results = make(map[string]*speculativeResult)
+	}
+	e.speculative.results[txID] = &speculativeResult{done: done, claims: res.claims(), expires: expires}
+	e.speculative.mu.Unlock()
+
+	e.endorsed.add(txID, tx.store, shards, expires)
+	return nil
+}
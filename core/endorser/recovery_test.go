@@ -0,0 +1,168 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package endorser
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/endorser/sharding"
+	. "github.com/onsi/gomega"
+)
+
+// recoveringStore finds the prepares it was given for the endorser peer0
+type recoveringStore struct {
+	abortingStore
+	prepares []*sharding.OriginPrepare
+}
+
+func (s *recoveringStore) PreparesOf(ctx context.Context, origin string) ([]*sharding.OriginPrepare, error) {
+	if origin != "peer0" {
+		return nil, nil
+	}
+	return s.prepares, nil
+}
+
+func TestRecoverPrepares(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	expires := time.Now().Add(time.Minute)
+	newStore := func() *recoveringStore {
+		return &recoveringStore{prepares: []*sharding.OriginPrepare{
+			{TxID: "tx1", ShardID: "mychannel/fabcar", ExpiryTime: expires, Proof: &sharding.PrepareProof{TxID: "tx1", ShardID: "mychannel/fabcar", HasDependency: true, DependentTxID: "tx0"}},
+			{TxID: "tx1", ShardID: "mychannel/marbles", ExpiryTime: expires, Proof: &sharding.PrepareProof{TxID: "tx1", ShardID: "mychannel/marbles"}},
+			{TxID: "tx2", ShardID: "mychannel/fabcar", ExpiryTime: expires},
+		}}
+	}
+
+	newJournal := func() *EndorsementJournal {
+		journal, err := NewEndorsementJournal(t.TempDir())
+		gt.Expect(err).NotTo(HaveOccurred())
+		t.Cleanup(func() { journal.Close() })
+		return journal
+	}
+
+	// prepares carry the ID of the endorser
+	store := newStore()
+	e := &Endorser{
+		Config:          EndorserConfig{EndorserID: "peer0", PrepareTimeout: time.Second, RecoveryPolicy: RecoveryAbort},
+		DependencyStore: store,
+		Journal:         newJournal(),
+	}
+	recording := &recordingStore{}
+	_, err := e.resolveDependencies(context.Background(), "mychannel", "tx9", DefaultLane, recording, map[string]map[string][]byte{"fabcar": {"fabcar:car1": []byte("red")}}, nil, nil, true, 0)
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(recording.reqs[0].Origin).To(Equal("peer0"))
+
+	result, err := e.RecoverPrepares(context.Background())
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(result.Aborted).To(Equal([]string{"tx1", "tx2"}))
+	gt.Expect(result.Resubscribed).To(BeEmpty())
+	gt.Expect(store.aborted).To(Equal([]string{"mychannel/fabcar/tx1", "mychannel/marbles/tx1", "mychannel/fabcar/tx2"}))
+
+	// the proofs known are served again and the transaction may be aborted,
+	// the prepare without a proof is aborted
+	store = newStore()
+	store.failing = map[string]bool{"mychannel/fabcar": true}
+	e = &Endorser{
+		Config:          EndorserConfig{EndorserID: "peer0", RecoveryPolicy: RecoveryResubscribe},
+		DependencyStore: store,
+		Journal:         newJournal(),
+	}
+	result, err = e.RecoverPrepares(context.Background())
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(result.Resubscribed).To(Equal([]string{"tx1"}))
+	gt.Expect(result.Aborted).To(BeEmpty())
+	gt.Expect(result.Failed).To(HaveKeyWithValue("tx2", "mychannel/fabcar: shard is down"))
+
+	proof, ok := e.SpeculativeProof(context.Background(), "tx1", 0)
+	gt.Expect(ok).To(BeTrue())
+	gt.Expect(proof.Done).To(BeTrue())
+	gt.Expect(proof.DependencyInfo).To(HavePrefix("HasDependency=true,DependentTxID=tx0,ShardProofs="))
+	proofs, err := sharding.DecodeProofs(strings.TrimPrefix(proof.DependencyInfo, "HasDependency=true,DependentTxID=tx0,ShardProofs="))
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(proofs).To(HaveLen(2))
+
+	store.failing = nil
+	aborted, err := e.AbortTransaction("tx1")
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(aborted.Shards).To(Equal([]string{"mychannel/fabcar", "mychannel/marbles"}))
+
+	// another endorser's prepares are left alone
+	e.Config.EndorserID = "peer1"
+	result, err = e.RecoverPrepares(context.Background())
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(result).To(Equal(&RecoveryResult{}))
+
+	// the transactions whose endorsement was returned are kept and may be
+	// aborted by their client
+	store = newStore()
+	e = &Endorser{
+		Config:          EndorserConfig{EndorserID: "peer0", RecoveryPolicy: RecoveryAbort},
+		DependencyStore: store,
+		Journal:         newJournal(),
+	}
+	gt.Expect(e.Journal.Record("tx1", expires)).To(Succeed())
+	result, err = e.RecoverPrepares(context.Background())
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(result.Endorsed).To(Equal([]string{"tx1"}))
+	gt.Expect(result.Aborted).To(Equal([]string{"tx2"}))
+	gt.Expect(store.aborted).To(Equal([]string{"mychannel/fabcar/tx2"}))
+	aborted, err = e.AbortTransaction("tx1")
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(aborted.Shards).To(Equal([]string{"mychannel/fabcar", "mychannel/marbles"}))
+
+	e.Config.RecoveryPolicy = ""
+	_, err = e.RecoverPrepares(context.Background())
+	gt.Expect(err).To(MatchError(`invalid recovery policy ""`))
+
+	e.Config.RecoveryPolicy = RecoveryAbort
+	e.Journal = nil
+	_, err = e.RecoverPrepares(context.Background())
+	gt.Expect(err).To(MatchError("recovering prepares requires an endorsement journal"))
+}
+
+func TestEndorsementJournal(t *testing.T) {
+	gt := NewGomegaWithT(t)
+	dir := t.TempDir()
+
+	journal, err := NewEndorsementJournal(dir)
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(journal.Record("tx1", time.Now().Add(time.Minute))).To(Succeed())
+	gt.Expect(journal.Record("tx2", time.Now().Add(-time.Second))).To(Succeed())
+	gt.Expect(journal.Returned("tx1")).To(BeTrue())
+	gt.Expect(journal.Returned("tx2")).To(BeFalse())
+	gt.Expect(journal.Returned("tx3")).To(BeFalse())
+	gt.Expect(journal.Close()).To(Succeed())
+
+	// a record half written by a crash is skipped
+	f, err := os.OpenFile(filepath.Join(dir, endorsementJournalName), os.O_APPEND|os.O_WRONLY, 0o644)
+	gt.Expect(err).NotTo(HaveOccurred())
+	_, err = f.WriteString(`{"TxID":"tx3","Exp`)
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(f.Close()).To(Succeed())
+
+	// the transactions survive a restart, until their reservations expire
+	journal, err = NewEndorsementJournal(dir)
+	gt.Expect(err).NotTo(HaveOccurred())
+	defer journal.Close()
+	gt.Expect(journal.Returned("tx1")).To(BeTrue())
+	gt.Expect(journal.Returned("tx3")).To(BeFalse())
+	gt.Expect(journal.returned).To(HaveLen(1))
+
+	// the records of expired transactions are dropped as the journal grows
+	for i := 0; i < minJournalCompaction; i++ {
+		gt.Expect(journal.Record(fmt.Sprintf("old%d", i), time.Now().Add(-time.Second))).To(Succeed())
+	}
+	gt.Expect(journal.records).To(BeNumerically("<", minJournalCompaction))
+	gt.Expect(journal.Returned("tx1")).To(BeTrue())
+}
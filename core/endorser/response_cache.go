@@ -0,0 +1,131 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package endorser
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-protos-go/ledger/rwset/kvrwset"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/hyperledger/fabric/core/ledger"
+)
+
+// DefaultResponseCacheSize caps the number of simulations cached when
+// ResponseCacheConfig.MaxEntries is unset
+const DefaultResponseCacheSize = 1000
+
+// ResponseCacheConfig caches the simulation of read-only invocations, so that
+// repeating one skips the chaincode. The endorsement itself, and the prepare
+// of its keys on the shards, are still done for each proposal.
+type ResponseCacheConfig struct {
+	// TTL is how long a simulation is reused. Caching is disabled when it
+	// is 0.
+	TTL time.Duration
+	// MaxEntries defaults to DefaultResponseCacheSize
+	MaxEntries int
+}
+
+// cachedSimulation holds the outcome of a read-only simulation
+type cachedSimulation struct {
+	response         *pb.Response
+	simulationResult *ledger.TxSimulationResults
+	ccevent          *pb.ChaincodeEvent
+	ccInterest       *pb.ChaincodeInterest
+	// reads holds the keys read, as namespace:key
+	reads []string
+}
+
+// responseCache holds the cached simulations of the endorser by
+// responseCacheKey
+type responseCache struct {
+	entries expiringCache[*cachedSimulation]
+}
+
+// responseCacheKey identifies an invocation of a chaincode version by a
+// client. The proposal payload holds the arguments and the transient data.
+func responseCacheKey(up *UnpackedProposal, version string) string {
+	h := sha256.New()
+	for _, part := range [][]byte{
+		[]byte(up.ChannelID()),
+		[]byte(up.ChaincodeName),
+		[]byte(version),
+		up.SignatureHeader.Creator,
+		up.Proposal.Payload,
+	} {
+		h.Write(part)
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// readOnlyKeys returns the keys read by a simulation, or false if it writes
+// anything or reads what the shards cannot tell was written since: ranges and
+// private data
+func readOnlyKeys(simResult *ledger.TxSimulationResults) ([]string, bool) {
+	if simResult == nil || simResult.PubSimulationResults == nil || simResult.PvtSimulationResults != nil {
+		return nil, false
+	}
+	var reads []string
+	for _, nsRWSet := range simResult.PubSimulationResults.NsRwset {
+		if len(nsRWSet.CollectionHashedRwset) > 0 {
+			return nil, false
+		}
+		kvRWSet := &kvrwset.KVRWSet{}
+		if err := proto.Unmarshal(nsRWSet.Rwset, kvRWSet); err != nil {
+			return nil, false
+		}
+		if len(kvRWSet.Writes) > 0 || len(kvRWSet.MetadataWrites) > 0 || len(kvRWSet.RangeQueriesInfo) > 0 {
+			return nil, false
+		}
+		for _, read := range kvRWSet.Reads {
+			reads = append(reads, nsRWSet.Namespace+":"+read.Key)
+		}
+	}
+	return reads, true
+}
+
+// lookup returns a copy of the cached simulation, whose response the caller
+// may modify
+func (c *responseCache) lookup(key string) (*cachedSimulation, bool) {
+	entry, ok := c.entries.lookup(key)
+	if !ok {
+		return nil, false
+	}
+	hit := *entry
+	hit.response = proto.Clone(entry.response).(*pb.Response)
+	return &hit, true
+}
+
+// store caches a simulation for ttl, making room among at most maxEntries
+func (c *responseCache) store(key string, entry *cachedSimulation, ttl time.Duration, maxEntries int) {
+	if maxEntries <= 0 {
+		maxEntries = DefaultResponseCacheSize
+	}
+	entry.response = proto.Clone(entry.response).(*pb.Response)
+	c.entries.store(key, entry, ttl, maxEntries)
+}
+
+// remove drops a cached simulation
+func (c *responseCache) remove(key string) {
+	c.entries.remove(key)
+}
+
+// invalidate drops the cached simulations that read any of the keys, given
+// as namespace:key, and returns how many were dropped
+func (c *responseCache) invalidate(keys map[string]bool) int {
+	return c.entries.removeIf(func(entry *cachedSimulation) bool {
+		for _, read := range entry.reads {
+			if keys[read] {
+				return true
+			}
+		}
+		return false
+	})
+}
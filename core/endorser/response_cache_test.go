@@ -0,0 +1,142 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package endorser
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	cb "github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric-protos-go/ledger/rwset"
+	"github.com/hyperledger/fabric-protos-go/ledger/rwset/kvrwset"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/hyperledger/fabric/core/ledger"
+	. "github.com/onsi/gomega"
+)
+
+func TestReadOnlyKeys(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	simResult := func(kvRWSet *kvrwset.KVRWSet) *ledger.TxSimulationResults {
+		bytes, err := proto.Marshal(kvRWSet)
+		gt.Expect(err).NotTo(HaveOccurred())
+		return &ledger.TxSimulationResults{
+			PubSimulationResults: &rwset.TxReadWriteSet{
+				NsRwset: []*rwset.NsReadWriteSet{{Namespace: "fabcar", Rwset: bytes}},
+			},
+		}
+	}
+
+	reads, ok := readOnlyKeys(simResult(&kvrwset.KVRWSet{
+		Reads: []*kvrwset.KVRead{{Key: "car1"}, {Key: "car2"}},
+	}))
+	gt.Expect(ok).To(BeTrue())
+	gt.Expect(reads).To(Equal([]string{"fabcar:car1", "fabcar:car2"}))
+
+	for _, kvRWSet := range []*kvrwset.KVRWSet{
+		{Writes: []*kvrwset.KVWrite{{Key: "car1", Value: []byte("red")}}},
+		{MetadataWrites: []*kvrwset.KVMetadataWrite{{Key: "car1"}}},
+		{RangeQueriesInfo: []*kvrwset.RangeQueryInfo{{StartKey: "car0", EndKey: "car9"}}},
+	} {
+		_, ok = readOnlyKeys(simResult(kvRWSet))
+		gt.Expect(ok).To(BeFalse())
+	}
+
+	private := simResult(&kvrwset.KVRWSet{})
+	private.PubSimulationResults.NsRwset[0].CollectionHashedRwset = []*rwset.CollectionHashedReadWriteSet{{CollectionName: "owners"}}
+	_, ok = readOnlyKeys(private)
+	gt.Expect(ok).To(BeFalse())
+
+	_, ok = readOnlyKeys(nil)
+	gt.Expect(ok).To(BeFalse())
+}
+
+func TestResponseCacheKey(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	proposal := func(creator, payload string) *UnpackedProposal {
+		return &UnpackedProposal{
+			ChaincodeName:   "fabcar",
+			ChannelHeader:   &cb.ChannelHeader{ChannelId: "mychannel", TxId: creator + payload},
+			SignatureHeader: &cb.SignatureHeader{Creator: []byte(creator)},
+			Proposal:        &pb.Proposal{Payload: []byte(payload)},
+		}
+	}
+
+	key := responseCacheKey(proposal("alice", "query car1"), "1.0")
+	gt.Expect(responseCacheKey(proposal("alice", "query car1"), "1.0")).To(Equal(key))
+	gt.Expect(responseCacheKey(proposal("alice", "query car1"), "2.0")).NotTo(Equal(key))
+	gt.Expect(responseCacheKey(proposal("bob", "query car1"), "1.0")).NotTo(Equal(key))
+	gt.Expect(responseCacheKey(proposal("alice", "query car2"), "1.0")).NotTo(Equal(key))
+}
+
+func TestResponseCache(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	c := &responseCache{}
+	_, ok := c.lookup("q1")
+	gt.Expect(ok).To(BeFalse())
+
+	response := &pb.Response{Status: 200, Payload: []byte("red")}
+	c.store("q1", &cachedSimulation{response: response, reads: []string{"fabcar:car1"}}, time.Minute, 0)
+	response.Message = "changed after caching"
+
+	hit, ok := c.lookup("q1")
+	gt.Expect(ok).To(BeTrue())
+	gt.Expect(hit.response.Message).To(BeEmpty())
+	// the caller may modify the response it got
+	hit.response.Message = "; DependencyInfo:HasDependency=false,DependentTxID="
+	hit, _ = c.lookup("q1")
+	gt.Expect(hit.response.Message).To(BeEmpty())
+
+	// a write to a key read drops the entry
+	c.store("q2", &cachedSimulation{response: response, reads: []string{"fabcar:car2"}}, time.Minute, 0)
+	gt.Expect(c.invalidate(map[string]bool{"fabcar:car1": true, "marbles:car2": true})).To(Equal(1))
+	_, ok = c.lookup("q1")
+	gt.Expect(ok).To(BeFalse())
+	_, ok = c.lookup("q2")
+	gt.Expect(ok).To(BeTrue())
+
+	// the entry expiring first makes room beyond maxEntries
+	c.store("q3", &cachedSimulation{response: response}, 2*time.Minute, 2)
+	c.store("q4", &cachedSimulation{response: response}, 2*time.Minute, 2)
+	_, ok = c.lookup("q2")
+	gt.Expect(ok).To(BeFalse())
+	_, ok = c.lookup("q4")
+	gt.Expect(ok).To(BeTrue())
+
+	c.store("q5", &cachedSimulation{response: response}, time.Millisecond, 0)
+	time.Sleep(5 * time.Millisecond)
+	_, ok = c.lookup("q5")
+	gt.Expect(ok).To(BeFalse())
+
+	c.remove("q3")
+	_, ok = c.lookup("q3")
+	gt.Expect(ok).To(BeFalse())
+}
+
+func TestResolveDependenciesInvalidatesCachedReads(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	e := &Endorser{Config: EndorserConfig{PrepareTimeout: time.Second}}
+	e.responses.store("q1", &cachedSimulation{response: &pb.Response{Status: 200}, reads: []string{"fabcar:car1"}}, time.Minute, 0)
+
+	// reading the key leaves the cached simulation in place
+	shards := map[string]map[string][]byte{"fabcar": {"fabcar:car1": []byte("1-0")}}
+	_, err := e.resolveDependencies(context.Background(), "mychannel", "tx1", DefaultLane, &flakyStore{}, shards, nil, nil, false, 0)
+	gt.Expect(err).NotTo(HaveOccurred())
+	_, ok := e.responses.lookup("q1")
+	gt.Expect(ok).To(BeTrue())
+
+	shards = map[string]map[string][]byte{"fabcar": {"fabcar:car1": []byte("red")}}
+	_, err = e.resolveDependencies(context.Background(), "mychannel", "tx2", DefaultLane, &flakyStore{}, shards, nil, nil, true, 0)
+	gt.Expect(err).NotTo(HaveOccurred())
+	_, ok = e.responses.lookup("q1")
+	gt.Expect(ok).To(BeFalse())
+}
@@ -0,0 +1,101 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package endorser
+
+import (
+	"sync"
+	"time"
+)
+
+// settleWeight is the weight of the latest sample in the moving averages of
+// the block intervals and the prepare latency
+const settleWeight = 0.2
+
+// blockCadence follows the height of the ledger of a channel
+type blockCadence struct {
+	height    uint64
+	changedAt time.Time
+	// interval is the moving average of the time between two blocks, 0
+	// until two heights were observed
+	interval time.Duration
+}
+
+// settleEstimator estimates how long until the transactions a proposal
+// depends on are committed, from the intervals between the blocks of each
+// channel and the time the shards take to commit a prepare
+type settleEstimator struct {
+	mu       sync.Mutex
+	channels map[string]*blockCadence
+	// prepare is the moving average of the prepares that got a proof
+	prepare time.Duration
+}
+
+// average moves the average toward the sample
+func average(avg, sample time.Duration) time.Duration {
+	if avg == 0 {
+		return sample
+	}
+	return avg + time.Duration(settleWeight*float64(sample-avg))
+}
+
+// observeHeight records the height of the ledger of the channel at the time.
+// The blocks committed since the previous change share the time elapsed.
+func (s *settleEstimator) observeHeight(channel string, height uint64, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.channels == nil {
+		s.channels = make(map[string]*blockCadence)
+	}
+	c, ok := s.channels[channel]
+	if !ok {
+		s.channels[channel] = &blockCadence{height: height, changedAt: at}
+		return
+	}
+	if height <= c.height {
+		return
+	}
+	sample := at.Sub(c.changedAt) / time.Duration(height-c.height)
+	c.interval = average(c.interval, sample)
+	c.height, c.changedAt = height, at
+}
+
+// observePrepare records the time a shard took to commit a prepare
+func (s *settleEstimator) observePrepare(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prepare = average(s.prepare, d)
+}
+
+// estimate returns how long until a transaction in flight on the channel is
+// likely committed: until the next block when its prepares on the shards
+// can complete before, or else until a whole interval after they complete.
+// It returns 0 when the blocks of the channel were not timed yet.
+func (s *settleEstimator) estimate(channel string, now time.Time) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.channels[channel]
+	if !ok || c.interval == 0 {
+		return 0
+	}
+	wait := c.interval - now.Sub(c.changedAt) - s.prepare
+	if wait <= 0 {
+		wait = c.interval
+	}
+	return s.prepare + wait
+}
+
+// estimateSettle sets how long until the transactions the proposal depends
+// on are likely committed, timing the blocks of the channel on the way
+func (e *Endorser) estimateSettle(channel string, deps *dependencyResolution) {
+	now := time.Now()
+	if height, err := e.Support.GetLedgerHeight(channel); err == nil {
+		e.settle.observeHeight(channel, height, now)
+	}
+	if deps.hasDependency && deps.dependentTxIDs != "" {
+		deps.settleEstimate = e.settle.estimate(channel, now)
+	}
+}
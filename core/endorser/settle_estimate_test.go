@@ -0,0 +1,43 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package endorser
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestSettleEstimator(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	var s settleEstimator
+	start := time.Unix(1000, 0)
+
+	// unknown until two heights were observed
+	s.observeHeight("mychannel", 10, start)
+	gt.Expect(s.estimate("mychannel", start)).To(BeZero())
+	s.observeHeight("mychannel", 10, start.Add(time.Second))
+	gt.Expect(s.estimate("mychannel", start)).To(BeZero())
+
+	// two blocks in 4s
+	s.observeHeight("mychannel", 12, start.Add(4*time.Second))
+	gt.Expect(s.estimate("mychannel", start.Add(4*time.Second))).To(Equal(2 * time.Second))
+	gt.Expect(s.estimate("marbles", start.Add(4*time.Second))).To(BeZero())
+
+	// the next block is due in 1.5s, after the prepares complete
+	s.observePrepare(500 * time.Millisecond)
+	gt.Expect(s.estimate("mychannel", start.Add(4500*time.Millisecond))).To(Equal(1500 * time.Millisecond))
+
+	// the prepares would miss the next block, the one after is awaited
+	gt.Expect(s.estimate("mychannel", start.Add(5700*time.Millisecond))).To(Equal(2500 * time.Millisecond))
+
+	// the average moves toward a slower block
+	s.observeHeight("mychannel", 13, start.Add(16*time.Second))
+	gt.Expect(s.channels["mychannel"].interval).To(Equal(4 * time.Second))
+}
@@ -0,0 +1,140 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package endorser
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ShardBreakerPolicyEnvVar selects what happens to a proposal touching a
+	// shard whose circuit breaker is open: ShardBreakerFailFast (the
+	// default) or ShardBreakerBypass
+	ShardBreakerPolicyEnvVar = "FABRIC_SHARD_BREAKER_POLICY"
+	// ShardBreakerThresholdEnvVar is the number of consecutive failed
+	// prepares after which the breaker of a shard opens
+	ShardBreakerThresholdEnvVar = "FABRIC_SHARD_BREAKER_THRESHOLD"
+	// ShardBreakerTimeoutEnvVar is how long the breaker of a shard stays open
+	// before a prepare is let through to probe it, as a Go duration
+	ShardBreakerTimeoutEnvVar = "FABRIC_SHARD_BREAKER_TIMEOUT"
+)
+
+// ShardBreakerPolicy decides how proposals treat a shard whose circuit
+// breaker is open
+type ShardBreakerPolicy string
+
+const (
+	// ShardBreakerFailFast fails the proposal without contacting the shard
+	ShardBreakerFailFast ShardBreakerPolicy = "fail"
+	// ShardBreakerBypass endorses the proposal without a proof from the
+	// shard, so that no dependency on its keys is recorded
+	ShardBreakerBypass ShardBreakerPolicy = "bypass"
+)
+
+// DefaultShardCircuitBreakerConfig opens the breaker of a shard after three
+// failed prepares in a row and probes it again after ten seconds
+func DefaultShardCircuitBreakerConfig() CircuitBreakerConfig {
+	return CircuitBreakerConfig{
+		Threshold: 3,
+		Timeout:   10 * time.Second,
+	}
+}
+
+// ShardCircuitBreakers keeps one CircuitBreaker per shard, so that a failing
+// shard does not slow down proposals for the healthy ones
+type ShardCircuitBreakers struct {
+	config   CircuitBreakerConfig
+	policy   ShardBreakerPolicy
+	metrics  *Metrics
+	mu       sync.Mutex
+	breakers map[string]*CircuitBreaker
+}
+
+// NewShardCircuitBreakers creates the breakers of shards as they are first
+// used
+func NewShardCircuitBreakers(config CircuitBreakerConfig, policy ShardBreakerPolicy, metrics *Metrics) *ShardCircuitBreakers {
+	return &ShardCircuitBreakers{
+		config:   config,
+		policy:   policy,
+		metrics:  metrics,
+		breakers: make(map[string]*CircuitBreaker),
+	}
+}
+
+// NewShardCircuitBreakersFromEnv creates shard circuit breakers configured
+// through ShardBreakerPolicyEnvVar, ShardBreakerThresholdEnvVar and
+// ShardBreakerTimeoutEnvVar. Invalid values are logged and replaced by the
+// defaults.
+func NewShardCircuitBreakersFromEnv(metrics *Metrics) *ShardCircuitBreakers {
+	config := DefaultShardCircuitBreakerConfig()
+	if value := strings.TrimSpace(os.Getenv(ShardBreakerThresholdEnvVar)); value != "" {
+		threshold, err := strconv.Atoi(value)
+		if err != nil || threshold <= 0 {
+			logger.Warningf("Ignoring invalid %s %q", ShardBreakerThresholdEnvVar, value)
+		} else {
+			config.Threshold = threshold
+		}
+	}
+	if value := strings.TrimSpace(os.Getenv(ShardBreakerTimeoutEnvVar)); value != "" {
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout <= 0 {
+			logger.Warningf("Ignoring invalid %s %q", ShardBreakerTimeoutEnvVar, value)
+		} else {
+			config.Timeout = timeout
+		}
+	}
+
+	policy := ShardBreakerFailFast
+	switch value := ShardBreakerPolicy(strings.TrimSpace(os.Getenv(ShardBreakerPolicyEnvVar))); value {
+	case "", ShardBreakerFailFast:
+	case ShardBreakerBypass:
+		policy = ShardBreakerBypass
+	default:
+		logger.Warningf("Ignoring unknown %s %q", ShardBreakerPolicyEnvVar, value)
+	}
+
+	return NewShardCircuitBreakers(config, policy, metrics)
+}
+
+// Policy returns how proposals treat a shard whose breaker is open
+func (b *ShardCircuitBreakers) Policy() ShardBreakerPolicy {
+	return b.policy
+}
+
+// Breaker returns the circuit breaker of the shard
+func (b *ShardCircuitBreakers) Breaker(shardID string) *CircuitBreaker {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if cb, ok := b.breakers[shardID]; ok {
+		return cb
+	}
+	cb := NewCircuitBreaker(b.config, nil)
+	if b.metrics != nil && b.metrics.ShardCircuitBreakerOpen != nil {
+		cb.openCounter = b.metrics.ShardCircuitBreakerOpen.With("shard", shardID)
+		cb.halfOpenCounter = b.metrics.ShardCircuitBreakerHalfOpen.With("shard", shardID)
+		cb.closedCounter = b.metrics.ShardCircuitBreakerClosed.With("shard", shardID)
+	}
+	b.breakers[shardID] = cb
+	return cb
+}
+
+// States returns the state of the breaker of every shard used so far
+func (b *ShardCircuitBreakers) States() map[string]CircuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	states := make(map[string]CircuitState, len(b.breakers))
+	for shardID, cb := range b.breakers {
+		states[shardID] = cb.GetState()
+	}
+	return states
+}
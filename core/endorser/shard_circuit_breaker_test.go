@@ -0,0 +1,122 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package endorser
+
+import (
+	"context"
+	"crypto/ed25519"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/common/metrics/metricsfakes"
+	"github.com/hyperledger/fabric/core/endorser/sharding"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+)
+
+// shardStore fails the prepares of the shards listed in failing
+type shardStore struct {
+	failing  map[string]bool
+	prepared map[string]int
+}
+
+func (s *shardStore) Prepare(ctx context.Context, req *sharding.PrepareRequest) (*sharding.PrepareProof, error) {
+	s.prepared[req.ShardID]++
+	if s.failing[req.ShardID] {
+		return nil, errors.New("timeout waiting for proof")
+	}
+	return &sharding.PrepareProof{TxID: req.TxID, ShardID: req.ShardID, CommitIndex: 1, SignerID: 1, Signature: make([]byte, ed25519.SignatureSize)}, nil
+}
+
+func (s *shardStore) Abort(shardID, txID string) error {
+	return nil
+}
+
+func TestShardCircuitBreakersFromEnv(t *testing.T) {
+	gt := NewGomegaWithT(t)
+	defer os.Unsetenv(ShardBreakerPolicyEnvVar)
+	defer os.Unsetenv(ShardBreakerThresholdEnvVar)
+	defer os.Unsetenv(ShardBreakerTimeoutEnvVar)
+
+	b := NewShardCircuitBreakersFromEnv(nil)
+	gt.Expect(b.Policy()).To(Equal(ShardBreakerFailFast))
+	gt.Expect(b.config).To(Equal(DefaultShardCircuitBreakerConfig()))
+
+	os.Setenv(ShardBreakerPolicyEnvVar, "bypass")
+	os.Setenv(ShardBreakerThresholdEnvVar, "7")
+	os.Setenv(ShardBreakerTimeoutEnvVar, "1m")
+	b = NewShardCircuitBreakersFromEnv(nil)
+	gt.Expect(b.Policy()).To(Equal(ShardBreakerBypass))
+	gt.Expect(b.config).To(Equal(CircuitBreakerConfig{Threshold: 7, Timeout: time.Minute}))
+
+	os.Setenv(ShardBreakerPolicyEnvVar, "retry")
+	os.Setenv(ShardBreakerThresholdEnvVar, "0")
+	os.Setenv(ShardBreakerTimeoutEnvVar, "soon")
+	b = NewShardCircuitBreakersFromEnv(nil)
+	gt.Expect(b.Policy()).To(Equal(ShardBreakerFailFast))
+	gt.Expect(b.config).To(Equal(DefaultShardCircuitBreakerConfig()))
+}
+
+func TestPrepareOnShardCircuitBreaker(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	opened := &metricsfakes.Counter{}
+	openCounter := &metricsfakes.Counter{}
+	openCounter.WithReturns(opened)
+	metrics := &Metrics{
+		ShardCircuitBreakerOpen:     openCounter,
+		ShardCircuitBreakerHalfOpen: &metricsfakes.Counter{},
+		ShardCircuitBreakerClosed:   &metricsfakes.Counter{},
+	}
+
+	store := &shardStore{failing: map[string]bool{"marbles": true}, prepared: make(map[string]int)}
+	e := &Endorser{ShardCircuitBreakers: NewShardCircuitBreakers(CircuitBreakerConfig{Threshold: 2, Timeout: 50 * time.Millisecond}, ShardBreakerFailFast, metrics)}
+	prepare := func(shardID string) error {
+		_, err := e.prepareOnShard(context.Background(), "mychannel", store, &sharding.PrepareRequest{TxID: "tx1", ShardID: shardID})
+		return err
+	}
+
+	gt.Expect(prepare("marbles")).To(MatchError("failed to prepare tx on shard marbles: timeout waiting for proof"))
+	gt.Expect(prepare("marbles")).To(MatchError("failed to prepare tx on shard marbles: timeout waiting for proof"))
+	gt.Expect(openCounter.WithArgsForCall(0)).To(Equal([]string{"shard", "marbles"}))
+	gt.Expect(opened.AddCallCount()).To(Equal(1))
+
+	// the open breaker fails fast without contacting the shard
+	err := prepare("marbles")
+	gt.Expect(errors.Is(err, ErrCircuitOpen)).To(BeTrue())
+	gt.Expect(err).To(MatchError("shard marbles is unavailable: circuit breaker is open"))
+	gt.Expect(store.prepared["marbles"]).To(Equal(2))
+
+	// healthy shards are unaffected
+	gt.Expect(prepare("fabcar")).To(Succeed())
+	gt.Expect(e.ShardCircuitBreakers.States()).To(Equal(map[string]CircuitState{"marbles": CircuitOpen, "fabcar": CircuitClosed}))
+
+	// once the timeout passed, a successful probe closes the breaker
+	time.Sleep(60 * time.Millisecond)
+	store.failing["marbles"] = false
+	gt.Expect(prepare("marbles")).To(Succeed())
+	gt.Expect(store.prepared["marbles"]).To(Equal(3))
+	gt.Expect(e.ShardCircuitBreakers.Breaker("marbles").GetState()).To(Equal(CircuitClosed))
+
+	// invalid proofs count as failures
+	e.ShardCircuitBreakers = NewShardCircuitBreakers(CircuitBreakerConfig{Threshold: 1, Timeout: time.Minute}, ShardBreakerFailFast, nil)
+	_, err = e.prepareOnShard(context.Background(), "mychannel", &invalidProofStore{}, &sharding.PrepareRequest{TxID: "tx1", ShardID: "fabcar"})
+	gt.Expect(err).To(MatchError(ContainSubstring("invalid proof from shard fabcar")))
+	gt.Expect(e.ShardCircuitBreakers.Breaker("fabcar").GetState()).To(Equal(CircuitOpen))
+}
+
+// invalidProofStore answers every prepare with the proof of another transaction
+type invalidProofStore struct{}
+
+func (s *invalidProofStore) Prepare(ctx context.Context, req *sharding.PrepareRequest) (*sharding.PrepareProof, error) {
+	return &sharding.PrepareProof{TxID: "other", ShardID: req.ShardID}, nil
+}
+
+func (s *invalidProofStore) Abort(shardID, txID string) error {
+	return nil
+}
@@ -0,0 +1,127 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package endorser
+
+import (
+	"fmt"
+
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/hyperledger/fabric/core/endorser/sharding"
+	"github.com/pkg/errors"
+)
+
+// Statuses of the responses to proposals that could not gather the proofs
+// of the shards, by cause. The message names the cause as well, as
+// ShardError=<cause>, so that clients can decide whether to retry.
+const (
+	// StatusDependencyConflict is the status of a proposal rejected by a
+	// shard under its conflict policy, or whose dependencies form a cycle.
+	// It fails the same way until the transactions it conflicts with
+	// commit or expire.
+	StatusDependencyConflict = 424
+	// StatusInvalidShardProof is the status of a proposal answered by a
+	// shard with a proof that failed verification
+	StatusInvalidShardProof = 502
+	// StatusShardQueueFull is the status of a proposal that a shard, or
+	// the prepare lanes of the endorser, had no room for. The client may
+	// retry it after backing off.
+	StatusShardQueueFull = 503
+	// StatusShardTimeout is the status of a proposal that a shard did not
+	// prepare within the PrepareTimeout, retries included
+	StatusShardTimeout = 504
+)
+
+// ShardFailure is the cause of a failure to gather the proof of a shard
+type ShardFailure string
+
+const (
+	ShardTimeout            ShardFailure = "timeout"
+	ShardQueueFull          ShardFailure = "queue_full"
+	ShardInvalidProof       ShardFailure = "invalid_proof"
+	ShardDependencyConflict ShardFailure = "dependency_conflict"
+)
+
+// shardFailurePriority orders the causes reported for a proposal that
+// failed on several shards for different reasons: the first ones are the
+// least likely to go away on a retry
+var shardFailurePriority = []ShardFailure{ShardDependencyConflict, ShardInvalidProof, ShardQueueFull, ShardTimeout}
+
+// status returns the response status of the cause
+func (f ShardFailure) status() int32 {
+	switch f {
+	case ShardTimeout:
+		return StatusShardTimeout
+	case ShardQueueFull:
+		return StatusShardQueueFull
+	case ShardInvalidProof:
+		return StatusInvalidShardProof
+	case ShardDependencyConflict:
+		return StatusDependencyConflict
+	default:
+		return 500
+	}
+}
+
+// ShardError is the error of a proposal that could not gather the proofs of
+// the shards, with the cause of the failure
+type ShardError struct {
+	Cause ShardFailure
+	Err   error
+}
+
+func (e *ShardError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ShardError) Unwrap() error {
+	return e.Err
+}
+
+// shardFailure returns the cause of the error of a prepare on a shard, or ""
+// when it has none of the known causes
+func shardFailure(err error) ShardFailure {
+	var shardErr *ShardError
+	switch {
+	case errors.As(err, &shardErr):
+		return shardErr.Cause
+	case errors.Is(err, errInvalidProof):
+		return ShardInvalidProof
+	case errors.Is(err, sharding.ErrQueueFull):
+		return ShardQueueFull
+	default:
+		return ""
+	}
+}
+
+// gatherError returns the error of a proposal that failed on the shards with
+// the given errors, marked with the cause that ranks first among theirs
+func gatherError(shardErrors []error) error {
+	err := errors.Errorf("failed to gather dependency proofs: %v", shardErrors)
+	causes := make(map[ShardFailure]bool)
+	for _, shardErr := range shardErrors {
+		causes[shardFailure(shardErr)] = true
+	}
+	for _, cause := range shardFailurePriority {
+		if causes[cause] {
+			return &ShardError{Cause: cause, Err: err}
+		}
+	}
+	return err
+}
+
+// errorResponse returns the response to a proposal that failed with err: the
+// status of the cause of a ShardError, or 500 for any other error
+func errorResponse(err error) *pb.ProposalResponse {
+	var shardErr *ShardError
+	if !errors.As(err, &shardErr) {
+		return &pb.ProposalResponse{Response: &pb.Response{Status: 500, Message: err.Error()}}
+	}
+	return &pb.ProposalResponse{Response: &pb.Response{
+		Status:  shardErr.Cause.status(),
+		Message: fmt.Sprintf("%s; ShardError=%s", err, shardErr.Cause),
+	}}
+}
@@ -0,0 +1,77 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package endorser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/endorser/sharding"
+	. "github.com/onsi/gomega"
+)
+
+func TestShardFailure(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	gt.Expect(shardFailure(fmt.Errorf("%w from shard fabcar: bad signature", errInvalidProof))).To(Equal(ShardInvalidProof))
+	gt.Expect(shardFailure(fmt.Errorf("remote error: 429 Too Many Requests: %w", sharding.ErrQueueFull))).To(Equal(ShardQueueFull))
+	gt.Expect(shardFailure(&ShardError{Cause: ShardTimeout, Err: errors.New("timeout")})).To(Equal(ShardTimeout))
+	gt.Expect(shardFailure(errors.New("connection refused"))).To(BeEmpty())
+}
+
+func TestGatherError(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	timeout := &ShardError{Cause: ShardTimeout, Err: errors.New("timeout waiting for proof from shard fabcar")}
+	conflict := &ShardError{Cause: ShardDependencyConflict, Err: errors.New("shard marbles rejected tx conflicting with tx1 under reject policy")}
+
+	err := gatherError([]error{timeout, conflict})
+	gt.Expect(err).To(MatchError("failed to gather dependency proofs: [timeout waiting for proof from shard fabcar shard marbles rejected tx conflicting with tx1 under reject policy]"))
+	gt.Expect(shardFailure(err)).To(Equal(ShardDependencyConflict))
+
+	gt.Expect(shardFailure(gatherError([]error{errors.New("connection refused"), timeout}))).To(Equal(ShardTimeout))
+	gt.Expect(shardFailure(gatherError([]error{errors.New("connection refused")}))).To(BeEmpty())
+}
+
+func TestErrorResponse(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	resp := errorResponse(errors.New("error in simulation"))
+	gt.Expect(resp.Response.Status).To(Equal(int32(500)))
+	gt.Expect(resp.Response.Message).To(Equal("error in simulation"))
+
+	for cause, status := range map[ShardFailure]int32{
+		ShardTimeout:            StatusShardTimeout,
+		ShardQueueFull:          StatusShardQueueFull,
+		ShardInvalidProof:       StatusInvalidShardProof,
+		ShardDependencyConflict: StatusDependencyConflict,
+	} {
+		resp = errorResponse(gatherError([]error{&ShardError{Cause: cause, Err: errors.New("failed")}}))
+		gt.Expect(resp.Response.Status).To(Equal(status), "cause %s", cause)
+		gt.Expect(resp.Response.Message).To(Equal(fmt.Sprintf("failed to gather dependency proofs: [failed]; ShardError=%s", cause)))
+	}
+}
+
+func TestPrepareWithRetryShardFailure(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	e := &Endorser{Config: EndorserConfig{PrepareTimeout: 20 * time.Millisecond}}
+	req := &sharding.PrepareRequest{TxID: "tx1", ShardID: "fabcar"}
+
+	_, err := e.prepareWithRetry(context.Background(), "mychannel", &flakyStore{timeouts: 1}, req)
+	gt.Expect(shardFailure(err)).To(Equal(ShardTimeout))
+
+	_, err = e.prepareWithRetry(context.Background(), "mychannel", &flakyStore{err: fmt.Errorf("shard fabcar: %w", sharding.ErrQueueFull)}, req)
+	gt.Expect(shardFailure(err)).To(Equal(ShardQueueFull))
+
+	_, err = e.prepareWithRetry(context.Background(), "mychannel", &flakyStore{err: errors.New("connection refused")}, req)
+	gt.Expect(err).To(HaveOccurred())
+	gt.Expect(shardFailure(err)).To(BeEmpty())
+}
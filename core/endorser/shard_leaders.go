@@ -0,0 +1,76 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package endorser
+
+import (
+	"fmt"
+
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// StatusMisdirected is the status of the response to a proposal sent to an
+// endorser that does not lead the chaincode it invokes. The message names
+// the leader endorser to send it to instead as ShardOwner=<address>.
+const StatusMisdirected = 421
+
+// shardOwner returns the leader endorser configured for the chaincode in
+// ShardLeaders, or "" when the chaincode has none of its own
+func (c EndorserConfig) shardOwner(chaincode string) string {
+	return c.ShardLeaders[chaincode]
+}
+
+// ChaincodeLeadership returns the role of this endorser for the chaincode and
+// the address of its leader endorser. A chaincode without a leader of its own
+// in ShardLeaders follows the Leadership of the channel.
+func (e *Endorser) ChaincodeLeadership(chaincode string) (EndorserRole, string) {
+	owner := e.Config.shardOwner(chaincode)
+	if owner == "" {
+		return e.Leadership()
+	}
+	if e.Config.isSelf(owner) {
+		return LeaderEndorser, owner
+	}
+	return NormalEndorser, owner
+}
+
+// misdirected returns the leader endorser of the chaincode of the proposal
+// when it is another endorser, which must then endorse the proposal so that
+// the dependencies on the chaincode are tracked in one place
+func (e *Endorser) misdirected(up *UnpackedProposal) (string, bool) {
+	if len(e.Config.ShardLeaders) == 0 || !e.Config.shardingEnabled(up.ChannelID(), up.ChaincodeName) {
+		return "", false
+	}
+	owner := e.Config.shardOwner(up.ChaincodeName)
+	if owner == "" || e.Config.isSelf(owner) {
+		return "", false
+	}
+	return owner, true
+}
+
+// withShardOwner returns the response with the leader endorser of the
+// chaincode appended to its message as ShardOwner=<address>. The response is
+// copied, since the one of a coalesced proposal is shared, and the signed
+// payload is left alone, since the endorsers of other organizations may
+// name another owner.
+func (e *Endorser) withShardOwner(pResp *pb.ProposalResponse, chaincode string) *pb.ProposalResponse {
+	owner := e.Config.shardOwner(chaincode)
+	if owner == "" || pResp == nil || pResp.Response == nil {
+		return pResp
+	}
+	return &pb.ProposalResponse{
+		Version:     pResp.Version,
+		Timestamp:   pResp.Timestamp,
+		Endorsement: pResp.Endorsement,
+		Payload:     pResp.Payload,
+		Interest:    pResp.Interest,
+		Response: &pb.Response{
+			Status:  pResp.Response.Status,
+			Message: fmt.Sprintf("%s; ShardOwner=%s", pResp.Response.Message, owner),
+			Payload: pResp.Response.Payload,
+		},
+	}
+}
@@ -0,0 +1,90 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package endorser
+
+import (
+	"testing"
+
+	cb "github.com/hyperledger/fabric-protos-go/common"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	. "github.com/onsi/gomega"
+)
+
+func TestChaincodeLeadership(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	e := &Endorser{Config: EndorserConfig{
+		Role:           NormalEndorser,
+		LeaderEndorser: "peer0:7051",
+		Address:        "peer1:7051",
+		ShardLeaders:   map[string]string{"fabcar": "peer1:7051", "marbles": "peer2:7051"},
+	}}
+
+	role, leader := e.ChaincodeLeadership("fabcar")
+	gt.Expect(role).To(Equal(LeaderEndorser))
+	gt.Expect(leader).To(Equal("peer1:7051"))
+
+	role, leader = e.ChaincodeLeadership("marbles")
+	gt.Expect(role).To(Equal(NormalEndorser))
+	gt.Expect(leader).To(Equal("peer2:7051"))
+
+	// a chaincode without a leader of its own follows the channel's
+	role, leader = e.ChaincodeLeadership("basic")
+	gt.Expect(role).To(Equal(NormalEndorser))
+	gt.Expect(leader).To(Equal("peer0:7051"))
+}
+
+func TestMisdirected(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	proposal := func(chaincode string) *UnpackedProposal {
+		return &UnpackedProposal{ChaincodeName: chaincode, ChannelHeader: &cb.ChannelHeader{ChannelId: "mychannel"}}
+	}
+	e := &Endorser{Config: EndorserConfig{
+		EndorserID:   "peer1",
+		ShardLeaders: map[string]string{"fabcar": "peer1", "marbles": "peer2:7051"},
+	}}
+
+	// the leaders only apply to the proposals prepared on the shards
+	_, ok := e.misdirected(proposal("marbles"))
+	gt.Expect(ok).To(BeFalse())
+
+	e.Config.ShardingEnabled = true
+	owner, ok := e.misdirected(proposal("marbles"))
+	gt.Expect(ok).To(BeTrue())
+	gt.Expect(owner).To(Equal("peer2:7051"))
+
+	_, ok = e.misdirected(proposal("fabcar"))
+	gt.Expect(ok).To(BeFalse())
+	_, ok = e.misdirected(proposal("basic"))
+	gt.Expect(ok).To(BeFalse())
+
+	e.Config.ShardingPolicy = ShardingPolicy{ExcludeChaincodes: []string{"marbles"}}
+	_, ok = e.misdirected(proposal("marbles"))
+	gt.Expect(ok).To(BeFalse())
+}
+
+func TestWithShardOwner(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	e := &Endorser{Config: EndorserConfig{ShardLeaders: map[string]string{"fabcar": "peer1:7051"}}}
+	resp := &pb.ProposalResponse{
+		Version:  1,
+		Payload:  []byte("payload"),
+		Response: &pb.Response{Status: 200, Message: "OK; DependencyInfo:HasDependency=false,DependentTxID="},
+	}
+
+	owned := e.withShardOwner(resp, "fabcar")
+	gt.Expect(owned.Response.Message).To(Equal("OK; DependencyInfo:HasDependency=false,DependentTxID=; ShardOwner=peer1:7051"))
+	gt.Expect(owned.Payload).To(Equal([]byte("payload")))
+	gt.Expect(owned.Version).To(Equal(int32(1)))
+	// the shared response is left alone
+	gt.Expect(resp.Response.Message).To(Equal("OK; DependencyInfo:HasDependency=false,DependentTxID="))
+
+	gt.Expect(e.withShardOwner(resp, "marbles")).To(BeIdenticalTo(resp))
+	gt.Expect(e.withShardOwner(&pb.ProposalResponse{}, "fabcar").Response).To(BeNil())
+}
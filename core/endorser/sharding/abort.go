@@ -0,0 +1,129 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"go.etcd.io/etcd/raft/v3/raftpb"
+)
+
+// DefaultAbortTimeout bounds how long an abort waits to be committed
+const DefaultAbortTimeout = 5 * time.Second
+
+// AbortRequest asks a replica of a shard to abort a transaction
+type AbortRequest struct {
+	TxID    string
+	ShardID string
+}
+
+// HandleAbort replicates the abort of the transaction through the Raft log
+// without waiting for it to be committed
+func (sl *ShardLeader) HandleAbort(txID string) error {
+	data, err := sl.serializeAbort(txID)
+	if err != nil {
+		return err
+	}
+	return sl.node.Propose(context.TODO(), data)
+}
+
+// AbortAndWait replicates the abort of the transaction through the Raft log
+// and returns the proof that acknowledges it once committed. Every replica
+// releases the reservations of the transaction when it applies the abort.
+func (sl *ShardLeader) AbortAndWait(ctx context.Context, txID string) (*PrepareProof, error) {
+	data, err := sl.serializeAbort(txID)
+	if err != nil {
+		return nil, err
+	}
+
+	ackC := make(chan *PrepareProof, 1)
+	sl.mu.Lock()
+	sl.abortWaiters[txID] = append(sl.abortWaiters[txID], ackC)
+	sl.mu.Unlock()
+	defer sl.removeAbortWaiter(txID, ackC)
+
+	if err := sl.node.Propose(ctx, data); err != nil {
+		return nil, fmt.Errorf("failed to propose abort of tx %s to shard %s: %v", txID, sl.shardID, err)
+	}
+
+	select {
+	case proof := <-ackC:
+		return proof, nil
+	case <-sl.stopC:
+		return nil, fmt.Errorf("shard %s: %w", sl.shardID, ErrStopped)
+	case <-ctx.Done():
+		return nil, fmt.Errorf("timeout waiting for abort of tx %s on shard %s", txID, sl.shardID)
+	}
+}
+
+func (sl *ShardLeader) serializeAbort(txID string) ([]byte, error) {
+	batch := &PrepareRequestBatch{
+		Aborts: []*AbortEntry{{
+			TxID:      txID,
+			Timestamp: time.Now().Unix(),
+		}},
+	}
+	return batch.Marshal()
+}
+
+func (sl *ShardLeader) removeAbortWaiter(txID string, ackC chan *PrepareProof) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	waiters := sl.abortWaiters[txID]
+	for i, w := range waiters {
+		if w == ackC {
+			waiters = append(waiters[:i], waiters[i+1:]...)
+			break
+		}
+	}
+	if len(waiters) == 0 {
+		delete(sl.abortWaiters, txID)
+	} else {
+		sl.abortWaiters[txID] = waiters
+	}
+}
+
+// applyAbort releases the reservations of an aborted transaction and
+// acknowledges the abort to local waiters. It must only be called from
+// applyEntry.
+func (sl *ShardLeader) applyAbort(abort *AbortEntry, entry raftpb.Entry) {
+	atomic.AddUint64(&sl.aborts, 1)
+	sl.releaseReservations([]string{abort.TxID})
+
+	// A retried prepare of the transaction must go through Raft again
+	sl.proofCacheLock.Lock()
+	delete(sl.proofCache, abort.TxID)
+	sl.proofCacheLock.Unlock()
+	sl.forgetProof(abort.TxID)
+
+	proof := &PrepareProof{
+		TxID:           abort.TxID,
+		ShardID:        sl.shardID,
+		CommitIndex:    entry.Index,
+		LeaderID:       sl.node.Status().Lead,
+		Term:           entry.Term,
+		ConflictPolicy: sl.conflictPolicy,
+		Aborted:        true,
+	}
+	proof.Signature, proof.SignerID = sl.signProof(abort.TxID, entry.Index, entry.Term)
+
+	sl.mu.Lock()
+	waiters := sl.abortWaiters[abort.TxID]
+	delete(sl.abortWaiters, abort.TxID)
+	sl.mu.Unlock()
+
+	for _, ackC := range waiters {
+		select {
+		case ackC <- proof:
+		default:
+		}
+	}
+	logger.Debugf("Shard %s: Released reservations of aborted tx %s at index %d", sl.shardID, abort.TxID, entry.Index)
+}
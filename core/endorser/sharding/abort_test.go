@@ -0,0 +1,79 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestShardAbortReplicates(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	peers := PeerConfig{1: freeTransportAddress(t), 2: freeTransportAddress(t)}
+	shards := make(map[uint64]*ShardLeader)
+	for id := range peers {
+		transport := NewTransport(id, peers[id], peers)
+		gt.Expect(transport.Start()).To(Succeed())
+		defer transport.Stop()
+
+		sl, err := NewShardLeader(ShardConfig{
+			ShardID:    "fabcar",
+			ReplicaIDs: []uint64{1, 2},
+			ReplicaID:  id,
+		}, DefaultBatchTimeout, DefaultBatchMaxSize)
+		gt.Expect(err).NotTo(HaveOccurred())
+		defer sl.Stop()
+		transport.RegisterShard("fabcar", sl)
+		shards[id] = sl
+	}
+
+	// Replicas campaign only after their election timeout
+	var leaderID uint64
+	gt.Eventually(func() uint64 {
+		leaderID = shards[1].GetStatus().LeaderID
+		return leaderID
+	}, 30*time.Second, 100*time.Millisecond).ShouldNot(BeZero())
+	follower := shards[3-leaderID]
+	gt.Eventually(func() uint64 { return follower.GetStatus().LeaderID }, 5*time.Second).Should(Equal(leaderID))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := follower.ProposeAndWait(ctx, &PrepareRequest{
+		TxID:     "tx1",
+		ShardID:  "fabcar",
+		WriteSet: map[string][]byte{"car1": []byte("v1")},
+	})
+	gt.Expect(err).NotTo(HaveOccurred())
+
+	dependencies := func(sl *ShardLeader) func() map[string]TransactionDependencyInfo {
+		return func() map[string]TransactionDependencyInfo {
+			backup, err := sl.Backup(time.Second)
+			if err != nil {
+				return nil
+			}
+			return backup.Dependencies
+		}
+	}
+	for _, sl := range shards {
+		gt.Eventually(dependencies(sl), 5*time.Second).Should(HaveKey("car1"))
+	}
+
+	proof, err := follower.AbortAndWait(ctx, "tx1")
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(proof.TxID).To(Equal("tx1"))
+	gt.Expect(proof.Aborted).To(BeTrue())
+	gt.Expect(VerifyPrepareProof(proof)).To(Succeed())
+	gt.Expect(follower.HasProof("tx1")).To(BeFalse())
+
+	for _, sl := range shards {
+		gt.Eventually(dependencies(sl), 5*time.Second).ShouldNot(HaveKey("car1"))
+	}
+}
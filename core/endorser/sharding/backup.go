@@ -0,0 +1,324 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// shardBackupVersion is the format version written into every backup
+const shardBackupVersion = 1
+
+// ShardBackupDirEnvVar sets the directory the admin API writes backups to and
+// restores them from. Defaults to backups under the shard data directory.
+const ShardBackupDirEnvVar = "FABRIC_SHARD_BACKUP_DIR"
+
+// ShardBackup is a consistent copy of a shard replica's state: its
+// configuration and the dependency map as of AppliedIndex
+type ShardBackup struct {
+	Version      int
+	ShardID      string
+	AppliedIndex uint64
+	CreatedAt    time.Time
+	Config       ShardConfig
+	Dependencies map[string]TransactionDependencyInfo
+	// Peers holds the addresses of replicas added at runtime
+	Peers PeerConfig `json:",omitempty"`
+	// AppliedProofs holds the proofs kept to deduplicate retried proposals
+	AppliedProofs map[string]*PrepareProof `json:",omitempty"`
+	// RangeReservations holds the ranges reserved by range queries
+	RangeReservations []RangeReservation `json:",omitempty"`
+	// Decisions holds the two-phase commit decisions still in effect
+	Decisions map[string]*DecisionEntry `json:",omitempty"`
+}
+
+// captureBackup copies the shard state. It must only be called from runApply
+// so that no entry is applied while the copy is taken.
+func (sl *ShardLeader) captureBackup() *ShardBackup {
+	sl.variableMapLock.RLock()
+	defer sl.variableMapLock.RUnlock()
+
+	backup := &ShardBackup{
+		Version:      shardBackupVersion,
+		ShardID:      sl.shardID,
+		AppliedIndex: atomic.LoadUint64(&sl.appliedIndex),
+		CreatedAt:    time.Now(),
+		Config:       sl.config,
+		Dependencies: make(map[string]TransactionDependencyInfo),
+	}
+	backup.Config.ConflictPolicy = sl.conflictPolicy
+	sl.variableMap.Range(func(key string, info TransactionDependencyInfo) {
+		backup.Dependencies[key] = info
+	})
+	sl.variableMap.RangeAppliedProofs(func(proof *PrepareProof) {
+		if backup.AppliedProofs == nil {
+			backup.AppliedProofs = make(map[string]*PrepareProof)
+		}
+		backup.AppliedProofs[proof.TxID] = proof
+	})
+	backup.RangeReservations = sl.RangeReservations()
+	sl.mu.RLock()
+	backup.Peers = sl.copyPeerAddrsLocked()
+	sl.mu.RUnlock()
+	backup.Decisions = sl.copyDecisions()
+	return backup
+}
+
+// restoreBackup restores the state of a backup the dependency map does not
+// hold, as restoreSnapshot does
+func (sl *ShardLeader) restoreBackup(backup *ShardBackup) {
+	sl.restoreRanges(backup.RangeReservations)
+	sl.mu.Lock()
+	sl.decisions = backup.Decisions
+	sl.mu.Unlock()
+}
+
+// Backup returns a consistent copy of the shard state
+func (sl *ShardLeader) Backup(timeout time.Duration) (*ShardBackup, error) {
+	resultC := make(chan *ShardBackup, 1)
+	select {
+	case sl.backupC <- resultC:
+	case <-sl.stopC:
+		return nil, fmt.Errorf("shard %s is stopped", sl.shardID)
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("timeout requesting backup of shard %s", sl.shardID)
+	}
+
+	select {
+	case backup := <-resultC:
+		return backup, nil
+	case <-sl.stopC:
+		return nil, fmt.Errorf("shard %s is stopped", sl.shardID)
+	}
+}
+
+// backupPath resolves a backup location to a local file path. Plain paths
+// and file:// URLs are supported.
+func backupPath(location string) (string, error) {
+	if location == "" {
+		return "", fmt.Errorf("backup location is empty")
+	}
+	if i := strings.Index(location, "://"); i >= 0 {
+		if scheme := location[:i]; scheme != "file" {
+			return "", fmt.Errorf("unsupported backup location scheme %q", scheme)
+		}
+		location = location[i+len("://"):]
+	}
+	return filepath.Clean(location), nil
+}
+
+// writeShardBackup writes the backup to location, replacing any existing
+// file only once the new one is completely written
+func writeShardBackup(location string, backup *ShardBackup) error {
+	path, err := backupPath(location)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(backup, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal backup of shard %s: %v", backup.ShardID, err)
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create backup file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write backup file: %v", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync backup file: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close backup file: %v", err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// readShardBackup reads a backup written by writeShardBackup
+func readShardBackup(location string) (*ShardBackup, error) {
+	path, err := backupPath(location)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup: %v", err)
+	}
+
+	backup := &ShardBackup{}
+	if err := json.Unmarshal(data, backup); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal backup: %v", err)
+	}
+	if backup.Version != shardBackupVersion {
+		return nil, fmt.Errorf("unsupported backup version %d", backup.Version)
+	}
+	return backup, nil
+}
+
+// BackupShard writes a consistent backup of the local replica of the shard
+// to location
+func (sm *ShardManager) BackupShard(shardID, location string) (*ShardBackup, error) {
+	shard, exists := sm.lookupShard(shardID)
+
+	if !exists {
+		return nil, fmt.Errorf("shard %s is not hosted on this peer", shardID)
+	}
+
+	backup, err := shard.Backup(30 * time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeShardBackup(location, backup); err != nil {
+		return nil, err
+	}
+
+	logger.Infof("Backed up shard %s at index %d (%d keys) to %s", shardID, backup.AppliedIndex, len(backup.Dependencies), location)
+	return backup, nil
+}
+
+// ShardBackupRequest is the body of the backup and restore calls of the
+// admin API. Location is a path relative to the backup directory.
+type ShardBackupRequest struct {
+	ShardID  string
+	Location string
+}
+
+// ShardBackupResponse describes the backup that was written or restored
+type ShardBackupResponse struct {
+	ShardID       string
+	SourceShardID string
+	AppliedIndex  uint64
+	Keys          int
+	Location      string
+}
+
+// shardBackupDir returns the directory of the backups of the admin API, or ""
+// when none is configured
+func (sm *ShardManager) shardBackupDir() string {
+	if sm.backupDir != "" {
+		return sm.backupDir
+	}
+	if dir := os.Getenv(ShardBackupDirEnvVar); dir != "" {
+		return dir
+	}
+	if dataDir := sm.shardDataDir(); dataDir != "" {
+		return filepath.Join(dataDir, "backups")
+	}
+	return ""
+}
+
+// backupLocation resolves the location of a backup given to the admin API,
+// which must be a relative path that stays within the backup directory
+func (sm *ShardManager) backupLocation(location string) (string, error) {
+	dir := sm.shardBackupDir()
+	if dir == "" {
+		return "", fmt.Errorf("no backup directory is configured")
+	}
+	if strings.Contains(location, "://") || !filepath.IsLocal(location) {
+		return "", fmt.Errorf("backup location %q must be a relative path within the backup directory", location)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create backup directory: %v", err)
+	}
+	return filepath.Join(dir, location), nil
+}
+
+// ServeBackup serves a POST request to write a backup of a local shard
+// replica to the backup directory
+func (sm *ShardManager) ServeBackup(w http.ResponseWriter, r *http.Request) {
+	sm.serveBackupAdmin(w, r, sm.BackupShard)
+}
+
+// ServeRestore serves a POST request to restore a shard on this peer from a
+// backup in the backup directory
+func (sm *ShardManager) ServeRestore(w http.ResponseWriter, r *http.Request) {
+	sm.serveBackupAdmin(w, r, sm.RestoreShard)
+}
+
+func (sm *ShardManager) serveBackupAdmin(w http.ResponseWriter, r *http.Request, op func(shardID, location string) (*ShardBackup, error)) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req ShardBackupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.ShardID == "" || req.Location == "" {
+		http.Error(w, "ShardID and Location are required", http.StatusBadRequest)
+		return
+	}
+
+	location, err := sm.backupLocation(req.Location)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	backup, err := op(req.ShardID, location)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&ShardBackupResponse{
+		ShardID:       req.ShardID,
+		SourceShardID: backup.ShardID,
+		AppliedIndex:  backup.AppliedIndex,
+		Keys:          len(backup.Dependencies),
+		Location:      req.Location,
+	})
+}
+
+// RestoreShard creates the shard shardID on this peer from the backup at
+// location. The backup may come from a different shard, which clones its
+// state. The restored replica starts a new Raft group whose dependency map is
+// seeded from the backup, so every replica of the shard must restore the same
+// backup. Replica membership is taken from the local configuration, while the
+// conflict policy is kept from the backup.
+func (sm *ShardManager) RestoreShard(shardID, location string) (*ShardBackup, error) {
+	backup, err := readShardBackup(location)
+	if err != nil {
+		return nil, err
+	}
+
+	sm.shardsLock.Lock()
+	defer sm.shardsLock.Unlock()
+
+	if _, exists := sm.shards[shardID]; exists {
+		return nil, fmt.Errorf("shard %s already exists on this peer", shardID)
+	}
+
+	config := sm.shardConfig(shardID)
+	config.ConflictPolicy = backup.Config.ConflictPolicy
+
+	shard, err := sm.startShardLocked(config, backup.Dependencies)
+	if err != nil {
+		return nil, err
+	}
+	shard.restoreBackup(backup)
+
+	logger.Infof("Restored shard %s from backup of shard %s at index %d (%d keys)", shardID, backup.ShardID, backup.AppliedIndex, len(backup.Dependencies))
+	return backup, nil
+}
@@ -0,0 +1,165 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestBackupPath(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	path, err := backupPath("/tmp/backups/../fabcar.json")
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(path).To(Equal("/tmp/fabcar.json"))
+
+	path, err = backupPath("file:///tmp/fabcar.json")
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(path).To(Equal("/tmp/fabcar.json"))
+
+	_, err = backupPath("s3://bucket/fabcar.json")
+	gt.Expect(err).To(MatchError(`unsupported backup location scheme "s3"`))
+
+	_, err = backupPath("")
+	gt.Expect(err).To(MatchError("backup location is empty"))
+}
+
+func TestShardBackupRoundTrip(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	dir, err := ioutil.TempDir("", "shard-backup")
+	gt.Expect(err).NotTo(HaveOccurred())
+	defer os.RemoveAll(dir)
+
+	deps := map[string]TransactionDependencyInfo{
+		"fabcar:car1": {Value: []byte("v1"), DependentTxID: "tx1", Timestamp: 42},
+	}
+	sl, err := newShardLeader(ShardConfig{
+		ShardID:        "fabcar",
+		ReplicaIDs:     []uint64{1},
+		ReplicaID:      1,
+		ConflictPolicy: ConflictPolicyFirstWins,
+	}, DefaultBatchTimeout, DefaultBatchMaxSize, deps)
+	gt.Expect(err).NotTo(HaveOccurred())
+	defer sl.Stop()
+
+	backup, err := sl.Backup(time.Second)
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(backup.ShardID).To(Equal("fabcar"))
+	gt.Expect(backup.Config.ConflictPolicy).To(Equal(ConflictPolicyFirstWins))
+	gt.Expect(backup.Dependencies).To(Equal(deps))
+
+	location := "file://" + filepath.Join(dir, "fabcar.json")
+	gt.Expect(writeShardBackup(location, backup)).To(Succeed())
+
+	restored, err := readShardBackup(location)
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(restored.AppliedIndex).To(Equal(backup.AppliedIndex))
+	gt.Expect(restored.Config).To(Equal(backup.Config))
+	gt.Expect(restored.Dependencies).To(HaveKeyWithValue("fabcar:car1", HaveField("DependentTxID", "tx1")))
+
+	// no temporary files are left behind
+	files, err := ioutil.ReadDir(dir)
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(files).To(HaveLen(1))
+
+	_, err = readShardBackup(filepath.Join(dir, "missing.json"))
+	gt.Expect(err).To(MatchError(ContainSubstring("failed to read backup")))
+}
+
+func TestShardBackupRestore(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	config := ShardConfig{ShardID: "fabcar", ReplicaIDs: []uint64{1}, ReplicaID: 1}
+	start := func(deps map[string]TransactionDependencyInfo) *ShardLeader {
+		sl, err := newShardLeader(config, DefaultBatchTimeout, DefaultBatchMaxSize, deps)
+		gt.Expect(err).NotTo(HaveOccurred())
+		gt.Eventually(func() bool { return sl.GetStatus().IsLeader }, 30*time.Second, 100*time.Millisecond).Should(BeTrue())
+		return sl
+	}
+
+	prepare := func(sl *ShardLeader, req *PrepareRequest) *PrepareProof {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		req.ShardID = "fabcar"
+		req.Timestamp = Clock().Now()
+		proof, err := sl.Prepare(ctx, req)
+		gt.Expect(err).NotTo(HaveOccurred())
+		return proof
+	}
+
+	sl := start(nil)
+	defer sl.Stop()
+	prepare(sl, &PrepareRequest{TxID: "tx1", ReservedRanges: []KeyRange{{StartKey: "fabcar:car1", EndKey: "fabcar:car5"}}})
+	decideCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := sl.DecideAndWait(decideCtx, "tx1", true)
+	gt.Expect(err).NotTo(HaveOccurred())
+
+	backup, err := sl.Backup(time.Second)
+	gt.Expect(err).NotTo(HaveOccurred())
+	location := filepath.Join(t.TempDir(), "fabcar.json")
+	gt.Expect(writeShardBackup(location, backup)).To(Succeed())
+	restored, err := readShardBackup(location)
+	gt.Expect(err).NotTo(HaveOccurred())
+
+	replica := start(restored.Dependencies)
+	defer replica.Stop()
+	replica.restoreBackup(restored)
+	gt.Expect(restored.RangeReservations).To(HaveLen(1))
+	gt.Expect(replica.RangeReservations()).To(Equal(restored.RangeReservations))
+
+	commit, decided := replica.Decision("tx1")
+	gt.Expect(decided).To(BeTrue())
+	gt.Expect(commit).To(BeTrue())
+
+	// a write within the range still depends on the scan
+	proof := prepare(replica, &PrepareRequest{TxID: "tx2", WriteSet: map[string][]byte{"fabcar:car3": []byte("red")}})
+	gt.Expect(proof.DependentTxID).To(Equal("tx1"))
+}
+
+func TestBackupAdminHandler(t *testing.T) {
+	gt := NewGomegaWithT(t)
+	sm := &ShardManager{shards: make(map[string]*ShardLeader), backupDir: t.TempDir()}
+
+	rec := httptest.NewRecorder()
+	sm.ServeBackup(rec, httptest.NewRequest(http.MethodGet, "/endorser/backup", nil))
+	gt.Expect(rec.Code).To(Equal(http.StatusMethodNotAllowed))
+
+	rec = httptest.NewRecorder()
+	sm.ServeBackup(rec, httptest.NewRequest(http.MethodPost, "/endorser/backup", strings.NewReader(`{"ShardID":"fabcar"}`)))
+	gt.Expect(rec.Code).To(Equal(http.StatusBadRequest))
+
+	rec = httptest.NewRecorder()
+	sm.ServeBackup(rec, httptest.NewRequest(http.MethodPost, "/endorser/backup", strings.NewReader(`{"ShardID":"fabcar","Location":"fabcar.json"}`)))
+	gt.Expect(rec.Code).To(Equal(http.StatusInternalServerError))
+	gt.Expect(rec.Body.String()).To(ContainSubstring("shard fabcar is not hosted on this peer"))
+
+	// locations are confined to the backup directory
+	for _, location := range []string{"/tmp/fabcar.json", "../fabcar.json", "backups/../../fabcar.json", "file:///tmp/fabcar.json", "s3://bucket/fabcar.json"} {
+		rec = httptest.NewRecorder()
+		sm.ServeRestore(rec, httptest.NewRequest(http.MethodPost, "/endorser/restore", strings.NewReader(`{"ShardID":"fabcar","Location":"`+location+`"}`)))
+		gt.Expect(rec.Code).To(Equal(http.StatusBadRequest), location)
+		gt.Expect(rec.Body.String()).To(ContainSubstring("must be a relative path within the backup directory"))
+	}
+
+	sm.backupDir = ""
+	rec = httptest.NewRecorder()
+	sm.ServeRestore(rec, httptest.NewRequest(http.MethodPost, "/endorser/restore", strings.NewReader(`{"ShardID":"fabcar","Location":"fabcar.json"}`)))
+	gt.Expect(rec.Code).To(Equal(http.StatusBadRequest))
+	gt.Expect(rec.Body.String()).To(ContainSubstring("no backup directory is configured"))
+}
@@ -0,0 +1,45 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"context"
+
+	"github.com/hyperledger/fabric/core/endorser/sharding/protos"
+	"google.golang.org/grpc"
+)
+
+// GRPCConflictOracle asks an external service to review the dependencies of
+// proposals through its ConflictOracle gRPC API
+type GRPCConflictOracle struct {
+	conn   *grpc.ClientConn
+	client protos.ConflictOracleClient
+}
+
+// NewGRPCConflictOracle connects to the conflict oracle at address. The
+// connection is plaintext when tlsConfig is nil.
+func NewGRPCConflictOracle(address string, tlsConfig *TransportTLS) (*GRPCConflictOracle, error) {
+	conn, err := grpc.Dial(address, grpc.WithTransportCredentials(tlsConfig.clientCredentials(0)))
+	if err != nil {
+		return nil, err
+	}
+	logger.Infof("Using the conflict oracle at %s", address)
+	return &GRPCConflictOracle{
+		conn:   conn,
+		client: protos.NewConflictOracleClient(conn),
+	}, nil
+}
+
+// Review asks the oracle to review the proposal
+func (o *GRPCConflictOracle) Review(ctx context.Context, req *protos.ReviewRequest) (*protos.ReviewResponse, error) {
+	return o.client.Review(ctx, req)
+}
+
+// Close closes the connection to the oracle
+func (o *GRPCConflictOracle) Close() error {
+	return o.conn.Close()
+}
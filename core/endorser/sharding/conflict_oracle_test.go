@@ -0,0 +1,62 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/endorser/sharding/protos"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+)
+
+// vetoingOracle vetoes the proposals that write to vetoed keys, and
+// annotates the others with the number of keys they write
+type vetoingOracle struct {
+	protos.UnimplementedConflictOracleServer
+	vetoed string
+}
+
+func (o *vetoingOracle) Review(ctx context.Context, req *protos.ReviewRequest) (*protos.ReviewResponse, error) {
+	for _, key := range req.Writes {
+		if key == o.vetoed {
+			return &protos.ReviewResponse{Veto: true, Reason: "writes " + key}, nil
+		}
+	}
+	return &protos.ReviewResponse{Annotations: []*protos.Annotation{{Key: "writes", Value: strconv.Itoa(len(req.Writes))}}}, nil
+}
+
+func TestGRPCConflictOracle(t *testing.T) {
+	gt := NewGomegaWithT(t)
+	ctx := context.Background()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	gt.Expect(err).NotTo(HaveOccurred())
+	server := grpc.NewServer()
+	protos.RegisterConflictOracleServer(server, &vetoingOracle{vetoed: "fabcar:car2"})
+	go server.Serve(lis)
+	defer server.Stop()
+
+	oracle, err := NewGRPCConflictOracle(lis.Addr().String(), nil)
+	gt.Expect(err).NotTo(HaveOccurred())
+	defer oracle.Close()
+
+	resp, err := oracle.Review(ctx, &protos.ReviewRequest{TxId: "tx1", Writes: []string{"fabcar:car1"}})
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(resp.Veto).To(BeFalse())
+	gt.Expect(resp.Annotations).To(HaveLen(1))
+	gt.Expect(resp.Annotations[0].Key).To(Equal("writes"))
+	gt.Expect(resp.Annotations[0].Value).To(Equal("1"))
+
+	resp, err = oracle.Review(ctx, &protos.ReviewRequest{TxId: "tx2", Writes: []string{"fabcar:car1", "fabcar:car2"}})
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(resp.Veto).To(BeTrue())
+	gt.Expect(resp.Reason).To(Equal("writes fabcar:car2"))
+}
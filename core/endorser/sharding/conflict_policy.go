@@ -0,0 +1,177 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ConflictPolicy selects how a shard resolves a prepare request that touches
+// keys already reserved by another transaction
+type ConflictPolicy string
+
+const (
+	// ConflictPolicyQueueBehind admits the newcomer and reports the holders as
+	// its dependencies. This is the default behaviour.
+	ConflictPolicyQueueBehind ConflictPolicy = "queue-behind"
+	// ConflictPolicyFirstWins rejects the newcomer and leaves the holders untouched
+	ConflictPolicyFirstWins ConflictPolicy = "first-wins"
+	// ConflictPolicyWoundWait aborts holders that are younger than the newcomer and
+	// queues the newcomer behind holders that are older than it
+	ConflictPolicyWoundWait ConflictPolicy = "wound-wait"
+)
+
+// ConflictPolicyEnvVar configures the conflict policy of shards created by the
+// ShardManager. It accepts either a single policy applied to every shard, or a
+// comma-separated list of shard=policy pairs where an entry without a shard name
+// sets the default, e.g. "queue-behind,fabcar=wound-wait".
+const ConflictPolicyEnvVar = "FABRIC_SHARD_CONFLICT_POLICY"
+
+// ParseConflictPolicy validates a policy name. An empty name selects the default policy.
+func ParseConflictPolicy(name string) (ConflictPolicy, error) {
+	switch ConflictPolicy(strings.TrimSpace(name)) {
+	case "", ConflictPolicyQueueBehind:
+		return ConflictPolicyQueueBehind, nil
+	case ConflictPolicyFirstWins:
+		return ConflictPolicyFirstWins, nil
+	case ConflictPolicyWoundWait:
+		return ConflictPolicyWoundWait, nil
+	default:
+		return "", fmt.Errorf("unknown conflict policy %q", name)
+	}
+}
+
+// conflictPolicyFromEnv returns the policy configured for the shard via ConflictPolicyEnvVar
+func conflictPolicyFromEnv(shardID string) ConflictPolicy {
+	spec := os.Getenv(ConflictPolicyEnvVar)
+	if spec == "" {
+		return ConflictPolicyQueueBehind
+	}
+
+	policy := ConflictPolicyQueueBehind
+	for _, item := range strings.Split(spec, ",") {
+		name, value := "", item
+		if kv := strings.SplitN(item, "=", 2); len(kv) == 2 {
+			name, value = strings.TrimSpace(kv[0]), kv[1]
+		}
+		if name != "" && name != shardID {
+			continue
+		}
+		p, err := ParseConflictPolicy(value)
+		if err != nil {
+			logger.Warningf("Ignoring %s entry %q: %v", ConflictPolicyEnvVar, item, err)
+			continue
+		}
+		policy = p
+		if name == shardID {
+			break
+		}
+	}
+	return policy
+}
+
+// conflictResolution is the outcome of applying the shard's conflict policy to a request
+type conflictResolution struct {
+	hasDependency bool
+	dependentTxID string
+	conflictTypes map[string][]ConflictType
+	rejected      bool
+	wounded       []string
+}
+
+// resolveConflicts applies the shard's conflict policy to the request. It only
+// depends on the replicated state and the request itself, so every replica
+// reaches the same decision for the same log entry.
+func (sl *ShardLeader) resolveConflicts(req *PrepareRequestProto) conflictResolution {
+	hasDependency, conflicts := sl.checkDependencies(req, sl.commitIndex)
+	dependentTxID := conflicts.dependentTxID()
+	res := conflictResolution{
+		hasDependency: hasDependency,
+		dependentTxID: dependentTxID,
+		conflictTypes: conflicts.types(),
+	}
+	if dependentTxID == "" {
+		return res
+	}
+
+	switch sl.conflictPolicy {
+	case ConflictPolicyFirstWins:
+		res.rejected = true
+
+	case ConflictPolicyWoundWait:
+		sl.variableMapLock.RLock()
+		holderTimestamps := make(map[string]int64)
+		sl.variableMap.Range(func(_ string, info TransactionDependencyInfo) {
+			holderTimestamps[info.DependentTxID] = info.Timestamp
+		})
+		sl.variableMapLock.RUnlock()
+
+		var waitFor []string
+		for _, holder := range strings.Split(dependentTxID, ",") {
+			if isOlder(req.Timestamp, req.TxID, holderTimestamps[holder], holder) {
+				res.wounded = append(res.wounded, holder)
+				delete(res.conflictTypes, holder)
+			} else {
+				waitFor = append(waitFor, holder)
+			}
+		}
+		sort.Strings(res.wounded)
+		if len(res.conflictTypes) == 0 {
+			res.conflictTypes = nil
+		}
+		res.dependentTxID = strings.Join(waitFor, ",")
+		res.hasDependency = len(waitFor) > 0
+	}
+
+	return res
+}
+
+// isOlder reports whether transaction a started before transaction b, breaking
+// timestamp ties by TxID so that the order is total
+func isOlder(tsA int64, txA string, tsB int64, txB string) bool {
+	if tsA != tsB {
+		return tsA < tsB
+	}
+	return txA < txB
+}
+
+// releaseReservations removes every key reserved by the given transactions,
+// and drops them from the chains of the other reservations
+func (sl *ShardLeader) releaseReservations(txIDs []string) {
+	if len(txIDs) == 0 {
+		return
+	}
+
+	released := make(map[string]bool, len(txIDs))
+	for _, txID := range txIDs {
+		released[txID] = true
+	}
+
+	sl.releaseRanges(released)
+
+	sl.variableMapLock.Lock()
+	defer sl.variableMapLock.Unlock()
+	sl.variableMap.Range(func(key string, info TransactionDependencyInfo) {
+		if released[info.DependentTxID] {
+			sl.variableMap.Delete(key)
+			return
+		}
+		var chain []ChainLink
+		for _, link := range info.Chain {
+			if !released[link.TxID] {
+				chain = append(chain, link)
+			}
+		}
+		if len(chain) != len(info.Chain) {
+			info.Chain = chain
+			sl.variableMap.Put(key, info)
+		}
+	})
+}
@@ -0,0 +1,93 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"os"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func newPolicyTestLeader(policy ConflictPolicy) *ShardLeader {
+	return &ShardLeader{
+		shardID:        "testContract",
+		variableMap:    newMemoryDependencyTable(),
+		conflictPolicy: policy,
+	}
+}
+
+func TestParseConflictPolicy(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	p, err := ParseConflictPolicy("")
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(p).To(Equal(ConflictPolicyQueueBehind))
+
+	p, err = ParseConflictPolicy(" wound-wait ")
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(p).To(Equal(ConflictPolicyWoundWait))
+
+	_, err = ParseConflictPolicy("last-wins")
+	gt.Expect(err).To(MatchError(`unknown conflict policy "last-wins"`))
+}
+
+func TestConflictPolicyFromEnv(t *testing.T) {
+	gt := NewGomegaWithT(t)
+	defer os.Unsetenv(ConflictPolicyEnvVar)
+
+	os.Setenv(ConflictPolicyEnvVar, "first-wins,fabcar=wound-wait,supply=bogus")
+	gt.Expect(conflictPolicyFromEnv("fabcar")).To(Equal(ConflictPolicyWoundWait))
+	gt.Expect(conflictPolicyFromEnv("other")).To(Equal(ConflictPolicyFirstWins))
+	gt.Expect(conflictPolicyFromEnv("supply")).To(Equal(ConflictPolicyFirstWins))
+
+	os.Unsetenv(ConflictPolicyEnvVar)
+	gt.Expect(conflictPolicyFromEnv("fabcar")).To(Equal(ConflictPolicyQueueBehind))
+}
+
+func TestResolveConflicts(t *testing.T) {
+	holder := &PrepareRequestProto{TxID: "tx2", WriteSet: map[string][]byte{"k1": []byte("v")}, Timestamp: 20}
+	older := &PrepareRequestProto{TxID: "tx1", WriteSet: map[string][]byte{"k1": []byte("v")}, Timestamp: 10}
+	younger := &PrepareRequestProto{TxID: "tx3", ReadSet: map[string][]byte{"k1": nil}, Timestamp: 30}
+
+	t.Run("QueueBehind", func(t *testing.T) {
+		gt := NewGomegaWithT(t)
+		sl := newPolicyTestLeader(ConflictPolicyQueueBehind)
+		sl.updateDependencyMap(holder, false, "", 1)
+
+		res := sl.resolveConflicts(older)
+		gt.Expect(res).To(Equal(conflictResolution{hasDependency: true, dependentTxID: "tx2", conflictTypes: map[string][]ConflictType{"tx2": {ConflictWriteWrite}}}))
+	})
+
+	t.Run("FirstWins", func(t *testing.T) {
+		gt := NewGomegaWithT(t)
+		sl := newPolicyTestLeader(ConflictPolicyFirstWins)
+		sl.updateDependencyMap(holder, false, "", 1)
+
+		res := sl.resolveConflicts(older)
+		gt.Expect(res.rejected).To(BeTrue())
+		gt.Expect(res.dependentTxID).To(Equal("tx2"))
+	})
+
+	t.Run("WoundWait", func(t *testing.T) {
+		gt := NewGomegaWithT(t)
+		sl := newPolicyTestLeader(ConflictPolicyWoundWait)
+		sl.updateDependencyMap(holder, false, "", 1)
+
+		res := sl.resolveConflicts(younger)
+		gt.Expect(res).To(Equal(conflictResolution{hasDependency: true, dependentTxID: "tx2", conflictTypes: map[string][]ConflictType{"tx2": {ConflictReadWrite}}}))
+
+		res = sl.resolveConflicts(older)
+		gt.Expect(res.rejected).To(BeFalse())
+		gt.Expect(res.hasDependency).To(BeFalse())
+		gt.Expect(res.wounded).To(Equal([]string{"tx2"}))
+		gt.Expect(res.conflictTypes).To(BeNil())
+
+		sl.releaseReservations(res.wounded)
+		gt.Expect(sl.Dependencies()).To(BeEmpty())
+	})
+}
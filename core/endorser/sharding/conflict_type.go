@@ -0,0 +1,102 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"sort"
+	"strings"
+)
+
+// ConflictType classifies a dependency by how the transaction and the holder
+// of the conflicting reservation access the keys they share. A writing
+// transaction reserves the keys it only reads as well, and its ReadSet tells
+// them apart from those it writes.
+type ConflictType string
+
+const (
+	// ConflictWriteWrite is a key both transactions write
+	ConflictWriteWrite ConflictType = "WW"
+	// ConflictReadWrite is a key the transaction reads, alone or within a
+	// range it scans, that the holder writes
+	ConflictReadWrite ConflictType = "RW"
+	// ConflictWriteAfterRead is a key the transaction writes that the holder
+	// read, alone or within a range it scanned
+	ConflictWriteAfterRead ConflictType = "WAR"
+	// ConflictReadRead is a key both transactions only read, which conflicts
+	// because the holder reserved it along with its writes
+	ConflictReadRead ConflictType = "RR"
+)
+
+// ConflictTypes lists the conflict types in the order they are reported
+var ConflictTypes = []ConflictType{ConflictWriteWrite, ConflictReadWrite, ConflictWriteAfterRead, ConflictReadRead}
+
+// classifyConflict returns the type of the conflict between a transaction
+// and the holder of a key, given whether each of them only reads it
+func classifyConflict(read, holderRead bool) ConflictType {
+	switch {
+	case read && holderRead:
+		return ConflictReadRead
+	case read:
+		return ConflictReadWrite
+	case holderRead:
+		return ConflictWriteAfterRead
+	default:
+		return ConflictWriteWrite
+	}
+}
+
+// conflictSet records the types of the conflicts of a transaction with each
+// of the transactions it depends on
+type conflictSet map[string]map[ConflictType]bool
+
+func (c conflictSet) add(txID string, conflictType ConflictType) {
+	if c[txID] == nil {
+		c[txID] = make(map[ConflictType]bool)
+	}
+	c[txID][conflictType] = true
+}
+
+// dependentTxID returns the sorted, comma-separated transactions
+func (c conflictSet) dependentTxID() string {
+	txIDs := make([]string, 0, len(c))
+	for txID := range c {
+		txIDs = append(txIDs, txID)
+	}
+	sort.Strings(txIDs)
+	return strings.Join(txIDs, ",")
+}
+
+// types returns the types of the conflicts with each transaction, in the
+// order of ConflictTypes
+func (c conflictSet) types() map[string][]ConflictType {
+	if len(c) == 0 {
+		return nil
+	}
+	types := make(map[string][]ConflictType, len(c))
+	for txID, set := range c {
+		for _, conflictType := range ConflictTypes {
+			if set[conflictType] {
+				types[txID] = append(types[txID], conflictType)
+			}
+		}
+	}
+	return types
+}
+
+// MergeConflictTypes combines the conflict types of the proofs of a
+// transaction, for each transaction it depends on
+func MergeConflictTypes(proofs []*PrepareProof) map[string][]ConflictType {
+	merged := make(conflictSet)
+	for _, proof := range proofs {
+		for txID, types := range proof.ConflictTypes {
+			for _, conflictType := range types {
+				merged.add(txID, conflictType)
+			}
+		}
+	}
+	return merged.types()
+}
@@ -0,0 +1,75 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestShardLeaderConflictTypes(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	sl, err := NewShardLeader(ShardConfig{
+		ShardID:    "fabcar",
+		ReplicaIDs: []uint64{1},
+		ReplicaID:  1,
+	}, DefaultBatchTimeout, DefaultBatchMaxSize)
+	gt.Expect(err).NotTo(HaveOccurred())
+	defer sl.Stop()
+
+	gt.Eventually(func() bool { return sl.GetStatus().IsLeader }, 30*time.Second, 100*time.Millisecond).Should(BeTrue())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	prepare := func(req *PrepareRequest) *PrepareProof {
+		req.ShardID = "fabcar"
+		req.Timestamp = Clock().Now()
+		proof, err := sl.Prepare(ctx, req)
+		gt.Expect(err).NotTo(HaveOccurred())
+		return proof
+	}
+
+	// tx1 writes car1 and reserves car2, which it only reads
+	proof := prepare(&PrepareRequest{
+		TxID:     "tx1",
+		ReadSet:  map[string][]byte{"fabcar:car2": nil},
+		WriteSet: map[string][]byte{"fabcar:car1": []byte("red"), "fabcar:car2": nil},
+	})
+	gt.Expect(proof.ConflictTypes).To(BeNil())
+
+	// A read-only transaction reading both keys
+	proof = prepare(&PrepareRequest{TxID: "tx2", ReadSet: map[string][]byte{"fabcar:car1": nil, "fabcar:car2": nil}})
+	gt.Expect(proof.DependentTxID).To(Equal("tx1"))
+	gt.Expect(proof.ConflictTypes).To(Equal(map[string][]ConflictType{"tx1": {ConflictReadWrite, ConflictReadRead}}))
+
+	// A writer of both keys
+	proof = prepare(&PrepareRequest{TxID: "tx3", WriteSet: map[string][]byte{"fabcar:car1": []byte("blue"), "fabcar:car2": []byte("blue")}})
+	gt.Expect(proof.ConflictTypes).To(Equal(map[string][]ConflictType{"tx1": {ConflictWriteWrite, ConflictWriteAfterRead}}))
+
+	// A write within a range scanned by a writer
+	proof = prepare(&PrepareRequest{TxID: "tx4", ReservedRanges: []KeyRange{{StartKey: "fabcar:car5", EndKey: "fabcar:car9"}}})
+	gt.Expect(proof.ConflictTypes).To(BeNil())
+	proof = prepare(&PrepareRequest{TxID: "tx5", WriteSet: map[string][]byte{"fabcar:car7": []byte("red")}})
+	gt.Expect(proof.ConflictTypes).To(Equal(map[string][]ConflictType{"tx4": {ConflictWriteAfterRead}}))
+}
+
+func TestMergeConflictTypes(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	gt.Expect(MergeConflictTypes(nil)).To(BeNil())
+	gt.Expect(MergeConflictTypes([]*PrepareProof{
+		{ShardID: "fabcar", ConflictTypes: map[string][]ConflictType{"tx1": {ConflictReadWrite}, "tx2": {ConflictWriteWrite}}},
+		{ShardID: "marbles", ConflictTypes: map[string][]ConflictType{"tx1": {ConflictWriteWrite, ConflictReadWrite}}},
+	})).To(Equal(map[string][]ConflictType{
+		"tx1": {ConflictWriteWrite, ConflictReadWrite},
+		"tx2": {ConflictWriteWrite},
+	}))
+}
@@ -0,0 +1,92 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ConflictWindowEnvVar bounds how recent a writer must be to make a later
+// transaction dependent on it. It accepts either a window applied to every
+// shard, or a comma-separated list of shard=window pairs where an entry
+// without a shard name sets the default, e.g. "1000,fabcar=30s". A plain
+// number is a count of log entries and anything else a Go duration.
+const ConflictWindowEnvVar = "FABRIC_SHARD_CONFLICT_WINDOW"
+
+// ConflictWindow limits dependencies to reservations made recently. A
+// reservation older than either bound is ignored by conflict detection; the
+// zero value keeps every reservation until it expires.
+type ConflictWindow struct {
+	// Entries is the number of log entries a reservation stays conflicting
+	// for after the entry that made it
+	Entries uint64 `json:",omitempty"`
+	// Duration is how long after the writer's timestamp a reservation stays
+	// conflicting, measured against the timestamp of the later request
+	Duration time.Duration `json:",omitempty"`
+}
+
+// ParseConflictWindow parses a window given as a count of log entries or a
+// duration. The empty string is the unbounded window.
+func ParseConflictWindow(spec string) (ConflictWindow, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return ConflictWindow{}, nil
+	}
+	if entries, err := strconv.ParseUint(spec, 10, 64); err == nil {
+		return ConflictWindow{Entries: entries}, nil
+	}
+	d, err := time.ParseDuration(spec)
+	if err != nil || d < 0 {
+		return ConflictWindow{}, fmt.Errorf("invalid conflict window %q", spec)
+	}
+	return ConflictWindow{Duration: d}, nil
+}
+
+// conflictWindowFromEnv returns the window configured for the shard via ConflictWindowEnvVar
+func conflictWindowFromEnv(shardID string) ConflictWindow {
+	window := ConflictWindow{}
+	for _, item := range strings.Split(os.Getenv(ConflictWindowEnvVar), ",") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		name, value := "", item
+		if kv := strings.SplitN(item, "=", 2); len(kv) == 2 {
+			name, value = strings.TrimSpace(kv[0]), kv[1]
+		}
+		if name != "" && name != shardID {
+			continue
+		}
+		w, err := ParseConflictWindow(value)
+		if err != nil {
+			logger.Warningf("Ignoring %s entry %q: %v", ConflictWindowEnvVar, item, err)
+			continue
+		}
+		window = w
+		if name == shardID {
+			break
+		}
+	}
+	return window
+}
+
+// covers reports whether a reservation still conflicts with a request
+// applied at commitIndex. Only values taken from the log are compared, so
+// that every replica reaches the same verdict. Reservations that predate
+// the bookkeeping a bound needs are always covered.
+func (w ConflictWindow) covers(info TransactionDependencyInfo, commitIndex uint64, timestamp int64) bool {
+	if w.Entries > 0 && info.CommitIndex > 0 && commitIndex > info.CommitIndex+w.Entries {
+		return false
+	}
+	if w.Duration > 0 && info.Timestamp > 0 && timestamp > 0 && timestamp-info.Timestamp > int64(w.Duration) {
+		return false
+	}
+	return true
+}
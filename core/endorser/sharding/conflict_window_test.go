@@ -0,0 +1,113 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestParseConflictWindow(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	w, err := ParseConflictWindow("")
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(w).To(Equal(ConflictWindow{}))
+
+	w, err = ParseConflictWindow(" 100 ")
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(w).To(Equal(ConflictWindow{Entries: 100}))
+
+	w, err = ParseConflictWindow("30s")
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(w).To(Equal(ConflictWindow{Duration: 30 * time.Second}))
+
+	_, err = ParseConflictWindow("-1s")
+	gt.Expect(err).To(MatchError(`invalid conflict window "-1s"`))
+	_, err = ParseConflictWindow("soon")
+	gt.Expect(err).To(MatchError(`invalid conflict window "soon"`))
+}
+
+func TestConflictWindowFromEnv(t *testing.T) {
+	gt := NewGomegaWithT(t)
+	defer os.Unsetenv(ConflictWindowEnvVar)
+
+	os.Setenv(ConflictWindowEnvVar, "50,fabcar=10s,supply=bogus")
+	gt.Expect(conflictWindowFromEnv("fabcar")).To(Equal(ConflictWindow{Duration: 10 * time.Second}))
+	gt.Expect(conflictWindowFromEnv("other")).To(Equal(ConflictWindow{Entries: 50}))
+	gt.Expect(conflictWindowFromEnv("supply")).To(Equal(ConflictWindow{Entries: 50}))
+
+	os.Unsetenv(ConflictWindowEnvVar)
+	gt.Expect(conflictWindowFromEnv("fabcar")).To(Equal(ConflictWindow{}))
+}
+
+func TestConflictWindow(t *testing.T) {
+	writer := func(txID, key string, ts int64) *PrepareRequestProto {
+		return &PrepareRequestProto{TxID: txID, WriteSet: map[string][]byte{key: []byte("v")}, Timestamp: ts}
+	}
+	reader := &PrepareRequestProto{
+		TxID:      "tx9",
+		ReadSet:   map[string][]byte{"old": nil, "recent": nil},
+		Timestamp: int64(100 * time.Second),
+	}
+
+	t.Run("Unbounded", func(t *testing.T) {
+		gt := NewGomegaWithT(t)
+		sl := newPolicyTestLeader(ConflictPolicyQueueBehind)
+		sl.updateDependencyMap(writer("tx1", "old", int64(time.Second)), false, "", 1)
+		sl.updateDependencyMap(writer("tx2", "recent", int64(99*time.Second)), false, "", 95)
+		sl.commitIndex = 100
+
+		res := sl.resolveConflicts(reader)
+		gt.Expect(res).To(Equal(conflictResolution{hasDependency: true, dependentTxID: "tx1,tx2", conflictTypes: map[string][]ConflictType{"tx1": {ConflictReadWrite}, "tx2": {ConflictReadWrite}}}))
+	})
+
+	t.Run("Entries", func(t *testing.T) {
+		gt := NewGomegaWithT(t)
+		sl := newPolicyTestLeader(ConflictPolicyQueueBehind)
+		sl.config.ConflictWindow = ConflictWindow{Entries: 10}
+		sl.updateDependencyMap(writer("tx1", "old", int64(time.Second)), false, "", 1)
+		sl.updateDependencyMap(writer("tx2", "recent", int64(99*time.Second)), false, "", 95)
+		sl.commitIndex = 100
+
+		res := sl.resolveConflicts(reader)
+		gt.Expect(res).To(Equal(conflictResolution{hasDependency: true, dependentTxID: "tx2", conflictTypes: map[string][]ConflictType{"tx2": {ConflictReadWrite}}}))
+
+		// the window ends exactly Entries entries after the writer
+		sl.commitIndex = 105
+		gt.Expect(sl.resolveConflicts(reader).dependentTxID).To(Equal("tx2"))
+		sl.commitIndex = 106
+		gt.Expect(sl.resolveConflicts(reader)).To(Equal(conflictResolution{}))
+	})
+
+	t.Run("Duration", func(t *testing.T) {
+		gt := NewGomegaWithT(t)
+		sl := newPolicyTestLeader(ConflictPolicyFirstWins)
+		sl.config.ConflictWindow = ConflictWindow{Duration: 5 * time.Second}
+		sl.updateDependencyMap(writer("tx1", "old", int64(time.Second)), false, "", 1)
+		sl.updateDependencyMap(writer("tx2", "recent", int64(99*time.Second)), false, "", 95)
+		sl.commitIndex = 100
+
+		res := sl.resolveConflicts(reader)
+		gt.Expect(res.rejected).To(BeTrue())
+		gt.Expect(res.dependentTxID).To(Equal("tx2"))
+	})
+
+	t.Run("UntrackedReservations", func(t *testing.T) {
+		gt := NewGomegaWithT(t)
+		sl := newPolicyTestLeader(ConflictPolicyQueueBehind)
+		sl.config.ConflictWindow = ConflictWindow{Entries: 1, Duration: time.Nanosecond}
+		// restored from a backup taken before reservations carried an index
+		sl.variableMap.Put("old", TransactionDependencyInfo{DependentTxID: "tx1"})
+		sl.commitIndex = 100
+
+		gt.Expect(sl.resolveConflicts(reader).dependentTxID).To(Equal("tx1"))
+	})
+}
@@ -0,0 +1,435 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultDecisionTimeout bounds each delivery of a decision to a shard
+	DefaultDecisionTimeout = 5 * time.Second
+	// DefaultDecisionRetryInterval is how often the decisions that some
+	// shards have not recorded yet are delivered again
+	DefaultDecisionRetryInterval = 5 * time.Second
+
+	coordinatorLogName = "decisions.log"
+)
+
+// ErrDecisionConflict is returned when a shard had already recorded the
+// opposite decision on the transaction, as when two endorsers coordinate the
+// same transaction
+var ErrDecisionConflict = errors.New("shard recorded the opposite decision")
+
+// Coordinator states of a transaction, as written to the decision log
+const (
+	coordinatorPreparing = "prepare"
+	coordinatorCommit    = "commit"
+	coordinatorAbort     = "abort"
+	coordinatorDone      = "done"
+)
+
+// CoordinatorConfig configures a two-phase commit coordinator
+type CoordinatorConfig struct {
+	// Dir is the directory the decision log is kept in. The coordinator only
+	// keeps its records in memory when empty, and then cannot recover the
+	// transactions in flight when the peer crashes.
+	Dir string
+	// DecisionTimeout bounds each delivery of a decision to a shard
+	DecisionTimeout time.Duration
+	// RetryInterval is how often undelivered decisions are delivered again
+	RetryInterval time.Duration
+	// GiveUpAfter is how long a decision is delivered again before the
+	// coordinator gives up on the shards that did not record it. It defaults
+	// to DefaultExpiryDuration, after which their reservations expired.
+	GiveUpAfter time.Duration
+}
+
+// coordinatorRecord is a line of the decision log
+type coordinatorRecord struct {
+	TxID   string
+	State  string
+	Shards []string `json:",omitempty"`
+	Time   time.Time
+}
+
+// coordinatedTx is a transaction the coordinator has not finished with
+type coordinatedTx struct {
+	state     string
+	shards    []string
+	decidedAt time.Time
+	// delivered holds the shards that recorded the decision
+	delivered map[string]bool
+}
+
+// Coordinator runs two-phase commit across the shards a transaction is
+// prepared on. The endorser begins a transaction before preparing it and
+// decides to commit it once every shard prepared it, or to abort it
+// otherwise. The decision is written to the coordinator's log before it is
+// delivered to the shards, which replicate it through Raft, so that a
+// coordinator that crashes finishes delivering it on restart. Transactions
+// that had not been decided when it crashed are aborted.
+type Coordinator struct {
+	store  DecisionStore
+	config CoordinatorConfig
+
+	mu  sync.Mutex
+	txs map[string]*coordinatedTx
+	log *os.File
+
+	stopOnce sync.Once
+	stopC    chan struct{}
+	doneC    chan struct{}
+}
+
+// NewCoordinator returns a coordinator that delivers its decisions to the
+// store. The transactions left in flight in the decision log under
+// config.Dir are recovered.
+func NewCoordinator(store DecisionStore, config CoordinatorConfig) (*Coordinator, error) {
+	if config.DecisionTimeout <= 0 {
+		config.DecisionTimeout = DefaultDecisionTimeout
+	}
+	if config.RetryInterval <= 0 {
+		config.RetryInterval = DefaultDecisionRetryInterval
+	}
+	if config.GiveUpAfter <= 0 {
+		config.GiveUpAfter = DefaultExpiryDuration
+	}
+
+	c := &Coordinator{
+		store:  store,
+		config: config,
+		txs:    make(map[string]*coordinatedTx),
+		stopC:  make(chan struct{}),
+		doneC:  make(chan struct{}),
+	}
+	if config.Dir != "" {
+		if err := c.openLog(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Transactions that were still being prepared are presumed aborted,
+	// since their endorser is gone
+	var recovered []string
+	for txID, tx := range c.txs {
+		if tx.state == coordinatorPreparing {
+			tx.state = coordinatorAbort
+			tx.decidedAt = time.Now()
+			if err := c.appendLocked(&coordinatorRecord{TxID: txID, State: coordinatorAbort, Time: tx.decidedAt}); err != nil {
+				c.log.Close()
+				return nil, err
+			}
+		}
+		recovered = append(recovered, txID)
+	}
+	if len(recovered) > 0 {
+		logger.Infof("Recovered %d transactions in flight from the decision log", len(recovered))
+	}
+
+	go c.retryLoop()
+	return c, nil
+}
+
+// openLog replays the decision log and rewrites it with the transactions
+// still in flight only
+func (c *Coordinator) openLog() error {
+	if err := os.MkdirAll(c.config.Dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create coordinator directory %s: %v", c.config.Dir, err)
+	}
+	path := filepath.Join(c.config.Dir, coordinatorLogName)
+
+	f, err := os.Open(path)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return fmt.Errorf("failed to open decision log %s: %v", path, err)
+	default:
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			record := &coordinatorRecord{}
+			if err := json.Unmarshal(scanner.Bytes(), record); err != nil {
+				// A crash may leave the last record half written
+				logger.Warningf("Ignoring the corrupt record in decision log %s: %v", path, err)
+				continue
+			}
+			c.replay(record)
+		}
+		err := scanner.Err()
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read decision log %s: %v", path, err)
+		}
+	}
+
+	tmp := path + ".tmp"
+	compacted, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to create decision log %s: %v", tmp, err)
+	}
+	c.log = compacted
+	for _, txID := range c.sortedTxIDs() {
+		tx := c.txs[txID]
+		records := []*coordinatorRecord{{TxID: txID, State: coordinatorPreparing, Shards: tx.shards}}
+		if tx.state != coordinatorPreparing {
+			records = append(records, &coordinatorRecord{TxID: txID, State: tx.state, Time: tx.decidedAt})
+		}
+		for _, record := range records {
+			if err := c.appendLocked(record); err != nil {
+				compacted.Close()
+				return err
+			}
+		}
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		compacted.Close()
+		return fmt.Errorf("failed to compact decision log %s: %v", path, err)
+	}
+	return nil
+}
+
+func (c *Coordinator) replay(record *coordinatorRecord) {
+	switch record.State {
+	case coordinatorPreparing:
+		c.txs[record.TxID] = &coordinatedTx{state: coordinatorPreparing, shards: record.Shards}
+	case coordinatorCommit, coordinatorAbort:
+		if tx, ok := c.txs[record.TxID]; ok {
+			tx.state = record.State
+			tx.decidedAt = record.Time
+		}
+	case coordinatorDone:
+		delete(c.txs, record.TxID)
+	}
+}
+
+func (c *Coordinator) sortedTxIDs() []string {
+	txIDs := make([]string, 0, len(c.txs))
+	for txID := range c.txs {
+		txIDs = append(txIDs, txID)
+	}
+	sort.Strings(txIDs)
+	return txIDs
+}
+
+// appendLocked writes the record to the decision log and syncs it. It must be
+// called with mu held, or before the coordinator is shared.
+func (c *Coordinator) appendLocked(record *coordinatorRecord) error {
+	if c.log == nil {
+		return nil
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s record of tx %s: %v", record.State, record.TxID, err)
+	}
+	if _, err := c.log.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write %s record of tx %s: %v", record.State, record.TxID, err)
+	}
+	if err := c.log.Sync(); err != nil {
+		return fmt.Errorf("failed to sync %s record of tx %s: %v", record.State, record.TxID, err)
+	}
+	return nil
+}
+
+// Begin records that the transaction is about to be prepared on the shards.
+// It must be called before any of them is asked to prepare it.
+func (c *Coordinator) Begin(txID string, shardIDs []string) error {
+	shards := append([]string(nil), shardIDs...)
+	sort.Strings(shards)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, exists := c.txs[txID]; exists {
+		return fmt.Errorf("tx %s is already being coordinated", txID)
+	}
+	if err := c.appendLocked(&coordinatorRecord{TxID: txID, State: coordinatorPreparing, Shards: shards}); err != nil {
+		return err
+	}
+	c.txs[txID] = &coordinatedTx{state: coordinatorPreparing, shards: shards}
+	return nil
+}
+
+// Commit decides to commit the transaction and delivers the decision to its
+// shards. Shards that cannot be reached are delivered the decision again in
+// the background. It fails with ErrDecisionConflict when a shard had already
+// decided to abort the transaction.
+func (c *Coordinator) Commit(ctx context.Context, txID string) error {
+	return c.decide(ctx, txID, coordinatorCommit)
+}
+
+// Abort decides to abort the transaction and delivers the decision to its
+// shards, which release its reservations
+func (c *Coordinator) Abort(ctx context.Context, txID string) error {
+	return c.decide(ctx, txID, coordinatorAbort)
+}
+
+func (c *Coordinator) decide(ctx context.Context, txID, state string) error {
+	c.mu.Lock()
+	tx, ok := c.txs[txID]
+	if !ok {
+		c.mu.Unlock()
+		return fmt.Errorf("tx %s is not being coordinated", txID)
+	}
+	if tx.state != coordinatorPreparing {
+		c.mu.Unlock()
+		return fmt.Errorf("tx %s was already decided to %s", txID, tx.state)
+	}
+	decidedAt := time.Now()
+	if err := c.appendLocked(&coordinatorRecord{TxID: txID, State: state, Time: decidedAt}); err != nil {
+		c.mu.Unlock()
+		return err
+	}
+	tx.state = state
+	tx.decidedAt = decidedAt
+	c.mu.Unlock()
+
+	return c.deliver(ctx, txID)
+}
+
+// deliver sends the decision on the transaction to the shards that have not
+// recorded it yet, and forgets the transaction once they all did
+func (c *Coordinator) deliver(ctx context.Context, txID string) error {
+	c.mu.Lock()
+	tx, ok := c.txs[txID]
+	if !ok {
+		c.mu.Unlock()
+		return nil
+	}
+	commit := tx.state == coordinatorCommit
+	var pending []string
+	for _, shardID := range tx.shards {
+		if !tx.delivered[shardID] {
+			pending = append(pending, shardID)
+		}
+	}
+	c.mu.Unlock()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var conflicts []string
+	delivered := make(map[string]bool)
+	for _, shardID := range pending {
+		wg.Add(1)
+		go func(shardID string) {
+			defer wg.Done()
+			decideCtx, cancel := context.WithTimeout(ctx, c.config.DecisionTimeout)
+			defer cancel()
+
+			decided, err := c.store.Decide(decideCtx, shardID, txID, commit)
+			if err != nil {
+				logger.Warningf("Failed to deliver the decision to %s tx %s to shard %s: %v", decisionName(commit), txID, shardID, err)
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			delivered[shardID] = true
+			if decided != commit {
+				conflicts = append(conflicts, shardID)
+			}
+		}(shardID)
+	}
+	wg.Wait()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if tx.delivered == nil {
+		tx.delivered = make(map[string]bool)
+	}
+	for shardID := range delivered {
+		tx.delivered[shardID] = true
+	}
+	if len(tx.delivered) == len(tx.shards) && c.txs[txID] == tx {
+		c.finishLocked(txID)
+	}
+
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return fmt.Errorf("failed to %s tx %s on shards %v: %w", decisionName(commit), txID, conflicts, ErrDecisionConflict)
+	}
+	return nil
+}
+
+// finishLocked forgets the transaction. It must be called with mu held.
+func (c *Coordinator) finishLocked(txID string) {
+	if err := c.appendLocked(&coordinatorRecord{TxID: txID, State: coordinatorDone, Time: time.Now()}); err != nil {
+		// The decision is delivered again on restart, which is harmless
+		logger.Warningf("Failed to record the completion of tx %s: %v", txID, err)
+	}
+	delete(c.txs, txID)
+}
+
+// InFlight returns the number of transactions the coordinator has not
+// finished with
+func (c *Coordinator) InFlight() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.txs)
+}
+
+func (c *Coordinator) retryLoop() {
+	defer close(c.doneC)
+	ticker := time.NewTicker(c.config.RetryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stopC:
+			return
+		case <-ticker.C:
+			c.retry()
+		}
+	}
+}
+
+// retry delivers the decisions that some shards have not recorded yet, and
+// gives up on those older than GiveUpAfter
+func (c *Coordinator) retry() {
+	c.mu.Lock()
+	var undelivered []string
+	for _, txID := range c.sortedTxIDs() {
+		tx := c.txs[txID]
+		switch {
+		case tx.state == coordinatorPreparing:
+		case time.Since(tx.decidedAt) > c.config.GiveUpAfter:
+			logger.Warningf("Giving up delivering the decision to %s tx %s to shards %v", tx.state, txID, tx.shards)
+			c.finishLocked(txID)
+		default:
+			undelivered = append(undelivered, txID)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, txID := range undelivered {
+		select {
+		case <-c.stopC:
+			return
+		default:
+		}
+		c.deliver(context.Background(), txID)
+	}
+}
+
+// Stop stops delivering decisions and closes the decision log
+func (c *Coordinator) Stop() {
+	c.stopOnce.Do(func() { close(c.stopC) })
+	<-c.doneC
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.log != nil {
+		c.log.Close()
+		c.log = nil
+	}
+}
@@ -0,0 +1,141 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+// decisionRecorder is a DecisionStore that keeps the first decision on each
+// transaction per shard, and fails the shards marked down
+type decisionRecorder struct {
+	mu        sync.Mutex
+	down      map[string]bool
+	decisions map[string]map[string]bool
+}
+
+func (r *decisionRecorder) Decide(ctx context.Context, shardID, txID string, commit bool) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.down[shardID] {
+		return false, errors.New("shard is down")
+	}
+	if r.decisions == nil {
+		r.decisions = make(map[string]map[string]bool)
+	}
+	if r.decisions[shardID] == nil {
+		r.decisions[shardID] = make(map[string]bool)
+	}
+	if decided, ok := r.decisions[shardID][txID]; ok {
+		return decided, nil
+	}
+	r.decisions[shardID][txID] = commit
+	return commit, nil
+}
+
+func (r *decisionRecorder) setDown(shardID string, down bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.down == nil {
+		r.down = make(map[string]bool)
+	}
+	r.down[shardID] = down
+}
+
+func (r *decisionRecorder) decision(shardID, txID string) (bool, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	decided, ok := r.decisions[shardID][txID]
+	return decided, ok
+}
+
+func TestCoordinatorDecisions(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	store := &decisionRecorder{}
+	c, err := NewCoordinator(store, CoordinatorConfig{RetryInterval: 10 * time.Millisecond})
+	gt.Expect(err).NotTo(HaveOccurred())
+	defer c.Stop()
+
+	ctx := context.Background()
+	gt.Expect(c.Begin("tx1", []string{"fabcar", "marbles"})).To(Succeed())
+	gt.Expect(c.Begin("tx1", []string{"fabcar"})).To(MatchError(ContainSubstring("already being coordinated")))
+	gt.Expect(c.Commit(ctx, "tx1")).To(Succeed())
+	for _, shardID := range []string{"fabcar", "marbles"} {
+		commit, decided := store.decision(shardID, "tx1")
+		gt.Expect(decided).To(BeTrue())
+		gt.Expect(commit).To(BeTrue())
+	}
+	gt.Expect(c.InFlight()).To(BeZero())
+	gt.Expect(c.Abort(ctx, "tx1")).To(MatchError(ContainSubstring("not being coordinated")))
+
+	// A shard that already aborted the transaction fails the commit
+	store.Decide(ctx, "marbles", "tx2", false)
+	gt.Expect(c.Begin("tx2", []string{"fabcar", "marbles"})).To(Succeed())
+	gt.Expect(errors.Is(c.Commit(ctx, "tx2"), ErrDecisionConflict)).To(BeTrue())
+
+	// The decision reaches the shards that were down once they are back
+	store.setDown("marbles", true)
+	gt.Expect(c.Begin("tx3", []string{"fabcar", "marbles"})).To(Succeed())
+	gt.Expect(c.Abort(ctx, "tx3")).To(Succeed())
+	gt.Expect(c.InFlight()).To(Equal(1))
+	store.setDown("marbles", false)
+	gt.Eventually(c.InFlight).Should(BeZero())
+	commit, decided := store.decision("marbles", "tx3")
+	gt.Expect(decided).To(BeTrue())
+	gt.Expect(commit).To(BeFalse())
+}
+
+func TestCoordinatorRecovery(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	dir := t.TempDir()
+	store := &decisionRecorder{}
+	store.setDown("marbles", true)
+	config := CoordinatorConfig{Dir: dir, RetryInterval: time.Hour}
+	c, err := NewCoordinator(store, config)
+	gt.Expect(err).NotTo(HaveOccurred())
+
+	ctx := context.Background()
+	// tx1 is done, tx2 is committed on fabcar only and tx3 is still being
+	// prepared when the coordinator crashes
+	for _, txID := range []string{"tx1", "tx2", "tx3"} {
+		gt.Expect(c.Begin(txID, []string{"fabcar", "marbles"})).To(Succeed())
+	}
+	store.setDown("marbles", false)
+	gt.Expect(c.Commit(ctx, "tx1")).To(Succeed())
+	store.setDown("marbles", true)
+	gt.Expect(c.Commit(ctx, "tx2")).To(Succeed())
+	c.Stop()
+
+	store.setDown("marbles", false)
+	config.RetryInterval = 10 * time.Millisecond
+	c, err = NewCoordinator(store, config)
+	gt.Expect(err).NotTo(HaveOccurred())
+	defer c.Stop()
+
+	gt.Eventually(c.InFlight).Should(BeZero())
+	commit, _ := store.decision("marbles", "tx2")
+	gt.Expect(commit).To(BeTrue())
+	for _, shardID := range []string{"fabcar", "marbles"} {
+		commit, decided := store.decision(shardID, "tx3")
+		gt.Expect(decided).To(BeTrue())
+		gt.Expect(commit).To(BeFalse())
+	}
+
+	// The log is compacted once the recovered transactions are done
+	c.Stop()
+	c, err = NewCoordinator(store, config)
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(c.InFlight()).To(BeZero())
+}
@@ -0,0 +1,183 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.etcd.io/etcd/raft/v3/raftpb"
+)
+
+// DecisionEntry records the outcome of a transaction prepared on several
+// shards, as decided by its two-phase commit coordinator. The first decision
+// applied for a transaction is final, so that every replica of every shard
+// agrees on it whatever the order in which the coordinator's retries arrive.
+// An abort decision releases the reservations of the transaction.
+type DecisionEntry struct {
+	TxID   string
+	Commit bool
+	// Timestamp is the leader's clock in Unix nanoseconds when it proposed
+	// the entry. The decision is forgotten once the reservations it covers
+	// expired.
+	Timestamp int64
+}
+
+// DecisionRequest asks a replica of a shard to record the decision on a
+// transaction. The replica answers with the decision in effect.
+type DecisionRequest struct {
+	TxID    string
+	ShardID string
+	Commit  bool
+}
+
+// DecisionStore records the decisions of the two-phase commit coordinator on
+// the shards. The ShardManager implements it.
+type DecisionStore interface {
+	// Decide records the decision on the transaction on the shard and
+	// returns the decision in effect, which differs when the shard had
+	// already decided otherwise
+	Decide(ctx context.Context, shardID, txID string, commit bool) (bool, error)
+}
+
+// DecideAndWait replicates the decision on the transaction through the Raft
+// log and returns the decision in effect once it is applied
+func (sl *ShardLeader) DecideAndWait(ctx context.Context, txID string, commit bool) (bool, error) {
+	data, err := (&PrepareRequestBatch{Decision: &DecisionEntry{
+		TxID:      txID,
+		Commit:    commit,
+		Timestamp: time.Now().UnixNano(),
+	}}).Marshal()
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal decision on tx %s: %v", txID, err)
+	}
+
+	decidedC := make(chan bool, 1)
+	sl.mu.Lock()
+	if sl.decisionWaiters == nil {
+		sl.decisionWaiters = make(map[string][]chan bool)
+	}
+	sl.decisionWaiters[txID] = append(sl.decisionWaiters[txID], decidedC)
+	sl.mu.Unlock()
+	defer sl.removeDecisionWaiter(txID, decidedC)
+
+	if err := sl.node.Propose(ctx, data); err != nil {
+		return false, fmt.Errorf("failed to propose decision on tx %s to shard %s: %v", txID, sl.shardID, err)
+	}
+
+	select {
+	case decided := <-decidedC:
+		return decided, nil
+	case <-sl.stopC:
+		return false, fmt.Errorf("shard %s: %w", sl.shardID, ErrStopped)
+	case <-ctx.Done():
+		return false, fmt.Errorf("timeout waiting for decision on tx %s on shard %s", txID, sl.shardID)
+	}
+}
+
+func (sl *ShardLeader) removeDecisionWaiter(txID string, decidedC chan bool) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	waiters := sl.decisionWaiters[txID]
+	for i, w := range waiters {
+		if w == decidedC {
+			waiters = append(waiters[:i], waiters[i+1:]...)
+			break
+		}
+	}
+	if len(waiters) == 0 {
+		delete(sl.decisionWaiters, txID)
+	} else {
+		sl.decisionWaiters[txID] = waiters
+	}
+}
+
+// Decision returns the decision recorded for the transaction, if any
+func (sl *ShardLeader) Decision(txID string) (commit bool, decided bool) {
+	sl.mu.RLock()
+	defer sl.mu.RUnlock()
+	decision, ok := sl.decisions[txID]
+	if !ok {
+		return false, false
+	}
+	return decision.Commit, true
+}
+
+// applyDecision records the decision unless the transaction was already
+// decided, and releases the reservations of an aborted transaction. It must
+// only be called from applyEntry.
+func (sl *ShardLeader) applyDecision(decision *DecisionEntry, entry raftpb.Entry) {
+	sl.mu.Lock()
+	recorded, decided := sl.decisions[decision.TxID]
+	if !decided {
+		if sl.decisions == nil {
+			sl.decisions = make(map[string]*DecisionEntry)
+		}
+		sl.decisions[decision.TxID] = decision
+		recorded = decision
+	}
+	waiters := sl.decisionWaiters[decision.TxID]
+	delete(sl.decisionWaiters, decision.TxID)
+	sl.mu.Unlock()
+
+	switch {
+	case decided && recorded.Commit != decision.Commit:
+		logger.Warningf("Shard %s: Ignoring the decision to %s tx %s at index %d, which was already decided", sl.shardID, decisionName(decision.Commit), decision.TxID, entry.Index)
+	case !decided && !decision.Commit:
+		sl.applyAbort(&AbortEntry{TxID: decision.TxID, Timestamp: decision.Timestamp / int64(time.Second)}, entry)
+	}
+	for _, decidedC := range waiters {
+		decidedC <- recorded.Commit
+	}
+}
+
+// pruneDecisions forgets the decisions older than the reservations they
+// cover. It must only be called from applyEntry.
+func (sl *ShardLeader) pruneDecisions(now time.Time) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	for txID, decision := range sl.decisions {
+		if !time.Unix(0, decision.Timestamp).Add(sl.dependencyTTL).After(now) {
+			delete(sl.decisions, txID)
+		}
+	}
+}
+
+// copyDecisions returns the recorded decisions for a snapshot
+func (sl *ShardLeader) copyDecisions() map[string]*DecisionEntry {
+	sl.mu.RLock()
+	defer sl.mu.RUnlock()
+	if len(sl.decisions) == 0 {
+		return nil
+	}
+	decisions := make(map[string]*DecisionEntry, len(sl.decisions))
+	for txID, decision := range sl.decisions {
+		decisions[txID] = decision
+	}
+	return decisions
+}
+
+func decisionName(commit bool) string {
+	if commit {
+		return "commit"
+	}
+	return "abort"
+}
+
+// Decide implements DecisionStore. Shards this peer does not replicate are
+// asked to record the decision through the REST API of one of their replicas.
+func (sm *ShardManager) Decide(ctx context.Context, shardID, txID string, commit bool) (bool, error) {
+	if !sm.IsReplica(shardID) {
+		return sm.RequestRemoteDecision(ctx, shardID, txID, commit)
+	}
+	shard, err := sm.GetOrCreateShard(shardID)
+	if err != nil {
+		return false, fmt.Errorf("failed to get shard %s: %v", shardID, err)
+	}
+	return shard.DecideAndWait(ctx, txID, commit)
+}
@@ -0,0 +1,89 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"go.etcd.io/etcd/raft/v3/raftpb"
+)
+
+func TestShardLeaderDecision(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	sl, err := NewShardLeader(ShardConfig{
+		ShardID:    "fabcar",
+		ReplicaIDs: []uint64{1},
+		ReplicaID:  1,
+	}, DefaultBatchTimeout, DefaultBatchMaxSize)
+	gt.Expect(err).NotTo(HaveOccurred())
+	defer sl.Stop()
+
+	gt.Eventually(func() bool { return sl.GetStatus().IsLeader }, 30*time.Second, 100*time.Millisecond).Should(BeTrue())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, txID := range []string{"tx1", "tx2"} {
+		_, err = sl.ProposeAndWait(ctx, &PrepareRequest{
+			TxID:      txID,
+			ShardID:   "fabcar",
+			WriteSet:  map[string][]byte{txID + "-car": []byte("v1")},
+			Timestamp: Clock().Now(),
+		})
+		gt.Expect(err).NotTo(HaveOccurred())
+	}
+
+	// A commit keeps the reservations until the transaction commits
+	commit, err := sl.DecideAndWait(ctx, "tx1", true)
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(commit).To(BeTrue())
+	gt.Expect(sl.Dependencies()).To(HaveKey("tx1-car"))
+
+	// An abort releases them
+	commit, err = sl.DecideAndWait(ctx, "tx2", false)
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(commit).To(BeFalse())
+	gt.Expect(sl.Dependencies()).NotTo(HaveKey("tx2-car"))
+
+	// The first decision is final
+	commit, err = sl.DecideAndWait(ctx, "tx1", false)
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(commit).To(BeTrue())
+	gt.Expect(sl.Dependencies()).To(HaveKey("tx1-car"))
+	commit, err = sl.DecideAndWait(ctx, "tx2", true)
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(commit).To(BeFalse())
+
+	backup, err := sl.Backup(time.Second)
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(backup.Decisions).To(HaveLen(2))
+}
+
+func TestShardLeaderDecisionGC(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	now := time.Now()
+	sl := &ShardLeader{
+		shardID:       "fabcar",
+		variableMap:   newMemoryDependencyTable(),
+		dependencyTTL: time.Minute,
+		decisions: map[string]*DecisionEntry{
+			"old": {TxID: "old", Commit: true, Timestamp: now.Add(-2 * time.Minute).UnixNano()},
+			"new": {TxID: "new", Commit: true, Timestamp: now.UnixNano()},
+		},
+	}
+	sl.applyGC(&GCEntry{Now: now.UnixNano()}, raftpb.Entry{Index: 1})
+
+	_, decided := sl.Decision("old")
+	gt.Expect(decided).To(BeFalse())
+	commit, decided := sl.Decision("new")
+	gt.Expect(decided).To(BeTrue())
+	gt.Expect(commit).To(BeTrue())
+}
@@ -0,0 +1,103 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"go.etcd.io/etcd/raft/v3/raftpb"
+)
+
+// DefaultDedupWindow is the number of log entries for which the proof of an
+// applied transaction is remembered to answer retried proposals
+const DefaultDedupWindow = uint64(1000)
+
+// appliedTx orders the remembered proofs by the entry that issued them
+type appliedTx struct {
+	txID  string
+	index uint64
+}
+
+// appliedProof returns the proof the state machine issued to txID, if it is
+// still within the dedup window
+func (sl *ShardLeader) appliedProof(txID string) (*PrepareProof, bool) {
+	sl.variableMapLock.RLock()
+	defer sl.variableMapLock.RUnlock()
+	return sl.variableMap.AppliedProof(txID)
+}
+
+// rememberProof records the proof issued by the entry being applied. It must
+// only be called from applyEntry.
+func (sl *ShardLeader) rememberProof(proof *PrepareProof) {
+	sl.variableMapLock.Lock()
+	defer sl.variableMapLock.Unlock()
+	sl.variableMap.PutAppliedProof(proof)
+	sl.appliedTxs = append(sl.appliedTxs, appliedTx{txID: proof.TxID, index: proof.CommitIndex})
+}
+
+// forgetProof lets a later proposal of txID be applied anew, as after an abort
+func (sl *ShardLeader) forgetProof(txID string) {
+	sl.variableMapLock.Lock()
+	defer sl.variableMapLock.Unlock()
+	sl.variableMap.DeleteAppliedProof(txID)
+}
+
+// expireAppliedProofs forgets the proofs issued more than dedupWindow entries
+// before index. It must only be called from applyEntry, so that all replicas
+// expire the same proofs.
+func (sl *ShardLeader) expireAppliedProofs(index uint64) {
+	sl.variableMapLock.Lock()
+	defer sl.variableMapLock.Unlock()
+
+	n := 0
+	for ; n < len(sl.appliedTxs) && sl.appliedTxs[n].index+sl.dedupWindow < index; n++ {
+		tx := sl.appliedTxs[n]
+		// The transaction may have been aborted and applied again since
+		if proof, ok := sl.variableMap.AppliedProof(tx.txID); ok && proof.CommitIndex == tx.index {
+			sl.variableMap.DeleteAppliedProof(tx.txID)
+		}
+	}
+	sl.appliedTxs = sl.appliedTxs[n:]
+}
+
+// loadAppliedTxs rebuilds the expiry order of the remembered proofs from the
+// dependency table. The caller must hold variableMapLock.
+func (sl *ShardLeader) loadAppliedTxs() {
+	sl.appliedTxs = sl.appliedTxs[:0]
+	sl.variableMap.RangeAppliedProofs(func(proof *PrepareProof) {
+		sl.appliedTxs = append(sl.appliedTxs, appliedTx{txID: proof.TxID, index: proof.CommitIndex})
+	})
+	sort.Slice(sl.appliedTxs, func(i, j int) bool {
+		if sl.appliedTxs[i].index != sl.appliedTxs[j].index {
+			return sl.appliedTxs[i].index < sl.appliedTxs[j].index
+		}
+		return sl.appliedTxs[i].txID < sl.appliedTxs[j].txID
+	})
+}
+
+// applyDuplicate answers a request whose transaction was already applied with
+// the original proof, leaving the dependency map untouched
+func (sl *ShardLeader) applyDuplicate(req *PrepareRequestProto, original *PrepareProof, entry raftpb.Entry, committedAt time.Time) {
+	logger.Debugf("Shard %s: Tx %s at index %d duplicates the entry at index %d", sl.shardID, req.TxID, entry.Index, original.CommitIndex)
+	atomic.AddUint64(&sl.duplicateProposals, 1)
+
+	sl.deliverProof(original)
+
+	sl.batchLock.Lock()
+	delete(sl.pendingTxIDs, req.TxID)
+	sl.batchLock.Unlock()
+
+	sl.latency.applied(req.TxID, entry.Index, committedAt, time.Now())
+}
+
+// DuplicateProposals returns the number of proposals answered with the proof
+// of an earlier proposal of the same transaction
+func (sl *ShardLeader) DuplicateProposals() uint64 {
+	return atomic.LoadUint64(&sl.duplicateProposals)
+}
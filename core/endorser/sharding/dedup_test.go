@@ -0,0 +1,116 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"go.etcd.io/etcd/raft/v3"
+	"go.etcd.io/etcd/raft/v3/raftpb"
+)
+
+// replayShard starts a single-replica shard whose log holds one entry per txID
+func replayShard(gt *GomegaWithT, config ShardConfig, txIDs ...string) *ShardLeader {
+	entries := testShardEntries(gt, txIDs...)
+
+	s, _, _, err := openShardStorage(config.DataDir, config.ShardID, raft.NewMemoryStorage())
+	gt.Expect(err).NotTo(HaveOccurred())
+	last := entries[len(entries)-1].Index
+	gt.Expect(s.store(raftpb.HardState{Term: 1, Vote: 1, Commit: last}, entries)).To(Succeed())
+	gt.Expect(s.close()).To(Succeed())
+
+	sl, err := NewShardLeader(config, DefaultBatchTimeout, DefaultBatchMaxSize)
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Eventually(func() uint64 { return atomic.LoadUint64(&sl.appliedIndex) }, 5*time.Second, 10*time.Millisecond).Should(Equal(last))
+	return sl
+}
+
+func TestDuplicateProposalsGetOriginalProof(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	dataDir, err := ioutil.TempDir("", "shard-dedup")
+	gt.Expect(err).NotTo(HaveOccurred())
+	defer os.RemoveAll(dataDir)
+
+	config := ShardConfig{ShardID: "fabcar", ReplicaIDs: []uint64{1}, ReplicaID: 1, DataDir: dataDir}
+	sl := replayShard(gt, config, "tx1", "tx2", "tx1")
+	defer sl.Stop()
+
+	// the second entry of tx1 is not applied again
+	gt.Expect(sl.GetRequestsHandled()).To(Equal(uint64(2)))
+	gt.Expect(sl.GetStatus().DuplicateProposals).To(Equal(uint64(1)))
+
+	proof, ok := sl.appliedProof("tx1")
+	gt.Expect(ok).To(BeTrue())
+	gt.Expect(proof.CommitIndex).To(Equal(uint64(2)))
+
+	// retries are answered without appending to the log
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	retried, err := sl.ProposeAndWait(ctx, &PrepareRequest{
+		TxID:     "tx1",
+		ShardID:  "fabcar",
+		WriteSet: map[string][]byte{"fabcar:tx1": []byte("v1")},
+	})
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(retried).To(Equal(proof))
+	gt.Expect(sl.GetStatus().DuplicateProposals).To(Equal(uint64(2)))
+	gt.Expect(sl.GetStatus().CommitIndex).To(Equal(uint64(4)))
+
+	backup := sl.captureBackup()
+	gt.Expect(backup.AppliedProofs).To(HaveKey("tx1"))
+	gt.Expect(backup.AppliedProofs).To(HaveKey("tx2"))
+}
+
+func TestDedupWindowExpiresProofs(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	dataDir, err := ioutil.TempDir("", "shard-dedup")
+	gt.Expect(err).NotTo(HaveOccurred())
+	defer os.RemoveAll(dataDir)
+
+	config := ShardConfig{ShardID: "fabcar", ReplicaIDs: []uint64{1}, ReplicaID: 1, DataDir: dataDir, DedupWindow: 1}
+	sl := replayShard(gt, config, "tx1", "tx2", "tx3", "tx1")
+	defer sl.Stop()
+
+	// the proof of tx1 at index 2 expired once index 4 was applied
+	gt.Expect(sl.GetRequestsHandled()).To(Equal(uint64(4)))
+	gt.Expect(sl.DuplicateProposals()).To(BeZero())
+	proof, ok := sl.appliedProof("tx1")
+	gt.Expect(ok).To(BeTrue())
+	gt.Expect(proof.CommitIndex).To(Equal(uint64(5)))
+
+	_, ok = sl.appliedProof("tx2")
+	gt.Expect(ok).To(BeFalse())
+	gt.Expect(sl.appliedTxs).To(Equal([]appliedTx{{txID: "tx3", index: 4}, {txID: "tx1", index: 5}}))
+}
+
+func TestAbortForgetsAppliedProof(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	sl := newPolicyTestLeader(ConflictPolicyQueueBehind)
+	sl.dedupWindow = DefaultDedupWindow
+	sl.rememberProof(&PrepareProof{TxID: "tx1", CommitIndex: 2})
+	gt.Expect(sl.appliedTxs).To(HaveLen(1))
+
+	sl.forgetProof("tx1")
+	_, ok := sl.appliedProof("tx1")
+	gt.Expect(ok).To(BeFalse())
+
+	// a later proof of the same transaction outlives the expiry of the first
+	sl.rememberProof(&PrepareProof{TxID: "tx1", CommitIndex: 5})
+	sl.expireAppliedProofs(2 + DefaultDedupWindow + 1)
+	_, ok = sl.appliedProof("tx1")
+	gt.Expect(ok).To(BeTrue())
+	gt.Expect(sl.appliedTxs).To(Equal([]appliedTx{{txID: "tx1", index: 5}}))
+}
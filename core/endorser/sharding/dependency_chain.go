@@ -0,0 +1,115 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// MaxDependencyChain bounds the number of earlier writers a reservation
+// remembers, so that hot keys do not grow unbounded chains
+const MaxDependencyChain = 32
+
+// ChainLink is an earlier pending writer of a reserved key
+type ChainLink struct {
+	TxID string
+	// CommitIndex is the index of the entry that made its reservation
+	CommitIndex uint64
+}
+
+// extendChain returns the chain of a key reserved by txID at reqTime, given
+// the reservation it replaces: the earlier writers of the key, oldest first,
+// as long as their reservations are pending
+func extendChain(prev TransactionDependencyInfo, held bool, txID string, reqTime time.Time) []ChainLink {
+	if !held || prev.DependentTxID == "" || expired(prev, reqTime) {
+		return nil
+	}
+	if prev.DependentTxID == txID {
+		return prev.Chain
+	}
+	chain := make([]ChainLink, 0, len(prev.Chain)+1)
+	for _, link := range prev.Chain {
+		if link.TxID != txID {
+			chain = append(chain, link)
+		}
+	}
+	chain = append(chain, ChainLink{TxID: prev.DependentTxID, CommitIndex: prev.CommitIndex})
+	if len(chain) > MaxDependencyChain {
+		chain = chain[len(chain)-MaxDependencyChain:]
+	}
+	return chain
+}
+
+// dependencyChain returns the ancestors of the request within the shard: the
+// holders it depends on and the pending writers that reserved the same keys
+// before them, ordered by the index of their reservation. It must be called
+// from applyEntry before the request's own reservations are made.
+func (sl *ShardLeader) dependencyChain(req *PrepareRequestProto, dependentTxID string) []string {
+	if dependentTxID == "" {
+		return nil
+	}
+	direct := make(map[string]bool)
+	for _, txID := range strings.Split(dependentTxID, ",") {
+		direct[txID] = true
+	}
+
+	links := make(map[string]uint64)
+	addLink := func(txID string, commitIndex uint64) {
+		if txID == req.TxID {
+			return
+		}
+		if idx, ok := links[txID]; !ok || commitIndex < idx {
+			links[txID] = commitIndex
+		}
+	}
+	for _, keys := range []map[string][]byte{req.ReadSet, req.WriteSet} {
+		for key := range keys {
+			info, ok := sl.reservation(key)
+			if !ok || !direct[info.DependentTxID] {
+				continue
+			}
+			for _, link := range info.Chain {
+				addLink(link.TxID, link.CommitIndex)
+			}
+			addLink(info.DependentTxID, info.CommitIndex)
+		}
+	}
+
+	chain := make([]string, 0, len(links))
+	for txID := range links {
+		chain = append(chain, txID)
+	}
+	sort.Slice(chain, func(i, j int) bool {
+		if links[chain[i]] != links[chain[j]] {
+			return links[chain[i]] < links[chain[j]]
+		}
+		return chain[i] < chain[j]
+	})
+	return chain
+}
+
+// MergeDependencyChains combines the chains of the proofs of a transaction.
+// Each chain keeps its order; the chains are taken by shard, and a
+// transaction appears once, where it was first listed.
+func MergeDependencyChains(proofs []*PrepareProof) []string {
+	sorted := append([]*PrepareProof(nil), proofs...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].ShardID < sorted[j].ShardID })
+
+	seen := make(map[string]bool)
+	var chain []string
+	for _, proof := range sorted {
+		for _, txID := range proof.DependencyChain {
+			if !seen[txID] {
+				seen[txID] = true
+				chain = append(chain, txID)
+			}
+		}
+	}
+	return chain
+}
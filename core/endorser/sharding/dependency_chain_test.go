@@ -0,0 +1,80 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestExtendChain(t *testing.T) {
+	gt := NewGomegaWithT(t)
+	now := time.Now()
+
+	prev := TransactionDependencyInfo{
+		DependentTxID: "tx2",
+		CommitIndex:   2,
+		ExpiryTime:    now.Add(time.Minute),
+		Chain:         []ChainLink{{TxID: "tx1", CommitIndex: 1}},
+	}
+	gt.Expect(extendChain(prev, true, "tx3", now)).To(Equal([]ChainLink{{TxID: "tx1", CommitIndex: 1}, {TxID: "tx2", CommitIndex: 2}}))
+
+	// a holder reserving the key again keeps its chain
+	gt.Expect(extendChain(prev, true, "tx2", now)).To(Equal(prev.Chain))
+	// a writer already in the chain is not listed as its own ancestor
+	gt.Expect(extendChain(prev, true, "tx1", now)).To(Equal([]ChainLink{{TxID: "tx2", CommitIndex: 2}}))
+
+	// free and expired reservations start a new chain
+	gt.Expect(extendChain(TransactionDependencyInfo{}, false, "tx3", now)).To(BeNil())
+	gt.Expect(extendChain(prev, true, "tx3", now.Add(time.Hour))).To(BeNil())
+
+	// the chain keeps the most recent writers
+	for i := 0; i < MaxDependencyChain; i++ {
+		prev.Chain = append(prev.Chain, ChainLink{TxID: fmt.Sprintf("old%d", i), CommitIndex: 1})
+	}
+	chain := extendChain(prev, true, "tx3", now)
+	gt.Expect(chain).To(HaveLen(MaxDependencyChain))
+	gt.Expect(chain[MaxDependencyChain-1].TxID).To(Equal("tx2"))
+}
+
+func TestDependencyChain(t *testing.T) {
+	gt := NewGomegaWithT(t)
+	sl := newPolicyTestLeader(ConflictPolicyQueueBehind)
+	ts := time.Now().UnixNano()
+
+	tx1 := &PrepareRequestProto{TxID: "tx1", WriteSet: map[string][]byte{"car1": []byte("v")}, Timestamp: ts}
+	tx2 := &PrepareRequestProto{TxID: "tx2", WriteSet: map[string][]byte{"car1": []byte("v"), "car2": []byte("v")}, Timestamp: ts}
+	tx3 := &PrepareRequestProto{TxID: "tx3", ReadSet: map[string][]byte{"car1": nil}, Timestamp: ts}
+
+	sl.updateDependencyMap(tx1, false, "", 1)
+	gt.Expect(sl.dependencyChain(tx2, "tx1")).To(Equal([]string{"tx1"}))
+	sl.updateDependencyMap(tx2, true, "tx1", 2)
+
+	// tx3 depends on tx2, which queued behind tx1 on the same key
+	gt.Expect(sl.dependencyChain(tx3, "tx2")).To(Equal([]string{"tx1", "tx2"}))
+	gt.Expect(sl.dependencyChain(tx3, "")).To(BeEmpty())
+
+	// aborted writers leave the chains
+	sl.releaseReservations([]string{"tx1"})
+	gt.Expect(sl.dependencyChain(tx3, "tx2")).To(Equal([]string{"tx2"}))
+}
+
+func TestMergeDependencyChains(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	proofs := []*PrepareProof{
+		{ShardID: "marbles", DependencyChain: []string{"tx2", "tx4"}},
+		{ShardID: "fabcar", DependencyChain: []string{"tx1", "tx2"}},
+		{ShardID: "supply"},
+	}
+	gt.Expect(MergeDependencyChains(proofs)).To(Equal([]string{"tx1", "tx2", "tx4"}))
+	gt.Expect(proofs[0].ShardID).To(Equal("marbles"))
+	gt.Expect(MergeDependencyChains(nil)).To(BeEmpty())
+}
@@ -0,0 +1,360 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ShardGossipIntervalEnvVar is how often, as a Go duration, the shard leaders
+// hosted by a peer push the reservations they made and dropped to the peers
+// that do not replicate their shard. Those peers then answer dependency
+// queries on the shard from their own copy. Nothing is gossiped when it is
+// unset.
+const ShardGossipIntervalEnvVar = "FABRIC_SHARD_GOSSIP_INTERVAL"
+
+// gossipTimeout bounds how long a push of updates to one peer may take
+const gossipTimeout = 5 * time.Second
+
+// DependencyUpdate is a reservation made or dropped on a shard
+type DependencyUpdate struct {
+	Key  string
+	Info TransactionDependencyInfo `json:",omitempty"`
+	// Removed is set when the reservation of the key was dropped, on expiry,
+	// abort or eviction
+	Removed bool `json:",omitempty"`
+}
+
+// DependencyUpdates are the updates a shard leader applied up to Index, in
+// the order it applied them. A batch without updates tells that the leader
+// is alive and has applied nothing new.
+type DependencyUpdates struct {
+	ShardID string
+	Index   uint64
+	Updates []DependencyUpdate `json:",omitempty"`
+}
+
+// gossipIntervalFromEnv returns the interval configured via
+// ShardGossipIntervalEnvVar
+func gossipIntervalFromEnv() time.Duration {
+	spec := os.Getenv(ShardGossipIntervalEnvVar)
+	if spec == "" {
+		return 0
+	}
+	interval, err := time.ParseDuration(spec)
+	if err != nil || interval < 0 {
+		logger.Warningf("Ignoring %s %q: invalid duration", ShardGossipIntervalEnvVar, spec)
+		return 0
+	}
+	return interval
+}
+
+// recordingDependencyTable is a DependencyTable that records the reservations
+// put and deleted while recording is on, so that the shard leader can gossip
+// them
+type recordingDependencyTable struct {
+	DependencyTable
+	// recording is accessed atomically
+	recording int32
+
+	// mu guards updates, which Put and Delete of keys in different stripes
+	// append to concurrently
+	mu      sync.Mutex
+	updates []DependencyUpdate
+}
+
+func newRecordingDependencyTable(table DependencyTable) *recordingDependencyTable {
+	return &recordingDependencyTable{DependencyTable: table}
+}
+
+func (t *recordingDependencyTable) Put(key string, info TransactionDependencyInfo) {
+	t.DependencyTable.Put(key, info)
+	t.record(DependencyUpdate{Key: key, Info: info})
+}
+
+func (t *recordingDependencyTable) Delete(key string) {
+	t.DependencyTable.Delete(key)
+	t.record(DependencyUpdate{Key: key, Removed: true})
+}
+
+func (t *recordingDependencyTable) record(update DependencyUpdate) {
+	if atomic.LoadInt32(&t.recording) == 0 {
+		return
+	}
+	t.mu.Lock()
+	t.updates = append(t.updates, update)
+	t.mu.Unlock()
+}
+
+// take returns the updates recorded since the last call
+func (t *recordingDependencyTable) take() []DependencyUpdate {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	updates := t.updates
+	t.updates = nil
+	return updates
+}
+
+// setUpdateObserver registers the function apply calls with the updates
+// of the reservations while this replica leads the shard. It must not block.
+func (sl *ShardLeader) setUpdateObserver(observer func(DependencyUpdates)) {
+	if sl.recorder == nil {
+		return
+	}
+	sl.mu.Lock()
+	sl.updateObserver = observer
+	sl.mu.Unlock()
+	atomic.StoreInt32(&sl.recorder.recording, 1)
+}
+
+// publishDependencyUpdates hands the updates applied up to index to the
+// update observer. Followers drop them, since the leader gossips the same
+// updates. It must only be called from runApply.
+func (sl *ShardLeader) publishDependencyUpdates(index uint64) {
+	if sl.recorder == nil {
+		return
+	}
+	updates := sl.recorder.take()
+	sl.mu.RLock()
+	observer := sl.updateObserver
+	sl.mu.RUnlock()
+	if observer == nil || len(updates) == 0 || !sl.leading() {
+		return
+	}
+	observer(DependencyUpdates{ShardID: sl.shardID, Index: index, Updates: updates})
+}
+
+// leading reports whether this replica leads the shard
+func (sl *ShardLeader) leading() bool {
+	sl.mu.RLock()
+	defer sl.mu.RUnlock()
+	return sl.leaderID != 0 && sl.leaderID == sl.config.ReplicaID
+}
+
+// DependencyView is an eventually consistent copy of the reservations of
+// shards that a peer does not replicate, built from the updates their leaders
+// gossip
+type DependencyView struct {
+	mu     sync.RWMutex
+	shards map[string]*viewShard
+}
+
+type viewShard struct {
+	index uint64
+	// received is when the leader was last heard from
+	received time.Time
+	keys     map[string]TransactionDependencyInfo
+}
+
+// NewDependencyView creates an empty dependency view
+func NewDependencyView() *DependencyView {
+	return &DependencyView{shards: make(map[string]*viewShard)}
+}
+
+// Apply applies the updates of a shard leader. Updates older than those
+// already applied, e.g. from a deposed leader, are ignored, and so is a
+// batch that was already applied. It reports whether the batch was applied.
+func (v *DependencyView) Apply(batch DependencyUpdates) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	now := time.Now()
+	shard, ok := v.shards[batch.ShardID]
+	if !ok {
+		shard = &viewShard{keys: make(map[string]TransactionDependencyInfo)}
+		v.shards[batch.ShardID] = shard
+	}
+	if batch.Index < shard.index || (batch.Index == shard.index && len(batch.Updates) > 0) {
+		return false
+	}
+
+	for _, update := range batch.Updates {
+		if update.Removed {
+			delete(shard.keys, update.Key)
+			continue
+		}
+		shard.keys[update.Key] = update.Info
+	}
+	for key, info := range shard.keys {
+		if expired(info, now) {
+			delete(shard.keys, key)
+		}
+	}
+	shard.index = batch.Index
+	shard.received = now
+	return true
+}
+
+// Get serves a dependency read from the view. Only the MaxLagTime of the
+// bound applies, measured from when the shard leader was last heard from,
+// since the view does not know how far the leader got. It fails when no
+// update of the shard was received.
+func (v *DependencyView) Get(shardID, key string, bound StalenessBound) (*DependencyRead, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	shard, ok := v.shards[shardID]
+	if !ok {
+		return nil, fmt.Errorf("shard %s is not hosted on this peer and none of its updates were gossiped", shardID)
+	}
+	read := &DependencyRead{
+		ShardID:      shardID,
+		Key:          key,
+		AppliedIndex: shard.index,
+		LagTime:      time.Since(shard.received),
+		Gossiped:     true,
+	}
+	if bound.MaxLagTime > 0 && read.LagTime > bound.MaxLagTime {
+		return nil, &StaleReadError{ShardID: shardID, LagTime: read.LagTime, Bound: bound}
+	}
+	info, found := shard.keys[key]
+	if found && !expired(info, time.Now()) {
+		read.Info, read.Found = info, true
+	}
+	return read, nil
+}
+
+// dependencyGossip pushes the updates of the shards led by this peer to the
+// peers of the topology that do not replicate them, and keeps the view of the
+// shards this peer does not replicate
+type dependencyGossip struct {
+	sm       *ShardManager
+	interval time.Duration
+	view     *DependencyView
+
+	mu      sync.Mutex
+	pending map[string]*DependencyUpdates
+}
+
+func newDependencyGossip(sm *ShardManager, interval time.Duration) *dependencyGossip {
+	return &dependencyGossip{
+		sm:       sm,
+		interval: interval,
+		view:     NewDependencyView(),
+		pending:  make(map[string]*DependencyUpdates),
+	}
+}
+
+// add queues the updates of a shard for the next push. It is the dependency
+// observer of the shards, so it must not block.
+func (g *dependencyGossip) add(batch DependencyUpdates) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	pending, ok := g.pending[batch.ShardID]
+	if !ok {
+		pending = &DependencyUpdates{ShardID: batch.ShardID}
+		g.pending[batch.ShardID] = pending
+	}
+	pending.Index = batch.Index
+	pending.Updates = append(pending.Updates, batch.Updates...)
+}
+
+func (g *dependencyGossip) run() {
+	ticker := time.NewTicker(g.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			g.push()
+		case <-g.sm.stopC:
+			return
+		}
+	}
+}
+
+// push sends the queued updates, along with an empty batch for each shard
+// led by this peer without any, to the peers that do not replicate the shard
+func (g *dependencyGossip) push() {
+	g.mu.Lock()
+	pending := g.pending
+	g.pending = make(map[string]*DependencyUpdates)
+	g.mu.Unlock()
+
+	g.sm.shardsLock.RLock()
+	for shardID, shard := range g.sm.shards {
+		if _, ok := pending[shardID]; !ok && shard.leading() {
+			pending[shardID] = &DependencyUpdates{ShardID: shardID, Index: atomic.LoadUint64(&shard.appliedIndex)}
+		}
+	}
+	g.sm.shardsLock.RUnlock()
+	if len(pending) == 0 {
+		return
+	}
+
+	topology := g.sm.Topology()
+	self := advertisedPeerAddress()
+	byPeer := make(map[string][]*DependencyUpdates)
+	for _, addr := range topology.Peers() {
+		if addr == self {
+			continue
+		}
+		for shardID, batch := range pending {
+			if set, ok := topology.ReplicaSet(shardID); ok && replicaAt(set.Replicas, addr) != 0 {
+				continue
+			}
+			byPeer[addr] = append(byPeer[addr], batch)
+		}
+	}
+
+	for addr, batches := range byPeer {
+		go g.send(addr, batches)
+	}
+}
+
+func (g *dependencyGossip) send(addr string, batches []*DependencyUpdates) {
+	body, err := json.Marshal(batches)
+	if err != nil {
+		logger.Errorf("Failed to marshal dependency updates: %v", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), gossipTimeout)
+	defer cancel()
+	resp, err := g.sm.post(ctx, addr, "/gossip/dependencies", body)
+	if err != nil {
+		logger.Debugf("Failed to gossip dependency updates of %d shards to %s: %v", len(batches), addr, err)
+		return
+	}
+	resp.Body.Close()
+}
+
+// handleDependencyGossip serves POST /gossip/dependencies, the updates
+// gossiped by the leaders of other shards
+func (sm *ShardManager) handleDependencyGossip(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if sm.gossip == nil {
+		http.Error(w, "dependency gossip is disabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	var batches []DependencyUpdates
+	if err := json.NewDecoder(r.Body).Decode(&batches); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, batch := range batches {
+		// The replicas of a shard read their own copy
+		sm.shardsLock.RLock()
+		_, hosted := sm.shards[batch.ShardID]
+		sm.shardsLock.RUnlock()
+		if !hosted {
+			sm.gossip.view.Apply(batch)
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,156 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestRecordingDependencyTable(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	recorder := newRecordingDependencyTable(newMemoryDependencyTable())
+	recorder.Put("k1", TransactionDependencyInfo{DependentTxID: "tx1"})
+	gt.Expect(recorder.take()).To(BeEmpty())
+
+	recorder.recording = 1
+	table := newBoundedDependencyTable(recorder, 1)
+	table.Put("k2", TransactionDependencyInfo{DependentTxID: "tx2", CommitIndex: 2})
+	table.Delete("k1")
+	table.Put("k3", TransactionDependencyInfo{DependentTxID: "tx3", CommitIndex: 3})
+	gt.Expect(table.evictOverflow()).To(Equal([]string{"k2"}))
+
+	gt.Expect(recorder.take()).To(Equal([]DependencyUpdate{
+		{Key: "k2", Info: TransactionDependencyInfo{DependentTxID: "tx2", CommitIndex: 2}},
+		{Key: "k1", Removed: true},
+		{Key: "k3", Info: TransactionDependencyInfo{DependentTxID: "tx3", CommitIndex: 3}},
+		{Key: "k2", Removed: true},
+	}))
+	gt.Expect(recorder.take()).To(BeEmpty())
+}
+
+func TestPublishDependencyUpdates(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	recorder := newRecordingDependencyTable(newMemoryDependencyTable())
+	sl := &ShardLeader{
+		shardID:     "fabcar",
+		config:      ShardConfig{ReplicaID: 1},
+		variableMap: recorder,
+		recorder:    recorder,
+	}
+	var published []DependencyUpdates
+	sl.setUpdateObserver(func(updates DependencyUpdates) {
+		published = append(published, updates)
+	})
+
+	// a follower drops its updates
+	sl.leaderID = 2
+	sl.variableMap.Put("k1", TransactionDependencyInfo{DependentTxID: "tx1"})
+	sl.publishDependencyUpdates(5)
+	gt.Expect(published).To(BeEmpty())
+
+	sl.leaderID = 1
+	sl.variableMap.Put("k2", TransactionDependencyInfo{DependentTxID: "tx2"})
+	sl.publishDependencyUpdates(6)
+	sl.publishDependencyUpdates(7)
+	gt.Expect(published).To(Equal([]DependencyUpdates{{
+		ShardID: "fabcar",
+		Index:   6,
+		Updates: []DependencyUpdate{{Key: "k2", Info: TransactionDependencyInfo{DependentTxID: "tx2"}}},
+	}}))
+}
+
+func TestDependencyView(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	view := NewDependencyView()
+	_, err := view.Get("fabcar", "k1", StalenessBound{})
+	gt.Expect(err).To(MatchError("shard fabcar is not hosted on this peer and none of its updates were gossiped"))
+
+	gt.Expect(view.Apply(DependencyUpdates{ShardID: "fabcar", Index: 5, Updates: []DependencyUpdate{
+		{Key: "k1", Info: TransactionDependencyInfo{DependentTxID: "tx1"}},
+		{Key: "k2", Info: TransactionDependencyInfo{DependentTxID: "tx2"}},
+		{Key: "k3", Info: TransactionDependencyInfo{DependentTxID: "tx3", ExpiryTime: time.Now().Add(-time.Second)}},
+	}})).To(BeTrue())
+
+	read, err := view.Get("fabcar", "k1", StalenessBound{MaxLagTime: time.Minute})
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(read.Gossiped).To(BeTrue())
+	gt.Expect(read.Found).To(BeTrue())
+	gt.Expect(read.Info.DependentTxID).To(Equal("tx1"))
+	gt.Expect(read.AppliedIndex).To(Equal(uint64(5)))
+
+	read, err = view.Get("fabcar", "k3", StalenessBound{})
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(read.Found).To(BeFalse())
+
+	// updates from before the last ones applied are ignored
+	gt.Expect(view.Apply(DependencyUpdates{ShardID: "fabcar", Index: 4, Updates: []DependencyUpdate{{Key: "k1", Removed: true}}})).To(BeFalse())
+	gt.Expect(view.Apply(DependencyUpdates{ShardID: "fabcar", Index: 5, Updates: []DependencyUpdate{{Key: "k1", Removed: true}}})).To(BeFalse())
+	read, err = view.Get("fabcar", "k1", StalenessBound{})
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(read.Found).To(BeTrue())
+
+	gt.Expect(view.Apply(DependencyUpdates{ShardID: "fabcar", Index: 6, Updates: []DependencyUpdate{{Key: "k1", Removed: true}}})).To(BeTrue())
+	read, err = view.Get("fabcar", "k1", StalenessBound{})
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(read.Found).To(BeFalse())
+
+	// a batch without updates tells the leader is alive
+	gt.Expect(view.Apply(DependencyUpdates{ShardID: "fabcar", Index: 6})).To(BeTrue())
+	time.Sleep(10 * time.Millisecond)
+	_, err = view.Get("fabcar", "k2", StalenessBound{MaxLagTime: time.Millisecond})
+	gt.Expect(err).To(BeAssignableToTypeOf(&StaleReadError{}))
+}
+
+func TestDependencyGossipHandler(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	sm := &ShardManager{shards: map[string]*ShardLeader{"marbles": {shardID: "marbles"}}}
+	post := func(body string) int {
+		rec := httptest.NewRecorder()
+		sm.handleDependencyGossip(rec, httptest.NewRequest(http.MethodPost, "/gossip/dependencies", strings.NewReader(body)))
+		return rec.Code
+	}
+	gt.Expect(post(`[]`)).To(Equal(http.StatusServiceUnavailable))
+
+	sm.gossip = newDependencyGossip(sm, time.Second)
+	gt.Expect(post(`{`)).To(Equal(http.StatusBadRequest))
+	gt.Expect(post(`[{"ShardID":"fabcar","Index":3,"Updates":[{"Key":"k1","Info":{"DependentTxID":"tx1"}}]},` +
+		`{"ShardID":"marbles","Index":3,"Updates":[{"Key":"k1","Info":{"DependentTxID":"tx1"}}]}]`)).To(Equal(http.StatusOK))
+
+	read, err := sm.GetDependency("fabcar", "k1", StalenessBound{})
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(read.Gossiped).To(BeTrue())
+	gt.Expect(read.Info.DependentTxID).To(Equal("tx1"))
+
+	// the replicas of a shard do not keep a copy of it
+	_, err = sm.gossip.view.Get("marbles", "k1", StalenessBound{})
+	gt.Expect(err).To(HaveOccurred())
+
+	rec := httptest.NewRecorder()
+	sm.handleDependencyGossip(rec, httptest.NewRequest(http.MethodGet, "/gossip/dependencies", nil))
+	gt.Expect(rec.Code).To(Equal(http.StatusMethodNotAllowed))
+}
+
+func TestDependencyGossipAdd(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	g := newDependencyGossip(&ShardManager{}, time.Second)
+	g.add(DependencyUpdates{ShardID: "fabcar", Index: 3, Updates: []DependencyUpdate{{Key: "k1"}}})
+	g.add(DependencyUpdates{ShardID: "fabcar", Index: 5, Updates: []DependencyUpdate{{Key: "k2"}}})
+	gt.Expect(g.pending).To(Equal(map[string]*DependencyUpdates{
+		"fabcar": {ShardID: "fabcar", Index: 5, Updates: []DependencyUpdate{{Key: "k1"}, {Key: "k2"}}},
+	}))
+}
@@ -0,0 +1,180 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"container/heap"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// MaxDependenciesEnvVar caps the number of reservations a shard holds. Once
+// the cap is exceeded, the least recently reserved keys are evicted before
+// they expire. There is no cap when it is unset.
+const MaxDependenciesEnvVar = "FABRIC_SHARD_MAX_DEPENDENCIES"
+
+// maxDependenciesFromEnv returns the cap configured via MaxDependenciesEnvVar
+func maxDependenciesFromEnv() int {
+	spec := os.Getenv(MaxDependenciesEnvVar)
+	if spec == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(spec)
+	if err != nil || n < 0 {
+		logger.Warningf("Ignoring %s %q: invalid dependency count", MaxDependenciesEnvVar, spec)
+		return 0
+	}
+	return n
+}
+
+// boundedDependencyTable is a DependencyTable that holds at most max
+// reservations. Keys are ordered by the index of the entry that last reserved
+// them, ties broken by key, so that every replica evicts the same keys at the
+// same log index; reads do not refresh a key.
+type boundedDependencyTable struct {
+	DependencyTable
+	max int
+
+	// mu guards order, which Put and Delete of keys in different stripes
+	// update concurrently
+	mu    sync.Mutex
+	order reservationHeap
+}
+
+func newBoundedDependencyTable(table DependencyTable, max int) *boundedDependencyTable {
+	t := &boundedDependencyTable{DependencyTable: table, max: max}
+	t.rebuild()
+	return t
+}
+
+func (t *boundedDependencyTable) Put(key string, info TransactionDependencyInfo) {
+	t.DependencyTable.Put(key, info)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.order.update(key, info.CommitIndex)
+}
+
+func (t *boundedDependencyTable) Delete(key string) {
+	t.DependencyTable.Delete(key)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.order.remove(key)
+}
+
+func (t *boundedDependencyTable) Reset(entries map[string]TransactionDependencyInfo, proofs map[string]*PrepareProof, index uint64) error {
+	if err := t.DependencyTable.Reset(entries, proofs, index); err != nil {
+		return err
+	}
+	t.rebuild()
+	return nil
+}
+
+// evictOverflow deletes the least recently reserved keys until the table
+// holds at most max reservations, and returns the evicted keys. The caller
+// must hold every stripe.
+func (t *boundedDependencyTable) evictOverflow() []string {
+	var evicted []string
+	for t.order.Len() > t.max {
+		key := t.order.items[0].key
+		t.Delete(key)
+		evicted = append(evicted, key)
+	}
+	return evicted
+}
+
+// rebuild orders the reservations held by the underlying table
+func (t *boundedDependencyTable) rebuild() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.order = reservationHeap{positions: make(map[string]int)}
+	t.DependencyTable.Range(func(key string, info TransactionDependencyInfo) {
+		t.order.update(key, info.CommitIndex)
+	})
+}
+
+type reservationItem struct {
+	key         string
+	commitIndex uint64
+}
+
+// reservationHeap is a min-heap of reserved keys by commit index and key,
+// with the position of every key so that it can be updated or removed
+type reservationHeap struct {
+	items     []reservationItem
+	positions map[string]int
+}
+
+func (h *reservationHeap) Len() int { return len(h.items) }
+
+func (h *reservationHeap) Less(i, j int) bool {
+	if h.items[i].commitIndex != h.items[j].commitIndex {
+		return h.items[i].commitIndex < h.items[j].commitIndex
+	}
+	return h.items[i].key < h.items[j].key
+}
+
+func (h *reservationHeap) Swap(i, j int) {
+	h.items[i], h.items[j] = h.items[j], h.items[i]
+	h.positions[h.items[i].key] = i
+	h.positions[h.items[j].key] = j
+}
+
+func (h *reservationHeap) Push(x interface{}) {
+	item := x.(reservationItem)
+	h.positions[item.key] = len(h.items)
+	h.items = append(h.items, item)
+}
+
+func (h *reservationHeap) Pop() interface{} {
+	item := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	delete(h.positions, item.key)
+	return item
+}
+
+func (h *reservationHeap) update(key string, commitIndex uint64) {
+	if i, ok := h.positions[key]; ok {
+		h.items[i].commitIndex = commitIndex
+		heap.Fix(h, i)
+		return
+	}
+	heap.Push(h, reservationItem{key: key, commitIndex: commitIndex})
+}
+
+func (h *reservationHeap) remove(key string) {
+	if i, ok := h.positions[key]; ok {
+		heap.Remove(h, i)
+	}
+}
+
+// evictDependencies enforces the cap on the reservations of the shard. It
+// must only be called from applyEntry.
+func (sl *ShardLeader) evictDependencies(commitIndex uint64) {
+	bounded, ok := sl.variableMap.(*boundedDependencyTable)
+	if !ok {
+		return
+	}
+
+	sl.variableMapLock.Lock()
+	evicted := bounded.evictOverflow()
+	sl.variableMapLock.Unlock()
+
+	if len(evicted) > 0 {
+		atomic.AddUint64(&sl.evictedDependencies, uint64(len(evicted)))
+		logger.Warnf("Shard %s: Evicted %d reservations at index %d to stay within %d", sl.shardID, len(evicted), commitIndex, bounded.max)
+	}
+}
+
+// EvictedDependencies returns the number of reservations evicted to stay
+// within the cap on reservations
+func (sl *ShardLeader) EvictedDependencies() uint64 {
+	return atomic.LoadUint64(&sl.evictedDependencies)
+}
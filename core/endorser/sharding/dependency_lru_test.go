@@ -0,0 +1,106 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestBoundedDependencyTable(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	inner := newMemoryDependencyTable()
+	inner.Put("seeded", TransactionDependencyInfo{DependentTxID: "tx0", CommitIndex: 1})
+	table := newBoundedDependencyTable(inner, 3)
+
+	table.Put("b", TransactionDependencyInfo{DependentTxID: "tx2", CommitIndex: 2})
+	table.Put("a", TransactionDependencyInfo{DependentTxID: "tx2", CommitIndex: 2})
+	gt.Expect(table.evictOverflow()).To(BeEmpty())
+
+	// keys reserved by the same entry are evicted in key order, and a key
+	// reserved again moves behind the others
+	table.Put("seeded", TransactionDependencyInfo{DependentTxID: "tx3", CommitIndex: 3})
+	table.Put("c", TransactionDependencyInfo{DependentTxID: "tx4", CommitIndex: 4})
+	table.Put("d", TransactionDependencyInfo{DependentTxID: "tx4", CommitIndex: 4})
+	gt.Expect(table.evictOverflow()).To(Equal([]string{"a", "b"}))
+
+	_, ok := table.Get("a")
+	gt.Expect(ok).To(BeFalse())
+	_, ok = table.Get("seeded")
+	gt.Expect(ok).To(BeTrue())
+
+	table.Delete("c")
+	table.Put("e", TransactionDependencyInfo{DependentTxID: "tx5", CommitIndex: 5})
+	gt.Expect(table.evictOverflow()).To(BeEmpty())
+
+	// a restored table is ordered by the commit index of its reservations
+	gt.Expect(table.Reset(map[string]TransactionDependencyInfo{
+		"x": {CommitIndex: 9},
+		"y": {CommitIndex: 7},
+		"z": {CommitIndex: 8},
+		"w": {CommitIndex: 10},
+	}, nil, 10)).To(Succeed())
+	gt.Expect(table.evictOverflow()).To(Equal([]string{"y"}))
+}
+
+func TestShardLeaderEvictsDependencies(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	t.Setenv(MaxDependenciesEnvVar, "3")
+	sm := &ShardManager{topology: DefaultShardTopology()}
+	config := sm.shardConfig("fabcar")
+	gt.Expect(config.MaxDependencies).To(Equal(3))
+	sm.maxDependencies = 2
+	gt.Expect(sm.shardConfig("fabcar").MaxDependencies).To(Equal(2))
+
+	sl, err := NewShardLeader(ShardConfig{
+		ShardID:         "fabcar",
+		ReplicaIDs:      []uint64{1},
+		ReplicaID:       1,
+		MaxDependencies: 3,
+	}, DefaultBatchTimeout, DefaultBatchMaxSize)
+	gt.Expect(err).NotTo(HaveOccurred())
+	defer sl.Stop()
+
+	gt.Eventually(func() bool { return sl.GetStatus().IsLeader }, 30*time.Second, 100*time.Millisecond).Should(BeTrue())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for i := 1; i <= 5; i++ {
+		_, err = sl.ProposeAndWait(ctx, &PrepareRequest{
+			TxID:      fmt.Sprintf("tx%d", i),
+			ShardID:   "fabcar",
+			WriteSet:  map[string][]byte{fmt.Sprintf("car%d", i): []byte("v1")},
+			Timestamp: Clock().Now(),
+		})
+		gt.Expect(err).NotTo(HaveOccurred())
+	}
+
+	deps := sl.Dependencies()
+	gt.Expect(deps).To(HaveLen(3))
+	gt.Expect(deps).To(HaveKey("car3"))
+	gt.Expect(deps).To(HaveKey("car4"))
+	gt.Expect(deps).To(HaveKey("car5"))
+	gt.Expect(sl.GetStatus().EvictedDependencies).To(Equal(uint64(2)))
+}
+
+func TestMaxDependenciesFromEnv(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	gt.Expect(maxDependenciesFromEnv()).To(BeZero())
+	t.Setenv(MaxDependenciesEnvVar, "100")
+	gt.Expect(maxDependenciesFromEnv()).To(Equal(100))
+	t.Setenv(MaxDependenciesEnvVar, "-1")
+	gt.Expect(maxDependenciesFromEnv()).To(BeZero())
+	t.Setenv(MaxDependenciesEnvVar, "many")
+	gt.Expect(maxDependenciesFromEnv()).To(BeZero())
+}
@@ -0,0 +1,134 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// DependencyStore records the key reservations of prepared transactions and
+// reports each transaction's dependencies on earlier reservations. It is the
+// strategy by which the endorser resolves dependencies: the ShardManager
+// implements it over embedded Raft shards, LocalDependencyStore in the
+// memory of the peer, EtcdDependencyStore over an etcd cluster shared by the
+// peers of an organization, and GRPCDependencyStore over an external
+// conflict service.
+type DependencyStore interface {
+	// Prepare reserves the request's write set on its shard and returns a
+	// proof listing the transactions the request depends on
+	Prepare(ctx context.Context, req *PrepareRequest) (*PrepareProof, error)
+	// Abort releases the reservations made by the transaction on the shard
+	Abort(shardID, txID string) error
+}
+
+const (
+	// DependencyStoreEnvVar selects the dependency store backend: "raft"
+	// (the default), "local", "etcd" or "grpc"
+	DependencyStoreEnvVar = "FABRIC_DEPENDENCY_STORE"
+	// DependencyStoreEndpointsEnvVar is a comma-separated list of endpoints
+	// of the external dependency store
+	DependencyStoreEndpointsEnvVar = "FABRIC_DEPENDENCY_STORE_ENDPOINTS"
+)
+
+// DependencyStoreConfig selects a dependency store
+type DependencyStoreConfig struct {
+	// Backend is "raft" (the default), "local", "etcd" or "grpc"
+	Backend string
+	// Endpoints are the addresses of the etcd cluster, or the address of
+	// the conflict service
+	Endpoints []string
+	// TTL bounds the reservations of the local and etcd stores. Defaults to
+	// DefaultExpiryDuration.
+	TTL time.Duration
+	// TLS secures the connection to the conflict service; it is plaintext
+	// when nil
+	TLS *TransportTLS
+}
+
+// NewDependencyStore returns the dependency store selected by the config, or
+// nil when dependencies are tracked by the embedded Raft shards
+func NewDependencyStore(config DependencyStoreConfig) (DependencyStore, error) {
+	ttl := config.TTL
+	if ttl <= 0 {
+		ttl = DefaultExpiryDuration
+	}
+	switch backend := strings.TrimSpace(config.Backend); backend {
+	case "", "raft":
+		return nil, nil
+	case "local":
+		return NewLocalDependencyStore(ttl), nil
+	case "etcd":
+		return NewEtcdDependencyStore(append([]string(nil), config.Endpoints...), DefaultEtcdKeyPrefix, ttl)
+	case "grpc":
+		if len(config.Endpoints) != 1 {
+			return nil, fmt.Errorf("the grpc dependency store needs the address of one conflict service, got %d", len(config.Endpoints))
+		}
+		return NewGRPCDependencyStore(config.Endpoints[0], config.TLS)
+	default:
+		return nil, fmt.Errorf("unknown dependency store %q", backend)
+	}
+}
+
+// NewDependencyStoreFromEnv returns the external dependency store configured
+// via DependencyStoreEnvVar, or nil when dependencies are tracked by the
+// embedded Raft shards.
+func NewDependencyStoreFromEnv() (DependencyStore, error) {
+	config := DependencyStoreConfig{Backend: os.Getenv(DependencyStoreEnvVar)}
+	for _, ep := range strings.Split(os.Getenv(DependencyStoreEndpointsEnvVar), ",") {
+		if ep = strings.TrimSpace(ep); ep != "" {
+			config.Endpoints = append(config.Endpoints, ep)
+		}
+	}
+	if strings.TrimSpace(config.Backend) == "grpc" {
+		tlsConfig, err := TransportTLSFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		config.TLS = tlsConfig
+	}
+	return NewDependencyStore(config)
+}
+
+// Prepare implements DependencyStore. Requests for shards this peer does not
+// replicate are forwarded to a replica over the shard REST API. Read-only
+// requests are answered from a ReadIndex where possible.
+func (sm *ShardManager) Prepare(ctx context.Context, req *PrepareRequest) (*PrepareProof, error) {
+	if !sm.IsReplica(req.ShardID) {
+		logger.Debugf("Requesting remote proof for tx %s from shard %s", req.TxID, req.ShardID)
+		return sm.RequestRemoteProof(ctx, req.ShardID, req)
+	}
+
+	shard, err := sm.GetOrCreateShard(req.ShardID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get shard %s: %v", req.ShardID, err)
+	}
+
+	return shard.Prepare(ctx, req)
+}
+
+// Abort implements DependencyStore. Shards this peer does not replicate are
+// asked to abort through the REST API of one of their replicas.
+func (sm *ShardManager) Abort(shardID, txID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultAbortTimeout)
+	defer cancel()
+
+	shard, exists := sm.lookupShard(shardID)
+	switch {
+	case exists:
+		_, err := shard.AbortAndWait(ctx, txID)
+		return err
+	case !sm.IsReplica(shardID):
+		return sm.RequestRemoteAbort(ctx, shardID, txID)
+	default:
+		// The shard holds no reservation before it started
+		return nil
+	}
+}
@@ -0,0 +1,370 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hyperledger/fabric/common/ledger/util/leveldbhelper"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+const (
+	// StateStoreMemory keeps the dependency table of a shard in memory. It
+	// is rebuilt from the Raft snapshot and log on restart.
+	StateStoreMemory = "memory"
+	// StateStoreLevelDB keeps the dependency table of a shard in LevelDB
+	// under the shard's DataDir, so that it need not fit in memory and is
+	// not rebuilt on restart
+	StateStoreLevelDB = "leveldb"
+
+	// StateStoreEnvVar selects the state store of shards created by the
+	// ShardManager: StateStoreMemory (the default) or StateStoreLevelDB
+	StateStoreEnvVar = "FABRIC_SHARD_STATE_STORE"
+)
+
+// DependencyTable stores the state of a shard's state machine: the key
+// reservations and the proofs of recently applied transactions, which are
+// kept to deduplicate retried proposals. Writes made while an entry is
+// applied are visible immediately and become durable with Commit.
+// Implementations keep the reservations of each stripe of keys (see
+// stripeOf) apart, so that Get, Put and Delete may run concurrently for keys
+// of different stripes; the shard guards each stripe through
+// variableMapLock. The other methods require every stripe to be held.
+type DependencyTable interface {
+	Get(key string) (TransactionDependencyInfo, bool)
+	Put(key string, info TransactionDependencyInfo)
+	Delete(key string)
+	// Range calls fn for every reservation. fn may delete the key it is
+	// given.
+	Range(fn func(key string, info TransactionDependencyInfo))
+	// AppliedProof returns the proof issued to txID, if it is remembered
+	AppliedProof(txID string) (*PrepareProof, bool)
+	PutAppliedProof(proof *PrepareProof)
+	DeleteAppliedProof(txID string)
+	// RangeAppliedProofs calls fn for every remembered proof
+	RangeAppliedProofs(fn func(proof *PrepareProof))
+	// Commit makes the writes since the previous Commit durable, together
+	// with the index of the last entry they reflect
+	Commit(index uint64) error
+	// Index returns the index passed to the last Commit or Reset
+	Index() uint64
+	// Reset replaces the contents of the table with the given reservations
+	// and proofs as of index
+	Reset(entries map[string]TransactionDependencyInfo, proofs map[string]*PrepareProof, index uint64) error
+	Close() error
+}
+
+// openDependencyTable opens the dependency table selected by the shard config
+func openDependencyTable(config ShardConfig) (DependencyTable, error) {
+	switch strings.TrimSpace(config.StateStore) {
+	case "", StateStoreMemory:
+		return newMemoryDependencyTable(), nil
+	case StateStoreLevelDB:
+		if config.DataDir == "" {
+			return nil, fmt.Errorf("the %s state store of shard %s requires a DataDir", StateStoreLevelDB, config.ShardID)
+		}
+		return openLevelDBDependencyTable(filepath.Join(config.DataDir, config.ShardID, "state"))
+	default:
+		return nil, fmt.Errorf("unknown state store %q for shard %s", config.StateStore, config.ShardID)
+	}
+}
+
+// memoryDependencyTable is a DependencyTable held in maps, with one map of
+// reservations per stripe
+type memoryDependencyTable struct {
+	entries [lockStripes]map[string]TransactionDependencyInfo
+	proofs  map[string]*PrepareProof
+	index   uint64
+}
+
+func newMemoryDependencyTable() *memoryDependencyTable {
+	t := &memoryDependencyTable{proofs: make(map[string]*PrepareProof)}
+	for i := range t.entries {
+		t.entries[i] = make(map[string]TransactionDependencyInfo)
+	}
+	return t
+}
+
+func (t *memoryDependencyTable) Get(key string) (TransactionDependencyInfo, bool) {
+	info, ok := t.entries[stripeOf(key)][key]
+	return info, ok
+}
+
+func (t *memoryDependencyTable) Put(key string, info TransactionDependencyInfo) {
+	t.entries[stripeOf(key)][key] = info
+}
+
+func (t *memoryDependencyTable) Delete(key string) {
+	delete(t.entries[stripeOf(key)], key)
+}
+
+func (t *memoryDependencyTable) Range(fn func(key string, info TransactionDependencyInfo)) {
+	for _, entries := range t.entries {
+		for key, info := range entries {
+			fn(key, info)
+		}
+	}
+}
+
+func (t *memoryDependencyTable) AppliedProof(txID string) (*PrepareProof, bool) {
+	proof, ok := t.proofs[txID]
+	return proof, ok
+}
+
+func (t *memoryDependencyTable) PutAppliedProof(proof *PrepareProof) {
+	if t.proofs == nil {
+		t.proofs = make(map[string]*PrepareProof)
+	}
+	t.proofs[proof.TxID] = proof
+}
+
+func (t *memoryDependencyTable) DeleteAppliedProof(txID string) {
+	delete(t.proofs, txID)
+}
+
+func (t *memoryDependencyTable) RangeAppliedProofs(fn func(proof *PrepareProof)) {
+	for _, proof := range t.proofs {
+		fn(proof)
+	}
+}
+
+func (t *memoryDependencyTable) Commit(index uint64) error {
+	t.index = index
+	return nil
+}
+
+func (t *memoryDependencyTable) Index() uint64 {
+	return t.index
+}
+
+func (t *memoryDependencyTable) Reset(entries map[string]TransactionDependencyInfo, proofs map[string]*PrepareProof, index uint64) error {
+	for i := range t.entries {
+		t.entries[i] = make(map[string]TransactionDependencyInfo)
+	}
+	for key, info := range entries {
+		t.Put(key, info)
+	}
+	t.proofs = make(map[string]*PrepareProof, len(proofs))
+	for txID, proof := range proofs {
+		t.proofs[txID] = proof
+	}
+	t.index = index
+	return nil
+}
+
+func (t *memoryDependencyTable) Close() error {
+	return nil
+}
+
+var (
+	levelDBDependencyPrefix = []byte("d/")
+	levelDBProofPrefix      = []byte("p/")
+	levelDBIndexKey         = []byte("m/index")
+)
+
+// levelDBDependencyTable is a DependencyTable persisted in LevelDB. Writes
+// are buffered until Commit, which stores them along with the applied index
+// in a single batch.
+type levelDBDependencyTable struct {
+	db    *leveldbhelper.DB
+	index uint64
+	// pending holds, for each stripe of keys, the encoded values written
+	// since the last Commit by database key, where nil marks a deletion
+	pending [lockStripes]map[string][]byte
+}
+
+// openLevelDBDependencyTable opens the table at dir, creating it if needed
+func openLevelDBDependencyTable(dir string) (*levelDBDependencyTable, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create state dir %s: %v", dir, err)
+	}
+	db := leveldbhelper.CreateDB(&leveldbhelper.Conf{DBPath: dir})
+	db.Open()
+
+	t := &levelDBDependencyTable{db: db}
+	t.resetPending()
+	value, err := db.Get(levelDBIndexKey)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to read applied index from %s: %v", dir, err)
+	}
+	if len(value) == 8 {
+		t.index = binary.BigEndian.Uint64(value)
+	}
+	return t, nil
+}
+
+func (t *levelDBDependencyTable) Get(key string) (TransactionDependencyInfo, bool) {
+	var info TransactionDependencyInfo
+	value, ok := t.get(levelDBDependencyPrefix, key)
+	if ok {
+		t.decode(key, value, &info)
+	}
+	return info, ok
+}
+
+func (t *levelDBDependencyTable) Put(key string, info TransactionDependencyInfo) {
+	t.put(levelDBDependencyPrefix, key, info)
+}
+
+func (t *levelDBDependencyTable) Delete(key string) {
+	t.pending[stripeOf(key)][string(levelDBDependencyPrefix)+key] = nil
+}
+
+func (t *levelDBDependencyTable) Range(fn func(key string, info TransactionDependencyInfo)) {
+	t.rangePrefix(levelDBDependencyPrefix, func(key string, value []byte) {
+		var info TransactionDependencyInfo
+		t.decode(key, value, &info)
+		fn(key, info)
+	})
+}
+
+func (t *levelDBDependencyTable) AppliedProof(txID string) (*PrepareProof, bool) {
+	value, ok := t.get(levelDBProofPrefix, txID)
+	if !ok {
+		return nil, false
+	}
+	proof := &PrepareProof{}
+	t.decode(txID, value, proof)
+	return proof, true
+}
+
+func (t *levelDBDependencyTable) PutAppliedProof(proof *PrepareProof) {
+	t.put(levelDBProofPrefix, proof.TxID, proof)
+}
+
+func (t *levelDBDependencyTable) DeleteAppliedProof(txID string) {
+	t.pending[stripeOf(txID)][string(levelDBProofPrefix)+txID] = nil
+}
+
+func (t *levelDBDependencyTable) RangeAppliedProofs(fn func(proof *PrepareProof)) {
+	t.rangePrefix(levelDBProofPrefix, func(txID string, value []byte) {
+		proof := &PrepareProof{}
+		t.decode(txID, value, proof)
+		fn(proof)
+	})
+}
+
+func (t *levelDBDependencyTable) get(prefix []byte, key string) ([]byte, bool) {
+	if value, ok := t.pending[stripeOf(key)][string(prefix)+key]; ok {
+		return value, value != nil
+	}
+
+	value, err := t.db.Get([]byte(string(prefix) + key))
+	if err != nil {
+		logger.Panicf("Failed to read state of key %s: %v", key, err)
+	}
+	return value, value != nil
+}
+
+func (t *levelDBDependencyTable) put(prefix []byte, key string, v interface{}) {
+	value, err := json.Marshal(v)
+	if err != nil {
+		logger.Panicf("Failed to encode state of key %s: %v", key, err)
+	}
+	t.pending[stripeOf(key)][string(prefix)+key] = value
+}
+
+// rangePrefix calls fn with the key, stripped of prefix, and value of every
+// entry under prefix
+func (t *levelDBDependencyTable) rangePrefix(prefix []byte, fn func(key string, value []byte)) {
+	// Copy the pending writes first, since fn may add deletions
+	pending := make(map[string][]byte)
+	for _, stripe := range t.pending {
+		for dbKey, value := range stripe {
+			if strings.HasPrefix(dbKey, string(prefix)) {
+				pending[dbKey] = value
+			}
+		}
+	}
+
+	end := append([]byte{}, prefix...)
+	end[len(end)-1]++
+	itr := t.db.GetIterator(prefix, end)
+	for itr.Next() {
+		if _, overridden := pending[string(itr.Key())]; overridden {
+			continue
+		}
+		fn(string(itr.Key()[len(prefix):]), itr.Value())
+	}
+	err := itr.Error()
+	itr.Release()
+	if err != nil {
+		logger.Panicf("Failed to iterate state: %v", err)
+	}
+
+	for dbKey, value := range pending {
+		if value != nil {
+			fn(dbKey[len(prefix):], value)
+		}
+	}
+}
+
+func (t *levelDBDependencyTable) decode(key string, value []byte, v interface{}) {
+	if err := json.Unmarshal(value, v); err != nil {
+		logger.Panicf("Failed to decode state of key %s: %v", key, err)
+	}
+}
+
+func (t *levelDBDependencyTable) Commit(index uint64) error {
+	batch := &leveldb.Batch{}
+	for _, stripe := range t.pending {
+		for dbKey, value := range stripe {
+			if value == nil {
+				batch.Delete([]byte(dbKey))
+			} else {
+				batch.Put([]byte(dbKey), value)
+			}
+		}
+	}
+	batch.Put(levelDBIndexKey, binary.BigEndian.AppendUint64(nil, index))
+
+	if err := t.db.WriteBatch(batch, true); err != nil {
+		return err
+	}
+	t.resetPending()
+	t.index = index
+	return nil
+}
+
+func (t *levelDBDependencyTable) resetPending() {
+	for i := range t.pending {
+		t.pending[i] = make(map[string][]byte)
+	}
+}
+
+func (t *levelDBDependencyTable) Index() uint64 {
+	return t.index
+}
+
+func (t *levelDBDependencyTable) Reset(entries map[string]TransactionDependencyInfo, proofs map[string]*PrepareProof, index uint64) error {
+	t.resetPending()
+	for _, prefix := range [][]byte{levelDBDependencyPrefix, levelDBProofPrefix} {
+		prefix := prefix
+		t.rangePrefix(prefix, func(key string, _ []byte) {
+			t.pending[stripeOf(key)][string(prefix)+key] = nil
+		})
+	}
+	for key, info := range entries {
+		t.Put(key, info)
+	}
+	for _, proof := range proofs {
+		t.PutAppliedProof(proof)
+	}
+	return t.Commit(index)
+}
+
+func (t *levelDBDependencyTable) Close() error {
+	t.db.Close()
+	return nil
+}
@@ -0,0 +1,158 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"go.etcd.io/etcd/raft/v3"
+	"go.etcd.io/etcd/raft/v3/raftpb"
+)
+
+func tableEntries(table DependencyTable) map[string]TransactionDependencyInfo {
+	entries := make(map[string]TransactionDependencyInfo)
+	table.Range(func(key string, info TransactionDependencyInfo) {
+		entries[key] = info
+	})
+	return entries
+}
+
+func TestOpenDependencyTable(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	table, err := openDependencyTable(ShardConfig{ShardID: "fabcar"})
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(table).To(BeAssignableToTypeOf(&memoryDependencyTable{}))
+
+	_, err = openDependencyTable(ShardConfig{ShardID: "fabcar", StateStore: StateStoreLevelDB})
+	gt.Expect(err).To(MatchError("the leveldb state store of shard fabcar requires a DataDir"))
+
+	_, err = openDependencyTable(ShardConfig{ShardID: "fabcar", StateStore: "rocksdb"})
+	gt.Expect(err).To(MatchError(`unknown state store "rocksdb" for shard fabcar`))
+}
+
+func TestLevelDBDependencyTable(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	dir, err := ioutil.TempDir("", "shard-state")
+	gt.Expect(err).NotTo(HaveOccurred())
+	defer os.RemoveAll(dir)
+
+	table, err := openLevelDBDependencyTable(dir)
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(table.Index()).To(Equal(uint64(0)))
+
+	expiry := time.Unix(0, 5000).UTC()
+	table.Put("k1", TransactionDependencyInfo{DependentTxID: "tx1", Value: []byte("v1"), ExpiryTime: expiry})
+	table.Put("k2", TransactionDependencyInfo{DependentTxID: "tx2"})
+	gt.Expect(table.Commit(3)).To(Succeed())
+
+	// uncommitted writes are visible to reads and ranges
+	table.Put("k3", TransactionDependencyInfo{DependentTxID: "tx3"})
+	table.Delete("k2")
+	_, found := table.Get("k2")
+	gt.Expect(found).To(BeFalse())
+	gt.Expect(tableEntries(table)).To(HaveLen(2))
+
+	// Range callbacks may delete the key they are given
+	table.Range(func(key string, info TransactionDependencyInfo) {
+		if info.DependentTxID == "tx3" {
+			table.Delete(key)
+		}
+	})
+	gt.Expect(tableEntries(table)).To(HaveLen(1))
+	gt.Expect(table.Commit(4)).To(Succeed())
+	table.Put("lost", TransactionDependencyInfo{DependentTxID: "tx4"})
+	gt.Expect(table.Close()).To(Succeed())
+
+	// only committed writes survive a restart
+	table, err = openLevelDBDependencyTable(dir)
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(table.Index()).To(Equal(uint64(4)))
+	info, found := table.Get("k1")
+	gt.Expect(found).To(BeTrue())
+	gt.Expect(info.DependentTxID).To(Equal("tx1"))
+	gt.Expect(info.Value).To(Equal([]byte("v1")))
+	gt.Expect(info.ExpiryTime.Equal(expiry)).To(BeTrue())
+	gt.Expect(tableEntries(table)).To(HaveLen(1))
+
+	table.PutAppliedProof(&PrepareProof{TxID: "tx1", CommitIndex: 3})
+	proof, found := table.AppliedProof("tx1")
+	gt.Expect(found).To(BeTrue())
+	gt.Expect(proof.CommitIndex).To(Equal(uint64(3)))
+	gt.Expect(table.Commit(5)).To(Succeed())
+
+	// proofs and reservations are kept apart
+	gt.Expect(tableEntries(table)).To(HaveLen(1))
+	var proofs []string
+	table.RangeAppliedProofs(func(proof *PrepareProof) { proofs = append(proofs, proof.TxID) })
+	gt.Expect(proofs).To(Equal([]string{"tx1"}))
+
+	gt.Expect(table.Reset(map[string]TransactionDependencyInfo{"k9": {DependentTxID: "tx9"}}, map[string]*PrepareProof{"tx9": {TxID: "tx9"}}, 9)).To(Succeed())
+	gt.Expect(table.Index()).To(Equal(uint64(9)))
+	gt.Expect(tableEntries(table)).To(Equal(map[string]TransactionDependencyInfo{"k9": {DependentTxID: "tx9"}}))
+	_, found = table.AppliedProof("tx1")
+	gt.Expect(found).To(BeFalse())
+	_, found = table.AppliedProof("tx9")
+	gt.Expect(found).To(BeTrue())
+	gt.Expect(table.Close()).To(Succeed())
+}
+
+func TestShardRestartWithLevelDBStateStore(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	dataDir, err := ioutil.TempDir("", "shard-leveldb")
+	gt.Expect(err).NotTo(HaveOccurred())
+	defer os.RemoveAll(dataDir)
+
+	s, _, _, err := openShardStorage(dataDir, "fabcar", raft.NewMemoryStorage())
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(s.store(raftpb.HardState{Term: 1, Vote: 1, Commit: 3}, testShardEntries(gt, "tx1", "tx2"))).To(Succeed())
+	gt.Expect(s.close()).To(Succeed())
+
+	config := ShardConfig{
+		ShardID:    "fabcar",
+		ReplicaIDs: []uint64{1},
+		ReplicaID:  1,
+		DataDir:    dataDir,
+		StateStore: StateStoreLevelDB,
+	}
+	sl, err := NewShardLeader(config, DefaultBatchTimeout, DefaultBatchMaxSize)
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Eventually(func() uint64 {
+		sl.variableMapLock.RLock()
+		defer sl.variableMapLock.RUnlock()
+		return sl.variableMap.Index()
+	}, 5*time.Second, 10*time.Millisecond).Should(Equal(uint64(3)))
+	gt.Expect(sl.HasProof("tx1")).To(BeTrue())
+	sl.Stop()
+
+	_, err = os.Stat(filepath.Join(dataDir, "fabcar", "state"))
+	gt.Expect(err).NotTo(HaveOccurred())
+
+	// the restarted replica reads its dependencies from the state store and
+	// does not apply the entries they already reflect
+	sl, err = NewShardLeader(config, DefaultBatchTimeout, DefaultBatchMaxSize)
+	gt.Expect(err).NotTo(HaveOccurred())
+	defer sl.Stop()
+
+	gt.Eventually(func() uint64 { return atomic.LoadUint64(&sl.appliedIndex) }, 5*time.Second, 10*time.Millisecond).Should(Equal(uint64(3)))
+	gt.Expect(sl.GetRequestsHandled()).To(BeZero())
+	gt.Expect(sl.HasProof("tx1")).To(BeTrue())
+	for _, txID := range []string{"tx1", "tx2"} {
+		read, err := sl.GetDependency("fabcar:"+txID, StalenessBound{})
+		gt.Expect(err).NotTo(HaveOccurred())
+		gt.Expect(read.Found).To(BeTrue())
+		gt.Expect(read.Info.DependentTxID).To(Equal(txID))
+	}
+}
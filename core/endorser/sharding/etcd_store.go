@@ -0,0 +1,404 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultEtcdKeyPrefix is the key prefix under which reservations are stored
+	DefaultEtcdKeyPrefix = "/fabric/dependencies"
+	// etcdMaxPrepareAttempts bounds the optimistic retries of a contended prepare
+	etcdMaxPrepareAttempts = 10
+)
+
+// EtcdDependencyStore tracks dependencies in an etcd cluster through its v3
+// JSON gateway. Each written key maps to the TxID of its latest reserving
+// transaction. Reservations are attached to a per-transaction lease, so they
+// expire on their own and an abort simply revokes the lease.
+type EtcdDependencyStore struct {
+	endpoints []string
+	prefix    string
+	ttl       time.Duration
+	client    *http.Client
+
+	mu     sync.Mutex
+	next   int
+	leases map[string]etcdLease
+}
+
+type etcdLease struct {
+	id        int64
+	expiresAt time.Time
+}
+
+// NewEtcdDependencyStore creates a dependency store backed by the etcd cluster
+// reachable at the given endpoints (e.g. "http://10.0.0.1:2379")
+func NewEtcdDependencyStore(endpoints []string, prefix string, ttl time.Duration) (*EtcdDependencyStore, error) {
+	if len(endpoints) == 0 {
+		return nil, fmt.Errorf("no etcd endpoints configured, set %s", DependencyStoreEndpointsEnvVar)
+	}
+	if ttl < time.Second {
+		return nil, fmt.Errorf("reservation ttl must be at least 1s, got %s", ttl)
+	}
+
+	for i, ep := range endpoints {
+		if !strings.Contains(ep, "://") {
+			ep = "http://" + ep
+		}
+		endpoints[i] = strings.TrimSuffix(ep, "/")
+	}
+
+	logger.Infof("Using etcd dependency store at %v", endpoints)
+	return &EtcdDependencyStore{
+		endpoints: endpoints,
+		prefix:    strings.TrimSuffix(prefix, "/"),
+		ttl:       ttl,
+		client:    &http.Client{Timeout: 10 * time.Second},
+		leases:    make(map[string]etcdLease),
+	}, nil
+}
+
+// Prepare implements DependencyStore. The read and write keys are read, the
+// holders become the dependencies, and the write keys are reserved in a
+// transaction guarded on the revisions that were read. The whole sequence is
+// retried if another peer reserved one of the keys in between.
+func (s *EtcdDependencyStore) Prepare(ctx context.Context, req *PrepareRequest) (*PrepareProof, error) {
+	keySet := make(map[string]bool)
+	for k := range req.ReadSet {
+		keySet[k] = true
+	}
+	for k := range req.WriteSet {
+		keySet[k] = true
+	}
+	keys := make([]string, 0, len(keySet))
+	for k := range keySet {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	ttl := req.ttlOr(s.ttl)
+	var leaseID int64
+	if len(req.WriteSet) > 0 {
+		id, err := s.grantLease(ctx, ttl)
+		if err != nil {
+			return nil, fmt.Errorf("failed to grant lease for tx %s: %v", req.TxID, err)
+		}
+		leaseID = id
+	}
+
+	reserved := false
+	defer func() {
+		if !reserved {
+			s.revokeLease(context.Background(), leaseID)
+		}
+	}()
+
+	for attempt := 0; attempt < etcdMaxPrepareAttempts; attempt++ {
+		txn := etcdTxnRequest{}
+		// The holders of the keys are recorded as writers, since the store
+		// does not remember which keys they only read
+		deps := make(conflictSet)
+		var revision int64
+
+		for _, key := range keys {
+			kv, rev, err := s.get(ctx, s.reservationKey(req.ShardID, key))
+			if err != nil {
+				return nil, fmt.Errorf("failed to read reservation of key %s: %v", key, err)
+			}
+			revision = rev
+
+			var modRevision int64
+			if kv != nil {
+				modRevision = kv.ModRevision
+				if holder := string(kv.Value); holder != req.TxID {
+					_, read := req.ReadSet[key]
+					deps.add(holder, classifyConflict(read, false))
+				}
+			}
+			txn.Compare = append(txn.Compare, etcdCompare{
+				Key:         kv64(s.reservationKey(req.ShardID, key)),
+				Target:      "MOD",
+				Result:      "EQUAL",
+				ModRevision: modRevision,
+			})
+			if _, isWrite := req.WriteSet[key]; isWrite {
+				txn.Success = append(txn.Success, etcdRequestOp{
+					RequestPut: &etcdPutRequest{
+						Key:   kv64(s.reservationKey(req.ShardID, key)),
+						Value: kv64(req.TxID),
+						Lease: leaseID,
+					},
+				})
+			}
+		}
+
+		if len(txn.Success) > 0 {
+			var resp etcdTxnResponse
+			if err := s.call(ctx, "/v3/kv/txn", txn, &resp); err != nil {
+				return nil, fmt.Errorf("failed to reserve keys for tx %s: %v", req.TxID, err)
+			}
+			if !resp.Succeeded {
+				logger.Debugf("Shard %s: reservation of tx %s raced with another peer, retrying", req.ShardID, req.TxID)
+				continue
+			}
+			revision = resp.Header.Revision
+			reserved = true
+			s.trackLease(req.ShardID, req.TxID, leaseID, ttl)
+		}
+
+		return &PrepareProof{
+			TxID:           req.TxID,
+			ShardID:        req.ShardID,
+			CommitIndex:    uint64(revision),
+			Signature:      signPrepareProof(req.ShardID, uint64(revision), req.TxID),
+			DependentTxID:  deps.dependentTxID(),
+			HasDependency:  len(deps) > 0,
+			ConflictTypes:  deps.types(),
+			ConflictPolicy: ConflictPolicyQueueBehind,
+			HLC:            observe(req),
+		}, nil
+	}
+
+	return nil, fmt.Errorf("failed to reserve keys for tx %s after %d attempts due to contention", req.TxID, etcdMaxPrepareAttempts)
+}
+
+// Abort implements DependencyStore by revoking the transaction's lease,
+// which deletes all keys it reserved
+func (s *EtcdDependencyStore) Abort(shardID, txID string) error {
+	s.mu.Lock()
+	lease, exists := s.leases[shardID+"/"+txID]
+	delete(s.leases, shardID+"/"+txID)
+	s.mu.Unlock()
+
+	if !exists {
+		return nil
+	}
+	return s.revokeLease(context.Background(), lease.id)
+}
+
+func (s *EtcdDependencyStore) reservationKey(shardID, key string) string {
+	return s.prefix + "/" + shardID + "/" + key
+}
+
+// trackLease remembers the lease of a reservation so it can be aborted, and
+// forgets leases that etcd has already expired
+func (s *EtcdDependencyStore) trackLease(shardID, txID string, leaseID int64, ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for k, lease := range s.leases {
+		if now.After(lease.expiresAt) {
+			delete(s.leases, k)
+		}
+	}
+	s.leases[shardID+"/"+txID] = etcdLease{id: leaseID, expiresAt: now.Add(ttl)}
+}
+
+// grantLease grants a lease of the ttl, rounded up to whole seconds
+func (s *EtcdDependencyStore) grantLease(ctx context.Context, ttl time.Duration) (int64, error) {
+	var resp etcdLeaseGrantResponse
+	if err := s.call(ctx, "/v3/lease/grant", etcdLeaseGrantRequest{TTL: int64((ttl + time.Second - 1) / time.Second)}, &resp); err != nil {
+		return 0, err
+	}
+	if resp.Error != "" {
+		return 0, fmt.Errorf("%s", resp.Error)
+	}
+	return resp.ID, nil
+}
+
+func (s *EtcdDependencyStore) revokeLease(ctx context.Context, leaseID int64) error {
+	if leaseID == 0 {
+		return nil
+	}
+	return s.call(ctx, "/v3/lease/revoke", etcdLeaseRevokeRequest{ID: leaseID}, &struct{}{})
+}
+
+// get returns the key-value for key, or nil if it does not exist, along with
+// the store revision the read was served at
+func (s *EtcdDependencyStore) get(ctx context.Context, key string) (*etcdKeyValue, int64, error) {
+	var resp etcdRangeResponse
+	if err := s.call(ctx, "/v3/kv/range", etcdRangeRequest{Key: kv64(key)}, &resp); err != nil {
+		return nil, 0, err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, resp.Header.Revision, nil
+	}
+	return &resp.Kvs[0], resp.Header.Revision, nil
+}
+
+// call posts the request to the gateway, failing over to the next endpoint on
+// transport errors
+func (s *EtcdDependencyStore) call(ctx context.Context, path string, in, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	start := s.next
+	s.mu.Unlock()
+
+	var lastErr error
+	for i := 0; i < len(s.endpoints); i++ {
+		idx := (start + i) % len(s.endpoints)
+		httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoints[idx]+path, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		httpReq.Header.Set("Content-Type", "application/json")
+
+		resp, err := s.client.Do(httpReq)
+		if err != nil {
+			lastErr = err
+			if ctx.Err() != nil {
+				return lastErr
+			}
+			continue
+		}
+		data, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("etcd %s returned %s: %s", path, resp.Status, strings.TrimSpace(string(data)))
+		}
+
+		s.mu.Lock()
+		s.next = idx
+		s.mu.Unlock()
+		return json.Unmarshal(data, out)
+	}
+	return fmt.Errorf("all etcd endpoints failed: %v", lastErr)
+}
+
+// kv64 encodes a key or value the way the etcd JSON gateway expects bytes fields
+func kv64(s string) string {
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
+
+// etcdInt64 decodes the int64 fields that the etcd JSON gateway renders as strings
+type etcdInt64 int64
+
+func (i *etcdInt64) UnmarshalJSON(data []byte) error {
+	v, err := strconv.ParseInt(strings.Trim(string(data), `"`), 10, 64)
+	if err != nil {
+		return err
+	}
+	*i = etcdInt64(v)
+	return nil
+}
+
+type etcdResponseHeader struct {
+	Revision int64
+}
+
+func (h *etcdResponseHeader) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Revision etcdInt64 `json:"revision"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	h.Revision = int64(raw.Revision)
+	return nil
+}
+
+type etcdKeyValue struct {
+	Key         []byte
+	Value       []byte
+	ModRevision int64
+}
+
+func (kv *etcdKeyValue) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Key         []byte    `json:"key"`
+		Value       []byte    `json:"value"`
+		ModRevision etcdInt64 `json:"mod_revision"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	kv.Key, kv.Value, kv.ModRevision = raw.Key, raw.Value, int64(raw.ModRevision)
+	return nil
+}
+
+type etcdRangeRequest struct {
+	Key string `json:"key"`
+}
+
+type etcdRangeResponse struct {
+	Header etcdResponseHeader `json:"header"`
+	Kvs    []etcdKeyValue     `json:"kvs"`
+}
+
+type etcdCompare struct {
+	Key         string `json:"key"`
+	Target      string `json:"target"`
+	Result      string `json:"result"`
+	ModRevision int64  `json:"mod_revision,string"`
+}
+
+type etcdPutRequest struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	Lease int64  `json:"lease,string,omitempty"`
+}
+
+type etcdRequestOp struct {
+	RequestPut *etcdPutRequest `json:"request_put,omitempty"`
+}
+
+type etcdTxnRequest struct {
+	Compare []etcdCompare   `json:"compare"`
+	Success []etcdRequestOp `json:"success"`
+}
+
+type etcdTxnResponse struct {
+	Header    etcdResponseHeader `json:"header"`
+	Succeeded bool               `json:"succeeded"`
+}
+
+type etcdLeaseGrantRequest struct {
+	TTL int64 `json:"TTL,string"`
+}
+
+type etcdLeaseGrantResponse struct {
+	ID    int64  `json:"-"`
+	Error string `json:"error"`
+}
+
+func (r *etcdLeaseGrantResponse) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		ID    etcdInt64 `json:"ID"`
+		Error string    `json:"error"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	r.ID, r.Error = int64(raw.ID), raw.Error
+	return nil
+}
+
+type etcdLeaseRevokeRequest struct {
+	ID int64 `json:"ID,string"`
+}
@@ -0,0 +1,159 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+// fakeEtcdGateway implements the subset of the etcd v3 JSON gateway used by
+// EtcdDependencyStore
+type fakeEtcdGateway struct {
+	mu        sync.Mutex
+	revision  int64
+	nextLease int64
+	kvs       map[string]fakeEtcdEntry
+}
+
+type fakeEtcdEntry struct {
+	value       []byte
+	modRevision int64
+	lease       int64
+}
+
+func newFakeEtcdGateway() *fakeEtcdGateway {
+	return &fakeEtcdGateway{revision: 1, kvs: make(map[string]fakeEtcdEntry)}
+}
+
+func (f *fakeEtcdGateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	header := map[string]string{"revision": strconv.FormatInt(f.revision, 10)}
+	switch r.URL.Path {
+	case "/v3/kv/range":
+		var req struct {
+			Key []byte `json:"key"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		resp := map[string]interface{}{"header": header}
+		if e, ok := f.kvs[string(req.Key)]; ok {
+			resp["kvs"] = []map[string]interface{}{{
+				"key":          req.Key,
+				"value":        e.value,
+				"mod_revision": strconv.FormatInt(e.modRevision, 10),
+			}}
+		}
+		json.NewEncoder(w).Encode(resp)
+
+	case "/v3/kv/txn":
+		var req struct {
+			Compare []struct {
+				Key         []byte `json:"key"`
+				ModRevision int64  `json:"mod_revision,string"`
+			} `json:"compare"`
+			Success []struct {
+				RequestPut struct {
+					Key   []byte `json:"key"`
+					Value []byte `json:"value"`
+					Lease int64  `json:"lease,string"`
+				} `json:"request_put"`
+			} `json:"success"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		for _, c := range req.Compare {
+			if f.kvs[string(c.Key)].modRevision != c.ModRevision {
+				json.NewEncoder(w).Encode(map[string]interface{}{"header": header})
+				return
+			}
+		}
+		f.revision++
+		for _, op := range req.Success {
+			f.kvs[string(op.RequestPut.Key)] = fakeEtcdEntry{
+				value:       op.RequestPut.Value,
+				modRevision: f.revision,
+				lease:       op.RequestPut.Lease,
+			}
+		}
+		header["revision"] = strconv.FormatInt(f.revision, 10)
+		json.NewEncoder(w).Encode(map[string]interface{}{"header": header, "succeeded": true})
+
+	case "/v3/lease/grant":
+		f.nextLease++
+		json.NewEncoder(w).Encode(map[string]string{"ID": strconv.FormatInt(f.nextLease, 10), "TTL": "300"})
+
+	case "/v3/lease/revoke":
+		var req struct {
+			ID int64 `json:"ID,string"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		for k, e := range f.kvs {
+			if e.lease == req.ID {
+				delete(f.kvs, k)
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"header": header})
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestEtcdDependencyStore(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	gateway := newFakeEtcdGateway()
+	server := httptest.NewServer(gateway)
+	defer server.Close()
+
+	_, err := NewEtcdDependencyStore(nil, DefaultEtcdKeyPrefix, time.Minute)
+	gt.Expect(err).To(MatchError("no etcd endpoints configured, set FABRIC_DEPENDENCY_STORE_ENDPOINTS"))
+
+	store, err := NewEtcdDependencyStore([]string{"127.0.0.1:1", server.URL}, DefaultEtcdKeyPrefix, time.Minute)
+	gt.Expect(err).NotTo(HaveOccurred())
+
+	ctx := context.Background()
+	proof1, err := store.Prepare(ctx, &PrepareRequest{
+		TxID:     "tx1",
+		ShardID:  "fabcar",
+		WriteSet: map[string][]byte{"fabcar:car1": []byte("v1")},
+	})
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(proof1.HasDependency).To(BeFalse())
+	gt.Expect(string(proof1.Signature)).To(Equal("fabcar:2:tx1"))
+
+	proof2, err := store.Prepare(ctx, &PrepareRequest{
+		TxID:     "tx2",
+		ShardID:  "fabcar",
+		WriteSet: map[string][]byte{"fabcar:car1": []byte("v2"), "fabcar:car2": []byte("v2")},
+	})
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(proof2.HasDependency).To(BeTrue())
+	gt.Expect(proof2.DependentTxID).To(Equal("tx1"))
+	gt.Expect(proof2.CommitIndex).To(Equal(uint64(3)))
+
+	// aborting tx2 releases its reservations, so tx3 has no dependency
+	gt.Expect(store.Abort("fabcar", "tx2")).To(Succeed())
+	gt.Expect(gateway.kvs).To(BeEmpty())
+
+	proof3, err := store.Prepare(ctx, &PrepareRequest{
+		TxID:    "tx3",
+		ShardID: "fabcar",
+		ReadSet: map[string][]byte{"fabcar:car2": nil},
+	})
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(proof3.HasDependency).To(BeFalse())
+}
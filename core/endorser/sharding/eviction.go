@@ -0,0 +1,199 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// ShardIdleTimeoutEnvVar sets how long a dynamically created shard may go
+	// without local requests or applied entries before it is stopped, e.g.
+	// "10m". Idle shards are kept when it is unset.
+	ShardIdleTimeoutEnvVar = "FABRIC_SHARD_IDLE_TIMEOUT"
+	// MaxActiveShardsEnvVar caps the number of dynamically created shards
+	// running at once. The least recently used shard is stopped to make room
+	// for a new one. There is no cap when it is unset.
+	MaxActiveShardsEnvVar = "FABRIC_SHARD_MAX_ACTIVE"
+)
+
+// minEvictionInterval bounds how often idle shards are looked for
+const minEvictionInterval = time.Second
+
+// idleEvictionFromEnv returns the idle timeout and the cap on active shards
+// configured via ShardIdleTimeoutEnvVar and MaxActiveShardsEnvVar
+func idleEvictionFromEnv() (time.Duration, int) {
+	var idleTimeout time.Duration
+	if spec := os.Getenv(ShardIdleTimeoutEnvVar); spec != "" {
+		d, err := time.ParseDuration(spec)
+		if err != nil || d < 0 {
+			logger.Warningf("Ignoring %s %q: invalid duration", ShardIdleTimeoutEnvVar, spec)
+		} else {
+			idleTimeout = d
+		}
+	}
+
+	var maxActive int
+	if spec := os.Getenv(MaxActiveShardsEnvVar); spec != "" {
+		n, err := strconv.Atoi(spec)
+		if err != nil || n < 0 {
+			logger.Warningf("Ignoring %s %q: invalid shard count", MaxActiveShardsEnvVar, spec)
+		} else {
+			maxActive = n
+		}
+	}
+	return idleTimeout, maxActive
+}
+
+// touch records that the shard was just used
+func (sl *ShardLeader) touch() {
+	atomic.StoreInt64(&sl.lastUsed, time.Now().UnixNano())
+}
+
+// LastUsed returns when the shard last served a local request or applied an entry
+func (sl *ShardLeader) LastUsed() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&sl.lastUsed))
+}
+
+// busy reports whether the shard holds queued requests or waiting proposers,
+// which would be lost if it were stopped
+func (sl *ShardLeader) busy() bool {
+	sl.batchLock.Lock()
+	queued := len(sl.batchQueue)
+	sl.batchLock.Unlock()
+
+	sl.mu.RLock()
+	waiting := len(sl.subscribers) + len(sl.abortWaiters) + len(sl.readIndexWaiters) + len(sl.expireWaiters)
+	sl.mu.RUnlock()
+
+	return queued+len(sl.proposeC)+waiting > 0
+}
+
+// lookupShard returns the shard if it is running on this peer and marks it used
+func (sm *ShardManager) lookupShard(shardID string) (*ShardLeader, bool) {
+	sm.shardsLock.RLock()
+	shard, exists := sm.shards[shardID]
+	sm.shardsLock.RUnlock()
+
+	if exists {
+		shard.touch()
+	}
+	return shard, exists
+}
+
+// runIdleEviction periodically stops shards that stayed idle for longer than
+// the idle timeout
+func (sm *ShardManager) runIdleEviction() {
+	interval := sm.idleTimeout / 2
+	if interval < minEvictionInterval {
+		interval = minEvictionInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			sm.evictIdleShards(time.Now())
+		case <-sm.stopC:
+			return
+		}
+	}
+}
+
+// evictIdleShards stops the dynamically created shards that were last used
+// before now minus the idle timeout
+func (sm *ShardManager) evictIdleShards(now time.Time) {
+	sm.shardsLock.Lock()
+	defer sm.shardsLock.Unlock()
+
+	for shardID, shard := range sm.shards {
+		if sm.evictable(shardID, shard) && now.Sub(shard.LastUsed()) > sm.idleTimeout {
+			sm.evictShardLocked(shardID, shard, "idle")
+		}
+	}
+}
+
+// makeRoomLocked evicts least recently used shards until a new shard fits
+// under the cap on active shards. The caller must hold shardsLock.
+func (sm *ShardManager) makeRoomLocked() {
+	if sm.maxActiveShards <= 0 {
+		return
+	}
+
+	for {
+		var lruID string
+		var lru *ShardLeader
+		active := 0
+		for shardID, shard := range sm.shards {
+			if sm.pinned(shardID) {
+				continue
+			}
+			active++
+			if !shard.busy() && (lru == nil || shard.LastUsed().Before(lru.LastUsed())) {
+				lruID, lru = shardID, shard
+			}
+		}
+		if active < sm.maxActiveShards || lru == nil {
+			return
+		}
+		sm.evictShardLocked(lruID, lru, "least recently used")
+	}
+}
+
+// evictable reports whether the shard may be stopped
+func (sm *ShardManager) evictable(shardID string, shard *ShardLeader) bool {
+	return !sm.pinned(shardID) && !shard.busy()
+}
+
+// pinned reports whether the shard was configured when the manager was
+// created or pinned since. Such shards are never evicted. The caller must
+// hold shardsLock.
+func (sm *ShardManager) pinned(shardID string) bool {
+	_, configured := sm.config[shardID]
+	return configured || sm.pinnedShards[shardID]
+}
+
+// PinShard starts the shard unless it is running and exempts it from
+// eviction, for shards that must keep running while no transaction touches
+// them
+func (sm *ShardManager) PinShard(shardID string) (*ShardLeader, error) {
+	sm.shardsLock.Lock()
+	defer sm.shardsLock.Unlock()
+
+	sm.pinnedShards[shardID] = true
+	if shard, exists := sm.shards[shardID]; exists {
+		return shard, nil
+	}
+	return sm.startShardLocked(sm.shardConfig(shardID), nil)
+}
+
+// evictShardLocked stops the shard and forgets it, so that the next request
+// for it creates it anew. Its Raft log is replayed on creation when the shard
+// persists it. The caller must hold shardsLock.
+func (sm *ShardManager) evictShardLocked(shardID string, shard *ShardLeader, reason string) {
+	globalTransportLock.Lock()
+	if globalTransport != nil {
+		globalTransport.UnregisterShard(shardID)
+	}
+	globalTransportLock.Unlock()
+	shard.Stop()
+	delete(sm.shards, shardID)
+	atomic.AddUint64(&sm.evictions, 1)
+	sm.events.publish(shardEvent{kind: shardStopped, shardID: shardID})
+
+	logger.Infof("Evicted %s shard %s, last used at %s", reason, shardID, shard.LastUsed().Format(time.RFC3339))
+}
+
+// Evictions returns the number of shards stopped for being idle or least
+// recently used
+func (sm *ShardManager) Evictions() uint64 {
+	return atomic.LoadUint64(&sm.evictions)
+}
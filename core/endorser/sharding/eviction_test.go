@@ -0,0 +1,133 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func newEvictionTestShard(t *testing.T, shardID string, lastUsed time.Time) *ShardLeader {
+	sl, err := NewShardLeader(ShardConfig{
+		ShardID:    shardID,
+		ReplicaIDs: []uint64{1},
+		ReplicaID:  1,
+	}, time.Hour, DefaultBatchMaxSize)
+	NewGomegaWithT(t).Expect(err).NotTo(HaveOccurred())
+	atomic.StoreInt64(&sl.lastUsed, lastUsed.UnixNano())
+	return sl
+}
+
+func TestIdleEvictionFromEnv(t *testing.T) {
+	gt := NewGomegaWithT(t)
+	defer os.Unsetenv(ShardIdleTimeoutEnvVar)
+	defer os.Unsetenv(MaxActiveShardsEnvVar)
+
+	idleTimeout, maxActive := idleEvictionFromEnv()
+	gt.Expect(idleTimeout).To(BeZero())
+	gt.Expect(maxActive).To(BeZero())
+
+	os.Setenv(ShardIdleTimeoutEnvVar, "10m")
+	os.Setenv(MaxActiveShardsEnvVar, "16")
+	idleTimeout, maxActive = idleEvictionFromEnv()
+	gt.Expect(idleTimeout).To(Equal(10 * time.Minute))
+	gt.Expect(maxActive).To(Equal(16))
+
+	os.Setenv(ShardIdleTimeoutEnvVar, "soon")
+	os.Setenv(MaxActiveShardsEnvVar, "-1")
+	idleTimeout, maxActive = idleEvictionFromEnv()
+	gt.Expect(idleTimeout).To(BeZero())
+	gt.Expect(maxActive).To(BeZero())
+}
+
+func TestEvictIdleShards(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	now := time.Now()
+	idle := newEvictionTestShard(t, "idle", now.Add(-time.Hour))
+	active := newEvictionTestShard(t, "active", now)
+	pinned := newEvictionTestShard(t, "pinned", now.Add(-time.Hour))
+	defer active.Stop()
+	defer pinned.Stop()
+
+	busy := newEvictionTestShard(t, "busy", now.Add(-time.Hour))
+	defer busy.Stop()
+	// Without a leader the request stays queued
+	busy.ProposeC() <- &PrepareRequest{TxID: "tx1", ShardID: "busy", WriteSet: map[string][]byte{"k": []byte("v")}}
+	gt.Eventually(busy.busy).Should(BeTrue())
+
+	sm := &ShardManager{
+		shards:      map[string]*ShardLeader{"idle": idle, "active": active, "pinned": pinned, "busy": busy},
+		config:      map[string]ShardConfig{"pinned": {ShardID: "pinned"}},
+		idleTimeout: time.Minute,
+	}
+	sm.evictIdleShards(now)
+
+	gt.Expect(sm.shards).To(HaveLen(3))
+	gt.Expect(sm.shards).NotTo(HaveKey("idle"))
+	gt.Expect(sm.Evictions()).To(Equal(uint64(1)))
+	err := idle.Propose(context.Background(), &PrepareRequest{TxID: "tx2"})
+	gt.Expect(errors.Is(err, ErrStopped)).To(BeTrue())
+
+	_, exists := sm.lookupShard("active")
+	gt.Expect(exists).To(BeTrue())
+	_, exists = sm.lookupShard("idle")
+	gt.Expect(exists).To(BeFalse())
+}
+
+func TestMakeRoomEvictsLeastRecentlyUsedShard(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	now := time.Now()
+	older := newEvictionTestShard(t, "older", now.Add(-2*time.Minute))
+	newer := newEvictionTestShard(t, "newer", now.Add(-time.Minute))
+	defer newer.Stop()
+
+	sm := &ShardManager{
+		shards:          map[string]*ShardLeader{"older": older, "newer": newer},
+		maxActiveShards: 3,
+	}
+	sm.makeRoomLocked()
+	gt.Expect(sm.shards).To(HaveLen(2))
+
+	sm.maxActiveShards = 2
+	sm.makeRoomLocked()
+	gt.Expect(sm.shards).To(HaveLen(1))
+	gt.Expect(sm.shards).To(HaveKey("newer"))
+	gt.Expect(sm.Evictions()).To(Equal(uint64(1)))
+}
+
+func TestPinShard(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	now := time.Now()
+	election := newEvictionTestShard(t, "election", now.Add(-time.Hour))
+	defer election.Stop()
+
+	sm := &ShardManager{
+		shards:       map[string]*ShardLeader{"election": election},
+		pinnedShards: make(map[string]bool),
+		topology:     &ShardTopology{Contracts: map[string]ReplicaSet{"election": {Replicas: map[uint64]string{1: "peer0:7051", 2: "peer1:7051"}}}},
+		idleTimeout:  time.Minute,
+	}
+	shard, err := sm.PinShard("election")
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(shard).To(BeIdenticalTo(election))
+
+	// pinned shards stay up however long they are idle
+	sm.evictIdleShards(now)
+	gt.Expect(sm.shards).To(HaveKey("election"))
+
+	gt.Expect(sm.ReplicaAddress("election", 2)).To(Equal("peer1:7051"))
+	gt.Expect(sm.ReplicaAddress("election", 3)).To(BeEmpty())
+}
@@ -115,6 +115,7 @@ func RunExperiment(t *testing.T, config ExperimentConfig) {
 	time.Sleep(2 * time.Second)
 
 	// Run workload
+	allCommits := make(chan *sharding.PrepareProof, config.TxCount)
 	var wg sync.WaitGroup
 	startTime := time.Now()
 	successCount := 0
@@ -157,31 +158,22 @@ func RunExperiment(t *testing.T, config ExperimentConfig) {
 					TxID:      fmt.Sprintf("tx-%d-%d", f, i),
 					ShardID:   "experiment-shard",
 					WriteSet:  map[string][]byte{key: []byte("value")},
-					Timestamp: time.Now(),
+					Timestamp: sharding.Clock().Now(),
 				}
 
-				// Send to node
-				// Note: ProposeC might block if full, so we select
-				select {
-				case node.ProposeC() <- req:
-					// Request sent
-				case <-time.After(500 * time.Millisecond):
-					// Send timeout
+				// Send to node and wait for this transaction's own proof
+				ctx, cancel := context.WithTimeout(context.Background(), config.Duration)
+				proof, err := node.ProposeAndWait(ctx, req)
+				cancel()
+				if err == nil {
+					allCommits <- proof
 				}
 			}
 		}()
 	}
 
-	// Monitor Commit Channels
+	// Collect proofs
 	committedTxs := make(map[string]bool)
-	allCommits := make(chan *sharding.PrepareProof, 10000)
-	for _, node := range nodes {
-		go func(c <-chan *sharding.PrepareProof) {
-			for proof := range c {
-				allCommits <- proof
-			}
-		}(node.CommitC())
-	}
 
 	// Run for duration or until done
 	timeout := time.After(config.Duration)
@@ -0,0 +1,149 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync/atomic"
+
+	"go.etcd.io/etcd/raft/v3/raftpb"
+)
+
+// ExpireEntry removes the reservation of Key regardless of its expiry time,
+// as requested by an operator. ID tags the entry like a conf change so that
+// only the proposing replica is notified when it applies.
+type ExpireEntry struct {
+	ID  uint64
+	Key string
+}
+
+// Dependencies returns a copy of the reservations currently held by the shard
+func (sl *ShardLeader) Dependencies() map[string]TransactionDependencyInfo {
+	sl.variableMapLock.RLock()
+	defer sl.variableMapLock.RUnlock()
+
+	deps := make(map[string]TransactionDependencyInfo)
+	sl.variableMap.Range(func(key string, info TransactionDependencyInfo) {
+		deps[key] = info
+	})
+	return deps
+}
+
+// InFlightTxIDs returns the sorted IDs of the transactions proposed on this
+// replica whose proof has not been applied yet
+func (sl *ShardLeader) InFlightTxIDs() []string {
+	sl.batchLock.Lock()
+	defer sl.batchLock.Unlock()
+
+	txIDs := make([]string, 0, len(sl.pendingTxIDs))
+	for txID := range sl.pendingTxIDs {
+		txIDs = append(txIDs, txID)
+	}
+	sort.Strings(txIDs)
+	return txIDs
+}
+
+// ExpireDependency replicates the removal of the reservation of key and waits
+// until it is applied. It reports whether the key was reserved.
+func (sl *ShardLeader) ExpireDependency(ctx context.Context, key string) (bool, error) {
+	expire := &ExpireEntry{
+		ID:  sl.config.ReplicaID<<32 | atomic.AddUint64(&sl.expireSeq, 1),
+		Key: key,
+	}
+	data, err := (&PrepareRequestBatch{Expire: expire}).Marshal()
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal expiry of key %s: %v", key, err)
+	}
+
+	removedC := make(chan bool, 1)
+	sl.mu.Lock()
+	sl.expireWaiters[expire.ID] = removedC
+	sl.mu.Unlock()
+	defer func() {
+		sl.mu.Lock()
+		delete(sl.expireWaiters, expire.ID)
+		sl.mu.Unlock()
+	}()
+
+	if err := sl.node.Propose(ctx, data); err != nil {
+		return false, fmt.Errorf("failed to propose expiry of key %s to shard %s: %v", key, sl.shardID, err)
+	}
+
+	select {
+	case removed := <-removedC:
+		return removed, nil
+	case <-sl.stopC:
+		return false, fmt.Errorf("shard %s: %w", sl.shardID, ErrStopped)
+	case <-ctx.Done():
+		return false, fmt.Errorf("timeout waiting for expiry of key %s on shard %s", key, sl.shardID)
+	}
+}
+
+// applyExpire removes the reservation named by the entry. It must only be
+// called from applyEntry.
+func (sl *ShardLeader) applyExpire(expire *ExpireEntry, entry raftpb.Entry) {
+	sl.variableMapLock.Lock()
+	info, removed := sl.variableMap.Get(expire.Key)
+	if removed {
+		sl.variableMap.Delete(expire.Key)
+	}
+	sl.variableMapLock.Unlock()
+
+	if removed {
+		atomic.AddUint64(&sl.expiredDependencies, 1)
+		logger.Infof("Shard %s: Force-expired dependency on key %s at index %d", sl.shardID, expire.Key, entry.Index)
+		sl.notifyExpired(map[string]TransactionDependencyInfo{expire.Key: info}, true)
+	}
+
+	sl.mu.Lock()
+	removedC := sl.expireWaiters[expire.ID]
+	delete(sl.expireWaiters, expire.ID)
+	sl.mu.Unlock()
+	if removedC != nil {
+		removedC <- removed
+	}
+}
+
+// GetDependencies returns the reservations held by every shard running on
+// this peer
+func (sm *ShardManager) GetDependencies() map[string]map[string]TransactionDependencyInfo {
+	sm.shardsLock.RLock()
+	defer sm.shardsLock.RUnlock()
+
+	deps := make(map[string]map[string]TransactionDependencyInfo)
+	for shardID, shard := range sm.shards {
+		deps[shardID] = shard.Dependencies()
+	}
+
+	return deps
+}
+
+// GetInFlightTxIDs returns the transactions proposed on every shard running
+// on this peer that are still waiting for their proof
+func (sm *ShardManager) GetInFlightTxIDs() map[string][]string {
+	sm.shardsLock.RLock()
+	defer sm.shardsLock.RUnlock()
+
+	inFlight := make(map[string][]string)
+	for shardID, shard := range sm.shards {
+		inFlight[shardID] = shard.InFlightTxIDs()
+	}
+
+	return inFlight
+}
+
+// ExpireDependency force-expires the reservation of key on a shard running on
+// this peer and reports whether the key was reserved
+func (sm *ShardManager) ExpireDependency(ctx context.Context, shardID, key string) (bool, error) {
+	shard, exists := sm.lookupShard(shardID)
+	if !exists {
+		return false, fmt.Errorf("shard %s is not running on this peer", shardID)
+	}
+	return shard.ExpireDependency(ctx, key)
+}
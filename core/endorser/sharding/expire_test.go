@@ -0,0 +1,55 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestShardLeaderExpireDependency(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	sl, err := NewShardLeader(ShardConfig{
+		ShardID:    "fabcar",
+		ReplicaIDs: []uint64{1},
+		ReplicaID:  1,
+	}, DefaultBatchTimeout, DefaultBatchMaxSize)
+	gt.Expect(err).NotTo(HaveOccurred())
+	defer sl.Stop()
+
+	gt.Eventually(func() bool { return sl.GetStatus().IsLeader }, 30*time.Second, 100*time.Millisecond).Should(BeTrue())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = sl.ProposeAndWait(ctx, &PrepareRequest{
+		TxID:      "tx1",
+		ShardID:   "fabcar",
+		WriteSet:  map[string][]byte{"car1": []byte("v1"), "car2": []byte("v2")},
+		Timestamp: Clock().Now(),
+	})
+	gt.Expect(err).NotTo(HaveOccurred())
+
+	deps := sl.Dependencies()
+	gt.Expect(deps).To(HaveLen(2))
+	gt.Expect(deps["car1"].DependentTxID).To(Equal("tx1"))
+	gt.Expect(sl.InFlightTxIDs()).To(BeEmpty())
+
+	removed, err := sl.ExpireDependency(ctx, "car1")
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(removed).To(BeTrue())
+	gt.Expect(sl.Dependencies()).To(HaveKey("car2"))
+	gt.Expect(sl.Dependencies()).NotTo(HaveKey("car1"))
+	gt.Expect(sl.GetStatus().ExpiredDependencies).To(Equal(uint64(1)))
+
+	removed, err = sl.ExpireDependency(ctx, "car1")
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(removed).To(BeFalse())
+}
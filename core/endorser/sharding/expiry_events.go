@@ -0,0 +1,90 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"sort"
+	"time"
+)
+
+// ExpiredEndorsement reports that a shard dropped the reservations of a
+// transaction before it committed. The proofs endorsed for the transaction
+// no longer hold, so its client should endorse it again rather than submit it
+// for ordering.
+type ExpiredEndorsement struct {
+	ShardID string
+	TxID    string
+	// Keys lists the sorted keys whose reservations were dropped
+	Keys []string
+	// ExpiryTime is the latest expiry time of the reservations
+	ExpiryTime time.Time
+	// Forced is true when an operator expired a key before its time
+	Forced bool
+}
+
+// ExpiryObserver is notified of the endorsements that expired on the shards
+// hosted by a ShardManager. Notifications are delivered like those of a
+// ShardObserver, in order on a goroutine of the manager.
+type ExpiryObserver interface {
+	OnEndorsementExpired(expired ExpiredEndorsement)
+}
+
+// AddExpiryObserver registers an observer of expired endorsements. Each
+// replica hosted by this peer reports the endorsements it drops, so the
+// observer only learns about the shards that this peer replicates.
+func (sm *ShardManager) AddExpiryObserver(observer ExpiryObserver) {
+	sm.events.mu.Lock()
+	defer sm.events.mu.Unlock()
+	sm.events.expiryObservers = append(sm.events.expiryObservers, observer)
+}
+
+func (e *shardEvents) endorsementExpired(expired ExpiredEndorsement) {
+	e.publish(shardEvent{kind: endorsementExpired, shardID: expired.ShardID, expired: &expired})
+}
+
+// setExpiryObserver registers the function the GC and force-expiries call
+// with the endorsements they dropped. It must not block.
+func (sl *ShardLeader) setExpiryObserver(observer func(ExpiredEndorsement)) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	sl.expiryObserver = observer
+}
+
+// notifyExpired groups the dropped reservations by transaction and reports
+// them to the expiry observer
+func (sl *ShardLeader) notifyExpired(dropped map[string]TransactionDependencyInfo, forced bool) {
+	sl.mu.RLock()
+	observer := sl.expiryObserver
+	sl.mu.RUnlock()
+	if observer == nil || len(dropped) == 0 {
+		return
+	}
+
+	byTxID := make(map[string]*ExpiredEndorsement)
+	for key, info := range dropped {
+		expired, ok := byTxID[info.DependentTxID]
+		if !ok {
+			expired = &ExpiredEndorsement{ShardID: sl.shardID, TxID: info.DependentTxID, Forced: forced}
+			byTxID[info.DependentTxID] = expired
+		}
+		expired.Keys = append(expired.Keys, key)
+		if info.ExpiryTime.After(expired.ExpiryTime) {
+			expired.ExpiryTime = info.ExpiryTime
+		}
+	}
+
+	txIDs := make([]string, 0, len(byTxID))
+	for txID := range byTxID {
+		txIDs = append(txIDs, txID)
+	}
+	sort.Strings(txIDs)
+	for _, txID := range txIDs {
+		expired := byTxID[txID]
+		sort.Strings(expired.Keys)
+		observer(*expired)
+	}
+}
@@ -0,0 +1,59 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"go.etcd.io/etcd/raft/v3/raftpb"
+)
+
+type expiryRecorder struct {
+	mu      sync.Mutex
+	expired []ExpiredEndorsement
+}
+
+func (r *expiryRecorder) OnEndorsementExpired(expired ExpiredEndorsement) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.expired = append(r.expired, expired)
+}
+
+func TestExpiredEndorsementEvents(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	now := time.Unix(0, 1000)
+	table := newMemoryDependencyTable()
+	gt.Expect(table.Reset(map[string]TransactionDependencyInfo{
+		"car2":    {DependentTxID: "tx1", ExpiryTime: now.Add(-time.Second)},
+		"car1":    {DependentTxID: "tx1", ExpiryTime: now.Add(-2 * time.Second)},
+		"car3":    {DependentTxID: "tx2", ExpiryTime: now},
+		"live":    {DependentTxID: "tx3", ExpiryTime: now.Add(time.Second)},
+		"forever": {DependentTxID: "tx4"},
+	}, nil, 0)).To(Succeed())
+	sl := &ShardLeader{shardID: "fabcar", variableMap: table}
+
+	recorder := &expiryRecorder{}
+	sm := &ShardManager{events: newShardEvents()}
+	sl.setExpiryObserver(sm.events.endorsementExpired)
+	sm.AddExpiryObserver(recorder)
+
+	sl.applyGC(&GCEntry{Now: now.UnixNano()}, raftpb.Entry{Index: 7})
+	sl.applyExpire(&ExpireEntry{ID: 1, Key: "live"}, raftpb.Entry{Index: 8})
+	sl.applyExpire(&ExpireEntry{ID: 2, Key: "unknown"}, raftpb.Entry{Index: 9})
+	// Closing delivers the queued events
+	sm.events.close()
+
+	gt.Expect(recorder.expired).To(Equal([]ExpiredEndorsement{
+		{ShardID: "fabcar", TxID: "tx1", Keys: []string{"car1", "car2"}, ExpiryTime: now.Add(-time.Second)},
+		{ShardID: "fabcar", TxID: "tx2", Keys: []string{"car3"}, ExpiryTime: now},
+		{ShardID: "fabcar", TxID: "tx3", Keys: []string{"live"}, ExpiryTime: now.Add(time.Second), Forced: true},
+	}))
+}
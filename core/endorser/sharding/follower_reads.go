@@ -0,0 +1,114 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"go.etcd.io/etcd/raft/v3"
+	"go.etcd.io/etcd/raft/v3/raftpb"
+)
+
+// StalenessBound limits how far a replica serving a dependency read may lag
+// behind the leader. A zero field leaves that dimension unbounded.
+type StalenessBound struct {
+	// MaxLagEntries is the maximum number of committed but unapplied entries
+	MaxLagEntries uint64
+	// MaxLagTime is the maximum time since the replica last heard from the leader
+	MaxLagTime time.Duration
+}
+
+// DependencyRead is the answer to a dependency query along with the lag of
+// the replica that served it
+type DependencyRead struct {
+	ShardID      string
+	Key          string
+	Found        bool
+	Info         TransactionDependencyInfo
+	AppliedIndex uint64
+	LagEntries   uint64
+	LagTime      time.Duration
+	IsLeader     bool
+	// Gossiped is set when the read was served from the updates gossiped by
+	// the leader of a shard this peer does not replicate. AppliedIndex is
+	// then the index of the last update received, and LagTime the time since.
+	Gossiped bool `json:",omitempty"`
+}
+
+// StaleReadError is returned when the replica lags beyond the requested bound.
+// Callers are expected to retry against the leader or another replica.
+type StaleReadError struct {
+	ShardID    string
+	LagEntries uint64
+	LagTime    time.Duration
+	Bound      StalenessBound
+}
+
+func (e *StaleReadError) Error() string {
+	return fmt.Sprintf("shard %s replica is too stale: lag %d entries/%s exceeds bound %d entries/%s",
+		e.ShardID, e.LagEntries, e.LagTime, e.Bound.MaxLagEntries, e.Bound.MaxLagTime)
+}
+
+// recordLeaderContact notes the arrival of a message that proves the leader
+// was alive and replicating to this replica
+func (sl *ShardLeader) recordLeaderContact(msg raftpb.Message) {
+	switch msg.Type {
+	case raftpb.MsgHeartbeat, raftpb.MsgApp, raftpb.MsgSnap:
+		atomic.StoreInt64(&sl.lastLeaderContact, time.Now().UnixNano())
+	}
+}
+
+// GetDependency returns the dependency info recorded for key, served from this
+// replica's applied state as long as its lag is within the bound
+func (sl *ShardLeader) GetDependency(key string, bound StalenessBound) (*DependencyRead, error) {
+	status := sl.node.Status()
+	applied := atomic.LoadUint64(&sl.appliedIndex)
+
+	read := &DependencyRead{
+		ShardID:      sl.shardID,
+		Key:          key,
+		AppliedIndex: applied,
+		IsLeader:     status.RaftState == raft.StateLeader,
+	}
+	if status.Commit > applied {
+		read.LagEntries = status.Commit - applied
+	}
+	if !read.IsLeader {
+		if lastContact := atomic.LoadInt64(&sl.lastLeaderContact); lastContact != 0 {
+			read.LagTime = time.Since(time.Unix(0, lastContact))
+		} else if bound.MaxLagTime > 0 {
+			// never heard from a leader, so the lag is unknown
+			return nil, &StaleReadError{ShardID: sl.shardID, LagEntries: read.LagEntries, LagTime: -1, Bound: bound}
+		}
+	}
+
+	if (bound.MaxLagEntries > 0 && read.LagEntries > bound.MaxLagEntries) ||
+		(bound.MaxLagTime > 0 && read.LagTime > bound.MaxLagTime) {
+		return nil, &StaleReadError{ShardID: sl.shardID, LagEntries: read.LagEntries, LagTime: read.LagTime, Bound: bound}
+	}
+
+	read.Info, read.Found = sl.reservation(key)
+
+	return read, nil
+}
+
+// GetDependency serves a bounded-staleness dependency read from the local
+// replica of the shard, or from the updates gossiped by its leader when this
+// peer does not replicate it
+func (sm *ShardManager) GetDependency(shardID, key string, bound StalenessBound) (*DependencyRead, error) {
+	shard, exists := sm.lookupShard(shardID)
+
+	if !exists {
+		if sm.gossip != nil {
+			return sm.gossip.view.Get(shardID, key, bound)
+		}
+		return nil, fmt.Errorf("shard %s is not hosted on this peer", shardID)
+	}
+	return shard.GetDependency(key, bound)
+}
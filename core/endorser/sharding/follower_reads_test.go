@@ -0,0 +1,81 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"go.etcd.io/etcd/raft/v3"
+	"go.etcd.io/etcd/raft/v3/raftpb"
+)
+
+// statusNode is a raft.Node that only reports a fixed status
+type statusNode struct {
+	raft.Node
+	status raft.Status
+}
+
+func (n *statusNode) Status() raft.Status {
+	return n.status
+}
+
+func newFollowerReadTestLeader(state raft.StateType, commit, applied uint64) *ShardLeader {
+	status := raft.Status{}
+	status.RaftState = state
+	status.Commit = commit
+
+	sl := &ShardLeader{
+		shardID:      "testContract",
+		node:         &statusNode{status: status},
+		variableMap:  newMemoryDependencyTable(),
+		appliedIndex: applied,
+	}
+	sl.variableMap.Put("k1", TransactionDependencyInfo{DependentTxID: "tx1"})
+	return sl
+}
+
+func TestGetDependencyOnLeader(t *testing.T) {
+	gt := NewGomegaWithT(t)
+	sl := newFollowerReadTestLeader(raft.StateLeader, 10, 10)
+
+	read, err := sl.GetDependency("k1", StalenessBound{MaxLagEntries: 1, MaxLagTime: time.Millisecond})
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(read.IsLeader).To(BeTrue())
+	gt.Expect(read.Found).To(BeTrue())
+	gt.Expect(read.Info.DependentTxID).To(Equal("tx1"))
+	gt.Expect(read.LagEntries).To(BeZero())
+	gt.Expect(read.LagTime).To(BeZero())
+}
+
+func TestGetDependencyOnFollower(t *testing.T) {
+	gt := NewGomegaWithT(t)
+	sl := newFollowerReadTestLeader(raft.StateFollower, 12, 10)
+
+	// the lag in time is unknown until the leader has been heard from
+	_, err := sl.GetDependency("k1", StalenessBound{MaxLagTime: time.Minute})
+	gt.Expect(err).To(BeAssignableToTypeOf(&StaleReadError{}))
+
+	sl.recordLeaderContact(raftpb.Message{Type: raftpb.MsgVote})
+	gt.Expect(sl.lastLeaderContact).To(BeZero())
+	sl.recordLeaderContact(raftpb.Message{Type: raftpb.MsgHeartbeat})
+
+	read, err := sl.GetDependency("k2", StalenessBound{MaxLagEntries: 2, MaxLagTime: time.Minute})
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(read.Found).To(BeFalse())
+	gt.Expect(read.LagEntries).To(Equal(uint64(2)))
+	gt.Expect(read.AppliedIndex).To(Equal(uint64(10)))
+
+	_, err = sl.GetDependency("k1", StalenessBound{MaxLagEntries: 1})
+	gt.Expect(err).To(MatchError(ContainSubstring("lag 2 entries")))
+
+	// an unbounded read always succeeds
+	read, err = sl.GetDependency("k1", StalenessBound{})
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(read.Found).To(BeTrue())
+}
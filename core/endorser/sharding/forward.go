@@ -0,0 +1,138 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.etcd.io/etcd/raft/v3"
+)
+
+// forwardTimeout bounds how long a follower waits for the leader to commit a
+// forwarded batch before it queues the batch again
+const forwardTimeout = 10 * time.Second
+
+// proposalForwarder sends batches proposed on a follower to the shard leader
+// and returns the proofs of their transactions
+type proposalForwarder interface {
+	ForwardBatch(ctx context.Context, shardID string, leaderID uint64, batch []byte) ([]*PrepareProof, error)
+}
+
+// setForwarder registers the forwarder used while this replica is a follower
+func (sl *ShardLeader) setForwarder(forwarder proposalForwarder) {
+	sl.mu.Lock()
+	sl.forwarder = forwarder
+	sl.mu.Unlock()
+}
+
+// proposeBatch proposes the batch when this replica leads the shard and
+// forwards it to the leader otherwise. Without a known leader the batch is
+// queued again rather than dropped by raft.
+func (sl *ShardLeader) proposeBatch(batch []*PrepareRequest, data []byte) {
+	status := sl.node.Status()
+	if status.RaftState == raft.StateLeader {
+		if err := sl.node.Propose(context.TODO(), data); err != nil {
+			logger.Errorf("Failed to propose batch for shard %s: %v", sl.shardID, err)
+		}
+		return
+	}
+
+	if status.Lead == raft.None {
+		// Leadership was lost since the batch was taken from the queue
+		logger.Debugf("Shard %s: No leader known, holding batch of %d requests", sl.shardID, len(batch))
+		sl.requeue(batch)
+		return
+	}
+
+	sl.mu.RLock()
+	forwarder := sl.forwarder
+	sl.mu.RUnlock()
+	if forwarder == nil {
+		// Let raft forward the proposal to the leader itself
+		if err := sl.node.Propose(context.TODO(), data); err != nil {
+			logger.Errorf("Failed to propose batch for shard %s: %v", sl.shardID, err)
+		}
+		return
+	}
+
+	go sl.forwardBatch(forwarder, status.Lead, batch, data)
+}
+
+// forwardBatch sends the batch to the leader and delivers the returned proofs
+// to local subscribers. The batch is queued again if the leader fails to
+// commit it, e.g. because leadership changed meanwhile.
+func (sl *ShardLeader) forwardBatch(forwarder proposalForwarder, leaderID uint64, batch []*PrepareRequest, data []byte) {
+	ctx, cancel := context.WithTimeout(context.Background(), forwardTimeout)
+	defer cancel()
+
+	proofs, err := forwarder.ForwardBatch(ctx, sl.shardID, leaderID, data)
+	if err != nil {
+		logger.Warnf("Shard %s: Failed to forward batch of %d requests to leader %d, requeueing: %v",
+			sl.shardID, len(batch), leaderID, err)
+		sl.requeue(batch)
+		return
+	}
+
+	for _, proof := range proofs {
+		sl.deliverProof(proof)
+	}
+	logger.Debugf("Shard %s: Leader %d committed forwarded batch of %d requests", sl.shardID, leaderID, len(batch))
+}
+
+// requeue puts the requests of a batch that have no proof yet back at the
+// front of the queue, to be proposed with the next flush
+func (sl *ShardLeader) requeue(batch []*PrepareRequest) {
+	pending := make([]*PrepareRequest, 0, len(batch))
+	for _, req := range batch {
+		if !sl.HasProof(req.TxID) {
+			pending = append(pending, req)
+		}
+	}
+
+	sl.batchLock.Lock()
+	sl.batchQueue = append(pending, sl.batchQueue...)
+	sl.batchLock.Unlock()
+}
+
+// proposeForwarded proposes a batch forwarded by a follower and waits for the
+// proofs of all its transactions
+func (sl *ShardLeader) proposeForwarded(ctx context.Context, data []byte) ([]*PrepareProof, error) {
+	if sl.node.Status().RaftState != raft.StateLeader {
+		return nil, fmt.Errorf("replica %d is not the leader of shard %s", sl.config.ReplicaID, sl.shardID)
+	}
+
+	batch := &PrepareRequestBatch{}
+	if err := batch.Unmarshal(data); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal forwarded batch: %v", err)
+	}
+
+	// Subscribe first so no proof is broadcast before we listen
+	commitCs := make([]<-chan *PrepareProof, len(batch.Requests))
+	for i, req := range batch.Requests {
+		commitCs[i] = sl.Subscribe(req.TxID)
+		defer sl.Unsubscribe(req.TxID, commitCs[i])
+	}
+
+	if err := sl.node.Propose(ctx, data); err != nil {
+		return nil, fmt.Errorf("failed to propose forwarded batch to shard %s: %v", sl.shardID, err)
+	}
+
+	proofs := make([]*PrepareProof, 0, len(commitCs))
+	for _, commitC := range commitCs {
+		select {
+		case proof := <-commitC:
+			proofs = append(proofs, proof)
+		case <-sl.stopC:
+			return nil, fmt.Errorf("shard %s is stopped", sl.shardID)
+		case <-ctx.Done():
+			return nil, fmt.Errorf("timeout waiting for forwarded batch to commit on shard %s", sl.shardID)
+		}
+	}
+	return proofs, nil
+}
@@ -0,0 +1,96 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+type countingForwarder struct {
+	proposalForwarder
+	calls int32
+}
+
+func (f *countingForwarder) ForwardBatch(ctx context.Context, shardID string, leaderID uint64, batch []byte) ([]*PrepareProof, error) {
+	atomic.AddInt32(&f.calls, 1)
+	return f.proposalForwarder.ForwardBatch(ctx, shardID, leaderID, batch)
+}
+
+func TestShardHoldsBatchWithoutLeader(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	// Replica 1 never starts, so replica 2 can never learn of a leader
+	sl, err := NewShardLeader(ShardConfig{
+		ShardID:    "fabcar",
+		ReplicaIDs: []uint64{1, 2},
+		ReplicaID:  2,
+	}, DefaultBatchTimeout, DefaultBatchMaxSize)
+	gt.Expect(err).NotTo(HaveOccurred())
+	defer sl.Stop()
+
+	sl.ProposeC() <- &PrepareRequest{TxID: "tx1", ShardID: "fabcar", WriteSet: map[string][]byte{"car1": []byte("v1")}}
+	gt.Eventually(func() int { return sl.GetStatus().QueueDepth }).Should(Equal(1))
+	gt.Consistently(func() int { return sl.GetStatus().QueueDepth }, time.Second, 50*time.Millisecond).Should(Equal(1))
+}
+
+func TestShardForwardsProposalToLeader(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	peers := PeerConfig{1: freeTransportAddress(t), 2: freeTransportAddress(t)}
+	shards := make(map[uint64]*ShardLeader)
+	for id := range peers {
+		transport := NewTransport(id, peers[id], peers)
+		gt.Expect(transport.Start()).To(Succeed())
+		defer transport.Stop()
+
+		sl, err := NewShardLeader(ShardConfig{
+			ShardID:    "fabcar",
+			ReplicaIDs: []uint64{1, 2},
+			ReplicaID:  id,
+		}, DefaultBatchTimeout, DefaultBatchMaxSize)
+		gt.Expect(err).NotTo(HaveOccurred())
+		defer sl.Stop()
+		transport.RegisterShard("fabcar", sl)
+		shards[id] = sl
+	}
+
+	// Replicas campaign only after their election timeout
+	var leaderID uint64
+	gt.Eventually(func() uint64 {
+		leaderID = shards[1].GetStatus().LeaderID
+		return leaderID
+	}, 30*time.Second, 100*time.Millisecond).ShouldNot(BeZero())
+	follower := shards[3-leaderID]
+	gt.Eventually(func() uint64 { return follower.GetStatus().LeaderID }, 5*time.Second).Should(Equal(leaderID))
+
+	follower.mu.RLock()
+	forwarder := &countingForwarder{proposalForwarder: follower.forwarder}
+	follower.mu.RUnlock()
+	follower.setForwarder(forwarder)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	proof, err := follower.ProposeAndWait(ctx, &PrepareRequest{
+		TxID:     "tx1",
+		ShardID:  "fabcar",
+		WriteSet: map[string][]byte{"car1": []byte("v1")},
+	})
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(proof.TxID).To(Equal("tx1"))
+	gt.Expect(proof.LeaderID).To(Equal(leaderID))
+	gt.Expect(VerifyPrepareProof(proof)).To(Succeed())
+	gt.Expect(atomic.LoadInt32(&forwarder.calls)).To(BeNumerically(">=", 1))
+
+	// A replica that does not lead the shard refuses forwarded batches
+	_, err = follower.proposeForwarded(ctx, []byte("{}"))
+	gt.Expect(err).To(MatchError(ContainSubstring("is not the leader of shard fabcar")))
+}
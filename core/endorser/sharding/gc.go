@@ -0,0 +1,97 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"go.etcd.io/etcd/raft/v3"
+	"go.etcd.io/etcd/raft/v3/raftpb"
+)
+
+// runDependencyGC periodically has the leader replicate a GC entry, so that
+// every replica prunes the same expired dependencies at the same log index
+func (sl *ShardLeader) runDependencyGC() {
+	ticker := time.NewTicker(sl.gcInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			sl.proposeGC(time.Now())
+		case <-sl.stopC:
+			return
+		}
+	}
+}
+
+// proposeGC proposes a GC entry on the leader if any dependency expired by
+// now. Every replica counts its reservations on the way.
+func (sl *ShardLeader) proposeGC(now time.Time) {
+	expired := sl.hasExpiredDependencies(now)
+	if sl.node.Status().RaftState != raft.StateLeader || !expired {
+		return
+	}
+
+	data, err := (&PrepareRequestBatch{GC: &GCEntry{Now: now.UnixNano()}}).Marshal()
+	if err != nil {
+		logger.Errorf("Shard %s: Failed to marshal GC entry: %v", sl.shardID, err)
+		return
+	}
+	if err := sl.node.Propose(context.TODO(), data); err != nil {
+		logger.Warnf("Shard %s: Failed to propose GC entry: %v", sl.shardID, err)
+	}
+}
+
+// hasExpiredDependencies reports whether any dependency expired by now, and
+// records the number of reservations for GetStatus, which must not wait for
+// the state machine
+func (sl *ShardLeader) hasExpiredDependencies(now time.Time) bool {
+	sl.variableMapLock.RLock()
+	defer sl.variableMapLock.RUnlock()
+	found := false
+	count := 0
+	sl.variableMap.Range(func(_ string, info TransactionDependencyInfo) {
+		found = found || expired(info, now)
+		count++
+	})
+	atomic.StoreInt64(&sl.dependencies, int64(count))
+	return found
+}
+
+// applyGC removes the dependencies that expired by the time carried in the
+// entry. It must only be called from applyEntry.
+func (sl *ShardLeader) applyGC(gc *GCEntry, entry raftpb.Entry) {
+	now := time.Unix(0, gc.Now)
+
+	removed := make(map[string]TransactionDependencyInfo)
+	sl.variableMapLock.Lock()
+	sl.variableMap.Range(func(key string, info TransactionDependencyInfo) {
+		if expired(info, now) {
+			sl.variableMap.Delete(key)
+			removed[key] = info
+		}
+	})
+	sl.variableMapLock.Unlock()
+	prunedRanges := sl.pruneRanges(now)
+
+	atomic.AddUint64(&sl.expiredDependencies, uint64(len(removed)))
+	logger.Debugf("Shard %s: GC at index %d removed %d expired dependencies and %d expired ranges", sl.shardID, entry.Index, len(removed), prunedRanges)
+	sl.notifyExpired(removed, false)
+	sl.pruneDecisions(now)
+}
+
+// ExpiredDependencies returns the number of dependencies pruned by GC
+func (sl *ShardLeader) ExpiredDependencies() uint64 {
+	return atomic.LoadUint64(&sl.expiredDependencies)
+}
+
+func expired(info TransactionDependencyInfo, now time.Time) bool {
+	return !info.ExpiryTime.IsZero() && !info.ExpiryTime.After(now)
+}
@@ -0,0 +1,79 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"go.etcd.io/etcd/raft/v3/raftpb"
+)
+
+func TestApplyGCRemovesExpiredDependencies(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	now := time.Unix(0, 1000)
+	table := newMemoryDependencyTable()
+	gt.Expect(table.Reset(map[string]TransactionDependencyInfo{
+		"expired":  {DependentTxID: "tx1", ExpiryTime: now.Add(-time.Second)},
+		"boundary": {DependentTxID: "tx2", ExpiryTime: now},
+		"live":     {DependentTxID: "tx3", ExpiryTime: now.Add(time.Second)},
+		"forever":  {DependentTxID: "tx4"},
+	}, nil, 0)).To(Succeed())
+	sl := &ShardLeader{shardID: "fabcar", variableMap: table}
+
+	gt.Expect(sl.hasExpiredDependencies(now)).To(BeTrue())
+	gt.Expect(sl.dependencies).To(BeEquivalentTo(4))
+	sl.applyGC(&GCEntry{Now: now.UnixNano()}, raftpb.Entry{Index: 7})
+
+	entries := sl.Dependencies()
+	gt.Expect(entries).To(HaveLen(2))
+	gt.Expect(entries).To(HaveKey("live"))
+	gt.Expect(entries).To(HaveKey("forever"))
+	gt.Expect(sl.ExpiredDependencies()).To(Equal(uint64(2)))
+	gt.Expect(sl.hasExpiredDependencies(now)).To(BeFalse())
+	gt.Expect(sl.dependencies).To(BeEquivalentTo(2))
+}
+
+func TestShardLeaderGarbageCollectsExpiredDependencies(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	sl, err := NewShardLeader(ShardConfig{
+		ShardID:       "fabcar",
+		ReplicaIDs:    []uint64{1},
+		ReplicaID:     1,
+		DependencyTTL: 500 * time.Millisecond,
+		GCInterval:    100 * time.Millisecond,
+	}, DefaultBatchTimeout, DefaultBatchMaxSize)
+	gt.Expect(err).NotTo(HaveOccurred())
+	defer sl.Stop()
+
+	gt.Eventually(func() bool { return sl.GetStatus().IsLeader }, 30*time.Second, 100*time.Millisecond).Should(BeTrue())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = sl.ProposeAndWait(ctx, &PrepareRequest{
+		TxID:      "tx1",
+		ShardID:   "fabcar",
+		WriteSet:  map[string][]byte{"car1": []byte("v1")},
+		Timestamp: Clock().Now(),
+	})
+	gt.Expect(err).NotTo(HaveOccurred())
+
+	hasKey := func() bool {
+		sl.variableMapLock.RLock()
+		defer sl.variableMapLock.RUnlock()
+		_, ok := sl.variableMap.Get("car1")
+		return ok
+	}
+	gt.Expect(hasKey()).To(BeTrue())
+
+	gt.Eventually(hasKey, 5*time.Second, 50*time.Millisecond).Should(BeFalse())
+	gt.Expect(sl.GetStatus().ExpiredDependencies).To(Equal(uint64(1)))
+}
@@ -0,0 +1,115 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/endorser/sharding/protos"
+	"google.golang.org/grpc"
+)
+
+// GRPCDependencyStore prepares transactions on an external conflict service
+// through its ConflictService gRPC API, so that one service orders the
+// transactions of every endorser using it
+type GRPCDependencyStore struct {
+	conn   *grpc.ClientConn
+	client protos.ConflictServiceClient
+}
+
+// NewGRPCDependencyStore connects to the conflict service at address. The
+// connection is plaintext when tlsConfig is nil.
+func NewGRPCDependencyStore(address string, tlsConfig *TransportTLS) (*GRPCDependencyStore, error) {
+	conn, err := grpc.Dial(address, grpc.WithTransportCredentials(tlsConfig.clientCredentials(0)))
+	if err != nil {
+		return nil, err
+	}
+	logger.Infof("Using the conflict service at %s as dependency store", address)
+	return &GRPCDependencyStore{
+		conn:   conn,
+		client: protos.NewConflictServiceClient(conn),
+	}, nil
+}
+
+// Prepare implements DependencyStore
+func (s *GRPCDependencyStore) Prepare(ctx context.Context, req *PrepareRequest) (*PrepareProof, error) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal prepare request: %v", err)
+	}
+	resp, err := s.client.Prepare(ctx, &protos.ConflictPrepareRequest{Request: data})
+	if err != nil {
+		return nil, err
+	}
+	if !resp.Success {
+		return nil, fmt.Errorf("conflict service failed to prepare tx %s: %s", req.TxID, resp.Error)
+	}
+	var proof PrepareProof
+	if err := json.Unmarshal(resp.Proof, &proof); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal proof of tx %s: %v", req.TxID, err)
+	}
+	return &proof, nil
+}
+
+// Abort implements DependencyStore
+func (s *GRPCDependencyStore) Abort(shardID, txID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultAbortTimeout)
+	defer cancel()
+
+	resp, err := s.client.Abort(ctx, &protos.ConflictAbortRequest{ShardId: shardID, TxId: txID})
+	if err != nil {
+		return err
+	}
+	if !resp.Success {
+		return fmt.Errorf("conflict service failed to abort tx %s: %s", txID, resp.Error)
+	}
+	return nil
+}
+
+// Close closes the connection to the conflict service
+func (s *GRPCDependencyStore) Close() error {
+	return s.conn.Close()
+}
+
+// ConflictServer serves a dependency store, such as a LocalDependencyStore,
+// as a conflict service for the endorsers to share
+type ConflictServer struct {
+	protos.UnimplementedConflictServiceServer
+	store DependencyStore
+}
+
+// NewConflictServer creates a conflict service tracking dependencies in store
+func NewConflictServer(store DependencyStore) *ConflictServer {
+	return &ConflictServer{store: store}
+}
+
+// Prepare prepares the transaction on the store
+func (c *ConflictServer) Prepare(ctx context.Context, in *protos.ConflictPrepareRequest) (*protos.ConflictPrepareResponse, error) {
+	var req PrepareRequest
+	if err := json.Unmarshal(in.Request, &req); err != nil {
+		return &protos.ConflictPrepareResponse{Error: fmt.Sprintf("failed to unmarshal prepare request: %v", err)}, nil
+	}
+	proof, err := c.store.Prepare(ctx, &req)
+	if err != nil {
+		return &protos.ConflictPrepareResponse{Error: err.Error()}, nil
+	}
+	data, err := json.Marshal(proof)
+	if err != nil {
+		return &protos.ConflictPrepareResponse{Error: fmt.Sprintf("failed to marshal proof: %v", err)}, nil
+	}
+	return &protos.ConflictPrepareResponse{Success: true, Proof: data}, nil
+}
+
+// Abort releases the reservations of the transaction on the store
+func (c *ConflictServer) Abort(ctx context.Context, in *protos.ConflictAbortRequest) (*protos.ConflictAbortResponse, error) {
+	if err := c.store.Abort(in.ShardId, in.TxId); err != nil {
+		return &protos.ConflictAbortResponse{Error: err.Error()}, nil
+	}
+	return &protos.ConflictAbortResponse{Success: true}, nil
+}
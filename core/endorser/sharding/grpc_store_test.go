@@ -0,0 +1,83 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/endorser/sharding/protos"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+)
+
+func TestGRPCDependencyStore(t *testing.T) {
+	gt := NewGomegaWithT(t)
+	ctx := context.Background()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	gt.Expect(err).NotTo(HaveOccurred())
+	server := grpc.NewServer()
+	local := NewLocalDependencyStore(time.Minute)
+	protos.RegisterConflictServiceServer(server, NewConflictServer(local))
+	go server.Serve(lis)
+	defer server.Stop()
+
+	store, err := NewDependencyStore(DependencyStoreConfig{Backend: "grpc", Endpoints: []string{lis.Addr().String()}})
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(store).To(BeAssignableToTypeOf(&GRPCDependencyStore{}))
+	defer store.(*GRPCDependencyStore).Close()
+
+	proof, err := store.Prepare(ctx, &PrepareRequest{TxID: "tx1", ShardID: "fabcar", WriteSet: map[string][]byte{"fabcar:car1": []byte("red")}})
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(proof.HasDependency).To(BeFalse())
+	gt.Expect(local.Reservations("fabcar")).To(Equal(1))
+
+	proof, err = store.Prepare(ctx, &PrepareRequest{TxID: "tx2", ShardID: "fabcar", ReadSet: map[string][]byte{"fabcar:car1": nil}})
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(proof.DependentTxID).To(Equal("tx1"))
+	gt.Expect(proof.ConflictTypes).To(Equal(map[string][]ConflictType{"tx1": {ConflictReadWrite}}))
+
+	gt.Expect(store.Abort("fabcar", "tx1")).To(Succeed())
+	gt.Expect(local.Reservations("fabcar")).To(Equal(0))
+
+	// Errors of the service are returned to the endorser
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	resp, err := NewConflictServer(local).Prepare(canceled, &protos.ConflictPrepareRequest{Request: []byte(`{"TxID":"tx3"}`)})
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(resp.Success).To(BeFalse())
+	gt.Expect(resp.Error).To(Equal("context canceled"))
+	resp, err = NewConflictServer(local).Prepare(ctx, &protos.ConflictPrepareRequest{Request: []byte("{")})
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(resp.Success).To(BeFalse())
+	gt.Expect(resp.Error).To(ContainSubstring("failed to unmarshal prepare request"))
+}
+
+func TestNewDependencyStore(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	store, err := NewDependencyStore(DependencyStoreConfig{})
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(store).To(BeNil())
+	store, err = NewDependencyStore(DependencyStoreConfig{Backend: "raft"})
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(store).To(BeNil())
+
+	store, err = NewDependencyStore(DependencyStoreConfig{Backend: "local", TTL: time.Second})
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(store.(*LocalDependencyStore).ttl).To(Equal(time.Second))
+
+	_, err = NewDependencyStore(DependencyStoreConfig{Backend: "etcd"})
+	gt.Expect(err).To(MatchError(ContainSubstring("no etcd endpoints configured")))
+	_, err = NewDependencyStore(DependencyStoreConfig{Backend: "grpc"})
+	gt.Expect(err).To(MatchError("the grpc dependency store needs the address of one conflict service, got 0"))
+	_, err = NewDependencyStore(DependencyStoreConfig{Backend: "zookeeper"})
+	gt.Expect(err).To(MatchError(`unknown dependency store "zookeeper"`))
+}
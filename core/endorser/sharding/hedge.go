@@ -0,0 +1,146 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+// ShardHedgeDelayEnvVar is how long, as a Go duration, a prepare sent to a
+// remote replica may go unanswered before it is sent to another replica of
+// the shard as well. Prepares are not hedged when it is unset.
+const ShardHedgeDelayEnvVar = "FABRIC_SHARD_HEDGE_DELAY"
+
+// HedgeMetrics is implemented by the Metrics of a ShardManager that count
+// hedged prepares
+type HedgeMetrics interface {
+	// PrepareHedged counts a prepare sent to a second replica of the shard
+	PrepareHedged(shardID string)
+	// HedgeWon counts a hedged prepare answered first by the second replica
+	HedgeWon(shardID string)
+}
+
+// hedgeDelayFromEnv returns the delay configured via ShardHedgeDelayEnvVar
+func hedgeDelayFromEnv() time.Duration {
+	spec := os.Getenv(ShardHedgeDelayEnvVar)
+	if spec == "" {
+		return 0
+	}
+	delay, err := time.ParseDuration(spec)
+	if err != nil || delay < 0 {
+		logger.Warningf("Ignoring %s %q: invalid duration", ShardHedgeDelayEnvVar, spec)
+		return 0
+	}
+	return delay
+}
+
+// hedgeTarget returns the replica a prepare sent to primary is hedged to: the
+// lowest other replica of the shard, or "" when prepares are not hedged or the
+// shard has no other replica
+func (sm *ShardManager) hedgeTarget(shardID, primary string) string {
+	if sm.hedgeDelay <= 0 {
+		return ""
+	}
+	set, ok := sm.Topology().ReplicaSet(shardID)
+	if !ok {
+		return ""
+	}
+	_, addrs := set.sortedReplicas()
+	for _, addr := range addrs {
+		if addr != primary {
+			return addr
+		}
+	}
+	return ""
+}
+
+// requestHedgedProof sends the prepare to primary and, if it has not answered
+// within the hedge delay, to secondary as well. A follower forwards the
+// request to the leader, where the second copy is deduplicated, or answers it
+// from a ReadIndex if the transaction is read-only.
+func (sm *ShardManager) requestHedgedProof(ctx context.Context, primary, secondary string, req *PrepareRequest) (*PrepareProof, error) {
+	proof, hedged, won, err := hedgeProposal(ctx, sm.hedgeDelay,
+		func(ctx context.Context) (*PrepareProof, error) {
+			return sm.postProposal(ctx, primary, req)
+		},
+		func(ctx context.Context) (*PrepareProof, error) {
+			return sm.postProposal(ctx, secondary, req)
+		},
+	)
+	if hedged {
+		logger.Debugf("Hedged prepare of tx %s on shard %s to %s after %s", req.TxID, req.ShardID, secondary, sm.hedgeDelay)
+	}
+	if m, ok := sm.metrics.(HedgeMetrics); ok {
+		if hedged {
+			m.PrepareHedged(req.ShardID)
+		}
+		if won {
+			m.HedgeWon(req.ShardID)
+		}
+	}
+	return proof, err
+}
+
+type hedgeResult struct {
+	proof *PrepareProof
+	err   error
+	hedge bool
+}
+
+// hedgeProposal runs primary and, unless it returned within delay, hedge
+// concurrently. It returns the first proof either of them returns, whether
+// hedge was run and whether its proof was returned. The other request is
+// abandoned. Once primary failed, hedge is not started; if both fail, the
+// error of primary is returned.
+func hedgeProposal(ctx context.Context, delay time.Duration, primary, hedge func(context.Context) (*PrepareProof, error)) (proof *PrepareProof, hedged, won bool, err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan hedgeResult, 2)
+	run := func(propose func(context.Context) (*PrepareProof, error), isHedge bool) {
+		go func() {
+			proof, err := propose(ctx)
+			results <- hedgeResult{proof: proof, err: err, hedge: isHedge}
+		}()
+	}
+	run(primary, false)
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	pending := 1
+	var primaryErr, hedgeErr error
+	for {
+		select {
+		case <-timer.C:
+			if ctx.Err() == nil {
+				hedged = true
+				pending++
+				run(hedge, true)
+			}
+		case res := <-results:
+			pending--
+			if res.err == nil {
+				return res.proof, hedged, res.hedge, nil
+			}
+			if res.hedge {
+				hedgeErr = res.err
+			} else {
+				primaryErr = res.err
+			}
+			if pending > 0 {
+				continue
+			}
+			if !hedged {
+				return nil, false, false, primaryErr
+			}
+			return nil, true, false, fmt.Errorf("%v (hedged request: %v)", primaryErr, hedgeErr)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestHedgeTarget(t *testing.T) {
+	gt := NewGomegaWithT(t)
+	sm := &ShardManager{topology: &ShardTopology{Contracts: map[string]ReplicaSet{
+		"fabcar":  {Replicas: map[uint64]string{1: "peer0:7051", 2: "peer1:7051", 3: "peer2:7051"}},
+		"marbles": {Replicas: map[uint64]string{1: "peer0:7051"}},
+	}}}
+
+	gt.Expect(sm.hedgeTarget("fabcar", "peer0:7051")).To(BeEmpty(), "prepares are not hedged without a delay")
+
+	sm.hedgeDelay = 100 * time.Millisecond
+	gt.Expect(sm.hedgeTarget("fabcar", "peer0:7051")).To(Equal("peer1:7051"))
+	gt.Expect(sm.hedgeTarget("fabcar", "peer2:7051")).To(Equal("peer0:7051"))
+	gt.Expect(sm.hedgeTarget("marbles", "peer0:7051")).To(BeEmpty())
+	gt.Expect(sm.hedgeTarget("unknown", "peer0:7051")).To(BeEmpty())
+}
+
+func TestHedgeProposal(t *testing.T) {
+	answer := func(wait time.Duration, proof *PrepareProof, err error) func(context.Context) (*PrepareProof, error) {
+		return func(ctx context.Context) (*PrepareProof, error) {
+			select {
+			case <-time.After(wait):
+				return proof, err
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+	}
+	primaryProof := &PrepareProof{TxID: "tx1", SignerID: 1}
+	hedgeProof := &PrepareProof{TxID: "tx1", SignerID: 2}
+	const delay = 20 * time.Millisecond
+
+	t.Run("FastPrimary", func(t *testing.T) {
+		gt := NewGomegaWithT(t)
+		proof, hedged, won, err := hedgeProposal(context.Background(), delay, answer(0, primaryProof, nil), answer(0, hedgeProof, nil))
+		gt.Expect(err).NotTo(HaveOccurred())
+		gt.Expect(proof).To(BeIdenticalTo(primaryProof))
+		gt.Expect(hedged).To(BeFalse())
+		gt.Expect(won).To(BeFalse())
+	})
+
+	t.Run("SlowPrimary", func(t *testing.T) {
+		gt := NewGomegaWithT(t)
+		proof, hedged, won, err := hedgeProposal(context.Background(), delay, answer(time.Minute, primaryProof, nil), answer(0, hedgeProof, nil))
+		gt.Expect(err).NotTo(HaveOccurred())
+		gt.Expect(proof).To(BeIdenticalTo(hedgeProof))
+		gt.Expect(hedged).To(BeTrue())
+		gt.Expect(won).To(BeTrue())
+	})
+
+	t.Run("HedgeFails", func(t *testing.T) {
+		gt := NewGomegaWithT(t)
+		proof, hedged, won, err := hedgeProposal(context.Background(), delay, answer(2*delay, primaryProof, nil), answer(0, nil, errors.New("queue full")))
+		gt.Expect(err).NotTo(HaveOccurred())
+		gt.Expect(proof).To(BeIdenticalTo(primaryProof))
+		gt.Expect(hedged).To(BeTrue())
+		gt.Expect(won).To(BeFalse())
+	})
+
+	t.Run("PrimaryFailsFast", func(t *testing.T) {
+		gt := NewGomegaWithT(t)
+		_, hedged, _, err := hedgeProposal(context.Background(), delay, answer(0, nil, errors.New("remote error")), answer(0, hedgeProof, nil))
+		gt.Expect(err).To(MatchError("remote error"))
+		gt.Expect(hedged).To(BeFalse())
+	})
+
+	t.Run("BothFail", func(t *testing.T) {
+		gt := NewGomegaWithT(t)
+		_, hedged, won, err := hedgeProposal(context.Background(), delay, answer(2*delay, nil, errors.New("remote error")), answer(0, nil, errors.New("queue full")))
+		gt.Expect(err).To(MatchError("remote error (hedged request: queue full)"))
+		gt.Expect(hedged).To(BeTrue())
+		gt.Expect(won).To(BeFalse())
+	})
+}
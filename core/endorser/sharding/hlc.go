@@ -0,0 +1,145 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultMaxClockOffset is how far ahead of the local wall clock a remote
+// timestamp may be for the clock to follow it
+const DefaultMaxClockOffset = 500 * time.Millisecond
+
+// ErrClockOffset is returned for a remote timestamp too far ahead of the
+// local wall clock to be followed
+var ErrClockOffset = errors.New("remote timestamp is too far ahead of the local clock")
+
+// HLCTimestamp is a reading of a hybrid logical clock: the highest wall clock
+// time the clock has seen, in Unix nanoseconds, and a counter ordering the
+// readings that share it. Readings that causally follow one another are
+// ordered alike on every node, whatever the skew of their wall clocks.
+type HLCTimestamp struct {
+	Wall    int64
+	Logical uint32 `json:",omitempty"`
+}
+
+// IsZero reports whether the timestamp was never set
+func (t HLCTimestamp) IsZero() bool {
+	return t.Wall == 0 && t.Logical == 0
+}
+
+// Before reports whether t is ordered before o
+func (t HLCTimestamp) Before(o HLCTimestamp) bool {
+	return t.Wall < o.Wall || (t.Wall == o.Wall && t.Logical < o.Logical)
+}
+
+// Time returns the wall clock part of the timestamp
+func (t HLCTimestamp) Time() time.Time {
+	return time.Unix(0, t.Wall)
+}
+
+func (t HLCTimestamp) String() string {
+	return fmt.Sprintf("%d.%d", t.Wall, t.Logical)
+}
+
+// HLC is a hybrid logical clock. Its readings never go backwards, stay close
+// to the wall clock, and follow the timestamps it observes from other nodes.
+type HLC struct {
+	mu        sync.Mutex
+	last      HLCTimestamp
+	maxOffset time.Duration
+	wallClock func() time.Time
+}
+
+// NewHLC returns a clock following remote timestamps up to maxOffset ahead
+// of the local wall clock
+func NewHLC(maxOffset time.Duration) *HLC {
+	return &HLC{maxOffset: maxOffset, wallClock: time.Now}
+}
+
+// processClock is shared by the endorser and the shards of the process
+var processClock = NewHLC(DefaultMaxClockOffset)
+
+// Clock returns the hybrid logical clock of this process, which times its
+// prepare requests and the entries of the shards it leads
+func Clock() *HLC {
+	return processClock
+}
+
+// Now returns a timestamp after every one the clock returned or observed
+func (c *HLC) Now() HLCTimestamp {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	wall := c.wallClock().UnixNano()
+	if wall > c.last.Wall {
+		c.last = HLCTimestamp{Wall: wall}
+	} else {
+		c.last.Logical++
+	}
+	return c.last
+}
+
+// Update observes a timestamp received from another node and returns a
+// timestamp after both it and every one the clock returned. A timestamp more
+// than the maximum offset ahead of the wall clock is not observed; Update
+// then fails with ErrClockOffset, and returns a reading of the clock as Now
+// does.
+func (c *HLC) Update(remote HLCTimestamp) (HLCTimestamp, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	wall := c.wallClock().UnixNano()
+	if c.maxOffset > 0 && remote.Wall-wall > int64(c.maxOffset) {
+		if wall > c.last.Wall {
+			c.last = HLCTimestamp{Wall: wall}
+		} else {
+			c.last.Logical++
+		}
+		return c.last, fmt.Errorf("%w: %s ahead", ErrClockOffset, time.Duration(remote.Wall-wall))
+	}
+
+	switch {
+	case wall > c.last.Wall && wall > remote.Wall:
+		c.last = HLCTimestamp{Wall: wall}
+	case c.last.Wall == remote.Wall:
+		if remote.Logical > c.last.Logical {
+			c.last.Logical = remote.Logical
+		}
+		c.last.Logical++
+	case c.last.Wall > remote.Wall:
+		c.last.Logical++
+	default:
+		c.last = HLCTimestamp{Wall: remote.Wall, Logical: remote.Logical + 1}
+	}
+	return c.last, nil
+}
+
+// observe returns the HLC a shard orders the request at: a reading of the
+// process clock after the timestamp of the request
+func observe(req *PrepareRequest) HLCTimestamp {
+	ts, err := processClock.Update(req.Timestamp)
+	if err != nil {
+		logger.Warningf("Tx %s on shard %s: %v", req.TxID, req.ShardID, err)
+	}
+	return ts
+}
+
+// observeEntry makes the process clock follow the HLC of an applied request,
+// so that a replica that becomes leader orders the requests it proposes
+// after those of the previous leader
+func (sl *ShardLeader) observeEntry(req *PrepareRequestProto) {
+	if req.HLC.IsZero() {
+		return
+	}
+	if _, err := processClock.Update(req.HLC); err != nil {
+		logger.Warningf("Shard %s: entry of tx %s: %v", sl.shardID, req.TxID, err)
+	}
+}
@@ -0,0 +1,116 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+// manualClock returns the wall time it is set to
+type manualClock struct {
+	now time.Time
+}
+
+func (c *manualClock) Now() time.Time { return c.now }
+
+func TestHLCNow(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	wall := &manualClock{now: time.Unix(100, 0)}
+	c := NewHLC(time.Second)
+	c.wallClock = wall.Now
+
+	gt.Expect(c.Now()).To(Equal(HLCTimestamp{Wall: int64(100 * time.Second)}))
+	// the logical counter orders the readings within the same wall time
+	gt.Expect(c.Now()).To(Equal(HLCTimestamp{Wall: int64(100 * time.Second), Logical: 1}))
+
+	// the clock never goes backwards
+	wall.now = time.Unix(99, 0)
+	gt.Expect(c.Now()).To(Equal(HLCTimestamp{Wall: int64(100 * time.Second), Logical: 2}))
+
+	wall.now = time.Unix(101, 0)
+	gt.Expect(c.Now()).To(Equal(HLCTimestamp{Wall: int64(101 * time.Second)}))
+}
+
+func TestHLCUpdate(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	wall := &manualClock{now: time.Unix(100, 0)}
+	c := NewHLC(time.Second)
+	c.wallClock = wall.Now
+	at := func(d time.Duration, logical uint32) HLCTimestamp {
+		return HLCTimestamp{Wall: int64(100*time.Second + d), Logical: logical}
+	}
+
+	// a remote clock behind the local one is ordered before it
+	ts, err := c.Update(at(-time.Millisecond, 5))
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(ts).To(Equal(at(0, 0)))
+
+	// a remote clock ahead within the offset is followed
+	ts, err = c.Update(at(300*time.Millisecond, 5))
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(ts).To(Equal(at(300*time.Millisecond, 6)))
+	gt.Expect(c.Now()).To(Equal(at(300*time.Millisecond, 7)))
+
+	ts, err = c.Update(at(300*time.Millisecond, 2))
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(ts).To(Equal(at(300*time.Millisecond, 8)))
+
+	// a remote clock too far ahead is not followed
+	ts, err = c.Update(at(2*time.Second, 0))
+	gt.Expect(err).To(MatchError(ErrClockOffset))
+	gt.Expect(ts).To(Equal(at(300*time.Millisecond, 9)))
+
+	gt.Expect(at(0, 1).Before(at(0, 2))).To(BeTrue())
+	gt.Expect(at(0, 2).Before(at(time.Nanosecond, 0))).To(BeTrue())
+	gt.Expect(at(0, 2).Before(at(0, 2))).To(BeFalse())
+	gt.Expect(at(0, 2).String()).To(Equal("100000000000.2"))
+}
+
+func TestShardLeaderProofHLC(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	sl, err := NewShardLeader(ShardConfig{
+		ShardID:    "fabcar",
+		ReplicaIDs: []uint64{1},
+		ReplicaID:  1,
+	}, DefaultBatchTimeout, DefaultBatchMaxSize)
+	gt.Expect(err).NotTo(HaveOccurred())
+	defer sl.Stop()
+
+	gt.Eventually(func() bool { return sl.GetStatus().IsLeader }, 30*time.Second, 100*time.Millisecond).Should(BeTrue())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// an endorser whose clock runs ahead still gets a proof ordered after
+	// its request
+	ahead := HLCTimestamp{Wall: time.Now().Add(100 * time.Millisecond).UnixNano()}
+	proof1, err := sl.ProposeAndWait(ctx, &PrepareRequest{TxID: "tx1", ShardID: "fabcar", WriteSet: map[string][]byte{"car1": nil}, Timestamp: ahead})
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(ahead.Before(proof1.HLC)).To(BeTrue())
+
+	// an endorser whose clock runs behind gets a proof ordered after the
+	// transactions the shard ordered before
+	behind := HLCTimestamp{Wall: time.Now().Add(-time.Minute).UnixNano()}
+	proof2, err := sl.ProposeAndWait(ctx, &PrepareRequest{TxID: "tx2", ShardID: "fabcar", ReadSet: map[string][]byte{"car1": nil}, WriteSet: map[string][]byte{"car1": nil}, Timestamp: behind})
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(proof2.DependentTxID).To(Equal("tx1"))
+	gt.Expect(proof1.HLC.Before(proof2.HLC)).To(BeTrue())
+
+	// the proofs embedded in endorsements keep the HLC
+	encoded, err := EncodeProofs([]*PrepareProof{proof2})
+	gt.Expect(err).NotTo(HaveOccurred())
+	decoded, err := DecodeProofs(encoded)
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(decoded[0].HLC).To(Equal(proof2.HLC))
+}
@@ -0,0 +1,241 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// LatencyStage identifies one segment of the propose-to-commit path
+type LatencyStage string
+
+const (
+	// StageBatching is the time a request waits in the batch queue
+	StageBatching LatencyStage = "batching"
+	// StageAppend is the time from batch formation until the entry is appended to the Raft log
+	StageAppend LatencyStage = "append"
+	// StageReplication is the time from local append until the entry is committed by a quorum
+	StageReplication LatencyStage = "replication"
+	// StageApply is the time spent applying the committed entry to the state machine
+	StageApply LatencyStage = "apply"
+	// StageTotal is the end-to-end time from enqueue to apply
+	StageTotal LatencyStage = "total"
+)
+
+// LatencyStages lists all stages in pipeline order
+var LatencyStages = []LatencyStage{StageBatching, StageAppend, StageReplication, StageApply, StageTotal}
+
+// DefaultLatencyBuckets are the histogram upper bounds in seconds
+var DefaultLatencyBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// HistogramSnapshot is a point-in-time copy of a latency histogram
+type HistogramSnapshot struct {
+	Buckets []float64
+	Counts  []uint64
+	Count   uint64
+	Sum     float64
+}
+
+// Mean returns the average observed value in seconds
+func (h HistogramSnapshot) Mean() float64 {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / float64(h.Count)
+}
+
+// Quantile estimates the q-quantile of the observed values by interpolating
+// linearly within the bucket that holds it. Values above the last bucket are
+// reported as its upper bound.
+func (h HistogramSnapshot) Quantile(q float64) float64 {
+	if h.Count == 0 {
+		return 0
+	}
+	rank := q * float64(h.Count)
+	seen, lower := 0.0, 0.0
+	for i, c := range h.Counts {
+		if i == len(h.Buckets) {
+			break
+		}
+		if c > 0 && seen+float64(c) >= rank {
+			return lower + (h.Buckets[i]-lower)*(rank-seen)/float64(c)
+		}
+		seen += float64(c)
+		lower = h.Buckets[i]
+	}
+	return lower
+}
+
+// latencyHistogram is a cumulative fixed-bucket histogram
+type latencyHistogram struct {
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     float64
+}
+
+func newLatencyHistogram(buckets []float64) *latencyHistogram {
+	return &latencyHistogram{
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)+1),
+	}
+}
+
+func (h *latencyHistogram) observe(d time.Duration) {
+	h.observeValue(d.Seconds())
+}
+
+func (h *latencyHistogram) observeValue(v float64) {
+	i := 0
+	for i < len(h.buckets) && v > h.buckets[i] {
+		i++
+	}
+	h.counts[i]++
+	h.count++
+	h.sum += v
+}
+
+func (h *latencyHistogram) snapshot() HistogramSnapshot {
+	counts := make([]uint64, len(h.counts))
+	copy(counts, h.counts)
+	return HistogramSnapshot{
+		Buckets: h.buckets,
+		Counts:  counts,
+		Count:   h.count,
+		Sum:     h.sum,
+	}
+}
+
+// txTimestamps records when a request reached each pre-apply stage
+type txTimestamps struct {
+	enqueued time.Time
+	batched  time.Time
+}
+
+// latencyTracker records per-request stage timestamps and aggregates them
+// into one histogram per stage. Only requests enqueued on this replica are
+// tracked, since followers never see the enqueue and batch times.
+type latencyTracker struct {
+	mu          sync.Mutex
+	pending     map[string]*txTimestamps
+	appendTimes map[uint64]time.Time
+	histograms  map[LatencyStage]*latencyHistogram
+}
+
+func newLatencyTracker() *latencyTracker {
+	lt := &latencyTracker{
+		pending:     make(map[string]*txTimestamps),
+		appendTimes: make(map[uint64]time.Time),
+		histograms:  make(map[LatencyStage]*latencyHistogram),
+	}
+	for _, stage := range LatencyStages {
+		lt.histograms[stage] = newLatencyHistogram(DefaultLatencyBuckets)
+	}
+	return lt
+}
+
+// enqueued marks the time a request was accepted into the batch queue
+func (lt *latencyTracker) enqueued(txID string, at time.Time) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	if _, exists := lt.pending[txID]; !exists {
+		lt.pending[txID] = &txTimestamps{enqueued: at}
+	}
+}
+
+// batched marks the time the batch containing the requests was formed
+func (lt *latencyTracker) batched(batch []*PrepareRequest, at time.Time) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	for _, req := range batch {
+		if ts, exists := lt.pending[req.TxID]; exists {
+			ts.batched = at
+		}
+	}
+}
+
+// appended marks the time an entry was persisted to the local Raft log
+func (lt *latencyTracker) appended(index uint64, at time.Time) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	if _, exists := lt.appendTimes[index]; !exists {
+		lt.appendTimes[index] = at
+	}
+}
+
+// applied records the full breakdown for a request whose entry was
+// committed at committedAt and applied at appliedAt
+func (lt *latencyTracker) applied(txID string, index uint64, committedAt, appliedAt time.Time) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	ts, exists := lt.pending[txID]
+	if !exists {
+		return
+	}
+	delete(lt.pending, txID)
+
+	appendedAt, ok := lt.appendTimes[index]
+	if !ok || ts.batched.IsZero() {
+		return
+	}
+
+	lt.histograms[StageBatching].observe(ts.batched.Sub(ts.enqueued))
+	lt.histograms[StageAppend].observe(appendedAt.Sub(ts.batched))
+	lt.histograms[StageReplication].observe(committedAt.Sub(appendedAt))
+	lt.histograms[StageApply].observe(appliedAt.Sub(committedAt))
+	lt.histograms[StageTotal].observe(appliedAt.Sub(ts.enqueued))
+}
+
+// entryApplied releases the append timestamp of a fully applied entry
+func (lt *latencyTracker) entryApplied(index uint64) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	delete(lt.appendTimes, index)
+}
+
+// expire drops timestamps of requests that never reached apply
+func (lt *latencyTracker) expire(olderThan time.Time) int {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	removed := 0
+	for txID, ts := range lt.pending {
+		if ts.enqueued.Before(olderThan) {
+			delete(lt.pending, txID)
+			removed++
+		}
+	}
+	for index, at := range lt.appendTimes {
+		if at.Before(olderThan) {
+			delete(lt.appendTimes, index)
+		}
+	}
+	return removed
+}
+
+// snapshot returns a copy of all stage histograms
+func (lt *latencyTracker) snapshot() map[LatencyStage]HistogramSnapshot {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	result := make(map[LatencyStage]HistogramSnapshot, len(lt.histograms))
+	for stage, h := range lt.histograms {
+		result[stage] = h.snapshot()
+	}
+	return result
+}
+
+// handleLatency serves the propose-to-commit latency histograms of the local
+// shards as JSON
+func (sm *ShardManager) handleLatency(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sm.GetLatencyBreakdown())
+}
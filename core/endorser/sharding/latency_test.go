@@ -0,0 +1,114 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestLatencyHistogram(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	h := newLatencyHistogram([]float64{0.001, 0.01, 0.1})
+	for _, v := range []float64{0.0005, 0.001, 0.005, 0.005, 0.05, 1} {
+		h.observeValue(v)
+	}
+	snapshot := h.snapshot()
+	// a value equal to a bound falls in its bucket; values above the last
+	// bound fall in the overflow bucket
+	gt.Expect(snapshot.Counts).To(Equal([]uint64{2, 2, 1, 1}))
+	gt.Expect(snapshot.Count).To(Equal(uint64(6)))
+	gt.Expect(snapshot.Sum).To(BeNumerically("~", 1.0615, 1e-9))
+	gt.Expect(snapshot.Mean()).To(BeNumerically("~", 1.0615/6, 1e-9))
+
+	gt.Expect(snapshot.Quantile(0.5)).To(BeNumerically("~", 0.0055, 1e-9))
+	gt.Expect(snapshot.Quantile(0.25)).To(BeNumerically("~", 0.00075, 1e-9))
+	gt.Expect(snapshot.Quantile(0.75)).To(BeNumerically("~", 0.055, 1e-9))
+	gt.Expect(snapshot.Quantile(0.99)).To(Equal(0.1))
+
+	// the snapshot is a copy
+	h.observe(2 * time.Millisecond)
+	gt.Expect(snapshot.Counts[1]).To(Equal(uint64(2)))
+
+	empty := newLatencyHistogram(DefaultLatencyBuckets).snapshot()
+	gt.Expect(empty.Mean()).To(BeZero())
+	gt.Expect(empty.Quantile(0.99)).To(BeZero())
+}
+
+func TestLatencyTracker(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	lt := newLatencyTracker()
+	start := time.Now()
+	lt.enqueued("tx1", start)
+	lt.enqueued("tx2", start)
+	// a request enqueued again keeps its first time
+	lt.enqueued("tx1", start.Add(time.Second))
+	lt.batched([]*PrepareRequest{{TxID: "tx1"}}, start.Add(2*time.Millisecond))
+	lt.appended(5, start.Add(3*time.Millisecond))
+	lt.applied("tx1", 5, start.Add(7*time.Millisecond), start.Add(8*time.Millisecond))
+	// tx2 was never batched, and tx3 was not enqueued on this replica
+	lt.applied("tx2", 5, start.Add(7*time.Millisecond), start.Add(8*time.Millisecond))
+	lt.applied("tx3", 5, start.Add(7*time.Millisecond), start.Add(8*time.Millisecond))
+	lt.entryApplied(5)
+
+	snapshot := lt.snapshot()
+	gt.Expect(snapshot).To(HaveLen(len(LatencyStages)))
+	expected := map[LatencyStage]float64{
+		StageBatching:    0.002,
+		StageAppend:      0.001,
+		StageReplication: 0.004,
+		StageApply:       0.001,
+		StageTotal:       0.008,
+	}
+	for stage, seconds := range expected {
+		gt.Expect(snapshot[stage].Count).To(Equal(uint64(1)), string(stage))
+		gt.Expect(snapshot[stage].Sum).To(BeNumerically("~", seconds, 1e-9), string(stage))
+	}
+	gt.Expect(lt.pending).To(BeEmpty())
+	gt.Expect(lt.appendTimes).To(BeEmpty())
+
+	lt.enqueued("tx4", start)
+	lt.enqueued("tx5", start.Add(time.Minute))
+	lt.appended(6, start)
+	gt.Expect(lt.expire(start.Add(time.Second))).To(Equal(1))
+	gt.Expect(lt.pending).To(HaveKey("tx5"))
+	gt.Expect(lt.appendTimes).To(BeEmpty())
+}
+
+func TestLatencyHandler(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	lt := newLatencyTracker()
+	start := time.Now()
+	lt.enqueued("tx1", start)
+	lt.batched([]*PrepareRequest{{TxID: "tx1"}}, start.Add(time.Millisecond))
+	lt.appended(1, start.Add(2*time.Millisecond))
+	lt.applied("tx1", 1, start.Add(3*time.Millisecond), start.Add(4*time.Millisecond))
+	sm := &ShardManager{shards: map[string]*ShardLeader{"fabcar": {latency: lt}}}
+
+	rec := httptest.NewRecorder()
+	sm.handleLatency(rec, httptest.NewRequest(http.MethodGet, "/latency", nil))
+	gt.Expect(rec.Code).To(Equal(http.StatusOK))
+	gt.Expect(rec.Header().Get("Content-Type")).To(Equal("application/json"))
+
+	var breakdown map[string]map[LatencyStage]HistogramSnapshot
+	gt.Expect(json.Unmarshal(rec.Body.Bytes(), &breakdown)).To(Succeed())
+	gt.Expect(breakdown).To(HaveKey("fabcar"))
+	total := breakdown["fabcar"][StageTotal]
+	gt.Expect(total.Buckets).To(Equal(DefaultLatencyBuckets))
+	gt.Expect(total.Count).To(Equal(uint64(1)))
+	// 4ms falls in the 5ms bucket
+	gt.Expect(total.Counts[3]).To(Equal(uint64(1)))
+	gt.Expect(breakdown["fabcar"][StageBatching].Sum).To(BeNumerically("~", 0.001, 1e-9))
+}
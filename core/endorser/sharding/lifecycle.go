@@ -0,0 +1,204 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"sync"
+	"time"
+
+	"go.etcd.io/etcd/raft/v3"
+)
+
+// ShardObserver is notified of lifecycle changes of the shards hosted by a
+// ShardManager. Notifications are delivered in order on a goroutine of the
+// manager, so an observer that blocks delays the ones after it but never the
+// shards themselves.
+type ShardObserver interface {
+	// OnShardCreated is called once a shard started on this peer
+	OnShardCreated(shardID string)
+	// OnShardStopped is called once a shard was stopped, on eviction or
+	// shutdown
+	OnShardStopped(shardID string)
+	// OnLeaderChanged is called when a replica of the shard learns of a new
+	// leader. leaderID is 0 while the shard has no leader.
+	OnLeaderChanged(shardID string, leaderID uint64)
+}
+
+type shardEventKind int
+
+const (
+	shardCreated shardEventKind = iota
+	shardStopped
+	leaderChanged
+	endorsementExpired
+)
+
+type shardEvent struct {
+	kind     shardEventKind
+	shardID  string
+	leaderID uint64
+	expired  *ExpiredEndorsement
+}
+
+// shardEvents queues lifecycle and expiry events and delivers them to the
+// observers
+type shardEvents struct {
+	mu              sync.Mutex
+	observers       []ShardObserver
+	expiryObservers []ExpiryObserver
+	queue           []shardEvent
+	closed          bool
+	signalC         chan struct{}
+	doneC           chan struct{}
+}
+
+func newShardEvents() *shardEvents {
+	e := &shardEvents{
+		signalC: make(chan struct{}, 1),
+		doneC:   make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+// publish queues the event without blocking. Events are dropped while there
+// are no observers of their kind.
+func (e *shardEvents) publish(event shardEvent) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	if e.closed || !e.observedLocked(event.kind) {
+		e.mu.Unlock()
+		return
+	}
+	e.queue = append(e.queue, event)
+	e.mu.Unlock()
+
+	select {
+	case e.signalC <- struct{}{}:
+	default:
+	}
+}
+
+// observedLocked reports whether any observer is told of events of the kind
+func (e *shardEvents) observedLocked(kind shardEventKind) bool {
+	if kind == endorsementExpired {
+		return len(e.expiryObservers) > 0
+	}
+	return len(e.observers) > 0
+}
+
+func (e *shardEvents) leaderChanged(shardID string, leaderID uint64) {
+	e.publish(shardEvent{kind: leaderChanged, shardID: shardID, leaderID: leaderID})
+}
+
+// close delivers the queued events and stops the dispatcher
+func (e *shardEvents) close() {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	if e.closed {
+		e.mu.Unlock()
+		return
+	}
+	e.closed = true
+	e.mu.Unlock()
+	close(e.signalC)
+	<-e.doneC
+}
+
+func (e *shardEvents) run() {
+	defer close(e.doneC)
+	for range e.signalC {
+		e.deliver()
+	}
+	e.deliver()
+}
+
+func (e *shardEvents) deliver() {
+	for {
+		e.mu.Lock()
+		queue := e.queue
+		e.queue = nil
+		observers := e.observers
+		expiryObservers := e.expiryObservers
+		e.mu.Unlock()
+
+		if len(queue) == 0 {
+			return
+		}
+		for _, event := range queue {
+			if event.kind == endorsementExpired {
+				for _, o := range expiryObservers {
+					o.OnEndorsementExpired(*event.expired)
+				}
+				continue
+			}
+			for _, o := range observers {
+				switch event.kind {
+				case shardCreated:
+					o.OnShardCreated(event.shardID)
+				case shardStopped:
+					o.OnShardStopped(event.shardID)
+				case leaderChanged:
+					o.OnLeaderChanged(event.shardID, event.leaderID)
+				}
+			}
+		}
+	}
+}
+
+// AddObserver registers an observer of shard lifecycle changes. The
+// observer is not told about the shards already running.
+func (sm *ShardManager) AddObserver(observer ShardObserver) {
+	sm.events.mu.Lock()
+	defer sm.events.mu.Unlock()
+	sm.events.observers = append(sm.events.observers, observer)
+}
+
+// watchShard reports the creation of the shard, its leader changes and the
+// endorsements it drops to the observers, and gossips its updates
+func (sm *ShardManager) watchShard(shard *ShardLeader) {
+	sm.events.publish(shardEvent{kind: shardCreated, shardID: shard.shardID})
+	if sm.events != nil {
+		shard.setLeaderObserver(sm.events.leaderChanged)
+		shard.setExpiryObserver(sm.events.endorsementExpired)
+	}
+	if sm.gossip != nil {
+		shard.setUpdateObserver(sm.gossip.add)
+	}
+}
+
+// setLeaderObserver registers the function runRaft calls on leader changes
+// and hands it the leader known so far. It must not block.
+func (sl *ShardLeader) setLeaderObserver(observer func(shardID string, leaderID uint64)) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	sl.leaderObserver = observer
+	if sl.leaderID != raft.None {
+		observer(sl.shardID, sl.leaderID)
+	}
+}
+
+// setLeader records the leader reported by Raft and passes it to the leader
+// observer. The observer is called under mu, so that it sees leaders in
+// order.
+func (sl *ShardLeader) setLeader(leaderID uint64) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	sl.leaderID = leaderID
+	if sl.leaderObserver != nil {
+		sl.leaderObserver(sl.shardID, leaderID)
+	}
+	// A new leader prunes the reservations that expired while the shard was
+	// down or leaderless rather than waiting for the next GC tick
+	if leaderID == sl.config.ReplicaID {
+		go sl.proposeGC(time.Now())
+	}
+}
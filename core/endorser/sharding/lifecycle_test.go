@@ -0,0 +1,90 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+type recordingObserver struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (o *recordingObserver) record(event string) {
+	o.mu.Lock()
+	o.events = append(o.events, event)
+	o.mu.Unlock()
+}
+
+func (o *recordingObserver) OnShardCreated(shardID string) { o.record("created " + shardID) }
+func (o *recordingObserver) OnShardStopped(shardID string) { o.record("stopped " + shardID) }
+func (o *recordingObserver) OnLeaderChanged(shardID string, leaderID uint64) {
+	o.record(fmt.Sprintf("leader %s %d", shardID, leaderID))
+}
+
+func (o *recordingObserver) recorded() []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]string(nil), o.events...)
+}
+
+func TestShardObserver(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	observer := &recordingObserver{}
+	sm := &ShardManager{shards: make(map[string]*ShardLeader), events: newShardEvents(), stopC: make(chan struct{})}
+	sm.AddObserver(observer)
+
+	fabcar := newEvictionTestShard(t, "fabcar", time.Now())
+	marbles := newEvictionTestShard(t, "marbles", time.Now())
+	sm.shards["fabcar"] = fabcar
+	sm.watchShard(fabcar)
+	sm.shards["marbles"] = marbles
+	sm.watchShard(marbles)
+
+	gt.Eventually(observer.recorded, 30*time.Second, 100*time.Millisecond).Should(ContainElements("leader fabcar 1", "leader marbles 1"))
+
+	sm.shardsLock.Lock()
+	sm.evictShardLocked("fabcar", fabcar, "idle")
+	sm.shardsLock.Unlock()
+	sm.Shutdown()
+
+	// Shutdown delivers the pending events before it returns
+	events := observer.recorded()
+	gt.Expect(events).To(HaveLen(6))
+	gt.Expect(events[:2]).To(Equal([]string{"created fabcar", "created marbles"}))
+	gt.Expect(events[4:]).To(Equal([]string{"stopped fabcar", "stopped marbles"}))
+
+	// no events are delivered after Shutdown
+	sm.events.publish(shardEvent{kind: shardCreated, shardID: "supply"})
+	gt.Consistently(observer.recorded, 200*time.Millisecond).Should(HaveLen(6))
+}
+
+func TestLeaderObserverGetsKnownLeader(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	sl := newEvictionTestShard(t, "fabcar", time.Now())
+	defer sl.Stop()
+	gt.Eventually(func() uint64 {
+		sl.mu.RLock()
+		defer sl.mu.RUnlock()
+		return sl.leaderID
+	}, 30*time.Second, 100*time.Millisecond).Should(Equal(uint64(1)))
+
+	var leaders []uint64
+	sl.setLeaderObserver(func(shardID string, leaderID uint64) {
+		gt.Expect(shardID).To(Equal("fabcar"))
+		leaders = append(leaders, leaderID)
+	})
+	gt.Expect(leaders).To(Equal([]uint64{1}))
+}
@@ -0,0 +1,210 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// LocalDependencyStore tracks dependencies in the memory of the peer, without
+// replicating them. It applies the checks of a shard under the queue-behind
+// policy, but only orders the transactions prepared by the same peer and
+// forgets every reservation when the peer restarts. It serves to measure
+// what the Raft round costs, or to endorse through a single peer.
+type LocalDependencyStore struct {
+	ttl time.Duration
+
+	mu     sync.Mutex
+	index  uint64
+	shards map[string]*localShard
+}
+
+// localShard holds the reservations of one shard
+type localShard struct {
+	keys   map[string]localReservation
+	ranges map[string][]RangeReservation
+	// proofs are kept until the reservations expire, so that a retried
+	// prepare gets the same proof
+	proofs map[string]localProof
+	// sweepAt is when the expired entries are dropped next; until then they
+	// are skipped as they are met
+	sweepAt time.Time
+}
+
+type localReservation struct {
+	txID    string
+	read    bool
+	expires time.Time
+}
+
+type localProof struct {
+	proof   *PrepareProof
+	expires time.Time
+}
+
+// NewLocalDependencyStore creates an in-memory dependency store whose
+// reservations expire after ttl
+func NewLocalDependencyStore(ttl time.Duration) *LocalDependencyStore {
+	if ttl <= 0 {
+		ttl = DefaultExpiryDuration
+	}
+	return &LocalDependencyStore{
+		ttl:    ttl,
+		shards: make(map[string]*localShard),
+	}
+}
+
+// Prepare implements DependencyStore
+func (s *LocalDependencyStore) Prepare(ctx context.Context, req *PrepareRequest) (*PrepareProof, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	shard := s.shard(req.ShardID)
+	if now.After(shard.sweepAt) {
+		shard.expire(now)
+	}
+	if prepared, ok := shard.proofs[req.TxID]; ok && !now.After(prepared.expires) {
+		return prepared.proof, nil
+	}
+
+	conflicts := make(conflictSet)
+	for key := range req.ReadSet {
+		if holder, ok := shard.holder(key, now); ok && holder.txID != req.TxID {
+			conflicts.add(holder.txID, classifyConflict(true, holder.read))
+		}
+	}
+	for key := range req.WriteSet {
+		_, read := req.ReadSet[key]
+		if holder, ok := shard.holder(key, now); ok && holder.txID != req.TxID && !read {
+			conflicts.add(holder.txID, classifyConflict(false, holder.read))
+		}
+		for txID, reservations := range shard.ranges {
+			for _, r := range reservations {
+				if txID != req.TxID && !now.After(r.ExpiryTime) && r.Contains(key) {
+					conflicts.add(txID, classifyConflict(read, true))
+				}
+			}
+		}
+	}
+	for _, r := range append(append([]KeyRange(nil), req.RangeReads...), req.ReservedRanges...) {
+		for key, holder := range shard.keys {
+			if holder.txID != req.TxID && !now.After(holder.expires) && r.Contains(key) {
+				conflicts.add(holder.txID, classifyConflict(true, holder.read))
+			}
+		}
+	}
+
+	s.index++
+	expires := now.Add(req.ttlOr(s.ttl))
+	for key := range req.WriteSet {
+		_, read := req.ReadSet[key]
+		shard.keys[key] = localReservation{txID: req.TxID, read: read, expires: expires}
+	}
+	for _, r := range req.ReservedRanges {
+		shard.ranges[req.TxID] = append(shard.ranges[req.TxID], RangeReservation{
+			KeyRange:    r,
+			TxID:        req.TxID,
+			ExpiryTime:  expires,
+			Timestamp:   req.Timestamp.Wall,
+			CommitIndex: s.index,
+		})
+	}
+
+	proof := &PrepareProof{
+		TxID:           req.TxID,
+		ShardID:        req.ShardID,
+		CommitIndex:    s.index,
+		Signature:      signPrepareProof(req.ShardID, s.index, req.TxID),
+		DependentTxID:  conflicts.dependentTxID(),
+		HasDependency:  len(conflicts) > 0,
+		ConflictTypes:  conflicts.types(),
+		ConflictPolicy: ConflictPolicyQueueBehind,
+		HLC:            observe(req),
+	}
+	shard.proofs[req.TxID] = localProof{proof: proof, expires: expires}
+	return proof, nil
+}
+
+// Abort implements DependencyStore
+func (s *LocalDependencyStore) Abort(shardID, txID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	shard, ok := s.shards[shardID]
+	if !ok {
+		return nil
+	}
+	for key, holder := range shard.keys {
+		if holder.txID == txID {
+			delete(shard.keys, key)
+		}
+	}
+	delete(shard.ranges, txID)
+	delete(shard.proofs, txID)
+	return nil
+}
+
+// Reservations returns the number of keys reserved on the shard
+func (s *LocalDependencyStore) Reservations(shardID string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	shard, ok := s.shards[shardID]
+	if !ok {
+		return 0
+	}
+	shard.expire(time.Now())
+	return len(shard.keys)
+}
+
+func (s *LocalDependencyStore) shard(shardID string) *localShard {
+	shard, ok := s.shards[shardID]
+	if !ok {
+		shard = &localShard{
+			keys:   make(map[string]localReservation),
+			ranges: make(map[string][]RangeReservation),
+			proofs: make(map[string]localProof),
+		}
+		s.shards[shardID] = shard
+	}
+	return shard
+}
+
+// holder returns the live reservation of the key
+func (shard *localShard) holder(key string, now time.Time) (localReservation, bool) {
+	holder, ok := shard.keys[key]
+	if !ok || now.After(holder.expires) {
+		return localReservation{}, false
+	}
+	return holder, true
+}
+
+// expire drops the reservations and proofs that expired by now
+func (shard *localShard) expire(now time.Time) {
+	shard.sweepAt = now.Add(DefaultGCInterval)
+	for key, holder := range shard.keys {
+		if now.After(holder.expires) {
+			delete(shard.keys, key)
+		}
+	}
+	for txID, reservations := range shard.ranges {
+		if len(reservations) > 0 && now.After(reservations[0].ExpiryTime) {
+			delete(shard.ranges, txID)
+		}
+	}
+	for txID, prepared := range shard.proofs {
+		if now.After(prepared.expires) {
+			delete(shard.proofs, txID)
+		}
+	}
+}
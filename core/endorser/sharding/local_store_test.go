@@ -0,0 +1,101 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestLocalDependencyStore(t *testing.T) {
+	gt := NewGomegaWithT(t)
+	ctx := context.Background()
+
+	store := NewLocalDependencyStore(time.Minute)
+	prepare := func(req *PrepareRequest) *PrepareProof {
+		req.ShardID = "fabcar"
+		req.Timestamp = Clock().Now()
+		proof, err := store.Prepare(ctx, req)
+		gt.Expect(err).NotTo(HaveOccurred())
+		return proof
+	}
+
+	// tx1 writes car1 and reserves car2, which it only reads
+	proof := prepare(&PrepareRequest{
+		TxID:     "tx1",
+		ReadSet:  map[string][]byte{"fabcar:car2": nil},
+		WriteSet: map[string][]byte{"fabcar:car1": []byte("red"), "fabcar:car2": nil},
+	})
+	gt.Expect(proof.HasDependency).To(BeFalse())
+	gt.Expect(proof.Signature).To(Equal(signPrepareProof("fabcar", proof.CommitIndex, "tx1")))
+
+	// a retried prepare gets the same proof
+	gt.Expect(prepare(&PrepareRequest{TxID: "tx1", WriteSet: map[string][]byte{"fabcar:car1": []byte("red")}})).To(BeIdenticalTo(proof))
+
+	proof = prepare(&PrepareRequest{TxID: "tx2", ReadSet: map[string][]byte{"fabcar:car1": nil, "fabcar:car2": nil}})
+	gt.Expect(proof.DependentTxID).To(Equal("tx1"))
+	gt.Expect(proof.ConflictTypes).To(Equal(map[string][]ConflictType{"tx1": {ConflictReadWrite, ConflictReadRead}}))
+	gt.Expect(store.Reservations("fabcar")).To(Equal(2))
+
+	proof = prepare(&PrepareRequest{TxID: "tx3", WriteSet: map[string][]byte{"fabcar:car1": []byte("blue"), "fabcar:car2": []byte("blue")}})
+	gt.Expect(proof.ConflictTypes).To(Equal(map[string][]ConflictType{"tx1": {ConflictWriteWrite, ConflictWriteAfterRead}}))
+
+	// A write within a range scanned by a writer
+	prepare(&PrepareRequest{TxID: "tx4", ReservedRanges: []KeyRange{{StartKey: "fabcar:car5", EndKey: "fabcar:car9"}}})
+	proof = prepare(&PrepareRequest{TxID: "tx5", WriteSet: map[string][]byte{"fabcar:car7": []byte("red")}})
+	gt.Expect(proof.ConflictTypes).To(Equal(map[string][]ConflictType{"tx4": {ConflictWriteAfterRead}}))
+	proof = prepare(&PrepareRequest{TxID: "tx6", RangeReads: []KeyRange{{StartKey: "fabcar:car6", EndKey: "fabcar:car8"}}})
+	gt.Expect(proof.DependentTxID).To(Equal("tx5"))
+
+	// Aborted reservations no longer cause dependencies
+	gt.Expect(store.Abort("fabcar", "tx3")).To(Succeed())
+	gt.Expect(store.Abort("fabcar", "tx5")).To(Succeed())
+	gt.Expect(store.Abort("marbles", "tx5")).To(Succeed())
+	proof = prepare(&PrepareRequest{TxID: "tx7", ReadSet: map[string][]byte{"fabcar:car1": nil, "fabcar:car7": nil}})
+	gt.Expect(proof.HasDependency).To(BeFalse())
+
+	// Requests that were canceled reserve nothing
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err := store.Prepare(canceled, &PrepareRequest{TxID: "tx8", ShardID: "fabcar", WriteSet: map[string][]byte{"fabcar:car1": nil}})
+	gt.Expect(err).To(MatchError(context.Canceled))
+}
+
+func TestLocalDependencyStoreExpiry(t *testing.T) {
+	gt := NewGomegaWithT(t)
+	ctx := context.Background()
+
+	store := NewLocalDependencyStore(10 * time.Millisecond)
+	_, err := store.Prepare(ctx, &PrepareRequest{TxID: "tx1", ShardID: "fabcar", WriteSet: map[string][]byte{"fabcar:car1": nil}})
+	gt.Expect(err).NotTo(HaveOccurred())
+	time.Sleep(20 * time.Millisecond)
+
+	proof, err := store.Prepare(ctx, &PrepareRequest{TxID: "tx2", ShardID: "fabcar", ReadSet: map[string][]byte{"fabcar:car1": nil}})
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(proof.HasDependency).To(BeFalse())
+	gt.Expect(store.Reservations("fabcar")).To(Equal(0))
+}
+
+func TestLocalDependencyStoreRequestTTL(t *testing.T) {
+	gt := NewGomegaWithT(t)
+	ctx := context.Background()
+
+	store := NewLocalDependencyStore(time.Minute)
+	_, err := store.Prepare(ctx, &PrepareRequest{TxID: "tx1", ShardID: "fabcar", WriteSet: map[string][]byte{"fabcar:car1": nil}, TTL: 10 * time.Millisecond})
+	gt.Expect(err).NotTo(HaveOccurred())
+	_, err = store.Prepare(ctx, &PrepareRequest{TxID: "tx2", ShardID: "fabcar", WriteSet: map[string][]byte{"fabcar:car2": nil}})
+	gt.Expect(err).NotTo(HaveOccurred())
+	time.Sleep(20 * time.Millisecond)
+
+	// the reservation with a TTL of its own expired, the other did not
+	proof, err := store.Prepare(ctx, &PrepareRequest{TxID: "tx3", ShardID: "fabcar", ReadSet: map[string][]byte{"fabcar:car1": nil, "fabcar:car2": nil}})
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(proof.DependentTxID).To(Equal("tx2"))
+}
@@ -0,0 +1,261 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"go.etcd.io/etcd/raft/v3/raftpb"
+)
+
+// peerUpdater is told the addresses of replicas that join a shard at runtime
+// and the IDs of replicas that leave it
+type peerUpdater interface {
+	UpdatePeers(peers PeerConfig)
+	RemovePeer(nodeID uint64)
+}
+
+// AddReplica adds the node with the given ID, reachable at addr, as a voting
+// replica of the shard. It returns once the change is applied locally.
+func (sl *ShardLeader) AddReplica(ctx context.Context, id uint64, addr string) error {
+	if id == 0 {
+		return fmt.Errorf("invalid replica ID 0 for shard %s", sl.shardID)
+	}
+	if addr == "" {
+		return fmt.Errorf("missing address of replica %d for shard %s", id, sl.shardID)
+	}
+	return sl.proposeConfChange(ctx, raftpb.ConfChange{
+		Type:    raftpb.ConfChangeAddNode,
+		NodeID:  id,
+		Context: []byte(addr),
+	})
+}
+
+// RemoveReplica removes the node with the given ID from the shard. It returns
+// once the change is applied locally.
+func (sl *ShardLeader) RemoveReplica(ctx context.Context, id uint64) error {
+	if !sl.isMember(id) {
+		return fmt.Errorf("node %d is not a replica of shard %s", id, sl.shardID)
+	}
+	return sl.proposeConfChange(ctx, raftpb.ConfChange{
+		Type:   raftpb.ConfChangeRemoveNode,
+		NodeID: id,
+	})
+}
+
+// AddLearner adds the node with the given ID, reachable at addr, as a
+// non-voting learner. A learner receives the dependency log but does not
+// count towards quorum until it is promoted. It returns once the change is
+// applied locally.
+func (sl *ShardLeader) AddLearner(ctx context.Context, id uint64, addr string) error {
+	if id == 0 {
+		return fmt.Errorf("invalid replica ID 0 for shard %s", sl.shardID)
+	}
+	if addr == "" {
+		return fmt.Errorf("missing address of learner %d for shard %s", id, sl.shardID)
+	}
+	if sl.isMember(id) {
+		return fmt.Errorf("node %d is already a replica of shard %s", id, sl.shardID)
+	}
+	return sl.proposeConfChange(ctx, raftpb.ConfChange{
+		Type:    raftpb.ConfChangeAddLearnerNode,
+		NodeID:  id,
+		Context: []byte(addr),
+	})
+}
+
+// PromoteLearner turns the learner with the given ID into a voting replica.
+// It returns once the change is applied locally.
+func (sl *ShardLeader) PromoteLearner(ctx context.Context, id uint64) error {
+	sl.mu.RLock()
+	learner := containsID(sl.members.Learners, id)
+	addr := sl.peerAddrs[id]
+	sl.mu.RUnlock()
+	if !learner {
+		return fmt.Errorf("node %d is not a learner of shard %s", id, sl.shardID)
+	}
+	return sl.proposeConfChange(ctx, raftpb.ConfChange{
+		Type:    raftpb.ConfChangeAddNode,
+		NodeID:  id,
+		Context: []byte(addr),
+	})
+}
+
+// ShardMembership describes the replica set of a shard as seen by one replica
+type ShardMembership struct {
+	ReplicaID uint64
+	Voters    []uint64
+	Learners  []uint64
+	// Learner is set while the local replica is a non-voting learner
+	Learner bool
+}
+
+// Membership returns the voters and learners of the shard
+func (sl *ShardLeader) Membership() ShardMembership {
+	sl.mu.RLock()
+	defer sl.mu.RUnlock()
+	return ShardMembership{
+		ReplicaID: sl.config.ReplicaID,
+		Voters:    append([]uint64(nil), sl.members.Voters...),
+		Learners:  append([]uint64(nil), sl.members.Learners...),
+		Learner:   containsID(sl.members.Learners, sl.config.ReplicaID),
+	}
+}
+
+// Replicas returns the IDs of the voting replicas of the shard
+func (sl *ShardLeader) Replicas() []uint64 {
+	sl.mu.RLock()
+	defer sl.mu.RUnlock()
+	return append([]uint64(nil), sl.members.Voters...)
+}
+
+// proposeConfChange proposes the membership change and waits for runApply to
+// apply it
+func (sl *ShardLeader) proposeConfChange(ctx context.Context, cc raftpb.ConfChange) error {
+	// Tag the change with this replica's ID so that changes proposed by other
+	// replicas never release a local waiter
+	cc.ID = sl.config.ReplicaID<<32 | atomic.AddUint64(&sl.confChangeSeq, 1)
+	appliedC := make(chan struct{})
+
+	sl.mu.Lock()
+	sl.confChangeWaiters[cc.ID] = appliedC
+	sl.mu.Unlock()
+	defer func() {
+		sl.mu.Lock()
+		delete(sl.confChangeWaiters, cc.ID)
+		sl.mu.Unlock()
+	}()
+
+	if err := sl.node.ProposeConfChange(ctx, cc); err != nil {
+		return fmt.Errorf("failed to propose %s of node %d to shard %s: %v", cc.Type, cc.NodeID, sl.shardID, err)
+	}
+
+	select {
+	case <-appliedC:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("timeout waiting for %s of node %d to apply on shard %s", cc.Type, cc.NodeID, sl.shardID)
+	case <-sl.stopC:
+		return fmt.Errorf("shard %s is stopped", sl.shardID)
+	}
+}
+
+// applyConfChange applies a committed membership change. It must only be
+// called from runApply.
+func (sl *ShardLeader) applyConfChange(entry raftpb.Entry) {
+	var cc raftpb.ConfChange
+	if err := cc.Unmarshal(entry.Data); err != nil {
+		logger.Errorf("Shard %s: Failed to unmarshal conf change at index %d: %v", sl.shardID, entry.Index, err)
+		return
+	}
+	sl.confState = *sl.node.ApplyConfChange(cc)
+
+	sl.mu.Lock()
+	sl.members = sl.confState
+	switch cc.Type {
+	case raftpb.ConfChangeAddNode, raftpb.ConfChangeAddLearnerNode:
+		if len(cc.Context) > 0 {
+			sl.peerAddrs[cc.NodeID] = string(cc.Context)
+		}
+	case raftpb.ConfChangeRemoveNode:
+		delete(sl.peerAddrs, cc.NodeID)
+	}
+	appliedC := sl.confChangeWaiters[cc.ID]
+	delete(sl.confChangeWaiters, cc.ID)
+	sl.mu.Unlock()
+
+	if cc.NodeID == sl.config.ReplicaID && cc.Type == raftpb.ConfChangeRemoveNode {
+		logger.Warnf("Shard %s: This replica was removed from the shard", sl.shardID)
+	}
+	logger.Infof("Shard %s: Applied %s of node %d at index %d, voters are now %v and learners %v",
+		sl.shardID, cc.Type, cc.NodeID, entry.Index, sl.confState.Voters, sl.confState.Learners)
+
+	sl.notifyPeers()
+	if cc.Type == raftpb.ConfChangeRemoveNode && cc.NodeID != sl.config.ReplicaID {
+		sl.notifyRemoved(cc.NodeID)
+	}
+	if appliedC != nil {
+		close(appliedC)
+	}
+}
+
+// setPeerUpdater registers the updater and hands it the replica addresses
+// learned so far, since replayed membership changes may precede it
+func (sl *ShardLeader) setPeerUpdater(updater peerUpdater) {
+	sl.mu.Lock()
+	sl.peerUpdater = updater
+	sl.mu.Unlock()
+	sl.notifyPeers()
+}
+
+// notifyPeers passes the known replica addresses to the peer updater
+func (sl *ShardLeader) notifyPeers() {
+	sl.mu.RLock()
+	updater := sl.peerUpdater
+	peers := sl.copyPeerAddrsLocked()
+	sl.mu.RUnlock()
+
+	if updater != nil && len(peers) > 0 {
+		updater.UpdatePeers(peers)
+	}
+}
+
+// notifyRemoved tells the peer updater that the node left the shard
+func (sl *ShardLeader) notifyRemoved(nodeID uint64) {
+	sl.mu.RLock()
+	updater := sl.peerUpdater
+	sl.mu.RUnlock()
+
+	if updater != nil {
+		updater.RemovePeer(nodeID)
+	}
+}
+
+// copyPeerAddrsLocked copies the replica addresses. The caller must hold mu.
+func (sl *ShardLeader) copyPeerAddrsLocked() PeerConfig {
+	peers := make(PeerConfig, len(sl.peerAddrs))
+	for id, addr := range sl.peerAddrs {
+		peers[id] = addr
+	}
+	return peers
+}
+
+// ReplicaAddress returns the address of a replica of the shard, as added by
+// a membership change or else as listed in the topology. It returns "" for
+// unknown replicas.
+func (sm *ShardManager) ReplicaAddress(shardID string, replicaID uint64) string {
+	if shard, ok := sm.lookupShard(shardID); ok {
+		shard.mu.RLock()
+		addr := shard.peerAddrs[replicaID]
+		shard.mu.RUnlock()
+		if addr != "" {
+			return addr
+		}
+	}
+	if set, ok := sm.Topology().ReplicaSet(shardID); ok {
+		return set.Replicas[replicaID]
+	}
+	return ""
+}
+
+// isMember reports whether the node is a voter or learner of the shard
+func (sl *ShardLeader) isMember(id uint64) bool {
+	sl.mu.RLock()
+	defer sl.mu.RUnlock()
+	return containsID(sl.members.Voters, id) || containsID(sl.members.Learners, id)
+}
+
+func containsID(ids []uint64, id uint64) bool {
+	for _, other := range ids {
+		if other == id {
+			return true
+		}
+	}
+	return false
+}
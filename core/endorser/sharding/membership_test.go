@@ -0,0 +1,189 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc/connectivity"
+)
+
+func TestShardAddReplica(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	transport := NewTransport(1, "127.0.0.1:7051", PeerConfig{1: "127.0.0.1:7051"})
+	defer transport.Stop()
+
+	sl, err := NewShardLeader(ShardConfig{
+		ShardID:    "fabcar",
+		ReplicaIDs: []uint64{1},
+		ReplicaID:  1,
+	}, DefaultBatchTimeout, DefaultBatchMaxSize)
+	gt.Expect(err).NotTo(HaveOccurred())
+	defer sl.Stop()
+	transport.RegisterShard("fabcar", sl)
+
+	gt.Eventually(sl.Replicas, 5*time.Second).Should(Equal([]uint64{1}))
+
+	// A single replica campaigns only after its election timeout, and adding
+	// a voter to it needs no other replica to commit
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	gt.Eventually(func() error {
+		return sl.AddReplica(ctx, 2, "127.0.0.1:7052")
+	}, 30*time.Second, time.Second).Should(Succeed())
+
+	gt.Expect(sl.Replicas()).To(ConsistOf(uint64(1), uint64(2)))
+	transport.mu.RLock()
+	gt.Expect(transport.peers).To(HaveKeyWithValue(uint64(2), "127.0.0.1:7052"))
+	transport.mu.RUnlock()
+
+	backup, err := sl.Backup(time.Second)
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(backup.Peers).To(Equal(PeerConfig{2: "127.0.0.1:7052"}))
+
+	err = sl.RemoveReplica(ctx, 3)
+	gt.Expect(err).To(MatchError("node 3 is not a replica of shard fabcar"))
+	err = sl.AddReplica(ctx, 3, "")
+	gt.Expect(err).To(MatchError("missing address of replica 3 for shard fabcar"))
+}
+
+func TestTransportUpdatePeers(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	transport := NewTransport(1, "127.0.0.1:7051", PeerConfig{1: "127.0.0.1:7051", 2: "127.0.0.1:7052"})
+	defer transport.Stop()
+
+	_, err := transport.getClient(2)
+	gt.Expect(err).NotTo(HaveOccurred())
+
+	// An unchanged address keeps the connection
+	transport.UpdatePeers(PeerConfig{2: "127.0.0.1:7052"})
+	gt.Expect(transport.clients).To(HaveKey(uint64(2)))
+
+	transport.UpdatePeers(PeerConfig{2: "127.0.0.1:8052", 3: "127.0.0.1:7053"})
+	gt.Expect(transport.clients).NotTo(HaveKey(uint64(2)))
+	gt.Expect(transport.peers).To(Equal(PeerConfig{
+		1: "127.0.0.1:7051",
+		2: "127.0.0.1:8052",
+		3: "127.0.0.1:7053",
+	}))
+
+	_, err = transport.getClient(3)
+	gt.Expect(err).NotTo(HaveOccurred())
+}
+
+func TestTransportRemovePeer(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	transport := NewTransport(1, "127.0.0.1:7051", PeerConfig{1: "127.0.0.1:7051"})
+	defer transport.Stop()
+
+	sl, err := NewShardLeader(ShardConfig{
+		ShardID:    "fabcar",
+		ReplicaIDs: []uint64{1},
+		ReplicaID:  1,
+	}, DefaultBatchTimeout, DefaultBatchMaxSize)
+	gt.Expect(err).NotTo(HaveOccurred())
+	defer sl.Stop()
+	transport.RegisterShard("fabcar", sl)
+
+	// The transport learns the replicas a shard is created with
+	marbles, err := NewShardLeader(ShardConfig{
+		ShardID:      "marbles",
+		ReplicaIDs:   []uint64{1, 3},
+		ReplicaNodes: []string{"127.0.0.1:7051", "127.0.0.1:7053"},
+		ReplicaID:    1,
+	}, DefaultBatchTimeout, DefaultBatchMaxSize)
+	gt.Expect(err).NotTo(HaveOccurred())
+	defer marbles.Stop()
+	transport.RegisterShard("marbles", marbles)
+	transport.mu.RLock()
+	gt.Expect(transport.peers).To(HaveKeyWithValue(uint64(3), "127.0.0.1:7053"))
+	transport.mu.RUnlock()
+
+	// Learners do not count towards quorum, so the single voter of fabcar
+	// commits their addition and removal on its own
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	gt.Eventually(func() error {
+		return sl.AddLearner(ctx, 2, "127.0.0.1:7052")
+	}, 30*time.Second, time.Second).Should(Succeed())
+
+	_, release, err := transport.acquireClient(2)
+	gt.Expect(err).NotTo(HaveOccurred())
+	transport.mu.RLock()
+	conn := transport.clientConn[2]
+	transport.mu.RUnlock()
+
+	gt.Expect(sl.RemoveReplica(ctx, 2)).To(Succeed())
+	transport.mu.RLock()
+	gt.Expect(transport.peers).NotTo(HaveKey(uint64(2)))
+	gt.Expect(transport.clients).NotTo(HaveKey(uint64(2)))
+	transport.mu.RUnlock()
+	_, err = transport.getClient(2)
+	gt.Expect(err).To(MatchError("unknown peer 2"))
+
+	// The connection is closed once the send in flight on it completed
+	gt.Consistently(conn.GetState, 200*time.Millisecond).ShouldNot(Equal(connectivity.Shutdown))
+	release()
+	gt.Eventually(conn.GetState).Should(Equal(connectivity.Shutdown))
+
+	// A node is kept while another shard replicates to it
+	transport.RemovePeer(3)
+	transport.mu.RLock()
+	gt.Expect(transport.peers).To(HaveKey(uint64(3)))
+	transport.mu.RUnlock()
+
+	transport.UnregisterShard("marbles")
+	transport.RemovePeer(3)
+	transport.mu.RLock()
+	gt.Expect(transport.peers).To(Equal(PeerConfig{1: "127.0.0.1:7051"}))
+	transport.mu.RUnlock()
+}
+
+func TestShardLearnerPromotion(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	sl, err := NewShardLeader(ShardConfig{
+		ShardID:    "fabcar",
+		ReplicaIDs: []uint64{1},
+		ReplicaID:  1,
+	}, DefaultBatchTimeout, DefaultBatchMaxSize)
+	gt.Expect(err).NotTo(HaveOccurred())
+	defer sl.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	gt.Eventually(func() error {
+		return sl.AddLearner(ctx, 2, "127.0.0.1:7052")
+	}, 30*time.Second, time.Second).Should(Succeed())
+
+	gt.Expect(sl.Membership()).To(Equal(ShardMembership{
+		ReplicaID: 1,
+		Voters:    []uint64{1},
+		Learners:  []uint64{2},
+	}))
+	err = sl.AddLearner(ctx, 2, "127.0.0.1:7052")
+	gt.Expect(err).To(MatchError("node 2 is already a replica of shard fabcar"))
+	err = sl.PromoteLearner(ctx, 1)
+	gt.Expect(err).To(MatchError("node 1 is not a learner of shard fabcar"))
+
+	// Learners do not count towards quorum, so the single voter commits the
+	// promotion on its own
+	gt.Expect(sl.PromoteLearner(ctx, 2)).To(Succeed())
+	membership := sl.Membership()
+	gt.Expect(membership.Voters).To(ConsistOf(uint64(1), uint64(2)))
+	gt.Expect(membership.Learners).To(BeEmpty())
+
+	backup, err := sl.Backup(time.Second)
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(backup.Peers).To(Equal(PeerConfig{2: "127.0.0.1:7052"}))
+}
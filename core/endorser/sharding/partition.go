@@ -0,0 +1,165 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"fmt"
+	"hash/fnv"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ShardPartitionsEnvVar enables key-hash sharding. It accepts either a single
+// partition count applied to every contract, or a comma-separated list of
+// contract=count pairs where an entry without a contract name sets the
+// default, e.g. "fabcar=8". Contracts with one partition, the default, map to
+// a single shard named after the contract.
+const ShardPartitionsEnvVar = "FABRIC_SHARD_PARTITIONS"
+
+// PartitionSeparator separates the contract name from the partition number in
+// the ID of a partitioned shard, e.g. "fabcar#3"
+const PartitionSeparator = "#"
+
+// ChannelSeparator separates the channel from the rest of the ID of a shard
+// of a contract on that channel, e.g. "mychannel/fabcar#3". Shards without a
+// channel are shared by the contracts of that name on every channel.
+const ChannelSeparator = "/"
+
+// KeyPartitioner maps the keys of a contract onto the shards that track them
+type KeyPartitioner struct {
+	// Default is the partition count of contracts not listed in Partitions
+	Default    int
+	Partitions map[string]int
+}
+
+// NewKeyPartitionerFromEnv returns the partitioner configured via ShardPartitionsEnvVar
+func NewKeyPartitionerFromEnv() *KeyPartitioner {
+	p := &KeyPartitioner{Default: 1, Partitions: make(map[string]int)}
+
+	spec := os.Getenv(ShardPartitionsEnvVar)
+	for _, item := range strings.Split(spec, ",") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		name, value := "", item
+		if kv := strings.SplitN(item, "=", 2); len(kv) == 2 {
+			name, value = strings.TrimSpace(kv[0]), kv[1]
+		}
+		count, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || count < 1 {
+			logger.Warningf("Ignoring %s entry %q: invalid partition count", ShardPartitionsEnvVar, item)
+			continue
+		}
+		if name == "" {
+			p.Default = count
+		} else {
+			p.Partitions[name] = count
+		}
+	}
+	return p
+}
+
+// PartitionCount returns the number of shards the keys of the contract are spread over
+func (p *KeyPartitioner) PartitionCount(contract string) int {
+	if p == nil {
+		return 1
+	}
+	if count, ok := p.Partitions[contract]; ok {
+		return count
+	}
+	if p.Default < 1 {
+		return 1
+	}
+	return p.Default
+}
+
+// ShardForKey returns the ID of the shard tracking the key of the contract
+func (p *KeyPartitioner) ShardForKey(contract, key string) string {
+	count := p.PartitionCount(contract)
+	if count == 1 {
+		return contract
+	}
+	return PartitionShardID(contract, PartitionOfKey(key, count))
+}
+
+// ShardsOfContract returns the IDs of all the shards tracking keys of the
+// contract, which a range of its keys may span since keys are hashed
+func (p *KeyPartitioner) ShardsOfContract(contract string) []string {
+	count := p.PartitionCount(contract)
+	if count == 1 {
+		return []string{contract}
+	}
+	shards := make([]string, count)
+	for i := range shards {
+		shards[i] = PartitionShardID(contract, i)
+	}
+	return shards
+}
+
+// PartitionShardID returns the ID of the given partition of the contract
+func PartitionShardID(contract string, partition int) string {
+	return fmt.Sprintf("%s%s%d", contract, PartitionSeparator, partition)
+}
+
+// ChannelShardID returns the ID of the shard of the channel otherwise named
+// shardID, which is shardID itself when the channel is empty
+func ChannelShardID(channel, shardID string) string {
+	if channel == "" {
+		return shardID
+	}
+	return channel + ChannelSeparator + shardID
+}
+
+// ChannelOfShard returns the channel a shard belongs to, or "" for a shard
+// shared by every channel
+func ChannelOfShard(shardID string) string {
+	if i := strings.Index(shardID, ChannelSeparator); i >= 0 {
+		return shardID[:i]
+	}
+	return ""
+}
+
+// UnscopedShardID returns the ID of the shard without its channel
+func UnscopedShardID(shardID string) string {
+	if i := strings.Index(shardID, ChannelSeparator); i >= 0 {
+		return shardID[i+len(ChannelSeparator):]
+	}
+	return shardID
+}
+
+// ContractOfShard returns the contract a shard belongs to, whatever its
+// channel
+func ContractOfShard(shardID string) string {
+	shardID = UnscopedShardID(shardID)
+	if i := strings.LastIndex(shardID, PartitionSeparator); i >= 0 {
+		if _, err := strconv.Atoi(shardID[i+len(PartitionSeparator):]); err == nil {
+			return shardID[:i]
+		}
+	}
+	return shardID
+}
+
+// PartitionOfKey maps the key onto one of count partitions with jump
+// consistent hashing, so that growing the count only moves the keys that land
+// on the new partitions
+func PartitionOfKey(key string, count int) int {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return jumpHash(h.Sum64(), count)
+}
+
+// jumpHash is the jump consistent hash of Lamping and Veach
+func jumpHash(key uint64, buckets int) int {
+	var b, j int64 = -1, 0
+	for j < int64(buckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
@@ -0,0 +1,104 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestKeyPartitionerFromEnv(t *testing.T) {
+	gt := NewGomegaWithT(t)
+	defer os.Unsetenv(ShardPartitionsEnvVar)
+
+	os.Setenv(ShardPartitionsEnvVar, "2,fabcar=8,supply=0")
+	p := NewKeyPartitionerFromEnv()
+	gt.Expect(p.PartitionCount("fabcar")).To(Equal(8))
+	gt.Expect(p.PartitionCount("other")).To(Equal(2))
+	gt.Expect(p.PartitionCount("supply")).To(Equal(2))
+
+	os.Unsetenv(ShardPartitionsEnvVar)
+	p = NewKeyPartitionerFromEnv()
+	gt.Expect(p.PartitionCount("fabcar")).To(Equal(1))
+	gt.Expect(p.ShardForKey("fabcar", "car1")).To(Equal("fabcar"))
+}
+
+func TestKeyPartitionerShardForKey(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	p := &KeyPartitioner{Default: 1, Partitions: map[string]int{"fabcar": 4}}
+	gt.Expect(p.ShardForKey("marbles", "marble1")).To(Equal("marbles"))
+
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("car%d", i)
+		shardID := p.ShardForKey("fabcar", key)
+		gt.Expect(shardID).To(Equal(p.ShardForKey("fabcar", key)))
+		gt.Expect(ContractOfShard(shardID)).To(Equal("fabcar"))
+		seen[shardID] = true
+	}
+	gt.Expect(seen).To(HaveLen(4))
+
+	// a range of keys may span every partition
+	gt.Expect(p.ShardsOfContract("marbles")).To(Equal([]string{"marbles"}))
+	gt.Expect(p.ShardsOfContract("fabcar")).To(HaveLen(4))
+	for shardID := range seen {
+		gt.Expect(p.ShardsOfContract("fabcar")).To(ContainElement(shardID))
+	}
+}
+
+func TestPartitionOfKeyIsConsistent(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	// Growing from 4 to 5 partitions only moves keys onto the new partition
+	moved := 0
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key%d", i)
+		before, after := PartitionOfKey(key, 4), PartitionOfKey(key, 5)
+		gt.Expect(before).To(BeNumerically("<", 4))
+		if before != after {
+			gt.Expect(after).To(Equal(4))
+			moved++
+		}
+	}
+	gt.Expect(moved).To(BeNumerically("~", 200, 60))
+}
+
+func TestContractOfShard(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	gt.Expect(ContractOfShard("fabcar")).To(Equal("fabcar"))
+	gt.Expect(ContractOfShard(PartitionShardID("fabcar", 3))).To(Equal("fabcar"))
+	gt.Expect(ContractOfShard("fab#car")).To(Equal("fab#car"))
+	gt.Expect(ContractOfShard(ChannelShardID("mychannel", PartitionShardID("fabcar", 3)))).To(Equal("fabcar"))
+
+	topology := legacyShardTopology(map[string][]string{"fabcar": {"peer0:7051"}, "fabcar#1": {"peer1:7051"}}, "peer0:7051")
+	set, ok := topology.ReplicaSet("fabcar#0")
+	gt.Expect(ok).To(BeTrue())
+	gt.Expect(set.Replicas).To(Equal(map[uint64]string{1: "peer0:7051"}))
+	set, _ = topology.ReplicaSet("fabcar#1")
+	gt.Expect(set.Replicas).To(Equal(map[uint64]string{2: "peer1:7051"}))
+	_, ok = topology.ReplicaSet("marbles#0")
+	gt.Expect(ok).To(BeFalse())
+}
+
+func TestChannelShardID(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	shardID := ChannelShardID("mychannel", PartitionShardID("fabcar", 3))
+	gt.Expect(shardID).To(Equal("mychannel/fabcar#3"))
+	gt.Expect(ChannelOfShard(shardID)).To(Equal("mychannel"))
+	gt.Expect(UnscopedShardID(shardID)).To(Equal("fabcar#3"))
+
+	// shards without a channel are shared by every channel
+	gt.Expect(ChannelShardID("", "fabcar")).To(Equal("fabcar"))
+	gt.Expect(ChannelOfShard("fabcar")).To(BeEmpty())
+	gt.Expect(UnscopedShardID("fabcar")).To(Equal("fabcar"))
+}
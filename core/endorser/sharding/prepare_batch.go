@@ -0,0 +1,191 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// BatchDependencyStore is a DependencyStore that prepares several requests
+// on a shard in one submission
+type BatchDependencyStore interface {
+	DependencyStore
+	// PrepareBatch prepares the requests, all on the shard, and returns
+	// their proofs in the order of the requests. It fails as a whole when
+	// any of them fails.
+	PrepareBatch(ctx context.Context, shardID string, reqs []*PrepareRequest) ([]*PrepareProof, error)
+}
+
+// PrepareBatch implements BatchDependencyStore. A batch for a shard this peer
+// does not replicate is sent to a replica in one request over the shard REST
+// API.
+func (sm *ShardManager) PrepareBatch(ctx context.Context, shardID string, reqs []*PrepareRequest) ([]*PrepareProof, error) {
+	if !sm.IsReplica(shardID) {
+		logger.Debugf("Requesting remote proofs for %d txs from shard %s", len(reqs), shardID)
+		return sm.RequestRemoteProofs(ctx, shardID, reqs)
+	}
+
+	shard, err := sm.GetOrCreateShard(shardID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get shard %s: %v", shardID, err)
+	}
+	return shard.PrepareBatch(ctx, reqs)
+}
+
+// RequestRemoteProofs requests the proofs of a batch of requests from a
+// replica of the shard over HTTP, picked like RequestRemoteProof does
+func (sm *ShardManager) RequestRemoteProofs(ctx context.Context, shardID string, reqs []*PrepareRequest) ([]*PrepareProof, error) {
+	targetAddr, err := sm.remoteTarget(ctx, shardID)
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(reqs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal requests: %v", err)
+	}
+	resp, err := sm.post(ctx, targetAddr, "/propose/batch", body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var proofs []*PrepareProof
+	if err := json.NewDecoder(resp.Body).Decode(&proofs); err != nil {
+		return nil, fmt.Errorf("failed to decode proofs: %v", err)
+	}
+	if len(proofs) != len(reqs) {
+		return nil, fmt.Errorf("replica %s returned %d proofs for %d requests", targetAddr, len(proofs), len(reqs))
+	}
+	return proofs, nil
+}
+
+// handleProposeBatch serves POST /propose/batch: it prepares a JSON array of
+// requests on their shard and answers with the array of their proofs
+func (sm *ShardManager) handleProposeBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var reqs []*PrepareRequest
+	if err := json.NewDecoder(r.Body).Decode(&reqs); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(reqs) == 0 {
+		http.Error(w, "empty batch", http.StatusBadRequest)
+		return
+	}
+	shardID := reqs[0].ShardID
+	for _, req := range reqs {
+		if req.ShardID != shardID {
+			http.Error(w, fmt.Sprintf("batch mixes shards %s and %s", shardID, req.ShardID), http.StatusBadRequest)
+			return
+		}
+	}
+
+	shard, err := sm.GetOrCreateShard(shardID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second) // DefaultPrepareTimeout
+	defer cancel()
+
+	proofs, err := shard.PrepareBatch(ctx, reqs)
+	switch {
+	case errors.Is(err, ErrQueueFull):
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+	case errors.Is(err, ErrStopped):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusGatewayTimeout)
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(proofs)
+	}
+}
+
+// PrepareBatch returns the proofs of the requests, in order. The requests
+// that Prepare would propose are queued together and flushed at once, so
+// that they are committed in one Raft entry unless more requests were queued
+// meanwhile than fit in a batch. The others are answered like Prepare.
+func (sl *ShardLeader) PrepareBatch(ctx context.Context, reqs []*PrepareRequest) ([]*PrepareProof, error) {
+	select {
+	case <-sl.stopC:
+		return nil, fmt.Errorf("shard %s: %w", sl.shardID, ErrStopped)
+	default:
+	}
+
+	sl.enqueueBatch(reqs)
+
+	proofs := make([]*PrepareProof, len(reqs))
+	errs := make([]error, len(reqs))
+	var wg sync.WaitGroup
+	for i, req := range reqs {
+		wg.Add(1)
+		go func(i int, req *PrepareRequest) {
+			defer wg.Done()
+			proofs[i], errs[i] = sl.Prepare(ctx, req)
+		}(i, req)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return proofs, nil
+}
+
+// enqueueBatch queues the requests that Prepare would propose and whose
+// proof is not known yet, and flushes them
+func (sl *ShardLeader) enqueueBatch(reqs []*PrepareRequest) {
+	proposed := make([]*PrepareRequest, 0, len(reqs))
+	for _, req := range reqs {
+		if sl.answersFromReadIndex(req) || sl.HasProof(req.TxID) {
+			continue
+		}
+		if !sl.config.QuorumCert {
+			if _, ok := sl.CachedReadOnlyProof(req); ok {
+				continue
+			}
+		}
+		proposed = append(proposed, req)
+	}
+	if len(proposed) == 0 {
+		return
+	}
+
+	now := time.Now()
+	sl.batchLock.Lock()
+	for _, req := range proposed {
+		if !sl.pendingTxIDs[req.TxID] {
+			sl.batchQueue = append(sl.batchQueue, req)
+			sl.pendingTxIDs[req.TxID] = true
+			sl.latency.enqueued(req.TxID, now)
+		}
+	}
+	sl.batchLock.Unlock()
+
+	sl.flushBatch()
+}
+
+// queued reports whether the transaction was queued or proposed on this
+// replica and its proof is not applied yet
+func (sl *ShardLeader) queued(txID string) bool {
+	sl.batchLock.Lock()
+	defer sl.batchLock.Unlock()
+	return sl.pendingTxIDs[txID]
+}
@@ -0,0 +1,68 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestShardPrepareBatch(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	// The batcher alone would wait a minute before proposing
+	sl, err := NewShardLeader(ShardConfig{ShardID: "fabcar", ReplicaIDs: []uint64{1}, ReplicaID: 1}, time.Minute, DefaultBatchMaxSize)
+	gt.Expect(err).NotTo(HaveOccurred())
+	defer sl.Stop()
+	gt.Eventually(func() uint64 { return sl.GetStatus().LeaderID }, 30*time.Second, 100*time.Millisecond).Should(Equal(uint64(1)))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	proofs, err := sl.PrepareBatch(ctx, []*PrepareRequest{
+		{TxID: "tx1", ShardID: "fabcar", WriteSet: map[string][]byte{"car1": []byte("v1")}},
+		{TxID: "tx2", ShardID: "fabcar", WriteSet: map[string][]byte{"car1": []byte("v2")}},
+		{TxID: "tx3", ShardID: "fabcar", ReadSet: map[string][]byte{"car1": nil}},
+	})
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(proofs).To(HaveLen(3))
+	for i, txID := range []string{"tx1", "tx2", "tx3"} {
+		gt.Expect(proofs[i].TxID).To(Equal(txID))
+	}
+	// the writes were committed in one entry, in order
+	gt.Expect(proofs[1].CommitIndex).To(Equal(proofs[0].CommitIndex))
+	gt.Expect(proofs[1].DependentTxID).To(Equal("tx1"))
+	gt.Expect(proofs[2].DependentTxID).To(Equal("tx2"))
+	gt.Expect(sl.queued("tx1")).To(BeFalse())
+
+	// a retried batch gets the same proofs
+	again, err := sl.PrepareBatch(ctx, []*PrepareRequest{
+		{TxID: "tx2", ShardID: "fabcar", WriteSet: map[string][]byte{"car1": []byte("v2")}},
+	})
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(again[0].CommitIndex).To(Equal(proofs[1].CommitIndex))
+}
+
+func TestProposeBatchHandler(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	sm := &ShardManager{}
+	post := func(method, body string) int {
+		rec := httptest.NewRecorder()
+		sm.handleProposeBatch(rec, httptest.NewRequest(method, "/propose/batch", strings.NewReader(body)))
+		return rec.Code
+	}
+	gt.Expect(post(http.MethodGet, "")).To(Equal(http.StatusMethodNotAllowed))
+	gt.Expect(post(http.MethodPost, `{`)).To(Equal(http.StatusBadRequest))
+	gt.Expect(post(http.MethodPost, `[]`)).To(Equal(http.StatusBadRequest))
+	gt.Expect(post(http.MethodPost, `[{"TxID":"tx1","ShardID":"fabcar"},{"TxID":"tx2","ShardID":"marbles"}]`)).To(Equal(http.StatusBadRequest))
+}
@@ -0,0 +1,131 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// VerifyPrepareProof checks that the proof is complete and that its signature
+// covers the shard, commit index and transaction it claims. Signatures made
+// with replica keys are only checked for their form; a ProofVerifier checks
+// them against the keys.
+func VerifyPrepareProof(proof *PrepareProof) error {
+	if proof == nil || proof.TxID == "" || proof.ShardID == "" {
+		return fmt.Errorf("incomplete prepare proof")
+	}
+	if proof.SignerID != 0 {
+		if len(proof.Signature) != ed25519.SignatureSize {
+			return fmt.Errorf("malformed signature on proof of tx %s from shard %s", proof.TxID, proof.ShardID)
+		}
+		return nil
+	}
+	if string(proof.Signature) != string(signPrepareProof(proof.ShardID, proof.CommitIndex, proof.TxID)) {
+		return fmt.Errorf("invalid signature on proof of tx %s from shard %s", proof.TxID, proof.ShardID)
+	}
+	return nil
+}
+
+// ProofVerifier checks the signatures and quorum certificates of proofs
+// against the public keys of the shard replicas. It implements the
+// committer's ShardProofVerifier.
+type ProofVerifier struct {
+	keys map[uint64]ed25519.PublicKey
+	// quorum is the number of co-signatures a proof must carry; proofs
+	// need no quorum certificate when it is 0
+	quorum int
+}
+
+// NewProofVerifier returns a verifier trusting the given replica keys, keyed
+// by replica ID, that requires quorum co-signatures on every proof
+func NewProofVerifier(keys map[uint64]ed25519.PublicKey, quorum int) *ProofVerifier {
+	return &ProofVerifier{keys: keys, quorum: quorum}
+}
+
+// VerifyShardProof checks that the proof is signed by a known replica and,
+// if a quorum is required, co-signed by that many distinct known replicas
+func (v *ProofVerifier) VerifyShardProof(proof *PrepareProof) error {
+	if proof == nil || proof.TxID == "" || proof.ShardID == "" {
+		return fmt.Errorf("incomplete prepare proof")
+	}
+	digest := ProofDigest(proof.ShardID, proof.TxID, proof.CommitIndex, proof.Term)
+
+	key, ok := v.keys[proof.SignerID]
+	if !ok {
+		return fmt.Errorf("proof of tx %s from shard %s is signed by unknown replica %d", proof.TxID, proof.ShardID, proof.SignerID)
+	}
+	if !ed25519.Verify(key, digest, proof.Signature) {
+		return fmt.Errorf("invalid signature of replica %d on proof of tx %s from shard %s", proof.SignerID, proof.TxID, proof.ShardID)
+	}
+
+	if v.quorum == 0 {
+		return nil
+	}
+	signers := make(map[uint64]bool)
+	for _, sig := range proof.QuorumCert {
+		key, ok := v.keys[sig.ReplicaID]
+		if ok && !signers[sig.ReplicaID] && ed25519.Verify(key, digest, sig.Signature) {
+			signers[sig.ReplicaID] = true
+		}
+	}
+	if len(signers) < v.quorum {
+		return fmt.Errorf("proof of tx %s from shard %s carries %d valid co-signatures, %d required",
+			proof.TxID, proof.ShardID, len(signers), v.quorum)
+	}
+	return nil
+}
+
+// EncodeProofs serializes the proofs for embedding in the endorsement
+// response. Only the fields every replica agrees on are kept, along with the
+// signatures, and the proofs are ordered by shard, so endorsers served by the
+// same replicas produce identical bytes. The encoding contains neither ','
+// nor '=' and therefore fits in a DependencyInfo value.
+func EncodeProofs(proofs []*PrepareProof) (string, error) {
+	embedded := make([]*PrepareProof, 0, len(proofs))
+	for _, p := range proofs {
+		embedded = append(embedded, &PrepareProof{
+			TxID:            p.TxID,
+			ShardID:         p.ShardID,
+			CommitIndex:     p.CommitIndex,
+			Term:            p.Term,
+			Signature:       p.Signature,
+			SignerID:        p.SignerID,
+			QuorumCert:      p.QuorumCert,
+			DependentTxID:   p.DependentTxID,
+			HasDependency:   p.HasDependency,
+			ConflictTypes:   p.ConflictTypes,
+			DependencyChain: p.DependencyChain,
+			ConflictPolicy:  p.ConflictPolicy,
+			Rejected:        p.Rejected,
+			HLC:             p.HLC,
+		})
+	}
+	sort.Slice(embedded, func(i, j int) bool { return embedded[i].ShardID < embedded[j].ShardID })
+
+	data, err := json.Marshal(embedded)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// DecodeProofs reverses EncodeProofs
+func DecodeProofs(encoded string) ([]*PrepareProof, error) {
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode proofs: %v", err)
+	}
+	var proofs []*PrepareProof
+	if err := json.Unmarshal(data, &proofs); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal proofs: %v", err)
+	}
+	return proofs, nil
+}
@@ -0,0 +1,112 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestShardProposeBackpressure(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	// Without runRaft nothing drains the queue
+	sl := &ShardLeader{
+		shardID:  "fabcar",
+		proposeC: make(chan *PrepareRequest, 1),
+		stopC:    make(chan struct{}),
+	}
+
+	gt.Expect(sl.Propose(context.Background(), &PrepareRequest{TxID: "tx1"})).To(Succeed())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := sl.Propose(ctx, &PrepareRequest{TxID: "tx2"})
+	gt.Expect(errors.Is(err, ErrQueueFull)).To(BeTrue())
+	gt.Expect(err).To(MatchError("shard fabcar: propose queue is full"))
+
+	close(sl.stopC)
+	<-sl.proposeC
+	err = sl.Propose(context.Background(), &PrepareRequest{TxID: "tx2"})
+	gt.Expect(errors.Is(err, ErrStopped)).To(BeTrue())
+	gt.Expect(sl.proposeC).To(BeEmpty())
+}
+
+func TestShardQueueLength(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	config := ShardConfig{ShardID: "fabcar", ReplicaIDs: []uint64{1}, ReplicaID: 1}
+	sl, err := NewShardLeader(config, DefaultBatchTimeout, DefaultBatchMaxSize)
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(cap(sl.proposeC)).To(Equal(DefaultQueueLength))
+	sl.Stop()
+
+	config.QueueLength = 5
+	sl, err = NewShardLeader(config, DefaultBatchTimeout, DefaultBatchMaxSize)
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(cap(sl.proposeC)).To(Equal(5))
+	sl.Stop()
+
+	_, err = sl.ProposeAndWait(context.Background(), &PrepareRequest{TxID: "tx1"})
+	gt.Expect(errors.Is(err, ErrStopped)).To(BeTrue())
+}
+
+func TestShardRaftTuning(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	config := ShardConfig{ShardID: "fabcar", ReplicaIDs: []uint64{1}, ReplicaID: 1}
+	sl, err := NewShardLeader(config, DefaultBatchTimeout, DefaultBatchMaxSize)
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(cap(sl.messagesC)).To(Equal(DefaultMessageBufferSize))
+	sl.Stop()
+
+	config.MaxSizePerMsg = 64 * 1024
+	config.MaxInflightMsgs = 1024
+	config.MaxUncommittedEntriesSize = 1 << 20
+	config.MessageBufferSize = 16
+	sl, err = NewShardLeader(config, DefaultBatchTimeout, DefaultBatchMaxSize)
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(cap(sl.messagesC)).To(Equal(16))
+	sl.Stop()
+}
+
+func TestSlowApplyDoesNotBlockRaft(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	config := ShardConfig{ShardID: "fabcar", ReplicaIDs: []uint64{1}, ReplicaID: 1}
+	sl, err := NewShardLeader(config, DefaultBatchTimeout, DefaultBatchMaxSize)
+	gt.Expect(err).NotTo(HaveOccurred())
+	defer sl.Stop()
+	gt.Eventually(func() bool { return sl.GetStatus().IsLeader }, 30*time.Second, 100*time.Millisecond).Should(BeTrue())
+	term := sl.GetStatus().Term
+
+	// Stall the state machine; Raft keeps committing and ticking meanwhile
+	sl.variableMapLock.Lock()
+	applied := atomic.LoadUint64(&sl.appliedIndex)
+	for _, txID := range []string{"tx1", "tx2"} {
+		gt.Expect(sl.Propose(context.Background(), &PrepareRequest{
+			TxID:     txID,
+			ShardID:  "fabcar",
+			WriteSet: map[string][]byte{txID: []byte("v1")},
+		})).To(Succeed())
+	}
+	gt.Eventually(func() uint64 { return sl.GetStatus().CommitIndex }, 5*time.Second, 10*time.Millisecond).Should(BeNumerically(">", applied))
+	gt.Consistently(func() ShardStatus { return sl.GetStatus() }, time.Second, 100*time.Millisecond).Should(And(
+		HaveField("IsLeader", BeTrue()),
+		HaveField("Term", term),
+		HaveField("AppliedIndex", applied),
+	))
+	sl.variableMapLock.Unlock()
+
+	gt.Eventually(func() bool { return sl.HasProof("tx1") && sl.HasProof("tx2") }, 5*time.Second, 10*time.Millisecond).Should(BeTrue())
+	gt.Expect(sl.GetStatus().AppliedIndex).To(Equal(sl.GetStatus().CommitIndex))
+}
@@ -0,0 +1,191 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.5
+// 	protoc        v4.25.1
+// source: core/endorser/sharding/protos/batch.proto
+
+package protos
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// ProposalBatch carries serialized SignedProposals
+type ProposalBatch struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	SignedProposals [][]byte               `protobuf:"bytes,1,rep,name=signed_proposals,json=signedProposals,proto3" json:"signed_proposals,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *ProposalBatch) Reset() {
+	*x = ProposalBatch{}
+	mi := &file_core_endorser_sharding_protos_batch_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ProposalBatch) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ProposalBatch) ProtoMessage() {}
+
+func (x *ProposalBatch) ProtoReflect() protoreflect.Message {
+	mi := &file_core_endorser_sharding_protos_batch_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ProposalBatch.ProtoReflect.Descriptor instead.
+func (*ProposalBatch) Descriptor() ([]byte, []int) {
+	return file_core_endorser_sharding_protos_batch_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *ProposalBatch) GetSignedProposals() [][]byte {
+	if x != nil {
+		return x.SignedProposals
+	}
+	return nil
+}
+
+// ProposalResponseBatch carries the serialized ProposalResponse of each
+// proposal of the batch, in the same order
+type ProposalResponseBatch struct {
+	state             protoimpl.MessageState `protogen:"open.v1"`
+	ProposalResponses [][]byte               `protobuf:"bytes,1,rep,name=proposal_responses,json=proposalResponses,proto3" json:"proposal_responses,omitempty"`
+	unknownFields     protoimpl.UnknownFields
+	sizeCache         protoimpl.SizeCache
+}
+
+func (x *ProposalResponseBatch) Reset() {
+	*x = ProposalResponseBatch{}
+	mi := &file_core_endorser_sharding_protos_batch_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ProposalResponseBatch) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ProposalResponseBatch) ProtoMessage() {}
+
+func (x *ProposalResponseBatch) ProtoReflect() protoreflect.Message {
+	mi := &file_core_endorser_sharding_protos_batch_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ProposalResponseBatch.ProtoReflect.Descriptor instead.
+func (*ProposalResponseBatch) Descriptor() ([]byte, []int) {
+	return file_core_endorser_sharding_protos_batch_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ProposalResponseBatch) GetProposalResponses() [][]byte {
+	if x != nil {
+		return x.ProposalResponses
+	}
+	return nil
+}
+
+var File_core_endorser_sharding_protos_batch_proto protoreflect.FileDescriptor
+
+var file_core_endorser_sharding_protos_batch_proto_rawDesc = string([]byte{
+	0x0a, 0x29, 0x63, 0x6f, 0x72, 0x65, 0x2f, 0x65, 0x6e, 0x64, 0x6f, 0x72, 0x73, 0x65, 0x72, 0x2f,
+	0x73, 0x68, 0x61, 0x72, 0x64, 0x69, 0x6e, 0x67, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x73, 0x2f,
+	0x62, 0x61, 0x74, 0x63, 0x68, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x06, 0x70, 0x72, 0x6f,
+	0x74, 0x6f, 0x73, 0x22, 0x3a, 0x0a, 0x0d, 0x50, 0x72, 0x6f, 0x70, 0x6f, 0x73, 0x61, 0x6c, 0x42,
+	0x61, 0x74, 0x63, 0x68, 0x12, 0x29, 0x0a, 0x10, 0x73, 0x69, 0x67, 0x6e, 0x65, 0x64, 0x5f, 0x70,
+	0x72, 0x6f, 0x70, 0x6f, 0x73, 0x61, 0x6c, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0c, 0x52, 0x0f,
+	0x73, 0x69, 0x67, 0x6e, 0x65, 0x64, 0x50, 0x72, 0x6f, 0x70, 0x6f, 0x73, 0x61, 0x6c, 0x73, 0x22,
+	0x46, 0x0a, 0x15, 0x50, 0x72, 0x6f, 0x70, 0x6f, 0x73, 0x61, 0x6c, 0x52, 0x65, 0x73, 0x70, 0x6f,
+	0x6e, 0x73, 0x65, 0x42, 0x61, 0x74, 0x63, 0x68, 0x12, 0x2d, 0x0a, 0x12, 0x70, 0x72, 0x6f, 0x70,
+	0x6f, 0x73, 0x61, 0x6c, 0x5f, 0x72, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x73, 0x18, 0x01,
+	0x20, 0x03, 0x28, 0x0c, 0x52, 0x11, 0x70, 0x72, 0x6f, 0x70, 0x6f, 0x73, 0x61, 0x6c, 0x52, 0x65,
+	0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x73, 0x32, 0x5b, 0x0a, 0x0d, 0x42, 0x61, 0x74, 0x63, 0x68,
+	0x45, 0x6e, 0x64, 0x6f, 0x72, 0x73, 0x65, 0x72, 0x12, 0x4a, 0x0a, 0x10, 0x50, 0x72, 0x6f, 0x63,
+	0x65, 0x73, 0x73, 0x50, 0x72, 0x6f, 0x70, 0x6f, 0x73, 0x61, 0x6c, 0x73, 0x12, 0x15, 0x2e, 0x70,
+	0x72, 0x6f, 0x74, 0x6f, 0x73, 0x2e, 0x50, 0x72, 0x6f, 0x70, 0x6f, 0x73, 0x61, 0x6c, 0x42, 0x61,
+	0x74, 0x63, 0x68, 0x1a, 0x1d, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x73, 0x2e, 0x50, 0x72, 0x6f,
+	0x70, 0x6f, 0x73, 0x61, 0x6c, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x42, 0x61, 0x74,
+	0x63, 0x68, 0x22, 0x00, 0x42, 0x3d, 0x5a, 0x3b, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63,
+	0x6f, 0x6d, 0x2f, 0x68, 0x79, 0x70, 0x65, 0x72, 0x6c, 0x65, 0x64, 0x67, 0x65, 0x72, 0x2f, 0x66,
+	0x61, 0x62, 0x72, 0x69, 0x63, 0x2f, 0x63, 0x6f, 0x72, 0x65, 0x2f, 0x65, 0x6e, 0x64, 0x6f, 0x72,
+	0x73, 0x65, 0x72, 0x2f, 0x73, 0x68, 0x61, 0x72, 0x64, 0x69, 0x6e, 0x67, 0x2f, 0x70, 0x72, 0x6f,
+	0x74, 0x6f, 0x73, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+})
+
+var (
+	file_core_endorser_sharding_protos_batch_proto_rawDescOnce sync.Once
+	file_core_endorser_sharding_protos_batch_proto_rawDescData []byte
+)
+
+func file_core_endorser_sharding_protos_batch_proto_rawDescGZIP() []byte {
+	file_core_endorser_sharding_protos_batch_proto_rawDescOnce.Do(func() {
+		file_core_endorser_sharding_protos_batch_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_core_endorser_sharding_protos_batch_proto_rawDesc), len(file_core_endorser_sharding_protos_batch_proto_rawDesc)))
+	})
+	return file_core_endorser_sharding_protos_batch_proto_rawDescData
+}
+
+var file_core_endorser_sharding_protos_batch_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_core_endorser_sharding_protos_batch_proto_goTypes = []any{
+	(*ProposalBatch)(nil),         // 0: protos.ProposalBatch
+	(*ProposalResponseBatch)(nil), // 1: protos.ProposalResponseBatch
+}
+var file_core_endorser_sharding_protos_batch_proto_depIdxs = []int32{
+	0, // 0: protos.BatchEndorser.ProcessProposals:input_type -> protos.ProposalBatch
+	1, // 1: protos.BatchEndorser.ProcessProposals:output_type -> protos.ProposalResponseBatch
+	1, // [1:2] is the sub-list for method output_type
+	0, // [0:1] is the sub-list for method input_type
+	0, // [0:0] is the sub-list for extension type_name
+	0, // [0:0] is the sub-list for extension extendee
+	0, // [0:0] is the sub-list for field type_name
+}
+
+func init() { file_core_endorser_sharding_protos_batch_proto_init() }
+func file_core_endorser_sharding_protos_batch_proto_init() {
+	if File_core_endorser_sharding_protos_batch_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_core_endorser_sharding_protos_batch_proto_rawDesc), len(file_core_endorser_sharding_protos_batch_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   2,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_core_endorser_sharding_protos_batch_proto_goTypes,
+		DependencyIndexes: file_core_endorser_sharding_protos_batch_proto_depIdxs,
+		MessageInfos:      file_core_endorser_sharding_protos_batch_proto_msgTypes,
+	}.Build()
+	File_core_endorser_sharding_protos_batch_proto = out.File
+	file_core_endorser_sharding_protos_batch_proto_goTypes = nil
+	file_core_endorser_sharding_protos_batch_proto_depIdxs = nil
+}
@@ -0,0 +1,115 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             v4.25.1
+// source: core/endorser/sharding/protos/batch.proto
+
+package protos
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	BatchEndorser_ProcessProposals_FullMethodName = "/protos.BatchEndorser/ProcessProposals"
+)
+
+// BatchEndorserClient is the client API for BatchEndorser service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type BatchEndorserClient interface {
+	// ProcessProposals simulates the proposals concurrently, prepares them
+	// on each shard in one combined submission and returns their responses
+	// in the order of the proposals
+	ProcessProposals(ctx context.Context, in *ProposalBatch, opts ...grpc.CallOption) (*ProposalResponseBatch, error)
+}
+
+type batchEndorserClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewBatchEndorserClient(cc grpc.ClientConnInterface) BatchEndorserClient {
+	return &batchEndorserClient{cc}
+}
+
+func (c *batchEndorserClient) ProcessProposals(ctx context.Context, in *ProposalBatch, opts ...grpc.CallOption) (*ProposalResponseBatch, error) {
+	out := new(ProposalResponseBatch)
+	err := c.cc.Invoke(ctx, BatchEndorser_ProcessProposals_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// BatchEndorserServer is the server API for BatchEndorser service.
+// All implementations must embed UnimplementedBatchEndorserServer
+// for forward compatibility
+type BatchEndorserServer interface {
+	// ProcessProposals simulates the proposals concurrently, prepares them
+	// on each shard in one combined submission and returns their responses
+	// in the order of the proposals
+	ProcessProposals(context.Context, *ProposalBatch) (*ProposalResponseBatch, error)
+	mustEmbedUnimplementedBatchEndorserServer()
+}
+
+// UnimplementedBatchEndorserServer must be embedded to have forward compatible implementations.
+type UnimplementedBatchEndorserServer struct {
+}
+
+func (UnimplementedBatchEndorserServer) ProcessProposals(context.Context, *ProposalBatch) (*ProposalResponseBatch, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ProcessProposals not implemented")
+}
+func (UnimplementedBatchEndorserServer) mustEmbedUnimplementedBatchEndorserServer() {}
+
+// UnsafeBatchEndorserServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to BatchEndorserServer will
+// result in compilation errors.
+type UnsafeBatchEndorserServer interface {
+	mustEmbedUnimplementedBatchEndorserServer()
+}
+
+func RegisterBatchEndorserServer(s grpc.ServiceRegistrar, srv BatchEndorserServer) {
+	s.RegisterService(&BatchEndorser_ServiceDesc, srv)
+}
+
+func _BatchEndorser_ProcessProposals_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ProposalBatch)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BatchEndorserServer).ProcessProposals(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: BatchEndorser_ProcessProposals_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BatchEndorserServer).ProcessProposals(ctx, req.(*ProposalBatch))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// BatchEndorser_ServiceDesc is the grpc.ServiceDesc for BatchEndorser service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var BatchEndorser_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "protos.BatchEndorser",
+	HandlerType: (*BatchEndorserServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ProcessProposals",
+			Handler:    _BatchEndorser_ProcessProposals_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "core/endorser/sharding/protos/batch.proto",
+}
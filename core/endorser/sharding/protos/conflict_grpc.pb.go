@@ -0,0 +1,152 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             v4.25.1
+// source: core/endorser/sharding/protos/conflict.proto
+
+package protos
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	ConflictService_Prepare_FullMethodName = "/protos.ConflictService/Prepare"
+	ConflictService_Abort_FullMethodName   = "/protos.ConflictService/Abort"
+)
+
+// ConflictServiceClient is the client API for ConflictService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ConflictServiceClient interface {
+	// Prepare reserves the keys of a transaction and returns the proof of
+	// its dependencies
+	Prepare(ctx context.Context, in *ConflictPrepareRequest, opts ...grpc.CallOption) (*ConflictPrepareResponse, error)
+	// Abort releases the reservations of a transaction
+	Abort(ctx context.Context, in *ConflictAbortRequest, opts ...grpc.CallOption) (*ConflictAbortResponse, error)
+}
+
+type conflictServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewConflictServiceClient(cc grpc.ClientConnInterface) ConflictServiceClient {
+	return &conflictServiceClient{cc}
+}
+
+func (c *conflictServiceClient) Prepare(ctx context.Context, in *ConflictPrepareRequest, opts ...grpc.CallOption) (*ConflictPrepareResponse, error) {
+	out := new(ConflictPrepareResponse)
+	err := c.cc.Invoke(ctx, ConflictService_Prepare_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *conflictServiceClient) Abort(ctx context.Context, in *ConflictAbortRequest, opts ...grpc.CallOption) (*ConflictAbortResponse, error) {
+	out := new(ConflictAbortResponse)
+	err := c.cc.Invoke(ctx, ConflictService_Abort_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ConflictServiceServer is the server API for ConflictService service.
+// All implementations must embed UnimplementedConflictServiceServer
+// for forward compatibility
+type ConflictServiceServer interface {
+	// Prepare reserves the keys of a transaction and returns the proof of
+	// its dependencies
+	Prepare(context.Context, *ConflictPrepareRequest) (*ConflictPrepareResponse, error)
+	// Abort releases the reservations of a transaction
+	Abort(context.Context, *ConflictAbortRequest) (*ConflictAbortResponse, error)
+	mustEmbedUnimplementedConflictServiceServer()
+}
+
+// UnimplementedConflictServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedConflictServiceServer struct {
+}
+
+func (UnimplementedConflictServiceServer) Prepare(context.Context, *ConflictPrepareRequest) (*ConflictPrepareResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Prepare not implemented")
+}
+func (UnimplementedConflictServiceServer) Abort(context.Context, *ConflictAbortRequest) (*ConflictAbortResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Abort not implemented")
+}
+func (UnimplementedConflictServiceServer) mustEmbedUnimplementedConflictServiceServer() {}
+
+// UnsafeConflictServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ConflictServiceServer will
+// result in compilation errors.
+type UnsafeConflictServiceServer interface {
+	mustEmbedUnimplementedConflictServiceServer()
+}
+
+func RegisterConflictServiceServer(s grpc.ServiceRegistrar, srv ConflictServiceServer) {
+	s.RegisterService(&ConflictService_ServiceDesc, srv)
+}
+
+func _ConflictService_Prepare_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ConflictPrepareRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ConflictServiceServer).Prepare(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ConflictService_Prepare_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ConflictServiceServer).Prepare(ctx, req.(*ConflictPrepareRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ConflictService_Abort_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ConflictAbortRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ConflictServiceServer).Abort(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ConflictService_Abort_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ConflictServiceServer).Abort(ctx, req.(*ConflictAbortRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// ConflictService_ServiceDesc is the grpc.ServiceDesc for ConflictService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ConflictService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "protos.ConflictService",
+	HandlerType: (*ConflictServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Prepare",
+			Handler:    _ConflictService_Prepare_Handler,
+		},
+		{
+			MethodName: "Abort",
+			Handler:    _ConflictService_Abort_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "core/endorser/sharding/protos/conflict.proto",
+}
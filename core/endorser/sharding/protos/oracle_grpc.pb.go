@@ -0,0 +1,111 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             v4.25.1
+// source: core/endorser/sharding/protos/oracle.proto
+
+package protos
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	ConflictOracle_Review_FullMethodName = "/protos.ConflictOracle/Review"
+)
+
+// ConflictOracleClient is the client API for ConflictOracle service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ConflictOracleClient interface {
+	// Review vetoes the endorsement of the proposal or annotates it
+	Review(ctx context.Context, in *ReviewRequest, opts ...grpc.CallOption) (*ReviewResponse, error)
+}
+
+type conflictOracleClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewConflictOracleClient(cc grpc.ClientConnInterface) ConflictOracleClient {
+	return &conflictOracleClient{cc}
+}
+
+func (c *conflictOracleClient) Review(ctx context.Context, in *ReviewRequest, opts ...grpc.CallOption) (*ReviewResponse, error) {
+	out := new(ReviewResponse)
+	err := c.cc.Invoke(ctx, ConflictOracle_Review_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ConflictOracleServer is the server API for ConflictOracle service.
+// All implementations must embed UnimplementedConflictOracleServer
+// for forward compatibility
+type ConflictOracleServer interface {
+	// Review vetoes the endorsement of the proposal or annotates it
+	Review(context.Context, *ReviewRequest) (*ReviewResponse, error)
+	mustEmbedUnimplementedConflictOracleServer()
+}
+
+// UnimplementedConflictOracleServer must be embedded to have forward compatible implementations.
+type UnimplementedConflictOracleServer struct {
+}
+
+func (UnimplementedConflictOracleServer) Review(context.Context, *ReviewRequest) (*ReviewResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Review not implemented")
+}
+func (UnimplementedConflictOracleServer) mustEmbedUnimplementedConflictOracleServer() {}
+
+// UnsafeConflictOracleServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ConflictOracleServer will
+// result in compilation errors.
+type UnsafeConflictOracleServer interface {
+	mustEmbedUnimplementedConflictOracleServer()
+}
+
+func RegisterConflictOracleServer(s grpc.ServiceRegistrar, srv ConflictOracleServer) {
+	s.RegisterService(&ConflictOracle_ServiceDesc, srv)
+}
+
+func _ConflictOracle_Review_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReviewRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ConflictOracleServer).Review(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ConflictOracle_Review_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ConflictOracleServer).Review(ctx, req.(*ReviewRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// ConflictOracle_ServiceDesc is the grpc.ServiceDesc for ConflictOracle service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ConflictOracle_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "protos.ConflictOracle",
+	HandlerType: (*ConflictOracleServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Review",
+			Handler:    _ConflictOracle_Review_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "core/endorser/sharding/protos/oracle.proto",
+}
@@ -21,10 +21,13 @@ const (
 	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
 )
 
-// RaftMessageProto wraps a serialized raftpb.Message
+// RaftMessageProto wraps serialized raftpb.Messages for one shard
 type RaftMessageProto struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Data          []byte                 `protobuf:"bytes,1,opt,name=data,proto3" json:"data,omitempty"`
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// data holds a single message
+	Data []byte `protobuf:"bytes,1,opt,name=data,proto3" json:"data,omitempty"`
+	// messages holds messages coalesced by the sender, in order
+	Messages      [][]byte `protobuf:"bytes,2,rep,name=messages,proto3" json:"messages,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -66,6 +69,13 @@ func (x *RaftMessageProto) GetData() []byte {
 	return nil
 }
 
+func (x *RaftMessageProto) GetMessages() [][]byte {
+	if x != nil {
+		return x.Messages
+	}
+	return nil
+}
+
 type StepResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Success       bool                   `protobuf:"varint,1,opt,name=success,proto3" json:"success,omitempty"`
@@ -118,18 +128,632 @@ func (x *StepResponse) GetError() string {
 	return ""
 }
 
+// SnapshotChunk carries part of a raftpb.MsgSnap message. The first chunk
+// holds the message with its snapshot data stripped, every chunk holds the
+// next slice of the snapshot data, and the final chunk sets last.
+type SnapshotChunk struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Message       []byte                 `protobuf:"bytes,1,opt,name=message,proto3" json:"message,omitempty"`
+	Data          []byte                 `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
+	Last          bool                   `protobuf:"varint,3,opt,name=last,proto3" json:"last,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SnapshotChunk) Reset() {
+	*x = SnapshotChunk{}
+	mi := &file_core_endorser_sharding_protos_shard_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SnapshotChunk) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SnapshotChunk) ProtoMessage() {}
+
+func (x *SnapshotChunk) ProtoReflect() protoreflect.Message {
+	mi := &file_core_endorser_sharding_protos_shard_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SnapshotChunk.ProtoReflect.Descriptor instead.
+func (*SnapshotChunk) Descriptor() ([]byte, []int) {
+	return file_core_endorser_sharding_protos_shard_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *SnapshotChunk) GetMessage() []byte {
+	if x != nil {
+		return x.Message
+	}
+	return nil
+}
+
+func (x *SnapshotChunk) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *SnapshotChunk) GetLast() bool {
+	if x != nil {
+		return x.Last
+	}
+	return false
+}
+
+// ForwardRequest carries a serialized PrepareRequestBatch from a follower
+type ForwardRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Batch         []byte                 `protobuf:"bytes,1,opt,name=batch,proto3" json:"batch,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ForwardRequest) Reset() {
+	*x = ForwardRequest{}
+	mi := &file_core_endorser_sharding_protos_shard_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ForwardRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ForwardRequest) ProtoMessage() {}
+
+func (x *ForwardRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_core_endorser_sharding_protos_shard_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ForwardRequest.ProtoReflect.Descriptor instead.
+func (*ForwardRequest) Descriptor() ([]byte, []int) {
+	return file_core_endorser_sharding_protos_shard_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *ForwardRequest) GetBatch() []byte {
+	if x != nil {
+		return x.Batch
+	}
+	return nil
+}
+
+// ForwardResponse carries the serialized proofs of a forwarded batch
+type ForwardResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Success       bool                   `protobuf:"varint,1,opt,name=success,proto3" json:"success,omitempty"`
+	Error         string                 `protobuf:"bytes,2,opt,name=error,proto3" json:"error,omitempty"`
+	Proofs        []byte                 `protobuf:"bytes,3,opt,name=proofs,proto3" json:"proofs,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ForwardResponse) Reset() {
+	*x = ForwardResponse{}
+	mi := &file_core_endorser_sharding_protos_shard_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ForwardResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ForwardResponse) ProtoMessage() {}
+
+func (x *ForwardResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_core_endorser_sharding_protos_shard_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ForwardResponse.ProtoReflect.Descriptor instead.
+func (*ForwardResponse) Descriptor() ([]byte, []int) {
+	return file_core_endorser_sharding_protos_shard_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *ForwardResponse) GetSuccess() bool {
+	if x != nil {
+		return x.Success
+	}
+	return false
+}
+
+func (x *ForwardResponse) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *ForwardResponse) GetProofs() []byte {
+	if x != nil {
+		return x.Proofs
+	}
+	return nil
+}
+
+// CoSignRequest identifies the proof a replica is asked to co-sign
+type CoSignRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	TxId          string                 `protobuf:"bytes,1,opt,name=tx_id,json=txId,proto3" json:"tx_id,omitempty"`
+	CommitIndex   uint64                 `protobuf:"varint,2,opt,name=commit_index,json=commitIndex,proto3" json:"commit_index,omitempty"`
+	Term          uint64                 `protobuf:"varint,3,opt,name=term,proto3" json:"term,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CoSignRequest) Reset() {
+	*x = CoSignRequest{}
+	mi := &file_core_endorser_sharding_protos_shard_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CoSignRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CoSignRequest) ProtoMessage() {}
+
+func (x *CoSignRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_core_endorser_sharding_protos_shard_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CoSignRequest.ProtoReflect.Descriptor instead.
+func (*CoSignRequest) Descriptor() ([]byte, []int) {
+	return file_core_endorser_sharding_protos_shard_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *CoSignRequest) GetTxId() string {
+	if x != nil {
+		return x.TxId
+	}
+	return ""
+}
+
+func (x *CoSignRequest) GetCommitIndex() uint64 {
+	if x != nil {
+		return x.CommitIndex
+	}
+	return 0
+}
+
+func (x *CoSignRequest) GetTerm() uint64 {
+	if x != nil {
+		return x.Term
+	}
+	return 0
+}
+
+// CoSignResponse carries the recipient's signature over the proof
+type CoSignResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Success       bool                   `protobuf:"varint,1,opt,name=success,proto3" json:"success,omitempty"`
+	Error         string                 `protobuf:"bytes,2,opt,name=error,proto3" json:"error,omitempty"`
+	Signature     []byte                 `protobuf:"bytes,3,opt,name=signature,proto3" json:"signature,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CoSignResponse) Reset() {
+	*x = CoSignResponse{}
+	mi := &file_core_endorser_sharding_protos_shard_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CoSignResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CoSignResponse) ProtoMessage() {}
+
+func (x *CoSignResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_core_endorser_sharding_protos_shard_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CoSignResponse.ProtoReflect.Descriptor instead.
+func (*CoSignResponse) Descriptor() ([]byte, []int) {
+	return file_core_endorser_sharding_protos_shard_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *CoSignResponse) GetSuccess() bool {
+	if x != nil {
+		return x.Success
+	}
+	return false
+}
+
+func (x *CoSignResponse) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *CoSignResponse) GetSignature() []byte {
+	if x != nil {
+		return x.Signature
+	}
+	return nil
+}
+
+// ShardMember is a replica of a shard
+type ShardMember struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            uint64                 `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Address       string                 `protobuf:"bytes,2,opt,name=address,proto3" json:"address,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ShardMember) Reset() {
+	*x = ShardMember{}
+	mi := &file_core_endorser_sharding_protos_shard_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ShardMember) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ShardMember) ProtoMessage() {}
+
+func (x *ShardMember) ProtoReflect() protoreflect.Message {
+	mi := &file_core_endorser_sharding_protos_shard_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ShardMember.ProtoReflect.Descriptor instead.
+func (*ShardMember) Descriptor() ([]byte, []int) {
+	return file_core_endorser_sharding_protos_shard_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *ShardMember) GetId() uint64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *ShardMember) GetAddress() string {
+	if x != nil {
+		return x.Address
+	}
+	return ""
+}
+
+// ShardRegistration announces the leader and members of a shard as seen by
+// its leader in term
+type ShardRegistration struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Contract      string                 `protobuf:"bytes,1,opt,name=contract,proto3" json:"contract,omitempty"`
+	ShardId       string                 `protobuf:"bytes,2,opt,name=shard_id,json=shardId,proto3" json:"shard_id,omitempty"`
+	LeaderId      uint64                 `protobuf:"varint,3,opt,name=leader_id,json=leaderId,proto3" json:"leader_id,omitempty"`
+	LeaderAddress string                 `protobuf:"bytes,4,opt,name=leader_address,json=leaderAddress,proto3" json:"leader_address,omitempty"`
+	Members       []*ShardMember         `protobuf:"bytes,5,rep,name=members,proto3" json:"members,omitempty"`
+	Term          uint64                 `protobuf:"varint,6,opt,name=term,proto3" json:"term,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ShardRegistration) Reset() {
+	*x = ShardRegistration{}
+	mi := &file_core_endorser_sharding_protos_shard_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ShardRegistration) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ShardRegistration) ProtoMessage() {}
+
+func (x *ShardRegistration) ProtoReflect() protoreflect.Message {
+	mi := &file_core_endorser_sharding_protos_shard_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ShardRegistration.ProtoReflect.Descriptor instead.
+func (*ShardRegistration) Descriptor() ([]byte, []int) {
+	return file_core_endorser_sharding_protos_shard_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *ShardRegistration) GetContract() string {
+	if x != nil {
+		return x.Contract
+	}
+	return ""
+}
+
+func (x *ShardRegistration) GetShardId() string {
+	if x != nil {
+		return x.ShardId
+	}
+	return ""
+}
+
+func (x *ShardRegistration) GetLeaderId() uint64 {
+	if x != nil {
+		return x.LeaderId
+	}
+	return 0
+}
+
+func (x *ShardRegistration) GetLeaderAddress() string {
+	if x != nil {
+		return x.LeaderAddress
+	}
+	return ""
+}
+
+func (x *ShardRegistration) GetMembers() []*ShardMember {
+	if x != nil {
+		return x.Members
+	}
+	return nil
+}
+
+func (x *ShardRegistration) GetTerm() uint64 {
+	if x != nil {
+		return x.Term
+	}
+	return 0
+}
+
+type RegisterResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Success       bool                   `protobuf:"varint,1,opt,name=success,proto3" json:"success,omitempty"`
+	Error         string                 `protobuf:"bytes,2,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RegisterResponse) Reset() {
+	*x = RegisterResponse{}
+	mi := &file_core_endorser_sharding_protos_shard_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RegisterResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RegisterResponse) ProtoMessage() {}
+
+func (x *RegisterResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_core_endorser_sharding_protos_shard_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RegisterResponse.ProtoReflect.Descriptor instead.
+func (*RegisterResponse) Descriptor() ([]byte, []int) {
+	return file_core_endorser_sharding_protos_shard_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *RegisterResponse) GetSuccess() bool {
+	if x != nil {
+		return x.Success
+	}
+	return false
+}
+
+func (x *RegisterResponse) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+// LookupRequest asks for the shards of a contract, or of every contract
+// when contract is empty
+type LookupRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Contract      string                 `protobuf:"bytes,1,opt,name=contract,proto3" json:"contract,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LookupRequest) Reset() {
+	*x = LookupRequest{}
+	mi := &file_core_endorser_sharding_protos_shard_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LookupRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LookupRequest) ProtoMessage() {}
+
+func (x *LookupRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_core_endorser_sharding_protos_shard_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LookupRequest.ProtoReflect.Descriptor instead.
+func (*LookupRequest) Descriptor() ([]byte, []int) {
+	return file_core_endorser_sharding_protos_shard_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *LookupRequest) GetContract() string {
+	if x != nil {
+		return x.Contract
+	}
+	return ""
+}
+
+type LookupResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Shards        []*ShardRegistration   `protobuf:"bytes,1,rep,name=shards,proto3" json:"shards,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LookupResponse) Reset() {
+	*x = LookupResponse{}
+	mi := &file_core_endorser_sharding_protos_shard_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LookupResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LookupResponse) ProtoMessage() {}
+
+func (x *LookupResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_core_endorser_sharding_protos_shard_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LookupResponse.ProtoReflect.Descriptor instead.
+func (*LookupResponse) Descriptor() ([]byte, []int) {
+	return file_core_endorser_sharding_protos_shard_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *LookupResponse) GetShards() []*ShardRegistration {
+	if x != nil {
+		return x.Shards
+	}
+	return nil
+}
+
 var File_core_endorser_sharding_protos_shard_proto protoreflect.FileDescriptor
 
 const file_core_endorser_sharding_protos_shard_proto_rawDesc = "" +
 	"\n" +
-	")core/endorser/sharding/protos/shard.proto\x12\x06protos\"&\n" +
+	")core/endorser/sharding/protos/shard.proto\x12\x06protos\"B\n" +
 	"\x10RaftMessageProto\x12\x12\n" +
-	"\x04data\x18\x01 \x01(\fR\x04data\">\n" +
+	"\x04data\x18\x01 \x01(\fR\x04data\x12\x1a\n" +
+	"\bmessages\x18\x02 \x03(\fR\bmessages\">\n" +
 	"\fStepResponse\x12\x18\n" +
 	"\asuccess\x18\x01 \x01(\bR\asuccess\x12\x14\n" +
-	"\x05error\x18\x02 \x01(\tR\x05error2N\n" +
+	"\x05error\x18\x02 \x01(\tR\x05error\"Q\n" +
+	"\rSnapshotChunk\x12\x18\n" +
+	"\amessage\x18\x01 \x01(\fR\amessage\x12\x12\n" +
+	"\x04data\x18\x02 \x01(\fR\x04data\x12\x12\n" +
+	"\x04last\x18\x03 \x01(\bR\x04last\"&\n" +
+	"\x0eForwardRequest\x12\x14\n" +
+	"\x05batch\x18\x01 \x01(\fR\x05batch\"Y\n" +
+	"\x0fForwardResponse\x12\x18\n" +
+	"\asuccess\x18\x01 \x01(\bR\asuccess\x12\x14\n" +
+	"\x05error\x18\x02 \x01(\tR\x05error\x12\x16\n" +
+	"\x06proofs\x18\x03 \x01(\fR\x06proofs\"[\n" +
+	"\rCoSignRequest\x12\x13\n" +
+	"\x05tx_id\x18\x01 \x01(\tR\x04txId\x12!\n" +
+	"\fcommit_index\x18\x02 \x01(\x04R\vcommitIndex\x12\x12\n" +
+	"\x04term\x18\x03 \x01(\x04R\x04term\"^\n" +
+	"\x0eCoSignResponse\x12\x18\n" +
+	"\asuccess\x18\x01 \x01(\bR\asuccess\x12\x14\n" +
+	"\x05error\x18\x02 \x01(\tR\x05error\x12\x1c\n" +
+	"\tsignature\x18\x03 \x01(\fR\tsignature\"7\n" +
+	"\vShardMember\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x04R\x02id\x12\x18\n" +
+	"\aaddress\x18\x02 \x01(\tR\aaddress\"\xd1\x01\n" +
+	"\x11ShardRegistration\x12\x1a\n" +
+	"\bcontract\x18\x01 \x01(\tR\bcontract\x12\x19\n" +
+	"\bshard_id\x18\x02 \x01(\tR\ashardId\x12\x1b\n" +
+	"\tleader_id\x18\x03 \x01(\x04R\bleaderId\x12%\n" +
+	"\x0eleader_address\x18\x04 \x01(\tR\rleaderAddress\x12-\n" +
+	"\amembers\x18\x05 \x03(\v2\x13.protos.ShardMemberR\amembers\x12\x12\n" +
+	"\x04term\x18\x06 \x01(\x04R\x04term\"B\n" +
+	"\x10RegisterResponse\x12\x18\n" +
+	"\asuccess\x18\x01 \x01(\bR\asuccess\x12\x14\n" +
+	"\x05error\x18\x02 \x01(\tR\x05error\"+\n" +
+	"\rLookupRequest\x12\x1a\n" +
+	"\bcontract\x18\x01 \x01(\tR\bcontract\"C\n" +
+	"\x0eLookupResponse\x121\n" +
+	"\x06shards\x18\x01 \x03(\v2\x19.protos.ShardRegistrationR\x06shards2\x88\x02\n" +
 	"\x12ShardCommunication\x128\n" +
-	"\x04Step\x12\x18.protos.RaftMessageProto\x1a\x14.protos.StepResponse\"\x00B=Z;github.com/hyperledger/fabric/core/endorser/sharding/protosb\x06proto3"
+	"\x04Step\x12\x18.protos.RaftMessageProto\x1a\x14.protos.StepResponse\"\x00\x12?\n" +
+	"\fSendSnapshot\x12\x15.protos.SnapshotChunk\x1a\x14.protos.StepResponse\"\x00(\x01\x12<\n" +
+	"\aForward\x12\x16.protos.ForwardRequest\x1a\x17.protos.ForwardResponse\"\x00\x129\n" +
+	"\x06CoSign\x12\x15.protos.CoSignRequest\x1a\x16.protos.CoSignResponse\"\x002\x8d\x01\n" +
+	"\rShardRegistry\x12A\n" +
+	"\bRegister\x12\x19.protos.ShardRegistration\x1a\x18.protos.RegisterResponse\"\x00\x129\n" +
+	"\x06Lookup\x12\x15.protos.LookupRequest\x1a\x16.protos.LookupResponse\"\x00B=Z;github.com/hyperledger/fabric/core/endorser/sharding/protosb\x06proto3"
 
 var (
 	file_core_endorser_sharding_protos_shard_proto_rawDescOnce sync.Once
@@ -143,19 +767,41 @@ func file_core_endorser_sharding_protos_shard_proto_rawDescGZIP() []byte {
 	return file_core_endorser_sharding_protos_shard_proto_rawDescData
 }
 
-var file_core_endorser_sharding_protos_shard_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_core_endorser_sharding_protos_shard_proto_msgTypes = make([]protoimpl.MessageInfo, 12)
 var file_core_endorser_sharding_protos_shard_proto_goTypes = []any{
-	(*RaftMessageProto)(nil), // 0: protos.RaftMessageProto
-	(*StepResponse)(nil),     // 1: protos.StepResponse
+	(*RaftMessageProto)(nil),  // 0: protos.RaftMessageProto
+	(*StepResponse)(nil),      // 1: protos.StepResponse
+	(*SnapshotChunk)(nil),     // 2: protos.SnapshotChunk
+	(*ForwardRequest)(nil),    // 3: protos.ForwardRequest
+	(*ForwardResponse)(nil),   // 4: protos.ForwardResponse
+	(*CoSignRequest)(nil),     // 5: protos.CoSignRequest
+	(*CoSignResponse)(nil),    // 6: protos.CoSignResponse
+	(*ShardMember)(nil),       // 7: protos.ShardMember
+	(*ShardRegistration)(nil), // 8: protos.ShardRegistration
+	(*RegisterResponse)(nil),  // 9: protos.RegisterResponse
+	(*LookupRequest)(nil),     // 10: protos.LookupRequest
+	(*LookupResponse)(nil),    // 11: protos.LookupResponse
 }
 var file_core_endorser_sharding_protos_shard_proto_depIdxs = []int32{
-	0, // 0: protos.ShardCommunication.Step:input_type -> protos.RaftMessageProto
-	1, // 1: protos.ShardCommunication.Step:output_type -> protos.StepResponse
-	1, // [1:2] is the sub-list for method output_type
-	0, // [0:1] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	7,  // 0: protos.ShardRegistration.members:type_name -> protos.ShardMember
+	8,  // 1: protos.LookupResponse.shards:type_name -> protos.ShardRegistration
+	0,  // 2: protos.ShardCommunication.Step:input_type -> protos.RaftMessageProto
+	2,  // 3: protos.ShardCommunication.SendSnapshot:input_type -> protos.SnapshotChunk
+	3,  // 4: protos.ShardCommunication.Forward:input_type -> protos.ForwardRequest
+	5,  // 5: protos.ShardCommunication.CoSign:input_type -> protos.CoSignRequest
+	8,  // 6: protos.ShardRegistry.Register:input_type -> protos.ShardRegistration
+	10, // 7: protos.ShardRegistry.Lookup:input_type -> protos.LookupRequest
+	1,  // 8: protos.ShardCommunication.Step:output_type -> protos.StepResponse
+	1,  // 9: protos.ShardCommunication.SendSnapshot:output_type -> protos.StepResponse
+	4,  // 10: protos.ShardCommunication.Forward:output_type -> protos.ForwardResponse
+	6,  // 11: protos.ShardCommunication.CoSign:output_type -> protos.CoSignResponse
+	9,  // 12: protos.ShardRegistry.Register:output_type -> protos.RegisterResponse
+	11, // 13: protos.ShardRegistry.Lookup:output_type -> protos.LookupResponse
+	8,  // [8:14] is the sub-list for method output_type
+	2,  // [2:8] is the sub-list for method input_type
+	2,  // [2:2] is the sub-list for extension type_name
+	2,  // [2:2] is the sub-list for extension extendee
+	0,  // [0:2] is the sub-list for field type_name
 }
 
 func init() { file_core_endorser_sharding_protos_shard_proto_init() }
@@ -169,9 +815,9 @@ func file_core_endorser_sharding_protos_shard_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_core_endorser_sharding_protos_shard_proto_rawDesc), len(file_core_endorser_sharding_protos_shard_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   2,
+			NumMessages:   12,
 			NumExtensions: 0,
-			NumServices:   1,
+			NumServices:   2,
 		},
 		GoTypes:           file_core_endorser_sharding_protos_shard_proto_goTypes,
 		DependencyIndexes: file_core_endorser_sharding_protos_shard_proto_depIdxs,
@@ -19,15 +19,26 @@ import (
 const _ = grpc.SupportPackageIsVersion7
 
 const (
-	ShardCommunication_Step_FullMethodName = "/protos.ShardCommunication/Step"
+	ShardCommunication_Step_FullMethodName         = "/protos.ShardCommunication/Step"
+	ShardCommunication_SendSnapshot_FullMethodName = "/protos.ShardCommunication/SendSnapshot"
+	ShardCommunication_Forward_FullMethodName      = "/protos.ShardCommunication/Forward"
+	ShardCommunication_CoSign_FullMethodName       = "/protos.ShardCommunication/CoSign"
 )
 
 // ShardCommunicationClient is the client API for ShardCommunication service.
 //
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type ShardCommunicationClient interface {
-	// Step passes a Raft message to the recipient node
+	// Step passes Raft messages to the recipient node
 	Step(ctx context.Context, in *RaftMessageProto, opts ...grpc.CallOption) (*StepResponse, error)
+	// SendSnapshot streams a raftpb.MsgSnap message to the recipient node in chunks
+	SendSnapshot(ctx context.Context, opts ...grpc.CallOption) (ShardCommunication_SendSnapshotClient, error)
+	// Forward proposes a batch on the leader on behalf of a follower and
+	// returns the proofs of its transactions
+	Forward(ctx context.Context, in *ForwardRequest, opts ...grpc.CallOption) (*ForwardResponse, error)
+	// CoSign signs the proof of a transaction once the recipient has applied
+	// it at the same commit index and term
+	CoSign(ctx context.Context, in *CoSignRequest, opts ...grpc.CallOption) (*CoSignResponse, error)
 }
 
 type shardCommunicationClient struct {
@@ -47,12 +58,72 @@ func (c *shardCommunicationClient) Step(ctx context.Context, in *RaftMessageProt
 	return out, nil
 }
 
+func (c *shardCommunicationClient) SendSnapshot(ctx context.Context, opts ...grpc.CallOption) (ShardCommunication_SendSnapshotClient, error) {
+	stream, err := c.cc.NewStream(ctx, &ShardCommunication_ServiceDesc.Streams[0], ShardCommunication_SendSnapshot_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &shardCommunicationSendSnapshotClient{stream}
+	return x, nil
+}
+
+type ShardCommunication_SendSnapshotClient interface {
+	Send(*SnapshotChunk) error
+	CloseAndRecv() (*StepResponse, error)
+	grpc.ClientStream
+}
+
+type shardCommunicationSendSnapshotClient struct {
+	grpc.ClientStream
+}
+
+func (x *shardCommunicationSendSnapshotClient) Send(m *SnapshotChunk) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *shardCommunicationSendSnapshotClient) CloseAndRecv() (*StepResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(StepResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *shardCommunicationClient) Forward(ctx context.Context, in *ForwardRequest, opts ...grpc.CallOption) (*ForwardResponse, error) {
+	out := new(ForwardResponse)
+	err := c.cc.Invoke(ctx, ShardCommunication_Forward_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *shardCommunicationClient) CoSign(ctx context.Context, in *CoSignRequest, opts ...grpc.CallOption) (*CoSignResponse, error) {
+	out := new(CoSignResponse)
+	err := c.cc.Invoke(ctx, ShardCommunication_CoSign_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // ShardCommunicationServer is the server API for ShardCommunication service.
 // All implementations must embed UnimplementedShardCommunicationServer
 // for forward compatibility
 type ShardCommunicationServer interface {
-	// Step passes a Raft message to the recipient node
+	// Step passes Raft messages to the recipient node
 	Step(context.Context, *RaftMessageProto) (*StepResponse, error)
+	// SendSnapshot streams a raftpb.MsgSnap message to the recipient node in chunks
+	SendSnapshot(ShardCommunication_SendSnapshotServer) error
+	// Forward proposes a batch on the leader on behalf of a follower and
+	// returns the proofs of its transactions
+	Forward(context.Context, *ForwardRequest) (*ForwardResponse, error)
+	// CoSign signs the proof of a transaction once the recipient has applied
+	// it at the same commit index and term
+	CoSign(context.Context, *CoSignRequest) (*CoSignResponse, error)
 	mustEmbedUnimplementedShardCommunicationServer()
 }
 
@@ -63,6 +134,15 @@ type UnimplementedShardCommunicationServer struct {
 func (UnimplementedShardCommunicationServer) Step(context.Context, *RaftMessageProto) (*StepResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Step not implemented")
 }
+func (UnimplementedShardCommunicationServer) SendSnapshot(ShardCommunication_SendSnapshotServer) error {
+	return status.Errorf(codes.Unimplemented, "method SendSnapshot not implemented")
+}
+func (UnimplementedShardCommunicationServer) Forward(context.Context, *ForwardRequest) (*ForwardResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Forward not implemented")
+}
+func (UnimplementedShardCommunicationServer) CoSign(context.Context, *CoSignRequest) (*CoSignResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CoSign not implemented")
+}
 func (UnimplementedShardCommunicationServer) mustEmbedUnimplementedShardCommunicationServer() {}
 
 // UnsafeShardCommunicationServer may be embedded to opt out of forward compatibility for this service.
@@ -94,6 +174,68 @@ func _ShardCommunication_Step_Handler(srv interface{}, ctx context.Context, dec
 	return interceptor(ctx, in, info, handler)
 }
 
+func _ShardCommunication_SendSnapshot_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(ShardCommunicationServer).SendSnapshot(&shardCommunicationSendSnapshotServer{stream})
+}
+
+type ShardCommunication_SendSnapshotServer interface {
+	SendAndClose(*StepResponse) error
+	Recv() (*SnapshotChunk, error)
+	grpc.ServerStream
+}
+
+type shardCommunicationSendSnapshotServer struct {
+	grpc.ServerStream
+}
+
+func (x *shardCommunicationSendSnapshotServer) SendAndClose(m *StepResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *shardCommunicationSendSnapshotServer) Recv() (*SnapshotChunk, error) {
+	m := new(SnapshotChunk)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _ShardCommunication_Forward_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ForwardRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ShardCommunicationServer).Forward(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ShardCommunication_Forward_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ShardCommunicationServer).Forward(ctx, req.(*ForwardRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ShardCommunication_CoSign_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CoSignRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ShardCommunicationServer).CoSign(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ShardCommunication_CoSign_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ShardCommunicationServer).CoSign(ctx, req.(*CoSignRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // ShardCommunication_ServiceDesc is the grpc.ServiceDesc for ShardCommunication service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -105,6 +247,153 @@ var ShardCommunication_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Step",
 			Handler:    _ShardCommunication_Step_Handler,
 		},
+		{
+			MethodName: "Forward",
+			Handler:    _ShardCommunication_Forward_Handler,
+		},
+		{
+			MethodName: "CoSign",
+			Handler:    _ShardCommunication_CoSign_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "SendSnapshot",
+			Handler:       _ShardCommunication_SendSnapshot_Handler,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "core/endorser/sharding/protos/shard.proto",
+}
+
+const (
+	ShardRegistry_Register_FullMethodName = "/protos.ShardRegistry/Register"
+	ShardRegistry_Lookup_FullMethodName   = "/protos.ShardRegistry/Lookup"
+)
+
+// ShardRegistryClient is the client API for ShardRegistry service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ShardRegistryClient interface {
+	// Register records the registration of a shard unless a registration
+	// from a later term is known
+	Register(ctx context.Context, in *ShardRegistration, opts ...grpc.CallOption) (*RegisterResponse, error)
+	// Lookup returns the live registrations of the shards of a contract
+	Lookup(ctx context.Context, in *LookupRequest, opts ...grpc.CallOption) (*LookupResponse, error)
+}
+
+type shardRegistryClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewShardRegistryClient(cc grpc.ClientConnInterface) ShardRegistryClient {
+	return &shardRegistryClient{cc}
+}
+
+func (c *shardRegistryClient) Register(ctx context.Context, in *ShardRegistration, opts ...grpc.CallOption) (*RegisterResponse, error) {
+	out := new(RegisterResponse)
+	err := c.cc.Invoke(ctx, ShardRegistry_Register_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *shardRegistryClient) Lookup(ctx context.Context, in *LookupRequest, opts ...grpc.CallOption) (*LookupResponse, error) {
+	out := new(LookupResponse)
+	err := c.cc.Invoke(ctx, ShardRegistry_Lookup_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ShardRegistryServer is the server API for ShardRegistry service.
+// All implementations must embed UnimplementedShardRegistryServer
+// for forward compatibility
+type ShardRegistryServer interface {
+	// Register records the registration of a shard unless a registration
+	// from a later term is known
+	Register(context.Context, *ShardRegistration) (*RegisterResponse, error)
+	// Lookup returns the live registrations of the shards of a contract
+	Lookup(context.Context, *LookupRequest) (*LookupResponse, error)
+	mustEmbedUnimplementedShardRegistryServer()
+}
+
+// UnimplementedShardRegistryServer must be embedded to have forward compatible implementations.
+type UnimplementedShardRegistryServer struct {
+}
+
+func (UnimplementedShardRegistryServer) Register(context.Context, *ShardRegistration) (*RegisterResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Register not implemented")
+}
+func (UnimplementedShardRegistryServer) Lookup(context.Context, *LookupRequest) (*LookupResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Lookup not implemented")
+}
+func (UnimplementedShardRegistryServer) mustEmbedUnimplementedShardRegistryServer() {}
+
+// UnsafeShardRegistryServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ShardRegistryServer will
+// result in compilation errors.
+type UnsafeShardRegistryServer interface {
+	mustEmbedUnimplementedShardRegistryServer()
+}
+
+func RegisterShardRegistryServer(s grpc.ServiceRegistrar, srv ShardRegistryServer) {
+	s.RegisterService(&ShardRegistry_ServiceDesc, srv)
+}
+
+func _ShardRegistry_Register_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ShardRegistration)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ShardRegistryServer).Register(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ShardRegistry_Register_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ShardRegistryServer).Register(ctx, req.(*ShardRegistration))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ShardRegistry_Lookup_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LookupRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ShardRegistryServer).Lookup(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ShardRegistry_Lookup_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ShardRegistryServer).Lookup(ctx, req.(*LookupRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// ShardRegistry_ServiceDesc is the grpc.ServiceDesc for ShardRegistry service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ShardRegistry_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "protos.ShardRegistry",
+	HandlerType: (*ShardRegistryServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Register",
+			Handler:    _ShardRegistry_Register_Handler,
+		},
+		{
+			MethodName: "Lookup",
+			Handler:    _ShardRegistry_Lookup_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "core/endorser/sharding/protos/shard.proto",
@@ -0,0 +1,110 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"sort"
+)
+
+// proofCoSigner asks other replicas of a shard to co-sign a proof
+type proofCoSigner interface {
+	CoSignProof(ctx context.Context, shardID string, replicaID uint64, proof *PrepareProof) ([]byte, error)
+}
+
+// setCoSigner registers the co-signer used to build quorum certificates
+func (sl *ShardLeader) setCoSigner(coSigner proofCoSigner) {
+	sl.mu.Lock()
+	sl.coSigner = coSigner
+	sl.mu.Unlock()
+}
+
+// certify returns a copy of the proof carrying the signatures of a quorum of
+// voters over its tuple. Each voter signs only once it applied the
+// transaction at the same commit index and term.
+func (sl *ShardLeader) certify(ctx context.Context, proof *PrepareProof) (*PrepareProof, error) {
+	sl.mu.RLock()
+	coSigner := sl.coSigner
+	sl.mu.RUnlock()
+
+	voters := sl.Replicas()
+	quorum := len(voters)/2 + 1
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		sig ProofSignature
+		err error
+	}
+	resultC := make(chan result, len(voters))
+	for _, id := range voters {
+		go func(id uint64) {
+			var signature []byte
+			var err error
+			switch {
+			case id == sl.config.ReplicaID:
+				signature, err = sl.coSign(ctx, proof.TxID, proof.CommitIndex, proof.Term)
+			case coSigner == nil:
+				err = fmt.Errorf("no co-signer registered")
+			default:
+				signature, err = coSigner.CoSignProof(ctx, sl.shardID, id, proof)
+			}
+			resultC <- result{sig: ProofSignature{ReplicaID: id, Signature: signature}, err: err}
+		}(id)
+	}
+
+	var cert []ProofSignature
+	var errs []error
+	for range voters {
+		res := <-resultC
+		if res.err != nil {
+			errs = append(errs, fmt.Errorf("replica %d: %v", res.sig.ReplicaID, res.err))
+			continue
+		}
+		cert = append(cert, res.sig)
+		if len(cert) == quorum {
+			break
+		}
+	}
+	if len(cert) < quorum {
+		return nil, fmt.Errorf("only %d of %d co-signatures needed for the proof of tx %s on shard %s: %v",
+			len(cert), quorum, proof.TxID, sl.shardID, errs)
+	}
+	sort.Slice(cert, func(i, j int) bool { return cert[i].ReplicaID < cert[j].ReplicaID })
+
+	// The proof is shared with other subscribers and the proof cache
+	certified := *proof
+	certified.QuorumCert = cert
+	return &certified, nil
+}
+
+// coSign signs the tuple of the proof of txID once this replica applied the
+// transaction at the given commit index and term
+func (sl *ShardLeader) coSign(ctx context.Context, txID string, commitIndex, term uint64) ([]byte, error) {
+	if sl.config.SigningKey == nil {
+		return nil, fmt.Errorf("replica %d of shard %s has no signing key", sl.config.ReplicaID, sl.shardID)
+	}
+	if err := sl.WaitForIndex(ctx, commitIndex); err != nil {
+		return nil, err
+	}
+
+	sl.proofCacheLock.RLock()
+	proof, exists := sl.proofCache[txID]
+	sl.proofCacheLock.RUnlock()
+	if !exists {
+		proof, exists = sl.appliedProof(txID)
+	}
+	if !exists || proof.CommitIndex != commitIndex || proof.Term != term {
+		return nil, fmt.Errorf("replica %d of shard %s holds no proof of tx %s at index %d and term %d",
+			sl.config.ReplicaID, sl.shardID, txID, commitIndex, term)
+	}
+
+	return ed25519.Sign(sl.config.SigningKey, ProofDigest(sl.shardID, txID, commitIndex, term)), nil
+}
@@ -0,0 +1,189 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"sort"
+	"time"
+)
+
+// KeyRange is a range of keys scanned by a range query, from StartKey
+// included to EndKey excluded. The keys are in the form of those of the
+// ReadSet and WriteSet, prefixed with their namespace.
+type KeyRange struct {
+	StartKey string
+	EndKey   string
+}
+
+// Contains reports whether the key falls within the range
+func (r KeyRange) Contains(key string) bool {
+	return key >= r.StartKey && key < r.EndKey
+}
+
+// RangeReservation is a range scanned by a prepared transaction. A later
+// transaction writing a key within the range depends on it, since the write
+// would have changed the result of the scan.
+type RangeReservation struct {
+	KeyRange
+	TxID        string
+	ExpiryTime  time.Time
+	Timestamp   int64
+	CommitIndex uint64 `json:",omitempty"`
+}
+
+// dependencyInfo returns the reservation in the form that the ConflictWindow
+// checks
+func (r RangeReservation) dependencyInfo() TransactionDependencyInfo {
+	return TransactionDependencyInfo{
+		DependentTxID: r.TxID,
+		ExpiryTime:    r.ExpiryTime,
+		Timestamp:     r.Timestamp,
+		CommitIndex:   r.CommitIndex,
+	}
+}
+
+// rangeDependencies adds to conflicts the holders of the reservations within
+// the ranges scanned by the request, and the transactions that scanned a range
+// containing a key the request reserves. It reports whether it found any.
+func (sl *ShardLeader) rangeDependencies(req *PrepareRequestProto, commitIndex uint64, conflicts conflictSet) bool {
+	ranges := append(append([]KeyRange(nil), req.RangeReads...), req.ReservedRanges...)
+	if len(ranges) == 0 && len(req.WriteSet) == 0 {
+		return false
+	}
+
+	hasDependency := false
+	if len(ranges) > 0 {
+		sl.variableMapLock.RLock()
+		sl.variableMap.Range(func(key string, info TransactionDependencyInfo) {
+			if info.DependentTxID == req.TxID || !sl.config.ConflictWindow.covers(info, commitIndex, req.Timestamp) {
+				return
+			}
+			for _, r := range ranges {
+				if r.Contains(key) {
+					hasDependency = true
+					if info.DependentTxID != "" {
+						conflicts.add(info.DependentTxID, classifyConflict(true, info.Read))
+					}
+					logger.Debugf("Shard %s: Tx %s has range dependency on %s for key %s", sl.shardID, req.TxID, info.DependentTxID, key)
+					return
+				}
+			}
+		})
+		sl.variableMapLock.RUnlock()
+	}
+
+	sl.rangesLock.RLock()
+	defer sl.rangesLock.RUnlock()
+	for txID, reservations := range sl.rangeReservations {
+		if txID == req.TxID {
+			continue
+		}
+		for _, r := range reservations {
+			if !sl.config.ConflictWindow.covers(r.dependencyInfo(), commitIndex, req.Timestamp) {
+				continue
+			}
+			// Every key is classified, so that replicas iterating the
+			// WriteSet in different orders find the same conflicts
+			for key := range req.WriteSet {
+				if r.Contains(key) {
+					_, read := req.ReadSet[key]
+					hasDependency = true
+					conflicts.add(txID, classifyConflict(read, true))
+					logger.Debugf("Shard %s: Tx %s reserves key %s within the range scanned by %s", sl.shardID, req.TxID, key, txID)
+				}
+			}
+		}
+	}
+	return hasDependency
+}
+
+// reserveRanges records the ranges the request reserves. It must only be
+// called from applyEntry.
+func (sl *ShardLeader) reserveRanges(req *PrepareRequestProto, expiryTime time.Time, commitIndex uint64) {
+	if len(req.ReservedRanges) == 0 {
+		return
+	}
+	sl.rangesLock.Lock()
+	defer sl.rangesLock.Unlock()
+	if sl.rangeReservations == nil {
+		sl.rangeReservations = make(map[string][]RangeReservation)
+	}
+	// A duplicate of the request replaces the ranges it reserved before
+	reservations := make([]RangeReservation, 0, len(req.ReservedRanges))
+	for _, r := range req.ReservedRanges {
+		reservations = append(reservations, RangeReservation{
+			KeyRange:    r,
+			TxID:        req.TxID,
+			ExpiryTime:  expiryTime,
+			Timestamp:   req.Timestamp,
+			CommitIndex: commitIndex,
+		})
+	}
+	sl.rangeReservations[req.TxID] = reservations
+}
+
+// releaseRanges drops the ranges reserved by the transactions
+func (sl *ShardLeader) releaseRanges(released map[string]bool) {
+	sl.rangesLock.Lock()
+	defer sl.rangesLock.Unlock()
+	for txID := range released {
+		delete(sl.rangeReservations, txID)
+	}
+}
+
+// pruneRanges drops the ranges that expired by now, and returns their number
+func (sl *ShardLeader) pruneRanges(now time.Time) int {
+	sl.rangesLock.Lock()
+	defer sl.rangesLock.Unlock()
+	pruned := 0
+	for txID, reservations := range sl.rangeReservations {
+		kept := reservations[:0]
+		for _, r := range reservations {
+			if expired(r.dependencyInfo(), now) {
+				pruned++
+			} else {
+				kept = append(kept, r)
+			}
+		}
+		if len(kept) == 0 {
+			delete(sl.rangeReservations, txID)
+		} else {
+			sl.rangeReservations[txID] = kept
+		}
+	}
+	return pruned
+}
+
+// RangeReservations returns the ranges reserved on the shard
+func (sl *ShardLeader) RangeReservations() []RangeReservation {
+	sl.rangesLock.RLock()
+	defer sl.rangesLock.RUnlock()
+	var reservations []RangeReservation
+	for _, rs := range sl.rangeReservations {
+		reservations = append(reservations, rs...)
+	}
+	sort.Slice(reservations, func(i, j int) bool {
+		if reservations[i].TxID != reservations[j].TxID {
+			return reservations[i].TxID < reservations[j].TxID
+		}
+		return reservations[i].StartKey < reservations[j].StartKey
+	})
+	return reservations
+}
+
+// restoreRanges replaces the range reservations with those of a snapshot
+func (sl *ShardLeader) restoreRanges(reservations []RangeReservation) {
+	sl.rangesLock.Lock()
+	defer sl.rangesLock.Unlock()
+	sl.rangeReservations = nil
+	for _, r := range reservations {
+		if sl.rangeReservations == nil {
+			sl.rangeReservations = make(map[string][]RangeReservation)
+		}
+		sl.rangeReservations[r.TxID] = append(sl.rangeReservations[r.TxID], r)
+	}
+}
@@ -0,0 +1,81 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"go.etcd.io/etcd/raft/v3/raftpb"
+)
+
+func TestShardLeaderRangeReads(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	sl, err := NewShardLeader(ShardConfig{
+		ShardID:    "fabcar",
+		ReplicaIDs: []uint64{1},
+		ReplicaID:  1,
+	}, DefaultBatchTimeout, DefaultBatchMaxSize)
+	gt.Expect(err).NotTo(HaveOccurred())
+	defer sl.Stop()
+
+	gt.Eventually(func() bool { return sl.GetStatus().IsLeader }, 30*time.Second, 100*time.Millisecond).Should(BeTrue())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	prepare := func(req *PrepareRequest) *PrepareProof {
+		req.ShardID = "fabcar"
+		req.Timestamp = Clock().Now()
+		proof, err := sl.Prepare(ctx, req)
+		gt.Expect(err).NotTo(HaveOccurred())
+		return proof
+	}
+	scanned := []KeyRange{{StartKey: "fabcar:car1", EndKey: "fabcar:car5"}}
+
+	// A writer that scanned a range reserves it, even with no key to write
+	// on this shard
+	proof := prepare(&PrepareRequest{TxID: "tx1", ReservedRanges: scanned})
+	gt.Expect(proof.HasDependency).To(BeFalse())
+	gt.Expect(sl.RangeReservations()).To(HaveLen(1))
+
+	// Writes within the range depend on the scan, the others do not
+	proof = prepare(&PrepareRequest{TxID: "tx2", WriteSet: map[string][]byte{"fabcar:car3": []byte("red")}})
+	gt.Expect(proof.DependentTxID).To(Equal("tx1"))
+	proof = prepare(&PrepareRequest{TxID: "tx3", WriteSet: map[string][]byte{"fabcar:car7": []byte("red")}})
+	gt.Expect(proof.HasDependency).To(BeFalse())
+
+	// A read-only scan depends on the reservations within its range
+	proof = prepare(&PrepareRequest{TxID: "tx4", RangeReads: []KeyRange{{StartKey: "fabcar:car0", EndKey: "fabcar:car4"}}})
+	gt.Expect(proof.DependentTxID).To(Equal("tx2"))
+	gt.Expect(sl.RangeReservations()).To(HaveLen(1))
+
+	// Aborting the scan releases its range
+	_, err = sl.AbortAndWait(ctx, "tx1")
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(sl.RangeReservations()).To(BeEmpty())
+	proof = prepare(&PrepareRequest{TxID: "tx5", WriteSet: map[string][]byte{"fabcar:car2": []byte("red")}})
+	gt.Expect(proof.HasDependency).To(BeFalse())
+}
+
+func TestRangeReservationsGC(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	now := time.Now()
+	sl := &ShardLeader{shardID: "fabcar", variableMap: newMemoryDependencyTable()}
+	sl.restoreRanges([]RangeReservation{
+		{KeyRange: KeyRange{StartKey: "fabcar:a", EndKey: "fabcar:b"}, TxID: "old", ExpiryTime: now.Add(-time.Second)},
+		{KeyRange: KeyRange{StartKey: "fabcar:c", EndKey: "fabcar:d"}, TxID: "new", ExpiryTime: now.Add(time.Second)},
+	})
+	sl.applyGC(&GCEntry{Now: now.UnixNano()}, raftpb.Entry{Index: 1})
+
+	reservations := sl.RangeReservations()
+	gt.Expect(reservations).To(HaveLen(1))
+	gt.Expect(reservations[0].TxID).To(Equal("new"))
+}
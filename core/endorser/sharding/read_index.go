@@ -0,0 +1,144 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sync/atomic"
+
+	"go.etcd.io/etcd/raft/v3"
+)
+
+// QueryDependency returns the dependency info recorded for key as of a Raft
+// ReadIndex, so the answer reflects every prepare committed before the call
+// without appending an entry to the log. Followers obtain the read index from
+// the leader and answer once they have applied up to it.
+func (sl *ShardLeader) QueryDependency(ctx context.Context, key string) (*DependencyRead, error) {
+	if _, err := sl.readIndex(ctx); err != nil {
+		return nil, err
+	}
+
+	read := &DependencyRead{
+		ShardID:      sl.shardID,
+		Key:          key,
+		AppliedIndex: atomic.LoadUint64(&sl.appliedIndex),
+		IsLeader:     sl.node.Status().RaftState == raft.StateLeader,
+	}
+	read.Info, read.Found = sl.reservation(key)
+
+	return read, nil
+}
+
+// readIndex obtains a read index from the leader and returns it once this
+// replica applied the log up to it
+func (sl *ShardLeader) readIndex(ctx context.Context) (uint64, error) {
+	// Tag the request with this replica's ID so that read states returned to
+	// other replicas never release a local waiter
+	rctx := make([]byte, 16)
+	binary.BigEndian.PutUint64(rctx, sl.config.ReplicaID)
+	binary.BigEndian.PutUint64(rctx[8:], atomic.AddUint64(&sl.readIndexSeq, 1))
+
+	indexC := make(chan uint64, 1)
+	sl.mu.Lock()
+	sl.readIndexWaiters[string(rctx)] = indexC
+	sl.mu.Unlock()
+	defer func() {
+		sl.mu.Lock()
+		delete(sl.readIndexWaiters, string(rctx))
+		sl.mu.Unlock()
+	}()
+
+	if err := sl.node.ReadIndex(ctx, rctx); err != nil {
+		return 0, fmt.Errorf("failed to request read index from shard %s: %v", sl.shardID, err)
+	}
+
+	var index uint64
+	select {
+	case index = <-indexC:
+	case <-sl.stopC:
+		return 0, fmt.Errorf("shard %s: %w", sl.shardID, ErrStopped)
+	case <-ctx.Done():
+		return 0, fmt.Errorf("timeout waiting for read index from shard %s", sl.shardID)
+	}
+
+	if err := sl.WaitForIndex(ctx, index); err != nil {
+		return 0, err
+	}
+	return index, nil
+}
+
+// deliverReadStates hands the read indexes confirmed by the leader to the
+// queries waiting for them. It must only be called from runRaft.
+func (sl *ShardLeader) deliverReadStates(states []raft.ReadState) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	for _, rs := range states {
+		if indexC, ok := sl.readIndexWaiters[string(rs.RequestCtx)]; ok {
+			delete(sl.readIndexWaiters, string(rs.RequestCtx))
+			indexC <- rs.Index
+		}
+	}
+}
+
+// WaitForIndex blocks until the replica has applied the entry at index, so
+// that reads served afterwards observe every write committed up to it. The
+// CommitIndex of a PrepareProof can be passed to give another endorser
+// read-after-write semantics.
+func (sl *ShardLeader) WaitForIndex(ctx context.Context, index uint64) error {
+	for {
+		// Fetch the channel before checking the index, so an apply in between
+		// closes the channel we wait on
+		sl.mu.RLock()
+		appliedC := sl.appliedC
+		sl.mu.RUnlock()
+
+		if atomic.LoadUint64(&sl.appliedIndex) >= index {
+			return nil
+		}
+
+		select {
+		case <-appliedC:
+		case <-sl.stopC:
+			return fmt.Errorf("shard %s: %w", sl.shardID, ErrStopped)
+		case <-ctx.Done():
+			return fmt.Errorf("timeout waiting for shard %s to apply index %d: %w", sl.shardID, index, ctx.Err())
+		}
+	}
+}
+
+// notifyApplied wakes up everyone waiting in WaitForIndex. It must only be
+// called from runApply after appliedIndex advanced.
+func (sl *ShardLeader) notifyApplied() {
+	sl.mu.Lock()
+	close(sl.appliedC)
+	sl.appliedC = make(chan struct{})
+	sl.mu.Unlock()
+}
+
+// QueryDependency serves a linearizable dependency read from the local
+// replica of the shard
+func (sm *ShardManager) QueryDependency(ctx context.Context, shardID, key string) (*DependencyRead, error) {
+	shard, exists := sm.lookupShard(shardID)
+
+	if !exists {
+		return nil, fmt.Errorf("shard %s is not hosted on this peer", shardID)
+	}
+	return shard.QueryDependency(ctx, key)
+}
+
+// WaitForIndex blocks until the local replica of the shard has applied the
+// entry at index
+func (sm *ShardManager) WaitForIndex(ctx context.Context, shardID string, index uint64) error {
+	shard, exists := sm.lookupShard(shardID)
+
+	if !exists {
+		return fmt.Errorf("shard %s is not hosted on this peer", shardID)
+	}
+	return shard.WaitForIndex(ctx, index)
+}
@@ -0,0 +1,107 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestQueryDependencyReadIndex(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	peers := PeerConfig{1: freeTransportAddress(t), 2: freeTransportAddress(t)}
+	shards := make(map[uint64]*ShardLeader)
+	for id := range peers {
+		transport := NewTransport(id, peers[id], peers)
+		gt.Expect(transport.Start()).To(Succeed())
+		defer transport.Stop()
+
+		sl, err := NewShardLeader(ShardConfig{
+			ShardID:    "fabcar",
+			ReplicaIDs: []uint64{1, 2},
+			ReplicaID:  id,
+		}, DefaultBatchTimeout, DefaultBatchMaxSize)
+		gt.Expect(err).NotTo(HaveOccurred())
+		defer sl.Stop()
+		transport.RegisterShard("fabcar", sl)
+		shards[id] = sl
+	}
+
+	// Replicas campaign only after their election timeout
+	var leaderID uint64
+	gt.Eventually(func() uint64 {
+		leaderID = shards[1].GetStatus().LeaderID
+		return leaderID
+	}, 30*time.Second, 100*time.Millisecond).ShouldNot(BeZero())
+	leader, follower := shards[leaderID], shards[3-leaderID]
+	gt.Eventually(func() uint64 { return follower.GetStatus().LeaderID }, 5*time.Second).Should(Equal(leaderID))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := leader.ProposeAndWait(ctx, &PrepareRequest{
+		TxID:     "tx1",
+		ShardID:  "fabcar",
+		WriteSet: map[string][]byte{"car1": []byte("v1")},
+	})
+	gt.Expect(err).NotTo(HaveOccurred())
+	commitIndex := leader.GetStatus().CommitIndex
+
+	for _, sl := range []*ShardLeader{leader, follower} {
+		read, err := sl.QueryDependency(ctx, "car1")
+		gt.Expect(err).NotTo(HaveOccurred())
+		gt.Expect(read.Found).To(BeTrue())
+		gt.Expect(read.Info.DependentTxID).To(Equal("tx1"))
+		gt.Expect(read.AppliedIndex).To(BeNumerically(">=", commitIndex))
+	}
+
+	// Reads do not append to the log
+	read, err := follower.QueryDependency(ctx, "car2")
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(read.Found).To(BeFalse())
+	gt.Expect(leader.GetStatus().CommitIndex).To(Equal(commitIndex))
+}
+
+func TestWaitForIndex(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	sl := &ShardLeader{
+		shardID:  "fabcar",
+		appliedC: make(chan struct{}),
+		stopC:    make(chan struct{}),
+	}
+	apply := func(index uint64) {
+		atomic.StoreUint64(&sl.appliedIndex, index)
+		sl.notifyApplied()
+	}
+	apply(3)
+
+	// indexes already applied return immediately
+	gt.Expect(sl.WaitForIndex(context.Background(), 3)).To(Succeed())
+
+	errC := make(chan error, 1)
+	go func() { errC <- sl.WaitForIndex(context.Background(), 5) }()
+	apply(4)
+	gt.Consistently(errC, 100*time.Millisecond).ShouldNot(Receive())
+	apply(5)
+	gt.Eventually(errC, time.Second).Should(Receive(BeNil()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := sl.WaitForIndex(ctx, 6)
+	gt.Expect(errors.Is(err, context.DeadlineExceeded)).To(BeTrue())
+	gt.Expect(err).To(MatchError(ContainSubstring("timeout waiting for shard fabcar to apply index 6")))
+
+	go func() { errC <- sl.WaitForIndex(context.Background(), 6) }()
+	close(sl.stopC)
+	gt.Eventually(errC, time.Second).Should(Receive(MatchError(ErrStopped)))
+}
@@ -0,0 +1,67 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import "context"
+
+// Prepare returns the proof of the request. A read-only request, which
+// reserves no key, is answered from a ReadIndex without appending to the log,
+// unless the conflict policy may wound on its behalf or the proofs need a
+// quorum certificate. The others are proposed, and forwarded to the leader by
+// a follower, like ProposeAndWait.
+func (sl *ShardLeader) Prepare(ctx context.Context, req *PrepareRequest) (*PrepareProof, error) {
+	if !sl.answersFromReadIndex(req) {
+		return sl.ProposeAndWait(ctx, req)
+	}
+	if proof, ok := sl.appliedProof(req.TxID); ok {
+		return proof, nil
+	}
+
+	index, err := sl.readIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+	status := sl.node.Status()
+	reqProto := &PrepareRequestProto{
+		TxID:      req.TxID,
+		ShardID:   req.ShardID,
+		ReadSet:   req.ReadSet,
+		Timestamp: req.Timestamp.Wall,
+
+		RangeReads: req.RangeReads,
+	}
+	hasDependency, conflicts := sl.checkDependencies(reqProto, index)
+	dependentTxID := conflicts.dependentTxID()
+	proof := &PrepareProof{
+		TxID:           req.TxID,
+		ShardID:        sl.shardID,
+		CommitIndex:    index,
+		LeaderID:       status.Lead,
+		Term:           status.Term,
+		DependentTxID:  dependentTxID,
+		HasDependency:  hasDependency,
+		ConflictTypes:  conflicts.types(),
+		ConflictPolicy: sl.conflictPolicy,
+		Rejected:       dependentTxID != "" && sl.conflictPolicy == ConflictPolicyFirstWins,
+		HLC:            observe(req),
+	}
+	// A read-only request reserves nothing, so its chain can be taken
+	// outside applyEntry
+	if !proof.Rejected {
+		proof.DependencyChain = sl.dependencyChain(reqProto, dependentTxID)
+	}
+	proof.Signature, proof.SignerID = sl.signProof(req.TxID, index, status.Term)
+	logger.Debugf("Shard %s: Answered read-only tx %s at read index %d", sl.shardID, req.TxID, index)
+	return proof, nil
+}
+
+// answersFromReadIndex reports whether Prepare answers the request from a
+// ReadIndex rather than proposing it
+func (sl *ShardLeader) answersFromReadIndex(req *PrepareRequest) bool {
+	_, readOnly := readOnlyKeySet(req.ReadSet, req.WriteSet)
+	return readOnly && len(req.ReservedRanges) == 0 && !sl.config.QuorumCert && sl.conflictPolicy != ConflictPolicyWoundWait
+}
@@ -0,0 +1,79 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestPrepareReadOnly(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	peers := PeerConfig{1: freeTransportAddress(t), 2: freeTransportAddress(t)}
+	shards := make(map[uint64]*ShardLeader)
+	for id := range peers {
+		transport := NewTransport(id, peers[id], peers)
+		gt.Expect(transport.Start()).To(Succeed())
+		defer transport.Stop()
+
+		sl, err := NewShardLeader(ShardConfig{
+			ShardID:    "fabcar",
+			ReplicaIDs: []uint64{1, 2},
+			ReplicaID:  id,
+		}, DefaultBatchTimeout, DefaultBatchMaxSize)
+		gt.Expect(err).NotTo(HaveOccurred())
+		defer sl.Stop()
+		transport.RegisterShard("fabcar", sl)
+		shards[id] = sl
+	}
+
+	var leaderID uint64
+	gt.Eventually(func() uint64 {
+		leaderID = shards[1].GetStatus().LeaderID
+		return leaderID
+	}, 30*time.Second, 100*time.Millisecond).ShouldNot(BeZero())
+	leader, follower := shards[leaderID], shards[3-leaderID]
+	gt.Eventually(func() uint64 { return follower.GetStatus().LeaderID }, 5*time.Second).Should(Equal(leaderID))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := leader.ProposeAndWait(ctx, &PrepareRequest{
+		TxID:     "tx1",
+		ShardID:  "fabcar",
+		WriteSet: map[string][]byte{"car1": []byte("v1")},
+	})
+	gt.Expect(err).NotTo(HaveOccurred())
+	commitIndex := leader.GetStatus().CommitIndex
+
+	// a read-only request is answered from a ReadIndex, without a log entry
+	proof, err := follower.Prepare(ctx, &PrepareRequest{
+		TxID:    "tx2",
+		ShardID: "fabcar",
+		ReadSet: map[string][]byte{"car1": nil},
+	})
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(proof.DependentTxID).To(Equal("tx1"))
+	gt.Expect(proof.DependencyChain).To(Equal([]string{"tx1"}))
+	gt.Expect(proof.CommitIndex).To(BeNumerically(">=", commitIndex))
+	gt.Expect(VerifyPrepareProof(proof)).To(Succeed())
+	gt.Expect(leader.GetStatus().CommitIndex).To(Equal(commitIndex))
+
+	// a write goes through the leader
+	proof, err = follower.Prepare(ctx, &PrepareRequest{
+		TxID:     "tx3",
+		ShardID:  "fabcar",
+		ReadSet:  map[string][]byte{"car1": nil},
+		WriteSet: map[string][]byte{"car1": []byte("v3")},
+	})
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(proof.DependentTxID).To(Equal("tx1"))
+	gt.Expect(leader.GetStatus().CommitIndex).To(BeNumerically(">", commitIndex))
+}
@@ -0,0 +1,140 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// readProofCache holds the proofs of read-only requests applied at a single
+// Raft index. A read-only request does not modify the dependency map, so its
+// outcome only depends on the keys it touches and the applied state; any
+// newer applied entry invalidates the whole cache. The dependencies of a
+// proof also lapse when their reservations expire, without a new entry, so a
+// proof is only served until the earliest of them expires.
+type readProofCache struct {
+	mu     sync.Mutex
+	index  uint64
+	proofs map[string]readProofEntry
+	hits   uint64
+}
+
+// readProofEntry is a cached proof with the earliest expiry of the
+// reservations it depends on, zero when it depends on none
+type readProofEntry struct {
+	proof   *PrepareProof
+	expires time.Time
+}
+
+// readOnlyKeySet returns the cache key of a request, or false if the request
+// reserves keys and therefore must go through Raft
+func readOnlyKeySet(readSet, writeSet map[string][]byte) (string, bool) {
+	if len(writeSet) > 0 {
+		return "", false
+	}
+	keys := make([]string, 0, len(readSet))
+	for k := range readSet {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	h := sha256.New()
+	for _, k := range keys {
+		h.Write([]byte(k))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil)), true
+}
+
+// store caches the proof of a read-only request applied at index, valid until
+// expires unless that is zero
+func (c *readProofCache) store(keySet string, index uint64, proof *PrepareProof, expires time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.proofs == nil || c.index != index {
+		c.index = index
+		c.proofs = make(map[string]readProofEntry)
+	}
+	c.proofs[keySet] = readProofEntry{proof: proof, expires: expires}
+}
+
+// lookup returns the cached proof for the key set if it was produced at the
+// currently applied index and none of its dependencies expired by now
+func (c *readProofCache) lookup(keySet string, appliedIndex uint64, now time.Time) (*PrepareProof, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.index != appliedIndex {
+		return nil, false
+	}
+	entry, exists := c.proofs[keySet]
+	if !exists || (!entry.expires.IsZero() && !entry.expires.After(now)) {
+		return nil, false
+	}
+	c.hits++
+	return entry.proof, true
+}
+
+// dependencyExpiry returns the earliest expiry of the reservations of the
+// read keys held by the dependencies of the proof, zero when it has none
+func (sl *ShardLeader) dependencyExpiry(readSet map[string][]byte, proof *PrepareProof) time.Time {
+	if !proof.HasDependency || proof.DependentTxID == "" {
+		return time.Time{}
+	}
+	dependencies := make(map[string]bool)
+	for _, txID := range strings.Split(proof.DependentTxID, ",") {
+		dependencies[txID] = true
+	}
+
+	var earliest time.Time
+	for key := range readSet {
+		info, exists := sl.reservation(key)
+		if !exists || !dependencies[info.DependentTxID] || info.ExpiryTime.IsZero() {
+			continue
+		}
+		if earliest.IsZero() || info.ExpiryTime.Before(earliest) {
+			earliest = info.ExpiryTime
+		}
+	}
+	return earliest
+}
+
+// CachedReadOnlyProof returns a proof for a read-only request without going
+// through Raft when an identical key set was prepared at the currently applied
+// index and the reservations it depends on have not expired since. The cached
+// outcome is re-issued under the request's TxID.
+func (sl *ShardLeader) CachedReadOnlyProof(req *PrepareRequest) (*PrepareProof, bool) {
+	keySet, ok := readOnlyKeySet(req.ReadSet, req.WriteSet)
+	if !ok || len(req.RangeReads) > 0 || len(req.ReservedRanges) > 0 {
+		return nil, false
+	}
+
+	cached, exists := sl.readProofs.lookup(keySet, atomic.LoadUint64(&sl.appliedIndex), time.Now())
+	if !exists {
+		return nil, false
+	}
+
+	proof := *cached
+	proof.TxID = req.TxID
+	proof.Signature, proof.SignerID = sl.signProof(req.TxID, proof.CommitIndex, proof.Term)
+	logger.Debugf("Shard %s: Reused read-only proof at index %d for tx %s", sl.shardID, proof.CommitIndex, req.TxID)
+	return &proof, true
+}
+
+// ReadProofCacheHits returns the number of proofs served from the read-only proof cache
+func (sl *ShardLeader) ReadProofCacheHits() uint64 {
+	sl.readProofs.mu.Lock()
+	defer sl.readProofs.mu.Unlock()
+	return sl.readProofs.hits
+}
@@ -0,0 +1,94 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestReadOnlyKeySet(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	_, ok := readOnlyKeySet(nil, map[string][]byte{"k1": nil})
+	gt.Expect(ok).To(BeFalse())
+
+	ks1, ok := readOnlyKeySet(map[string][]byte{"k1": nil, "k2": nil}, nil)
+	gt.Expect(ok).To(BeTrue())
+	ks2, _ := readOnlyKeySet(map[string][]byte{"k2": []byte("v"), "k1": nil}, map[string][]byte{})
+	gt.Expect(ks2).To(Equal(ks1))
+	ks3, _ := readOnlyKeySet(map[string][]byte{"k1k2": nil}, nil)
+	gt.Expect(ks3).NotTo(Equal(ks1))
+}
+
+func TestCachedReadOnlyProof(t *testing.T) {
+	gt := NewGomegaWithT(t)
+	sl := &ShardLeader{shardID: "testContract"}
+
+	req := &PrepareRequest{TxID: "tx2", ReadSet: map[string][]byte{"k1": nil}}
+	_, ok := sl.CachedReadOnlyProof(req)
+	gt.Expect(ok).To(BeFalse())
+
+	keySet, _ := readOnlyKeySet(req.ReadSet, nil)
+	sl.readProofs.store(keySet, 5, &PrepareProof{
+		TxID:          "tx1",
+		ShardID:       "testContract",
+		CommitIndex:   5,
+		Signature:     signPrepareProof("testContract", 5, "tx1"),
+		HasDependency: true,
+		DependentTxID: "tx0",
+	}, time.Now().Add(time.Minute))
+
+	// the cache only serves proofs produced at the applied index
+	sl.appliedIndex = 4
+	_, ok = sl.CachedReadOnlyProof(req)
+	gt.Expect(ok).To(BeFalse())
+
+	sl.appliedIndex = 5
+	proof, ok := sl.CachedReadOnlyProof(req)
+	gt.Expect(ok).To(BeTrue())
+	gt.Expect(proof.TxID).To(Equal("tx2"))
+	gt.Expect(string(proof.Signature)).To(Equal("testContract:5:tx2"))
+	gt.Expect(proof.DependentTxID).To(Equal("tx0"))
+	gt.Expect(sl.ReadProofCacheHits()).To(Equal(uint64(1)))
+
+	_, ok = sl.CachedReadOnlyProof(&PrepareRequest{TxID: "tx3", ReadSet: req.ReadSet, WriteSet: map[string][]byte{"k2": nil}})
+	gt.Expect(ok).To(BeFalse())
+
+	// the proof lapses once a reservation it depends on expires
+	sl.readProofs.store(keySet, 5, &PrepareProof{TxID: "tx1", HasDependency: true, DependentTxID: "tx0"}, time.Now().Add(-time.Second))
+	_, ok = sl.CachedReadOnlyProof(req)
+	gt.Expect(ok).To(BeFalse())
+
+	// storing at a newer index drops the older proofs
+	sl.readProofs.store("other", 6, &PrepareProof{}, time.Time{})
+	sl.appliedIndex = 6
+	_, ok = sl.CachedReadOnlyProof(req)
+	gt.Expect(ok).To(BeFalse())
+}
+
+func TestReadProofDependencyExpiry(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	now := time.Unix(0, 1000)
+	table := newMemoryDependencyTable()
+	gt.Expect(table.Reset(map[string]TransactionDependencyInfo{
+		"k1": {DependentTxID: "tx1", ExpiryTime: now.Add(2 * time.Second)},
+		"k2": {DependentTxID: "tx2", ExpiryTime: now.Add(time.Second)},
+		"k3": {DependentTxID: "tx3", ExpiryTime: now.Add(-time.Second)},
+		"k4": {DependentTxID: "tx4"},
+	}, nil, 0)).To(Succeed())
+	sl := &ShardLeader{shardID: "fabcar", variableMap: table}
+
+	readSet := map[string][]byte{"k1": nil, "k2": nil, "k3": nil, "k4": nil}
+	gt.Expect(sl.dependencyExpiry(readSet, &PrepareProof{})).To(BeZero())
+	gt.Expect(sl.dependencyExpiry(readSet, &PrepareProof{HasDependency: true, DependentTxID: "tx1,tx2"})).To(Equal(now.Add(time.Second)))
+	gt.Expect(sl.dependencyExpiry(readSet, &PrepareProof{HasDependency: true, DependentTxID: "tx1"})).To(Equal(now.Add(2 * time.Second)))
+	gt.Expect(sl.dependencyExpiry(readSet, &PrepareProof{HasDependency: true, DependentTxID: "tx4"})).To(BeZero())
+}
@@ -0,0 +1,119 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// recoveryReadInterval bounds each attempt to obtain a read index while a
+// restarted shard has no leader yet, since Raft drops the reads it receives
+// before an election
+const recoveryReadInterval = time.Second
+
+// OriginPrepare is a transaction prepared on a shard by a given endorser
+// whose reservations are still held
+type OriginPrepare struct {
+	TxID    string
+	ShardID string
+	// Proof is the proof the shard issued for the prepare, nil when it was
+	// applied longer ago than the shard remembers proofs
+	Proof *PrepareProof `json:",omitempty"`
+	// ExpiryTime is the latest expiry of the reservations of the prepare
+	ExpiryTime time.Time
+}
+
+// RecoverableStore is a DependencyStore that finds the prepares an endorser
+// made, so that the endorser resumes or releases them after a restart.
+// Stores that do not record the endorser of a prepare do not implement it.
+type RecoverableStore interface {
+	DependencyStore
+	// PreparesOf returns the prepares of the endorser whose reservations are
+	// held, ordered by shard and transaction
+	PreparesOf(ctx context.Context, origin string) ([]*OriginPrepare, error)
+}
+
+// PreparesOf returns the prepares of the endorser whose reservations are held
+// by the shard, as of a read index so that every prepare committed before a
+// restart was applied. It waits for the shard to elect a leader until ctx
+// ends.
+func (sl *ShardLeader) PreparesOf(ctx context.Context, origin string) ([]*OriginPrepare, error) {
+	for {
+		readCtx, cancel := context.WithTimeout(ctx, recoveryReadInterval)
+		_, err := sl.readIndex(readCtx)
+		cancel()
+		if err == nil {
+			break
+		}
+		if ctx.Err() != nil || errors.Is(err, ErrStopped) {
+			return nil, err
+		}
+	}
+
+	byTx := make(map[string]*OriginPrepare)
+	now := time.Now()
+	sl.variableMapLock.RLock()
+	sl.variableMap.Range(func(key string, info TransactionDependencyInfo) {
+		if info.Origin != origin || now.After(info.ExpiryTime) {
+			return
+		}
+		prepare, ok := byTx[info.DependentTxID]
+		if !ok {
+			prepare = &OriginPrepare{TxID: info.DependentTxID, ShardID: sl.shardID}
+			byTx[info.DependentTxID] = prepare
+		}
+		if info.ExpiryTime.After(prepare.ExpiryTime) {
+			prepare.ExpiryTime = info.ExpiryTime
+		}
+	})
+	sl.variableMapLock.RUnlock()
+
+	prepares := make([]*OriginPrepare, 0, len(byTx))
+	for txID, prepare := range byTx {
+		if proof, ok := sl.appliedProof(txID); ok {
+			prepare.Proof = proof
+		}
+		prepares = append(prepares, prepare)
+	}
+	sort.Slice(prepares, func(i, j int) bool { return prepares[i].TxID < prepares[j].TxID })
+	return prepares, nil
+}
+
+// PreparesOf returns the prepares of the endorser held by the shards running
+// on this peer. The prepares of the shards that answered are returned along
+// with an error naming those that did not.
+func (sm *ShardManager) PreparesOf(ctx context.Context, origin string) ([]*OriginPrepare, error) {
+	sm.shardsLock.RLock()
+	shardIDs := make([]string, 0, len(sm.shards))
+	shards := make(map[string]*ShardLeader, len(sm.shards))
+	for shardID, shard := range sm.shards {
+		shardIDs = append(shardIDs, shardID)
+		shards[shardID] = shard
+	}
+	sm.shardsLock.RUnlock()
+	sort.Strings(shardIDs)
+
+	var prepares []*OriginPrepare
+	var failed []string
+	for _, shardID := range shardIDs {
+		found, err := shards[shardID].PreparesOf(ctx, origin)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", shardID, err))
+			continue
+		}
+		prepares = append(prepares, found...)
+	}
+	if len(failed) > 0 {
+		return prepares, fmt.Errorf("failed to find the prepares of endorser %s on %d shards: %s", origin, len(failed), strings.Join(failed, "; "))
+	}
+	return prepares, nil
+}
@@ -0,0 +1,82 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestPreparesOfSurviveRestart(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	dataDir, err := ioutil.TempDir("", "shard-recovery")
+	gt.Expect(err).NotTo(HaveOccurred())
+	defer os.RemoveAll(dataDir)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	start := func() *ShardLeader {
+		sl, err := NewShardLeader(ShardConfig{
+			ShardID:       "fabcar",
+			ReplicaIDs:    []uint64{1},
+			ReplicaID:     1,
+			DataDir:       dataDir,
+			DependencyTTL: time.Hour,
+			GCInterval:    time.Hour,
+		}, DefaultBatchTimeout, DefaultBatchMaxSize)
+		gt.Expect(err).NotTo(HaveOccurred())
+		return sl
+	}
+
+	sl := start()
+	gt.Eventually(func() bool { return sl.GetStatus().IsLeader }, 30*time.Second, 100*time.Millisecond).Should(BeTrue())
+	proofs := make(map[string]*PrepareProof)
+	for _, prepare := range []struct{ txID, origin string }{{"tx1", "peer0"}, {"tx2", "peer1"}, {"tx3", "peer0"}} {
+		txID := prepare.txID
+		proof, err := sl.ProposeAndWait(ctx, &PrepareRequest{
+			TxID:      txID,
+			ShardID:   "fabcar",
+			WriteSet:  map[string][]byte{"fabcar:" + txID: []byte("v1"), "fabcar:" + txID + "b": []byte("v1")},
+			Timestamp: Clock().Now(),
+			Origin:    prepare.origin,
+		})
+		gt.Expect(err).NotTo(HaveOccurred())
+		proofs[txID] = proof
+	}
+	sl.Stop()
+
+	// the prepares of the endorser are found again once the log is replayed
+	sl = start()
+	defer sl.Stop()
+	prepares, err := sl.PreparesOf(ctx, "peer0")
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(prepares).To(HaveLen(2))
+	for i, txID := range []string{"tx1", "tx3"} {
+		gt.Expect(prepares[i].TxID).To(Equal(txID))
+		gt.Expect(prepares[i].ShardID).To(Equal("fabcar"))
+		gt.Expect(prepares[i].Proof.CommitIndex).To(Equal(proofs[txID].CommitIndex))
+		gt.Expect(prepares[i].Proof.Signature).To(Equal(proofs[txID].Signature))
+		gt.Expect(prepares[i].ExpiryTime).To(BeTemporally(">", time.Now().Add(time.Minute)))
+	}
+
+	gt.Expect(sl.Dependencies()["fabcar:tx2"].Origin).To(Equal("peer1"))
+	prepares, err = sl.PreparesOf(ctx, "peer2")
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(prepares).To(BeEmpty())
+
+	sm := &ShardManager{shards: map[string]*ShardLeader{"fabcar": sl}}
+	prepares, err = sm.PreparesOf(ctx, "peer1")
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(prepares).To(HaveLen(1))
+	gt.Expect(prepares[0].TxID).To(Equal("tx2"))
+}
@@ -0,0 +1,317 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/fabric/core/endorser/sharding/protos"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// ShardRegistryEnvVar is the address of the peer that hosts the shard
+	// registry. When it is set, shard leaders register their shards with it
+	// and remote proofs are requested from the registered leaders.
+	ShardRegistryEnvVar = "FABRIC_SHARD_REGISTRY"
+	// ShardRegistryServeEnvVar makes the peer host the shard registry on its
+	// shard transport when set to true
+	ShardRegistryServeEnvVar = "FABRIC_SHARD_REGISTRY_SERVE"
+
+	// DefaultRegistrationTTL is how long a registration is kept without
+	// being refreshed. Leaders refresh theirs three times per TTL.
+	DefaultRegistrationTTL = 30 * time.Second
+
+	// registryLookupCacheTTL is how long a client reuses a lookup
+	registryLookupCacheTTL = 5 * time.Second
+	registryCallTimeout    = 5 * time.Second
+)
+
+// registryServeFromEnv reports whether ShardRegistryServeEnvVar is set
+func registryServeFromEnv() bool {
+	return envBool(ShardRegistryServeEnvVar)
+}
+
+// ShardRegistry records where the shards of each contract run, as announced
+// by their leaders. Registrations expire unless they are refreshed, so the
+// shards of a failed leader disappear once its successor registers them or
+// the TTL passes.
+type ShardRegistry struct {
+	protos.UnimplementedShardRegistryServer
+
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]registryEntry
+}
+
+type registryEntry struct {
+	registration *protos.ShardRegistration
+	expiresAt    time.Time
+}
+
+// NewShardRegistry creates an empty registry whose registrations expire
+// after ttl
+func NewShardRegistry(ttl time.Duration) *ShardRegistry {
+	if ttl <= 0 {
+		ttl = DefaultRegistrationTTL
+	}
+	return &ShardRegistry{
+		ttl:     ttl,
+		entries: make(map[string]registryEntry),
+	}
+}
+
+// Register records the registration of a shard. A registration from an
+// earlier term than the live one is rejected, so that a deposed leader cannot
+// replace its successor.
+func (r *ShardRegistry) Register(ctx context.Context, reg *protos.ShardRegistration) (*protos.RegisterResponse, error) {
+	if reg.ShardId == "" {
+		return &protos.RegisterResponse{Error: "missing shard ID"}, nil
+	}
+	reg = proto.Clone(reg).(*protos.ShardRegistration)
+	if reg.Contract == "" {
+		reg.Contract = ContractOfShard(reg.ShardId)
+	}
+
+	now := time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if current, ok := r.entries[reg.ShardId]; ok && now.Before(current.expiresAt) && current.registration.Term > reg.Term {
+		return &protos.RegisterResponse{
+			Error: fmt.Sprintf("shard %s is registered in term %d, after term %d", reg.ShardId, current.registration.Term, reg.Term),
+		}, nil
+	}
+	r.entries[reg.ShardId] = registryEntry{registration: reg, expiresAt: now.Add(r.ttl)}
+	return &protos.RegisterResponse{Success: true}, nil
+}
+
+// Lookup returns the live registrations of the shards of the contract, or of
+// all shards when no contract is given, ordered by shard ID
+func (r *ShardRegistry) Lookup(ctx context.Context, req *protos.LookupRequest) (*protos.LookupResponse, error) {
+	now := time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	resp := &protos.LookupResponse{}
+	for shardID, entry := range r.entries {
+		if !now.Before(entry.expiresAt) {
+			delete(r.entries, shardID)
+			continue
+		}
+		if req.Contract != "" && entry.registration.Contract != req.Contract {
+			continue
+		}
+		resp.Shards = append(resp.Shards, proto.Clone(entry.registration).(*protos.ShardRegistration))
+	}
+	sort.Slice(resp.Shards, func(i, j int) bool { return resp.Shards[i].ShardId < resp.Shards[j].ShardId })
+	return resp, nil
+}
+
+// RegistryClient registers shards with and looks them up in the registry
+// hosted by another peer. Lookups are cached briefly, since endorsers look up
+// the same contracts on every remote proof.
+type RegistryClient struct {
+	conn   *grpc.ClientConn
+	client protos.ShardRegistryClient
+
+	mu    sync.Mutex
+	cache map[string]cachedLookup
+}
+
+type cachedLookup struct {
+	shards    []*protos.ShardRegistration
+	expiresAt time.Time
+}
+
+// NewRegistryClient connects to the registry hosted on the shard transport of
+// the peer at address. The connection is plaintext when tlsConfig is nil.
+func NewRegistryClient(address string, tlsConfig *TransportTLS) (*RegistryClient, error) {
+	dialAddr, err := parseAndOffsetPort(address, 20000)
+	if err != nil {
+		return nil, fmt.Errorf("failed to offset port for registry address %s: %v", address, err)
+	}
+	conn, err := grpc.Dial(dialAddr, grpc.WithTransportCredentials(tlsConfig.clientCredentials(0)))
+	if err != nil {
+		return nil, err
+	}
+	return &RegistryClient{
+		conn:   conn,
+		client: protos.NewShardRegistryClient(conn),
+		cache:  make(map[string]cachedLookup),
+	}, nil
+}
+
+// newRegistryClientFromEnv connects to the registry configured via
+// ShardRegistryEnvVar, or returns nil when none is
+func newRegistryClientFromEnv(tlsConfig *TransportTLS) *RegistryClient {
+	address := strings.TrimSpace(os.Getenv(ShardRegistryEnvVar))
+	if address == "" {
+		return nil
+	}
+	client, err := NewRegistryClient(address, tlsConfig)
+	if err != nil {
+		logger.Errorf("Ignoring shard registry %s: %v", address, err)
+		return nil
+	}
+	return client
+}
+
+// Register records the registration of a shard with the registry
+func (c *RegistryClient) Register(ctx context.Context, reg *protos.ShardRegistration) error {
+	resp, err := c.client.Register(ctx, reg)
+	if err != nil {
+		return err
+	}
+	if !resp.Success {
+		return fmt.Errorf("%s", resp.Error)
+	}
+	return nil
+}
+
+// Lookup returns the registered shards of the contract
+func (c *RegistryClient) Lookup(ctx context.Context, contract string) ([]*protos.ShardRegistration, error) {
+	c.mu.Lock()
+	cached, ok := c.cache[contract]
+	c.mu.Unlock()
+	if ok && time.Now().Before(cached.expiresAt) {
+		return cached.shards, nil
+	}
+
+	resp, err := c.client.Lookup(ctx, &protos.LookupRequest{Contract: contract})
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.cache[contract] = cachedLookup{shards: resp.Shards, expiresAt: time.Now().Add(registryLookupCacheTTL)}
+	c.mu.Unlock()
+	return resp.Shards, nil
+}
+
+// LeaderAddress returns the address of the registered leader of the shard
+func (c *RegistryClient) LeaderAddress(ctx context.Context, shardID string) (string, error) {
+	shards, err := c.Lookup(ctx, ContractOfShard(shardID))
+	if err != nil {
+		return "", err
+	}
+	for _, reg := range shards {
+		if reg.ShardId == shardID && reg.LeaderAddress != "" {
+			return reg.LeaderAddress, nil
+		}
+	}
+	return "", fmt.Errorf("shard %s is not registered", shardID)
+}
+
+// Close closes the connection to the registry
+func (c *RegistryClient) Close() error {
+	return c.conn.Close()
+}
+
+// registration describes the shard for the registry. It returns false unless
+// this replica leads the shard, since only leaders register.
+func (sl *ShardLeader) registration(topology *ShardTopology) (*protos.ShardRegistration, bool) {
+	status := sl.node.Status()
+	if status.Lead == 0 || status.Lead != status.ID {
+		return nil, false
+	}
+
+	addrs := make(PeerConfig)
+	if set, ok := topology.ReplicaSet(sl.shardID); ok {
+		for id, addr := range set.Replicas {
+			addrs[id] = addr
+		}
+	}
+	sl.mu.RLock()
+	for id, addr := range sl.peerAddrs {
+		addrs[id] = addr
+	}
+	voters := append([]uint64(nil), sl.members.Voters...)
+	sl.mu.RUnlock()
+
+	sort.Slice(voters, func(i, j int) bool { return voters[i] < voters[j] })
+	members := make([]*protos.ShardMember, 0, len(voters))
+	for _, id := range voters {
+		members = append(members, &protos.ShardMember{Id: id, Address: addrs[id]})
+	}
+
+	return &protos.ShardRegistration{
+		Contract:      ContractOfShard(sl.shardID),
+		ShardId:       sl.shardID,
+		LeaderId:      status.ID,
+		LeaderAddress: advertisedPeerAddress(),
+		Members:       members,
+		Term:          status.Term,
+	}, true
+}
+
+// registryAnnouncer makes the ShardManager register its shards as soon as
+// one of them changes leader
+type registryAnnouncer struct {
+	kickC chan struct{}
+}
+
+func (a *registryAnnouncer) OnShardCreated(shardID string) {}
+
+func (a *registryAnnouncer) OnShardStopped(shardID string) {}
+
+func (a *registryAnnouncer) OnLeaderChanged(shardID string, leaderID uint64) {
+	select {
+	case a.kickC <- struct{}{}:
+	default:
+	}
+}
+
+// runRegistryAnnouncer registers the shards led by this peer with the
+// registry, periodically and whenever a leader changes
+func (sm *ShardManager) runRegistryAnnouncer(interval time.Duration) {
+	announcer := &registryAnnouncer{kickC: make(chan struct{}, 1)}
+	sm.AddObserver(announcer)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-announcer.kickC:
+		case <-sm.stopC:
+			return
+		}
+		sm.announceShards()
+	}
+}
+
+// announceShards registers the shards led by this peer with the registry
+func (sm *ShardManager) announceShards() {
+	sm.shardsLock.RLock()
+	shards := make([]*ShardLeader, 0, len(sm.shards))
+	for _, shard := range sm.shards {
+		shards = append(shards, shard)
+	}
+	sm.shardsLock.RUnlock()
+
+	topology := sm.Topology()
+	for _, shard := range shards {
+		reg, ok := shard.registration(topology)
+		if !ok {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), registryCallTimeout)
+		err := sm.registry.Register(ctx, reg)
+		cancel()
+		if err != nil {
+			logger.Warningf("Failed to register shard %s with the shard registry: %v", reg.ShardId, err)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/endorser/sharding/protos"
+	. "github.com/onsi/gomega"
+)
+
+func TestShardRegistry(t *testing.T) {
+	gt := NewGomegaWithT(t)
+	ctx := context.Background()
+
+	registry := NewShardRegistry(time.Hour)
+	for _, reg := range []*protos.ShardRegistration{
+		{ShardId: "fabcar", LeaderId: 1, LeaderAddress: "peer0:7051", Term: 2},
+		{ShardId: "marbles" + PartitionSeparator + "1", LeaderId: 2, LeaderAddress: "peer1:7051", Term: 1},
+		{ShardId: "marbles" + PartitionSeparator + "0", LeaderId: 3, LeaderAddress: "peer2:7051", Term: 1},
+	} {
+		resp, err := registry.Register(ctx, reg)
+		gt.Expect(err).NotTo(HaveOccurred())
+		gt.Expect(resp.Success).To(BeTrue())
+	}
+
+	// The contract of partitioned shards is derived from their IDs
+	resp, err := registry.Lookup(ctx, &protos.LookupRequest{Contract: "marbles"})
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(resp.Shards).To(HaveLen(2))
+	gt.Expect(resp.Shards[0].ShardId).To(Equal("marbles" + PartitionSeparator + "0"))
+	gt.Expect(resp.Shards[0].Contract).To(Equal("marbles"))
+	gt.Expect(resp.Shards[1].LeaderAddress).To(Equal("peer1:7051"))
+
+	resp, err = registry.Lookup(ctx, &protos.LookupRequest{})
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(resp.Shards).To(HaveLen(3))
+
+	// A deposed leader cannot replace the registration of its successor
+	regResp, err := registry.Register(ctx, &protos.ShardRegistration{ShardId: "fabcar", LeaderId: 2, LeaderAddress: "peer1:7051", Term: 1})
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(regResp.Success).To(BeFalse())
+	gt.Expect(regResp.Error).To(ContainSubstring("term 2"))
+
+	regResp, err = registry.Register(ctx, &protos.ShardRegistration{ShardId: "fabcar", LeaderId: 2, LeaderAddress: "peer1:7051", Term: 3})
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(regResp.Success).To(BeTrue())
+	resp, err = registry.Lookup(ctx, &protos.LookupRequest{Contract: "fabcar"})
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(resp.Shards).To(HaveLen(1))
+	gt.Expect(resp.Shards[0].LeaderId).To(Equal(uint64(2)))
+
+	regResp, err = registry.Register(ctx, &protos.ShardRegistration{})
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(regResp.Success).To(BeFalse())
+}
+
+func TestShardRegistryExpiry(t *testing.T) {
+	gt := NewGomegaWithT(t)
+	ctx := context.Background()
+
+	registry := NewShardRegistry(100 * time.Millisecond)
+	_, err := registry.Register(ctx, &protos.ShardRegistration{ShardId: "fabcar", LeaderId: 1, Term: 5})
+	gt.Expect(err).NotTo(HaveOccurred())
+
+	gt.Eventually(func() int {
+		resp, err := registry.Lookup(ctx, &protos.LookupRequest{Contract: "fabcar"})
+		gt.Expect(err).NotTo(HaveOccurred())
+		return len(resp.Shards)
+	}).Should(BeZero())
+
+	// Once expired, a registration from any term is accepted
+	resp, err := registry.Register(ctx, &protos.ShardRegistration{ShardId: "fabcar", LeaderId: 2, Term: 1})
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(resp.Success).To(BeTrue())
+}
+
+func TestRegistryClient(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	address := freeTransportAddress(t)
+	transport := NewTransport(1, address, PeerConfig{1: address})
+	transport.ServeRegistry(NewShardRegistry(time.Hour))
+	gt.Expect(transport.Start()).To(Succeed())
+	defer transport.Stop()
+
+	client, err := NewRegistryClient(address, nil)
+	gt.Expect(err).NotTo(HaveOccurred())
+	defer client.Close()
+
+	sl, err := NewShardLeader(ShardConfig{
+		ShardID:    "fabcar",
+		ReplicaIDs: []uint64{1},
+		ReplicaID:  1,
+	}, DefaultBatchTimeout, DefaultBatchMaxSize)
+	gt.Expect(err).NotTo(HaveOccurred())
+	defer sl.Stop()
+
+	topology := &ShardTopology{Contracts: map[string]ReplicaSet{
+		"fabcar": {Replicas: map[uint64]string{1: "peer0:7051"}},
+	}}
+	_, ok := sl.registration(topology)
+	gt.Expect(ok).To(BeFalse())
+
+	gt.Eventually(func() bool { return sl.GetStatus().IsLeader }, 30*time.Second, 100*time.Millisecond).Should(BeTrue())
+	reg, ok := sl.registration(topology)
+	gt.Expect(ok).To(BeTrue())
+	gt.Expect(reg.Contract).To(Equal("fabcar"))
+	gt.Expect(reg.LeaderId).To(Equal(uint64(1)))
+	gt.Expect(reg.LeaderAddress).To(Equal(localPeerAddress()))
+	gt.Expect(reg.Term).To(BeNumerically(">", 0))
+	gt.Expect(reg.Members).To(HaveLen(1))
+	gt.Expect(reg.Members[0].Address).To(Equal("peer0:7051"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	gt.Eventually(func() error { return client.Register(ctx, reg) }, 10*time.Second, 100*time.Millisecond).Should(Succeed())
+
+	leaderAddress, err := client.LeaderAddress(ctx, "fabcar")
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(leaderAddress).To(Equal(localPeerAddress()))
+
+	_, err = client.LeaderAddress(ctx, "marbles")
+	gt.Expect(err).To(MatchError("shard marbles is not registered"))
+}
@@ -2,11 +2,13 @@ package sharding
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
-	"os"
+	"strconv"
 	"time"
 )
 
@@ -40,27 +42,158 @@ func (sm *ShardManager) StartHTTPServer(myAddr string) {
 			return
 		}
 
-		commitC := shard.Subscribe(req.TxID)
-		defer shard.Unsubscribe(req.TxID, commitC)
+		ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second) // DefaultPrepareTimeout
+		defer cancel()
 
-		if !shard.HasProof(req.TxID) {
-			select {
-			case shard.ProposeC() <- &req:
-			default:
-				http.Error(w, "propose channel full", http.StatusServiceUnavailable)
-				return
-			}
+		proof, err := shard.Prepare(ctx, &req)
+		switch {
+		case errors.Is(err, ErrQueueFull):
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+		case errors.Is(err, ErrStopped):
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusGatewayTimeout)
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(proof)
+		}
+	})
+
+	mux.HandleFunc("/abort", sm.requireSignature(func(w http.ResponseWriter, r *http.Request) {
+		var req AbortRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		shard, err := sm.GetOrCreateShard(req.ShardID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
 
-		select {
-		case proof := <-commitC:
+		ctx, cancel := context.WithTimeout(r.Context(), DefaultAbortTimeout)
+		defer cancel()
+
+		proof, err := shard.AbortAndWait(ctx, req.TxID)
+		switch {
+		case errors.Is(err, ErrStopped):
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusGatewayTimeout)
+		default:
 			w.Header().Set("Content-Type", "application/json")
 			json.NewEncoder(w).Encode(proof)
-		case <-time.After(30 * time.Second): // DefaultPrepareTimeout
-			http.Error(w, "timeout waiting for local shard proof", http.StatusGatewayTimeout)
 		}
+	}))
+
+	mux.HandleFunc("/decision", sm.requireSignature(func(w http.ResponseWriter, r *http.Request) {
+		var req DecisionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		shard, err := sm.GetOrCreateShard(req.ShardID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), DefaultAbortTimeout)
+		defer cancel()
+
+		commit, err := shard.DecideAndWait(ctx, req.TxID, req.Commit)
+		switch {
+		case errors.Is(err, ErrStopped):
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusGatewayTimeout)
+		default:
+			req.Commit = commit
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(&req)
+		}
+	}))
+
+	// /dependency?shard=<id>&key=<key>[&maxLagEntries=<n>][&maxLag=<duration>]
+	// serves a bounded-staleness read from this peer's replica of the shard,
+	// or from the updates gossiped by its leader when this peer does not
+	// replicate it, and /dependency?shard=<id>&key=<key>&linearizable=true a
+	// ReadIndex read
+	mux.HandleFunc("/dependency", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("linearizable") == "true" {
+			ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second) // DefaultPrepareTimeout
+			defer cancel()
+
+			read, err := sm.QueryDependency(ctx, q.Get("shard"), q.Get("key"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(read)
+			return
+		}
+
+		var bound StalenessBound
+		if v := q.Get("maxLagEntries"); v != "" {
+			n, err := strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid maxLagEntries: %v", err), http.StatusBadRequest)
+				return
+			}
+			bound.MaxLagEntries = n
+		}
+		if v := q.Get("maxLag"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid maxLag: %v", err), http.StatusBadRequest)
+				return
+			}
+			bound.MaxLagTime = d
+		}
+
+		read, err := sm.GetDependency(q.Get("shard"), q.Get("key"), bound)
+		if err != nil {
+			status := http.StatusNotFound
+			if _, stale := err.(*StaleReadError); stale {
+				status = http.StatusServiceUnavailable
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(read)
+	})
+
+	mux.HandleFunc("/latency", sm.handleLatency)
+
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(sm.GetShardMetrics())
+	})
+
+	mux.HandleFunc("/transport", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(sm.GetTransportStatus())
+	})
+
+	mux.HandleFunc("/membership", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(sm.GetMembership())
+	})
+
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(sm.GetStatus())
 	})
 
+	mux.HandleFunc("/propose/batch", sm.handleProposeBatch)
+	mux.HandleFunc("/gossip/dependencies", sm.requireSignature(sm.handleDependencyGossip))
+	mux.HandleFunc("/admin/reload", sm.handleReload)
+
 	go func() {
 		logger.Infof("Starting Shard Remote REST API at %s", bindAddr)
 		if err := http.ListenAndServe(bindAddr, mux); err != nil {
@@ -69,67 +202,159 @@ func (sm *ShardManager) StartHTTPServer(myAddr string) {
 	}()
 }
 
-// RequestRemoteProof requests a dependency proof from an actual replica over HTTP
-func (sm *ShardManager) RequestRemoteProof(shardID string, req *PrepareRequest) (*PrepareProof, error) {
+// RequestRemoteProof requests a dependency proof from an actual replica over
+// HTTP. The leader registered with the shard registry is asked when one is
+// configured, and the lowest replica of the shard topology otherwise. With a
+// hedge delay, another replica is asked as well if the first one has not
+// answered in time. The request is abandoned, and the replica stops waiting
+// for the proof, once ctx is done.
+func (sm *ShardManager) RequestRemoteProof(ctx context.Context, shardID string, req *PrepareRequest) (*PrepareProof, error) {
+	targetAddr, err := sm.remoteTarget(ctx, shardID)
+	if err != nil {
+		return nil, err
+	}
+
+	if hedgeAddr := sm.hedgeTarget(shardID, targetAddr); hedgeAddr != "" {
+		return sm.requestHedgedProof(ctx, targetAddr, hedgeAddr, req)
+	}
+	return sm.postProposal(ctx, targetAddr, req)
+}
+
+// RequestRemoteAbort asks a replica of the shard over HTTP to abort the
+// transaction, as RequestRemoteProof asks for its proof
+func (sm *ShardManager) RequestRemoteAbort(ctx context.Context, shardID, txID string) error {
+	targetAddr, err := sm.remoteTarget(ctx, shardID)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(&AbortRequest{TxID: txID, ShardID: shardID})
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %v", err)
+	}
+	resp, err := sm.post(ctx, targetAddr, "/abort", body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// RequestRemoteDecision asks a replica of the shard over HTTP to record the
+// decision on the transaction, and returns the decision in effect
+func (sm *ShardManager) RequestRemoteDecision(ctx context.Context, shardID, txID string, commit bool) (bool, error) {
+	targetAddr, err := sm.remoteTarget(ctx, shardID)
+	if err != nil {
+		return false, err
+	}
+	body, err := json.Marshal(&DecisionRequest{TxID: txID, ShardID: shardID, Commit: commit})
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal request: %v", err)
+	}
+	resp, err := sm.post(ctx, targetAddr, "/decision", body)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	var decided DecisionRequest
+	if err := json.NewDecoder(resp.Body).Decode(&decided); err != nil {
+		return false, fmt.Errorf("failed to decode decision: %v", err)
+	}
+	return decided.Commit, nil
+}
+
+// remoteTarget returns the address of the replica of the shard that remote
+// requests are sent to
+func (sm *ShardManager) remoteTarget(ctx context.Context, shardID string) (string, error) {
 	var targetAddr string
-	if externalConfig, err := loadShardingConfig("sharding.json"); err == nil {
-		if replicas, ok := externalConfig[shardID]; ok && len(replicas) > 0 {
-			targetAddr = replicas[0] // Pick the first replica in the list to handle the dependency coord
+	if sm.registry != nil {
+		registryCtx, cancel := context.WithTimeout(ctx, registryCallTimeout)
+		addr, err := sm.registry.LeaderAddress(registryCtx, shardID)
+		cancel()
+		if err != nil {
+			logger.Debugf("Falling back to the shard topology for shard %s: %v", shardID, err)
+		}
+		targetAddr = addr
+	}
+	if targetAddr == "" {
+		if set, ok := sm.Topology().ReplicaSet(shardID); ok && len(set.Replicas) > 0 {
+			_, addrs := set.sortedReplicas()
+			targetAddr = addrs[0] // Pick the lowest replica to handle the dependency coord
 		}
 	}
 
 	if targetAddr == "" {
-		return nil, fmt.Errorf("no replicas found for shard %s in sharding config", shardID)
+		return "", fmt.Errorf("no replicas found for shard %s in shard topology", shardID)
+	}
+	return targetAddr, nil
+}
+
+// postProposal sends the prepare request to the shard REST API of the replica
+// at targetAddr
+func (sm *ShardManager) postProposal(ctx context.Context, targetAddr string, req *PrepareRequest) (*PrepareProof, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %v", err)
+	}
+
+	resp, err := sm.post(ctx, targetAddr, "/propose", body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var proof PrepareProof
+	if err := json.NewDecoder(resp.Body).Decode(&proof); err != nil {
+		return nil, fmt.Errorf("failed to decode proof: %v", err)
 	}
 
+	return &proof, nil
+}
+
+// post sends the JSON body to the path of the shard REST API of the replica
+// at targetAddr, and returns the response if it succeeded
+func (sm *ShardManager) post(ctx context.Context, targetAddr, path string, body []byte) (*http.Response, error) {
 	host, portStr, err := net.SplitHostPort(targetAddr)
 	if err != nil {
 		return nil, fmt.Errorf("failed to split host/port for target %s: %v", targetAddr, err)
 	}
 	var port int
 	fmt.Sscanf(portStr, "%d", &port)
-	url := fmt.Sprintf("http://%s:%d/propose", host, port+30000)
+	url := fmt.Sprintf("http://%s:%d%s", host, port+30000, path)
 
-	body, err := json.Marshal(req)
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(body))
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %v", err)
+		return nil, fmt.Errorf("failed to create request: %v", err)
 	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	sm.signRequest(httpReq, path, body)
 
 	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Post(url, "application/json", bytes.NewBuffer(body))
+	resp, err := client.Do(httpReq)
 	if err != nil {
 		return nil, fmt.Errorf("remote HTTP error: %v", err)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		var errResp map[string]interface{}
-		json.NewDecoder(resp.Body).Decode(&errResp)
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusTooManyRequests:
+		// The replica could not queue the request for its shard
+		resp.Body.Close()
+		return nil, fmt.Errorf("remote error: %s: %w", resp.Status, ErrQueueFull)
+	default:
+		resp.Body.Close()
 		return nil, fmt.Errorf("remote error: %s (status %d)", resp.Status, resp.StatusCode)
 	}
-
-	var proof PrepareProof
-	if err := json.NewDecoder(resp.Body).Decode(&proof); err != nil {
-		return nil, fmt.Errorf("failed to decode proof: %v", err)
-	}
-
-	return &proof, nil
+	return resp, nil
 }
 
-// IsReplica checks if the current peer's address matches any of the ReplicaNodes for the given Contract/Shard
+// IsReplica checks if the current peer is one of the replicas of the given Contract/Shard
 func (sm *ShardManager) IsReplica(shardID string) bool {
-	myAddr := os.Getenv("CORE_PEER_ADDRESS")
-	if myAddr == "" {
-		myAddr = "localhost:7051"
-	}
-	if externalConfig, err := loadShardingConfig("sharding.json"); err == nil {
-		if replicas, ok := externalConfig[shardID]; ok {
-			for _, nodeAddr := range replicas {
-				if nodeAddr == myAddr {
-					return true
-				}
-			}
-		}
+	topology := sm.Topology()
+	set, ok := topology.ReplicaSet(shardID)
+	if !ok {
+		return false
 	}
-	return false
+	_, ok = topology.localReplica(set, advertisedPeerAddress())
+	return ok
 }
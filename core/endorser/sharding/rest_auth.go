@@ -0,0 +1,90 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	restAuthTimeHeader  = "X-Shard-Auth-Time"
+	restAuthTokenHeader = "X-Shard-Auth-Token"
+
+	// maxSignedBodySize bounds the body read to check the token of a request
+	maxSignedBodySize = 16 << 20
+)
+
+// signRequestToken returns the token proving that a holder of the secret
+// sent the body to the path of the shard REST API at the given Unix time
+func signRequestToken(secret []byte, path string, unix int64, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "%s:%d:", path, unix)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signRequest signs a request to the shard REST API with the secret shared by
+// the peers, if one is configured
+func (sm *ShardManager) signRequest(req *http.Request, path string, body []byte) {
+	if len(sm.authSecret) == 0 {
+		return
+	}
+	now := time.Now().Unix()
+	req.Header.Set(restAuthTimeHeader, strconv.FormatInt(now, 10))
+	req.Header.Set(restAuthTokenHeader, signRequestToken(sm.authSecret, path, now, body))
+}
+
+// requireSignature serves only the requests signed with the secret shared by
+// the peers, when one is configured. These are the requests that change the
+// state of the shards on behalf of another peer.
+func (sm *ShardManager) requireSignature(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(sm.authSecret) == 0 {
+			handler(w, r)
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSignedBodySize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := sm.verifyRequest(r, body); err != nil {
+			logger.Warningf("Rejecting %s from %s: %v", r.URL.Path, r.RemoteAddr, err)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		handler(w, r)
+	}
+}
+
+// verifyRequest checks the token of a request to the shard REST API
+func (sm *ShardManager) verifyRequest(r *http.Request, body []byte) error {
+	timeValue, token := r.Header.Get(restAuthTimeHeader), r.Header.Get(restAuthTokenHeader)
+	if timeValue == "" || token == "" {
+		return fmt.Errorf("missing auth token")
+	}
+	unix, err := strconv.ParseInt(timeValue, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid auth time %q", timeValue)
+	}
+	if skew := time.Since(time.Unix(unix, 0)); skew > authTokenMaxSkew || skew < -authTokenMaxSkew {
+		return fmt.Errorf("auth token is %s off", skew.Round(time.Second))
+	}
+	if !hmac.Equal([]byte(token), []byte(signRequestToken(sm.authSecret, r.URL.Path, unix, body))) {
+		return fmt.Errorf("invalid auth token")
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestRequireSignature(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	var served []string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		gt.Expect(err).NotTo(HaveOccurred())
+		served = append(served, string(body))
+	}
+	serve := func(sm *ShardManager, req *http.Request) int {
+		rec := httptest.NewRecorder()
+		sm.requireSignature(handler)(rec, req)
+		return rec.Code
+	}
+	request := func(path, body string) *http.Request {
+		return httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	}
+
+	// without a secret every request is served
+	open := &ShardManager{}
+	gt.Expect(serve(open, request("/abort", "a"))).To(Equal(http.StatusOK))
+
+	sm := &ShardManager{authSecret: []byte("secret")}
+	gt.Expect(serve(sm, request("/abort", "b"))).To(Equal(http.StatusUnauthorized))
+
+	signed := request("/abort", "c")
+	sm.signRequest(signed, "/abort", []byte("c"))
+	gt.Expect(serve(sm, signed)).To(Equal(http.StatusOK))
+
+	// the token covers the body and the path
+	tampered := request("/abort", "d")
+	sm.signRequest(tampered, "/abort", []byte("c"))
+	gt.Expect(serve(sm, tampered)).To(Equal(http.StatusUnauthorized))
+	moved := request("/decision", "c")
+	sm.signRequest(moved, "/abort", []byte("c"))
+	gt.Expect(serve(sm, moved)).To(Equal(http.StatusUnauthorized))
+
+	other := request("/abort", "e")
+	(&ShardManager{authSecret: []byte("other")}).signRequest(other, "/abort", []byte("e"))
+	gt.Expect(serve(sm, other)).To(Equal(http.StatusUnauthorized))
+
+	old := request("/abort", "f")
+	then := time.Now().Add(-2 * authTokenMaxSkew).Unix()
+	old.Header.Set(restAuthTimeHeader, strconv.FormatInt(then, 10))
+	old.Header.Set(restAuthTokenHeader, signRequestToken(sm.authSecret, "/abort", then, []byte("f")))
+	gt.Expect(serve(sm, old)).To(Equal(http.StatusUnauthorized))
+
+	gt.Expect(served).To(Equal([]string{"a", "c"}))
+}
@@ -8,10 +8,12 @@ package sharding
 
 import (
 	"context"
+	"crypto/ed25519"
+	"errors"
 	"fmt"
 	"sort"
-	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/hyperledger/fabric/common/flogging"
@@ -25,6 +27,21 @@ const (
 	DefaultBatchMaxSize   = 500
 	DefaultBatchTimeout   = 10 * time.Millisecond
 	DefaultExpiryDuration = 5 * time.Minute
+	DefaultQueueLength    = 10000
+	DefaultGCInterval     = time.Minute
+
+	DefaultMaxSizePerMsg     = 1024 * 1024
+	DefaultMaxInflightMsgs   = 256
+	DefaultMessageBufferSize = 10000
+	DefaultApplyBufferSize   = 1000
+)
+
+var (
+	// ErrQueueFull is returned when a request cannot be queued for proposal
+	// before its context ends
+	ErrQueueFull = errors.New("propose queue is full")
+	// ErrStopped is returned when a request is made to a stopped shard
+	ErrStopped = errors.New("shard is stopped")
 )
 
 // TransactionDependencyInfo represents information about a transaction dependency
@@ -33,6 +50,17 @@ type TransactionDependencyInfo struct {
 	DependentTxID string
 	ExpiryTime    time.Time
 	HasDependency bool
+	Timestamp     int64
+	// CommitIndex is the index of the entry that made the reservation
+	CommitIndex uint64 `json:",omitempty"`
+	// Chain lists the earlier writers of the key, oldest first, whose
+	// reservations were pending when DependentTxID replaced them
+	Chain []ChainLink `json:",omitempty"`
+	// Read is set when DependentTxID only read the key, which it reserved
+	// along with its writes
+	Read bool `json:",omitempty"`
+	// Origin is the ID of the endorser that prepared DependentTxID
+	Origin string `json:",omitempty"`
 }
 
 // ShardConfig represents configuration for a contract shard
@@ -41,6 +69,65 @@ type ShardConfig struct {
 	ReplicaNodes []string
 	ReplicaIDs   []uint64
 	ReplicaID    uint64
+	// ConflictPolicy selects how conflicting prepare requests are resolved.
+	// Defaults to ConflictPolicyQueueBehind.
+	ConflictPolicy ConflictPolicy
+	// ConflictWindow limits dependencies to recent writers of the same keys.
+	// The zero value makes every unexpired reservation conflict.
+	ConflictWindow ConflictWindow
+	// DataDir is the directory under which the Raft log is persisted. The
+	// log is kept in memory only when empty.
+	DataDir string
+	// SnapshotInterval is the number of applied entries between snapshots of
+	// the dependency map. Defaults to DefaultSnapshotInterval.
+	SnapshotInterval uint64
+	// SnapshotCatchUpEntries is the number of entries retained in the log
+	// after a snapshot. Defaults to DefaultSnapshotCatchUpEntries.
+	SnapshotCatchUpEntries uint64
+	// QueueLength is the number of prepare requests that may wait to be
+	// batched before Propose blocks. Defaults to DefaultQueueLength.
+	QueueLength int
+	// DependencyTTL is how long the reservations of a prepared transaction
+	// are kept. Defaults to DefaultExpiryDuration.
+	DependencyTTL time.Duration
+	// GCInterval is how often the leader prunes expired dependencies.
+	// Defaults to DefaultGCInterval.
+	GCInterval time.Duration
+	// SigningKey signs the proofs issued by this replica. Proofs carry an
+	// unkeyed digest when it is nil.
+	SigningKey ed25519.PrivateKey `json:"-"`
+	// QuorumCert makes ProposeAndWait attach the co-signatures of a quorum
+	// of voters to each proof. It requires a SigningKey.
+	QuorumCert bool
+	// DedupWindow is the number of log entries for which retried proposals
+	// of an applied transaction get its original proof instead of being
+	// applied again. Defaults to DefaultDedupWindow.
+	DedupWindow uint64
+	// MaxSizePerMsg bounds the size of the entries sent in one append
+	// message. Defaults to DefaultMaxSizePerMsg.
+	MaxSizePerMsg uint64
+	// MaxInflightMsgs is the number of append messages a leader sends to a
+	// follower before waiting for an acknowledgement. Defaults to
+	// DefaultMaxInflightMsgs.
+	MaxInflightMsgs int
+	// MaxUncommittedEntriesSize bounds the size of the entries a leader
+	// accepts but has not committed yet. Zero leaves it unbounded.
+	MaxUncommittedEntriesSize uint64
+	// MessageBufferSize is the number of Ready batches of outgoing Raft
+	// messages buffered for the transport before further batches are
+	// dropped. Defaults to DefaultMessageBufferSize.
+	MessageBufferSize int
+	// ApplyBufferSize is the number of Ready batches of committed entries
+	// queued for the apply loop before Raft processing waits for it.
+	// Defaults to DefaultApplyBufferSize.
+	ApplyBufferSize int
+	// StateStore selects where the dependency map is kept: StateStoreMemory
+	// (the default) or StateStoreLevelDB, which requires a DataDir.
+	StateStore string
+	// MaxDependencies caps the number of reservations held by the shard;
+	// the least recently reserved keys are evicted beyond it. It must be the
+	// same on every replica. Zero leaves it unbounded.
+	MaxDependencies int
 }
 
 // PrepareRequest represents a dependency preparation request
@@ -49,19 +136,69 @@ type PrepareRequest struct {
 	ShardID   string
 	ReadSet   map[string][]byte
 	WriteSet  map[string][]byte
-	Timestamp time.Time
+	// Timestamp is the reading of the endorser's hybrid logical clock when
+	// it prepared the transaction
+	Timestamp HLCTimestamp
+	// RangeReads are the ranges scanned by a read-only transaction, which
+	// depends on the reservations within them. ReservedRanges are those
+	// scanned by a writing transaction, which are also reserved, so that a
+	// later write within them depends on it.
+	RangeReads     []KeyRange `json:",omitempty"`
+	ReservedRanges []KeyRange `json:",omitempty"`
+	// TTL is how long the reservations of the request are kept, in place of
+	// the store's own expiry when set
+	TTL time.Duration `json:",omitempty"`
+	// Origin is the ID of the endorser preparing the request, which finds
+	// its prepares in flight again after a restart
+	Origin string `json:",omitempty"`
+}
+
+// ttlOr returns the TTL of the request, or def when it has none
+func (r *PrepareRequest) ttlOr(def time.Duration) time.Duration {
+	if r.TTL > 0 {
+		return r.TTL
+	}
+	return def
 }
 
 // PrepareProof represents a committed dependency entry
 type PrepareProof struct {
-	TxID          string
-	ShardID       string
-	CommitIndex   uint64
-	LeaderID      uint64
-	Signature     []byte
-	Term          uint64
+	TxID        string
+	ShardID     string
+	CommitIndex uint64
+	LeaderID    uint64
+	Signature   []byte
+	Term        uint64
+	// DependentTxID lists, sorted and comma-separated, the holders of the
+	// conflicting reservations within the shard's ConflictWindow
 	DependentTxID string
 	HasDependency bool
+	// ConflictTypes gives the types of the conflicts with each transaction
+	// of DependentTxID
+	ConflictTypes map[string][]ConflictType `json:",omitempty"`
+	// DependencyChain lists the transaction's ancestors within the shard:
+	// the holders in DependentTxID and the pending writers before them, in
+	// the order they reserved the keys
+	DependencyChain []string `json:",omitempty"`
+	// ConflictPolicy is the policy the shard applied to this transaction
+	ConflictPolicy ConflictPolicy
+	// Rejected is set when the policy refused the transaction's reservations
+	Rejected bool
+	// AbortedTxIDs lists the holders that were wounded to admit this transaction
+	AbortedTxIDs []string
+	// Aborted is set on the proof acknowledging that the reservations of the
+	// transaction were released
+	Aborted bool
+	// SignerID is the replica whose key produced Signature, or 0 when the
+	// signature is the unkeyed digest
+	SignerID uint64 `json:",omitempty"`
+	// QuorumCert holds the signatures of a quorum of voters over the same
+	// (ShardID, TxID, CommitIndex, Term) tuple, ordered by replica ID
+	QuorumCert []ProofSignature `json:",omitempty"`
+	// HLC is the hybrid logical clock reading the shard ordered the
+	// transaction at. Transactions prepared after the endorser saw this
+	// proof are ordered after it on every shard.
+	HLC HLCTimestamp
 }
 
 // ShardLeader manages a Raft group for a specific contract
@@ -69,10 +206,16 @@ type ShardLeader struct {
 	shardID         string
 	node            raft.Node
 	storage         *raft.MemoryStorage
+	wal             *shardStorage
+	confState       raftpb.ConfState
 	peers           []raft.Peer
 	commitIndex     uint64
-	variableMap     map[string]TransactionDependencyInfo
-	variableMapLock sync.RWMutex
+	variableMap     DependencyTable
+	variableMapLock stripedLock
+	// recorder records the updates of variableMap for updateObserver, which
+	// is guarded by mu; it is nil when the table is not wrapped
+	recorder        *recordingDependencyTable
+	updateObserver  func(DependencyUpdates)
 	batchQueue      []*PrepareRequest
 	batchLock       sync.Mutex
 	batchTimeout    time.Duration
@@ -85,22 +228,156 @@ type ShardLeader struct {
 	proofCacheLock  sync.RWMutex
 	errorC          chan error
 	stopC           chan struct{}
+	raftDoneC       chan struct{}
 	messagesC       chan []raftpb.Message
+	applyC          chan *applyBatch
+	applyDoneC      chan struct{}
 	requestsHandled uint64
-	mu              sync.RWMutex
+	latency         *latencyTracker
+	conflictPolicy  ConflictPolicy
+	// appliedIndex and lastLeaderContact (Unix nanoseconds) are accessed
+	// atomically and bound the staleness of follower reads
+	appliedIndex      uint64
+	lastLeaderContact int64
+	readProofs        readProofCache
+	config            ShardConfig
+	backupC           chan chan *ShardBackup
+	mu                sync.RWMutex
+
+	// snapshotIndex is the index of the latest snapshot; like confState it
+	// is only accessed from runApply
+	snapshotIndex          uint64
+	snapshotInterval       uint64
+	snapshotCatchUpEntries uint64
+
+	// members mirrors confState for readers outside runApply, and peerAddrs
+	// holds the addresses of replicas added at runtime; both are guarded by mu
+	members           raftpb.ConfState
+	peerAddrs         PeerConfig
+	peerUpdater       peerUpdater
+	forwarder         proposalForwarder
+	coSigner          proofCoSigner
+	leaderObserver    func(shardID string, leaderID uint64)
+	expiryObserver    func(ExpiredEndorsement)
+	leaderID          uint64
+	abortWaiters      map[string][]chan *PrepareProof
+	readIndexSeq      uint64
+	readIndexWaiters  map[string]chan uint64
+	appliedC          chan struct{}
+	confChangeSeq     uint64
+	confChangeWaiters map[uint64]chan struct{}
+	expireSeq         uint64
+	expireWaiters     map[uint64]chan bool
+
+	// decisions holds the two-phase commit decisions on the transactions
+	// prepared on this shard, and decisionWaiters the local callers waiting
+	// for theirs to be applied; both are guarded by mu
+	decisions       map[string]*DecisionEntry
+	decisionWaiters map[string][]chan bool
+
+	// dependencyTTL and gcInterval drive the replicated dependency GC, and
+	// expiredDependencies counts the entries it removed (accessed atomically)
+	dependencyTTL       time.Duration
+	gcInterval          time.Duration
+	expiredDependencies uint64
+	// rangeReservations holds the ranges reserved by each transaction
+	rangesLock        sync.RWMutex
+	rangeReservations map[string][]RangeReservation
+
+	// dependencies is the number of reservations found by the last GC
+	// check (accessed atomically)
+	dependencies int64
+
+	// evictedDependencies counts the reservations evicted to respect
+	// MaxDependencies (accessed atomically)
+	evictedDependencies uint64
+
+	// appliedTxs orders the proofs kept for deduplication by commit index
+	// (guarded by variableMapLock), and duplicateProposals counts the
+	// proposals answered from them (accessed atomically)
+	dedupWindow        uint64
+	appliedTxs         []appliedTx
+	duplicateProposals uint64
+
+	// batchSizes records the size of every batch proposed on this replica,
+	// and aborts counts the applied aborts (accessed atomically)
+	batchSizes *sizeHistogram
+	aborts     uint64
+
+	// lastUsed (Unix nanoseconds, accessed atomically) lets the ShardManager
+	// evict shards that stay idle
+	lastUsed int64
 }
 
 // NewShardLeader creates a new Raft-based shard leader
 func NewShardLeader(config ShardConfig, batchTimeout time.Duration, maxBatchSize int) (*ShardLeader, error) {
+	return newShardLeader(config, batchTimeout, maxBatchSize, nil)
+}
+
+// newShardLeader creates a shard leader whose dependency map is seeded with
+// the given entries before it starts applying Raft entries
+func newShardLeader(config ShardConfig, batchTimeout time.Duration, maxBatchSize int, dependencies map[string]TransactionDependencyInfo) (*ShardLeader, error) {
+	conflictPolicy, err := ParseConflictPolicy(string(config.ConflictPolicy))
+	if err != nil {
+		return nil, fmt.Errorf("invalid config for shard %s: %v", config.ShardID, err)
+	}
+
+	queueLength := config.QueueLength
+	if queueLength <= 0 {
+		queueLength = DefaultQueueLength
+	}
+
+	table, err := openDependencyTable(config)
+	if err != nil {
+		return nil, err
+	}
+	// The recorder only records once an update observer is set, and goes
+	// under the bounded table so that it sees the evictions
+	recorder := newRecordingDependencyTable(table)
+	table = recorder
+	if config.MaxDependencies > 0 {
+		table = newBoundedDependencyTable(table, config.MaxDependencies)
+	}
+
 	storage := raft.NewMemoryStorage()
 
+	var persisted *shardStorage
+	var snapshot *raftpb.Snapshot
+	restart := false
+	if config.DataDir != "" {
+		if persisted, snapshot, restart, err = openShardStorage(config.DataDir, config.ShardID, storage); err != nil {
+			table.Close()
+			return nil, err
+		}
+		if restart && len(dependencies) > 0 {
+			persisted.close()
+			table.Close()
+			return nil, fmt.Errorf("shard %s already has persisted state in %s", config.ShardID, config.DataDir)
+		}
+	}
+
 	c := &raft.Config{
-		ID:              config.ReplicaID,
-		ElectionTick:    100, // 100 * 100ms = 10 seconds
-		HeartbeatTick:   5,   // 5 * 100ms = 0.5 seconds
-		Storage:         storage,
-		MaxSizePerMsg:   1024 * 1024,
-		MaxInflightMsgs: 256,
+		ID:                        config.ReplicaID,
+		ElectionTick:              100, // 100 * 100ms = 10 seconds
+		HeartbeatTick:             5,   // 5 * 100ms = 0.5 seconds
+		Storage:                   storage,
+		MaxSizePerMsg:             config.MaxSizePerMsg,
+		MaxInflightMsgs:           config.MaxInflightMsgs,
+		MaxUncommittedEntriesSize: config.MaxUncommittedEntriesSize,
+	}
+	if c.MaxSizePerMsg == 0 {
+		c.MaxSizePerMsg = DefaultMaxSizePerMsg
+	}
+	if c.MaxInflightMsgs <= 0 {
+		c.MaxInflightMsgs = DefaultMaxInflightMsgs
+	}
+	messageBufferSize := config.MessageBufferSize
+	if messageBufferSize <= 0 {
+		messageBufferSize = DefaultMessageBufferSize
+	}
+	applyBufferSize := config.ApplyBufferSize
+	if applyBufferSize <= 0 {
+		applyBufferSize = DefaultApplyBufferSize
 	}
 
 	var peers []raft.Peer
@@ -108,38 +385,120 @@ func NewShardLeader(config ShardConfig, batchTimeout time.Duration, maxBatchSize
 		peers = append(peers, raft.Peer{ID: id})
 	}
 
-	node := raft.StartNode(c, peers)
+	// A restarted node restores its latest snapshot and replays the committed
+	// entries after it, which rebuilds the dependency map. A persistent state
+	// store skips the entries it already reflects.
+	var node raft.Node
+	if restart {
+		node = raft.RestartNode(c)
+	} else {
+		node = raft.StartNode(c, peers)
+	}
 
 	sl := &ShardLeader{
-		shardID:       config.ShardID,
-		node:          node,
-		storage:       storage,
-		peers:         peers,
-		variableMap:   make(map[string]TransactionDependencyInfo),
-		batchQueue:    make([]*PrepareRequest, 0, maxBatchSize),
-		batchTimeout:  batchTimeout,
-		maxBatchSize:  maxBatchSize,
-		lastBatchTime: time.Now(),
-		proposeC:      make(chan *PrepareRequest, 10000),
-		subscribers:   make(map[string][]chan *PrepareProof),
-		pendingTxIDs:  make(map[string]bool),
-		proofCache:    make(map[string]*PrepareProof),
-		errorC:        make(chan error, 10),
-		stopC:         make(chan struct{}),
-		messagesC:     make(chan []raftpb.Message, 10000),
+		shardID:        config.ShardID,
+		node:           node,
+		storage:        storage,
+		wal:            persisted,
+		peers:          peers,
+		variableMap:    table,
+		recorder:       recorder,
+		batchQueue:     make([]*PrepareRequest, 0, maxBatchSize),
+		batchTimeout:   batchTimeout,
+		maxBatchSize:   maxBatchSize,
+		lastBatchTime:  time.Now(),
+		batchSizes:     newSizeHistogram(DefaultBatchSizeBuckets),
+		proposeC:       make(chan *PrepareRequest, queueLength),
+		subscribers:    make(map[string][]chan *PrepareProof),
+		pendingTxIDs:   make(map[string]bool),
+		proofCache:     make(map[string]*PrepareProof),
+		errorC:         make(chan error, 10),
+		stopC:          make(chan struct{}),
+		raftDoneC:      make(chan struct{}),
+		messagesC:      make(chan []raftpb.Message, messageBufferSize),
+		applyC:         make(chan *applyBatch, applyBufferSize),
+		applyDoneC:     make(chan struct{}),
+		latency:        newLatencyTracker(),
+		conflictPolicy: conflictPolicy,
+		config:         config,
+		backupC:        make(chan chan *ShardBackup),
+
+		snapshotInterval:       config.SnapshotInterval,
+		snapshotCatchUpEntries: config.SnapshotCatchUpEntries,
+
+		peerAddrs:         make(PeerConfig),
+		confChangeWaiters: make(map[uint64]chan struct{}),
+		expireWaiters:     make(map[uint64]chan bool),
+		abortWaiters:      make(map[string][]chan *PrepareProof),
+		readIndexWaiters:  make(map[string]chan uint64),
+		appliedC:          make(chan struct{}),
+
+		dependencyTTL: config.DependencyTTL,
+		gcInterval:    config.GCInterval,
+		dedupWindow:   config.DedupWindow,
+	}
+	if sl.snapshotInterval == 0 {
+		sl.snapshotInterval = DefaultSnapshotInterval
+	}
+	if sl.snapshotCatchUpEntries == 0 {
+		sl.snapshotCatchUpEntries = DefaultSnapshotCatchUpEntries
+	}
+	if sl.dependencyTTL <= 0 {
+		sl.dependencyTTL = DefaultExpiryDuration
+	}
+	if sl.gcInterval <= 0 {
+		sl.gcInterval = DefaultGCInterval
+	}
+	if sl.dedupWindow == 0 {
+		sl.dedupWindow = DefaultDedupWindow
 	}
+	if !restart {
+		// A fresh log must not be paired with state left by an earlier one
+		if err := table.Reset(dependencies, nil, 0); err != nil {
+			node.Stop()
+			persisted.close()
+			table.Close()
+			return nil, fmt.Errorf("failed to seed dependencies of shard %s: %v", config.ShardID, err)
+		}
+	}
+	if snapshot != nil {
+		if err := sl.restoreSnapshot(*snapshot); err != nil {
+			node.Stop()
+			persisted.close()
+			table.Close()
+			return nil, fmt.Errorf("failed to restore snapshot of shard %s: %v", config.ShardID, err)
+		}
+	}
+	// A persistent state store may hold proofs that no snapshot covers
+	sl.variableMapLock.Lock()
+	sl.loadAppliedTxs()
+	sl.variableMapLock.Unlock()
 
+	sl.touch()
 	go sl.runRaft()
+	go sl.runApply()
 	go sl.runBatcher()
 	go sl.runProofCleanup()
+	go sl.runDependencyGC()
 
 	return sl, nil
 }
 
-// runRaft handles Raft consensus events
+// applyBatch carries the committed part of a Ready to runApply
+type applyBatch struct {
+	snapshot    raftpb.Snapshot
+	entries     []raftpb.Entry
+	committedAt time.Time
+}
+
+// runRaft handles Raft consensus events. Committed entries are handed to
+// runApply, so that a slow state machine does not delay ticks, heartbeats
+// and log replication.
 func (sl *ShardLeader) runRaft() {
+	defer close(sl.raftDoneC)
 	ticker := time.NewTicker(100 * time.Millisecond)
 	defer ticker.Stop()
+	var leaderID uint64 // last leader passed to setLeader
 
 	for {
 		select {
@@ -147,10 +506,33 @@ func (sl *ShardLeader) runRaft() {
 			sl.node.Tick()
 
 		case rd := <-sl.node.Ready():
+			if rd.SoftState != nil && rd.SoftState.Lead != leaderID {
+				leaderID = rd.SoftState.Lead
+				sl.setLeader(leaderID)
+			}
 			if !raft.IsEmptySnap(rd.Snapshot) {
+				// A snapshot sent by the leader to catch this replica up
+				if sl.wal != nil {
+					if err := sl.wal.saveSnap(rd.Snapshot); err != nil {
+						logger.Panicf("Shard %s: Failed to persist snapshot: %v", sl.shardID, err)
+					}
+				}
 				sl.storage.ApplySnapshot(rd.Snapshot)
 			}
-			sl.storage.Append(rd.Entries)
+			if sl.wal != nil {
+				if err := sl.wal.store(rd.HardState, rd.Entries); err != nil {
+					logger.Panicf("Shard %s: Failed to persist Raft entries: %v", sl.shardID, err)
+				}
+			} else {
+				sl.storage.Append(rd.Entries)
+			}
+
+			appendedAt := time.Now()
+			for _, entry := range rd.Entries {
+				if entry.Type == raftpb.EntryNormal && len(entry.Data) > 0 {
+					sl.latency.appended(entry.Index, appendedAt)
+				}
+			}
 
 			if len(rd.Messages) > 0 {
 				select {
@@ -160,9 +542,17 @@ func (sl *ShardLeader) runRaft() {
 				}
 			}
 
-			for _, entry := range rd.CommittedEntries {
-				if entry.Type == raftpb.EntryNormal && len(entry.Data) > 0 {
-					sl.applyEntry(entry)
+			if len(rd.ReadStates) > 0 {
+				sl.deliverReadStates(rd.ReadStates)
+			}
+
+			if !raft.IsEmptySnap(rd.Snapshot) || len(rd.CommittedEntries) > 0 {
+				batch := &applyBatch{snapshot: rd.Snapshot, entries: rd.CommittedEntries, committedAt: time.Now()}
+				select {
+				case sl.applyC <- batch:
+				case <-sl.stopC:
+					sl.stopRaft()
+					return
 				}
 			}
 
@@ -173,6 +563,7 @@ func (sl *ShardLeader) runRaft() {
 			if !sl.pendingTxIDs[req.TxID] {
 				sl.batchQueue = append(sl.batchQueue, req)
 				sl.pendingTxIDs[req.TxID] = true
+				sl.latency.enqueued(req.TxID, time.Now())
 			}
 			shouldFlush := len(sl.batchQueue) >= sl.maxBatchSize
 			sl.batchLock.Unlock()
@@ -182,12 +573,75 @@ func (sl *ShardLeader) runRaft() {
 			}
 
 		case <-sl.stopC:
-			sl.node.Stop()
+			sl.stopRaft()
 			return
 		}
 	}
 }
 
+// stopRaft stops the node and, once runApply returned, closes the WAL
+func (sl *ShardLeader) stopRaft() {
+	sl.node.Stop()
+	<-sl.applyDoneC
+	if sl.wal != nil {
+		if err := sl.wal.close(); err != nil {
+			logger.Errorf("Shard %s: Failed to close WAL: %v", sl.shardID, err)
+		}
+	}
+}
+
+// runApply applies the snapshots and entries committed by Raft, in order
+func (sl *ShardLeader) runApply() {
+	defer close(sl.applyDoneC)
+
+	for {
+		select {
+		case batch := <-sl.applyC:
+			sl.apply(batch)
+
+		case resultC := <-sl.backupC:
+			// Served between applied batches, so the captured dependency map
+			// matches the applied index exactly
+			resultC <- sl.captureBackup()
+
+		case <-sl.stopC:
+			sl.variableMapLock.Lock()
+			if err := sl.variableMap.Close(); err != nil {
+				logger.Errorf("Shard %s: Failed to close state store: %v", sl.shardID, err)
+			}
+			sl.variableMapLock.Unlock()
+			return
+		}
+	}
+}
+
+// apply applies the committed part of one Ready. It must only be called from
+// runApply.
+func (sl *ShardLeader) apply(batch *applyBatch) {
+	if !raft.IsEmptySnap(batch.snapshot) {
+		if err := sl.restoreSnapshot(batch.snapshot); err != nil {
+			logger.Panicf("Shard %s: Failed to restore snapshot at index %d: %v", sl.shardID, batch.snapshot.Metadata.Index, err)
+		}
+	}
+
+	for _, entry := range batch.entries {
+		switch {
+		case entry.Type == raftpb.EntryNormal && len(entry.Data) > 0 && entry.Index > sl.variableMap.Index():
+			sl.applyEntry(entry, batch.committedAt)
+		case entry.Type == raftpb.EntryConfChange:
+			// Membership is rebuilt from the log when a node restarts
+			sl.applyConfChange(entry)
+		}
+		atomic.StoreUint64(&sl.appliedIndex, entry.Index)
+	}
+	if n := len(batch.entries); n > 0 {
+		sl.commitDependencies(batch.entries[n-1].Index)
+		sl.publishDependencyUpdates(batch.entries[n-1].Index)
+	}
+	sl.maybeSnapshot()
+	sl.notifyApplied()
+}
+
 // runBatcher batches prepare requests
 func (sl *ShardLeader) runBatcher() {
 	ticker := time.NewTicker(sl.batchTimeout)
@@ -218,6 +672,10 @@ func (sl *ShardLeader) runProofCleanup() {
 				sl.proofCache = make(map[string]*PrepareProof)
 			}
 			sl.proofCacheLock.Unlock()
+
+			if removed := sl.latency.expire(time.Now().Add(-DefaultExpiryDuration)); removed > 0 {
+				logger.Debugf("Shard %s: Dropped latency timestamps for %d unapplied requests", sl.shardID, removed)
+			}
 		case <-sl.stopC:
 			return
 		}
@@ -226,6 +684,12 @@ func (sl *ShardLeader) runProofCleanup() {
 
 // flushBatch proposes batched requests to Raft
 func (sl *ShardLeader) flushBatch() {
+	// Nobody can commit a batch before a leader is elected, so hold it
+	// instead of letting raft drop the proposal
+	if sl.node.Status().Lead == raft.None {
+		return
+	}
+
 	sl.batchLock.Lock()
 	if len(sl.batchQueue) == 0 {
 		sl.batchLock.Unlock()
@@ -237,15 +701,16 @@ func (sl *ShardLeader) flushBatch() {
 	sl.lastBatchTime = time.Now()
 	sl.batchLock.Unlock()
 
+	sl.latency.batched(batch, sl.lastBatchTime)
+	sl.batchSizes.observe(len(batch))
+
 	data, err := sl.serializeBatch(batch)
 	if err != nil {
 		logger.Errorf("Failed to serialize batch for shard %s: %v", sl.shardID, err)
 		return
 	}
 
-	if err := sl.node.Propose(context.TODO(), data); err != nil {
-		logger.Errorf("Failed to propose batch for shard %s: %v", sl.shardID, err)
-	}
+	sl.proposeBatch(batch, data)
 }
 
 // serializeBatch serializes a batch of prepare requests
@@ -254,6 +719,7 @@ func (sl *ShardLeader) serializeBatch(batch []*PrepareRequest) ([]byte, error) {
 		Requests: make([]*PrepareRequestProto, len(batch)),
 	}
 
+	now := time.Now()
 	for i, req := range batch {
 		readSet := make(map[string][]byte)
 		writeSet := make(map[string][]byte)
@@ -270,7 +736,13 @@ func (sl *ShardLeader) serializeBatch(batch []*PrepareRequest) ([]byte, error) {
 			ShardID:   req.ShardID,
 			ReadSet:   readSet,
 			WriteSet:  writeSet,
-			Timestamp: req.Timestamp.Unix(),
+			Timestamp: req.Timestamp.Wall,
+			ExpiresAt: now.Add(req.ttlOr(sl.dependencyTTL)).UnixNano(),
+			HLC:       observe(req),
+			Origin:    req.Origin,
+
+			RangeReads:     req.RangeReads,
+			ReservedRanges: req.ReservedRanges,
 		}
 	}
 
@@ -278,8 +750,10 @@ func (sl *ShardLeader) serializeBatch(batch []*PrepareRequest) ([]byte, error) {
 }
 
 // applyEntry applies a committed Raft entry
-func (sl *ShardLeader) applyEntry(entry raftpb.Entry) {
+func (sl *ShardLeader) applyEntry(entry raftpb.Entry, committedAt time.Time) {
 	sl.commitIndex = entry.Index
+	sl.touch()
+	defer sl.latency.entryApplied(entry.Index)
 
 	batch := &PrepareRequestBatch{}
 	if err := batch.Unmarshal(entry.Data); err != nil {
@@ -287,54 +761,85 @@ func (sl *ShardLeader) applyEntry(entry raftpb.Entry) {
 		return
 	}
 
-	for _, reqProto := range batch.Requests {
-		hasDependency, dependentTxID := sl.checkDependencies(reqProto)
+	for _, abort := range batch.Aborts {
+		sl.applyAbort(abort, entry)
+	}
+	if batch.GC != nil {
+		sl.applyGC(batch.GC, entry)
+	}
+	if batch.Expire != nil {
+		sl.applyExpire(batch.Expire, entry)
+	}
+	if batch.Decision != nil {
+		sl.applyDecision(batch.Decision, entry)
+	}
 
-		proof := &PrepareProof{
-			TxID:          reqProto.TxID,
-			ShardID:       sl.shardID,
-			CommitIndex:   sl.commitIndex,
-			LeaderID:      sl.node.Status().Lead,
-			Term:          entry.Term,
-			Signature:     sl.signProof(reqProto.TxID, sl.commitIndex),
-			DependentTxID: dependentTxID,
-			HasDependency: hasDependency,
+	// Read-only outcomes are cached once the whole entry is applied, unless a
+	// later request in the same entry wrote to one of the keys they read
+	type readOnlyCandidate struct {
+		keySet  string
+		readSet map[string][]byte
+		proof   *PrepareProof
+	}
+	var readOnly []*readOnlyCandidate
+
+	for _, reqProto := range batch.Requests {
+		if original, ok := sl.appliedProof(reqProto.TxID); ok {
+			sl.applyDuplicate(reqProto, original, entry, committedAt)
+			continue
 		}
 
-		sl.updateDependencyMap(reqProto, hasDependency, dependentTxID, entry.Index)
+		if len(reqProto.WriteSet) > 0 && len(readOnly) > 0 {
+			kept := readOnly[:0]
+			for _, c := range readOnly {
+				overwritten := false
+				for key := range reqProto.WriteSet {
+					if _, read := c.readSet[key]; read {
+						overwritten = true
+						break
+					}
+				}
+				if !overwritten {
+					kept = append(kept, c)
+				}
+			}
+			readOnly = kept
+		}
 
-		// 1. Cache the proof first for immediate resolution of late subscribers
-		sl.proofCacheLock.Lock()
-		sl.proofCache[reqProto.TxID] = proof
-		sl.proofCacheLock.Unlock()
+		res := sl.resolveConflicts(reqProto)
+		sl.observeEntry(reqProto)
 
-		// 2. Extract and delete subscribers atomically
-		sl.mu.Lock()
-		subs, exists := sl.subscribers[reqProto.TxID]
-		if exists {
-			delete(sl.subscribers, reqProto.TxID)
+		proof := &PrepareProof{
+			TxID:           reqProto.TxID,
+			ShardID:        sl.shardID,
+			CommitIndex:    sl.commitIndex,
+			LeaderID:       sl.node.Status().Lead,
+			Term:           entry.Term,
+			DependentTxID:  res.dependentTxID,
+			HasDependency:  res.hasDependency,
+			ConflictTypes:  res.conflictTypes,
+			ConflictPolicy: sl.conflictPolicy,
+			Rejected:       res.rejected,
+			AbortedTxIDs:   res.wounded,
+			HLC:            reqProto.HLC,
 		}
-		sl.mu.Unlock()
-
-		if exists {
-			for _, ch := range subs {
-				func(c chan *PrepareProof) {
-					defer func() {
-						if r := recover(); r != nil {
-							logger.Warnf("Shard %s: recovered from send on closed channel for tx %s", sl.shardID, reqProto.TxID)
-						}
-					}()
-					select {
-					case c <- proof:
-						logger.Debugf("Shard %s: Sent proof for tx %s at index %d", sl.shardID, reqProto.TxID, entry.Index)
-					default:
-						logger.Warnf("Commit channel full for tx %s in shard %s", reqProto.TxID, sl.shardID)
-					}
-				}(ch)
+		proof.Signature, proof.SignerID = sl.signProof(reqProto.TxID, sl.commitIndex, entry.Term)
+
+		if res.rejected {
+			logger.Debugf("Shard %s: Rejected tx %s conflicting with %s", sl.shardID, reqProto.TxID, res.dependentTxID)
+		} else {
+			if len(res.wounded) > 0 {
+				logger.Debugf("Shard %s: Tx %s wounded %v", sl.shardID, reqProto.TxID, res.wounded)
+				sl.releaseReservations(res.wounded)
 			}
+			proof.DependencyChain = sl.dependencyChain(reqProto, res.dependentTxID)
+			sl.updateDependencyMap(reqProto, res.hasDependency, res.dependentTxID, entry.Index)
 		}
 
-		// 3. Cleanup pending ID map
+		sl.rememberProof(proof)
+		sl.deliverProof(proof)
+
+		// Cleanup pending ID map
 		sl.batchLock.Lock()
 		delete(sl.pendingTxIDs, reqProto.TxID)
 		sl.batchLock.Unlock()
@@ -342,16 +847,59 @@ func (sl *ShardLeader) applyEntry(entry raftpb.Entry) {
 		sl.mu.Lock()
 		sl.requestsHandled++
 		sl.mu.Unlock()
+
+		sl.latency.applied(reqProto.TxID, entry.Index, committedAt, time.Now())
+
+		if keySet, ok := readOnlyKeySet(reqProto.ReadSet, reqProto.WriteSet); ok && len(reqProto.RangeReads) == 0 && !sl.holdsAnyKey(reqProto.TxID, reqProto.ReadSet) {
+			readOnly = append(readOnly, &readOnlyCandidate{keySet: keySet, readSet: reqProto.ReadSet, proof: proof})
+		}
+	}
+
+	for _, c := range readOnly {
+		sl.readProofs.store(c.keySet, entry.Index, c.proof, sl.dependencyExpiry(c.readSet, c.proof))
 	}
+	sl.expireAppliedProofs(entry.Index)
+	sl.evictDependencies(entry.Index)
 }
 
-// checkDependencies checks if transaction has dependencies
-func (sl *ShardLeader) checkDependencies(req *PrepareRequestProto) (bool, string) {
-	sl.variableMapLock.RLock()
-	defer sl.variableMapLock.RUnlock()
+// deliverProof caches the proof and hands it to the subscribers of its TxID
+func (sl *ShardLeader) deliverProof(proof *PrepareProof) {
+	// 1. Cache the proof first for immediate resolution of late subscribers
+	sl.proofCacheLock.Lock()
+	sl.proofCache[proof.TxID] = proof
+	sl.proofCacheLock.Unlock()
+
+	// 2. Extract and delete subscribers atomically
+	sl.mu.Lock()
+	subs, exists := sl.subscribers[proof.TxID]
+	if exists {
+		delete(sl.subscribers, proof.TxID)
+	}
+	sl.mu.Unlock()
+
+	for _, ch := range subs {
+		func(c chan *PrepareProof) {
+			defer func() {
+				if r := recover(); r != nil {
+					logger.Warnf("Shard %s: recovered from send on closed channel for tx %s", sl.shardID, proof.TxID)
+				}
+			}()
+			select {
+			case c <- proof:
+				logger.Debugf("Shard %s: Sent proof for tx %s at index %d", sl.shardID, proof.TxID, proof.CommitIndex)
+			default:
+				logger.Warnf("Commit channel full for tx %s in shard %s", proof.TxID, sl.shardID)
+			}
+		}(ch)
+	}
+}
 
+// checkDependencies checks if transaction has dependencies on reservations
+// within the ConflictWindow as of commitIndex, and classifies the conflicts
+// with each transaction it depends on
+func (sl *ShardLeader) checkDependencies(req *PrepareRequestProto, commitIndex uint64) (bool, conflictSet) {
 	hasDependency := false
-	depMap := make(map[string]bool)
+	conflicts := make(conflictSet)
 
 	// Must sort keys because Go map iteration is randomized
 	// If a tx touches multiple variables with dependencies, different
@@ -363,7 +911,7 @@ func (sl *ShardLeader) checkDependencies(req *PrepareRequestProto) (bool, string
 	sort.Strings(readKeys)
 
 	for _, key := range readKeys {
-		if depInfo, exists := sl.variableMap[key]; exists {
+		if depInfo, exists := sl.reservation(key); exists && sl.config.ConflictWindow.covers(depInfo, commitIndex, req.Timestamp) {
 			// CRITICAL: Ignore self-dependencies! If the same TxID appears
 			// twice in the Raft log, it MUST NOT depend on its own earlier
 			// version. This ensures that every endorsing peer produces
@@ -375,21 +923,25 @@ func (sl *ShardLeader) checkDependencies(req *PrepareRequestProto) (bool, string
 
 			hasDependency = true
 			if depInfo.DependentTxID != "" {
-				depMap[depInfo.DependentTxID] = true
+				conflicts.add(depInfo.DependentTxID, classifyConflict(true, depInfo.Read))
 			}
 			logger.Debugf("Shard %s: Tx %s has read dependency on %s for key %s",
 				sl.shardID, req.TxID, depInfo.DependentTxID, key)
 		}
 	}
 
+	// A writer reserves the keys it only reads as well, which were checked
+	// as reads above
 	var writeKeys []string
 	for k := range req.WriteSet {
-		writeKeys = append(writeKeys, k)
+		if _, read := req.ReadSet[k]; !read {
+			writeKeys = append(writeKeys, k)
+		}
 	}
 	sort.Strings(writeKeys)
 
 	for _, key := range writeKeys {
-		if depInfo, exists := sl.variableMap[key]; exists {
+		if depInfo, exists := sl.reservation(key); exists && sl.config.ConflictWindow.covers(depInfo, commitIndex, req.Timestamp) {
 			// CRITICAL: Ignore self-dependencies
 			if depInfo.DependentTxID == req.TxID {
 				continue
@@ -397,73 +949,118 @@ func (sl *ShardLeader) checkDependencies(req *PrepareRequestProto) (bool, string
 
 			hasDependency = true
 			if depInfo.DependentTxID != "" {
-				depMap[depInfo.DependentTxID] = true
+				conflicts.add(depInfo.DependentTxID, classifyConflict(false, depInfo.Read))
 			}
 			logger.Debugf("Shard %s: Tx %s has write dependency on %s for key %s",
 				sl.shardID, req.TxID, depInfo.DependentTxID, key)
 		}
 	}
 
-	var depList []string
-	for txID := range depMap {
-		depList = append(depList, txID)
+	if sl.rangeDependencies(req, commitIndex, conflicts) {
+		hasDependency = true
+	}
+
+	return hasDependency, conflicts
+}
+
+// holdsAnyKey reports whether the transaction holds a reservation on any of
+// the keys. Such outcomes skip the self-dependency and cannot be shared.
+func (sl *ShardLeader) holdsAnyKey(txID string, keys map[string][]byte) bool {
+	for key := range keys {
+		if info, exists := sl.reservation(key); exists && info.DependentTxID == txID {
+			return true
+		}
 	}
-	sort.Strings(depList)
-	dependentTxID := strings.Join(depList, ",")
+	return false
+}
 
-	return hasDependency, dependentTxID
+// reservation returns the reservation of key, locking only its stripe
+func (sl *ShardLeader) reservation(key string) (TransactionDependencyInfo, bool) {
+	sl.variableMapLock.RLockKey(key)
+	defer sl.variableMapLock.RUnlockKey(key)
+	return sl.variableMap.Get(key)
 }
 
 // updateDependencyMap updates the shard's dependency tracking
 func (sl *ShardLeader) updateDependencyMap(req *PrepareRequestProto, hasDep bool, depTxID string, commitIndex uint64) {
-	sl.variableMapLock.Lock()
-	defer sl.variableMapLock.Unlock()
-
-	expiryTime := time.Now().Add(DefaultExpiryDuration)
+	// The expiry comes from the log so that all replicas prune alike
+	expiryTime := time.Unix(0, req.ExpiresAt)
+	if req.ExpiresAt == 0 {
+		expiryTime = time.Unix(0, req.Timestamp).Add(DefaultExpiryDuration)
+	}
 
 	for key := range req.WriteSet {
-		sl.variableMap[key] = TransactionDependencyInfo{
+		_, read := req.ReadSet[key]
+		sl.variableMapLock.LockKey(key)
+		prev, held := sl.variableMap.Get(key)
+		sl.variableMap.Put(key, TransactionDependencyInfo{
+			Read:          read,
 			Value:         req.WriteSet[key],
 			DependentTxID: req.TxID,
 			ExpiryTime:    expiryTime,
 			HasDependency: hasDep,
-		}
+			Timestamp:     req.Timestamp,
+			CommitIndex:   commitIndex,
+			Chain:         extendChain(prev, held, req.TxID, time.Unix(0, req.Timestamp)),
+			Origin:        req.Origin,
+		})
+		sl.variableMapLock.UnlockKey(key)
 		logger.Debugf("Shard %s: Updated dependency map for key %s -> tx %s at index %d",
 			sl.shardID, key, req.TxID, commitIndex)
 	}
+	sl.reserveRanges(req, expiryTime, commitIndex)
 }
 
-// signProof creates a signature for the proof
-func (sl *ShardLeader) signProof(txID string, commitIndex uint64) []byte {
-	data := fmt.Sprintf("%s:%d:%s", sl.shardID, commitIndex, txID)
-	return []byte(data)
-}
-
-// HandleAbort handles abort requests
-func (sl *ShardLeader) HandleAbort(txID string) error {
-	abortData := &AbortEntry{
-		TxID:      txID,
-		Timestamp: time.Now().Unix(),
+// commitDependencies persists the dependency map as of the given applied
+// index. It must only be called from runApply.
+func (sl *ShardLeader) commitDependencies(index uint64) {
+	sl.variableMapLock.Lock()
+	defer sl.variableMapLock.Unlock()
+	if index <= sl.variableMap.Index() {
+		return
 	}
-
-	data, err := abortData.Marshal()
-	if err != nil {
-		return err
+	if err := sl.variableMap.Commit(index); err != nil {
+		logger.Panicf("Shard %s: Failed to persist dependencies at index %d: %v", sl.shardID, index, err)
 	}
-
-	return sl.node.Propose(context.TODO(), data)
 }
 
-// ProposeC returns the propose channel
+// ProposeC returns the propose channel. Sends on it block while the queue is
+// full; prefer Propose, which honours a context and reports why it failed.
 func (sl *ShardLeader) ProposeC() chan<- *PrepareRequest {
 	return sl.proposeC
 }
 
-// HasProof checks if a proof for the given TxID is already in the cache
+// Propose queues the request for the next batch, waiting for room in the
+// queue until ctx ends. It fails with ErrQueueFull if the queue stayed full
+// and with ErrStopped once the shard is stopped.
+func (sl *ShardLeader) Propose(ctx context.Context, req *PrepareRequest) error {
+	select {
+	case <-sl.stopC:
+		return fmt.Errorf("shard %s: %w", sl.shardID, ErrStopped)
+	default:
+	}
+
+	select {
+	case sl.proposeC <- req:
+		logger.Debugf("Submitted prepare request for tx %s to shard %s", req.TxID, sl.shardID)
+		return nil
+	case <-sl.stopC:
+		return fmt.Errorf("shard %s: %w", sl.shardID, ErrStopped)
+	case <-ctx.Done():
+		return fmt.Errorf("shard %s: %w", sl.shardID, ErrQueueFull)
+	}
+}
+
+// HasProof checks if a proof for the given TxID is already in the cache or
+// remembered by the state machine
 func (sl *ShardLeader) HasProof(txID string) bool {
 	sl.proofCacheLock.RLock()
-	defer sl.proofCacheLock.RUnlock()
 	_, exists := sl.proofCache[txID]
+	sl.proofCacheLock.RUnlock()
+	if exists {
+		return true
+	}
+	_, exists = sl.appliedProof(txID)
 	return exists
 }
 
@@ -490,6 +1087,52 @@ func (sl *ShardLeader) Subscribe(txID string) <-chan *PrepareProof {
 	return ch
 }
 
+// ProposeAndWait submits the request and waits for the proof of its own
+// TxID, so concurrent proposers never receive each other's proofs. Requests
+// whose proof is already cached are not proposed again, and retries of an
+// applied transaction get its original proof. With QuorumCert set
+// the proof is returned once a quorum of voters co-signed it, and read-only
+// proofs are not reused since other replicas hold no record of them.
+func (sl *ShardLeader) ProposeAndWait(ctx context.Context, req *PrepareRequest) (*PrepareProof, error) {
+	if !sl.config.QuorumCert {
+		if proof, ok := sl.CachedReadOnlyProof(req); ok {
+			return proof, nil
+		}
+	}
+
+	if proof, ok := sl.appliedProof(req.TxID); ok {
+		atomic.AddUint64(&sl.duplicateProposals, 1)
+		if sl.config.QuorumCert {
+			return sl.certify(ctx, proof)
+		}
+		return proof, nil
+	}
+
+	// Subscribe first so the proof cannot be broadcast before we listen
+	commitC := sl.Subscribe(req.TxID)
+	defer sl.Unsubscribe(req.TxID, commitC)
+
+	// Skip the proposal if the proof is already cached, or the request is
+	// already queued, e.g. by PrepareBatch, avoiding redundant Raft entries
+	if !sl.HasProof(req.TxID) && !sl.queued(req.TxID) {
+		if err := sl.Propose(ctx, req); err != nil {
+			return nil, err
+		}
+	}
+
+	select {
+	case proof := <-commitC:
+		if sl.config.QuorumCert {
+			return sl.certify(ctx, proof)
+		}
+		return proof, nil
+	case <-sl.stopC:
+		return nil, fmt.Errorf("shard %s: %w", sl.shardID, ErrStopped)
+	case <-ctx.Done():
+		return nil, fmt.Errorf("timeout waiting for proof from shard %s", sl.shardID)
+	}
+}
+
 // Unsubscribe removes a specific subscription channel.
 func (sl *ShardLeader) Unsubscribe(txID string, ch <-chan *PrepareProof) {
 	sl.mu.Lock()
@@ -516,6 +1159,12 @@ func (sl *ShardLeader) GetRequestsHandled() uint64 {
 	return sl.requestsHandled
 }
 
+// LatencyBreakdown returns the propose-to-commit latency histograms of
+// requests enqueued on this replica, keyed by pipeline stage
+func (sl *ShardLeader) LatencyBreakdown() map[LatencyStage]HistogramSnapshot {
+	return sl.latency.snapshot()
+}
+
 // MessagesC returns the channel for outgoing Raft messages
 func (sl *ShardLeader) MessagesC() <-chan []raftpb.Message {
 	return sl.messagesC
@@ -523,11 +1172,26 @@ func (sl *ShardLeader) MessagesC() <-chan []raftpb.Message {
 
 // Step advances the state machine using the given message
 func (sl *ShardLeader) Step(ctx context.Context, msg raftpb.Message) error {
+	sl.recordLeaderContact(msg)
 	return sl.node.Step(ctx, msg)
 }
 
+// ReportSnapshot reports the outcome of sending a snapshot to a replica, so
+// that the leader resumes replicating to it or retries the snapshot
+func (sl *ShardLeader) ReportSnapshot(id uint64, status raft.SnapshotStatus) {
+	sl.node.ReportSnapshot(id, status)
+}
+
+// ReportUnreachable reports that the last message to a replica could not be
+// delivered
+func (sl *ShardLeader) ReportUnreachable(id uint64) {
+	sl.node.ReportUnreachable(id)
+}
+
 // Stop gracefully stops the shard leader
 func (sl *ShardLeader) Stop() {
 	close(sl.stopC)
 	sl.node.Stop()
+	// The WAL and the state store are closed once runRaft returns
+	<-sl.raftDoneC
 }
@@ -1,6 +1,7 @@
 package sharding_test
 
 import (
+    "context"
     "testing"
     "time"
     
@@ -23,7 +24,8 @@ var _ = Describe("ShardLeader", func() {
     BeforeEach(func() {
         config = sharding.ShardConfig{
             ShardID: "testContract",
-            ReplicaNodes: []string{"node1", "node2", "node3"},
+            ReplicaNodes: []string{"node1"},
+            ReplicaIDs: []uint64{1},
             ReplicaID: 1,
         }
         var err error
@@ -44,41 +46,43 @@ var _ = Describe("ShardLeader", func() {
             TxID: "tx1",
             ShardID: "testContract",
             WriteSet: map[string][]byte{"key1": []byte("value1")},
-            Timestamp: time.Now(),
+            Timestamp: sharding.Clock().Now(),
         }
         
-        shard.ProposeC() <- req
-        
-        select {
-        case proof := <-shard.CommitC():
-            Expect(proof).ToNot(BeNil())
-            Expect(proof.TxID).To(Equal("tx1"))
-        case <-time.After(5 * time.Second):
-            Fail("Timeout waiting for proof")
-        }
+        // A single replica campaigns only after its election timeout
+        ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+        defer cancel()
+
+        proof, err := shard.ProposeAndWait(ctx, req)
+        Expect(err).ToNot(HaveOccurred())
+        Expect(proof.TxID).To(Equal("tx1"))
     })
     
     It("should detect dependencies", func() {
+        ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+        defer cancel()
+
         // First transaction
         req1 := &sharding.PrepareRequest{
             TxID: "tx1",
             ShardID: "testContract",
             WriteSet: map[string][]byte{"key1": []byte("value1")},
-            Timestamp: time.Now(),
+            Timestamp: sharding.Clock().Now(),
         }
-        shard.ProposeC() <- req1
-        <-shard.CommitC()
+        _, err := shard.ProposeAndWait(ctx, req1)
+        Expect(err).ToNot(HaveOccurred())
         
         // Second transaction with dependency
         req2 := &sharding.PrepareRequest{
             TxID: "tx2",
             ShardID: "testContract",
             ReadSet: map[string][]byte{"key1": []byte("value1")},
-            Timestamp: time.Now(),
+            Timestamp: sharding.Clock().Now(),
         }
-        shard.ProposeC() <- req2
-        
-        proof := <-shard.CommitC()
-        Expect(proof.CommitIndex).To(BeNumerically(">", 1))
+        proof, err := shard.ProposeAndWait(ctx, req2)
+        Expect(err).ToNot(HaveOccurred())
+        Expect(proof.TxID).To(Equal("tx2"))
+        Expect(proof.HasDependency).To(BeTrue())
+        Expect(proof.DependentTxID).To(Equal("tx1"))
     })
 })
\ No newline at end of file
@@ -7,10 +7,10 @@ SPDX-License-Identifier: Apache-2.0
 package sharding
 
 import (
-	"encoding/json"
 	"os"
-	"sort"
+	"strings"
 	"sync"
+	"time"
 )
 
 // Global Transport instance across all ShardManagers in the Peer
@@ -24,32 +24,141 @@ type Metrics interface{}
 
 // ShardManager manages multiple contract shards
 type ShardManager struct {
-	shards     map[string]*ShardLeader
-	shardsLock sync.RWMutex
-	config     map[string]ShardConfig
-	metrics    Metrics
+	shards      map[string]*ShardLeader
+	shardsLock  sync.RWMutex
+	config      map[string]ShardConfig
+	metrics     Metrics
+	partitioner *KeyPartitioner
+	events      *shardEvents
+	registry    *RegistryClient
+	// transportTLS secures the shard transport; it is nil without TLS
+	transportTLS *TransportTLS
+	// authSecret is the secret shared by the peers that signs the requests
+	// of the transport and of the shard REST API; it is nil when they are
+	// not signed
+	authSecret []byte
+
+	// topology is replaced by SetTopology; topologyLock guards it
+	topology     *ShardTopology
+	topologyLock sync.RWMutex
+
+	// idleTimeout and maxActiveShards bound the dynamically created shards
+	// kept running; evictions is accessed atomically
+	idleTimeout     time.Duration
+	maxActiveShards int
+	evictions       uint64
+	stopC           chan struct{}
+	// pinnedShards are never evicted; shardsLock guards it
+	pinnedShards map[string]bool
+
+	// topologyFile, dependencyTTL, dataDir, maxDependencies, hedgeDelay and
+	// backupDir are set from ShardManagerOptions
+	topologyFile    string
+	dependencyTTL   time.Duration
+	dataDir         string
+	maxDependencies int
+	hedgeDelay      time.Duration
+	backupDir       string
+
+	// gossip pushes the updates of the shards led by this peer to the other
+	// peers; it is nil when they are not gossiped
+	gossip *dependencyGossip
 }
 
-// NewShardManager creates a shard manager
+// ShardManagerOptions configures a ShardManager through the peer's
+// configuration rather than the FABRIC_SHARD_* variables. Zero fields keep
+// the behavior configured by the variables.
+type ShardManagerOptions struct {
+	// TopologyFile is the file the topology is reloaded from. It takes
+	// precedence over ShardTopologyEnvVar.
+	TopologyFile string
+	// DependencyTTL is how long the shards keep the reservations of a
+	// prepared transaction. Defaults to DefaultExpiryDuration.
+	DependencyTTL time.Duration
+	// DataDir is the directory under which the shards persist their Raft log
+	// and state, so that their reservations survive a restart. It takes
+	// precedence over ShardDataDirEnvVar.
+	DataDir string
+	// MaxDependencies caps the number of reservations of each shard. It
+	// takes precedence over MaxDependenciesEnvVar.
+	MaxDependencies int
+	// HedgeDelay is how long a prepare sent to a remote replica may go
+	// unanswered before it is sent to another replica as well. It takes
+	// precedence over ShardHedgeDelayEnvVar.
+	HedgeDelay time.Duration
+	// GossipInterval is how often the shard leaders of this peer push their
+	// updates to the peers that do not replicate their shard. It takes
+	// precedence over ShardGossipIntervalEnvVar.
+	GossipInterval time.Duration
+	// BackupDir is the directory the admin API writes backups to and
+	// restores them from. It takes precedence over ShardBackupDirEnvVar.
+	BackupDir string
+}
+
+// NewShardManager creates a shard manager with the topology configured via
+// ShardTopologyEnvVar
 func NewShardManager(configs map[string]ShardConfig, metrics Metrics) *ShardManager {
+	topology, err := LoadShardTopologyFromEnv()
+	if err != nil {
+		logger.Errorf("Falling back to the default shard topology: %v", err)
+		topology = DefaultShardTopology()
+	}
+	return NewShardManagerWithTopology(configs, topology, metrics)
+}
+
+// NewShardManagerWithTopology creates a shard manager that replicates shards
+// as described by topology
+func NewShardManagerWithTopology(configs map[string]ShardConfig, topology *ShardTopology, metrics Metrics) *ShardManager {
+	return NewShardManagerWithOptions(configs, topology, metrics, ShardManagerOptions{})
+}
+
+// NewShardManagerWithOptions creates a shard manager that replicates shards
+// as described by topology, configured by opts
+func NewShardManagerWithOptions(configs map[string]ShardConfig, topology *ShardTopology, metrics Metrics, opts ShardManagerOptions) *ShardManager {
 	if configs == nil {
 		configs = make(map[string]ShardConfig)
 	}
 
 	sm := &ShardManager{
-		shards:  make(map[string]*ShardLeader),
-		config:  configs,
-		metrics: metrics,
+		shards:      make(map[string]*ShardLeader),
+		config:      configs,
+		metrics:     metrics,
+		partitioner: NewKeyPartitionerFromEnv(),
+		topology:    topology,
+		events:      newShardEvents(),
+		stopC:       make(chan struct{}),
+
+		pinnedShards: make(map[string]bool),
+
+		topologyFile:    opts.TopologyFile,
+		dependencyTTL:   opts.DependencyTTL,
+		dataDir:         opts.DataDir,
+		maxDependencies: opts.MaxDependencies,
+		hedgeDelay:      opts.HedgeDelay,
+		backupDir:       opts.BackupDir,
 	}
-
-	// 1. Determine local address for the transport binding
-	myAddr := os.Getenv("CORE_PEER_ADDRESS")
-	if myAddr == "" {
-		myAddr = "localhost:7051"
+	if sm.hedgeDelay == 0 {
+		sm.hedgeDelay = hedgeDelayFromEnv()
+	}
+	gossipInterval := opts.GossipInterval
+	if gossipInterval == 0 {
+		gossipInterval = gossipIntervalFromEnv()
+	}
+	if gossipInterval > 0 {
+		sm.gossip = newDependencyGossip(sm, gossipInterval)
 	}
+	// Refuse to fall back to plaintext when TLS is configured but unusable
+	transportTLS, err := TransportTLSFromEnv()
+	if err != nil {
+		logger.Panicf("Failed to load the TLS configuration of the shard transport: %v", err)
+	}
+	sm.transportTLS = transportTLS
+	sm.authSecret = authTokenFromEnv()
+	sm.registry = newRegistryClientFromEnv(transportTLS)
+	sm.idleTimeout, sm.maxActiveShards = idleEvictionFromEnv()
 
-	// 2. Discover the global replica node list and Initialize Transport
-	sm.initGlobalTransportOnce(myAddr)
+	// Start the transport shared by the shards of this peer
+	sm.initGlobalTransportOnce(localPeerAddress())
 
 	// 6. Pre-initialize any configured shards
 	for shardID, config := range configs {
@@ -59,19 +168,32 @@ func NewShardManager(configs map[string]ShardConfig, metrics Metrics) *ShardMana
 			continue
 		}
 		sm.shards[shardID] = shard
+		sm.watchShard(shard)
 		logger.Infof("Initialized shard %s with %d replicas", shardID, len(config.ReplicaNodes))
 	}
 
+	// 7. Restart the shards persisted before the peer restarted
+	sm.recoverShards()
+
+	if sm.idleTimeout > 0 {
+		go sm.runIdleEviction()
+	}
+	if interval := topologyReloadFromEnv(); interval > 0 {
+		go sm.runTopologyReload(interval)
+	}
+	if sm.registry != nil {
+		go sm.runRegistryAnnouncer(DefaultRegistrationTTL / 3)
+	}
+	if sm.gossip != nil {
+		go sm.gossip.run()
+	}
+
 	return sm
 }
 
 // GetOrCreateShard gets or creates a shard for a contract
 func (sm *ShardManager) GetOrCreateShard(contractName string) (*ShardLeader, error) {
-	sm.shardsLock.RLock()
-	shard, exists := sm.shards[contractName]
-	sm.shardsLock.RUnlock()
-
-	if exists {
+	if shard, exists := sm.lookupShard(contractName); exists {
 		return shard, nil
 	}
 
@@ -83,83 +205,115 @@ func (sm *ShardManager) GetOrCreateShard(contractName string) (*ShardLeader, err
 		return shard, nil
 	}
 
-	// Default config
-	config := ShardConfig{
-		ShardID:      contractName,
-		ReplicaNodes: []string{"localhost:7051", "localhost:7052", "localhost:7053"},
-		ReplicaIDs:   []uint64{1, 2, 3},
-		ReplicaID:    1,
+	return sm.startShardLocked(sm.shardConfig(contractName), nil)
+}
+
+// ShardForKey returns the ID of the shard that tracks the key of the
+// contract on the channel. Unless key-hash sharding is enabled for the
+// contract via ShardPartitionsEnvVar, this is the contract name itself,
+// scoped to the channel, so that the contracts of the same name on two
+// channels do not share their reservations.
+func (sm *ShardManager) ShardForKey(channel, contract, key string) string {
+	return ChannelShardID(channel, sm.partitioner.ShardForKey(contract, key))
+}
+
+// ShardsOfContract returns the IDs of all the shards of the contract on the
+// channel
+func (sm *ShardManager) ShardsOfContract(channel, contract string) []string {
+	shards := sm.partitioner.ShardsOfContract(contract)
+	for i, shardID := range shards {
+		shards[i] = ChannelShardID(channel, shardID)
 	}
+	return shards
+}
 
-	myAddr := os.Getenv("CORE_PEER_ADDRESS")
-	if myAddr == "" {
-		myAddr = "localhost:7051"
+// shardDataDir returns the directory the shards persist their state under,
+// or "" when they keep it in memory only
+func (sm *ShardManager) shardDataDir() string {
+	if sm.dataDir != "" {
+		return sm.dataDir
+	}
+	return os.Getenv(ShardDataDirEnvVar)
+}
+
+// shardConfig builds the configuration of a shard from the topology
+func (sm *ShardManager) shardConfig(contractName string) ShardConfig {
+	config := ShardConfig{
+		ShardID:        contractName,
+		ReplicaID:      1,
+		ConflictPolicy: conflictPolicyFromEnv(ContractOfShard(contractName)),
+		ConflictWindow: conflictWindowFromEnv(ContractOfShard(contractName)),
+		DataDir:        sm.shardDataDir(),
+		QuorumCert:     os.Getenv(QuorumCertEnvVar) == "true",
+		StateStore:     os.Getenv(StateStoreEnvVar),
+		DependencyTTL:  sm.dependencyTTL,
+	}
+	config.MaxDependencies = sm.maxDependencies
+	if config.MaxDependencies <= 0 {
+		config.MaxDependencies = maxDependenciesFromEnv()
 	}
 
-	// Try to load from configuration file
-	if externalConfig, err := loadShardingConfig("sharding.json"); err == nil {
-		if replicas, ok := externalConfig[contractName]; ok {
-			config.ReplicaNodes = replicas
-			logger.Infof("Loaded configuration for shard %s: %v", contractName, replicas)
-
-			// Compute global deterministic mapping
-			var globalReplicas []string
-			replicaSet := make(map[string]bool)
-			for _, repls := range externalConfig {
-				for _, r := range repls {
-					if !replicaSet[r] {
-						replicaSet[r] = true
-						globalReplicas = append(globalReplicas, r)
-					}
-				}
-			}
-			sort.Strings(globalReplicas)
-
-			// Resolve local replicas directly to global ID map indices
-			var globalIDs []uint64
-			for _, nodeAddr := range replicas {
-				for i, gAddr := range globalReplicas {
-					if nodeAddr == gAddr {
-						globalIDs = append(globalIDs, uint64(i+1))
-						if nodeAddr == myAddr {
-							config.ReplicaID = uint64(i + 1)
-						}
-						break
-					}
-				}
-			}
-			config.ReplicaIDs = globalIDs
+	if keyPath := os.Getenv(ProofSigningKeyEnvVar); keyPath != "" {
+		key, err := LoadProofSigningKey(keyPath)
+		if err != nil {
+			logger.Errorf("Shard %s will issue unsigned proofs: %v", contractName, err)
+		} else {
+			config.SigningKey = key
 		}
 	}
 
-	shard, err := NewShardLeader(config, DefaultBatchTimeout, DefaultBatchMaxSize)
+	topology := sm.Topology()
+	set, ok := topology.ReplicaSet(contractName)
+	if !ok {
+		set = *defaultReplicaSet()
+	}
+	config.ReplicaIDs, config.ReplicaNodes = set.sortedReplicas()
+	if id, ok := topology.localReplica(set, advertisedPeerAddress()); ok {
+		config.ReplicaID = id
+	}
+	logger.Infof("Loaded configuration for shard %s: %v", contractName, config.ReplicaNodes)
+
+	return config
+}
+
+// startShardLocked starts a shard seeded with the given dependencies and
+// hooks it into the transport. The caller must hold shardsLock.
+func (sm *ShardManager) startShardLocked(config ShardConfig, dependencies map[string]TransactionDependencyInfo) (*ShardLeader, error) {
+	sm.makeRoomLocked()
+
+	shard, err := newShardLeader(config, DefaultBatchTimeout, DefaultBatchMaxSize, dependencies)
 	if err != nil {
 		return nil, err
 	}
 
-	sm.initGlobalTransportOnce(myAddr)
+	sm.initGlobalTransportOnce(localPeerAddress())
 
-	globalTransport.RegisterShard(contractName, shard)
+	globalTransport.RegisterShard(config.ShardID, shard)
 
-	sm.shards[contractName] = shard
+	sm.shards[config.ShardID] = shard
+	sm.watchShard(shard)
 
-	logger.Infof("Created shard for contract %s with ReplicaID %d and hooked into multiplexed transport", contractName, config.ReplicaID)
+	logger.Infof("Created shard for contract %s with ReplicaID %d and hooked into multiplexed transport", config.ShardID, config.ReplicaID)
 	return shard, nil
 }
 
-// Helper to load config
-func loadShardingConfig(path string) (map[string][]string, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return nil, err
+// localPeerAddress returns the address this peer is reachable at
+func localPeerAddress() string {
+	myAddr := os.Getenv("CORE_PEER_ADDRESS")
+	if myAddr == "" {
+		myAddr = "localhost:7051"
 	}
-	defer file.Close()
+	return myAddr
+}
 
-	var config map[string][]string
-	if err := json.NewDecoder(file).Decode(&config); err != nil {
-		return nil, err
+// advertisedPeerAddress returns the address other peers reach this peer at,
+// under which it appears in the topology. It is the address of the peer
+// unless ShardAdvertisedAddressEnvVar overrides it.
+func advertisedPeerAddress() string {
+	if addr := strings.TrimSpace(os.Getenv(ShardAdvertisedAddressEnvVar)); addr != "" {
+		return addr
 	}
-	return config, nil
+	return localPeerAddress()
 }
 
 func (sm *ShardManager) initGlobalTransportOnce(myAddr string) {
@@ -170,36 +324,28 @@ func (sm *ShardManager) initGlobalTransportOnce(myAddr string) {
 		return
 	}
 
-	var globalReplicas []string
-	if externalConfig, err := loadShardingConfig("sharding.json"); err == nil {
-		replicaSet := make(map[string]bool)
-		for _, replicas := range externalConfig {
-			for _, r := range replicas {
-				if !replicaSet[r] {
-					replicaSet[r] = true
-					globalReplicas = append(globalReplicas, r)
-				}
-			}
-		}
-		sort.Strings(globalReplicas)
-	} else {
-		globalReplicas = []string{"localhost:7051", "localhost:7052", "localhost:7053"}
+	topology := sm.Topology()
+	peers := topology.Peers()
+	if len(peers) == 0 {
+		peers = PeerConfig(defaultReplicaSet().Replicas)
 	}
-
-	var replicaID uint64 = 1
-	for i, nodeAddr := range globalReplicas {
-		if nodeAddr == myAddr {
-			replicaID = uint64(i + 1)
-			break
-		}
+	advertisedAddr := advertisedPeerAddress()
+	replicaID := topology.LocalReplicaID(advertisedAddr)
+	if replicaID == 0 {
+		replicaID = replicaAt(peers, advertisedAddr)
 	}
-
-	peers := make(PeerConfig)
-	for i, addr := range globalReplicas {
-		peers[uint64(i+1)] = addr
+	if replicaID == 0 {
+		replicaID = 1
 	}
 
 	transport := NewTransport(replicaID, myAddr, peers)
+	if registryServeFromEnv() {
+		transport.ServeRegistry(NewShardRegistry(DefaultRegistrationTTL))
+	}
+	if sm.transportTLS != nil {
+		transport.UseTLS(sm.transportTLS.withServerNames(topology.ServerNames))
+	}
+	transport.UseAuthToken(sm.authSecret)
 	if err := transport.Start(); err != nil {
 		logger.Errorf("Failed to start global shard transport: %v", err)
 	} else {
@@ -215,9 +361,21 @@ func (sm *ShardManager) initGlobalTransportOnce(myAddr string) {
 
 // Shutdown stops all shards
 func (sm *ShardManager) Shutdown() {
+	// Deliver the last events once shardsLock is released, in case an
+	// observer queries the manager
+	defer sm.events.close()
 	sm.shardsLock.Lock()
 	defer sm.shardsLock.Unlock()
 
+	select {
+	case <-sm.stopC:
+	default:
+		close(sm.stopC)
+		if sm.registry != nil {
+			sm.registry.Close()
+		}
+	}
+
 	globalTransportLock.Lock()
 	if globalTransport != nil {
 		logger.Infof("Stopping global shard transport")
@@ -229,18 +387,58 @@ func (sm *ShardManager) Shutdown() {
 	for shardID, shard := range sm.shards {
 		logger.Infof("Stopping shard %s", shardID)
 		shard.Stop()
+		sm.events.publish(shardEvent{kind: shardStopped, shardID: shardID})
 	}
 }
 
 // GetShardMetrics returns metrics for all shards
-func (sm *ShardManager) GetShardMetrics() map[string]int64 {
+func (sm *ShardManager) GetShardMetrics() map[string]ShardMetrics {
 	sm.shardsLock.RLock()
 	defer sm.shardsLock.RUnlock()
 
-	metrics := make(map[string]int64)
+	metrics := make(map[string]ShardMetrics)
 	for shardID, shard := range sm.shards {
-		metrics[shardID] = int64(shard.GetRequestsHandled())
+		metrics[shardID] = shard.Metrics()
 	}
 
 	return metrics
 }
+
+// GetLatencyBreakdown returns the propose-to-commit latency histograms for all shards
+func (sm *ShardManager) GetLatencyBreakdown() map[string]map[LatencyStage]HistogramSnapshot {
+	sm.shardsLock.RLock()
+	defer sm.shardsLock.RUnlock()
+
+	breakdown := make(map[string]map[LatencyStage]HistogramSnapshot)
+	for shardID, shard := range sm.shards {
+		breakdown[shardID] = shard.LatencyBreakdown()
+	}
+
+	return breakdown
+}
+
+// GetMembership returns the voters and learners of all shards
+func (sm *ShardManager) GetMembership() map[string]ShardMembership {
+	sm.shardsLock.RLock()
+	defer sm.shardsLock.RUnlock()
+
+	membership := make(map[string]ShardMembership)
+	for shardID, shard := range sm.shards {
+		membership[shardID] = shard.Membership()
+	}
+
+	return membership
+}
+
+// GetStatus returns the state of every shard hosted by this peer
+func (sm *ShardManager) GetStatus() map[string]ShardStatus {
+	sm.shardsLock.RLock()
+	defer sm.shardsLock.RUnlock()
+
+	status := make(map[string]ShardStatus)
+	for shardID, shard := range sm.shards {
+		status[shardID] = shard.GetStatus()
+	}
+
+	return status
+}
@@ -0,0 +1,102 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultBatchSizeBuckets are the upper bounds of the batch size histogram
+var DefaultBatchSizeBuckets = []float64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000}
+
+// LatencyPercentiles summarizes a latency histogram
+type LatencyPercentiles struct {
+	P50 time.Duration
+	P90 time.Duration
+	P99 time.Duration
+}
+
+// ShardMetrics is a point-in-time set of counters and distributions of a
+// shard replica, suitable for export
+type ShardMetrics struct {
+	RequestsHandled uint64
+	// CommitLatency summarizes the time from enqueue to apply of the
+	// requests proposed on this replica
+	CommitLatency LatencyPercentiles
+	// QueueDepth counts the prepare requests waiting to be proposed
+	QueueDepth int
+	// BatchSizes is the distribution of the number of requests in the
+	// batches proposed on this replica
+	BatchSizes         HistogramSnapshot
+	Aborts             uint64
+	DuplicateProposals uint64
+	// LockContention counts the acquisitions of a stripe of the dependency
+	// map that had to wait for another holder
+	LockContention uint64
+	// EvictedDependencies counts the reservations evicted to respect
+	// MaxDependencies
+	EvictedDependencies uint64
+	// LeaderID is 0 while no leader is known to this replica
+	LeaderID uint64
+	IsLeader bool
+	Term     uint64
+}
+
+// sizeHistogram is a latencyHistogram of plain values, safe for concurrent use
+type sizeHistogram struct {
+	mu sync.Mutex
+	h  *latencyHistogram
+}
+
+func newSizeHistogram(buckets []float64) *sizeHistogram {
+	return &sizeHistogram{h: newLatencyHistogram(buckets)}
+}
+
+func (s *sizeHistogram) observe(v int) {
+	s.mu.Lock()
+	s.h.observeValue(float64(v))
+	s.mu.Unlock()
+}
+
+func (s *sizeHistogram) snapshot() HistogramSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.h.snapshot()
+}
+
+// percentiles summarizes a histogram of durations in seconds
+func percentiles(h HistogramSnapshot) LatencyPercentiles {
+	seconds := func(q float64) time.Duration {
+		return time.Duration(h.Quantile(q) * float64(time.Second))
+	}
+	return LatencyPercentiles{P50: seconds(0.5), P90: seconds(0.9), P99: seconds(0.99)}
+}
+
+// Aborts returns the number of aborts applied by this replica
+func (sl *ShardLeader) Aborts() uint64 {
+	return atomic.LoadUint64(&sl.aborts)
+}
+
+// Metrics returns the current metrics of the replica
+func (sl *ShardLeader) Metrics() ShardMetrics {
+	status := sl.GetStatus()
+	return ShardMetrics{
+		RequestsHandled:     sl.GetRequestsHandled(),
+		CommitLatency:       percentiles(sl.LatencyBreakdown()[StageTotal]),
+		QueueDepth:          status.QueueDepth,
+		BatchSizes:          sl.batchSizes.snapshot(),
+		Aborts:              sl.Aborts(),
+		DuplicateProposals:  status.DuplicateProposals,
+		LockContention:      sl.variableMapLock.Contention(),
+		EvictedDependencies: status.EvictedDependencies,
+		LeaderID:            status.LeaderID,
+		IsLeader:            status.IsLeader,
+		Term:                status.Term,
+	}
+}
@@ -0,0 +1,186 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+const (
+	// ProofSigningKeyEnvVar is the path of a PEM encoded PKCS #8 ed25519
+	// private key with which the shards of this peer sign their proofs.
+	// Proofs carry an unkeyed digest when it is unset.
+	ProofSigningKeyEnvVar = "FABRIC_SHARD_SIGNING_KEY"
+	// QuorumCertEnvVar makes shards attach the co-signatures of a quorum of
+	// replicas to their proofs when set to "true"
+	QuorumCertEnvVar = "FABRIC_SHARD_QUORUM_CERT"
+	// ProofVerifierConfigEnvVar is the path of a ProofVerifierConfig file
+	// listing the keys of the shard replicas. Proofs are only checked for
+	// their form when it is unset.
+	ProofVerifierConfigEnvVar = "FABRIC_SHARD_PROOF_KEYS"
+)
+
+// proofSigningDomain keeps proof signatures apart from anything else signed
+// with the same key
+const proofSigningDomain = "fabric-shard-prepare-proof"
+
+// ProofSignature is the signature of one replica over the tuple a proof attests
+type ProofSignature struct {
+	ReplicaID uint64
+	Signature []byte
+}
+
+// ProofDigest returns the bytes the replicas sign for the proof of txID
+// prepared on the shard at the given commit index and term. The strings are
+// length prefixed so that distinct tuples never encode alike.
+func ProofDigest(shardID, txID string, commitIndex, term uint64) []byte {
+	buf := make([]byte, 0, len(proofSigningDomain)+len(shardID)+len(txID)+5*8)
+	for _, s := range []string{proofSigningDomain, shardID, txID} {
+		buf = binary.BigEndian.AppendUint64(buf, uint64(len(s)))
+		buf = append(buf, s...)
+	}
+	buf = binary.BigEndian.AppendUint64(buf, commitIndex)
+	return binary.BigEndian.AppendUint64(buf, term)
+}
+
+// LoadProofSigningKey reads a PEM encoded PKCS #8 ed25519 private key
+func LoadProofSigningKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key: %v", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block in signing key %s", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signing key %s: %v", path, err)
+	}
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("signing key %s is a %T, not an ed25519 key", path, key)
+	}
+	return edKey, nil
+}
+
+// ProofVerifierConfig lists the identities trusted to sign proofs
+type ProofVerifierConfig struct {
+	// Replicas maps replica IDs to PEM files holding either the X.509
+	// certificate or the PKIX public key of the replica's ed25519 signing
+	// key. Relative paths are resolved against the directory of the config.
+	Replicas map[string]string
+	// Quorum is the number of co-signatures each proof must carry. Proofs
+	// need no quorum certificate when it is 0.
+	Quorum int
+}
+
+// NewProofVerifierFromEnv returns the verifier configured via
+// ProofVerifierConfigEnvVar, or nil when none is configured
+func NewProofVerifierFromEnv() (*ProofVerifier, error) {
+	path := os.Getenv(ProofVerifierConfigEnvVar)
+	if path == "" {
+		return nil, nil
+	}
+	return LoadProofVerifier(path)
+}
+
+// LoadProofVerifier reads a ProofVerifierConfig from path and loads the
+// replica keys it lists
+func LoadProofVerifier(path string) (*ProofVerifier, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read proof verifier config: %v", err)
+	}
+	config := &ProofVerifierConfig{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("failed to parse proof verifier config %s: %v", path, err)
+	}
+	if len(config.Replicas) == 0 {
+		return nil, fmt.Errorf("proof verifier config %s lists no replicas", path)
+	}
+	if config.Quorum < 0 || config.Quorum > len(config.Replicas) {
+		return nil, fmt.Errorf("quorum %d of proof verifier config %s is out of range", config.Quorum, path)
+	}
+
+	keys := make(map[uint64]ed25519.PublicKey, len(config.Replicas))
+	for idStr, keyPath := range config.Replicas {
+		id, err := strconv.ParseUint(idStr, 10, 64)
+		if err != nil || id == 0 {
+			return nil, fmt.Errorf("invalid replica ID %q in proof verifier config %s", idStr, path)
+		}
+		if !filepath.IsAbs(keyPath) {
+			keyPath = filepath.Join(filepath.Dir(path), keyPath)
+		}
+		key, err := loadReplicaPublicKey(keyPath)
+		if err != nil {
+			return nil, fmt.Errorf("replica %d: %v", id, err)
+		}
+		keys[id] = key
+	}
+	return NewProofVerifier(keys, config.Quorum), nil
+}
+
+// loadReplicaPublicKey reads the ed25519 public key from a PEM encoded X.509
+// certificate or PKIX public key
+func loadReplicaPublicKey(path string) (ed25519.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read public key: %v", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block in %s", path)
+	}
+
+	var key interface{}
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate %s: %v", path, err)
+		}
+		key = cert.PublicKey
+	case "PUBLIC KEY":
+		if key, err = x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+			return nil, fmt.Errorf("failed to parse public key %s: %v", path, err)
+		}
+	default:
+		return nil, fmt.Errorf("unexpected PEM block %q in %s", block.Type, path)
+	}
+
+	edKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("key in %s is a %T, not an ed25519 key", path, key)
+	}
+	return edKey, nil
+}
+
+// signProof signs the proof tuple with the replica's key and returns the
+// signature along with the ID of the signer. Without a key the proof carries
+// the unkeyed digest and signer 0.
+func (sl *ShardLeader) signProof(txID string, commitIndex, term uint64) ([]byte, uint64) {
+	if sl.config.SigningKey == nil {
+		return signPrepareProof(sl.shardID, commitIndex, txID), 0
+	}
+	return ed25519.Sign(sl.config.SigningKey, ProofDigest(sl.shardID, txID, commitIndex, term)), sl.config.ReplicaID
+}
+
+// signPrepareProof creates the unkeyed proof signature for a transaction
+// prepared on a shard
+func signPrepareProof(shardID string, commitIndex uint64, txID string) []byte {
+	data := fmt.Sprintf("%s:%d:%s", shardID, commitIndex, txID)
+	return []byte(data)
+}
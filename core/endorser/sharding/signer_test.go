@@ -0,0 +1,188 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestProofDigestSeparatesFields(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	digest := ProofDigest("fabcar", "tx1", 7, 2)
+	gt.Expect(digest).To(Equal(ProofDigest("fabcar", "tx1", 7, 2)))
+	gt.Expect(digest).NotTo(Equal(ProofDigest("fabca", "rtx1", 7, 2)))
+	gt.Expect(digest).NotTo(Equal(ProofDigest("fabcar", "tx1", 7, 3)))
+	gt.Expect(digest).NotTo(Equal(ProofDigest("fabcar", "tx1", 8, 2)))
+}
+
+func TestLoadProofSigningKey(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	gt.Expect(err).NotTo(HaveOccurred())
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	gt.Expect(err).NotTo(HaveOccurred())
+
+	path := filepath.Join(t.TempDir(), "key.pem")
+	gt.Expect(os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600)).To(Succeed())
+	loaded, err := LoadProofSigningKey(path)
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(loaded).To(Equal(key))
+
+	gt.Expect(os.WriteFile(path, []byte("not a key"), 0o600)).To(Succeed())
+	_, err = LoadProofSigningKey(path)
+	gt.Expect(err).To(MatchError(ContainSubstring("no PEM block")))
+}
+
+func TestProofVerifier(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	keys := make(map[uint64]ed25519.PublicKey)
+	privs := make(map[uint64]ed25519.PrivateKey)
+	for id := uint64(1); id <= 3; id++ {
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		gt.Expect(err).NotTo(HaveOccurred())
+		keys[id], privs[id] = pub, priv
+	}
+
+	sl := &ShardLeader{shardID: "fabcar", config: ShardConfig{ReplicaID: 1, SigningKey: privs[1]}}
+	proof := &PrepareProof{TxID: "tx1", ShardID: "fabcar", CommitIndex: 7, Term: 2}
+	proof.Signature, proof.SignerID = sl.signProof("tx1", 7, 2)
+	gt.Expect(proof.SignerID).To(Equal(uint64(1)))
+	gt.Expect(VerifyPrepareProof(proof)).To(Succeed())
+
+	gt.Expect(NewProofVerifier(keys, 0).VerifyShardProof(proof)).To(Succeed())
+	gt.Expect(NewProofVerifier(map[uint64]ed25519.PublicKey{2: keys[2]}, 0).VerifyShardProof(proof)).To(
+		MatchError(ContainSubstring("signed by unknown replica 1")))
+
+	tampered := *proof
+	tampered.CommitIndex = 8
+	gt.Expect(NewProofVerifier(keys, 0).VerifyShardProof(&tampered)).To(MatchError(ContainSubstring("invalid signature of replica 1")))
+
+	// A quorum of two distinct replicas must co-sign
+	digest := ProofDigest("fabcar", "tx1", 7, 2)
+	proof.QuorumCert = []ProofSignature{
+		{ReplicaID: 1, Signature: ed25519.Sign(privs[1], digest)},
+		{ReplicaID: 1, Signature: ed25519.Sign(privs[1], digest)},
+	}
+	gt.Expect(NewProofVerifier(keys, 2).VerifyShardProof(proof)).To(MatchError(ContainSubstring("carries 1 valid co-signatures, 2 required")))
+	proof.QuorumCert[1] = ProofSignature{ReplicaID: 3, Signature: ed25519.Sign(privs[3], digest)}
+	gt.Expect(NewProofVerifier(keys, 2).VerifyShardProof(proof)).To(Succeed())
+
+	// Unkeyed proofs still pass the structural check only
+	unkeyed := &ShardLeader{shardID: "fabcar", config: ShardConfig{ReplicaID: 1}}
+	proof = &PrepareProof{TxID: "tx1", ShardID: "fabcar", CommitIndex: 7, Term: 2}
+	proof.Signature, proof.SignerID = unkeyed.signProof("tx1", 7, 2)
+	gt.Expect(proof.SignerID).To(BeZero())
+	gt.Expect(VerifyPrepareProof(proof)).To(Succeed())
+	gt.Expect(NewProofVerifier(keys, 0).VerifyShardProof(proof)).To(HaveOccurred())
+}
+
+func TestShardProofCarriesQuorumCert(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	peers := PeerConfig{1: freeTransportAddress(t), 2: freeTransportAddress(t), 3: freeTransportAddress(t)}
+	keys := make(map[uint64]ed25519.PublicKey)
+	shards := make(map[uint64]*ShardLeader)
+	for id := range peers {
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		gt.Expect(err).NotTo(HaveOccurred())
+		keys[id] = pub
+
+		transport := NewTransport(id, peers[id], peers)
+		gt.Expect(transport.Start()).To(Succeed())
+		defer transport.Stop()
+
+		sl, err := NewShardLeader(ShardConfig{
+			ShardID:    "fabcar",
+			ReplicaIDs: []uint64{1, 2, 3},
+			ReplicaID:  id,
+			SigningKey: priv,
+			QuorumCert: true,
+		}, DefaultBatchTimeout, DefaultBatchMaxSize)
+		gt.Expect(err).NotTo(HaveOccurred())
+		defer sl.Stop()
+		transport.RegisterShard("fabcar", sl)
+		shards[id] = sl
+	}
+
+	gt.Eventually(func() uint64 { return shards[1].GetStatus().LeaderID }, 30*time.Second, 100*time.Millisecond).ShouldNot(BeZero())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	proof, err := shards[1].ProposeAndWait(ctx, &PrepareRequest{
+		TxID:     "tx1",
+		ShardID:  "fabcar",
+		WriteSet: map[string][]byte{"car1": []byte("v1")},
+	})
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(proof.SignerID).NotTo(BeZero())
+	gt.Expect(proof.QuorumCert).To(HaveLen(2))
+	gt.Expect(NewProofVerifier(keys, 2).VerifyShardProof(proof)).To(Succeed())
+
+	// The cached proof handed to other subscribers is left without the certificate
+	shards[1].proofCacheLock.RLock()
+	gt.Expect(shards[1].proofCache["tx1"].QuorumCert).To(BeEmpty())
+	shards[1].proofCacheLock.RUnlock()
+
+	// Replicas refuse to co-sign a proof they never applied
+	_, err = shards[2].coSign(ctx, "tx2", proof.CommitIndex, proof.Term)
+	gt.Expect(err).To(MatchError(ContainSubstring("holds no proof of tx tx2")))
+}
+
+func TestLoadProofVerifier(t *testing.T) {
+	gt := NewGomegaWithT(t)
+	dir := t.TempDir()
+
+	// Replica 1 is listed by its certificate, replica 2 by its public key
+	pub1, priv1, err := ed25519.GenerateKey(rand.Reader)
+	gt.Expect(err).NotTo(HaveOccurred())
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), NotAfter: time.Now().Add(time.Hour)}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, pub1, priv1)
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(os.WriteFile(filepath.Join(dir, "replica1.pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), 0o644)).To(Succeed())
+
+	pub2, _, err := ed25519.GenerateKey(rand.Reader)
+	gt.Expect(err).NotTo(HaveOccurred())
+	pubDER, err := x509.MarshalPKIXPublicKey(pub2)
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(os.WriteFile(filepath.Join(dir, "replica2.pem"), pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}), 0o644)).To(Succeed())
+
+	configPath := filepath.Join(dir, "keys.json")
+	gt.Expect(os.WriteFile(configPath, []byte(`{"Replicas": {"1": "replica1.pem", "2": "replica2.pem"}, "Quorum": 1}`), 0o644)).To(Succeed())
+
+	defer os.Unsetenv(ProofVerifierConfigEnvVar)
+	verifier, err := NewProofVerifierFromEnv()
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(verifier).To(BeNil())
+
+	os.Setenv(ProofVerifierConfigEnvVar, configPath)
+	verifier, err = NewProofVerifierFromEnv()
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(verifier.keys).To(Equal(map[uint64]ed25519.PublicKey{1: pub1, 2: pub2}))
+	gt.Expect(verifier.quorum).To(Equal(1))
+
+	gt.Expect(os.WriteFile(configPath, []byte(`{"Replicas": {"1": "replica1.pem"}, "Quorum": 2}`), 0o644)).To(Succeed())
+	_, err = LoadProofVerifier(configPath)
+	gt.Expect(err).To(MatchError(ContainSubstring("quorum 2 of proof verifier config")))
+
+	gt.Expect(os.WriteFile(configPath, []byte(`{"Replicas": {"one": "replica1.pem"}}`), 0o644)).To(Succeed())
+	_, err = LoadProofVerifier(configPath)
+	gt.Expect(err).To(MatchError(ContainSubstring(`invalid replica ID "one"`)))
+}
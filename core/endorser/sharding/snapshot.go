@@ -0,0 +1,104 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"encoding/json"
+	"sync/atomic"
+
+	"go.etcd.io/etcd/raft/v3"
+	"go.etcd.io/etcd/raft/v3/raftpb"
+)
+
+const (
+	// DefaultSnapshotInterval is the number of applied entries between snapshots
+	DefaultSnapshotInterval = uint64(10000)
+	// DefaultSnapshotCatchUpEntries is the number of entries kept in memory
+	// after a snapshot so that slightly lagging followers can catch up from
+	// the log instead of a snapshot
+	DefaultSnapshotCatchUpEntries = uint64(500)
+)
+
+// maybeSnapshot snapshots the dependency map once SnapshotInterval entries
+// were applied since the last snapshot and compacts the in-memory log. It
+// must only be called from runApply after a batch of entries is applied.
+func (sl *ShardLeader) maybeSnapshot() {
+	applied := atomic.LoadUint64(&sl.appliedIndex)
+	if applied-sl.snapshotIndex < sl.snapshotInterval {
+		return
+	}
+
+	data, err := json.Marshal(sl.captureBackup())
+	if err != nil {
+		logger.Errorf("Shard %s: Failed to marshal snapshot at index %d: %v", sl.shardID, applied, err)
+		return
+	}
+	snapshot, err := sl.storage.CreateSnapshot(applied, &sl.confState, data)
+	if err == raft.ErrSnapOutOfDate {
+		// runRaft already installed a newer snapshot from the leader
+		return
+	}
+	if err != nil {
+		logger.Errorf("Shard %s: Failed to create snapshot at index %d: %v", sl.shardID, applied, err)
+		return
+	}
+	if sl.wal != nil {
+		if err := sl.wal.saveSnap(snapshot); err != nil {
+			logger.Panicf("Shard %s: Failed to persist snapshot at index %d: %v", sl.shardID, applied, err)
+		}
+	}
+	sl.snapshotIndex = applied
+
+	if applied > sl.snapshotCatchUpEntries {
+		compactIndex := applied - sl.snapshotCatchUpEntries
+		if err := sl.storage.Compact(compactIndex); err != nil && err != raft.ErrCompacted {
+			logger.Errorf("Shard %s: Failed to compact log to index %d: %v", sl.shardID, compactIndex, err)
+		}
+	}
+
+	logger.Infof("Shard %s: Snapshot taken at index %d", sl.shardID, applied)
+}
+
+// restoreSnapshot replaces the dependency map with the state in the snapshot
+func (sl *ShardLeader) restoreSnapshot(snapshot raftpb.Snapshot) error {
+	state := &ShardBackup{}
+	if err := json.Unmarshal(snapshot.Data, state); err != nil {
+		return err
+	}
+	if state.Dependencies == nil {
+		state.Dependencies = make(map[string]TransactionDependencyInfo)
+	}
+
+	// A persistent state store may already be ahead of the snapshot
+	sl.variableMapLock.Lock()
+	if snapshot.Metadata.Index > sl.variableMap.Index() {
+		if err := sl.variableMap.Reset(state.Dependencies, state.AppliedProofs, snapshot.Metadata.Index); err != nil {
+			sl.variableMapLock.Unlock()
+			return err
+		}
+		sl.loadAppliedTxs()
+	}
+	sl.variableMapLock.Unlock()
+	sl.restoreRanges(state.RangeReservations)
+
+	sl.confState = snapshot.Metadata.ConfState
+	sl.mu.Lock()
+	sl.members = sl.confState
+	sl.peerAddrs = make(PeerConfig, len(state.Peers))
+	for id, addr := range state.Peers {
+		sl.peerAddrs[id] = addr
+	}
+	sl.decisions = state.Decisions
+	sl.mu.Unlock()
+	sl.notifyPeers()
+	sl.snapshotIndex = snapshot.Metadata.Index
+	sl.commitIndex = snapshot.Metadata.Index
+	atomic.StoreUint64(&sl.appliedIndex, snapshot.Metadata.Index)
+
+	logger.Infof("Shard %s: Restored %d keys from snapshot at index %d", sl.shardID, len(state.Dependencies), snapshot.Metadata.Index)
+	return nil
+}
@@ -0,0 +1,68 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"sync/atomic"
+)
+
+// ShardStatus is a point-in-time view of a shard replica and its Raft group
+type ShardStatus struct {
+	ShardID   string
+	ReplicaID uint64
+	// LeaderID is 0 while no leader is known to this replica
+	LeaderID     uint64
+	IsLeader     bool
+	RaftState    string
+	Term         uint64
+	CommitIndex  uint64
+	AppliedIndex uint64
+	// QueueDepth counts the prepare requests waiting to be proposed
+	QueueDepth int
+	Voters     []uint64
+	Learners   []uint64
+	// Dependencies counts the reservations the replica held at its last
+	// GC check
+	Dependencies int
+	// ExpiredDependencies counts the dependencies pruned by GC
+	ExpiredDependencies uint64
+	// EvictedDependencies counts the reservations evicted before they
+	// expired to respect MaxDependencies
+	EvictedDependencies uint64
+	// DuplicateProposals counts the retried proposals answered with the
+	// proof of an applied transaction
+	DuplicateProposals uint64
+}
+
+// GetStatus returns the current state of the replica
+func (sl *ShardLeader) GetStatus() ShardStatus {
+	status := sl.node.Status()
+	membership := sl.Membership()
+
+	sl.batchLock.Lock()
+	queued := len(sl.batchQueue)
+	sl.batchLock.Unlock()
+
+	return ShardStatus{
+		ShardID:      sl.shardID,
+		ReplicaID:    sl.config.ReplicaID,
+		LeaderID:     status.Lead,
+		IsLeader:     status.Lead != 0 && status.Lead == status.ID,
+		RaftState:    status.RaftState.String(),
+		Term:         status.Term,
+		CommitIndex:  status.Commit,
+		AppliedIndex: atomic.LoadUint64(&sl.appliedIndex),
+		QueueDepth:   queued + len(sl.proposeC),
+		Voters:       membership.Voters,
+		Learners:     membership.Learners,
+
+		Dependencies:        int(atomic.LoadInt64(&sl.dependencies)),
+		ExpiredDependencies: sl.ExpiredDependencies(),
+		EvictedDependencies: sl.EvictedDependencies(),
+		DuplicateProposals:  sl.DuplicateProposals(),
+	}
+}
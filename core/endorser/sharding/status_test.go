@@ -0,0 +1,97 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestShardLeaderGetStatus(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	sl, err := NewShardLeader(ShardConfig{
+		ShardID:    "fabcar",
+		ReplicaIDs: []uint64{1},
+		ReplicaID:  1,
+	}, time.Hour, DefaultBatchMaxSize)
+	gt.Expect(err).NotTo(HaveOccurred())
+	defer sl.Stop()
+
+	status := sl.GetStatus()
+	gt.Expect(status.ShardID).To(Equal("fabcar"))
+	gt.Expect(status.ReplicaID).To(Equal(uint64(1)))
+
+	// A single replica campaigns only after its election timeout
+	gt.Eventually(func() bool { return sl.GetStatus().IsLeader }, 30*time.Second, 100*time.Millisecond).Should(BeTrue())
+
+	status = sl.GetStatus()
+	gt.Expect(status.LeaderID).To(Equal(uint64(1)))
+	gt.Expect(status.RaftState).To(Equal("StateLeader"))
+	gt.Expect(status.Term).To(BeNumerically(">", 0))
+	gt.Expect(status.Voters).To(Equal([]uint64{1}))
+	gt.Expect(status.Learners).To(BeEmpty())
+	gt.Eventually(func() uint64 { return sl.GetStatus().AppliedIndex }).Should(Equal(sl.GetStatus().CommitIndex))
+
+	// With an hour-long batch timeout the request waits in the queue
+	sl.ProposeC() <- &PrepareRequest{TxID: "tx1", ShardID: "fabcar", WriteSet: map[string][]byte{"car1": []byte("v1")}}
+	gt.Eventually(func() int { return sl.GetStatus().QueueDepth }).Should(Equal(1))
+}
+
+func TestShardLeaderMetrics(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	sl, err := NewShardLeader(ShardConfig{
+		ShardID:    "fabcar",
+		ReplicaIDs: []uint64{1},
+		ReplicaID:  1,
+	}, DefaultBatchTimeout, DefaultBatchMaxSize)
+	gt.Expect(err).NotTo(HaveOccurred())
+	defer sl.Stop()
+	gt.Eventually(func() bool { return sl.GetStatus().IsLeader }, 30*time.Second, 100*time.Millisecond).Should(BeTrue())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, txID := range []string{"tx1", "tx2"} {
+		_, err := sl.ProposeAndWait(ctx, &PrepareRequest{TxID: txID, ShardID: "fabcar", WriteSet: map[string][]byte{txID: []byte("v")}})
+		gt.Expect(err).NotTo(HaveOccurred())
+	}
+	_, err = sl.AbortAndWait(ctx, "tx1")
+	gt.Expect(err).NotTo(HaveOccurred())
+
+	metrics := sl.Metrics()
+	gt.Expect(metrics.RequestsHandled).To(Equal(uint64(2)))
+	gt.Expect(metrics.Aborts).To(Equal(uint64(1)))
+	gt.Expect(metrics.BatchSizes.Count).To(Equal(uint64(2)))
+	gt.Expect(metrics.BatchSizes.Sum).To(Equal(float64(2)))
+	gt.Expect(metrics.CommitLatency.P50).To(BeNumerically(">", 0))
+	gt.Expect(metrics.CommitLatency.P99).To(BeNumerically(">=", metrics.CommitLatency.P50))
+	gt.Expect(metrics.QueueDepth).To(BeZero())
+	gt.Expect(metrics.IsLeader).To(BeTrue())
+	gt.Expect(metrics.LeaderID).To(Equal(uint64(1)))
+	gt.Expect(metrics.Term).To(BeNumerically(">", 0))
+}
+
+func TestHistogramSnapshotQuantile(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	h := newLatencyHistogram([]float64{1, 2, 4})
+	gt.Expect(h.snapshot().Quantile(0.5)).To(BeZero())
+
+	for _, v := range []float64{0.5, 1.5, 1.5, 3, 10} {
+		h.observeValue(v)
+	}
+	snapshot := h.snapshot()
+	gt.Expect(snapshot.Quantile(0.2)).To(Equal(1.0))
+	gt.Expect(snapshot.Quantile(0.4)).To(Equal(1.5))
+	gt.Expect(snapshot.Quantile(0.7)).To(Equal(3.0))
+	// values beyond the last bucket are reported as its bound
+	gt.Expect(snapshot.Quantile(0.99)).To(Equal(4.0))
+}
@@ -0,0 +1,109 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// lockStripes is the number of stripes the keys of a dependency table are
+// spread over
+const lockStripes = 64
+
+// stripeOf returns the stripe of key, an FNV-1a hash of the key modulo
+// lockStripes
+func stripeOf(key string) int {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return int(h % lockStripes)
+}
+
+// stripedLock guards a dependency table by stripes of keys. LockKey and
+// RLockKey guard the reservation of a single key, so that reads of one key
+// neither wait for reads nor for writes of keys in other stripes. Lock and
+// RLock take every stripe, for operations over the whole table. The zero
+// value is unlocked.
+type stripedLock struct {
+	stripes [lockStripes]sync.RWMutex
+	// contended counts the stripe acquisitions that had to wait for another
+	// holder (accessed atomically)
+	contended uint64
+}
+
+// Lock locks every stripe for writing
+func (l *stripedLock) Lock() {
+	for i := range l.stripes {
+		l.lock(i)
+	}
+}
+
+// Unlock unlocks every stripe locked by Lock
+func (l *stripedLock) Unlock() {
+	for i := range l.stripes {
+		l.stripes[i].Unlock()
+	}
+}
+
+// RLock locks every stripe for reading
+func (l *stripedLock) RLock() {
+	for i := range l.stripes {
+		l.rlock(i)
+	}
+}
+
+// RUnlock unlocks every stripe locked by RLock
+func (l *stripedLock) RUnlock() {
+	for i := range l.stripes {
+		l.stripes[i].RUnlock()
+	}
+}
+
+// LockKey locks the stripe of key for writing. The caller must not hold any
+// other stripe.
+func (l *stripedLock) LockKey(key string) {
+	l.lock(stripeOf(key))
+}
+
+// UnlockKey unlocks the stripe locked by LockKey
+func (l *stripedLock) UnlockKey(key string) {
+	l.stripes[stripeOf(key)].Unlock()
+}
+
+// RLockKey locks the stripe of key for reading. The caller must not hold any
+// other stripe.
+func (l *stripedLock) RLockKey(key string) {
+	l.rlock(stripeOf(key))
+}
+
+// RUnlockKey unlocks the stripe locked by RLockKey
+func (l *stripedLock) RUnlockKey(key string) {
+	l.stripes[stripeOf(key)].RUnlock()
+}
+
+// Contention returns the number of stripe acquisitions that waited for
+// another holder
+func (l *stripedLock) Contention() uint64 {
+	return atomic.LoadUint64(&l.contended)
+}
+
+func (l *stripedLock) lock(i int) {
+	if !l.stripes[i].TryLock() {
+		atomic.AddUint64(&l.contended, 1)
+		l.stripes[i].Lock()
+	}
+}
+
+func (l *stripedLock) rlock(i int) {
+	if !l.stripes[i].TryRLock() {
+		atomic.AddUint64(&l.contended, 1)
+		l.stripes[i].RLock()
+	}
+}
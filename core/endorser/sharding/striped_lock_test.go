@@ -0,0 +1,105 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestStripeOf(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	stripes := map[int]bool{}
+	for i := 0; i < 1000; i++ {
+		stripe := stripeOf(fmt.Sprintf("car%d", i))
+		gt.Expect(stripe).To(BeNumerically(">=", 0))
+		gt.Expect(stripe).To(BeNumerically("<", lockStripes))
+		stripes[stripe] = true
+	}
+	gt.Expect(stripes).To(HaveLen(lockStripes))
+	gt.Expect(stripeOf("car1")).To(Equal(stripeOf("car1")))
+}
+
+func TestStripedLock(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	// find two keys in different stripes
+	a, b := "car0", ""
+	for i := 1; stripeOf(b) == stripeOf(a) || b == ""; i++ {
+		b = fmt.Sprintf("car%d", i)
+	}
+
+	var l stripedLock
+	l.LockKey(a)
+
+	// keys of other stripes are not blocked by a writer
+	l.RLockKey(b)
+	l.RUnlockKey(b)
+	gt.Expect(l.Contention()).To(BeZero())
+
+	// a reader of the same key, or of the whole table, waits for the writer
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		l.RLockKey(a)
+		l.RUnlockKey(a)
+	}()
+	go func() {
+		defer wg.Done()
+		l.RLock()
+		l.RUnlock()
+	}()
+	gt.Eventually(l.Contention, time.Second, 10*time.Millisecond).Should(Equal(uint64(2)))
+	l.UnlockKey(a)
+	wg.Wait()
+
+	l.Lock()
+	l.Unlock()
+	gt.Expect(l.Contention()).To(Equal(uint64(2)))
+}
+
+func TestMemoryDependencyTableStripes(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	var l stripedLock
+	table := newMemoryDependencyTable()
+
+	// writes and reads of distinct keys run concurrently
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				key := fmt.Sprintf("car%d-%d", i, j)
+				l.LockKey(key)
+				table.Put(key, TransactionDependencyInfo{DependentTxID: key})
+				l.UnlockKey(key)
+
+				l.RLockKey(key)
+				info, ok := table.Get(key)
+				l.RUnlockKey(key)
+				gt.Expect(ok).To(BeTrue())
+				gt.Expect(info.DependentTxID).To(Equal(key))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	count := 0
+	table.Range(func(key string, info TransactionDependencyInfo) {
+		gt.Expect(info.DependentTxID).To(Equal(key))
+		count++
+	})
+	gt.Expect(count).To(Equal(800))
+}
@@ -0,0 +1,299 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// ShardTopologyEnvVar is the path of a ShardTopology file describing the
+// replicas of every shard. When it is unset, the replicas are read from
+// sharding.json in the working directory, if present, and otherwise default
+// to three replicas on localhost.
+const ShardTopologyEnvVar = "FABRIC_SHARD_TOPOLOGY"
+
+// legacyShardingConfigPath is the file mapping contracts to the addresses of
+// their replicas that predates ShardTopology
+const legacyShardingConfigPath = "sharding.json"
+
+// ReplicaSet lists the replicas of a shard
+type ReplicaSet struct {
+	// Replicas maps the Raft IDs of the replicas to their peer addresses
+	Replicas map[uint64]string
+	// ReplicaID is the ID of this peer among Replicas. When it is 0, the
+	// ReplicaID of the topology is used if it is one of Replicas, and
+	// otherwise the replica whose address is that of this peer.
+	ReplicaID uint64 `json:",omitempty"`
+}
+
+// ShardTopology maps contracts to the replicas of their shards. A replica
+// ID names the same peer in every set it appears in.
+type ShardTopology struct {
+	// ReplicaID is the ID of this peer on the shard transport
+	ReplicaID uint64 `json:",omitempty"`
+	// Contracts maps contracts, or the IDs of individual partitions of a
+	// contract, to their replicas. Either may be scoped to a channel, as
+	// channel/contract, to replicate its shards on that channel only.
+	Contracts map[string]ReplicaSet
+	// Channels maps channels to the replicas of the shards of their
+	// contracts that are not listed for the channel in Contracts
+	Channels map[string]ReplicaSet `json:",omitempty"`
+	// Default is the template for contracts that are not listed. Only
+	// listed contracts are replicated when it is nil.
+	Default *ReplicaSet `json:",omitempty"`
+	// ServerNames overrides, per replica ID, the name expected in the TLS
+	// certificate of the replica when the transport uses TLS
+	ServerNames map[uint64]string `json:",omitempty"`
+}
+
+// DefaultShardTopology replicates every contract on three peers on localhost
+func DefaultShardTopology() *ShardTopology {
+	return &ShardTopology{Default: defaultReplicaSet()}
+}
+
+func defaultReplicaSet() *ReplicaSet {
+	return &ReplicaSet{Replicas: map[uint64]string{1: "localhost:7051", 2: "localhost:7052", 3: "localhost:7053"}}
+}
+
+// LoadShardTopologyFromEnv returns the topology configured via
+// ShardTopologyEnvVar, falling back to sharding.json and then to
+// DefaultShardTopology
+func LoadShardTopologyFromEnv() (*ShardTopology, error) {
+	if path := os.Getenv(ShardTopologyEnvVar); path != "" {
+		return LoadShardTopology(path)
+	}
+	config, err := loadShardingConfig(legacyShardingConfigPath)
+	if os.IsNotExist(err) {
+		return DefaultShardTopology(), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s: %v", legacyShardingConfigPath, err)
+	}
+	return legacyShardTopology(config, advertisedPeerAddress()), nil
+}
+
+// LoadShardTopology reads and validates a ShardTopology from path
+func LoadShardTopology(path string) (*ShardTopology, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read shard topology: %v", err)
+	}
+	topology := &ShardTopology{}
+	if err := json.Unmarshal(data, topology); err != nil {
+		return nil, fmt.Errorf("failed to parse shard topology %s: %v", path, err)
+	}
+	if err := topology.validate(); err != nil {
+		return nil, fmt.Errorf("invalid shard topology %s: %v", path, err)
+	}
+	return topology, nil
+}
+
+// loadShardingConfig reads a legacy sharding config mapping contracts to the
+// addresses of their replicas
+func loadShardingConfig(path string) (map[string][]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var config map[string][]string
+	if err := json.NewDecoder(file).Decode(&config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// legacyShardTopology converts a legacy sharding config. Replica IDs are
+// assigned by the sorted order of all addresses in the config, so that every
+// peer derives the same IDs.
+func legacyShardTopology(config map[string][]string, myAddr string) *ShardTopology {
+	seen := make(map[string]bool)
+	var addrs []string
+	for _, replicas := range config {
+		for _, addr := range replicas {
+			if !seen[addr] {
+				seen[addr] = true
+				addrs = append(addrs, addr)
+			}
+		}
+	}
+	sort.Strings(addrs)
+
+	ids := make(map[string]uint64, len(addrs))
+	topology := &ShardTopology{Contracts: make(map[string]ReplicaSet, len(config))}
+	for i, addr := range addrs {
+		ids[addr] = uint64(i + 1)
+		if addr == myAddr {
+			topology.ReplicaID = uint64(i + 1)
+		}
+	}
+	for contract, replicas := range config {
+		set := ReplicaSet{Replicas: make(map[uint64]string, len(replicas))}
+		for _, addr := range replicas {
+			set.Replicas[ids[addr]] = addr
+		}
+		topology.Contracts[contract] = set
+	}
+	return topology
+}
+
+func (t *ShardTopology) validate() error {
+	addrs := make(map[uint64]string)
+	check := func(name string, set *ReplicaSet) error {
+		if len(set.Replicas) == 0 {
+			return fmt.Errorf("%s lists no replicas", name)
+		}
+		for id, addr := range set.Replicas {
+			if id == 0 {
+				return fmt.Errorf("%s uses the reserved replica ID 0", name)
+			}
+			if addr == "" {
+				return fmt.Errorf("replica %d of %s has no address", id, name)
+			}
+			if prev, ok := addrs[id]; ok && prev != addr {
+				return fmt.Errorf("replica %d is at both %s and %s", id, prev, addr)
+			}
+			addrs[id] = addr
+		}
+		if _, ok := set.Replicas[set.ReplicaID]; set.ReplicaID != 0 && !ok {
+			return fmt.Errorf("replica %d of %s is not one of its replicas", set.ReplicaID, name)
+		}
+		return nil
+	}
+
+	contracts := make([]string, 0, len(t.Contracts))
+	for contract := range t.Contracts {
+		contracts = append(contracts, contract)
+	}
+	sort.Strings(contracts)
+	for _, contract := range contracts {
+		set := t.Contracts[contract]
+		if err := check("contract "+contract, &set); err != nil {
+			return err
+		}
+	}
+	channels := make([]string, 0, len(t.Channels))
+	for channel := range t.Channels {
+		channels = append(channels, channel)
+	}
+	sort.Strings(channels)
+	for _, channel := range channels {
+		set := t.Channels[channel]
+		if err := check("channel "+channel, &set); err != nil {
+			return err
+		}
+	}
+	if t.Default != nil {
+		if err := check("the default replica set", t.Default); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReplicaSet returns the replicas of the shard. A partition of a contract
+// that is not listed on its own is replicated by the replicas of the
+// contract, and an unlisted contract by the default set. The entries scoped
+// to the channel of the shard, then its channel, take precedence over those
+// shared by every channel.
+func (t *ShardTopology) ReplicaSet(shardID string) (ReplicaSet, bool) {
+	if set, ok := t.Contracts[shardID]; ok {
+		return set, true
+	}
+	channel, contract := ChannelOfShard(shardID), ContractOfShard(shardID)
+	if channel != "" {
+		if set, ok := t.Contracts[ChannelShardID(channel, contract)]; ok {
+			return set, true
+		}
+		if set, ok := t.Channels[channel]; ok {
+			return set, true
+		}
+		if set, ok := t.Contracts[UnscopedShardID(shardID)]; ok {
+			return set, true
+		}
+	}
+	if set, ok := t.Contracts[contract]; ok {
+		return set, true
+	}
+	if t.Default != nil {
+		return *t.Default, true
+	}
+	return ReplicaSet{}, false
+}
+
+// Peers returns the addresses of every replica in the topology by ID
+func (t *ShardTopology) Peers() PeerConfig {
+	peers := make(PeerConfig)
+	for _, set := range t.Contracts {
+		for id, addr := range set.Replicas {
+			peers[id] = addr
+		}
+	}
+	for _, set := range t.Channels {
+		for id, addr := range set.Replicas {
+			peers[id] = addr
+		}
+	}
+	if t.Default != nil {
+		for id, addr := range t.Default.Replicas {
+			peers[id] = addr
+		}
+	}
+	return peers
+}
+
+// LocalReplicaID returns the ID of the peer at myAddr on the shard
+// transport, or 0 if it is not part of the topology
+func (t *ShardTopology) LocalReplicaID(myAddr string) uint64 {
+	if t.ReplicaID != 0 {
+		return t.ReplicaID
+	}
+	return replicaAt(t.Peers(), myAddr)
+}
+
+// localReplica returns the ID of the peer at myAddr among the replicas of the
+// set, if it is one of them
+func (t *ShardTopology) localReplica(set ReplicaSet, myAddr string) (uint64, bool) {
+	if set.ReplicaID != 0 {
+		return set.ReplicaID, true
+	}
+	if _, ok := set.Replicas[t.ReplicaID]; ok && t.ReplicaID != 0 {
+		return t.ReplicaID, true
+	}
+	id := replicaAt(set.Replicas, myAddr)
+	return id, id != 0
+}
+
+// replicaAt returns the lowest ID of the replicas at addr, or 0 if none is
+func replicaAt(replicas map[uint64]string, addr string) uint64 {
+	var found uint64
+	for id, a := range replicas {
+		if a == addr && (found == 0 || id < found) {
+			found = id
+		}
+	}
+	return found
+}
+
+// sortedReplicas returns the IDs of the replicas in ascending order together
+// with their addresses
+func (set ReplicaSet) sortedReplicas() ([]uint64, []string) {
+	ids := make([]uint64, 0, len(set.Replicas))
+	for id := range set.Replicas {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	addrs := make([]string, len(ids))
+	for i, id := range ids {
+		addrs[i] = set.Replicas[id]
+	}
+	return ids, addrs
+}
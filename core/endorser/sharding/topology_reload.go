@@ -0,0 +1,163 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ShardTopologyReloadEnvVar is how often, as a Go duration, the ShardManager
+// checks the topology file for changes and reloads it. The topology is only
+// reloaded through Reload when it is unset.
+const ShardTopologyReloadEnvVar = "FABRIC_SHARD_TOPOLOGY_RELOAD"
+
+// topologyReloadFromEnv returns the interval configured via
+// ShardTopologyReloadEnvVar, or 0 when the file is not watched
+func topologyReloadFromEnv() time.Duration {
+	value := strings.TrimSpace(os.Getenv(ShardTopologyReloadEnvVar))
+	if value == "" {
+		return 0
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil || interval <= 0 {
+		logger.Warningf("Ignoring invalid %s %q", ShardTopologyReloadEnvVar, value)
+		return 0
+	}
+	return interval
+}
+
+// topologyPath returns the file the topology is loaded from
+func (sm *ShardManager) topologyPath() string {
+	if sm.topologyFile != "" {
+		return sm.topologyFile
+	}
+	if path := os.Getenv(ShardTopologyEnvVar); path != "" {
+		return path
+	}
+	return legacyShardingConfigPath
+}
+
+// Topology returns the topology the shards of this peer are configured from
+func (sm *ShardManager) Topology() *ShardTopology {
+	sm.topologyLock.RLock()
+	defer sm.topologyLock.RUnlock()
+	return sm.topology
+}
+
+// Reload loads the topology from the TopologyFile of the options, or the one
+// configured via ShardTopologyEnvVar, again and applies it with SetTopology.
+// The current topology is kept when the new one fails to load.
+func (sm *ShardManager) Reload() error {
+	var topology *ShardTopology
+	var err error
+	if sm.topologyFile != "" {
+		topology, err = LoadShardTopology(sm.topologyFile)
+	} else {
+		topology, err = LoadShardTopologyFromEnv()
+	}
+	if err != nil {
+		return err
+	}
+	sm.SetTopology(topology)
+	return nil
+}
+
+// SetTopology replaces the topology of the manager. Shards created from now
+// on use the new replica sets, and the transport dials replicas at their new
+// addresses. The membership of running shards is left to the membership API,
+// since it has to change through their Raft logs; differences are logged.
+func (sm *ShardManager) SetTopology(topology *ShardTopology) {
+	sm.topologyLock.Lock()
+	sm.topology = topology
+	sm.topologyLock.Unlock()
+
+	globalTransportLock.Lock()
+	if globalTransport != nil {
+		globalTransport.UpdatePeers(topology.Peers())
+		if id := topology.LocalReplicaID(advertisedPeerAddress()); id != 0 && id != globalTransport.nodeID {
+			logger.Warningf("The shard topology names this peer replica %d, but the transport runs as replica %d until restart", id, globalTransport.nodeID)
+		}
+	}
+	globalTransportLock.Unlock()
+
+	sm.shardsLock.RLock()
+	for shardID, shard := range sm.shards {
+		set, ok := topology.ReplicaSet(shardID)
+		if !ok {
+			logger.Warningf("Shard %s is no longer part of the shard topology", shardID)
+			continue
+		}
+		configured, _ := set.sortedReplicas()
+		voters := shard.Replicas()
+		sort.Slice(voters, func(i, j int) bool { return voters[i] < voters[j] })
+		if !reflect.DeepEqual(configured, voters) {
+			logger.Warningf("Shard %s has replicas %v, but the shard topology lists %v", shardID, voters, configured)
+		}
+	}
+	sm.shardsLock.RUnlock()
+
+	logger.Infof("Applied shard topology with %d contracts", len(topology.Contracts))
+}
+
+// runTopologyReload reloads the topology whenever its file changes
+func (sm *ShardManager) runTopologyReload(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := topologyFileVersion(sm.topologyPath())
+	for {
+		select {
+		case <-ticker.C:
+			version := topologyFileVersion(sm.topologyPath())
+			if version == last {
+				continue
+			}
+			last = version
+			if err := sm.Reload(); err != nil {
+				logger.Errorf("Failed to reload shard topology: %v", err)
+			}
+		case <-sm.stopC:
+			return
+		}
+	}
+}
+
+// fileVersion identifies the contents of a file by its size and
+// modification time, which are zero when it does not exist
+type fileVersion struct {
+	size    int64
+	modTime time.Time
+}
+
+func topologyFileVersion(path string) fileVersion {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileVersion{}
+	}
+	return fileVersion{size: info.Size(), modTime: info.ModTime()}
+}
+
+// handleReload serves POST /admin/reload
+func (sm *ShardManager) handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := sm.Reload(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sm.Topology())
+}
@@ -0,0 +1,285 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func writeTopology(gt *GomegaWithT, dir, contents string) string {
+	path := filepath.Join(dir, "topology.json")
+	gt.Expect(ioutil.WriteFile(path, []byte(contents), 0o644)).To(Succeed())
+	return path
+}
+
+func TestLoadShardTopology(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	dir, err := ioutil.TempDir("", "shard-topology")
+	gt.Expect(err).NotTo(HaveOccurred())
+	defer os.RemoveAll(dir)
+
+	path := writeTopology(gt, dir, `{
+		"ReplicaID": 4,
+		"Contracts": {
+			"fabcar": {"Replicas": {"1": "host1:7051", "2": "host2:7051", "4": "host4:7051"}},
+			"marbles#1": {"Replicas": {"3": "host3:7051"}, "ReplicaID": 3}
+		},
+		"Default": {"Replicas": {"1": "host1:7051", "2": "host2:7051", "3": "host3:7051"}}
+	}`)
+	topology, err := LoadShardTopology(path)
+	gt.Expect(err).NotTo(HaveOccurred())
+
+	set, ok := topology.ReplicaSet("fabcar#2")
+	gt.Expect(ok).To(BeTrue())
+	ids, addrs := set.sortedReplicas()
+	gt.Expect(ids).To(Equal([]uint64{1, 2, 4}))
+	gt.Expect(addrs).To(Equal([]string{"host1:7051", "host2:7051", "host4:7051"}))
+	id, ok := topology.localReplica(set, "localhost:7051")
+	gt.Expect(ok).To(BeTrue())
+	gt.Expect(id).To(Equal(uint64(4)))
+
+	set, _ = topology.ReplicaSet("marbles#1")
+	id, ok = topology.localReplica(set, "host4:7051")
+	gt.Expect(ok).To(BeTrue())
+	gt.Expect(id).To(Equal(uint64(3)))
+
+	// unlisted contracts use the default template, without this peer
+	set, ok = topology.ReplicaSet("supply")
+	gt.Expect(ok).To(BeTrue())
+	gt.Expect(set.Replicas).To(HaveLen(3))
+	_, ok = topology.localReplica(set, "host4:7051")
+	gt.Expect(ok).To(BeFalse())
+
+	gt.Expect(topology.Peers()).To(Equal(PeerConfig{1: "host1:7051", 2: "host2:7051", 3: "host3:7051", 4: "host4:7051"}))
+	gt.Expect(topology.LocalReplicaID("host1:7051")).To(Equal(uint64(4)))
+
+	// the entries of a channel take precedence over those of every channel
+	path = writeTopology(gt, dir, `{
+		"Contracts": {
+			"fabcar": {"Replicas": {"1": "host1:7051"}},
+			"marbles#1": {"Replicas": {"1": "host1:7051"}},
+			"audit/fabcar": {"Replicas": {"2": "host2:7051"}}
+		},
+		"Channels": {"audit": {"Replicas": {"3": "host3:7051"}}},
+		"Default": {"Replicas": {"4": "host4:7051"}}
+	}`)
+	topology, err = LoadShardTopology(path)
+	gt.Expect(err).NotTo(HaveOccurred())
+	for shardID, replica := range map[string]uint64{
+		"fabcar":              1,
+		"mychannel/fabcar":    1,
+		"audit/fabcar#2":      2,
+		"audit/marbles#1":     3,
+		"mychannel/marbles#1": 1,
+		"mychannel/supply":    4,
+	} {
+		set, ok := topology.ReplicaSet(shardID)
+		gt.Expect(ok).To(BeTrue())
+		gt.Expect(set.Replicas).To(HaveKey(replica), "shard %s", shardID)
+	}
+	gt.Expect(topology.Peers()).To(HaveLen(4))
+
+	for contents, msg := range map[string]string{
+		`{"Contracts": {"fabcar": {"Replicas": {}}}}`:                                                     "contract fabcar lists no replicas",
+		`{"Contracts": {"fabcar": {"Replicas": {"0": "host1:7051"}}}}`:                                    "contract fabcar uses the reserved replica ID 0",
+		`{"Contracts": {"fabcar": {"Replicas": {"1": "host1:7051"}, "ReplicaID": 2}}}`:                    "replica 2 of contract fabcar is not one of its replicas",
+		`{"Contracts": {"a": {"Replicas": {"1": "host1:7051"}}, "b": {"Replicas": {"1": "host2:7051"}}}}`: "replica 1 is at both host1:7051 and host2:7051",
+		`{"Contracts": {}, "Channels": {"audit": {"Replicas": {}}}}`:                                      "channel audit lists no replicas",
+	} {
+		_, err := LoadShardTopology(writeTopology(gt, dir, contents))
+		gt.Expect(err).To(MatchError(ContainSubstring(msg)))
+	}
+}
+
+func TestLoadShardTopologyFromEnv(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	dir, err := ioutil.TempDir("", "shard-topology")
+	gt.Expect(err).NotTo(HaveOccurred())
+	defer os.RemoveAll(dir)
+
+	wd, err := os.Getwd()
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(os.Chdir(dir)).To(Succeed())
+	defer os.Chdir(wd)
+	defer os.Unsetenv(ShardTopologyEnvVar)
+	defer os.Unsetenv("CORE_PEER_ADDRESS")
+
+	os.Setenv("CORE_PEER_ADDRESS", "host2:7051")
+	topology, err := LoadShardTopologyFromEnv()
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(topology).To(Equal(DefaultShardTopology()))
+
+	// sharding.json assigns IDs by the sorted order of all addresses
+	gt.Expect(ioutil.WriteFile(legacyShardingConfigPath, []byte(`{"fabcar": ["host3:7051", "host2:7051"], "marbles": ["host1:7051"]}`), 0o644)).To(Succeed())
+	topology, err = LoadShardTopologyFromEnv()
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(topology.ReplicaID).To(Equal(uint64(2)))
+	gt.Expect(topology.Contracts["fabcar"].Replicas).To(Equal(map[uint64]string{2: "host2:7051", 3: "host3:7051"}))
+	gt.Expect(topology.Default).To(BeNil())
+
+	sm := &ShardManager{topology: topology}
+	gt.Expect(sm.IsReplica("fabcar")).To(BeTrue())
+	gt.Expect(sm.IsReplica("marbles")).To(BeFalse())
+	gt.Expect(sm.IsReplica("supply")).To(BeFalse())
+	config := sm.shardConfig("fabcar")
+	gt.Expect(config.ReplicaIDs).To(Equal([]uint64{2, 3}))
+	gt.Expect(config.ReplicaNodes).To(Equal([]string{"host2:7051", "host3:7051"}))
+	gt.Expect(config.ReplicaID).To(Equal(uint64(2)))
+
+	// the topology file takes precedence over sharding.json
+	os.Setenv(ShardTopologyEnvVar, writeTopology(gt, dir, `{"Default": {"Replicas": {"7": "host7:7051"}, "ReplicaID": 7}}`))
+	topology, err = LoadShardTopologyFromEnv()
+	gt.Expect(err).NotTo(HaveOccurred())
+	sm = &ShardManager{topology: topology}
+	gt.Expect(sm.IsReplica("fabcar")).To(BeTrue())
+	config = sm.shardConfig("fabcar")
+	gt.Expect(config.ReplicaIDs).To(Equal([]uint64{7}))
+	gt.Expect(config.ReplicaID).To(Equal(uint64(7)))
+
+	os.Setenv(ShardTopologyEnvVar, filepath.Join(dir, "missing.json"))
+	_, err = LoadShardTopologyFromEnv()
+	gt.Expect(err).To(MatchError(ContainSubstring("failed to read shard topology")))
+}
+
+func TestAdvertisedPeerAddress(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	// The peer listens on the address of its container, but the topology
+	// lists the address mapped to it on the host
+	t.Setenv("CORE_PEER_ADDRESS", "peer0:7051")
+	topology := &ShardTopology{Contracts: map[string]ReplicaSet{
+		"fabcar": {Replicas: map[uint64]string{1: "host1:9051", 2: "host2:9051"}},
+	}}
+	sm := &ShardManager{topology: topology}
+	gt.Expect(sm.IsReplica("fabcar")).To(BeFalse())
+
+	t.Setenv(ShardAdvertisedAddressEnvVar, "host2:9051")
+	gt.Expect(advertisedPeerAddress()).To(Equal("host2:9051"))
+	gt.Expect(localPeerAddress()).To(Equal("peer0:7051"))
+	gt.Expect(sm.IsReplica("fabcar")).To(BeTrue())
+	gt.Expect(sm.shardConfig("fabcar").ReplicaID).To(Equal(uint64(2)))
+
+	transport := NewTransport(2, localPeerAddress(), topology.Peers())
+	gt.Expect(transport.AdvertisedAddress()).To(Equal("host2:9051"))
+	transport = NewTransportWithConfig(2, localPeerAddress(), topology.Peers(), TransportConfig{})
+	gt.Expect(transport.AdvertisedAddress()).To(Equal("peer0:7051"))
+}
+
+func TestShardManagerReload(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	dir, err := ioutil.TempDir("", "shard-topology")
+	gt.Expect(err).NotTo(HaveOccurred())
+	defer os.RemoveAll(dir)
+	defer os.Unsetenv(ShardTopologyEnvVar)
+
+	os.Setenv(ShardTopologyEnvVar, writeTopology(gt, dir, `{"ReplicaID": 1, "Contracts": {"fabcar": {"Replicas": {"1": "host1:7051"}}}}`))
+	topology, err := LoadShardTopologyFromEnv()
+	gt.Expect(err).NotTo(HaveOccurred())
+	sm := &ShardManager{shards: make(map[string]*ShardLeader), topology: topology, stopC: make(chan struct{})}
+	gt.Expect(sm.IsReplica("supply")).To(BeFalse())
+
+	// new contracts and addresses apply to shards created after the reload
+	writeTopology(gt, dir, `{"ReplicaID": 1, "Contracts": {"fabcar": {"Replicas": {"1": "host1:7051"}}, "supply": {"Replicas": {"1": "host1:7051", "2": "host2:8051"}}}}`)
+	gt.Expect(sm.Reload()).To(Succeed())
+	gt.Expect(sm.IsReplica("supply")).To(BeTrue())
+	config := sm.shardConfig("supply")
+	gt.Expect(config.ReplicaIDs).To(Equal([]uint64{1, 2}))
+	gt.Expect(config.ReplicaNodes).To(Equal([]string{"host1:7051", "host2:8051"}))
+
+	// an invalid topology leaves the current one in place
+	writeTopology(gt, dir, `{"Contracts": {"fabcar": {"Replicas": {}}}}`)
+	gt.Expect(sm.Reload()).To(MatchError(ContainSubstring("contract fabcar lists no replicas")))
+	gt.Expect(sm.IsReplica("supply")).To(BeTrue())
+
+	rec := httptest.NewRecorder()
+	sm.handleReload(rec, httptest.NewRequest(http.MethodGet, "/admin/reload", nil))
+	gt.Expect(rec.Code).To(Equal(http.StatusMethodNotAllowed))
+	rec = httptest.NewRecorder()
+	sm.handleReload(rec, httptest.NewRequest(http.MethodPost, "/admin/reload", nil))
+	gt.Expect(rec.Code).To(Equal(http.StatusInternalServerError))
+
+	writeTopology(gt, dir, `{"ReplicaID": 1, "Contracts": {"marbles": {"Replicas": {"1": "host1:7051"}}}}`)
+	rec = httptest.NewRecorder()
+	sm.handleReload(rec, httptest.NewRequest(http.MethodPost, "/admin/reload", nil))
+	gt.Expect(rec.Code).To(Equal(http.StatusOK))
+	gt.Expect(rec.Body.String()).To(ContainSubstring(`"marbles"`))
+	gt.Expect(sm.IsReplica("supply")).To(BeFalse())
+}
+
+func TestShardManagerOptions(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	dir := t.TempDir()
+	t.Setenv(ShardTopologyEnvVar, writeTopology(gt, dir, `{"ReplicaID": 1, "Contracts": {"fabcar": {"Replicas": {"1": "host1:7051"}}}}`))
+
+	// The file of the options takes precedence over the variable
+	path := filepath.Join(dir, "core-topology.json")
+	gt.Expect(ioutil.WriteFile(path, []byte(`{"ReplicaID": 1, "Contracts": {"supply": {"Replicas": {"1": "host1:7051"}}}}`), 0o644)).To(Succeed())
+	sm := &ShardManager{shards: make(map[string]*ShardLeader), topology: DefaultShardTopology(), stopC: make(chan struct{}), topologyFile: path, dependencyTTL: time.Minute}
+	gt.Expect(sm.topologyPath()).To(Equal(path))
+	gt.Expect(sm.Reload()).To(Succeed())
+	gt.Expect(sm.IsReplica("supply")).To(BeTrue())
+	gt.Expect(sm.IsReplica("fabcar")).To(BeFalse())
+	gt.Expect(sm.shardConfig("supply").DependencyTTL).To(Equal(time.Minute))
+}
+
+func TestShardManagerWatchesTopology(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	dir, err := ioutil.TempDir("", "shard-topology")
+	gt.Expect(err).NotTo(HaveOccurred())
+	defer os.RemoveAll(dir)
+	defer os.Unsetenv(ShardTopologyEnvVar)
+
+	os.Setenv(ShardTopologyEnvVar, writeTopology(gt, dir, `{"ReplicaID": 1, "Contracts": {"fabcar": {"Replicas": {"1": "host1:7051"}}}}`))
+	topology, err := LoadShardTopologyFromEnv()
+	gt.Expect(err).NotTo(HaveOccurred())
+	sm := &ShardManager{shards: make(map[string]*ShardLeader), topology: topology, stopC: make(chan struct{})}
+	defer close(sm.stopC)
+	go sm.runTopologyReload(10 * time.Millisecond)
+
+	time.Sleep(50 * time.Millisecond)
+	writeTopology(gt, dir, `{"ReplicaID": 1, "Contracts": {"fabcar": {"Replicas": {"1": "host1:7051"}}, "supply": {"Replicas": {"1": "host1:7051"}}}}`)
+	gt.Eventually(func() bool { return sm.IsReplica("supply") }, 5*time.Second, 10*time.Millisecond).Should(BeTrue())
+}
+
+func TestShardManagerChannels(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	topology := &ShardTopology{
+		ReplicaID: 1,
+		Contracts: map[string]ReplicaSet{"fabcar": {Replicas: map[uint64]string{1: "host1:7051", 2: "host2:7051"}}},
+		Channels:  map[string]ReplicaSet{"audit": {Replicas: map[uint64]string{2: "host2:7051", 3: "host3:7051"}}},
+	}
+	sm := &ShardManager{shards: make(map[string]*ShardLeader), topology: topology, partitioner: &KeyPartitioner{Default: 1, Partitions: map[string]int{"marbles": 2}}}
+
+	// the same contract on two channels has a shard on each
+	gt.Expect(sm.ShardForKey("mychannel", "fabcar", "car1")).To(Equal("mychannel/fabcar"))
+	gt.Expect(sm.ShardForKey("audit", "fabcar", "car1")).To(Equal("audit/fabcar"))
+	gt.Expect(sm.ShardForKey("", "fabcar", "car1")).To(Equal("fabcar"))
+	gt.Expect(sm.ShardsOfContract("mychannel", "marbles")).To(Equal([]string{"mychannel/marbles#0", "mychannel/marbles#1"}))
+
+	// and each channel may replicate it on its own peers
+	config := sm.shardConfig("mychannel/fabcar")
+	gt.Expect(config.ShardID).To(Equal("mychannel/fabcar"))
+	gt.Expect(config.ReplicaIDs).To(Equal([]uint64{1, 2}))
+	config = sm.shardConfig("audit/fabcar")
+	gt.Expect(config.ReplicaIDs).To(Equal([]uint64{2, 3}))
+	gt.Expect(sm.IsReplica("mychannel/fabcar")).To(BeTrue())
+	gt.Expect(sm.IsReplica("audit/fabcar")).To(BeFalse())
+}
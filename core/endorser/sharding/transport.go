@@ -0,0 +1,38 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+// TransportInterface carries the traffic between the replicas of the shards
+// a node hosts: the Raft messages a ShardLeader emits on MessagesC, batches
+// forwarded to the leader and proofs to be co-signed. Transport implements
+// it over gRPC, InProcessTransport between nodes of the same process.
+type TransportInterface interface {
+	// RegisterShard routes the messages of the shard leader to the other
+	// replicas, and makes the leader use the transport to reach them
+	RegisterShard(shardID string, leader *ShardLeader)
+	// UnregisterShard stops routing messages to and from the shard
+	UnregisterShard(shardID string)
+	// Stop stops the transport
+	Stop()
+
+	proposalForwarder
+	proofCoSigner
+	peerUpdater
+}
+
+var (
+	_ TransportInterface = (*Transport)(nil)
+	_ TransportInterface = (*InProcessTransport)(nil)
+)
+
+// setTransport makes the shard leader forward batches, collect co-signatures
+// and report membership changes through the transport
+func (sl *ShardLeader) setTransport(transport TransportInterface) {
+	sl.setForwarder(transport)
+	sl.setCoSigner(transport)
+	sl.setPeerUpdater(transport)
+}
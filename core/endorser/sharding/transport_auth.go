@@ -0,0 +1,238 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/endorser/sharding/protos"
+	"go.etcd.io/etcd/raft/v3/raftpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// ShardAuthTokenEnvVar is a secret shared by all replicas. When it is
+	// set, the transport signs its requests with it and only serves requests
+	// signed with it.
+	ShardAuthTokenEnvVar = "FABRIC_SHARD_AUTH_TOKEN"
+
+	// authTokenMaxSkew bounds how old a signed request may be, and how far
+	// the clocks of two replicas may drift apart
+	authTokenMaxSkew = time.Minute
+
+	nodeIDMetadataKey    = "shard-node-id"
+	authTimeMetadataKey  = "shard-auth-time"
+	authTokenMetadataKey = "shard-auth-token"
+)
+
+// authTokenFromEnv returns the shared secret of the replicas, or nil when
+// requests are not signed
+func authTokenFromEnv() []byte {
+	if token := strings.TrimSpace(os.Getenv(ShardAuthTokenEnvVar)); token != "" {
+		return []byte(token)
+	}
+	return nil
+}
+
+// UseAuthToken makes the transport sign its requests with the secret shared
+// by the replicas and reject requests that are not signed with it. It must
+// be called before Start.
+func (t *Transport) UseAuthToken(secret []byte) {
+	t.authSecret = secret
+}
+
+// authRequired reports whether callers have to prove which replica they are
+func (t *Transport) authRequired() bool {
+	return len(t.authSecret) > 0 || (t.tls != nil && t.tls.RequireClientCert)
+}
+
+// signToken returns the token proving that nodeID sent a request at the
+// given Unix time
+func signToken(secret []byte, nodeID uint64, unix int64) string {
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "%d:%d", nodeID, unix)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// callerCredentials attaches the ID of the calling replica, and a token when
+// a secret is configured, to every request
+type callerCredentials struct {
+	nodeID uint64
+	secret []byte
+}
+
+func (c callerCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	md := map[string]string{nodeIDMetadataKey: strconv.FormatUint(c.nodeID, 10)}
+	if len(c.secret) > 0 {
+		now := time.Now().Unix()
+		md[authTimeMetadataKey] = strconv.FormatInt(now, 10)
+		md[authTokenMetadataKey] = signToken(c.secret, c.nodeID, now)
+	}
+	return md, nil
+}
+
+// RequireTransportSecurity is false, since the token is bound to its time
+// and replicas without TLS must still identify themselves
+func (c callerCredentials) RequireTransportSecurity() bool {
+	return false
+}
+
+var _ credentials.PerRPCCredentials = callerCredentials{}
+
+// authenticatedNodeKey is the context key of the ID of the authenticated
+// caller
+type authenticatedNodeKey struct{}
+
+// authenticatedNode returns the ID of the replica that sent the request, if
+// the transport authenticated it
+func authenticatedNode(ctx context.Context) (uint64, bool) {
+	nodeID, ok := ctx.Value(authenticatedNodeKey{}).(uint64)
+	return nodeID, ok
+}
+
+// authenticate returns the ID of the known replica that sent the request. The
+// replica proves its identity with a token signed with the shared secret,
+// with a client certificate issued for its name, or with both when both are
+// configured.
+func (t *Transport) authenticate(ctx context.Context) (uint64, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(nodeIDMetadataKey)
+	if len(values) == 0 {
+		return 0, status.Error(codes.Unauthenticated, "missing caller node ID")
+	}
+	nodeID, err := strconv.ParseUint(values[0], 10, 64)
+	if err != nil || nodeID == 0 {
+		return 0, status.Errorf(codes.Unauthenticated, "invalid caller node ID %q", values[0])
+	}
+
+	t.mu.RLock()
+	addr, known := t.peers[nodeID]
+	t.mu.RUnlock()
+	if !known {
+		return 0, status.Errorf(codes.PermissionDenied, "node %d is not a known peer", nodeID)
+	}
+
+	if len(t.authSecret) > 0 {
+		if err := t.verifyToken(md, nodeID); err != nil {
+			return 0, err
+		}
+	}
+	if t.tls != nil && t.tls.RequireClientCert {
+		if err := t.verifyClientCert(ctx, nodeID, addr); err != nil {
+			return 0, err
+		}
+	}
+	return nodeID, nil
+}
+
+func (t *Transport) verifyToken(md metadata.MD, nodeID uint64) error {
+	times, tokens := md.Get(authTimeMetadataKey), md.Get(authTokenMetadataKey)
+	if len(times) == 0 || len(tokens) == 0 {
+		return status.Errorf(codes.Unauthenticated, "missing auth token of node %d", nodeID)
+	}
+	unix, err := strconv.ParseInt(times[0], 10, 64)
+	if err != nil {
+		return status.Errorf(codes.Unauthenticated, "invalid auth time %q of node %d", times[0], nodeID)
+	}
+	if skew := time.Since(time.Unix(unix, 0)); skew > authTokenMaxSkew || skew < -authTokenMaxSkew {
+		return status.Errorf(codes.Unauthenticated, "auth token of node %d is %s off", nodeID, skew.Round(time.Second))
+	}
+	if !hmac.Equal([]byte(tokens[0]), []byte(signToken(t.authSecret, nodeID, unix))) {
+		return status.Errorf(codes.PermissionDenied, "invalid auth token of node %d", nodeID)
+	}
+	return nil
+}
+
+// verifyClientCert checks that the verified client certificate is issued for
+// the name of the replica, as its server certificate is
+func (t *Transport) verifyClientCert(ctx context.Context, nodeID uint64, addr string) error {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return status.Error(codes.Unauthenticated, "missing peer information")
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.VerifiedChains) == 0 || len(tlsInfo.State.VerifiedChains[0]) == 0 {
+		return status.Errorf(codes.Unauthenticated, "node %d presented no verified certificate", nodeID)
+	}
+
+	name := t.tls.ServerNames[nodeID]
+	if name == "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		name = host
+	}
+	if err := tlsInfo.State.VerifiedChains[0][0].VerifyHostname(name); err != nil {
+		return status.Errorf(codes.PermissionDenied, "certificate does not identify node %d: %v", nodeID, err)
+	}
+	return nil
+}
+
+// isShardCommunication reports whether the method belongs to the
+// ShardCommunication service, whose callers are replicas
+func isShardCommunication(fullMethod string) bool {
+	return strings.HasPrefix(fullMethod, "/"+protos.ShardCommunication_ServiceDesc.ServiceName+"/")
+}
+
+// unaryAuthInterceptor authenticates the callers of ShardCommunication
+func (t *Transport) unaryAuthInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if !t.authRequired() || !isShardCommunication(info.FullMethod) {
+		return handler(ctx, req)
+	}
+	nodeID, err := t.authenticate(ctx)
+	if err != nil {
+		logger.Warningf("Rejecting %s: %v", info.FullMethod, err)
+		return nil, err
+	}
+	return handler(context.WithValue(ctx, authenticatedNodeKey{}, nodeID), req)
+}
+
+// streamAuthInterceptor authenticates the callers of ShardCommunication
+func (t *Transport) streamAuthInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if !t.authRequired() || !isShardCommunication(info.FullMethod) {
+		return handler(srv, ss)
+	}
+	nodeID, err := t.authenticate(ss.Context())
+	if err != nil {
+		logger.Warningf("Rejecting %s: %v", info.FullMethod, err)
+		return err
+	}
+	return handler(srv, &authenticatedStream{ServerStream: ss, ctx: context.WithValue(ss.Context(), authenticatedNodeKey{}, nodeID)})
+}
+
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
+
+// checkSender rejects messages that an authenticated caller sent on behalf
+// of another replica
+func checkSender(ctx context.Context, msg raftpb.Message) error {
+	if nodeID, ok := authenticatedNode(ctx); ok && msg.From != nodeID {
+		return fmt.Errorf("node %d may not send messages from node %d", nodeID, msg.From)
+	}
+	return nil
+}
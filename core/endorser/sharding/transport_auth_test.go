@@ -0,0 +1,147 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/common/crypto/tlsgen"
+	"github.com/hyperledger/fabric/core/endorser/sharding/protos"
+	. "github.com/onsi/gomega"
+	"go.etcd.io/etcd/raft/v3/raftpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// stepHeartbeat sends a heartbeat from the given node to node 2 directly,
+// without the sender's send queue
+func stepHeartbeat(sender *Transport, from uint64) (*protos.StepResponse, error) {
+	client, err := sender.getClient(2)
+	if err != nil {
+		return nil, err
+	}
+	data, err := (&raftpb.Message{Type: raftpb.MsgHeartbeat, From: from, To: 2, Term: 5}).Marshal()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ctx = metadata.AppendToOutgoingContext(ctx, "shard-id", "fabcar")
+	return client.Step(ctx, &protos.RaftMessageProto{Data: data})
+}
+
+func TestTransportAuthToken(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	peers := PeerConfig{1: freeTransportAddress(t), 2: freeTransportAddress(t), 3: freeTransportAddress(t)}
+	receiver := NewTransport(2, peers[2], peers)
+	receiver.UseAuthToken([]byte("secret"))
+	gt.Expect(receiver.Start()).To(Succeed())
+	defer receiver.Stop()
+
+	follower, err := NewShardLeader(ShardConfig{
+		ShardID:    "fabcar",
+		ReplicaIDs: []uint64{1, 2, 3},
+		ReplicaID:  2,
+	}, DefaultBatchTimeout, DefaultBatchMaxSize)
+	gt.Expect(err).NotTo(HaveOccurred())
+	defer follower.Stop()
+	receiver.RegisterShard("fabcar", follower)
+
+	newSender := func(nodeID uint64, secret string) *Transport {
+		sender := NewTransport(nodeID, peers[nodeID], peers)
+		if secret != "" {
+			sender.UseAuthToken([]byte(secret))
+		}
+		return sender
+	}
+
+	sender := newSender(1, "secret")
+	defer sender.Stop()
+	gt.Eventually(func() error {
+		_, err := stepHeartbeat(sender, 1)
+		return err
+	}, 10*time.Second, 100*time.Millisecond).Should(Succeed())
+	gt.Eventually(func() uint64 { return follower.GetStatus().LeaderID }, 5*time.Second).Should(Equal(uint64(1)))
+
+	// An authenticated replica cannot speak for another one
+	resp, err := stepHeartbeat(sender, 3)
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(resp.Error).To(Equal("node 1 may not send messages from node 3"))
+
+	wrongSecret := newSender(3, "guess")
+	defer wrongSecret.Stop()
+	_, err = stepHeartbeat(wrongSecret, 3)
+	gt.Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+	gt.Expect(err).To(MatchError(ContainSubstring("invalid auth token of node 3")))
+
+	noSecret := newSender(3, "")
+	defer noSecret.Stop()
+	_, err = stepHeartbeat(noSecret, 3)
+	gt.Expect(status.Code(err)).To(Equal(codes.Unauthenticated))
+
+	// The secret does not make up for an unknown node ID
+	unknown := NewTransport(4, freeTransportAddress(t), peers)
+	unknown.UseAuthToken([]byte("secret"))
+	defer unknown.Stop()
+	_, err = stepHeartbeat(unknown, 4)
+	gt.Expect(err).To(MatchError(ContainSubstring("node 4 is not a known peer")))
+
+	// Stale tokens are refused, so that captured ones cannot be replayed
+	stale := time.Now().Add(-2 * authTokenMaxSkew).Unix()
+	err = receiver.verifyToken(metadata.Pairs(
+		authTimeMetadataKey, strconv.FormatInt(stale, 10),
+		authTokenMetadataKey, signToken([]byte("secret"), 1, stale),
+	), 1)
+	gt.Expect(status.Code(err)).To(Equal(codes.Unauthenticated))
+}
+
+func TestTransportAuthClientCert(t *testing.T) {
+	gt := NewGomegaWithT(t)
+	dir := t.TempDir()
+
+	ca, err := tlsgen.NewCA()
+	gt.Expect(err).NotTo(HaveOccurred())
+
+	peers := PeerConfig{1: freeTransportAddress(t), 2: freeTransportAddress(t)}
+	receiverTLS := newTestTransportTLS(gt, dir, "receiver", ca, "127.0.0.1")
+	receiverTLS.RequireClientCert = true
+	receiver := NewTransport(2, peers[2], peers)
+	receiver.UseTLS(receiverTLS)
+	gt.Expect(receiver.Start()).To(Succeed())
+	defer receiver.Stop()
+
+	follower, err := NewShardLeader(ShardConfig{
+		ShardID:    "fabcar",
+		ReplicaIDs: []uint64{1, 2},
+		ReplicaID:  2,
+	}, DefaultBatchTimeout, DefaultBatchMaxSize)
+	gt.Expect(err).NotTo(HaveOccurred())
+	defer follower.Stop()
+	receiver.RegisterShard("fabcar", follower)
+
+	// The certificate of node 1 names the host of its address
+	sender := NewTransport(1, peers[1], peers)
+	sender.UseTLS(newTestTransportTLS(gt, dir, "sender", ca, "127.0.0.1"))
+	defer sender.Stop()
+	gt.Eventually(func() error {
+		_, err := stepHeartbeat(sender, 1)
+		return err
+	}, 10*time.Second, 100*time.Millisecond).Should(Succeed())
+
+	// A certificate of the same CA issued to another host does not
+	impostor := NewTransport(1, peers[1], peers)
+	impostor.UseTLS(newTestTransportTLS(gt, dir, "impostor", ca, "other.example.com"))
+	defer impostor.Stop()
+	_, err = stepHeartbeat(impostor, 1)
+	gt.Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+	gt.Expect(err).To(MatchError(ContainSubstring("certificate does not identify node 1")))
+}
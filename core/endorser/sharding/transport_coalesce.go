@@ -0,0 +1,67 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"time"
+
+	"go.etcd.io/etcd/raft/v3/raftpb"
+)
+
+const (
+	// maxCoalescedMessages bounds the messages collected in one window and
+	// the messages a peer's sender takes from its queue at once
+	maxCoalescedMessages = 1024
+	// maxCoalescedBytes bounds the size of one Step request, well below the
+	// transport's message size limit; a larger message is sent on its own
+	maxCoalescedBytes = 2 << 20
+)
+
+// collectMessages adds the messages the shard emits within the coalescing
+// window to msgs
+func (t *Transport) collectMessages(leader *ShardLeader, msgs []raftpb.Message) []raftpb.Message {
+	if t.config.CoalesceWindow <= 0 {
+		return msgs
+	}
+
+	batch := append([]raftpb.Message(nil), msgs...)
+	timer := time.NewTimer(t.config.CoalesceWindow)
+	defer timer.Stop()
+	for len(batch) < maxCoalescedMessages {
+		select {
+		case more := <-leader.MessagesC():
+			batch = append(batch, more...)
+		case <-timer.C:
+			return batch
+		case <-leader.stopC:
+			return batch
+		case <-t.stopC:
+			return batch
+		}
+	}
+	return batch
+}
+
+// sendBatch sends snapshots on their own and queues the other messages for
+// their recipients, whose senders coalesce them into Step requests
+func (t *Transport) sendBatch(shardID string, msgs []raftpb.Message) {
+	var peers []uint64
+	pending := make(map[uint64][]raftpb.Message)
+	for _, msg := range msgs {
+		if msg.Type == raftpb.MsgSnap {
+			go t.send(shardID, msg)
+			continue
+		}
+		if _, ok := pending[msg.To]; !ok {
+			peers = append(peers, msg.To)
+		}
+		pending[msg.To] = append(pending[msg.To], msg)
+	}
+	for _, to := range peers {
+		t.enqueue(shardID, to, pending[to])
+	}
+}
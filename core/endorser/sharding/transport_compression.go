@@ -0,0 +1,124 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"compress/gzip"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/golang/snappy"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// ShardCompressionEnvVar selects the compression of the requests the
+	// shard transport sends: gzip, snappy or none
+	ShardCompressionEnvVar = "FABRIC_SHARD_COMPRESSION"
+
+	CompressionNone   = ""
+	CompressionGzip   = "gzip"
+	CompressionSnappy = "snappy"
+)
+
+// Every transport can decompress requests with either compressor, whatever
+// it sends with itself
+func init() {
+	encoding.RegisterCompressor(&gzipCompressor{})
+	encoding.RegisterCompressor(snappyCompressor{})
+}
+
+// validCompression reports whether the transport can compress with name
+func validCompression(name string) bool {
+	switch name {
+	case CompressionNone, CompressionGzip, CompressionSnappy:
+		return true
+	default:
+		return false
+	}
+}
+
+// gzipCompressor pools its writers, whose allocation dominates the cost of
+// compressing small Raft messages
+type gzipCompressor struct {
+	writers sync.Pool
+}
+
+type pooledGzipWriter struct {
+	*gzip.Writer
+	pool *sync.Pool
+}
+
+func (w *pooledGzipWriter) Close() error {
+	defer w.pool.Put(w)
+	return w.Writer.Close()
+}
+
+func (c *gzipCompressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	if pooled, ok := c.writers.Get().(*pooledGzipWriter); ok {
+		pooled.Reset(w)
+		return pooled, nil
+	}
+	return &pooledGzipWriter{Writer: gzip.NewWriter(w), pool: &c.writers}, nil
+}
+
+func (c *gzipCompressor) Decompress(r io.Reader) (io.Reader, error) {
+	return gzip.NewReader(r)
+}
+
+func (c *gzipCompressor) Name() string {
+	return CompressionGzip
+}
+
+type snappyCompressor struct{}
+
+func (snappyCompressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	return snappy.NewBufferedWriter(w), nil
+}
+
+func (snappyCompressor) Decompress(r io.Reader) (io.Reader, error) {
+	return snappy.NewReader(r), nil
+}
+
+func (snappyCompressor) Name() string {
+	return CompressionSnappy
+}
+
+// isCompressionUnsupported reports whether the peer rejected a request
+// because it cannot decompress it
+func isCompressionUnsupported(err error) bool {
+	return status.Code(err) == codes.Unimplemented && strings.Contains(status.Convert(err).Message(), "Decompressor is not installed")
+}
+
+// compressionFor returns the compressor to send to the peer with. Peers that
+// rejected compressed requests are sent plain ones.
+func (t *Transport) compressionFor(nodeID uint64) string {
+	t.healthMu.Lock()
+	defer t.healthMu.Unlock()
+	if health, ok := t.health[nodeID]; ok && health.uncompressed {
+		return CompressionNone
+	}
+	return t.config.Compression
+}
+
+// disableCompression makes the transport send plain requests to a peer that
+// cannot decompress them, such as a peer running an older release
+func (t *Transport) disableCompression(nodeID uint64) {
+	t.healthMu.Lock()
+	health := t.healthLocked(nodeID)
+	already := health.uncompressed
+	health.uncompressed = true
+	t.healthMu.Unlock()
+
+	if !already {
+		logger.Warningf("Node %d does not accept %s compressed requests, sending it plain requests", nodeID, t.config.Compression)
+		t.dropClient(nodeID)
+	}
+}
@@ -0,0 +1,95 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"go.etcd.io/etcd/raft/v3/raftpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestTransportCompression(t *testing.T) {
+	for _, compression := range []string{CompressionGzip, CompressionSnappy} {
+		t.Run(compression, func(t *testing.T) {
+			gt := NewGomegaWithT(t)
+
+			peers := PeerConfig{1: freeTransportAddress(t), 2: freeTransportAddress(t)}
+			sender := NewTransportWithConfig(1, peers[1], peers, TransportConfig{Compression: compression})
+			defer sender.Stop()
+			receiver := NewTransport(2, peers[2], peers)
+			gt.Expect(receiver.Start()).To(Succeed())
+			defer receiver.Stop()
+
+			follower, err := NewShardLeader(ShardConfig{
+				ShardID:    "fabcar",
+				ReplicaIDs: []uint64{1, 2},
+				ReplicaID:  2,
+			}, DefaultBatchTimeout, DefaultBatchMaxSize)
+			gt.Expect(err).NotTo(HaveOccurred())
+			defer follower.Stop()
+			receiver.RegisterShard("fabcar", follower)
+
+			// The receiver decompresses requests without being configured to
+			heartbeat := raftpb.Message{Type: raftpb.MsgHeartbeat, From: 1, To: 2, Term: 5}
+			gt.Eventually(func() uint64 {
+				sender.sendBatch("fabcar", []raftpb.Message{heartbeat, heartbeat})
+				return follower.GetStatus().LeaderID
+			}, 10*time.Second, 100*time.Millisecond).Should(Equal(uint64(1)))
+
+			peer := sender.PeerStatus()[2]
+			gt.Expect(peer.Compression).To(Equal(compression))
+			gt.Expect(peer.Sends).To(BeNumerically(">", peer.SendFailures))
+		})
+	}
+}
+
+func TestTransportCompressionFallback(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	peers := PeerConfig{1: freeTransportAddress(t), 2: freeTransportAddress(t)}
+	sender := NewTransportWithConfig(1, peers[1], peers, TransportConfig{Compression: CompressionSnappy})
+	defer sender.Stop()
+	_, err := sender.getClient(2)
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(sender.compressionFor(2)).To(Equal(CompressionSnappy))
+
+	// A peer without the compressor rejects the request, after which it is
+	// sent plain requests over a new connection
+	sender.recordSend(2, status.Error(codes.Unimplemented, `grpc: Decompressor is not installed for grpc-encoding "snappy"`))
+	gt.Expect(sender.compressionFor(2)).To(Equal(CompressionNone))
+	peer := sender.PeerStatus()[2]
+	gt.Expect(peer.Compression).To(Equal(CompressionNone))
+	gt.Expect(peer.Reconnects).To(Equal(uint64(1)))
+	gt.Expect(peer.State).To(Equal("None"))
+	gt.Expect(peer.ConsecutiveFailures).To(BeZero())
+
+	// Other rejections leave compression on
+	sender.recordSend(1, status.Error(codes.Unimplemented, "unknown method Step"))
+	gt.Expect(sender.compressionFor(1)).To(Equal(CompressionSnappy))
+
+	// Compression is negotiated again with a peer that moved
+	sender.UpdatePeers(PeerConfig{2: freeTransportAddress(t)})
+	gt.Expect(sender.compressionFor(2)).To(Equal(CompressionSnappy))
+}
+
+func TestTransportCompressionFromEnv(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	t.Setenv(ShardCompressionEnvVar, "Gzip")
+	gt.Expect(TransportConfigFromEnv().Compression).To(Equal(CompressionGzip))
+
+	t.Setenv(ShardCompressionEnvVar, "none")
+	gt.Expect(TransportConfigFromEnv().Compression).To(Equal(CompressionNone))
+
+	// Unknown compressors are ignored
+	t.Setenv(ShardCompressionEnvVar, "zstd")
+	gt.Expect(TransportConfigFromEnv().withDefaults().Compression).To(Equal(CompressionNone))
+}
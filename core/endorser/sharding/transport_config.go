@@ -0,0 +1,245 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/keepalive"
+)
+
+const (
+	// ShardKeepaliveTimeEnvVar is how long, as a Go duration, a transport
+	// connection may be idle before it is probed with a ping, and
+	// ShardKeepaliveTimeoutEnvVar how long the ping may go unanswered before
+	// the connection is closed
+	ShardKeepaliveTimeEnvVar    = "FABRIC_SHARD_KEEPALIVE_TIME"
+	ShardKeepaliveTimeoutEnvVar = "FABRIC_SHARD_KEEPALIVE_TIMEOUT"
+	// ShardMaxSendMsgSizeEnvVar and ShardMaxRecvMsgSizeEnvVar bound, in
+	// bytes, the gRPC messages the transport sends and accepts
+	ShardMaxSendMsgSizeEnvVar = "FABRIC_SHARD_MAX_SEND_MSG_SIZE"
+	ShardMaxRecvMsgSizeEnvVar = "FABRIC_SHARD_MAX_RECV_MSG_SIZE"
+	// ShardDialTimeoutEnvVar bounds, as a Go duration, each attempt to
+	// connect to a peer
+	ShardDialTimeoutEnvVar = "FABRIC_SHARD_DIAL_TIMEOUT"
+	// ShardCoalesceWindowEnvVar is how long, as a Go duration, the transport
+	// collects outgoing Raft messages of a shard before sending them, so that
+	// the messages for a peer share one request. Without it, only the
+	// messages of one Raft Ready are coalesced.
+	ShardCoalesceWindowEnvVar = "FABRIC_SHARD_COALESCE_WINDOW"
+	// ShardAdvertisedAddressEnvVar is the address other peers reach this
+	// peer at, when it differs from the one it listens on, e.g. behind NAT
+	// or a container port mapping
+	ShardAdvertisedAddressEnvVar = "FABRIC_SHARD_ADVERTISED_ADDRESS"
+
+	DefaultKeepaliveTime    = 30 * time.Second
+	DefaultKeepaliveTimeout = 10 * time.Second
+	DefaultMaxMsgSize       = 16 << 20
+	DefaultDialTimeout      = 5 * time.Second
+
+	// minTransportMsgSize is the smallest message size limit, which leaves
+	// room for a coalesced Step request or a snapshot chunk
+	minTransportMsgSize = 2 * maxCoalescedBytes
+	// keepaliveMinTime is the most frequent ping the server accepts from a
+	// peer whose keepalive time is shorter than its own
+	keepaliveMinTime = 5 * time.Second
+)
+
+// TransportConfig tunes the connections of the shard transport. Zero fields
+// take their defaults.
+type TransportConfig struct {
+	KeepaliveTime    time.Duration
+	KeepaliveTimeout time.Duration
+	// MaxSendMsgSize and MaxRecvMsgSize must be at least 4MB, so that
+	// coalesced messages and snapshot chunks fit
+	MaxSendMsgSize int
+	MaxRecvMsgSize int
+	// DialTimeout bounds each attempt to connect to a peer
+	DialTimeout time.Duration
+
+	// SnapshotChunkSize is the amount of snapshot data per SendSnapshot chunk
+	SnapshotChunkSize int
+	// CoalesceWindow is how long outgoing messages are collected before
+	// they are sent; see ShardCoalesceWindowEnvVar
+	CoalesceWindow time.Duration
+	// BackoffBase and BackoffMax bound how long the transport stops sending
+	// to a peer it failed to reach
+	BackoffBase time.Duration
+	BackoffMax  time.Duration
+	// Compression is the compressor requests are sent with; see
+	// ShardCompressionEnvVar
+	Compression string
+	// SendQueueLength bounds the messages queued for each peer, and
+	// SendQueuePolicy selects which are dropped when it is exceeded
+	SendQueueLength int
+	SendQueuePolicy string
+	// AdvertisedAddress is the address peers reach the transport at, in the
+	// host:port form of the topology, when it differs from the one it
+	// listens on; see ShardAdvertisedAddressEnvVar
+	AdvertisedAddress string
+}
+
+// DefaultTransportConfig returns the configuration used when nothing is
+// configured
+func DefaultTransportConfig() TransportConfig {
+	return TransportConfig{
+		KeepaliveTime:     DefaultKeepaliveTime,
+		KeepaliveTimeout:  DefaultKeepaliveTimeout,
+		MaxSendMsgSize:    DefaultMaxMsgSize,
+		MaxRecvMsgSize:    DefaultMaxMsgSize,
+		DialTimeout:       DefaultDialTimeout,
+		SnapshotChunkSize: DefaultSnapshotChunkSize,
+		BackoffBase:       DefaultPeerBackoffBase,
+		BackoffMax:        DefaultPeerBackoffMax,
+		SendQueueLength:   DefaultSendQueueLength,
+		SendQueuePolicy:   DropNewest,
+	}
+}
+
+// TransportConfigFromEnv returns the default configuration with the values
+// set through the FABRIC_SHARD_* variables. Invalid values are ignored.
+func TransportConfigFromEnv() TransportConfig {
+	config := DefaultTransportConfig()
+	config.KeepaliveTime = envDuration(ShardKeepaliveTimeEnvVar, config.KeepaliveTime)
+	config.KeepaliveTimeout = envDuration(ShardKeepaliveTimeoutEnvVar, config.KeepaliveTimeout)
+	config.DialTimeout = envDuration(ShardDialTimeoutEnvVar, config.DialTimeout)
+	config.CoalesceWindow = envDuration(ShardCoalesceWindowEnvVar, config.CoalesceWindow)
+	config.MaxSendMsgSize = envPositiveInt(ShardMaxSendMsgSizeEnvVar, config.MaxSendMsgSize)
+	config.MaxRecvMsgSize = envPositiveInt(ShardMaxRecvMsgSizeEnvVar, config.MaxRecvMsgSize)
+	config.SendQueueLength = envPositiveInt(ShardSendQueueLengthEnvVar, config.SendQueueLength)
+	if policy := strings.TrimSpace(os.Getenv(ShardSendQueuePolicyEnvVar)); policy != "" {
+		config.SendQueuePolicy = policy
+	}
+	config.AdvertisedAddress = strings.TrimSpace(os.Getenv(ShardAdvertisedAddressEnvVar))
+	if compression := strings.ToLower(strings.TrimSpace(os.Getenv(ShardCompressionEnvVar))); compression != "none" {
+		config.Compression = compression
+	}
+	return config
+}
+
+// withDefaults fills in the zero fields and raises message size limits that
+// are too small for the transport to work
+func (c TransportConfig) withDefaults() TransportConfig {
+	defaults := DefaultTransportConfig()
+	if c.KeepaliveTime <= 0 {
+		c.KeepaliveTime = defaults.KeepaliveTime
+	}
+	if c.KeepaliveTimeout <= 0 {
+		c.KeepaliveTimeout = defaults.KeepaliveTimeout
+	}
+	if c.MaxSendMsgSize <= 0 {
+		c.MaxSendMsgSize = defaults.MaxSendMsgSize
+	} else if c.MaxSendMsgSize < minTransportMsgSize {
+		logger.Warningf("Raising the shard transport send limit of %d bytes to %d", c.MaxSendMsgSize, minTransportMsgSize)
+		c.MaxSendMsgSize = minTransportMsgSize
+	}
+	if c.MaxRecvMsgSize <= 0 {
+		c.MaxRecvMsgSize = defaults.MaxRecvMsgSize
+	} else if c.MaxRecvMsgSize < minTransportMsgSize {
+		logger.Warningf("Raising the shard transport receive limit of %d bytes to %d", c.MaxRecvMsgSize, minTransportMsgSize)
+		c.MaxRecvMsgSize = minTransportMsgSize
+	}
+	if c.DialTimeout <= 0 {
+		c.DialTimeout = defaults.DialTimeout
+	}
+	if c.SnapshotChunkSize <= 0 {
+		c.SnapshotChunkSize = defaults.SnapshotChunkSize
+	}
+	if c.CoalesceWindow < 0 {
+		c.CoalesceWindow = 0
+	}
+	if c.BackoffBase <= 0 {
+		c.BackoffBase = defaults.BackoffBase
+	}
+	if c.BackoffMax < c.BackoffBase {
+		c.BackoffMax = c.BackoffBase
+	}
+	if c.SendQueueLength <= 0 {
+		c.SendQueueLength = defaults.SendQueueLength
+	}
+	if c.SendQueuePolicy == "" {
+		c.SendQueuePolicy = defaults.SendQueuePolicy
+	} else if !validSendQueuePolicy(c.SendQueuePolicy) {
+		logger.Warningf("Ignoring unknown shard send queue policy %q", c.SendQueuePolicy)
+		c.SendQueuePolicy = defaults.SendQueuePolicy
+	}
+	if !validCompression(c.Compression) {
+		logger.Warningf("Ignoring unknown shard transport compression %q", c.Compression)
+		c.Compression = CompressionNone
+	}
+	return c
+}
+
+// serverOptions returns the options the transport serves with
+func (c TransportConfig) serverOptions() []grpc.ServerOption {
+	minTime := keepaliveMinTime
+	if c.KeepaliveTime < minTime {
+		minTime = c.KeepaliveTime
+	}
+	return []grpc.ServerOption{
+		grpc.MaxSendMsgSize(c.MaxSendMsgSize),
+		grpc.MaxRecvMsgSize(c.MaxRecvMsgSize),
+		grpc.KeepaliveParams(keepalive.ServerParameters{
+			Time:    c.KeepaliveTime,
+			Timeout: c.KeepaliveTimeout,
+		}),
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             minTime,
+			PermitWithoutStream: true,
+		}),
+	}
+}
+
+// dialOptions returns the options the transport dials peers with
+func (c TransportConfig) dialOptions() []grpc.DialOption {
+	connectParams := grpc.ConnectParams{Backoff: backoff.DefaultConfig, MinConnectTimeout: c.DialTimeout}
+	connectParams.Backoff.BaseDelay = c.BackoffBase
+	connectParams.Backoff.MaxDelay = c.BackoffMax
+	return []grpc.DialOption{
+		grpc.WithConnectParams(connectParams),
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                c.KeepaliveTime,
+			Timeout:             c.KeepaliveTimeout,
+			PermitWithoutStream: true,
+		}),
+		grpc.WithDefaultCallOptions(
+			grpc.MaxCallSendMsgSize(c.MaxSendMsgSize),
+			grpc.MaxCallRecvMsgSize(c.MaxRecvMsgSize),
+		),
+	}
+}
+
+func envDuration(name string, fallback time.Duration) time.Duration {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		logger.Warningf("Ignoring invalid %s %q", name, value)
+		return fallback
+	}
+	return d
+}
+
+func envPositiveInt(name string, fallback int) int {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return fallback
+	}
+	size, err := strconv.Atoi(value)
+	if err != nil || size <= 0 {
+		logger.Warningf("Ignoring invalid %s %q", name, value)
+		return fallback
+	}
+	return size
+}
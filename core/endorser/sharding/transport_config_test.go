@@ -0,0 +1,77 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"go.etcd.io/etcd/raft/v3/raftpb"
+)
+
+func TestTransportConfigFromEnv(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	gt.Expect(TransportConfigFromEnv()).To(Equal(DefaultTransportConfig()))
+
+	t.Setenv(ShardKeepaliveTimeEnvVar, "1m")
+	t.Setenv(ShardKeepaliveTimeoutEnvVar, "20s")
+	t.Setenv(ShardDialTimeoutEnvVar, "2s")
+	t.Setenv(ShardCoalesceWindowEnvVar, "1ms")
+	t.Setenv(ShardMaxSendMsgSizeEnvVar, "33554432")
+	t.Setenv(ShardMaxRecvMsgSizeEnvVar, "-1")
+
+	config := TransportConfigFromEnv()
+	gt.Expect(config.KeepaliveTime).To(Equal(time.Minute))
+	gt.Expect(config.KeepaliveTimeout).To(Equal(20 * time.Second))
+	gt.Expect(config.DialTimeout).To(Equal(2 * time.Second))
+	gt.Expect(config.CoalesceWindow).To(Equal(time.Millisecond))
+	gt.Expect(config.MaxSendMsgSize).To(Equal(32 << 20))
+	// Invalid values are ignored
+	gt.Expect(config.MaxRecvMsgSize).To(Equal(DefaultMaxMsgSize))
+
+	// Zero fields take their defaults, and limits too small for a snapshot
+	// chunk are raised
+	config = TransportConfig{MaxRecvMsgSize: 1024, BackoffBase: time.Second}.withDefaults()
+	gt.Expect(config.KeepaliveTime).To(Equal(DefaultKeepaliveTime))
+	gt.Expect(config.MaxSendMsgSize).To(Equal(DefaultMaxMsgSize))
+	gt.Expect(config.MaxRecvMsgSize).To(Equal(minTransportMsgSize))
+	gt.Expect(config.SnapshotChunkSize).To(Equal(DefaultSnapshotChunkSize))
+	gt.Expect(config.BackoffMax).To(Equal(time.Second))
+}
+
+func TestTransportMessageSizeLimit(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	peers := PeerConfig{1: freeTransportAddress(t), 2: freeTransportAddress(t), 3: freeTransportAddress(t)}
+	sender := NewTransportWithConfig(1, peers[1], peers, TransportConfig{})
+	defer sender.Stop()
+
+	// Node 2 accepts the default 16MB, node 3 only the minimum of 4MB
+	large := NewTransportWithConfig(2, peers[2], peers, TransportConfig{})
+	gt.Expect(large.Start()).To(Succeed())
+	defer large.Stop()
+	small := NewTransportWithConfig(3, peers[3], peers, TransportConfig{MaxRecvMsgSize: minTransportMsgSize})
+	gt.Expect(small.Start()).To(Succeed())
+	defer small.Stop()
+
+	// A 6MB append exceeds gRPC's default limit of 4MB
+	msg := raftpb.Message{Type: raftpb.MsgApp, From: 1, Term: 1, Entries: []raftpb.Entry{{Data: make([]byte, 6<<20)}}}
+
+	msg.To = 2
+	gt.Eventually(func() uint64 {
+		sender.sendMessages("unknown", 2, []raftpb.Message{msg})
+		return sender.PeerStatus()[2].Messages - sender.PeerStatus()[2].SendFailures
+	}, 10*time.Second, 100*time.Millisecond).Should(BeNumerically(">", 0))
+
+	msg.To = 3
+	sender.sendMessages("unknown", 3, []raftpb.Message{msg})
+	peer := sender.PeerStatus()[3]
+	gt.Expect(peer.SendFailures).To(Equal(uint64(1)))
+	gt.Expect(peer.LastError).To(ContainSubstring("ResourceExhausted"))
+}
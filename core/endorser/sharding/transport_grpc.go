@@ -2,18 +2,28 @@ package sharding
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"sync"
 	"time"
 
 	"github.com/hyperledger/fabric/core/endorser/sharding/protos"
+	"go.etcd.io/etcd/raft/v3"
 	"go.etcd.io/etcd/raft/v3/raftpb"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/metadata"
 )
 
+const (
+	// DefaultSnapshotChunkSize is the amount of snapshot data sent per chunk
+	DefaultSnapshotChunkSize = 1 << 20
+	// snapshotSendTimeout bounds the transfer of a whole snapshot
+	snapshotSendTimeout = 30 * time.Second
+)
+
 // PeerConfig maps NodeID to Address (host:port)
 type PeerConfig map[uint64]string
 
@@ -27,32 +37,91 @@ type Transport struct {
 	leadersMu  sync.RWMutex
 	grpcServer *grpc.Server
 	clients    map[uint64]protos.ShardCommunicationClient
-	clientConn map[uint64]*grpc.ClientConn
+	clientConn map[uint64]*peerConn
 	mu         sync.RWMutex
 	stopC      chan struct{}
+
+	// registry is served next to ShardCommunication when set
+	registry protos.ShardRegistryServer
+	// tls secures the connections of the transport; they are plaintext
+	// when it is nil
+	tls *TransportTLS
+	// authSecret is the secret shared by the replicas, with which requests
+	// are signed; see UseAuthToken
+	authSecret []byte
+	// config holds the connection settings, with defaults filled in
+	config TransportConfig
+
+	// health tracks the sends to each peer
+	health   map[uint64]*peerHealth
+	healthMu sync.Mutex
+
+	// queues hold the messages waiting to be sent to each peer
+	queues   map[uint64]*peerQueue
+	queuesMu sync.Mutex
 }
 
-// NewTransport creates a new gRPC transport
+// NewTransport creates a new gRPC transport configured via the
+// FABRIC_SHARD_* variables
 func NewTransport(nodeID uint64, address string, peers PeerConfig) *Transport {
+	return NewTransportWithConfig(nodeID, address, peers, TransportConfigFromEnv())
+}
+
+// NewTransportWithConfig creates a new gRPC transport with the given
+// connection settings
+func NewTransportWithConfig(nodeID uint64, address string, peers PeerConfig, config TransportConfig) *Transport {
 	return &Transport{
 		nodeID:     nodeID,
 		address:    address,
 		peers:      peers,
 		leaders:    make(map[string]*ShardLeader),
 		clients:    make(map[uint64]protos.ShardCommunicationClient),
-		clientConn: make(map[uint64]*grpc.ClientConn),
+		clientConn: make(map[uint64]*peerConn),
 		stopC:      make(chan struct{}),
+		config:     config.withDefaults(),
+		health:     make(map[uint64]*peerHealth),
+		queues:     make(map[uint64]*peerQueue),
 	}
 }
 
+// AdvertisedAddress returns the address peers reach the transport at, which
+// is the one it listens on unless the config advertises another
+func (t *Transport) AdvertisedAddress() string {
+	if t.config.AdvertisedAddress != "" {
+		return t.config.AdvertisedAddress
+	}
+	return t.address
+}
+
 // RegisterShard registers a shard leader with the transport
 func (t *Transport) RegisterShard(shardID string, leader *ShardLeader) {
 	t.leadersMu.Lock()
 	t.leaders[shardID] = leader
 	t.leadersMu.Unlock()
+	t.learnConfiguredPeers(leader.config)
+	leader.setTransport(t)
 	go t.consumeMessages(shardID, leader)
 }
 
+// UnregisterShard stops routing messages to and from the shard
+func (t *Transport) UnregisterShard(shardID string) {
+	t.leadersMu.Lock()
+	delete(t.leaders, shardID)
+	t.leadersMu.Unlock()
+}
+
+// ServeRegistry makes the transport host the shard registry. It must be
+// called before Start.
+func (t *Transport) ServeRegistry(registry protos.ShardRegistryServer) {
+	t.registry = registry
+}
+
+// UseTLS makes the transport serve and dial replicas with TLS. It must be
+// called before Start.
+func (t *Transport) UseTLS(config *TransportTLS) {
+	t.tls = config
+}
+
 // parseAndOffsetPort adds an offset to the port in a host:port string
 func parseAndOffsetPort(addr string, offset int) (string, error) {
 	host, portStr, err := net.SplitHostPort(addr)
@@ -87,8 +156,20 @@ func (t *Transport) Start() error {
 		return fmt.Errorf("failed to listen on %s: %v", bindAddr, err)
 	}
 
-	t.grpcServer = grpc.NewServer()
+	opts := append(t.config.serverOptions(),
+		grpc.Creds(t.tls.serverCredentials()),
+		grpc.ChainUnaryInterceptor(t.unaryAuthInterceptor),
+		grpc.ChainStreamInterceptor(t.streamAuthInterceptor),
+	)
+	t.grpcServer = grpc.NewServer(opts...)
 	protos.RegisterShardCommunicationServer(t.grpcServer, t)
+	if t.registry != nil {
+		protos.RegisterShardRegistryServer(t.grpcServer, t.registry)
+	}
+
+	if advertised := t.AdvertisedAddress(); advertised != t.address {
+		logger.Infof("Shard transport of node %d listens on %s and is advertised as %s", t.nodeID, lis.Addr(), advertised)
+	}
 
 	// Start server
 	go func() {
@@ -113,8 +194,126 @@ func (t *Transport) Stop() {
 	}
 }
 
-// Step receives a message from a peer (gRPC handler)
+// Step receives messages from a peer (gRPC handler)
 func (t *Transport) Step(ctx context.Context, req *protos.RaftMessageProto) (*protos.StepResponse, error) {
+	leader, err := t.shardFromContext(ctx)
+	if err != nil {
+		return &protos.StepResponse{Success: false, Error: err.Error()}, nil
+	}
+
+	payloads := req.Messages
+	if len(req.Data) > 0 {
+		payloads = append([][]byte{req.Data}, payloads...)
+	}
+
+	// Raft tolerates lost messages, so a message that fails to step does not
+	// hold back the ones after it; the first failure is reported
+	var stepErr error
+	for _, data := range payloads {
+		var msg raftpb.Message
+		err := msg.Unmarshal(data)
+		if err == nil {
+			err = checkSender(ctx, msg)
+		}
+		if err != nil {
+			if stepErr == nil {
+				stepErr = err
+			}
+			continue
+		}
+		if err := leader.Step(ctx, msg); err != nil && stepErr == nil {
+			stepErr = err
+		}
+	}
+	if stepErr != nil {
+		return &protos.StepResponse{Success: false, Error: stepErr.Error()}, nil
+	}
+
+	return &protos.StepResponse{Success: true}, nil
+}
+
+// SendSnapshot receives a chunked MsgSnap from a peer, reassembles its
+// snapshot data and steps it (gRPC handler)
+func (t *Transport) SendSnapshot(stream protos.ShardCommunication_SendSnapshotServer) error {
+	leader, err := t.shardFromContext(stream.Context())
+	if err != nil {
+		return stream.SendAndClose(&protos.StepResponse{Success: false, Error: err.Error()})
+	}
+
+	var msg raftpb.Message
+	var data []byte
+	for first := true; ; first = false {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			return stream.SendAndClose(&protos.StepResponse{Success: false, Error: "snapshot stream ended before the last chunk"})
+		}
+		if err != nil {
+			return err
+		}
+		if first {
+			if err := msg.Unmarshal(chunk.Message); err != nil {
+				return stream.SendAndClose(&protos.StepResponse{Success: false, Error: err.Error()})
+			}
+			if msg.Type != raftpb.MsgSnap {
+				return stream.SendAndClose(&protos.StepResponse{Success: false, Error: fmt.Sprintf("expected %s, got %s", raftpb.MsgSnap, msg.Type)})
+			}
+			if err := checkSender(stream.Context(), msg); err != nil {
+				return stream.SendAndClose(&protos.StepResponse{Success: false, Error: err.Error()})
+			}
+		}
+		data = append(data, chunk.Data...)
+		if chunk.Last {
+			break
+		}
+	}
+
+	msg.Snapshot.Data = data
+	if err := leader.Step(stream.Context(), msg); err != nil {
+		return stream.SendAndClose(&protos.StepResponse{Success: false, Error: err.Error()})
+	}
+	logger.Infof("Shard %s: Received snapshot at index %d (%d bytes) from node %d",
+		leader.shardID, msg.Snapshot.Metadata.Index, len(data), msg.From)
+
+	return stream.SendAndClose(&protos.StepResponse{Success: true})
+}
+
+// Forward proposes a batch forwarded by a follower and returns the proofs of
+// its transactions (gRPC handler)
+func (t *Transport) Forward(ctx context.Context, req *protos.ForwardRequest) (*protos.ForwardResponse, error) {
+	leader, err := t.shardFromContext(ctx)
+	if err != nil {
+		return &protos.ForwardResponse{Success: false, Error: err.Error()}, nil
+	}
+
+	proofs, err := leader.proposeForwarded(ctx, req.Batch)
+	if err != nil {
+		return &protos.ForwardResponse{Success: false, Error: err.Error()}, nil
+	}
+
+	data, err := json.Marshal(proofs)
+	if err != nil {
+		return &protos.ForwardResponse{Success: false, Error: err.Error()}, nil
+	}
+	return &protos.ForwardResponse{Success: true, Proofs: data}, nil
+}
+
+// CoSign signs the proof of a transaction applied by the shard on this node
+// (gRPC handler)
+func (t *Transport) CoSign(ctx context.Context, req *protos.CoSignRequest) (*protos.CoSignResponse, error) {
+	leader, err := t.shardFromContext(ctx)
+	if err != nil {
+		return &protos.CoSignResponse{Success: false, Error: err.Error()}, nil
+	}
+
+	signature, err := leader.coSign(ctx, req.TxId, req.CommitIndex, req.Term)
+	if err != nil {
+		return &protos.CoSignResponse{Success: false, Error: err.Error()}, nil
+	}
+	return &protos.CoSignResponse{Success: true, Signature: signature}, nil
+}
+
+// shardFromContext returns the shard leader named by the shard-id metadata
+func (t *Transport) shardFromContext(ctx context.Context) (*ShardLeader, error) {
 	md, ok := metadata.FromIncomingContext(ctx)
 	shardID := ""
 	if ok && len(md["shard-id"]) > 0 {
@@ -122,7 +321,7 @@ func (t *Transport) Step(ctx context.Context, req *protos.RaftMessageProto) (*pr
 	}
 
 	if shardID == "" {
-		return &protos.StepResponse{Success: false, Error: "missing shard-id in metadata"}, nil
+		return nil, fmt.Errorf("missing shard-id in metadata")
 	}
 
 	t.leadersMu.RLock()
@@ -130,19 +329,9 @@ func (t *Transport) Step(ctx context.Context, req *protos.RaftMessageProto) (*pr
 	t.leadersMu.RUnlock()
 
 	if !exists {
-		return &protos.StepResponse{Success: false, Error: fmt.Sprintf("shard %s not found on this node", shardID)}, nil
-	}
-
-	var msg raftpb.Message
-	if err := msg.Unmarshal(req.Data); err != nil {
-		return &protos.StepResponse{Success: false, Error: err.Error()}, nil
-	}
-
-	if err := leader.Step(ctx, msg); err != nil {
-		return &protos.StepResponse{Success: false, Error: err.Error()}, nil
+		return nil, fmt.Errorf("shard %s not found on this node", shardID)
 	}
-
-	return &protos.StepResponse{Success: true}, nil
+	return leader, nil
 }
 
 // consumeMessages reads outgoing messages from ShardLeader and sends them
@@ -150,9 +339,9 @@ func (t *Transport) consumeMessages(shardID string, leader *ShardLeader) {
 	for {
 		select {
 		case msgs := <-leader.MessagesC():
-			for _, msg := range msgs {
-				go t.send(shardID, msg)
-			}
+			t.sendBatch(shardID, t.collectMessages(leader, msgs))
+		case <-leader.stopC:
+			return
 		case <-t.stopC:
 			return
 		}
@@ -161,20 +350,60 @@ func (t *Transport) consumeMessages(shardID string, leader *ShardLeader) {
 
 // send sends a single Raft message to a peer
 func (t *Transport) send(shardID string, msg raftpb.Message) {
-	client, err := t.getClient(msg.To)
+	if msg.Type != raftpb.MsgSnap {
+		t.sendMessages(shardID, msg.To, []raftpb.Message{msg})
+		return
+	}
+
+	client, release, err := t.acquireClient(msg.To)
 	if err != nil {
 		logger.Errorf("Failed to get client for node %d: %v", msg.To, err)
+		t.reportSnapshot(shardID, msg.To, err)
 		return
 	}
+	defer release()
+
+	// Snapshots can be arbitrarily large, so they are streamed in chunks
+	// with a deadline of their own instead of going through Step
+	err = t.sendSnapshot(shardID, client, msg)
+	t.recordSend(msg.To, err)
+	t.reportSnapshot(shardID, msg.To, err)
+}
 
-	data, err := msg.Marshal()
+// sendMessages sends Raft messages to a peer in a single Step request
+func (t *Transport) sendMessages(shardID string, to uint64, msgs []raftpb.Message) {
+	client, release, err := t.acquireClient(to)
 	if err != nil {
-		logger.Errorf("Failed to marshal raft message: %v", err)
+		if errors.Is(err, ErrPeerUnreachable) {
+			logger.Debugf("Dropping %d messages to node %d: %v", len(msgs), to, err)
+		} else {
+			logger.Errorf("Failed to get client for node %d: %v", to, err)
+		}
+		t.reportUnreachable(shardID, to)
+		return
+	}
+	defer release()
+
+	payloads := make([][]byte, 0, len(msgs))
+	for _, msg := range msgs {
+		data, err := msg.Marshal()
+		if err != nil {
+			logger.Errorf("Failed to marshal raft message: %v", err)
+			continue
+		}
+		payloads = append(payloads, data)
+	}
+	if len(payloads) == 0 {
 		return
 	}
 
-	req := &protos.RaftMessageProto{
-		Data: data,
+	// A single message goes in data, so that peers which predate coalescing
+	// still understand it
+	req := &protos.RaftMessageProto{}
+	if len(payloads) == 1 {
+		req.Data = payloads[0]
+	} else {
+		req.Messages = payloads
 	}
 
 	// Use an aggressive 500ms timeout for internal Raft routing since heartbeat ticks
@@ -186,13 +415,148 @@ func (t *Transport) send(shardID string, msg raftpb.Message) {
 	ctx = metadata.AppendToOutgoingContext(ctx, "shard-id", shardID)
 
 	_, err = client.Step(ctx, req)
+	t.recordSend(to, err)
+	t.countMessages(to, len(payloads))
+	if err != nil {
+		logger.Warnf("Failed to send %d messages to node %d: %v", len(payloads), to, err)
+		t.reportUnreachable(shardID, to)
+	}
+}
+
+// sendSnapshot streams a MsgSnap to its recipient in chunks of at most
+// SnapshotChunkSize bytes of snapshot data
+func (t *Transport) sendSnapshot(shardID string, client protos.ShardCommunicationClient, msg raftpb.Message) error {
+	data := msg.Snapshot.Data
+	msg.Snapshot.Data = nil
+	header, err := msg.Marshal()
+	if err != nil {
+		return fmt.Errorf("failed to marshal snapshot message: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), snapshotSendTimeout)
+	defer cancel()
+	ctx = metadata.AppendToOutgoingContext(ctx, "shard-id", shardID)
+
+	stream, err := client.SendSnapshot(ctx)
 	if err != nil {
-		logger.Warnf("Failed to send message to node %d: %v", msg.To, err)
+		return err
 	}
+
+	chunk := &protos.SnapshotChunk{Message: header}
+	for {
+		n := len(data)
+		if n > t.config.SnapshotChunkSize {
+			n = t.config.SnapshotChunkSize
+		}
+		chunk.Data, data = data[:n], data[n:]
+		chunk.Last = len(data) == 0
+		if err := stream.Send(chunk); err != nil {
+			return err
+		}
+		if chunk.Last {
+			break
+		}
+		chunk = &protos.SnapshotChunk{}
+	}
+
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		return err
+	}
+	if !resp.Success {
+		return fmt.Errorf("%s", resp.Error)
+	}
+	return nil
 }
 
-// getClient returns or creates a gRPC client for a node
+// reportSnapshot tells the local shard leader whether a snapshot reached the
+// given node
+func (t *Transport) reportSnapshot(shardID string, to uint64, err error) {
+	t.leadersMu.RLock()
+	leader, exists := t.leaders[shardID]
+	t.leadersMu.RUnlock()
+	if !exists {
+		return
+	}
+
+	if err != nil {
+		logger.Warnf("Shard %s: Failed to send snapshot to node %d: %v", shardID, to, err)
+		leader.ReportSnapshot(to, raft.SnapshotFailure)
+		return
+	}
+	leader.ReportSnapshot(to, raft.SnapshotFinish)
+}
+
+// reportUnreachable tells the local shard leader that a message to the given
+// node could not be delivered, so that Raft probes it instead of streaming
+// entries to it
+func (t *Transport) reportUnreachable(shardID string, to uint64) {
+	t.leadersMu.RLock()
+	leader, exists := t.leaders[shardID]
+	t.leadersMu.RUnlock()
+	if exists {
+		leader.ReportUnreachable(to)
+	}
+}
+
+// ForwardBatch sends a serialized batch proposed on this node to the leader
+// of the shard and returns the proofs of its transactions
+func (t *Transport) ForwardBatch(ctx context.Context, shardID string, leaderID uint64, batch []byte) ([]*PrepareProof, error) {
+	client, release, err := t.acquireClient(leaderID)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	ctx = metadata.AppendToOutgoingContext(ctx, "shard-id", shardID)
+	resp, err := client.Forward(ctx, &protos.ForwardRequest{Batch: batch})
+	t.recordSend(leaderID, err)
+	if err != nil {
+		return nil, err
+	}
+	if !resp.Success {
+		return nil, fmt.Errorf("%s", resp.Error)
+	}
+
+	var proofs []*PrepareProof
+	if err := json.Unmarshal(resp.Proofs, &proofs); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal proofs from node %d: %v", leaderID, err)
+	}
+	return proofs, nil
+}
+
+// CoSignProof asks the given replica of the shard to co-sign the proof
+func (t *Transport) CoSignProof(ctx context.Context, shardID string, replicaID uint64, proof *PrepareProof) ([]byte, error) {
+	client, release, err := t.acquireClient(replicaID)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	ctx = metadata.AppendToOutgoingContext(ctx, "shard-id", shardID)
+	resp, err := client.CoSign(ctx, &protos.CoSignRequest{TxId: proof.TxID, CommitIndex: proof.CommitIndex, Term: proof.Term})
+	t.recordSend(replicaID, err)
+	if err != nil {
+		return nil, err
+	}
+	if !resp.Success {
+		return nil, fmt.Errorf("%s", resp.Error)
+	}
+	return resp.Signature, nil
+}
+
+// getClient returns or creates a gRPC client for a node. It fails with
+// ErrPeerUnreachable while the transport backs off from the node, and dials
+// the node again once a failing connection's backoff expired.
 func (t *Transport) getClient(nodeID uint64) (protos.ShardCommunicationClient, error) {
+	if err := t.checkBackoff(nodeID); err != nil {
+		return nil, err
+	}
+	if t.needsRedial(nodeID) {
+		logger.Infof("Reconnecting to node %d", nodeID)
+		t.dropClient(nodeID)
+	}
+
 	t.mu.RLock()
 	client, exists := t.clients[nodeID]
 	t.mu.RUnlock()
@@ -219,14 +583,21 @@ func (t *Transport) getClient(nodeID uint64) (protos.ShardCommunicationClient, e
 	}
 
 	// Connect
-	conn, err := grpc.Dial(dialAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	opts := append(t.config.dialOptions(),
+		grpc.WithTransportCredentials(t.tls.clientCredentials(nodeID)),
+		grpc.WithPerRPCCredentials(callerCredentials{nodeID: t.nodeID, secret: t.authSecret}),
+	)
+	if compression := t.compressionFor(nodeID); compression != CompressionNone {
+		opts = append(opts, grpc.WithDefaultCallOptions(grpc.UseCompressor(compression)))
+	}
+	conn, err := grpc.Dial(dialAddr, opts...)
 	if err != nil {
 		return nil, err
 	}
 
 	client = protos.NewShardCommunicationClient(conn)
 	t.clients[nodeID] = client
-	t.clientConn[nodeID] = conn
+	t.clientConn[nodeID] = &peerConn{ClientConn: conn}
 
 	return client, nil
 }
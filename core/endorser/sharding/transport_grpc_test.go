@@ -0,0 +1,219 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"go.etcd.io/etcd/raft/v3/raftpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// freeTransportAddress returns an address whose transport port, 20000 above
+// the returned one, is free to listen on
+func freeTransportAddress(t *testing.T) string {
+	for i := 0; i < 20; i++ {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		port := lis.Addr().(*net.TCPAddr).Port
+		lis.Close()
+		if port > 20000 {
+			return fmt.Sprintf("127.0.0.1:%d", port-20000)
+		}
+	}
+	t.Fatal("no free transport port found")
+	return ""
+}
+
+func TestTransportSendSnapshotInChunks(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	peers := PeerConfig{1: freeTransportAddress(t), 2: freeTransportAddress(t)}
+	sender := NewTransport(1, peers[1], peers)
+	receiver := NewTransport(2, peers[2], peers)
+	gt.Expect(sender.Start()).To(Succeed())
+	defer sender.Stop()
+	gt.Expect(receiver.Start()).To(Succeed())
+	defer receiver.Stop()
+
+	follower, err := NewShardLeader(ShardConfig{
+		ShardID:    "fabcar",
+		ReplicaIDs: []uint64{1, 2},
+		ReplicaID:  2,
+	}, DefaultBatchTimeout, DefaultBatchMaxSize)
+	gt.Expect(err).NotTo(HaveOccurred())
+	defer follower.Stop()
+	receiver.RegisterShard("fabcar", follower)
+
+	deps := make(map[string]TransactionDependencyInfo)
+	for i := 0; i < 200; i++ {
+		deps[fmt.Sprintf("fabcar:car%d", i)] = TransactionDependencyInfo{Value: []byte("v"), DependentTxID: fmt.Sprintf("tx%d", i)}
+	}
+	data, err := json.Marshal(&ShardBackup{Version: shardBackupVersion, ShardID: "fabcar", Dependencies: deps})
+	gt.Expect(err).NotTo(HaveOccurred())
+
+	// Force the snapshot across many chunks
+	sender.config.SnapshotChunkSize = 1024
+	gt.Expect(len(data)).To(BeNumerically(">", 10*sender.config.SnapshotChunkSize))
+
+	client, err := sender.getClient(2)
+	gt.Expect(err).NotTo(HaveOccurred())
+	msg := raftpb.Message{
+		Type: raftpb.MsgSnap,
+		From: 1,
+		To:   2,
+		Term: 2,
+		Snapshot: raftpb.Snapshot{
+			Data: data,
+			Metadata: raftpb.SnapshotMetadata{
+				Index:     1000,
+				Term:      2,
+				ConfState: raftpb.ConfState{Voters: []uint64{1, 2}},
+			},
+		},
+	}
+	gt.Eventually(func() error {
+		return sender.sendSnapshot("fabcar", client, msg)
+	}, 10*time.Second, 100*time.Millisecond).Should(Succeed())
+
+	gt.Eventually(func() uint64 {
+		backup, err := follower.Backup(time.Second)
+		if err != nil {
+			return 0
+		}
+		return backup.AppliedIndex
+	}, 10*time.Second, 100*time.Millisecond).Should(Equal(uint64(1000)))
+
+	backup, err := follower.Backup(time.Second)
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(backup.Dependencies).To(Equal(deps))
+}
+
+func TestTransportSendSnapshotToUnknownShard(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	peers := PeerConfig{1: freeTransportAddress(t), 2: freeTransportAddress(t)}
+	sender := NewTransport(1, peers[1], peers)
+	receiver := NewTransport(2, peers[2], peers)
+	gt.Expect(receiver.Start()).To(Succeed())
+	defer receiver.Stop()
+	defer sender.Stop()
+
+	client, err := sender.getClient(2)
+	gt.Expect(err).NotTo(HaveOccurred())
+
+	// The shard is not hosted by the receiver
+	err = sender.sendSnapshot("fabcar", client, raftpb.Message{Type: raftpb.MsgSnap, From: 1, To: 2})
+	gt.Expect(err).To(MatchError("shard fabcar not found on this node"))
+}
+
+func TestTransportBacksOffUnreachablePeer(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	peers := PeerConfig{1: freeTransportAddress(t), 2: freeTransportAddress(t)}
+	sender := NewTransport(1, peers[1], peers)
+	sender.config.BackoffBase = 50 * time.Millisecond
+	sender.config.BackoffMax = 200 * time.Millisecond
+	defer sender.Stop()
+
+	proof := &PrepareProof{TxID: "tx1", CommitIndex: 1, Term: 1}
+	coSign := func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		_, err := sender.CoSignProof(ctx, "fabcar", 2, proof)
+		return err
+	}
+
+	// Nothing listens at the address of node 2 yet
+	gt.Expect(status.Code(coSign())).To(Equal(codes.Unavailable))
+	gt.Expect(coSign()).To(MatchError(ErrPeerUnreachable))
+
+	peer := sender.PeerStatus()[2]
+	gt.Expect(peer.Address).To(Equal(peers[2]))
+	gt.Expect(peer.SendFailures).To(Equal(uint64(1)))
+	gt.Expect(peer.ConsecutiveFailures).To(Equal(1))
+	gt.Expect(peer.RetryAt).NotTo(BeZero())
+	gt.Expect(peer.LastError).NotTo(BeEmpty())
+
+	// Further failures double the backoff up to its maximum
+	gt.Eventually(func() int {
+		coSign()
+		return sender.PeerStatus()[2].ConsecutiveFailures
+	}, 5*time.Second, 10*time.Millisecond).Should(BeNumerically(">=", 4))
+	gt.Expect(time.Until(sender.PeerStatus()[2].RetryAt)).To(BeNumerically("<=", sender.config.BackoffMax))
+
+	// Once node 2 is up, the sender reaches it again. The receiver rejects the
+	// request as it does not host the shard, which is not a send failure.
+	receiver := NewTransport(2, peers[2], peers)
+	gt.Expect(receiver.Start()).To(Succeed())
+	defer receiver.Stop()
+	gt.Eventually(coSign, 10*time.Second, 50*time.Millisecond).Should(MatchError(ContainSubstring("not found on this node")))
+
+	peer = sender.PeerStatus()[2]
+	gt.Expect(peer.State).To(Equal("READY"))
+	gt.Expect(peer.ConsecutiveFailures).To(BeZero())
+	gt.Expect(peer.RetryAt).To(BeZero())
+	gt.Expect(peer.Sends).To(BeNumerically(">", peer.SendFailures))
+
+	// A new address ends the backoff right away
+	gt.Expect(sender.PeerStatus()[1].State).To(Equal("None"))
+	sender.recordSend(1, status.Error(codes.Unavailable, "connection refused"))
+	gt.Expect(sender.getClient(1)).Error().To(MatchError(ErrPeerUnreachable))
+	sender.UpdatePeers(PeerConfig{1: freeTransportAddress(t)})
+	gt.Expect(sender.getClient(1)).Error().NotTo(HaveOccurred())
+}
+
+func TestTransportCoalescesMessages(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	peers := PeerConfig{1: freeTransportAddress(t), 2: freeTransportAddress(t)}
+	sender := NewTransport(1, peers[1], peers)
+	defer sender.Stop()
+	receiver := NewTransport(2, peers[2], peers)
+	gt.Expect(receiver.Start()).To(Succeed())
+	defer receiver.Stop()
+
+	follower, err := NewShardLeader(ShardConfig{
+		ShardID:    "fabcar",
+		ReplicaIDs: []uint64{1, 2},
+		ReplicaID:  2,
+	}, DefaultBatchTimeout, DefaultBatchMaxSize)
+	gt.Expect(err).NotTo(HaveOccurred())
+	defer follower.Stop()
+	receiver.RegisterShard("fabcar", follower)
+
+	heartbeat := raftpb.Message{Type: raftpb.MsgHeartbeat, From: 1, To: 2, Term: 5}
+
+	// Messages emitted within the window are sent together
+	sender.config.CoalesceWindow = 100 * time.Millisecond
+	source := &ShardLeader{messagesC: make(chan []raftpb.Message, 2), stopC: make(chan struct{})}
+	source.messagesC <- []raftpb.Message{heartbeat}
+	source.messagesC <- []raftpb.Message{heartbeat}
+	batch := sender.collectMessages(source, []raftpb.Message{heartbeat})
+	gt.Expect(batch).To(HaveLen(3))
+
+	sender.sendBatch("fabcar", batch)
+	gt.Eventually(func() uint64 { return follower.GetStatus().LeaderID }, 10*time.Second, 50*time.Millisecond).Should(Equal(uint64(1)))
+	gt.Expect(follower.GetStatus().Term).To(Equal(uint64(5)))
+	gt.Eventually(func() uint64 { return sender.PeerStatus()[2].Messages }).Should(Equal(uint64(3)))
+	gt.Expect(sender.PeerStatus()[2].Sends).To(Equal(uint64(1)))
+
+	// Requests are split before they exceed the size bound
+	large := raftpb.Message{Type: raftpb.MsgApp, From: 1, To: 2, Term: 5, Entries: []raftpb.Entry{{Data: make([]byte, maxCoalescedBytes*3/4)}}}
+	sender.sendBatch("unknown", []raftpb.Message{large, large, heartbeat})
+	gt.Eventually(func() uint64 { return sender.PeerStatus()[2].Messages }).Should(Equal(uint64(6)))
+	gt.Expect(sender.PeerStatus()[2].Sends).To(Equal(uint64(3)))
+}
@@ -0,0 +1,253 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// DefaultPeerBackoffBase is how long the transport stops sending to a
+	// peer after a first connection failure. The pause doubles with every
+	// further failure up to DefaultPeerBackoffMax.
+	DefaultPeerBackoffBase = 100 * time.Millisecond
+	DefaultPeerBackoffMax  = 10 * time.Second
+)
+
+// ErrPeerUnreachable is returned for requests to a peer the transport backs
+// off from after failing to reach it
+var ErrPeerUnreachable = errors.New("peer is unreachable")
+
+// TransportPeerStatus describes the connection of the transport to a peer
+type TransportPeerStatus struct {
+	Address string
+	// State is the connectivity state of the connection, or "None" before
+	// the peer is dialed
+	State string
+	// Sends counts requests and Messages the Raft messages they carried
+	Sends        uint64
+	Messages     uint64
+	SendFailures uint64
+	// ConsecutiveFailures counts the failures since the last successful
+	// send; the peer is backed off from until RetryAt while it is not 0
+	ConsecutiveFailures int
+	RetryAt             time.Time
+	// Reconnects counts the connections dialed again after failing
+	Reconnects uint64
+	LastError  string `json:",omitempty"`
+	// Compression is the compressor requests to the peer are sent with
+	Compression string `json:",omitempty"`
+	// Queued is the number of messages waiting to be sent, and Dropped
+	// counts the messages discarded since the queue was full
+	Queued  int
+	Dropped uint64
+}
+
+// peerHealth tracks the sends to a peer. It is guarded by Transport.healthMu.
+type peerHealth struct {
+	sends               uint64
+	messages            uint64
+	sendFailures        uint64
+	consecutiveFailures int
+	retryAt             time.Time
+	reconnects          uint64
+	lastError           string
+	dropped             uint64
+	// uncompressed is set once the peer rejected a compressed request
+	uncompressed bool
+}
+
+func (t *Transport) healthLocked(nodeID uint64) *peerHealth {
+	health, ok := t.health[nodeID]
+	if !ok {
+		health = &peerHealth{}
+		t.health[nodeID] = health
+	}
+	return health
+}
+
+// checkBackoff returns ErrPeerUnreachable while the transport backs off from
+// the peer
+func (t *Transport) checkBackoff(nodeID uint64) error {
+	t.healthMu.Lock()
+	defer t.healthMu.Unlock()
+	health, ok := t.health[nodeID]
+	if !ok || health.consecutiveFailures == 0 {
+		return nil
+	}
+	if wait := time.Until(health.retryAt); wait > 0 {
+		return fmt.Errorf("%w: node %d failed %d times, retrying in %s", ErrPeerUnreachable, nodeID, health.consecutiveFailures, wait.Round(time.Millisecond))
+	}
+	return nil
+}
+
+// recordSend accounts for the outcome of a request to the peer. Failures to
+// reach the peer back it off exponentially; errors returned by the peer
+// itself are only counted.
+func (t *Transport) recordSend(nodeID uint64, err error) {
+	if isCompressionUnsupported(err) {
+		t.disableCompression(nodeID)
+	}
+
+	t.healthMu.Lock()
+	defer t.healthMu.Unlock()
+
+	health := t.healthLocked(nodeID)
+	health.sends++
+	if err == nil {
+		health.consecutiveFailures = 0
+		health.retryAt = time.Time{}
+		return
+	}
+
+	health.sendFailures++
+	health.lastError = err.Error()
+	if !isConnectionError(err) {
+		return
+	}
+	health.consecutiveFailures++
+	backoff := t.config.BackoffBase << uint(health.consecutiveFailures-1)
+	if backoff > t.config.BackoffMax || backoff <= 0 {
+		backoff = t.config.BackoffMax
+	}
+	health.retryAt = time.Now().Add(backoff)
+}
+
+// countMessages accounts for the Raft messages sent to the peer in one
+// request
+func (t *Transport) countMessages(nodeID uint64, n int) {
+	t.healthMu.Lock()
+	defer t.healthMu.Unlock()
+	t.healthLocked(nodeID).messages += uint64(n)
+}
+
+// countDropped accounts for messages to the peer discarded by its send queue
+func (t *Transport) countDropped(nodeID uint64, n int) {
+	t.healthMu.Lock()
+	defer t.healthMu.Unlock()
+	t.healthLocked(nodeID).dropped += uint64(n)
+}
+
+// resetBackoff lets the transport send to the peer right away, e.g. after
+// its address changed. Compression is negotiated with it again.
+func (t *Transport) resetBackoff(nodeID uint64) {
+	t.healthMu.Lock()
+	defer t.healthMu.Unlock()
+	if health, ok := t.health[nodeID]; ok {
+		health.consecutiveFailures = 0
+		health.retryAt = time.Time{}
+		health.uncompressed = false
+	}
+}
+
+// isConnectionError reports whether the request failed to reach the peer, as
+// opposed to being rejected by it
+func isConnectionError(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	default:
+		return false
+	}
+}
+
+// needsRedial reports whether a cached connection has to be replaced. A
+// connection that keeps failing is dialed again once the peer's backoff
+// expired, so that a peer that came back at the same address is reached
+// without waiting for gRPC's own reconnect backoff.
+func (t *Transport) needsRedial(nodeID uint64) bool {
+	t.mu.RLock()
+	conn, ok := t.clientConn[nodeID]
+	t.mu.RUnlock()
+	if !ok {
+		return false
+	}
+	switch conn.GetState() {
+	case connectivity.Shutdown:
+		return true
+	case connectivity.TransientFailure:
+		t.healthMu.Lock()
+		defer t.healthMu.Unlock()
+		health, ok := t.health[nodeID]
+		return ok && health.consecutiveFailures > 0
+	default:
+		return false
+	}
+}
+
+// dropClient retires the cached connection to the peer, so that the next
+// request dials it again
+func (t *Transport) dropClient(nodeID uint64) {
+	t.mu.Lock()
+	ok := t.retireClientLocked(nodeID)
+	t.mu.Unlock()
+
+	if ok {
+		t.healthMu.Lock()
+		t.healthLocked(nodeID).reconnects++
+		t.healthMu.Unlock()
+	}
+}
+
+// PeerStatus returns the state of the connections of the transport to the
+// peers it knows of
+func (t *Transport) PeerStatus() map[uint64]TransportPeerStatus {
+	t.mu.RLock()
+	peers := make(map[uint64]TransportPeerStatus, len(t.peers))
+	for id, addr := range t.peers {
+		state := "None"
+		if conn, ok := t.clientConn[id]; ok {
+			state = conn.GetState().String()
+		}
+		peers[id] = TransportPeerStatus{Address: addr, State: state, Compression: t.config.Compression}
+	}
+	t.mu.RUnlock()
+
+	for id, peer := range peers {
+		peer.Queued = t.queueLength(id)
+		peers[id] = peer
+	}
+
+	t.healthMu.Lock()
+	defer t.healthMu.Unlock()
+	for id, health := range t.health {
+		peer := peers[id]
+		peer.Sends = health.sends
+		peer.Messages = health.messages
+		peer.SendFailures = health.sendFailures
+		peer.ConsecutiveFailures = health.consecutiveFailures
+		peer.Reconnects = health.reconnects
+		peer.LastError = health.lastError
+		peer.Dropped = health.dropped
+		if health.consecutiveFailures > 0 {
+			peer.RetryAt = health.retryAt
+		}
+		if health.uncompressed {
+			peer.Compression = CompressionNone
+		}
+		peers[id] = peer
+	}
+	return peers
+}
+
+// GetTransportStatus returns the state of the connections of the shard
+// transport of this peer
+func (sm *ShardManager) GetTransportStatus() map[uint64]TransportPeerStatus {
+	globalTransportLock.Lock()
+	transport := globalTransport
+	globalTransportLock.Unlock()
+	if transport == nil {
+		return map[uint64]TransportPeerStatus{}
+	}
+	return transport.PeerStatus()
+}
@@ -0,0 +1,227 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.etcd.io/etcd/raft/v3"
+	"go.etcd.io/etcd/raft/v3/raftpb"
+)
+
+// inProcessStepTimeout bounds the delivery of a message to a node, as the
+// Step deadline does over gRPC
+const inProcessStepTimeout = 500 * time.Millisecond
+
+// InProcessNetwork connects the InProcessTransports of nodes running in the
+// same process, so that shard clusters can be run without opening sockets,
+// e.g. in tests and single-binary experiments
+type InProcessNetwork struct {
+	mu    sync.RWMutex
+	nodes map[uint64]*InProcessTransport
+	// isolated nodes neither send nor receive messages
+	isolated map[uint64]bool
+}
+
+// NewInProcessNetwork creates a network without nodes
+func NewInProcessNetwork() *InProcessNetwork {
+	return &InProcessNetwork{
+		nodes:    make(map[uint64]*InProcessTransport),
+		isolated: make(map[uint64]bool),
+	}
+}
+
+// NewTransport creates the transport of the node and connects it to the
+// network, replacing an earlier transport of the node
+func (n *InProcessNetwork) NewTransport(nodeID uint64) *InProcessTransport {
+	t := &InProcessTransport{
+		nodeID:  nodeID,
+		network: n,
+		leaders: make(map[string]*ShardLeader),
+		stopC:   make(chan struct{}),
+	}
+	n.mu.Lock()
+	n.nodes[nodeID] = t
+	n.mu.Unlock()
+	return t
+}
+
+// Isolate cuts the node off from all other nodes until Heal is called
+func (n *InProcessNetwork) Isolate(nodeID uint64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.isolated[nodeID] = true
+}
+
+// Heal reconnects an isolated node
+func (n *InProcessNetwork) Heal(nodeID uint64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.isolated, nodeID)
+}
+
+// route returns the transport of the node to, if from can reach it
+func (n *InProcessNetwork) route(from, to uint64) (*InProcessTransport, error) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	if n.isolated[from] || n.isolated[to] {
+		return nil, fmt.Errorf("%w: node %d cannot reach node %d", ErrPeerUnreachable, from, to)
+	}
+	t, ok := n.nodes[to]
+	if !ok {
+		return nil, fmt.Errorf("unknown peer %d", to)
+	}
+	return t, nil
+}
+
+// remove disconnects the transport, unless it was replaced already
+func (n *InProcessNetwork) remove(t *InProcessTransport) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.nodes[t.nodeID] == t {
+		delete(n.nodes, t.nodeID)
+	}
+}
+
+// InProcessTransport delivers the messages of the shards of a node directly
+// to the shards of other nodes of its InProcessNetwork
+type InProcessTransport struct {
+	nodeID    uint64
+	network   *InProcessNetwork
+	leaders   map[string]*ShardLeader
+	leadersMu sync.RWMutex
+	stopC     chan struct{}
+	stopOnce  sync.Once
+}
+
+// RegisterShard registers a shard leader with the transport
+func (t *InProcessTransport) RegisterShard(shardID string, leader *ShardLeader) {
+	t.leadersMu.Lock()
+	t.leaders[shardID] = leader
+	t.leadersMu.Unlock()
+	leader.setTransport(t)
+	go t.consumeMessages(shardID, leader)
+}
+
+// UnregisterShard stops routing messages to and from the shard
+func (t *InProcessTransport) UnregisterShard(shardID string) {
+	t.leadersMu.Lock()
+	delete(t.leaders, shardID)
+	t.leadersMu.Unlock()
+}
+
+// Stop disconnects the transport from the network
+func (t *InProcessTransport) Stop() {
+	t.stopOnce.Do(func() {
+		close(t.stopC)
+		t.network.remove(t)
+	})
+}
+
+// UpdatePeers does nothing, since nodes are addressed by their IDs
+func (t *InProcessTransport) UpdatePeers(peers PeerConfig) {}
+
+// RemovePeer does nothing, since nodes are addressed by their IDs
+func (t *InProcessTransport) RemovePeer(nodeID uint64) {}
+
+// ForwardBatch proposes a batch on the leader of the shard and returns the
+// proofs of its transactions
+func (t *InProcessTransport) ForwardBatch(ctx context.Context, shardID string, leaderID uint64, batch []byte) ([]*PrepareProof, error) {
+	leader, err := t.remoteShard(shardID, leaderID)
+	if err != nil {
+		return nil, err
+	}
+	proofs, err := leader.proposeForwarded(ctx, batch)
+	if err != nil {
+		return nil, err
+	}
+
+	// Copy the proofs, so that the nodes share no state as over gRPC
+	data, err := json.Marshal(proofs)
+	if err != nil {
+		return nil, err
+	}
+	var copied []*PrepareProof
+	if err := json.Unmarshal(data, &copied); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal proofs from node %d: %v", leaderID, err)
+	}
+	return copied, nil
+}
+
+// CoSignProof asks the given replica of the shard to co-sign the proof
+func (t *InProcessTransport) CoSignProof(ctx context.Context, shardID string, replicaID uint64, proof *PrepareProof) ([]byte, error) {
+	leader, err := t.remoteShard(shardID, replicaID)
+	if err != nil {
+		return nil, err
+	}
+	return leader.coSign(ctx, proof.TxID, proof.CommitIndex, proof.Term)
+}
+
+// remoteShard returns the shard leader of the node, if this node can reach it
+func (t *InProcessTransport) remoteShard(shardID string, nodeID uint64) (*ShardLeader, error) {
+	select {
+	case <-t.stopC:
+		return nil, fmt.Errorf("transport of node %d is stopped", t.nodeID)
+	default:
+	}
+
+	peer, err := t.network.route(t.nodeID, nodeID)
+	if err != nil {
+		return nil, err
+	}
+	peer.leadersMu.RLock()
+	leader, exists := peer.leaders[shardID]
+	peer.leadersMu.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("shard %s not found on node %d", shardID, nodeID)
+	}
+	return leader, nil
+}
+
+// consumeMessages reads outgoing messages from the shard leader and delivers
+// them in order
+func (t *InProcessTransport) consumeMessages(shardID string, leader *ShardLeader) {
+	for {
+		select {
+		case msgs := <-leader.MessagesC():
+			for _, msg := range msgs {
+				t.deliver(shardID, leader, msg)
+			}
+		case <-leader.stopC:
+			return
+		case <-t.stopC:
+			return
+		}
+	}
+}
+
+// deliver steps the message on its recipient and reports failures to the
+// sending shard leader, as the gRPC transport does
+func (t *InProcessTransport) deliver(shardID string, leader *ShardLeader, msg raftpb.Message) {
+	target, err := t.remoteShard(shardID, msg.To)
+	if err == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), inProcessStepTimeout)
+		err = target.Step(ctx, msg)
+		cancel()
+	}
+
+	if msg.Type == raftpb.MsgSnap {
+		status := raft.SnapshotFinish
+		if err != nil {
+			status = raft.SnapshotFailure
+		}
+		leader.ReportSnapshot(msg.To, status)
+	}
+	if err != nil {
+		logger.Debugf("Shard %s: Failed to deliver %s to node %d: %v", shardID, msg.Type, msg.To, err)
+		leader.ReportUnreachable(msg.To)
+	}
+}
@@ -0,0 +1,104 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestInProcessTransport(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	network := NewInProcessNetwork()
+	keys := make(map[uint64]ed25519.PublicKey)
+	shards := make(map[uint64]*ShardLeader)
+	for _, id := range []uint64{1, 2, 3} {
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		gt.Expect(err).NotTo(HaveOccurred())
+		keys[id] = pub
+
+		transport := network.NewTransport(id)
+		defer transport.Stop()
+
+		sl, err := NewShardLeader(ShardConfig{
+			ShardID:    "fabcar",
+			ReplicaIDs: []uint64{1, 2, 3},
+			ReplicaID:  id,
+			SigningKey: priv,
+			QuorumCert: true,
+		}, DefaultBatchTimeout, DefaultBatchMaxSize)
+		gt.Expect(err).NotTo(HaveOccurred())
+		defer sl.Stop()
+		transport.RegisterShard("fabcar", sl)
+		shards[id] = sl
+	}
+
+	leaderOf := func(ids ...uint64) uint64 {
+		for _, id := range ids {
+			if shards[id].GetStatus().IsLeader {
+				return id
+			}
+		}
+		return 0
+	}
+	var leaderID uint64
+	gt.Eventually(func() uint64 {
+		leaderID = leaderOf(1, 2, 3)
+		return leaderID
+	}, 30*time.Second, 100*time.Millisecond).ShouldNot(BeZero())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// The leader collects co-signatures from the other nodes
+	proof, err := shards[leaderID].ProposeAndWait(ctx, &PrepareRequest{
+		TxID:     "tx1",
+		ShardID:  "fabcar",
+		WriteSet: map[string][]byte{"car1": []byte("v1")},
+	})
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(proof.QuorumCert).To(HaveLen(2))
+	gt.Expect(NewProofVerifier(keys, 2).VerifyShardProof(proof)).To(Succeed())
+
+	// A follower forwards its proposals to the leader
+	follower := leaderID%3 + 1
+	gt.Eventually(func() uint64 { return shards[follower].GetStatus().LeaderID }, 5*time.Second).Should(Equal(leaderID))
+	proof, err = shards[follower].ProposeAndWait(ctx, &PrepareRequest{
+		TxID:     "tx2",
+		ShardID:  "fabcar",
+		WriteSet: map[string][]byte{"car1": []byte("v2")},
+	})
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(proof.LeaderID).To(Equal(leaderID))
+	gt.Expect(proof.DependentTxID).To(Equal("tx1"))
+
+	// Once the leader is cut off, the other nodes elect a new one
+	network.Isolate(leaderID)
+	others := []uint64{follower, 6 - leaderID - follower}
+	var newLeaderID uint64
+	gt.Eventually(func() uint64 {
+		newLeaderID = leaderOf(others...)
+		return newLeaderID
+	}, 30*time.Second, 100*time.Millisecond).ShouldNot(BeZero())
+
+	_, err = shards[newLeaderID].ProposeAndWait(ctx, &PrepareRequest{
+		TxID:     "tx3",
+		ShardID:  "fabcar",
+		WriteSet: map[string][]byte{"car2": []byte("v1")},
+	})
+	gt.Expect(err).NotTo(HaveOccurred())
+
+	// Healed, the old leader follows the new one
+	network.Heal(leaderID)
+	gt.Eventually(func() uint64 { return shards[leaderID].GetStatus().LeaderID }, 30*time.Second, 100*time.Millisecond).Should(Equal(newLeaderID))
+}
@@ -0,0 +1,156 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hyperledger/fabric/core/endorser/sharding/protos"
+	"google.golang.org/grpc"
+)
+
+// peerDrainTimeout bounds how long a retired connection is kept open for the
+// sends in flight on it, which take at most as long as a snapshot transfer
+const peerDrainTimeout = snapshotSendTimeout
+
+// peerConn is a connection to a peer together with the sends in flight on it
+type peerConn struct {
+	*grpc.ClientConn
+	sends sync.WaitGroup
+}
+
+// UpdatePeers adds or updates the addresses of the given nodes. Connections
+// to nodes whose address changed are re-established on the next send, and
+// the old ones are closed once their sends completed. Nodes that are not
+// listed are kept, since other shards may still replicate to them.
+func (t *Transport) UpdatePeers(peers PeerConfig) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for id, addr := range peers {
+		if current, ok := t.peers[id]; ok && current == addr {
+			continue
+		}
+		t.retireClientLocked(id)
+		t.peers[id] = addr
+		t.resetBackoff(id)
+		logger.Infof("Transport peer %d is now at %s", id, addr)
+	}
+}
+
+// RemovePeer forgets the node once none of the shards registered with the
+// transport replicates to it any more. Sends in flight to the node complete
+// before its connection is closed, while the messages still queued for it
+// are discarded; later ones fail as to an unknown peer.
+func (t *Transport) RemovePeer(nodeID uint64) {
+	if nodeID == t.nodeID {
+		return
+	}
+	if shardID, ok := t.shardReplicatingTo(nodeID); ok {
+		logger.Infof("Keeping transport peer %d, which still replicates shard %s", nodeID, shardID)
+		return
+	}
+
+	t.mu.Lock()
+	addr, ok := t.peers[nodeID]
+	delete(t.peers, nodeID)
+	t.retireClientLocked(nodeID)
+	t.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	t.stopQueue(nodeID)
+
+	t.healthMu.Lock()
+	delete(t.health, nodeID)
+	t.healthMu.Unlock()
+	logger.Infof("Removed transport peer %d at %s", nodeID, addr)
+}
+
+// shardReplicatingTo returns a registered shard the node is a voter or
+// learner of
+func (t *Transport) shardReplicatingTo(nodeID uint64) (string, bool) {
+	t.leadersMu.RLock()
+	defer t.leadersMu.RUnlock()
+	for shardID, leader := range t.leaders {
+		if leader.isMember(nodeID) {
+			return shardID, true
+		}
+	}
+	return "", false
+}
+
+// learnConfiguredPeers adds the replicas a shard was created with that the
+// transport does not know, e.g. since they were removed from another shard
+func (t *Transport) learnConfiguredPeers(config ShardConfig) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, id := range config.ReplicaIDs {
+		if i >= len(config.ReplicaNodes) || config.ReplicaNodes[i] == "" {
+			break
+		}
+		if _, ok := t.peers[id]; !ok {
+			t.peers[id] = config.ReplicaNodes[i]
+			logger.Infof("Transport peer %d of shard %s is at %s", id, config.ShardID, config.ReplicaNodes[i])
+		}
+	}
+}
+
+// acquireClient returns the client for the node like getClient, and keeps
+// its connection open until the returned release function is called, even
+// if the node moves or is removed in the meantime
+func (t *Transport) acquireClient(nodeID uint64) (protos.ShardCommunicationClient, func(), error) {
+	for {
+		if _, err := t.getClient(nodeID); err != nil {
+			return nil, nil, err
+		}
+
+		t.mu.RLock()
+		conn, ok := t.clientConn[nodeID]
+		client := t.clients[nodeID]
+		if ok {
+			conn.sends.Add(1)
+		}
+		t.mu.RUnlock()
+		// Otherwise the connection was retired after it was dialed
+		if ok {
+			return client, conn.sends.Done, nil
+		}
+	}
+}
+
+// retireClientLocked forgets the connection to the node, so that the next
+// send dials it again, and closes the connection once the sends in flight on
+// it completed. The caller must hold mu.
+func (t *Transport) retireClientLocked(nodeID uint64) bool {
+	conn, ok := t.clientConn[nodeID]
+	if !ok {
+		return false
+	}
+	delete(t.clientConn, nodeID)
+	delete(t.clients, nodeID)
+	go t.drain(nodeID, conn)
+	return true
+}
+
+func (t *Transport) drain(nodeID uint64, conn *peerConn) {
+	drained := make(chan struct{})
+	go func() {
+		conn.sends.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+	case <-time.After(peerDrainTimeout):
+		logger.Warningf("Closing the connection to node %d with sends still in flight", nodeID)
+	case <-t.stopC:
+	}
+	conn.Close()
+}
@@ -0,0 +1,213 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"sync"
+
+	"go.etcd.io/etcd/raft/v3/raftpb"
+)
+
+const (
+	// ShardSendQueueLengthEnvVar bounds the Raft messages queued for each
+	// peer, and ShardSendQueuePolicyEnvVar selects which messages are dropped
+	// when the queue of a slow peer is full: drop-newest or drop-oldest
+	ShardSendQueueLengthEnvVar = "FABRIC_SHARD_SEND_QUEUE_LENGTH"
+	ShardSendQueuePolicyEnvVar = "FABRIC_SHARD_SEND_QUEUE_POLICY"
+
+	DefaultSendQueueLength = 4096
+
+	// DropNewest discards the messages that do not fit into a full queue,
+	// DropOldest makes room for them by discarding the oldest queued ones
+	DropNewest = "drop-newest"
+	DropOldest = "drop-oldest"
+)
+
+// queuedMessage is a Raft message waiting to be sent to a peer
+type queuedMessage struct {
+	shardID string
+	msg     raftpb.Message
+}
+
+// peerQueue holds the messages for one peer, which its sender goroutine
+// sends in order, so that a slow peer only delays the messages sent to it
+type peerQueue struct {
+	mu   sync.Mutex
+	msgs []queuedMessage
+	// readyC is signalled when messages are queued
+	readyC chan struct{}
+	stopC  chan struct{}
+}
+
+// isCriticalMessage reports whether the message keeps leadership stable and
+// must therefore never be dropped
+func isCriticalMessage(msg raftpb.Message) bool {
+	switch msg.Type {
+	case raftpb.MsgHeartbeat, raftpb.MsgHeartbeatResp,
+		raftpb.MsgVote, raftpb.MsgVoteResp,
+		raftpb.MsgPreVote, raftpb.MsgPreVoteResp:
+		return true
+	default:
+		return false
+	}
+}
+
+// validSendQueuePolicy reports whether the transport knows the drop policy
+func validSendQueuePolicy(policy string) bool {
+	return policy == DropNewest || policy == DropOldest
+}
+
+// push queues the messages and returns how many messages were dropped.
+// Critical messages are queued even if the queue is full.
+func (q *peerQueue) push(shardID string, msgs []raftpb.Message, limit int, policy string) int {
+	q.mu.Lock()
+	dropped := 0
+	for _, msg := range msgs {
+		if len(q.msgs) >= limit && !isCriticalMessage(msg) {
+			dropped++
+			if policy != DropOldest || !q.dropOldestLocked() {
+				continue
+			}
+		}
+		q.msgs = append(q.msgs, queuedMessage{shardID: shardID, msg: msg})
+	}
+	q.mu.Unlock()
+
+	select {
+	case q.readyC <- struct{}{}:
+	default:
+	}
+	return dropped
+}
+
+// dropOldestLocked removes the oldest message that is not critical. The
+// caller must hold mu.
+func (q *peerQueue) dropOldestLocked() bool {
+	for i, queued := range q.msgs {
+		if !isCriticalMessage(queued.msg) {
+			q.msgs = append(q.msgs[:i], q.msgs[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// pop removes up to max messages from the front of the queue
+func (q *peerQueue) pop(max int) []queuedMessage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := len(q.msgs)
+	if n > max {
+		n = max
+	}
+	batch := append([]queuedMessage(nil), q.msgs[:n]...)
+	q.msgs = q.msgs[n:]
+	if len(q.msgs) == 0 {
+		q.msgs = nil
+	}
+	return batch
+}
+
+func (q *peerQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.msgs)
+}
+
+// enqueue queues messages of the shard for the peer, starting the peer's
+// sender on first use
+func (t *Transport) enqueue(shardID string, to uint64, msgs []raftpb.Message) {
+	t.mu.RLock()
+	_, known := t.peers[to]
+	t.mu.RUnlock()
+	if !known {
+		logger.Errorf("Failed to send %d messages to node %d: unknown peer %d", len(msgs), to, to)
+		t.reportUnreachable(shardID, to)
+		return
+	}
+
+	t.queuesMu.Lock()
+	q, ok := t.queues[to]
+	if !ok {
+		q = &peerQueue{readyC: make(chan struct{}, 1), stopC: make(chan struct{})}
+		t.queues[to] = q
+		go t.runQueue(to, q)
+	}
+	t.queuesMu.Unlock()
+
+	if dropped := q.push(shardID, msgs, t.config.SendQueueLength, t.config.SendQueuePolicy); dropped > 0 {
+		logger.Debugf("Shard %s: Dropped %d messages to node %d, whose send queue is full", shardID, dropped, to)
+		t.countDropped(to, dropped)
+		t.reportUnreachable(shardID, to)
+	}
+}
+
+// runQueue sends the messages queued for the peer until the peer is removed
+// or the transport stops. Messages that queue up while a request is in
+// flight are coalesced into the next ones.
+func (t *Transport) runQueue(to uint64, q *peerQueue) {
+	for {
+		select {
+		case <-q.readyC:
+		case <-q.stopC:
+			return
+		case <-t.stopC:
+			return
+		}
+		for batch := q.pop(maxCoalescedMessages); len(batch) > 0; batch = q.pop(maxCoalescedMessages) {
+			t.sendQueued(to, batch)
+		}
+	}
+}
+
+// sendQueued sends the messages of each shard in as few Step requests as the
+// size bound allows, keeping their order
+func (t *Transport) sendQueued(to uint64, batch []queuedMessage) {
+	var shards []string
+	byShard := make(map[string][]raftpb.Message)
+	for _, queued := range batch {
+		if _, ok := byShard[queued.shardID]; !ok {
+			shards = append(shards, queued.shardID)
+		}
+		byShard[queued.shardID] = append(byShard[queued.shardID], queued.msg)
+	}
+
+	for _, shardID := range shards {
+		var msgs []raftpb.Message
+		size := 0
+		for _, msg := range byShard[shardID] {
+			if len(msgs) > 0 && size+msg.Size() > maxCoalescedBytes {
+				t.sendMessages(shardID, to, msgs)
+				msgs, size = nil, 0
+			}
+			msgs = append(msgs, msg)
+			size += msg.Size()
+		}
+		t.sendMessages(shardID, to, msgs)
+	}
+}
+
+// stopQueue stops the sender of the peer and discards its queued messages
+func (t *Transport) stopQueue(nodeID uint64) {
+	t.queuesMu.Lock()
+	defer t.queuesMu.Unlock()
+	if q, ok := t.queues[nodeID]; ok {
+		close(q.stopC)
+		delete(t.queues, nodeID)
+	}
+}
+
+// queueLength returns the number of messages queued for the peer
+func (t *Transport) queueLength(nodeID uint64) int {
+	t.queuesMu.Lock()
+	q, ok := t.queues[nodeID]
+	t.queuesMu.Unlock()
+	if !ok {
+		return 0
+	}
+	return q.len()
+}
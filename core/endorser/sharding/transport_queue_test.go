@@ -0,0 +1,102 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"go.etcd.io/etcd/raft/v3/raftpb"
+)
+
+func queuedIndexes(q *peerQueue) []uint64 {
+	var indexes []uint64
+	for _, queued := range q.pop(q.len()) {
+		indexes = append(indexes, queued.msg.Index)
+	}
+	return indexes
+}
+
+func TestPeerQueueDropPolicy(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	app := func(index uint64) raftpb.Message {
+		return raftpb.Message{Type: raftpb.MsgApp, To: 2, Index: index}
+	}
+	heartbeat := raftpb.Message{Type: raftpb.MsgHeartbeat, To: 2, Index: 100}
+	vote := raftpb.Message{Type: raftpb.MsgVote, To: 2, Index: 200}
+
+	q := &peerQueue{readyC: make(chan struct{}, 1)}
+	gt.Expect(q.push("fabcar", []raftpb.Message{app(1), app(2), heartbeat, app(3)}, 2, DropNewest)).To(Equal(1))
+	gt.Expect(queuedIndexes(q)).To(Equal([]uint64{1, 2, 100}))
+
+	gt.Expect(q.push("fabcar", []raftpb.Message{app(1), app(2), heartbeat, app(3)}, 2, DropOldest)).To(Equal(1))
+	gt.Expect(queuedIndexes(q)).To(Equal([]uint64{2, 100, 3}))
+
+	// Heartbeats and votes are never dropped, even to make room
+	gt.Expect(q.push("fabcar", []raftpb.Message{heartbeat, vote, app(1)}, 1, DropOldest)).To(Equal(1))
+	gt.Expect(queuedIndexes(q)).To(Equal([]uint64{100, 200}))
+}
+
+func TestTransportSlowPeerQueue(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	peers := PeerConfig{1: freeTransportAddress(t), 2: freeTransportAddress(t), 3: freeTransportAddress(t)}
+	sender := NewTransportWithConfig(1, peers[1], peers, TransportConfig{SendQueueLength: 4})
+	defer sender.Stop()
+	receiver := NewTransport(3, peers[3], peers)
+	gt.Expect(receiver.Start()).To(Succeed())
+	defer receiver.Stop()
+
+	follower, err := NewShardLeader(ShardConfig{
+		ShardID:    "fabcar",
+		ReplicaIDs: []uint64{1, 3},
+		ReplicaID:  3,
+	}, DefaultBatchTimeout, DefaultBatchMaxSize)
+	gt.Expect(err).NotTo(HaveOccurred())
+	defer follower.Stop()
+	receiver.RegisterShard("fabcar", follower)
+
+	// Node 2 accepts connections but never answers, so that each request to
+	// it runs into its deadline
+	slowAddr, err := parseAndOffsetPort(peers[2], 20000)
+	gt.Expect(err).NotTo(HaveOccurred())
+	lis, err := net.Listen("tcp", slowAddr)
+	gt.Expect(err).NotTo(HaveOccurred())
+	defer lis.Close()
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	app := raftpb.Message{Type: raftpb.MsgApp, From: 1, To: 2, Term: 5}
+	heartbeat := raftpb.Message{Type: raftpb.MsgHeartbeat, From: 1, To: 2, Term: 5}
+	sender.sendBatch("fabcar", []raftpb.Message{app})
+	gt.Eventually(func() int { return sender.PeerStatus()[2].Queued }).Should(BeZero())
+
+	// While the first request is in flight, the messages behind it are
+	// queued up to the limit, except for the heartbeat
+	sender.sendBatch("fabcar", []raftpb.Message{app, app, app, app, app, app, heartbeat})
+	peer := sender.PeerStatus()[2]
+	gt.Expect(peer.Queued).To(Equal(5))
+	gt.Expect(peer.Dropped).To(Equal(uint64(2)))
+
+	// Other peers are not held up by the slow one
+	heartbeat.To = 3
+	sender.sendBatch("fabcar", []raftpb.Message{heartbeat})
+	gt.Eventually(func() uint64 { return follower.GetStatus().LeaderID }, 400*time.Millisecond, 10*time.Millisecond).Should(Equal(uint64(1)))
+
+	gt.Eventually(func() int { return sender.PeerStatus()[2].Queued }, 5*time.Second).Should(BeZero())
+	gt.Expect(sender.PeerStatus()[2].SendFailures).To(BeNumerically(">", 0))
+}
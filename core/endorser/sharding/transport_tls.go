@@ -0,0 +1,205 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+const (
+	// ShardTLSEnabledEnvVar makes the shard transport serve and dial with TLS
+	// when set to true
+	ShardTLSEnabledEnvVar = "FABRIC_SHARD_TLS_ENABLED"
+	// ShardTLSCertEnvVar and ShardTLSKeyEnvVar are the PEM files of the
+	// certificate the transport presents. They default to the TLS
+	// certificate of the peer.
+	ShardTLSCertEnvVar = "FABRIC_SHARD_TLS_CERT"
+	ShardTLSKeyEnvVar  = "FABRIC_SHARD_TLS_KEY"
+	// ShardTLSRootCAsEnvVar lists the comma-separated PEM files of the CAs
+	// the certificates of other replicas are verified against. It defaults to
+	// the TLS root certificate of the peer and the TLS CAs of its MSP.
+	ShardTLSRootCAsEnvVar = "FABRIC_SHARD_TLS_ROOTCAS"
+	// ShardTLSClientAuthEnvVar makes the transport require and verify client
+	// certificates (mutual TLS) when set to true
+	ShardTLSClientAuthEnvVar = "FABRIC_SHARD_TLS_CLIENT_AUTH"
+	// ShardTLSServerNamesEnvVar overrides the name expected in the
+	// certificate of a replica, as comma-separated id=name pairs
+	ShardTLSServerNamesEnvVar = "FABRIC_SHARD_TLS_SERVER_NAMES"
+)
+
+// TransportTLS holds the certificates the shard transport serves and dials
+// with
+type TransportTLS struct {
+	Certificate tls.Certificate
+	RootCAs     *x509.CertPool
+	// RequireClientCert makes the server verify the certificate of every
+	// replica that connects to it
+	RequireClientCert bool
+	// ServerNames overrides, per replica ID, the name the certificate of the
+	// replica is verified for. The host of its address is used otherwise.
+	ServerNames map[uint64]string
+}
+
+// LoadTransportTLS loads the certificate and key of the transport and the
+// CAs it trusts from PEM files
+func LoadTransportTLS(certFile, keyFile string, rootCAFiles []string) (*TransportTLS, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load shard TLS key pair %s: %v", certFile, err)
+	}
+	if len(rootCAFiles) == 0 {
+		return nil, fmt.Errorf("no shard TLS root CAs configured")
+	}
+	roots := x509.NewCertPool()
+	for _, file := range rootCAFiles {
+		pem, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read shard TLS root CA: %v", err)
+		}
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in shard TLS root CA %s", file)
+		}
+	}
+	return &TransportTLS{
+		Certificate: cert,
+		RootCAs:     roots,
+		ServerNames: make(map[uint64]string),
+	}, nil
+}
+
+// TransportTLSFromEnv loads the TLS configuration of the transport from the
+// FABRIC_SHARD_TLS_* variables, falling back to the TLS settings of the peer.
+// It returns nil when ShardTLSEnabledEnvVar is not set.
+func TransportTLSFromEnv() (*TransportTLS, error) {
+	if !envBool(ShardTLSEnabledEnvVar) {
+		return nil, nil
+	}
+
+	certFile := envOr(ShardTLSCertEnvVar, os.Getenv("CORE_PEER_TLS_CERT_FILE"))
+	keyFile := envOr(ShardTLSKeyEnvVar, os.Getenv("CORE_PEER_TLS_KEY_FILE"))
+	rootCAFiles := splitList(os.Getenv(ShardTLSRootCAsEnvVar))
+	if len(rootCAFiles) == 0 {
+		rootCAFiles = peerTLSRootCAs()
+	}
+
+	config, err := LoadTransportTLS(certFile, keyFile, rootCAFiles)
+	if err != nil {
+		return nil, err
+	}
+	config.RequireClientCert = envBool(ShardTLSClientAuthEnvVar)
+	config.ServerNames, err = parseServerNames(os.Getenv(ShardTLSServerNamesEnvVar))
+	if err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// peerTLSRootCAs returns the TLS root certificate of the peer and the TLS
+// CAs of its local MSP
+func peerTLSRootCAs() []string {
+	var files []string
+	if file := os.Getenv("CORE_PEER_TLS_ROOTCERT_FILE"); file != "" {
+		files = append(files, file)
+	}
+	if dir := os.Getenv("CORE_PEER_MSPCONFIGPATH"); dir != "" {
+		msp, _ := filepath.Glob(filepath.Join(dir, "tlscacerts", "*"))
+		files = append(files, msp...)
+	}
+	return files
+}
+
+// parseServerNames parses comma-separated id=name pairs
+func parseServerNames(value string) (map[uint64]string, error) {
+	names := make(map[uint64]string)
+	for _, pair := range splitList(value) {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, fmt.Errorf("invalid %s entry %q", ShardTLSServerNamesEnvVar, pair)
+		}
+		id, err := strconv.ParseUint(parts[0], 10, 64)
+		if err != nil || id == 0 {
+			return nil, fmt.Errorf("invalid replica ID in %s entry %q", ShardTLSServerNamesEnvVar, pair)
+		}
+		names[id] = parts[1]
+	}
+	return names, nil
+}
+
+func envBool(name string) bool {
+	value, _ := strconv.ParseBool(strings.TrimSpace(os.Getenv(name)))
+	return value
+}
+
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// withServerNames returns a copy of the configuration that also overrides
+// the server names of the given replicas, unless it already does
+func (t *TransportTLS) withServerNames(names map[uint64]string) *TransportTLS {
+	config := *t
+	config.ServerNames = make(map[uint64]string, len(t.ServerNames)+len(names))
+	for id, name := range names {
+		config.ServerNames[id] = name
+	}
+	for id, name := range t.ServerNames {
+		config.ServerNames[id] = name
+	}
+	return &config
+}
+
+// serverCredentials returns the credentials the transport serves with
+func (t *TransportTLS) serverCredentials() credentials.TransportCredentials {
+	if t == nil {
+		return insecure.NewCredentials()
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{t.Certificate},
+		ClientCAs:    t.RootCAs,
+		MinVersion:   tls.VersionTLS12,
+	}
+	if t.RequireClientCert {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return credentials.NewTLS(config)
+}
+
+// clientCredentials returns the credentials to dial the given replica with.
+// Replica 0 stands for a peer that is not a replica, such as the registry.
+func (t *TransportTLS) clientCredentials(nodeID uint64) credentials.TransportCredentials {
+	if t == nil {
+		return insecure.NewCredentials()
+	}
+	return credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{t.Certificate},
+		RootCAs:      t.RootCAs,
+		ServerName:   t.ServerNames[nodeID],
+		MinVersion:   tls.VersionTLS12,
+	})
+}
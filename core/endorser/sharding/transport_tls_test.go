@@ -0,0 +1,146 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/common/crypto/tlsgen"
+	"github.com/hyperledger/fabric/core/endorser/sharding/protos"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// writeCertKeyPair writes the PEM files of the pair to dir and returns their
+// paths
+func writeCertKeyPair(gt *GomegaWithT, dir, name string, pair *tlsgen.CertKeyPair) (string, string) {
+	certFile := filepath.Join(dir, name+"-cert.pem")
+	keyFile := filepath.Join(dir, name+"-key.pem")
+	gt.Expect(os.WriteFile(certFile, pair.Cert, 0o600)).To(Succeed())
+	gt.Expect(os.WriteFile(keyFile, pair.Key, 0o600)).To(Succeed())
+	return certFile, keyFile
+}
+
+// newTestTransportTLS issues a certificate for hosts from ca and loads it
+// with ca as the only trusted root
+func newTestTransportTLS(gt *GomegaWithT, dir, name string, ca tlsgen.CA, hosts ...string) *TransportTLS {
+	pair, err := ca.NewServerCertKeyPair(hosts...)
+	gt.Expect(err).NotTo(HaveOccurred())
+	certFile, keyFile := writeCertKeyPair(gt, dir, name, pair)
+	caFile := filepath.Join(dir, name+"-ca.pem")
+	gt.Expect(os.WriteFile(caFile, ca.CertBytes(), 0o600)).To(Succeed())
+
+	config, err := LoadTransportTLS(certFile, keyFile, []string{caFile})
+	gt.Expect(err).NotTo(HaveOccurred())
+	return config
+}
+
+func TestTransportMutualTLS(t *testing.T) {
+	gt := NewGomegaWithT(t)
+	dir := t.TempDir()
+
+	ca, err := tlsgen.NewCA()
+	gt.Expect(err).NotTo(HaveOccurred())
+	otherCA, err := tlsgen.NewCA()
+	gt.Expect(err).NotTo(HaveOccurred())
+
+	peers := PeerConfig{1: freeTransportAddress(t), 2: freeTransportAddress(t)}
+
+	// The receiver's certificate does not name its address
+	receiverTLS := newTestTransportTLS(gt, dir, "receiver", ca, "shard.example.com")
+	receiverTLS.RequireClientCert = true
+	receiver := NewTransport(2, peers[2], peers)
+	receiver.UseTLS(receiverTLS)
+	gt.Expect(receiver.Start()).To(Succeed())
+	defer receiver.Stop()
+
+	coSign := func(config *TransportTLS) error {
+		sender := NewTransport(1, peers[1], peers)
+		sender.UseTLS(config)
+		client, err := sender.getClient(2)
+		gt.Expect(err).NotTo(HaveOccurred())
+		defer sender.Stop()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		resp, err := client.CoSign(ctx, &protos.CoSignRequest{TxId: "tx1"})
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("%s", resp.Error)
+	}
+
+	// Reaching the handler, which rejects the request for lack of a shard,
+	// shows that the handshake succeeded
+	senderTLS := newTestTransportTLS(gt, dir, "sender", ca, "127.0.0.1")
+	senderTLS.ServerNames[2] = "shard.example.com"
+	gt.Eventually(func() error { return coSign(senderTLS) }, 10*time.Second, 100*time.Millisecond).Should(MatchError(ContainSubstring("missing shard-id")))
+
+	// Without the override the certificate is verified for the address
+	noOverride := newTestTransportTLS(gt, dir, "no-override", ca, "127.0.0.1")
+	gt.Expect(status.Code(coSign(noOverride))).To(Equal(codes.Unavailable))
+
+	// Replicas must present a certificate of a trusted CA
+	untrusted := newTestTransportTLS(gt, dir, "untrusted", otherCA, "127.0.0.1")
+	untrusted.RootCAs = senderTLS.RootCAs
+	untrusted.ServerNames[2] = "shard.example.com"
+	gt.Expect(status.Code(coSign(untrusted))).To(Equal(codes.Unavailable))
+
+	gt.Expect(status.Code(coSign(nil))).To(Equal(codes.Unavailable))
+}
+
+func TestTransportTLSFromEnv(t *testing.T) {
+	gt := NewGomegaWithT(t)
+	dir := t.TempDir()
+
+	ca, err := tlsgen.NewCA()
+	gt.Expect(err).NotTo(HaveOccurred())
+	pair, err := ca.NewServerCertKeyPair("127.0.0.1")
+	gt.Expect(err).NotTo(HaveOccurred())
+	certFile, keyFile := writeCertKeyPair(gt, dir, "peer", pair)
+	mspCAs := filepath.Join(dir, "msp", "tlscacerts")
+	gt.Expect(os.MkdirAll(mspCAs, 0o700)).To(Succeed())
+	gt.Expect(os.WriteFile(filepath.Join(mspCAs, "ca.pem"), ca.CertBytes(), 0o600)).To(Succeed())
+
+	config, err := TransportTLSFromEnv()
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(config).To(BeNil())
+
+	// The transport falls back to the TLS identity of the peer and its MSP
+	t.Setenv(ShardTLSEnabledEnvVar, "true")
+	t.Setenv("CORE_PEER_TLS_CERT_FILE", certFile)
+	t.Setenv("CORE_PEER_TLS_KEY_FILE", keyFile)
+	t.Setenv("CORE_PEER_MSPCONFIGPATH", filepath.Join(dir, "msp"))
+	t.Setenv(ShardTLSClientAuthEnvVar, "true")
+	t.Setenv(ShardTLSServerNamesEnvVar, "1=peer0.org1.example.com, 2=peer1.org1.example.com")
+
+	config, err = TransportTLSFromEnv()
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(config.RequireClientCert).To(BeTrue())
+	gt.Expect(config.ServerNames).To(Equal(map[uint64]string{1: "peer0.org1.example.com", 2: "peer1.org1.example.com"}))
+	gt.Expect(config.Certificate.Certificate).To(HaveLen(1))
+
+	// Names from the environment take precedence over the topology
+	merged := config.withServerNames(map[uint64]string{2: "other.example.com", 3: "peer2.org1.example.com"})
+	gt.Expect(merged.ServerNames).To(Equal(map[uint64]string{1: "peer0.org1.example.com", 2: "peer1.org1.example.com", 3: "peer2.org1.example.com"}))
+	gt.Expect(config.ServerNames).To(HaveLen(2))
+
+	t.Setenv(ShardTLSServerNamesEnvVar, "peer0.org1.example.com")
+	_, err = TransportTLSFromEnv()
+	gt.Expect(err).To(MatchError(ContainSubstring(ShardTLSServerNamesEnvVar)))
+
+	t.Setenv(ShardTLSServerNamesEnvVar, "")
+	t.Setenv("CORE_PEER_MSPCONFIGPATH", "")
+	_, err = TransportTLSFromEnv()
+	gt.Expect(err).To(MatchError("no shard TLS root CAs configured"))
+}
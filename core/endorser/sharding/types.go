@@ -10,18 +10,36 @@ import (
 	"encoding/json"
 )
 
-// PrepareRequestProto represents a serialized prepare request
+// PrepareRequestProto represents a serialized prepare request. Timestamp is in
+// Unix nanoseconds and orders transactions under the wound-wait conflict policy.
+// ExpiresAt, also in Unix nanoseconds, is when the reservations of the request
+// may be garbage collected.
 type PrepareRequestProto struct {
 	TxID      string
 	ShardID   string
 	ReadSet   map[string][]byte
 	WriteSet  map[string][]byte
 	Timestamp int64
+	ExpiresAt int64 `json:",omitempty"`
+	// RangeReads are checked like the ReadSet, and ReservedRanges are
+	// reserved like the WriteSet
+	RangeReads     []KeyRange `json:",omitempty"`
+	ReservedRanges []KeyRange `json:",omitempty"`
+	// HLC is the reading of the leader's hybrid logical clock when it
+	// proposed the request, after the requests it proposed before
+	HLC HLCTimestamp
+	// Origin is the ID of the endorser that prepared the request
+	Origin string `json:",omitempty"`
 }
 
-// PrepareRequestBatch represents a batch of prepare requests
+// PrepareRequestBatch represents a batch of prepare requests. Aborts are
+// replicated in the same envelope so every replica releases them in log order.
 type PrepareRequestBatch struct {
 	Requests []*PrepareRequestProto
+	Aborts   []*AbortEntry  `json:",omitempty"`
+	GC       *GCEntry       `json:",omitempty"`
+	Expire   *ExpireEntry   `json:",omitempty"`
+	Decision *DecisionEntry `json:",omitempty"`
 }
 
 // AbortEntry represents a transaction abort entry
@@ -30,6 +48,12 @@ type AbortEntry struct {
 	Timestamp int64
 }
 
+// GCEntry prunes the dependencies that expired by Now, the leader's clock in
+// Unix nanoseconds when it proposed the entry
+type GCEntry struct {
+	Now int64
+}
+
 // Marshal serializes the batch to JSON
 func (b *PrepareRequestBatch) Marshal() ([]byte, error) {
 	return json.Marshal(b)
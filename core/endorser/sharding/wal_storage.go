@@ -0,0 +1,263 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"go.etcd.io/etcd/raft/v3"
+	"go.etcd.io/etcd/raft/v3/raftpb"
+	"go.etcd.io/etcd/server/v3/etcdserver/api/snap"
+	"go.etcd.io/etcd/server/v3/wal"
+	"go.etcd.io/etcd/server/v3/wal/walpb"
+)
+
+// ShardDataDirEnvVar sets the directory under which shards persist their Raft
+// log. Shards keep their log in memory only when it is unset.
+const ShardDataDirEnvVar = "FABRIC_SHARD_DATA_DIR"
+
+// maxShardSnapshotFiles is the number of snapshot files retained on disk.
+// Older snapshots and the WAL segments they cover are purged.
+const maxShardSnapshotFiles = 4
+
+// shardStorage persists the Raft log of a shard in an etcd write-ahead log,
+// with snapshots alongside it, and mirrors it into the MemoryStorage that
+// raft reads from
+type shardStorage struct {
+	walDir  string
+	snapDir string
+	ram     *raft.MemoryStorage
+	wal     *wal.WAL
+	snap    *snap.Snapshotter
+
+	// mu serializes the writes of runRaft and the snapshots of runApply
+	mu sync.Mutex
+}
+
+// openShardStorage opens the WAL of the shard under dataDir, creating it if
+// needed, and loads the latest snapshot, hard state and entries into ram. It
+// returns the loaded snapshot, if any, and reports whether Raft state was
+// found, in which case the node must be restarted rather than bootstrapped.
+func openShardStorage(dataDir, shardID string, ram *raft.MemoryStorage) (*shardStorage, *raftpb.Snapshot, bool, error) {
+	walDir := filepath.Join(dataDir, shardID, "wal")
+	snapDir := filepath.Join(dataDir, shardID, "snap")
+
+	if err := os.MkdirAll(snapDir, 0o755); err != nil {
+		return nil, nil, false, fmt.Errorf("failed to create data dir of shard %s: %v", shardID, err)
+	}
+	snapshotter := snap.New(logger.Zap(), snapDir)
+	snapshot, err := snapshotter.Load()
+	if err != nil && err != snap.ErrNoSnapshot {
+		return nil, nil, false, fmt.Errorf("failed to load snapshot of shard %s: %v", shardID, err)
+	}
+
+	if !wal.Exist(walDir) {
+		logger.Infof("Shard %s: No WAL found, creating new WAL at %s", shardID, walDir)
+		w, err := wal.Create(logger.Zap(), walDir, nil)
+		if err != nil {
+			return nil, nil, false, fmt.Errorf("failed to create WAL of shard %s: %v", shardID, err)
+		}
+		if err := w.Close(); err != nil {
+			return nil, nil, false, fmt.Errorf("failed to close new WAL of shard %s: %v", shardID, err)
+		}
+	} else {
+		logger.Infof("Shard %s: Found WAL at %s, replaying it", shardID, walDir)
+	}
+
+	walsnap := walpb.Snapshot{}
+	if snapshot != nil {
+		walsnap.Index, walsnap.Term = snapshot.Metadata.Index, snapshot.Metadata.Term
+		if err := ram.ApplySnapshot(*snapshot); err != nil {
+			return nil, nil, false, fmt.Errorf("failed to apply snapshot of shard %s: %v", shardID, err)
+		}
+	}
+
+	w, st, ents, err := readShardWAL(walDir, walsnap)
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("failed to read WAL of shard %s: %v", shardID, err)
+	}
+
+	ram.SetHardState(st) // MemoryStorage.SetHardState always returns nil
+	ram.Append(ents)     // MemoryStorage.Append always returns nil
+
+	restart := snapshot != nil || !raft.IsEmptyHardState(st) || len(ents) > 0
+	if restart {
+		logger.Infof("Shard %s: Loaded %d entries after index %d at term %d, commit %d from disk",
+			shardID, len(ents), walsnap.Index, st.Term, st.Commit)
+	}
+
+	s := &shardStorage{walDir: walDir, snapDir: snapDir, ram: ram, wal: w, snap: snapshotter}
+	return s, snapshot, restart, nil
+}
+
+// readShardWAL opens the WAL for appending after reading all of it from the
+// given snapshot on, repairing a torn final record once
+func readShardWAL(walDir string, walsnap walpb.Snapshot) (*wal.WAL, raftpb.HardState, []raftpb.Entry, error) {
+	repaired := false
+	for {
+		w, err := wal.Open(logger.Zap(), walDir, walsnap)
+		if err != nil {
+			return nil, raftpb.HardState{}, nil, err
+		}
+
+		_, st, ents, err := w.ReadAll()
+		if err == nil {
+			return w, st, ents, nil
+		}
+
+		w.Close()
+		if repaired || err != io.ErrUnexpectedEOF || !wal.Repair(logger.Zap(), walDir) {
+			return nil, raftpb.HardState{}, nil, err
+		}
+		logger.Warnf("Repaired torn WAL at %s", walDir)
+		repaired = true
+	}
+}
+
+// store persists the hard state and entries before they are made visible to raft
+func (s *shardStorage) store(hardState raftpb.HardState, entries []raftpb.Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.wal.Save(hardState, entries); err != nil {
+		return err
+	}
+	return s.ram.Append(entries)
+}
+
+// saveSnap persists a snapshot and purges the files it makes obsolete. The
+// snapshot index is recorded in the WAL first, so the WAL is only ever opened
+// at an index it has seen.
+func (s *shardStorage) saveSnap(snapshot raftpb.Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	walsnap := walpb.Snapshot{
+		Index:     snapshot.Metadata.Index,
+		Term:      snapshot.Metadata.Term,
+		ConfState: &snapshot.Metadata.ConfState,
+	}
+	if err := s.wal.SaveSnapshot(walsnap); err != nil {
+		return fmt.Errorf("failed to save snapshot to WAL: %v", err)
+	}
+	if err := s.snap.SaveSnap(snapshot); err != nil {
+		return fmt.Errorf("failed to save snapshot: %v", err)
+	}
+	if err := s.wal.ReleaseLockTo(snapshot.Metadata.Index); err != nil {
+		return err
+	}
+
+	s.gc()
+	return nil
+}
+
+// gc removes all but the newest maxShardSnapshotFiles snapshots along with
+// the WAL segments that only hold entries preceding the oldest of them
+func (s *shardStorage) gc() {
+	snaps := s.filesWithSuffix(s.snapDir, ".snap")
+	if len(snaps) <= maxShardSnapshotFiles {
+		return
+	}
+	purged := snaps[:len(snaps)-maxShardSnapshotFiles]
+
+	var retain uint64
+	var term uint64
+	fmt.Sscanf(filepath.Base(snaps[len(purged)]), "%016x-%016x.snap", &term, &retain)
+
+	var walFiles []string
+	for _, f := range s.filesWithSuffix(s.walDir, ".wal") {
+		var seq, index uint64
+		fmt.Sscanf(filepath.Base(f), "%016x-%016x.wal", &seq, &index)
+		if index >= retain {
+			break
+		}
+		walFiles = append(walFiles, f)
+	}
+	// the last segment starting before the snapshot still holds entries after it
+	if len(walFiles) > 1 {
+		purged = append(purged, walFiles[:len(walFiles)-1]...)
+	}
+
+	for _, f := range purged {
+		if err := os.Remove(f); err != nil {
+			logger.Errorf("Failed to purge %s: %v", f, err)
+		}
+	}
+}
+
+func (s *shardStorage) filesWithSuffix(dir, suffix string) []string {
+	files, err := filepath.Glob(filepath.Join(dir, "*"+suffix))
+	if err != nil {
+		logger.Errorf("Failed to list %s: %v", dir, err)
+		return nil
+	}
+	sort.Strings(files)
+	return files
+}
+
+func (s *shardStorage) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.wal.Close()
+}
+
+// persistedShards returns the IDs of the shards that have a WAL under dataDir.
+// The shards of a channel persist theirs under the directory of the channel.
+func persistedShards(dataDir string) ([]string, error) {
+	dirs, err := os.ReadDir(dataDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var shardIDs []string
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+		if wal.Exist(filepath.Join(dataDir, dir.Name(), "wal")) {
+			shardIDs = append(shardIDs, dir.Name())
+			continue
+		}
+		channelDirs, err := os.ReadDir(filepath.Join(dataDir, dir.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, channelDir := range channelDirs {
+			if channelDir.IsDir() && wal.Exist(filepath.Join(dataDir, dir.Name(), channelDir.Name(), "wal")) {
+				shardIDs = append(shardIDs, ChannelShardID(dir.Name(), channelDir.Name()))
+			}
+		}
+	}
+	return shardIDs, nil
+}
+
+// recoverShards restarts the shards that persisted state under the data dir,
+// so that their reservations are in force before new proposals arrive
+func (sm *ShardManager) recoverShards() {
+	dataDir := sm.shardDataDir()
+	if dataDir == "" {
+		return
+	}
+	shardIDs, err := persistedShards(dataDir)
+	if err != nil {
+		logger.Errorf("Failed to list the shards persisted in %s: %v", dataDir, err)
+		return
+	}
+	for _, shardID := range shardIDs {
+		if _, err := sm.GetOrCreateShard(shardID); err != nil {
+			logger.Errorf("Failed to recover shard %s: %v", shardID, err)
+			continue
+		}
+		logger.Infof("Recovered shard %s from %s", shardID, dataDir)
+	}
+}
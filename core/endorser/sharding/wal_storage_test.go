@@ -0,0 +1,205 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package sharding
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"go.etcd.io/etcd/raft/v3"
+	"go.etcd.io/etcd/raft/v3/raftpb"
+)
+
+// testShardEntries returns the log of a single-replica shard in which each
+// transaction writes its own key "fabcar:<txID>"
+func testShardEntries(gt *GomegaWithT, txIDs ...string) []raftpb.Entry {
+	cc := raftpb.ConfChange{Type: raftpb.ConfChangeAddNode, NodeID: 1}
+	ccData, err := cc.Marshal()
+	gt.Expect(err).NotTo(HaveOccurred())
+	entries := []raftpb.Entry{{Term: 1, Index: 1, Type: raftpb.EntryConfChange, Data: ccData}}
+
+	for i, txID := range txIDs {
+		batch := &PrepareRequestBatch{Requests: []*PrepareRequestProto{{
+			TxID:     txID,
+			ShardID:  "fabcar",
+			WriteSet: map[string][]byte{"fabcar:" + txID: []byte("v1")},
+		}}}
+		data, err := batch.Marshal()
+		gt.Expect(err).NotTo(HaveOccurred())
+		entries = append(entries, raftpb.Entry{Term: 1, Index: uint64(i + 2), Type: raftpb.EntryNormal, Data: data})
+	}
+	return entries
+}
+
+func TestShardRestartReplaysWAL(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	dataDir, err := ioutil.TempDir("", "shard-wal")
+	gt.Expect(err).NotTo(HaveOccurred())
+	defer os.RemoveAll(dataDir)
+
+	// a fresh data dir bootstraps the node
+	s, _, restart, err := openShardStorage(dataDir, "fabcar", raft.NewMemoryStorage())
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(restart).To(BeFalse())
+
+	gt.Expect(s.store(raftpb.HardState{Term: 1, Vote: 1, Commit: 2}, testShardEntries(gt, "tx1"))).To(Succeed())
+	gt.Expect(s.close()).To(Succeed())
+
+	config := ShardConfig{ShardID: "fabcar", ReplicaIDs: []uint64{1}, ReplicaID: 1, DataDir: dataDir}
+
+	// restored backups must not be layered on top of persisted state
+	_, err = newShardLeader(config, DefaultBatchTimeout, DefaultBatchMaxSize, map[string]TransactionDependencyInfo{"k": {}})
+	gt.Expect(err).To(MatchError("shard fabcar already has persisted state in " + dataDir))
+
+	sl, err := NewShardLeader(config, DefaultBatchTimeout, DefaultBatchMaxSize)
+	gt.Expect(err).NotTo(HaveOccurred())
+	defer sl.Stop()
+
+	// committed entries are re-applied without waiting for a leader
+	gt.Eventually(func() string {
+		read, err := sl.GetDependency("fabcar:tx1", StalenessBound{})
+		if err != nil || !read.Found {
+			return ""
+		}
+		return read.Info.DependentTxID
+	}, 5*time.Second, 10*time.Millisecond).Should(Equal("tx1"))
+	gt.Expect(sl.HasProof("tx1")).To(BeTrue())
+	gt.Expect(sl.node.Status().Config.Voters.IDs()).To(HaveKey(uint64(1)))
+}
+
+func TestShardSnapshotAndCompaction(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	dataDir, err := ioutil.TempDir("", "shard-snap")
+	gt.Expect(err).NotTo(HaveOccurred())
+	defer os.RemoveAll(dataDir)
+
+	s, _, _, err := openShardStorage(dataDir, "fabcar", raft.NewMemoryStorage())
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(s.store(raftpb.HardState{Term: 1, Vote: 1, Commit: 4}, testShardEntries(gt, "tx1", "tx2", "tx3"))).To(Succeed())
+	gt.Expect(s.close()).To(Succeed())
+
+	config := ShardConfig{
+		ShardID:                "fabcar",
+		ReplicaIDs:             []uint64{1},
+		ReplicaID:              1,
+		DataDir:                dataDir,
+		SnapshotInterval:       3,
+		SnapshotCatchUpEntries: 1,
+	}
+	sl, err := NewShardLeader(config, DefaultBatchTimeout, DefaultBatchMaxSize)
+	gt.Expect(err).NotTo(HaveOccurred())
+
+	// replaying four entries crosses the snapshot interval
+	gt.Eventually(func() ([]string, error) {
+		return filepath.Glob(filepath.Join(dataDir, "fabcar", "snap", "*.snap"))
+	}, 5*time.Second, 10*time.Millisecond).Should(HaveLen(1))
+	sl.Stop()
+
+	firstIndex, err := sl.storage.FirstIndex()
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(firstIndex).To(Equal(uint64(4)))
+
+	// the restarted replica starts from the snapshot
+	sl, err = NewShardLeader(config, DefaultBatchTimeout, DefaultBatchMaxSize)
+	gt.Expect(err).NotTo(HaveOccurred())
+	defer sl.Stop()
+
+	gt.Expect(sl.snapshotIndex).To(Equal(uint64(4)))
+	gt.Expect(sl.confState.Voters).To(ConsistOf(uint64(1)))
+	for _, txID := range []string{"tx1", "tx2", "tx3"} {
+		read, err := sl.GetDependency("fabcar:"+txID, StalenessBound{})
+		gt.Expect(err).NotTo(HaveOccurred())
+		gt.Expect(read.Info.DependentTxID).To(Equal(txID))
+	}
+}
+
+func TestShardRestartRecoversDependencies(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	dataDir, err := ioutil.TempDir("", "shard-wal")
+	gt.Expect(err).NotTo(HaveOccurred())
+	defer os.RemoveAll(dataDir)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	start := func(ttl time.Duration) *ShardLeader {
+		sl, err := NewShardLeader(ShardConfig{
+			ShardID:       "fabcar",
+			ReplicaIDs:    []uint64{1},
+			ReplicaID:     1,
+			DataDir:       dataDir,
+			DependencyTTL: ttl,
+			GCInterval:    time.Hour,
+		}, DefaultBatchTimeout, DefaultBatchMaxSize)
+		gt.Expect(err).NotTo(HaveOccurred())
+		return sl
+	}
+	prepare := func(sl *ShardLeader, txID string) {
+		gt.Eventually(func() bool { return sl.GetStatus().IsLeader }, 30*time.Second, 100*time.Millisecond).Should(BeTrue())
+		_, err := sl.ProposeAndWait(ctx, &PrepareRequest{
+			TxID:      txID,
+			ShardID:   "fabcar",
+			WriteSet:  map[string][]byte{"fabcar:" + txID: []byte("v1")},
+			Timestamp: Clock().Now(),
+		})
+		gt.Expect(err).NotTo(HaveOccurred())
+	}
+	dependencies := func(sl *ShardLeader) func() map[string]TransactionDependencyInfo {
+		return func() map[string]TransactionDependencyInfo { return sl.Dependencies() }
+	}
+
+	sl := start(time.Second)
+	prepare(sl, "tx1")
+	sl.Stop()
+
+	// the reservation expires while the shard is down; it is reloaded and
+	// then pruned as soon as the shard elects a leader, well before the next
+	// GC tick
+	sl = start(time.Hour)
+	gt.Eventually(dependencies(sl), 5*time.Second, 10*time.Millisecond).Should(HaveKey("fabcar:tx1"))
+	gt.Eventually(dependencies(sl), 30*time.Second, 100*time.Millisecond).Should(BeEmpty())
+	gt.Expect(sl.GetStatus().ExpiredDependencies).To(Equal(uint64(1)))
+
+	prepare(sl, "tx2")
+	sl.Stop()
+
+	// live reservations survive a restart
+	sl = start(time.Hour)
+	defer sl.Stop()
+	gt.Eventually(dependencies(sl), 5*time.Second, 10*time.Millisecond).Should(HaveKey("fabcar:tx2"))
+	gt.Expect(sl.Dependencies()).To(HaveLen(1))
+}
+
+func TestPersistedShards(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	dataDir, err := ioutil.TempDir("", "shard-wal")
+	gt.Expect(err).NotTo(HaveOccurred())
+	defer os.RemoveAll(dataDir)
+
+	shardIDs, err := persistedShards(filepath.Join(dataDir, "missing"))
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(shardIDs).To(BeEmpty())
+
+	for _, shardID := range []string{"fabcar", "marbles", ChannelShardID("mychannel", "fabcar")} {
+		s, _, _, err := openShardStorage(dataDir, shardID, raft.NewMemoryStorage())
+		gt.Expect(err).NotTo(HaveOccurred())
+		gt.Expect(s.close()).To(Succeed())
+	}
+	gt.Expect(os.MkdirAll(filepath.Join(dataDir, "other"), 0o755)).To(Succeed())
+
+	shardIDs, err = persistedShards(dataDir)
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(shardIDs).To(ConsistOf("fabcar", "marbles", "mychannel/fabcar"))
+}
@@ -0,0 +1,114 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package endorser
+
+import "strings"
+
+// ShardingPolicy selects the proposals whose dependencies are resolved
+// through the shards. The other proposals are endorsed as in plain Fabric,
+// without a prepare round, so that contracts with little contention do not
+// pay for it.
+type ShardingPolicy struct {
+	// Channels limits sharding to the listed channels. All channels are
+	// sharded when it is empty.
+	Channels []string
+	// Chaincodes limits sharding to the listed chaincodes. An entry is
+	// either a chaincode name or channel/chaincode to name the chaincode of
+	// one channel. All chaincodes are sharded when it is empty.
+	Chaincodes []string
+	// ExcludeChaincodes lists chaincodes, in the form of Chaincodes, that
+	// are never sharded
+	ExcludeChaincodes []string
+	// Untracked lists the keys of sharded proposals that are left out of
+	// their dependencies
+	Untracked UntrackedKeys
+}
+
+// UntrackedKeys lists keys that are neither prepared on the shards nor
+// reported as dependencies, such as high-churn counters that every
+// invocation writes and that would otherwise make each proposal depend on
+// the previous one. A proposal whose writes are all untracked is prepared as
+// read-only.
+type UntrackedKeys struct {
+	// Namespaces lists chaincodes, in the form of Chaincodes, none of whose
+	// keys are tracked, even when another chaincode reads or writes them
+	Namespaces []string
+	// Collections lists private data collections as chaincode:collection,
+	// optionally qualified as channel/chaincode:collection
+	Collections []string
+	// KeyPrefixes lists key prefixes as chaincode:prefix, optionally
+	// qualified as channel/chaincode:prefix. A prefix applies to the public
+	// keys of the chaincode and to those of its collections.
+	KeyPrefixes []string
+}
+
+// tracks reports whether the key of the namespace on the channel is
+// tracked. The collection is empty for public keys.
+func (u UntrackedKeys) tracks(channelID, namespace, collection, key string) bool {
+	if matchesChaincode(u.Namespaces, channelID, namespace) {
+		return false
+	}
+	if collection != "" {
+		for _, entry := range u.Collections {
+			if entry, ok := unqualified(entry, channelID); ok && entry == namespace+":"+collection {
+				return false
+			}
+		}
+	}
+	for _, entry := range u.KeyPrefixes {
+		entry, ok := unqualified(entry, channelID)
+		if ok && strings.HasPrefix(entry, namespace+":") && strings.HasPrefix(key, entry[len(namespace)+1:]) {
+			return false
+		}
+	}
+	return true
+}
+
+// unqualified strips the channel from an entry qualified as channel/entry,
+// and reports whether the entry applies to the channel. Only a slash before
+// the chaincode's colon qualifies the entry, as key prefixes may hold others.
+func unqualified(entry, channelID string) (string, bool) {
+	entry = strings.TrimSpace(entry)
+	i, colon := strings.Index(entry, "/"), strings.Index(entry, ":")
+	if i >= 0 && (colon < 0 || i < colon) {
+		return entry[i+1:], entry[:i] == channelID
+	}
+	return entry, true
+}
+
+// Applies reports whether proposals for the chaincode on the channel are
+// prepared on the shards
+func (p ShardingPolicy) Applies(channelID, chaincode string) bool {
+	if len(p.Channels) > 0 && !containsString(p.Channels, channelID) {
+		return false
+	}
+	if matchesChaincode(p.ExcludeChaincodes, channelID, chaincode) {
+		return false
+	}
+	return len(p.Chaincodes) == 0 || matchesChaincode(p.Chaincodes, channelID, chaincode)
+}
+
+// matchesChaincode reports whether one of the entries names the chaincode,
+// alone or qualified with its channel
+func matchesChaincode(entries []string, channelID, chaincode string) bool {
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == chaincode || entry == channelID+"/"+chaincode {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if strings.TrimSpace(v) == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package endorser
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestShardingPolicy(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	gt.Expect(ShardingPolicy{}.Applies("mychannel", "fabcar")).To(BeTrue())
+
+	policy := ShardingPolicy{
+		Channels:          []string{"mychannel", "otherchannel"},
+		Chaincodes:        []string{"fabcar", "otherchannel/marbles"},
+		ExcludeChaincodes: []string{"mychannel/fabcar"},
+	}
+	gt.Expect(policy.Applies("otherchannel", "fabcar")).To(BeTrue())
+	gt.Expect(policy.Applies("otherchannel", "marbles")).To(BeTrue())
+	// Chaincodes qualified with a channel only match on that channel
+	gt.Expect(policy.Applies("mychannel", "marbles")).To(BeFalse())
+	// Exclusions win over inclusions
+	gt.Expect(policy.Applies("mychannel", "fabcar")).To(BeFalse())
+	gt.Expect(policy.Applies("thirdchannel", "fabcar")).To(BeFalse())
+
+	policy = ShardingPolicy{ExcludeChaincodes: []string{"lowcontention"}}
+	gt.Expect(policy.Applies("mychannel", "lowcontention")).To(BeFalse())
+	gt.Expect(policy.Applies("mychannel", "fabcar")).To(BeTrue())
+}
+
+func TestUntrackedKeys(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	gt.Expect(UntrackedKeys{}.tracks("mychannel", "fabcar", "", "car1")).To(BeTrue())
+
+	untracked := UntrackedKeys{
+		Namespaces:  []string{"otherchannel/metrics"},
+		Collections: []string{"fabcar:audit", "otherchannel/marbles:prices"},
+		KeyPrefixes: []string{"fabcar:counter", "mychannel/marbles:stats/"},
+	}
+	gt.Expect(untracked.tracks("otherchannel", "metrics", "", "calls")).To(BeFalse())
+	gt.Expect(untracked.tracks("mychannel", "metrics", "", "calls")).To(BeTrue())
+
+	gt.Expect(untracked.tracks("mychannel", "fabcar", "audit", "car1")).To(BeFalse())
+	gt.Expect(untracked.tracks("mychannel", "fabcar", "", "car1")).To(BeTrue())
+	gt.Expect(untracked.tracks("otherchannel", "marbles", "prices", "marble1")).To(BeFalse())
+	gt.Expect(untracked.tracks("mychannel", "marbles", "prices", "marble1")).To(BeTrue())
+
+	// key prefixes apply to public and private keys alike
+	gt.Expect(untracked.tracks("mychannel", "fabcar", "", "counter")).To(BeFalse())
+	gt.Expect(untracked.tracks("mychannel", "fabcar", "owners", "counter~1")).To(BeFalse())
+	gt.Expect(untracked.tracks("mychannel", "fabcar", "", "car1")).To(BeTrue())
+	// the slash of a prefix does not qualify it with a channel
+	gt.Expect(untracked.tracks("mychannel", "marbles", "", "stats/daily")).To(BeFalse())
+	gt.Expect(untracked.tracks("otherchannel", "marbles", "", "stats/daily")).To(BeTrue())
+	gt.Expect(UntrackedKeys{KeyPrefixes: []string{"marbles:stats/"}}.tracks("mychannel", "marbles", "", "stats/daily")).To(BeFalse())
+}
+
+func TestShardingEnabled(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	config := EndorserConfig{ShardingPolicy: ShardingPolicy{Chaincodes: []string{"fabcar"}}}
+	gt.Expect(config.shardingEnabled("mychannel", "fabcar")).To(BeFalse())
+
+	// The policy narrows sharding down, whichever way it is enabled
+	t.Setenv("FABRIC_SHARDING_ENABLED", "true")
+	gt.Expect(config.shardingEnabled("mychannel", "fabcar")).To(BeTrue())
+	gt.Expect(config.shardingEnabled("mychannel", "marbles")).To(BeFalse())
+
+	t.Setenv("FABRIC_SHARDING_ENABLED", "false")
+	config.ShardingEnabled = true
+	gt.Expect(config.shardingEnabled("mychannel", "fabcar")).To(BeTrue())
+	gt.Expect(config.shardingEnabled("mychannel", "marbles")).To(BeFalse())
+}
+
+func TestPrepareQuorum(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	// every shard must answer by default
+	gt.Expect(EndorserConfig{}.prepareQuorum(3)).To(Equal(3))
+	gt.Expect(EndorserConfig{PrepareQuorum: 1}.prepareQuorum(3)).To(Equal(3))
+
+	config := EndorserConfig{PrepareQuorum: 0.5}
+	gt.Expect(config.prepareQuorum(1)).To(Equal(1))
+	gt.Expect(config.prepareQuorum(3)).To(Equal(2))
+	gt.Expect(config.prepareQuorum(4)).To(Equal(2))
+	gt.Expect(EndorserConfig{PrepareQuorum: 0.01}.prepareQuorum(3)).To(Equal(1))
+}
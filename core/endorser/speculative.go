@@ -0,0 +1,118 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package endorser
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/fabric/core/endorser/sharding"
+)
+
+// SpeculativeProof is the outcome of the dependency resolution of a
+// speculatively endorsed transaction
+type SpeculativeProof struct {
+	TxID string
+	// Done is false while the shards are still being prepared
+	Done bool
+	// DependencyInfo holds the claims and proofs that a strict endorsement
+	// would have embedded in the response message
+	DependencyInfo string `json:",omitempty"`
+	// Error tells why the transaction would not have been endorsed
+	Error string `json:",omitempty"`
+}
+
+// speculativeResult tracks the background resolution of one transaction
+type speculativeResult struct {
+	done   chan struct{}
+	claims string
+	err    error
+	// expires is set once done, after which the result is forgotten
+	expires time.Time
+}
+
+// speculativeProofs holds the results of the speculative endorsements of the
+// endorser by transaction ID
+type speculativeProofs struct {
+	mu      sync.Mutex
+	results map[string]*speculativeResult
+}
+
+// speculate resolves the dependencies of a transaction in the background,
+// after its response was endorsed without them. The client's context is not
+// followed, since the client already has its response; the prepares are still
+// bounded by the prepare timeout and retries.
+func (e *Endorser) speculate(channel, txID string, lane PrepareLane, store sharding.DependencyStore, involvedShards map[string]map[string][]byte, ranges map[string][]sharding.KeyRange, reads map[string]bool, writes bool, expiry time.Duration) {
+	result := &speculativeResult{done: make(chan struct{})}
+
+	e.speculative.mu.Lock()
+	if e.speculative.results == nil {
+		e.speculative.results = make(map[string]*speculativeResult)
+	}
+	now := time.Now()
+	for id, r := range e.speculative.results {
+		if !r.expires.IsZero() && now.After(r.expires) {
+			delete(e.speculative.results, id)
+		}
+	}
+	e.speculative.results[txID] = result
+	e.speculative.mu.Unlock()
+
+	go func() {
+		deps, err := e.resolveDependencies(context.Background(), channel, txID, lane, store, involvedShards, ranges, reads, writes, expiry)
+		if err != nil {
+			logger.Warningf("Speculatively endorsed tx %s failed its dependency resolution: %s", txID, err)
+		}
+
+		e.speculative.mu.Lock()
+		result.err = err
+		if err == nil {
+			result.claims = deps.claims()
+		}
+		result.expires = time.Now().Add(e.Config.retention(expiry))
+		e.speculative.mu.Unlock()
+		close(result.done)
+	}()
+}
+
+// SpeculativeProof returns the dependency resolution of a speculatively
+// endorsed transaction, waiting up to wait for it to complete. The second
+// result is false when the endorser knows of no such transaction.
+func (e *Endorser) SpeculativeProof(ctx context.Context, txID string, wait time.Duration) (SpeculativeProof, bool) {
+	e.speculative.mu.Lock()
+	result, ok := e.speculative.results[txID]
+	e.speculative.mu.Unlock()
+	if !ok {
+		return SpeculativeProof{}, false
+	}
+
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-result.done:
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+	}
+
+	proof := SpeculativeProof{TxID: txID}
+	select {
+	case <-result.done:
+	default:
+		return proof, true
+	}
+	e.speculative.mu.Lock()
+	defer e.speculative.mu.Unlock()
+	proof.Done = true
+	proof.DependencyInfo = result.claims
+	if result.err != nil {
+		proof.Error = result.err.Error()
+	}
+	return proof, true
+}
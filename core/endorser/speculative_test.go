@@ -0,0 +1,122 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package endorser
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/endorser/sharding"
+	. "github.com/onsi/gomega"
+)
+
+func TestDependencyResolutionClaims(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	gt.Expect((&dependencyResolution{}).claims()).To(Equal("HasDependency=false,DependentTxID="))
+	gt.Expect((&dependencyResolution{speculative: true}).claims()).To(Equal("HasDependency=false,Speculative=true,DependentTxID="))
+	gt.Expect((&dependencyResolution{hasDependency: true, dependentTxIDs: "tx1", settleEstimate: 2345678 * time.Microsecond}).claims()).To(Equal("HasDependency=true,SettleEstimate=2.346s,DependentTxID=tx1"))
+	gt.Expect((&dependencyResolution{
+		hasDependency:   true,
+		dependentTxIDs:  "tx1,tx2",
+		missingShards:   "marbles",
+		dependencyChain: "tx0;tx1",
+		conflictTypes:   formatConflictTypes(map[string][]sharding.ConflictType{"tx2": {sharding.ConflictWriteWrite}, "tx1": {sharding.ConflictReadWrite, sharding.ConflictWriteAfterRead}}),
+		encodedProofs:   "proofs",
+	}).claims()).To(Equal("HasDependency=true,MissingShards=marbles,DependencyChain=tx0;tx1,ConflictTypes=tx1:RW+WAR;tx2:WW,DependentTxID=tx1,tx2,ShardProofs=proofs"))
+}
+
+func TestSpeculativeEndorsement(t *testing.T) {
+	newEndorser := func() *Endorser {
+		return &Endorser{
+			Config: EndorserConfig{
+				SpeculativeEndorsement: true,
+				PrepareTimeout:         20 * time.Millisecond,
+				PrepareRetry:           PrepareRetryConfig{Attempts: 1, Backoff: time.Millisecond},
+			},
+		}
+	}
+	shards := map[string]map[string][]byte{"fabcar": {"fabcar:car1": []byte("red")}}
+
+	t.Run("Proofs", func(t *testing.T) {
+		gt := NewGomegaWithT(t)
+		e := newEndorser()
+		// the first prepare times out, so the proof is only known after
+		// the retry
+		e.speculate("mychannel", "tx1", DefaultLane, &flakyStore{timeouts: 1}, shards, nil, nil, true, 0)
+
+		proof, ok := e.SpeculativeProof(context.Background(), "tx1", 0)
+		gt.Expect(ok).To(BeTrue())
+		gt.Expect(proof).To(Equal(SpeculativeProof{TxID: "tx1"}))
+
+		proof, ok = e.SpeculativeProof(context.Background(), "tx1", 5*time.Second)
+		gt.Expect(ok).To(BeTrue())
+		gt.Expect(proof.Done).To(BeTrue())
+		gt.Expect(proof.Error).To(BeEmpty())
+		gt.Expect(proof.DependencyInfo).To(HavePrefix("HasDependency=false,DependentTxID=,ShardProofs="))
+
+		_, ok = e.SpeculativeProof(context.Background(), "tx2", 0)
+		gt.Expect(ok).To(BeFalse())
+	})
+
+	t.Run("Rejected", func(t *testing.T) {
+		gt := NewGomegaWithT(t)
+		e := newEndorser()
+		e.speculate("mychannel", "tx1", DefaultLane, &flakyStore{err: errors.New("shard is down")}, shards, nil, nil, true, 0)
+
+		proof, ok := e.SpeculativeProof(context.Background(), "tx1", 5*time.Second)
+		gt.Expect(ok).To(BeTrue())
+		gt.Expect(proof.Done).To(BeTrue())
+		gt.Expect(proof.DependencyInfo).To(BeEmpty())
+		gt.Expect(proof.Error).To(ContainSubstring("shard is down"))
+	})
+
+	t.Run("Expiry", func(t *testing.T) {
+		gt := NewGomegaWithT(t)
+		e := newEndorser()
+		e.Config.ExpiryDuration = time.Millisecond
+		e.speculate("mychannel", "tx1", DefaultLane, &flakyStore{}, shards, nil, nil, true, 0)
+		_, _ = e.SpeculativeProof(context.Background(), "tx1", 5*time.Second)
+
+		time.Sleep(10 * time.Millisecond)
+		e.speculate("mychannel", "tx2", DefaultLane, &flakyStore{}, shards, nil, nil, true, 0)
+		_, ok := e.SpeculativeProof(context.Background(), "tx1", 0)
+		gt.Expect(ok).To(BeFalse())
+	})
+
+	t.Run("AdminHandler", func(t *testing.T) {
+		gt := NewGomegaWithT(t)
+		e := newEndorser()
+		e.speculate("mychannel", "tx1", DefaultLane, &flakyStore{}, shards, nil, nil, true, 0)
+		handler := NewAdminHandler(e)
+
+		serve := func(target string) *httptest.ResponseRecorder {
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, target, nil))
+			return rr
+		}
+
+		rr := serve("/endorser/speculative?txid=tx1&wait=5s")
+		gt.Expect(rr.Code).To(Equal(http.StatusOK))
+		gt.Expect(rr.Body.String()).To(ContainSubstring(`"Done":true`))
+		gt.Expect(strings.Count(rr.Body.String(), "ShardProofs=")).To(Equal(1))
+
+		rr = serve("/endorser/speculative?txid=tx2")
+		gt.Expect(rr.Code).To(Equal(http.StatusNotFound))
+
+		rr = serve("/endorser/speculative?txid=tx1&wait=soon")
+		gt.Expect(rr.Code).To(Equal(http.StatusBadRequest))
+
+		rr = serve("/endorser/speculative")
+		gt.Expect(rr.Code).To(Equal(http.StatusBadRequest))
+	})
+}
@@ -0,0 +1,94 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package endorser
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/endorser/sharding"
+	. "github.com/onsi/gomega"
+)
+
+// decidingStore records the two-phase commit decisions, and rejects the
+// prepares of the shards listed in rejecting
+type decidingStore struct {
+	abortingStore
+	rejecting map[string]bool
+	decisions []string
+}
+
+func (s *decidingStore) Prepare(ctx context.Context, req *sharding.PrepareRequest) (*sharding.PrepareProof, error) {
+	proof, err := s.abortingStore.Prepare(ctx, req)
+	if err == nil && s.rejecting[req.ShardID] {
+		proof.Rejected = true
+	}
+	return proof, err
+}
+
+func (s *decidingStore) Decide(ctx context.Context, shardID, txID string, commit bool) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.decisions = append(s.decisions, decisionOf(shardID, txID, commit))
+	return commit, nil
+}
+
+func decisionOf(shardID, txID string, commit bool) string {
+	if commit {
+		return "commit " + shardID + "/" + txID
+	}
+	return "abort " + shardID + "/" + txID
+}
+
+func (s *decidingStore) recorded() ([]string, []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.decisions...), append([]string(nil), s.aborted...)
+}
+
+func TestTwoPhaseCommit(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	store := &decidingStore{}
+	coordinator, err := sharding.NewCoordinator(store, sharding.CoordinatorConfig{})
+	gt.Expect(err).NotTo(HaveOccurred())
+	e := &Endorser{
+		Config:          EndorserConfig{PrepareTimeout: time.Second},
+		DependencyStore: store,
+		Coordinator:     coordinator,
+	}
+	defer e.Coordinator.Stop()
+	shards := map[string]map[string][]byte{
+		"fabcar":  {"fabcar:car1": []byte("red")},
+		"marbles": {"marbles:m1": []byte("blue")},
+	}
+
+	_, err = e.resolveDependencies(context.Background(), "mychannel", "tx1", DefaultLane, store, shards, nil, nil, true, 0)
+	gt.Expect(err).NotTo(HaveOccurred())
+	decisions, aborted := store.recorded()
+	gt.Expect(decisions).To(ConsistOf(decisionOf("fabcar", "tx1", true), decisionOf("marbles", "tx1", true)))
+	gt.Expect(aborted).To(BeEmpty())
+
+	// a shard rejecting the transaction aborts it on every shard
+	store.rejecting = map[string]bool{"marbles": true}
+	_, err = e.resolveDependencies(context.Background(), "mychannel", "tx2", DefaultLane, store, shards, nil, nil, true, 0)
+	gt.Expect(err).To(MatchError(ContainSubstring("shard marbles rejected tx")))
+	decisions, aborted = store.recorded()
+	gt.Expect(decisions[2:]).To(ConsistOf(decisionOf("fabcar", "tx2", false), decisionOf("marbles", "tx2", false)))
+	gt.Expect(aborted).To(BeEmpty())
+	store.rejecting = nil
+
+	// transactions that read or write on a single shard are not coordinated
+	_, err = e.resolveDependencies(context.Background(), "mychannel", "tx3", DefaultLane, store, shards, nil, nil, false, 0)
+	gt.Expect(err).NotTo(HaveOccurred())
+	_, err = e.resolveDependencies(context.Background(), "mychannel", "tx4", DefaultLane, store, map[string]map[string][]byte{"fabcar": shards["fabcar"]}, nil, nil, true, 0)
+	gt.Expect(err).NotTo(HaveOccurred())
+	decisions, _ = store.recorded()
+	gt.Expect(decisions).To(HaveLen(4))
+	gt.Expect(coordinator.InFlight()).To(BeZero())
+}
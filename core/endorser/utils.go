@@ -14,7 +14,11 @@ import (
 	pb "github.com/hyperledger/fabric-protos-go/peer"
 	"github.com/hyperledger/fabric/common/flogging"
 	"github.com/hyperledger/fabric/core/common/ccprovider"
+	"github.com/hyperledger/fabric/core/endorser/hints"
+	"github.com/hyperledger/fabric/core/endorser/sharding"
 	"github.com/hyperledger/fabric/core/ledger"
+	"github.com/hyperledger/fabric/protoutil"
+	"github.com/pkg/errors"
 )
 
 // decorateLogger adds transaction context to the logger
@@ -54,9 +58,74 @@ func CreateCCEventBytes(ccevent *pb.ChaincodeEvent) ([]byte, error) {
 	return proto.Marshal(ccevent)
 }
 
-// extractTransactionDependencies identifies variables that the transaction operates on
-func (e *Endorser) extractTransactionDependencies(simResult *ledger.TxSimulationResults) (map[string][]byte, error) {
+// dependencyHints returns the dependency hints declared by the client in the
+// transient data of the proposal, merged with those the chaincode set as its
+// event. Only the chaincode may declare an expiry.
+func dependencyHints(up *UnpackedProposal, ccevent *pb.ChaincodeEvent) (*hints.Hints, error) {
+	var declared []*hints.Hints
+	cpp, err := protoutil.UnmarshalChaincodeProposalPayload(up.Proposal.Payload)
+	if err != nil {
+		return nil, err
+	}
+	if data, ok := cpp.TransientMap[hints.TransientKey]; ok {
+		h, err := hints.Unmarshal(data)
+		if err != nil {
+			return nil, errors.WithMessage(err, "invalid transient field "+hints.TransientKey)
+		}
+		// A client could otherwise cut the reservations of its transaction
+		// short, so that later writers no longer depend on it
+		h.Expiry = ""
+		declared = append(declared, h)
+	}
+	if ccevent != nil && ccevent.EventName == hints.EventName {
+		h, err := hints.Unmarshal(ccevent.Payload)
+		if err != nil {
+			return nil, errors.WithMessage(err, "invalid chaincode event "+hints.EventName)
+		}
+		declared = append(declared, h)
+	}
+
+	switch len(declared) {
+	case 0:
+		return nil, nil
+	case 1:
+		return declared[0], nil
+	default:
+		return hints.Merge(declared[0], declared[1]), nil
+	}
+}
+
+// extractTransactionDependencies identifies variables that the transaction
+// operates on, the ranges of public keys it scanned, and the keys it writes,
+// including those whose metadata only it writes. The keys hinted for the
+// chaincode are added to those of the simulation results, and a write hint
+// makes the transaction a writer of the key. The keys the sharding policy
+// leaves untracked on the channel are skipped.
+func (e *Endorser) extractTransactionDependencies(simResult *ledger.TxSimulationResults, channelID, chaincode string, declared *hints.Hints) (map[string][]byte, []sharding.KeyRange, map[string]bool, error) {
 	dependencies := make(map[string][]byte)
+	var ranges []sharding.KeyRange
+	written := make(map[string]bool)
+
+	untracked := e.Config.ShardingPolicy.Untracked
+	tracks := func(namespace, collection, key string) bool {
+		if untracked.tracks(channelID, namespace, collection, key) {
+			return true
+		}
+		logger.Debugf("Key %s of namespace %s collection %q is not tracked", key, namespace, collection)
+		return false
+	}
+
+	addRead := func(key string, version *kvrwset.Version) {
+		if _, exists := dependencies[key]; !exists {
+			if version != nil {
+				versionBytes := []byte(fmt.Sprintf("%d-%d", version.BlockNum, version.TxNum))
+				dependencies[key] = versionBytes
+			} else {
+				dependencies[key] = []byte{}
+			}
+			logger.Debugf("Transaction read dependency identified: %s", key)
+		}
+	}
 
 	// Extract variables from public state
 	if simResult.PubSimulationResults != nil {
@@ -74,23 +143,59 @@ func (e *Endorser) extractTransactionDependencies(simResult *ledger.TxSimulation
 			}
 
 			// Extract write dependencies
+			for _, write := range kvRWSet.MetadataWrites {
+				if tracks(namespace, "", write.Key) {
+					written[namespace+":"+write.Key] = true
+				}
+			}
 			for _, write := range kvRWSet.Writes {
+				if !tracks(namespace, "", write.Key) {
+					continue
+				}
 				key := namespace + ":" + string(write.Key)
+				written[key] = true
 				dependencies[key] = write.Value
 				logger.Debugf("Transaction write dependency identified: %s", key)
 			}
 
 			// Extract read dependencies
 			for _, read := range kvRWSet.Reads {
-				key := namespace + ":" + string(read.Key)
-				if _, exists := dependencies[key]; !exists {
-					if read.Version != nil {
-						versionBytes := []byte(fmt.Sprintf("%d-%d", read.Version.BlockNum, read.Version.TxNum))
-						dependencies[key] = versionBytes
-					} else {
-						dependencies[key] = []byte{}
+				if !tracks(namespace, "", read.Key) {
+					continue
+				}
+				addRead(namespace+":"+string(read.Key), read.Version)
+			}
+
+			// Extract range query dependencies. The keys returned are read
+			// dependencies, and the range itself catches later writes of
+			// keys that would have been returned.
+			for _, rqi := range kvRWSet.RangeQueriesInfo {
+				// A range is only left out when its whole namespace is
+				if !tracks(namespace, "", "") {
+					break
+				}
+				keyRange := sharding.KeyRange{
+					StartKey: namespace + ":" + rqi.StartKey,
+					EndKey:   namespace + ":" + rqi.EndKey,
+				}
+				rawReads := rqi.GetRawReads().GetKvReads()
+				switch {
+				case !rqi.ItrExhausted && len(rawReads) > 0:
+					// The scan stopped after the last key it returned
+					keyRange.EndKey = namespace + ":" + rawReads[len(rawReads)-1].Key + "\x00"
+				case rqi.EndKey == "":
+					// An open range runs to the end of the namespace, and ';'
+					// follows ':' in the key order
+					keyRange.EndKey = namespace + ";"
+				}
+				ranges = append(ranges, keyRange)
+				logger.Debugf("Transaction range dependency identified: [%s, %s)", keyRange.StartKey, keyRange.EndKey)
+
+				for _, read := range rawReads {
+					if !tracks(namespace, "", read.Key) {
+						continue
 					}
-					logger.Debugf("Transaction read dependency identified: %s", key)
+					addRead(namespace+":"+read.Key, read.Version)
 				}
 			}
 		}
@@ -116,14 +221,26 @@ func (e *Endorser) extractTransactionDependencies(simResult *ledger.TxSimulation
 				}
 
 				// Extract private write dependencies
+				for _, write := range collKVRWSet.MetadataWrites {
+					if tracks(namespace, collectionName, write.Key) {
+						written[namespace+":"+collectionName+":"+write.Key] = true
+					}
+				}
 				for _, write := range collKVRWSet.Writes {
+					if !tracks(namespace, collectionName, write.Key) {
+						continue
+					}
 					key := namespace + ":" + collectionName + ":" + string(write.Key)
+					written[key] = true
 					dependencies[key] = write.Value
 					logger.Debugf("Private data write dependency identified: %s", key)
 				}
 
 				// Extract private read dependencies
 				for _, read := range collKVRWSet.Reads {
+					if !tracks(namespace, collectionName, read.Key) {
+						continue
+					}
 					key := namespace + ":" + collectionName + ":" + string(read.Key)
 					if _, exists := dependencies[key]; !exists {
 						if read.Version != nil {
@@ -139,5 +256,23 @@ func (e *Endorser) extractTransactionDependencies(simResult *ledger.TxSimulation
 		}
 	}
 
-	return dependencies, nil
+	if !declared.Empty() {
+		for i, hinted := range [][]string{declared.Writes, declared.Reads} {
+			for _, hint := range hinted {
+				if !tracks(chaincode, "", hint) {
+					continue
+				}
+				key := chaincode + ":" + hint
+				if i == 0 {
+					written[key] = true
+				}
+				if _, exists := dependencies[key]; !exists {
+					dependencies[key] = []byte{}
+					logger.Debugf("Hinted dependency identified: %s", key)
+				}
+			}
+		}
+	}
+
+	return dependencies, ranges, written, nil
 }
@@ -0,0 +1,150 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package endorser
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-protos-go/ledger/rwset"
+	"github.com/hyperledger/fabric-protos-go/ledger/rwset/kvrwset"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/hyperledger/fabric/core/endorser/hints"
+	"github.com/hyperledger/fabric/core/endorser/sharding"
+	"github.com/hyperledger/fabric/core/ledger"
+	. "github.com/onsi/gomega"
+)
+
+// noSysCCSupport knows no system chaincodes; its other methods are not
+// implemented
+type noSysCCSupport struct {
+	Support
+}
+
+func (noSysCCSupport) IsSysCC(string) bool { return false }
+
+func TestExtractTransactionDependencies(t *testing.T) {
+	gt := NewGomegaWithT(t)
+	e := &Endorser{Support: noSysCCSupport{}}
+
+	simResult := func(kvRWSet *kvrwset.KVRWSet) *ledger.TxSimulationResults {
+		bytes, err := proto.Marshal(kvRWSet)
+		gt.Expect(err).NotTo(HaveOccurred())
+		return &ledger.TxSimulationResults{
+			PubSimulationResults: &rwset.TxReadWriteSet{
+				NsRwset: []*rwset.NsReadWriteSet{{Namespace: "fabcar", Rwset: bytes}},
+			},
+		}
+	}
+
+	deps, _, written, err := e.extractTransactionDependencies(simResult(&kvrwset.KVRWSet{
+		Reads: []*kvrwset.KVRead{{Key: "car1", Version: &kvrwset.Version{BlockNum: 3, TxNum: 1}}},
+	}), "mychannel", "fabcar", nil)
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(written).To(BeEmpty())
+	gt.Expect(deps).To(Equal(map[string][]byte{"fabcar:car1": []byte("3-1")}))
+
+	deps, _, written, err = e.extractTransactionDependencies(simResult(&kvrwset.KVRWSet{
+		Reads:  []*kvrwset.KVRead{{Key: "car1"}},
+		Writes: []*kvrwset.KVWrite{{Key: "car2", Value: []byte("red")}},
+	}), "mychannel", "fabcar", nil)
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(written).To(Equal(map[string]bool{"fabcar:car2": true}))
+	gt.Expect(deps).To(Equal(map[string][]byte{"fabcar:car1": {}, "fabcar:car2": []byte("red")}))
+
+	// hinted keys are merged with those of the simulation
+	deps, _, written, err = e.extractTransactionDependencies(simResult(&kvrwset.KVRWSet{
+		Reads: []*kvrwset.KVRead{{Key: "car1", Version: &kvrwset.Version{BlockNum: 3, TxNum: 1}}},
+	}), "mychannel", "fabcar", &hints.Hints{Reads: []string{"car1", "car2"}, Writes: []string{"owner~car1"}})
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(written).To(Equal(map[string]bool{"fabcar:owner~car1": true}))
+	gt.Expect(deps).To(Equal(map[string][]byte{"fabcar:car1": []byte("3-1"), "fabcar:car2": {}, "fabcar:owner~car1": {}}))
+
+	// range queries add their results and the range they scanned
+	deps, ranges, written, err := e.extractTransactionDependencies(simResult(&kvrwset.KVRWSet{
+		RangeQueriesInfo: []*kvrwset.RangeQueryInfo{
+			{StartKey: "car1", EndKey: "car9", ItrExhausted: true, ReadsInfo: &kvrwset.RangeQueryInfo_RawReads{RawReads: &kvrwset.QueryReads{
+				KvReads: []*kvrwset.KVRead{{Key: "car1", Version: &kvrwset.Version{BlockNum: 3, TxNum: 1}}},
+			}}},
+			{StartKey: "car1", EndKey: "car9", ReadsInfo: &kvrwset.RangeQueryInfo_RawReads{RawReads: &kvrwset.QueryReads{
+				KvReads: []*kvrwset.KVRead{{Key: "car2"}},
+			}}},
+			{StartKey: "owner~", ItrExhausted: true},
+		},
+	}), "mychannel", "fabcar", nil)
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(written).To(BeEmpty())
+	gt.Expect(deps).To(Equal(map[string][]byte{"fabcar:car1": []byte("3-1"), "fabcar:car2": {}}))
+	gt.Expect(ranges).To(Equal([]sharding.KeyRange{
+		{StartKey: "fabcar:car1", EndKey: "fabcar:car9"},
+		{StartKey: "fabcar:car1", EndKey: "fabcar:car2\x00"},
+		{StartKey: "fabcar:owner~", EndKey: "fabcar;"},
+	}))
+	gt.Expect(ranges[2].Contains("fabcar:owner~car1")).To(BeTrue())
+	gt.Expect(ranges[2].Contains("fabcarx:owner~car1")).To(BeFalse())
+
+	// untracked keys are left out, and only writing them is no write
+	e.Config.ShardingPolicy.Untracked = UntrackedKeys{KeyPrefixes: []string{"mychannel/fabcar:counter"}}
+	deps, _, written, err = e.extractTransactionDependencies(simResult(&kvrwset.KVRWSet{
+		Reads:  []*kvrwset.KVRead{{Key: "car1"}, {Key: "counter"}},
+		Writes: []*kvrwset.KVWrite{{Key: "counter", Value: []byte("2")}},
+	}), "mychannel", "fabcar", &hints.Hints{Writes: []string{"counter~cars"}})
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(written).To(BeEmpty())
+	gt.Expect(deps).To(Equal(map[string][]byte{"fabcar:car1": {}}))
+
+	deps, _, written, err = e.extractTransactionDependencies(simResult(&kvrwset.KVRWSet{
+		Writes: []*kvrwset.KVWrite{{Key: "counter", Value: []byte("2")}},
+	}), "otherchannel", "fabcar", nil)
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(written).To(Equal(map[string]bool{"fabcar:counter": true}))
+	gt.Expect(deps).To(Equal(map[string][]byte{"fabcar:counter": []byte("2")}))
+
+	e.Config.ShardingPolicy.Untracked = UntrackedKeys{Namespaces: []string{"fabcar"}}
+	deps, ranges, written, err = e.extractTransactionDependencies(simResult(&kvrwset.KVRWSet{
+		Writes:           []*kvrwset.KVWrite{{Key: "car1", Value: []byte("red")}},
+		RangeQueriesInfo: []*kvrwset.RangeQueryInfo{{StartKey: "owner~", ItrExhausted: true}},
+	}), "mychannel", "fabcar", nil)
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(written).To(BeEmpty())
+	gt.Expect(deps).To(BeEmpty())
+	gt.Expect(ranges).To(BeEmpty())
+}
+
+func TestDependencyHints(t *testing.T) {
+	gt := NewGomegaWithT(t)
+
+	proposal := func(transient map[string][]byte) *UnpackedProposal {
+		payload, err := proto.Marshal(&pb.ChaincodeProposalPayload{TransientMap: transient})
+		gt.Expect(err).NotTo(HaveOccurred())
+		return &UnpackedProposal{Proposal: &pb.Proposal{Payload: payload}}
+	}
+
+	declared, err := dependencyHints(proposal(nil), &pb.ChaincodeEvent{EventName: "sold"})
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(declared.Empty()).To(BeTrue())
+
+	clientHints, err := hints.Marshal(&hints.Hints{Reads: []string{"car1"}, Expiry: "1s"})
+	gt.Expect(err).NotTo(HaveOccurred())
+	chaincodeHints, err := hints.Marshal(&hints.Hints{Writes: []string{"car1", "car2"}, Expiry: "30s"})
+	gt.Expect(err).NotTo(HaveOccurred())
+
+	declared, err = dependencyHints(proposal(map[string][]byte{hints.TransientKey: clientHints}), nil)
+	gt.Expect(err).NotTo(HaveOccurred())
+	// only the chaincode may shorten the expiry
+	gt.Expect(declared).To(Equal(&hints.Hints{Reads: []string{"car1"}}))
+
+	declared, err = dependencyHints(
+		proposal(map[string][]byte{hints.TransientKey: clientHints}),
+		&pb.ChaincodeEvent{EventName: hints.EventName, Payload: chaincodeHints},
+	)
+	gt.Expect(err).NotTo(HaveOccurred())
+	gt.Expect(declared).To(Equal(&hints.Hints{Writes: []string{"car1", "car2"}, Expiry: "30s"}))
+
+	_, err = dependencyHints(proposal(map[string][]byte{hints.TransientKey: []byte("car1")}), nil)
+	gt.Expect(err).To(MatchError(ContainSubstring("invalid transient field " + hints.TransientKey)))
+}
@@ -724,14 +724,20 @@ func (l *kvLedger) commit(pvtdataAndBlock *ledger.BlockAndPvtData, commitOpts *l
 		l.commitHash,
 	)
 
+	var abortReasons map[int]ledger.AbortReason
+	if commitOpts != nil {
+		abortReasons = commitOpts.AbortReasons
+	}
+
 	l.updateBlockStats(
 		elapsedBlockProcessing,
 		elapsedBlockstorageAndPvtdataCommit,
 		elapsedCommitState,
 		txstatsInfo,
+		abortReasons,
 	)
 
-	l.sendCommitNotification(blockNo, txstatsInfo)
+	l.sendCommitNotification(blockNo, txstatsInfo, abortReasons)
 	return nil
 }
 
@@ -801,11 +807,13 @@ func (l *kvLedger) updateBlockStats(
 	blockstorageAndPvtdataCommitTime time.Duration,
 	statedbCommitTime time.Duration,
 	txstatsInfo []*validation.TxStatInfo,
+	abortReasons map[int]ledger.AbortReason,
 ) {
 	l.stats.updateBlockProcessingTime(blockProcessingTime)
 	l.stats.updateBlockstorageAndPvtdataCommitTime(blockstorageAndPvtdataCommitTime)
 	l.stats.updateStatedbCommitTime(statedbCommitTime)
 	l.stats.updateTransactionsStats(txstatsInfo)
+	l.stats.updateAbortedTransactionsStats(abortReasons)
 }
 
 func (l *kvLedger) addBlockCommitHash(block *common.Block, updateBatchBytes []byte) {
@@ -969,7 +977,7 @@ func (l *kvLedger) CommitNotificationsChannel(done <-chan struct{}) (<-chan *led
 	return l.commitNotifier.dataChannel, nil
 }
 
-func (l *kvLedger) sendCommitNotification(blockNum uint64, txStatsInfo []*validation.TxStatInfo) {
+func (l *kvLedger) sendCommitNotification(blockNum uint64, txStatsInfo []*validation.TxStatInfo, abortReasons map[int]ledger.AbortReason) {
 	l.commitNotifierLock.Lock()
 	defer l.commitNotifierLock.Unlock()
 
@@ -984,7 +992,7 @@ func (l *kvLedger) sendCommitNotification(blockNum uint64, txStatsInfo []*valida
 	default:
 		txsByID := map[string]struct{}{}
 		txs := []*ledger.CommitNotificationTxInfo{}
-		for _, t := range txStatsInfo {
+		for i, t := range txStatsInfo {
 			txID := t.TxIDFromChannelHeader
 			_, ok := txsByID[txID]
 
@@ -999,6 +1007,7 @@ func (l *kvLedger) sendCommitNotification(blockNum uint64, txStatsInfo []*valida
 				ValidationCode:     t.ValidationCode,
 				ChaincodeID:        t.ChaincodeID,
 				ChaincodeEventData: t.ChaincodeEventData,
+				AbortReason:        abortReasons[i],
 			})
 		}
 
@@ -1168,7 +1168,7 @@ func TestCommitNotifications(t *testing.T) {
 				ChaincodeEventData:    []byte("cc2_event"),
 				TxType:                common.HeaderType_ENDORSER_TRANSACTION,
 			},
-		})
+		}, nil)
 
 		commitNotification := <-dataChannel
 		require.Equal(t,
@@ -1206,7 +1206,7 @@ func TestCommitNotifications(t *testing.T) {
 				TxIDFromChannelHeader: "",
 				ValidationCode:        peer.TxValidationCode_DUPLICATE_TXID,
 			},
-		})
+		}, nil)
 
 		commitNotification := <-dataChannel
 		require.Equal(t,
@@ -1218,6 +1218,39 @@ func TestCommitNotifications(t *testing.T) {
 		)
 	})
 
+	t.Run("abort reasons are included in notification", func(t *testing.T) {
+		setup()
+		lgr.sendCommitNotification(1, []*validation.TxStatInfo{
+			{
+				TxIDFromChannelHeader: "txid_1",
+				ValidationCode:        peer.TxValidationCode_VALID,
+			},
+			{
+				TxIDFromChannelHeader: "txid_2",
+				ValidationCode:        peer.TxValidationCode_MVCC_READ_CONFLICT,
+			},
+		}, map[int]ledger.AbortReason{1: ledger.AbortReasonDependencyInvalid})
+
+		commitNotification := <-dataChannel
+		require.Equal(t,
+			&ledger.CommitNotification{
+				BlockNumber: 1,
+				TxsInfo: []*ledger.CommitNotificationTxInfo{
+					{
+						TxID:           "txid_1",
+						ValidationCode: peer.TxValidationCode_VALID,
+					},
+					{
+						TxID:           "txid_2",
+						ValidationCode: peer.TxValidationCode_MVCC_READ_CONFLICT,
+						AbortReason:    ledger.AbortReasonDependencyInvalid,
+					},
+				},
+			},
+			commitNotification,
+		)
+	})
+
 	t.Run("second time calling CommitNotificationsChannel returns error", func(t *testing.T) {
 		setup()
 		_, err := lgr.CommitNotificationsChannel(make(chan struct{}))
@@ -1226,12 +1259,12 @@ func TestCommitNotifications(t *testing.T) {
 
 	t.Run("closing done channel closes the data channel on next commit", func(t *testing.T) {
 		setup()
-		lgr.sendCommitNotification(1, []*validation.TxStatInfo{})
+		lgr.sendCommitNotification(1, []*validation.TxStatInfo{}, nil)
 		_, ok := <-dataChannel
 		require.True(t, ok)
 
 		close(doneChannel)
-		lgr.sendCommitNotification(2, []*validation.TxStatInfo{})
+		lgr.sendCommitNotification(2, []*validation.TxStatInfo{}, nil)
 
 		_, ok = <-dataChannel
 		require.False(t, ok)
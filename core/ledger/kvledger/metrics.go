@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/hyperledger/fabric/common/metrics"
+	"github.com/hyperledger/fabric/core/ledger"
 	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/validation"
 )
 
@@ -18,6 +19,7 @@ type stats struct {
 	blockAndPvtdataStoreCommitTime metrics.Histogram
 	statedbCommitTime              metrics.Histogram
 	transactionsCount              metrics.Counter
+	abortedTransactionsCount       metrics.Counter
 }
 
 func newStats(metricsProvider metrics.Provider) *stats {
@@ -26,6 +28,7 @@ func newStats(metricsProvider metrics.Provider) *stats {
 	stats.blockAndPvtdataStoreCommitTime = metricsProvider.NewHistogram(blockAndPvtdataStoreCommitTimeOpts)
 	stats.statedbCommitTime = metricsProvider.NewHistogram(statedbCommitTimeOpts)
 	stats.transactionsCount = metricsProvider.NewCounter(transactionCountOpts)
+	stats.abortedTransactionsCount = metricsProvider.NewCounter(abortedTransactionCountOpts)
 	return stats
 }
 
@@ -75,6 +78,15 @@ func (s *ledgerStats) updateTransactionsStats(
 	}
 }
 
+func (s *ledgerStats) updateAbortedTransactionsStats(abortReasons map[int]ledger.AbortReason) {
+	for _, reason := range abortReasons {
+		s.stats.abortedTransactionsCount.With(
+			"channel", s.ledgerid,
+			"reason", string(reason),
+		).Add(1)
+	}
+}
+
 var (
 	blockProcessingTimeOpts = metrics.HistogramOpts{
 		Namespace:    "ledger",
@@ -114,4 +126,13 @@ var (
 		LabelNames:   []string{"channel", "transaction_type", "chaincode", "validation_code"},
 		StatsdFormat: "%{#fqname}.%{channel}.%{transaction_type}.%{chaincode}.%{validation_code}",
 	}
+
+	abortedTransactionCountOpts = metrics.CounterOpts{
+		Namespace:    "ledger",
+		Subsystem:    "",
+		Name:         "aborted_transaction_count",
+		Help:         "Number of transactions invalidated by the DAG-based committer, by abort reason.",
+		LabelNames:   []string{"channel", "reason"},
+		StatsdFormat: "%{#fqname}.%{channel}.%{reason}",
+	}
 )
@@ -88,6 +88,7 @@ func TestStatsBlockCommit(t *testing.T) {
 				TxType:         -1,
 			},
 		},
+		map[int]lgr.AbortReason{1: lgr.AbortReasonDependencyInvalid},
 	)
 	require.Equal(t,
 		[]string{"channel", ledgerid},
@@ -140,6 +141,19 @@ func TestStatsBlockCommit(t *testing.T) {
 		float64(1),
 		testMetricProvider.fakeTransactionsCount.AddArgsForCall(2),
 	)
+
+	require.Equal(t, 1, testMetricProvider.fakeAbortedTransactionsCount.WithCallCount())
+	require.Equal(t,
+		[]string{
+			"channel", ledgerid,
+			"reason", string(lgr.AbortReasonDependencyInvalid),
+		},
+		testMetricProvider.fakeAbortedTransactionsCount.WithArgsForCall(0),
+	)
+	require.Equal(t,
+		float64(1),
+		testMetricProvider.fakeAbortedTransactionsCount.AddArgsForCall(0),
+	)
 }
 
 type testMetricProvider struct {
@@ -148,6 +162,7 @@ type testMetricProvider struct {
 	fakeBlockstorageCommitWithPvtDataTimeHist *metricsfakes.Histogram
 	fakeStatedbCommitTimeHist                 *metricsfakes.Histogram
 	fakeTransactionsCount                     *metricsfakes.Counter
+	fakeAbortedTransactionsCount              *metricsfakes.Counter
 }
 
 func testutilConstructMetricProvider() *testMetricProvider {
@@ -156,6 +171,7 @@ func testutilConstructMetricProvider() *testMetricProvider {
 	fakeBlockstorageCommitWithPvtDataTimeHist := testutilConstructHist()
 	fakeStatedbCommitTimeHist := testutilConstructHist()
 	fakeTransactionsCount := testutilConstructCounter()
+	fakeAbortedTransactionsCount := testutilConstructCounter()
 	fakeProvider.NewGaugeStub = func(opts metrics.GaugeOpts) metrics.Gauge {
 		// return a gauge for metrics in common/ledger
 		return testutilConstructGauge()
@@ -178,6 +194,8 @@ func testutilConstructMetricProvider() *testMetricProvider {
 		switch opts.Name {
 		case transactionCountOpts.Name:
 			return fakeTransactionsCount
+		case abortedTransactionCountOpts.Name:
+			return fakeAbortedTransactionsCount
 		}
 		return nil
 	}
@@ -187,6 +205,7 @@ func testutilConstructMetricProvider() *testMetricProvider {
 		fakeBlockstorageCommitWithPvtDataTimeHist,
 		fakeStatedbCommitTimeHist,
 		fakeTransactionsCount,
+		fakeAbortedTransactionsCount,
 	}
 }
 
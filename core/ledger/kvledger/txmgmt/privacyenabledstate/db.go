@@ -49,6 +49,7 @@ type DBProvider struct {
 	VersionedDBProvider statedb.VersionedDBProvider
 	HealthCheckRegistry ledger.HealthCheckRegistry
 	bookkeepingProvider *bookkeeping.Provider
+	groupCommitConfig   *ledger.GroupCommitConfig
 }
 
 // NewDBProvider constructs an instance of DBProvider
@@ -77,6 +78,9 @@ func NewDBProvider(
 		HealthCheckRegistry: healthCheckRegistry,
 		bookkeepingProvider: bookkeeperProvider,
 	}
+	if stateDBConf != nil && stateDBConf.StateDBConfig != nil {
+		dbProvider.groupCommitConfig = stateDBConf.GroupCommit
+	}
 
 	err = dbProvider.RegisterHealthChecker()
 	if err != nil {
@@ -107,7 +111,7 @@ func (p *DBProvider) GetDBHandle(id string, chInfoProvider channelInfoProvider)
 	if err != nil {
 		return nil, err
 	}
-	return NewDB(vdb, id, metadataHint)
+	return NewDB(newWriteBehindDB(vdb, p.groupCommitConfig), id, metadataHint)
 }
 
 // Close closes all the VersionedDB instances and releases any resources held by VersionedDBProvider
@@ -0,0 +1,296 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package privacyenabledstate
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hyperledger/fabric/core/ledger"
+	"github.com/hyperledger/fabric/core/ledger/internal/version"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/statedb"
+)
+
+const (
+	defaultGroupCommitMaxBlocks = 10
+	defaultGroupCommitMaxDelay  = 100 * time.Millisecond
+)
+
+// writeBehindDB buffers the update batches of consecutive blocks and applies them
+// to the wrapped VersionedDB as a single grouped batch. Point reads are served from
+// the buffer first so that validation and simulation observe the latest committed
+// state; range scans, rich queries and full scans flush the buffer before being
+// delegated, as merging iterators across the buffer and the db is not supported.
+//
+// Crash safety relies on the ordering that kvledger already enforces: a block is
+// added to the block store before its state updates are handed to the statedb. The
+// savepoint of the wrapped db only advances when a group is flushed, so on restart
+// the ledger recovery replays any blocks whose updates were still buffered.
+type writeBehindDB struct {
+	statedb.VersionedDB
+	maxBlocks int
+	maxDelay  time.Duration
+
+	mu            sync.RWMutex
+	pending       *statedb.UpdateBatch
+	pendingHeight *version.Height
+	pendingBlocks int
+
+	stopC    chan struct{}
+	doneC    chan struct{}
+	stopOnce sync.Once
+}
+
+// bulkIndexWriteBehindDB is a writeBehindDB over a db that is both
+// statedb.BulkOptimizable and statedb.IndexCapable (i.e., CouchDB)
+type bulkIndexWriteBehindDB struct {
+	*writeBehindDB
+	bulk  statedb.BulkOptimizable
+	index statedb.IndexCapable
+}
+
+// newWriteBehindDB wraps vdb according to the group commit config. The original
+// vdb is returned when group commit is disabled, or when the db exposes only a
+// subset of the optional statedb capabilities that the wrapper can forward.
+func newWriteBehindDB(vdb statedb.VersionedDB, conf *ledger.GroupCommitConfig) statedb.VersionedDB {
+	if conf == nil || !conf.Enabled {
+		return vdb
+	}
+
+	bulk, isBulk := vdb.(statedb.BulkOptimizable)
+	index, isIndex := vdb.(statedb.IndexCapable)
+	if isBulk != isIndex {
+		logger.Warnf("Group commit is not supported for this state database, committing each block individually")
+		return vdb
+	}
+
+	wb := &writeBehindDB{
+		VersionedDB: vdb,
+		maxBlocks:   conf.MaxBlocks,
+		maxDelay:    conf.MaxDelay,
+		stopC:       make(chan struct{}),
+		doneC:       make(chan struct{}),
+	}
+	if wb.maxBlocks <= 0 {
+		wb.maxBlocks = defaultGroupCommitMaxBlocks
+	}
+	if wb.maxDelay <= 0 {
+		wb.maxDelay = defaultGroupCommitMaxDelay
+	}
+	go wb.runFlusher()
+
+	logger.Infof("Group commit enabled for state database: maxBlocks=%d, maxDelay=%s", wb.maxBlocks, wb.maxDelay)
+	if isBulk {
+		return &bulkIndexWriteBehindDB{writeBehindDB: wb, bulk: bulk, index: index}
+	}
+	return wb
+}
+
+// runFlusher bounds the time an update can stay buffered
+func (wb *writeBehindDB) runFlusher() {
+	defer close(wb.doneC)
+	ticker := time.NewTicker(wb.maxDelay)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			wb.mu.Lock()
+			if err := wb.flushLocked(); err != nil {
+				logger.Errorf("Failed to flush grouped state updates: %s", err)
+			}
+			wb.mu.Unlock()
+		case <-wb.stopC:
+			return
+		}
+	}
+}
+
+// flushLocked applies the buffered group to the wrapped db. The buffer is kept on
+// failure so that the next commit retries it. The caller must hold wb.mu.
+func (wb *writeBehindDB) flushLocked() error {
+	if wb.pending == nil {
+		return nil
+	}
+	if err := wb.VersionedDB.ApplyUpdates(wb.pending, wb.pendingHeight); err != nil {
+		return err
+	}
+	logger.Debugf("Flushed %d grouped blocks to state database up to height %s", wb.pendingBlocks, wb.pendingHeight)
+	wb.pending = nil
+	wb.pendingHeight = nil
+	wb.pendingBlocks = 0
+	return nil
+}
+
+// flush applies the buffered group to the wrapped db
+func (wb *writeBehindDB) flush() error {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	return wb.flushLocked()
+}
+
+// pendingValue returns the buffered update for the key, if any
+func (wb *writeBehindDB) pendingValue(namespace, key string) (*statedb.VersionedValue, bool) {
+	if wb.pending == nil {
+		return nil, false
+	}
+	vv := wb.pending.Get(namespace, key)
+	return vv, vv != nil
+}
+
+// ApplyUpdates implements method in VersionedDB interface
+func (wb *writeBehindDB) ApplyUpdates(batch *statedb.UpdateBatch, height *version.Height) error {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+
+	// post-order writes (e.g., from old block pvtdata reconciliation) may
+	// target older heights, so keep them out of the group
+	if batch.ContainsPostOrderWrites {
+		if err := wb.flushLocked(); err != nil {
+			return err
+		}
+		return wb.VersionedDB.ApplyUpdates(batch, height)
+	}
+
+	if wb.pending == nil {
+		wb.pending = statedb.NewUpdateBatch()
+	}
+	wb.pending.Merge(batch)
+	if height != nil {
+		wb.pendingHeight = height
+	}
+	wb.pendingBlocks++
+
+	if wb.pendingBlocks >= wb.maxBlocks {
+		return wb.flushLocked()
+	}
+	return nil
+}
+
+// GetState implements method in VersionedDB interface
+func (wb *writeBehindDB) GetState(namespace string, key string) (*statedb.VersionedValue, error) {
+	wb.mu.RLock()
+	defer wb.mu.RUnlock()
+	if vv, ok := wb.pendingValue(namespace, key); ok {
+		if vv.IsDelete() {
+			return nil, nil
+		}
+		return vv, nil
+	}
+	return wb.VersionedDB.GetState(namespace, key)
+}
+
+// GetVersion implements method in VersionedDB interface
+func (wb *writeBehindDB) GetVersion(namespace string, key string) (*version.Height, error) {
+	wb.mu.RLock()
+	defer wb.mu.RUnlock()
+	if vv, ok := wb.pendingValue(namespace, key); ok {
+		if vv.IsDelete() {
+			return nil, nil
+		}
+		return vv.Version, nil
+	}
+	return wb.VersionedDB.GetVersion(namespace, key)
+}
+
+// GetStateMultipleKeys implements method in VersionedDB interface
+func (wb *writeBehindDB) GetStateMultipleKeys(namespace string, keys []string) ([]*statedb.VersionedValue, error) {
+	vals := make([]*statedb.VersionedValue, len(keys))
+	for i, key := range keys {
+		vv, err := wb.GetState(namespace, key)
+		if err != nil {
+			return nil, err
+		}
+		vals[i] = vv
+	}
+	return vals, nil
+}
+
+// GetStateRangeScanIterator implements method in VersionedDB interface
+func (wb *writeBehindDB) GetStateRangeScanIterator(namespace string, startKey string, endKey string) (statedb.ResultsIterator, error) {
+	if err := wb.flush(); err != nil {
+		return nil, err
+	}
+	return wb.VersionedDB.GetStateRangeScanIterator(namespace, startKey, endKey)
+}
+
+// GetStateRangeScanIteratorWithPagination implements method in VersionedDB interface
+func (wb *writeBehindDB) GetStateRangeScanIteratorWithPagination(namespace string, startKey string, endKey string, pageSize int32) (statedb.QueryResultsIterator, error) {
+	if err := wb.flush(); err != nil {
+		return nil, err
+	}
+	return wb.VersionedDB.GetStateRangeScanIteratorWithPagination(namespace, startKey, endKey, pageSize)
+}
+
+// ExecuteQuery implements method in VersionedDB interface
+func (wb *writeBehindDB) ExecuteQuery(namespace, query string) (statedb.ResultsIterator, error) {
+	if err := wb.flush(); err != nil {
+		return nil, err
+	}
+	return wb.VersionedDB.ExecuteQuery(namespace, query)
+}
+
+// ExecuteQueryWithPagination implements method in VersionedDB interface
+func (wb *writeBehindDB) ExecuteQueryWithPagination(namespace, query, bookmark string, pageSize int32) (statedb.QueryResultsIterator, error) {
+	if err := wb.flush(); err != nil {
+		return nil, err
+	}
+	return wb.VersionedDB.ExecuteQueryWithPagination(namespace, query, bookmark, pageSize)
+}
+
+// GetFullScanIterator implements method in VersionedDB interface
+func (wb *writeBehindDB) GetFullScanIterator(skipNamespace func(string) bool) (statedb.FullScanIterator, error) {
+	if err := wb.flush(); err != nil {
+		return nil, err
+	}
+	return wb.VersionedDB.GetFullScanIterator(skipNamespace)
+}
+
+// Close flushes any buffered updates and closes the wrapped db
+func (wb *writeBehindDB) Close() {
+	wb.stopOnce.Do(func() {
+		close(wb.stopC)
+		<-wb.doneC
+	})
+	if err := wb.flush(); err != nil {
+		logger.Errorf("Failed to flush grouped state updates on close, they will be recovered from the block store: %s", err)
+	}
+	wb.VersionedDB.Close()
+}
+
+// LoadCommittedVersions implements method in BulkOptimizable interface
+func (db *bulkIndexWriteBehindDB) LoadCommittedVersions(keys []*statedb.CompositeKey) error {
+	return db.bulk.LoadCommittedVersions(keys)
+}
+
+// GetCachedVersion implements method in BulkOptimizable interface
+func (db *bulkIndexWriteBehindDB) GetCachedVersion(namespace, key string) (*version.Height, bool) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if vv, ok := db.pendingValue(namespace, key); ok {
+		if vv.IsDelete() {
+			return nil, true
+		}
+		return vv.Version, true
+	}
+	return db.bulk.GetCachedVersion(namespace, key)
+}
+
+// ClearCachedVersions implements method in BulkOptimizable interface
+func (db *bulkIndexWriteBehindDB) ClearCachedVersions() {
+	db.bulk.ClearCachedVersions()
+}
+
+// GetDBType implements method in IndexCapable interface
+func (db *bulkIndexWriteBehindDB) GetDBType() string {
+	return db.index.GetDBType()
+}
+
+// ProcessIndexesForChaincodeDeploy implements method in IndexCapable interface
+func (db *bulkIndexWriteBehindDB) ProcessIndexesForChaincodeDeploy(namespace string, indexFilesData map[string][]byte) error {
+	return db.index.ProcessIndexesForChaincodeDeploy(namespace, indexFilesData)
+}
@@ -0,0 +1,129 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package privacyenabledstate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/ledger"
+	"github.com/hyperledger/fabric/core/ledger/internal/version"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/statedb"
+	"github.com/hyperledger/fabric/core/ledger/kvledger/txmgmt/statedb/stateleveldb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteBehindDisabled(t *testing.T) {
+	provider, err := stateleveldb.NewVersionedDBProvider(t.TempDir())
+	require.NoError(t, err)
+	defer provider.Close()
+
+	vdb, err := provider.GetDBHandle("testwritebehind", nil)
+	require.NoError(t, err)
+	require.Equal(t, vdb, newWriteBehindDB(vdb, nil))
+	require.Equal(t, vdb, newWriteBehindDB(vdb, &ledger.GroupCommitConfig{}))
+}
+
+func TestWriteBehindGroupsBlocks(t *testing.T) {
+	provider, err := stateleveldb.NewVersionedDBProvider(t.TempDir())
+	require.NoError(t, err)
+	defer provider.Close()
+
+	vdb, err := provider.GetDBHandle("testwritebehind", nil)
+	require.NoError(t, err)
+
+	db := newWriteBehindDB(vdb, &ledger.GroupCommitConfig{
+		Enabled:   true,
+		MaxBlocks: 2,
+		MaxDelay:  time.Hour,
+	})
+	wb, ok := db.(*writeBehindDB)
+	require.True(t, ok)
+	defer wb.stopOnce.Do(func() { close(wb.stopC) })
+
+	batch1 := statedb.NewUpdateBatch()
+	batch1.Put("ns", "key1", []byte("value1"), version.NewHeight(1, 0))
+	batch1.Put("ns", "key2", []byte("value2"), version.NewHeight(1, 1))
+	require.NoError(t, db.ApplyUpdates(batch1, version.NewHeight(1, 1)))
+
+	// the update is served from the buffer but the savepoint has not moved
+	vv, err := db.GetState("ns", "key1")
+	require.NoError(t, err)
+	require.Equal(t, []byte("value1"), vv.Value)
+	vv, err = vdb.GetState("ns", "key1")
+	require.NoError(t, err)
+	require.Nil(t, vv)
+	savepoint, err := db.GetLatestSavePoint()
+	require.NoError(t, err)
+	require.Nil(t, savepoint)
+
+	batch2 := statedb.NewUpdateBatch()
+	batch2.Delete("ns", "key2", version.NewHeight(2, 0))
+	require.NoError(t, db.ApplyUpdates(batch2, version.NewHeight(2, 0)))
+
+	// the second block completes the group and flushes it
+	savepoint, err = db.GetLatestSavePoint()
+	require.NoError(t, err)
+	require.Equal(t, version.NewHeight(2, 0), savepoint)
+	vv, err = vdb.GetState("ns", "key1")
+	require.NoError(t, err)
+	require.Equal(t, []byte("value1"), vv.Value)
+	vv, err = vdb.GetState("ns", "key2")
+	require.NoError(t, err)
+	require.Nil(t, vv)
+}
+
+func TestWriteBehindReadsAndFlushOnQuery(t *testing.T) {
+	provider, err := stateleveldb.NewVersionedDBProvider(t.TempDir())
+	require.NoError(t, err)
+	defer provider.Close()
+
+	vdb, err := provider.GetDBHandle("testwritebehind", nil)
+	require.NoError(t, err)
+	committed := statedb.NewUpdateBatch()
+	committed.Put("ns", "key1", []byte("old"), version.NewHeight(1, 0))
+	require.NoError(t, vdb.ApplyUpdates(committed, version.NewHeight(1, 0)))
+
+	db := newWriteBehindDB(vdb, &ledger.GroupCommitConfig{
+		Enabled:   true,
+		MaxBlocks: 10,
+		MaxDelay:  time.Hour,
+	})
+
+	batch := statedb.NewUpdateBatch()
+	batch.Delete("ns", "key1", version.NewHeight(2, 0))
+	batch.Put("ns", "key2", []byte("new"), version.NewHeight(2, 1))
+	require.NoError(t, db.ApplyUpdates(batch, version.NewHeight(2, 1)))
+
+	ver, err := db.GetVersion("ns", "key1")
+	require.NoError(t, err)
+	require.Nil(t, ver)
+	ver, err = db.GetVersion("ns", "key2")
+	require.NoError(t, err)
+	require.Equal(t, version.NewHeight(2, 1), ver)
+
+	vals, err := db.GetStateMultipleKeys("ns", []string{"key1", "key2"})
+	require.NoError(t, err)
+	require.Nil(t, vals[0])
+	require.Equal(t, []byte("new"), vals[1].Value)
+
+	itr, err := db.GetStateRangeScanIterator("ns", "", "")
+	require.NoError(t, err)
+	defer itr.Close()
+	kv, err := itr.Next()
+	require.NoError(t, err)
+	require.Equal(t, "key2", kv.Key)
+	kv, err = itr.Next()
+	require.NoError(t, err)
+	require.Nil(t, kv)
+
+	savepoint, err := vdb.GetLatestSavePoint()
+	require.NoError(t, err)
+	require.Equal(t, version.NewHeight(2, 1), savepoint)
+
+	db.Close()
+}
@@ -62,6 +62,21 @@ type StateDBConfig struct {
 	// CouchDB is the configuration for CouchDB.  It is used when StateDatabase
 	// is set to "CouchDB".
 	CouchDB *CouchDBConfig
+	// GroupCommit is the configuration for buffering state updates and writing
+	// them to the state database in grouped batches.
+	GroupCommit *GroupCommitConfig
+}
+
+// GroupCommitConfig is a structure used to configure write-behind group commits
+// of validated state updates.
+type GroupCommitConfig struct {
+	// Enabled turns on buffering of state updates across blocks.
+	Enabled bool
+	// MaxBlocks is the maximum number of blocks whose updates are grouped
+	// into a single write to the state database.
+	MaxBlocks int
+	// MaxDelay is the maximum time an update is buffered before it is flushed.
+	MaxDelay time.Duration
 }
 
 // CouchDBConfig is a structure used to configure a CouchInstance.
@@ -434,8 +449,34 @@ type CommitOptions struct {
 	// parallelizing applyWriteSet within each level (safe because the DAG
 	// guarantees no R/W set overlap at the same level).
 	DAGLevels map[int][]int
+	// AbortReasons maps the index of each transaction invalidated by the
+	// DAG-based committer to the reason it was invalidated. The reasons are
+	// surfaced in commit notifications and ledger metrics.
+	AbortReasons map[int]AbortReason
 }
 
+// AbortReason explains why the DAG-based committer invalidated a transaction.
+// It refines the TxValidationCode recorded in the block's transaction filter.
+type AbortReason string
+
+const (
+	// AbortReasonMVCCConflict indicates a read/write conflict with another transaction
+	AbortReasonMVCCConflict AbortReason = "mvcc_conflict"
+	// AbortReasonDependencyInvalid indicates that a predecessor in the DAG was invalid
+	AbortReasonDependencyInvalid AbortReason = "dependency_invalid"
+	// AbortReasonMissingEndorsement indicates that an endorsement lacked the
+	// endorser identity or its signature. The signatures themselves are
+	// verified by the validator before the block reaches the committer.
+	AbortReasonMissingEndorsement AbortReason = "missing_endorsement"
+	// AbortReasonParseError indicates that the transaction could not be unmarshaled
+	AbortReasonParseError AbortReason = "parse_error"
+	// AbortReasonChaincodeFailure indicates that the chaincode response carried an error status
+	AbortReasonChaincodeFailure AbortReason = "chaincode_failure"
+	// AbortReasonProofInvalid indicates that the embedded shard prepare proofs
+	// did not verify or did not support the transaction's dependency claims
+	AbortReasonProofInvalid AbortReason = "proof_invalid"
+)
+
 // PvtCollFilter represents the set of the collection names (as keys of the map with value 'true')
 type PvtCollFilter map[string]bool
 
@@ -828,6 +869,8 @@ type CommitNotificationTxInfo struct {
 	ValidationCode     peer.TxValidationCode
 	ChaincodeID        *peer.ChaincodeID
 	ChaincodeEventData []byte
+	// AbortReason is set when the DAG-based committer invalidated the transaction
+	AbortReason AbortReason
 }
 
 //go:generate counterfeiter -o mock/state_listener.go -fake-name StateListener . StateListener
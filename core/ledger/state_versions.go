@@ -0,0 +1,63 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package ledger
+
+import (
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-protos-go/ledger/rwset/kvrwset"
+	"github.com/pkg/errors"
+)
+
+// ReadVersions returns the version of each public key read by a simulation,
+// by namespace. A key that did not exist has a nil version.
+func ReadVersions(simResults *TxSimulationResults) (map[string]map[string]*kvrwset.Version, error) {
+	versions := make(map[string]map[string]*kvrwset.Version)
+	if simResults == nil || simResults.PubSimulationResults == nil {
+		return versions, nil
+	}
+	for _, nsRWSet := range simResults.PubSimulationResults.NsRwset {
+		kvRWSet := &kvrwset.KVRWSet{}
+		if err := proto.Unmarshal(nsRWSet.Rwset, kvRWSet); err != nil {
+			return nil, errors.Wrapf(err, "failed to unmarshal rwset for namespace %s", nsRWSet.Namespace)
+		}
+		for _, read := range kvRWSet.Reads {
+			if versions[nsRWSet.Namespace] == nil {
+				versions[nsRWSet.Namespace] = make(map[string]*kvrwset.Version)
+			}
+			versions[nsRWSet.Namespace][read.Key] = read.Version
+		}
+	}
+	return versions, nil
+}
+
+// CommittedVersions reads the keys, given by namespace, with a simulator,
+// which records the versions committed at the time as it reads them, and
+// returns those versions as ReadVersions does. The simulator should be
+// dedicated to this read; the caller remains responsible for calling Done.
+func CommittedVersions(sim TxSimulator, keys map[string][]string) (map[string]map[string]*kvrwset.Version, error) {
+	for namespace, nsKeys := range keys {
+		for _, key := range nsKeys {
+			if _, err := sim.GetState(namespace, key); err != nil {
+				return nil, errors.WithMessagef(err, "failed to read key %s of namespace %s", key, namespace)
+			}
+		}
+	}
+	results, err := sim.GetTxSimulationResults()
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to get the versions read")
+	}
+	return ReadVersions(results)
+}
+
+// SameVersion reports whether two versions are equal, nil standing for a key
+// that does not exist
+func SameVersion(v1, v2 *kvrwset.Version) bool {
+	if v1 == nil || v2 == nil {
+		return v1 == v2
+	}
+	return v1.BlockNum == v2.BlockNum && v1.TxNum == v2.TxNum
+}
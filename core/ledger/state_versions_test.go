@@ -0,0 +1,54 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package ledger
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-protos-go/ledger/rwset"
+	"github.com/hyperledger/fabric-protos-go/ledger/rwset/kvrwset"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadVersions(t *testing.T) {
+	versions, err := ReadVersions(nil)
+	require.NoError(t, err)
+	require.Empty(t, versions)
+
+	kvRWSet, err := proto.Marshal(&kvrwset.KVRWSet{
+		Reads: []*kvrwset.KVRead{
+			{Key: "a", Version: &kvrwset.Version{BlockNum: 3, TxNum: 1}},
+			{Key: "b"},
+		},
+		Writes: []*kvrwset.KVWrite{{Key: "c"}},
+	})
+	require.NoError(t, err)
+	versions, err = ReadVersions(&TxSimulationResults{
+		PubSimulationResults: &rwset.TxReadWriteSet{
+			NsRwset: []*rwset.NsReadWriteSet{{Namespace: "ns", Rwset: kvRWSet}},
+		},
+	})
+	require.NoError(t, err)
+	require.Len(t, versions["ns"], 2)
+	require.True(t, SameVersion(&kvrwset.Version{BlockNum: 3, TxNum: 1}, versions["ns"]["a"]))
+	require.Nil(t, versions["ns"]["b"])
+
+	_, err = ReadVersions(&TxSimulationResults{
+		PubSimulationResults: &rwset.TxReadWriteSet{
+			NsRwset: []*rwset.NsReadWriteSet{{Namespace: "ns", Rwset: []byte("garbage")}},
+		},
+	})
+	require.ErrorContains(t, err, "failed to unmarshal rwset for namespace ns")
+}
+
+func TestSameVersion(t *testing.T) {
+	require.True(t, SameVersion(nil, nil))
+	require.False(t, SameVersion(nil, &kvrwset.Version{}))
+	require.False(t, SameVersion(&kvrwset.Version{BlockNum: 1}, &kvrwset.Version{BlockNum: 1, TxNum: 1}))
+	require.True(t, SameVersion(&kvrwset.Version{BlockNum: 1, TxNum: 2}, &kvrwset.Version{BlockNum: 1, TxNum: 2}))
+}
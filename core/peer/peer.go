@@ -150,6 +150,11 @@ type Peer struct {
 	LedgerMgr                *ledgermgmt.LedgerMgr
 	OrdererEndpointOverrides map[string]*orderers.Endpoint
 	CryptoProvider           bccsp.BCCSP
+	// ConflictDetector is the conflict policy of the committers of the
+	// channels; the key-overlap policy applies when nil
+	ConflictDetector committer.ConflictDetector
+	// CommitterMetrics are the metrics of the committers of the channels
+	CommitterMetrics *committer.Metrics
 
 	// validationWorkersSemaphore is used to limit the number of concurrent validation
 	// go routines.
@@ -329,7 +334,11 @@ func (p *Peer) createChannel(
 		callbacks...,
 	)
 
+	stateVersions := committer.NewSimulatedStateVersions(l)
 	committer := committer.NewLedgerCommitter(l)
+	committer.ConflictDetector = p.ConflictDetector
+	committer.Metrics = p.CommitterMetrics
+	committer.StateVersions = stateVersions
 	validator := &txvalidator.ValidationRouter{
 		CapabilityProvider: channel,
 		V14Validator: validatorv14.NewTxValidator(
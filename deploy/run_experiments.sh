@@ -18,6 +18,10 @@ fi
 
 FABRIC_VERSION=$1
 
+# Optional per-shard dependency rates for skewed workloads, e.g.
+# SHARD_DEPENDENCY="fabcar=0.6,marbles=0.1" ./run_experiments.sh proposed
+SHARD_DEPENDENCY=${SHARD_DEPENDENCY:-}
+
 # Helper to run the benchmark client
 run_benchmark() {
     local tx_count=$1
@@ -43,6 +47,7 @@ run_benchmark() {
         --pcross "${pcross}" \
         --threads "${threads}" \
         --shards "${shards_arg}" \
+        ${SHARD_DEPENDENCY:+--shard-dependency "${SHARD_DEPENDENCY}"} \
         | tee "${log_file}"
 
     echo "Pushing metrics to CouchDB Analytics backend..."
@@ -7,11 +7,18 @@ SPDX-License-Identifier: Apache-2.0
 package node
 
 import (
+	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/hyperledger/fabric/core/committer"
 	coreconfig "github.com/hyperledger/fabric/core/config"
+	"github.com/hyperledger/fabric/core/endorser"
+	"github.com/hyperledger/fabric/core/endorser/sharding"
 	"github.com/hyperledger/fabric/core/ledger"
+	"github.com/pkg/errors"
 	"github.com/spf13/viper"
 )
 
@@ -73,6 +80,14 @@ func ledgerConfig() *ledger.Config {
 		},
 	}
 
+	if viper.GetBool("ledger.state.groupCommit.enabled") {
+		conf.StateDBConfig.GroupCommit = &ledger.GroupCommitConfig{
+			Enabled:   true,
+			MaxBlocks: viper.GetInt("ledger.state.groupCommit.maxBlocks"),
+			MaxDelay:  viper.GetDuration("ledger.state.groupCommit.maxDelay"),
+		}
+	}
+
 	if conf.StateDBConfig.StateDatabase == ledger.CouchDB {
 		conf.StateDBConfig.CouchDB = &ledger.CouchDBConfig{
 			Address:               viper.GetString("ledger.state.couchDBConfig.couchDBAddress"),
@@ -90,3 +105,312 @@ func ledgerConfig() *ledger.Config {
 	}
 	return conf
 }
+
+// endorserConfig returns the configuration of the endorser and of its
+// dependency shards from the peer.endorser section
+func endorserConfig() (endorser.EndorserConfig, sharding.ShardManagerOptions, error) {
+	role, err := endorser.ParseEndorserRole(viper.GetString("peer.endorser.sharding.role"))
+	if err != nil {
+		return endorser.EndorserConfig{}, sharding.ShardManagerOptions{}, errors.WithMessage(err, "invalid peer.endorser.sharding.role")
+	}
+	endorserID := viper.GetString("peer.endorser.sharding.endorserID")
+	if endorserID == "" {
+		endorserID = viper.GetString("peer.id")
+	}
+	prepareTimeout := endorser.DefaultPrepareTimeout
+	if viper.IsSet("peer.endorser.sharding.prepareTimeout") {
+		prepareTimeout = viper.GetDuration("peer.endorser.sharding.prepareTimeout")
+	}
+	expiryDuration := sharding.DefaultExpiryDuration
+	if viper.IsSet("peer.endorser.sharding.expiryDuration") {
+		expiryDuration = viper.GetDuration("peer.endorser.sharding.expiryDuration")
+	}
+	if prepareTimeout <= 0 || expiryDuration <= 0 {
+		return endorser.EndorserConfig{}, sharding.ShardManagerOptions{}, errors.Errorf("peer.endorser.sharding.prepareTimeout and expiryDuration must be positive, got %s and %s", prepareTimeout, expiryDuration)
+	}
+	maxDependencies := viper.GetInt("peer.endorser.sharding.maxDependencies")
+	if maxDependencies < 0 {
+		return endorser.EndorserConfig{}, sharding.ShardManagerOptions{}, errors.Errorf("peer.endorser.sharding.maxDependencies must not be negative, got %d", maxDependencies)
+	}
+	prepareQuorum := viper.GetFloat64("peer.endorser.sharding.prepareQuorum")
+	if prepareQuorum < 0 || prepareQuorum > 1 {
+		return endorser.EndorserConfig{}, sharding.ShardManagerOptions{}, errors.Errorf("peer.endorser.sharding.prepareQuorum must be between 0 and 1, got %g", prepareQuorum)
+	}
+	prepareRetry := endorser.PrepareRetryConfig{
+		Attempts:   viper.GetInt("peer.endorser.sharding.prepareRetry.attempts"),
+		Backoff:    viper.GetDuration("peer.endorser.sharding.prepareRetry.backoff"),
+		MaxBackoff: viper.GetDuration("peer.endorser.sharding.prepareRetry.maxBackoff"),
+	}
+	if prepareRetry.Attempts < 0 || prepareRetry.Backoff < 0 || prepareRetry.MaxBackoff < 0 {
+		return endorser.EndorserConfig{}, sharding.ShardManagerOptions{}, errors.Errorf("peer.endorser.sharding.prepareRetry must not be negative, got %d attempts, backoff %s and maxBackoff %s", prepareRetry.Attempts, prepareRetry.Backoff, prepareRetry.MaxBackoff)
+	}
+
+	hedgeDelay := viper.GetDuration("peer.endorser.sharding.hedgeDelay")
+	if hedgeDelay < 0 {
+		return endorser.EndorserConfig{}, sharding.ShardManagerOptions{}, errors.Errorf("peer.endorser.sharding.hedgeDelay must not be negative, got %s", hedgeDelay)
+	}
+	dependencyWait := viper.GetDuration("peer.endorser.sharding.dependencyWait")
+	if dependencyWait < 0 {
+		return endorser.EndorserConfig{}, sharding.ShardManagerOptions{}, errors.Errorf("peer.endorser.sharding.dependencyWait must not be negative, got %s", dependencyWait)
+	}
+	gossipInterval := viper.GetDuration("peer.endorser.sharding.gossipInterval")
+	if gossipInterval < 0 {
+		return endorser.EndorserConfig{}, sharding.ShardManagerOptions{}, errors.Errorf("peer.endorser.sharding.gossipInterval must not be negative, got %s", gossipInterval)
+	}
+	leaderFailoverThreshold := viper.GetInt("peer.endorser.sharding.leaderFailoverThreshold")
+	if leaderFailoverThreshold < 0 {
+		return endorser.EndorserConfig{}, sharding.ShardManagerOptions{}, errors.Errorf("peer.endorser.sharding.leaderFailoverThreshold must not be negative, got %d", leaderFailoverThreshold)
+	}
+	responseCache := endorser.ResponseCacheConfig{
+		TTL:        viper.GetDuration("peer.endorser.responseCache.ttl"),
+		MaxEntries: viper.GetInt("peer.endorser.responseCache.maxEntries"),
+	}
+	if responseCache.TTL < 0 || responseCache.MaxEntries < 0 {
+		return endorser.EndorserConfig{}, sharding.ShardManagerOptions{}, errors.Errorf("peer.endorser.responseCache must not be negative, got ttl %s and maxEntries %d", responseCache.TTL, responseCache.MaxEntries)
+	}
+	idempotency := endorser.IdempotencyConfig{
+		TTL:        viper.GetDuration("peer.endorser.idempotency.ttl"),
+		MaxEntries: viper.GetInt("peer.endorser.idempotency.maxEntries"),
+	}
+	if idempotency.TTL < 0 || idempotency.MaxEntries < 0 {
+		return endorser.EndorserConfig{}, sharding.ShardManagerOptions{}, errors.Errorf("peer.endorser.idempotency must not be negative, got ttl %s and maxEntries %d", idempotency.TTL, idempotency.MaxEntries)
+	}
+	prepareLanes := endorser.PrepareLanesConfig{
+		Concurrency:      viper.GetInt("peer.endorser.sharding.lanes.concurrency"),
+		Operators:        viper.GetStringSlice("peer.endorser.sharding.lanes.operators"),
+		SystemChaincodes: viper.GetStringSlice("peer.endorser.sharding.lanes.systemChaincodes"),
+	}
+	if prepareLanes.Concurrency < 0 {
+		return endorser.EndorserConfig{}, sharding.ShardManagerOptions{}, errors.Errorf("peer.endorser.sharding.lanes.concurrency must not be negative, got %d", prepareLanes.Concurrency)
+	}
+	prepareBatch := endorser.PrepareBatchConfig{
+		Window:  viper.GetDuration("peer.endorser.sharding.prepareBatch.window"),
+		MaxSize: viper.GetInt("peer.endorser.sharding.prepareBatch.maxSize"),
+	}
+	if prepareBatch.Window < 0 || prepareBatch.MaxSize < 0 {
+		return endorser.EndorserConfig{}, sharding.ShardManagerOptions{}, errors.Errorf("peer.endorser.sharding.prepareBatch must not be negative, got window %s and maxSize %d", prepareBatch.Window, prepareBatch.MaxSize)
+	}
+	maxProposalBatch := viper.GetInt("peer.endorser.maxProposalBatch")
+	if maxProposalBatch < 0 {
+		return endorser.EndorserConfig{}, sharding.ShardManagerOptions{}, errors.Errorf("peer.endorser.maxProposalBatch must not be negative, got %d", maxProposalBatch)
+	}
+	recoveryPolicy := viper.GetString("peer.endorser.sharding.recoveryPolicy")
+	switch recoveryPolicy {
+	case "", endorser.RecoveryAbort, endorser.RecoveryResubscribe:
+	default:
+		return endorser.EndorserConfig{}, sharding.ShardManagerOptions{}, errors.Errorf("invalid peer.endorser.sharding.recoveryPolicy %q, expected %s or %s", recoveryPolicy, endorser.RecoveryAbort, endorser.RecoveryResubscribe)
+	}
+	rateLimit, err := rateLimitConfig()
+	if err != nil {
+		return endorser.EndorserConfig{}, sharding.ShardManagerOptions{}, err
+	}
+	dependencyStores, err := dependencyStoresConfig(expiryDuration)
+	if err != nil {
+		return endorser.EndorserConfig{}, sharding.ShardManagerOptions{}, err
+	}
+	shardLeaders, err := shardLeadersConfig()
+	if err != nil {
+		return endorser.EndorserConfig{}, sharding.ShardManagerOptions{}, err
+	}
+	chaincodeExpiry, err := chaincodeExpiryConfig()
+	if err != nil {
+		return endorser.EndorserConfig{}, sharding.ShardManagerOptions{}, err
+	}
+	conflictOracle, err := conflictOracleConfig()
+	if err != nil {
+		return endorser.EndorserConfig{}, sharding.ShardManagerOptions{}, err
+	}
+
+	conf := endorser.EndorserConfig{
+		Role:                    role,
+		LeaderEndorser:          viper.GetString("peer.endorser.sharding.leaderEndorser"),
+		LeaderElectionShard:     viper.GetString("peer.endorser.sharding.leaderElectionShard"),
+		LeaderCandidates:        viper.GetStringSlice("peer.endorser.sharding.leaderCandidates"),
+		LeaderFailoverThreshold: leaderFailoverThreshold,
+		Address:                 viper.GetString("peer.address"),
+		EndorserID:              endorserID,
+		ShardingEnabled:         viper.GetBool("peer.endorser.sharding.enabled"),
+		PrepareTimeout:          prepareTimeout,
+		PrepareRetry:            prepareRetry,
+		PrepareQuorum:           prepareQuorum,
+		ExpiryDuration:          expiryDuration,
+		ChaincodeExpiry:         chaincodeExpiry,
+		SpeculativeEndorsement:  viper.GetBool("peer.endorser.sharding.speculative"),
+		ResponseCache:           responseCache,
+		Idempotency:             idempotency,
+		RateLimit:               rateLimit,
+		PrepareLanes:            prepareLanes,
+		PrepareBatch:            prepareBatch,
+		TwoPhaseCommit:          viper.GetBool("peer.endorser.sharding.twoPhaseCommit"),
+		MVCCPreCheck:            viper.GetBool("peer.endorser.mvccPreCheck"),
+		DependencyStores:        dependencyStores,
+		ShardLeaders:            shardLeaders,
+		DependencyWait:          dependencyWait,
+		ConflictOracle:          conflictOracle,
+		RecoveryPolicy:          recoveryPolicy,
+		MaxProposalBatch:        maxProposalBatch,
+		ShardingPolicy: endorser.ShardingPolicy{
+			Channels:          viper.GetStringSlice("peer.endorser.sharding.policy.channels"),
+			Chaincodes:        viper.GetStringSlice("peer.endorser.sharding.policy.chaincodes"),
+			ExcludeChaincodes: viper.GetStringSlice("peer.endorser.sharding.policy.excludeChaincodes"),
+			Untracked: endorser.UntrackedKeys{
+				Namespaces:  viper.GetStringSlice("peer.endorser.sharding.policy.untracked.namespaces"),
+				Collections: viper.GetStringSlice("peer.endorser.sharding.policy.untracked.collections"),
+				KeyPrefixes: viper.GetStringSlice("peer.endorser.sharding.policy.untracked.keyPrefixes"),
+			},
+		},
+	}
+	// The shards persist their reservations under the peer's file system
+	// path unless told otherwise, so that a restart does not forget them
+	dataDir := coreconfig.GetPath("peer.endorser.sharding.dataDir")
+	if dataDir == "" && os.Getenv(sharding.ShardDataDirEnvVar) == "" {
+		if fsPath := coreconfig.GetPath("peer.fileSystemPath"); fsPath != "" {
+			dataDir = filepath.Join(fsPath, "shards")
+		}
+	}
+	opts := sharding.ShardManagerOptions{
+		TopologyFile:    coreconfig.GetPath("peer.endorser.sharding.topology"),
+		DependencyTTL:   expiryDuration,
+		DataDir:         dataDir,
+		MaxDependencies: maxDependencies,
+		HedgeDelay:      hedgeDelay,
+		GossipInterval:  gossipInterval,
+		BackupDir:       coreconfig.GetPath("peer.endorser.sharding.backupDir"),
+	}
+	return conf, opts, nil
+}
+
+// rateLimitConfig returns the per-client rate limits of the
+// peer.endorser.rateLimit section, whose mspRates are given as MSPID=rate
+func rateLimitConfig() (endorser.RateLimitConfig, error) {
+	conf := endorser.RateLimitConfig{
+		Rate:  viper.GetFloat64("peer.endorser.rateLimit.rate"),
+		Burst: viper.GetInt("peer.endorser.rateLimit.burst"),
+	}
+	if conf.Rate < 0 || conf.Burst < 0 {
+		return endorser.RateLimitConfig{}, errors.Errorf("peer.endorser.rateLimit must not be negative, got rate %g and burst %d", conf.Rate, conf.Burst)
+	}
+	for _, entry := range viper.GetStringSlice("peer.endorser.rateLimit.mspRates") {
+		mspID, rate, ok := strings.Cut(entry, "=")
+		r, err := strconv.ParseFloat(strings.TrimSpace(rate), 64)
+		if !ok || mspID == "" || err != nil || r < 0 {
+			return endorser.RateLimitConfig{}, errors.Errorf("invalid peer.endorser.rateLimit.mspRates entry %q, expected MSPID=rate", entry)
+		}
+		if conf.MSPRates == nil {
+			conf.MSPRates = make(map[string]float64)
+		}
+		conf.MSPRates[strings.TrimSpace(mspID)] = r
+	}
+	return conf, nil
+}
+
+// conflictOracleConfig returns the conflict oracle of
+// peer.endorser.conflictOracle
+func conflictOracleConfig() (endorser.ConflictOracleConfig, error) {
+	conf := endorser.ConflictOracleConfig{
+		Address:       viper.GetString("peer.endorser.conflictOracle.address"),
+		Timeout:       viper.GetDuration("peer.endorser.conflictOracle.timeout"),
+		FailurePolicy: viper.GetString("peer.endorser.conflictOracle.failurePolicy"),
+	}
+	if conf.Timeout < 0 {
+		return endorser.ConflictOracleConfig{}, errors.Errorf("peer.endorser.conflictOracle.timeout must not be negative, got %s", conf.Timeout)
+	}
+	switch conf.FailurePolicy {
+	case "", endorser.OracleFailOpen, endorser.OracleFailClosed:
+	default:
+		return endorser.ConflictOracleConfig{}, errors.Errorf("invalid peer.endorser.conflictOracle.failurePolicy %q, expected %s or %s", conf.FailurePolicy, endorser.OracleFailOpen, endorser.OracleFailClosed)
+	}
+	return conf, nil
+}
+
+// dependencyStoresConfig returns the dependency stores selected by channel in
+// peer.endorser.sharding.dependencyStores, whose entries are given as
+// channel=backend or channel=backend:endpoint,endpoint
+func dependencyStoresConfig(ttl time.Duration) (map[string]sharding.DependencyStoreConfig, error) {
+	var stores map[string]sharding.DependencyStoreConfig
+	for _, entry := range viper.GetStringSlice("peer.endorser.sharding.dependencyStores") {
+		channel, store, ok := strings.Cut(entry, "=")
+		backend, endpoints, _ := strings.Cut(strings.TrimSpace(store), ":")
+		channel, backend = strings.TrimSpace(channel), strings.TrimSpace(backend)
+		if !ok || channel == "" || backend == "" {
+			return nil, errors.Errorf("invalid peer.endorser.sharding.dependencyStores entry %q, expected channel=backend or channel=backend:endpoints", entry)
+		}
+		conf := sharding.DependencyStoreConfig{Backend: backend, TTL: ttl}
+		for _, ep := range strings.Split(endpoints, ",") {
+			if ep = strings.TrimSpace(ep); ep != "" {
+				conf.Endpoints = append(conf.Endpoints, ep)
+			}
+		}
+		if stores == nil {
+			stores = make(map[string]sharding.DependencyStoreConfig)
+		}
+		stores[channel] = conf
+	}
+	return stores, nil
+}
+
+// shardLeadersConfig returns the leader endorsers by chaincode of
+// peer.endorser.sharding.shardLeaders, whose entries are given as
+// chaincode=address
+func shardLeadersConfig() (map[string]string, error) {
+	var leaders map[string]string
+	for _, entry := range viper.GetStringSlice("peer.endorser.sharding.shardLeaders") {
+		chaincode, leader, ok := strings.Cut(entry, "=")
+		chaincode, leader = strings.TrimSpace(chaincode), strings.TrimSpace(leader)
+		if !ok || chaincode == "" || leader == "" {
+			return nil, errors.Errorf("invalid peer.endorser.sharding.shardLeaders entry %q, expected chaincode=address", entry)
+		}
+		if leaders == nil {
+			leaders = make(map[string]string)
+		}
+		leaders[chaincode] = leader
+	}
+	return leaders, nil
+}
+
+// chaincodeExpiryConfig returns the expiry overrides of
+// peer.endorser.sharding.chaincodeExpiry, whose entries are given as
+// scope=duration, the scope being channel/chaincode, chaincode or channel/*
+func chaincodeExpiryConfig() (map[string]time.Duration, error) {
+	var overrides map[string]time.Duration
+	for _, entry := range viper.GetStringSlice("peer.endorser.sharding.chaincodeExpiry") {
+		scope, value, ok := strings.Cut(entry, "=")
+		scope, value = strings.TrimSpace(scope), strings.TrimSpace(value)
+		if !ok || scope == "" || value == "" {
+			return nil, errors.Errorf("invalid peer.endorser.sharding.chaincodeExpiry entry %q, expected scope=duration", entry)
+		}
+		expiry, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid peer.endorser.sharding.chaincodeExpiry entry %q", entry)
+		}
+		if expiry <= 0 {
+			return nil, errors.Errorf("peer.endorser.sharding.chaincodeExpiry entry %q must be positive", entry)
+		}
+		if overrides == nil {
+			overrides = make(map[string]time.Duration)
+		}
+		overrides[scope] = expiry
+	}
+	return overrides, nil
+}
+
+// loadShardTopology loads the topology of the dependency shards from the file
+// named in the options, or else as configured via FABRIC_SHARD_TOPOLOGY
+func loadShardTopology(opts sharding.ShardManagerOptions) (*sharding.ShardTopology, error) {
+	if opts.TopologyFile != "" {
+		return sharding.LoadShardTopology(opts.TopologyFile)
+	}
+	return sharding.LoadShardTopologyFromEnv()
+}
+
+// committerConflictDetector returns the detector of the conflict policy of
+// peer.committer.conflictPolicy
+func committerConflictDetector() (committer.ConflictDetector, error) {
+	policy := viper.GetString("peer.committer.conflictPolicy")
+	detector, err := committer.NewConflictDetector(policy)
+	if err != nil {
+		return nil, errors.Errorf("invalid peer.committer.conflictPolicy %q, expected %s, %s or %s", policy,
+			committer.ConflictPolicyKeyOverlap, committer.ConflictPolicyMVCC, committer.ConflictPolicyEndorserHints)
+	}
+	return detector, nil
+}
@@ -10,6 +10,9 @@ import (
 	"testing"
 	"time"
 
+	"github.com/hyperledger/fabric/core/committer"
+	"github.com/hyperledger/fabric/core/endorser"
+	"github.com/hyperledger/fabric/core/endorser/sharding"
 	"github.com/hyperledger/fabric/core/ledger"
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/require"
@@ -165,3 +168,343 @@ func TestLedgerConfig(t *testing.T) {
 		})
 	}
 }
+
+func TestEndorserConfig(t *testing.T) {
+	defer viper.Reset()
+
+	viper.Set("peer.id", "peer0")
+	conf, opts, err := endorserConfig()
+	require.NoError(t, err)
+	require.Equal(t, endorser.EndorserConfig{
+		Role:           endorser.NormalEndorser,
+		EndorserID:     "peer0",
+		PrepareTimeout: endorser.DefaultPrepareTimeout,
+		ExpiryDuration: sharding.DefaultExpiryDuration,
+	}, conf)
+	require.Equal(t, sharding.ShardManagerOptions{DependencyTTL: conf.ExpiryDuration}, opts)
+
+	viper.Set("peer.endorser.sharding.enabled", true)
+	viper.Set("peer.endorser.sharding.role", "leader")
+	viper.Set("peer.endorser.sharding.leaderEndorser", "peer1:7051")
+	viper.Set("peer.endorser.sharding.endorserID", "endorser0")
+	viper.Set("peer.endorser.sharding.prepareTimeout", "5s")
+	viper.Set("peer.endorser.sharding.expiryDuration", "1m")
+	viper.Set("peer.endorser.sharding.topology", "/etc/hyperledger/topology.json")
+	viper.Set("peer.endorser.sharding.policy.channels", []string{"mychannel"})
+	viper.Set("peer.endorser.sharding.policy.excludeChaincodes", "lowcontention mychannel/marbles")
+	viper.Set("peer.endorser.sharding.policy.untracked.collections", []string{"fabcar:audit"})
+	viper.Set("peer.endorser.sharding.policy.untracked.keyPrefixes", "fabcar:counter")
+	conf, opts, err = endorserConfig()
+	require.NoError(t, err)
+	require.Equal(t, endorser.EndorserConfig{
+		Role:            endorser.LeaderEndorser,
+		LeaderEndorser:  "peer1:7051",
+		EndorserID:      "endorser0",
+		ShardingEnabled: true,
+		PrepareTimeout:  5 * time.Second,
+		ExpiryDuration:  time.Minute,
+		ShardingPolicy: endorser.ShardingPolicy{
+			Channels:          []string{"mychannel"},
+			ExcludeChaincodes: []string{"lowcontention", "mychannel/marbles"},
+			Untracked: endorser.UntrackedKeys{
+				Collections: []string{"fabcar:audit"},
+				KeyPrefixes: []string{"fabcar:counter"},
+			},
+		},
+	}, conf)
+	require.Equal(t, sharding.ShardManagerOptions{
+		TopologyFile:  "/etc/hyperledger/topology.json",
+		DependencyTTL: time.Minute,
+	}, opts)
+
+	// the shards persist their state under the peer's file system path
+	viper.Set("peer.fileSystemPath", "/var/hyperledger/production")
+	_, opts, err = endorserConfig()
+	require.NoError(t, err)
+	require.Equal(t, "/var/hyperledger/production/shards", opts.DataDir)
+
+	t.Setenv(sharding.ShardDataDirEnvVar, "/var/shards")
+	_, opts, err = endorserConfig()
+	require.NoError(t, err)
+	require.Empty(t, opts.DataDir)
+
+	viper.Set("peer.endorser.sharding.dataDir", "/data/shards")
+	_, opts, err = endorserConfig()
+	require.NoError(t, err)
+	require.Equal(t, "/data/shards", opts.DataDir)
+
+	viper.Set("peer.endorser.sharding.maxDependencies", 100000)
+	_, opts, err = endorserConfig()
+	require.NoError(t, err)
+	require.Equal(t, 100000, opts.MaxDependencies)
+
+	viper.Set("peer.endorser.sharding.maxDependencies", -1)
+	_, _, err = endorserConfig()
+	require.EqualError(t, err, "peer.endorser.sharding.maxDependencies must not be negative, got -1")
+	viper.Set("peer.endorser.sharding.maxDependencies", 0)
+
+	viper.Set("peer.endorser.sharding.hedgeDelay", "200ms")
+	_, opts, err = endorserConfig()
+	require.NoError(t, err)
+	require.Equal(t, 200*time.Millisecond, opts.HedgeDelay)
+
+	viper.Set("peer.endorser.sharding.hedgeDelay", "-1s")
+	_, _, err = endorserConfig()
+	require.EqualError(t, err, "peer.endorser.sharding.hedgeDelay must not be negative, got -1s")
+	viper.Set("peer.endorser.sharding.hedgeDelay", "0s")
+
+	viper.Set("peer.endorser.sharding.dependencyWait", "2s")
+	conf, _, err = endorserConfig()
+	require.NoError(t, err)
+	require.Equal(t, 2*time.Second, conf.DependencyWait)
+
+	viper.Set("peer.endorser.sharding.dependencyWait", "-1s")
+	_, _, err = endorserConfig()
+	require.EqualError(t, err, "peer.endorser.sharding.dependencyWait must not be negative, got -1s")
+	viper.Set("peer.endorser.sharding.dependencyWait", "0s")
+
+	viper.Set("peer.endorser.sharding.gossipInterval", "500ms")
+	_, opts, err = endorserConfig()
+	require.NoError(t, err)
+	require.Equal(t, 500*time.Millisecond, opts.GossipInterval)
+
+	viper.Set("peer.endorser.sharding.gossipInterval", "-1s")
+	_, _, err = endorserConfig()
+	require.EqualError(t, err, "peer.endorser.sharding.gossipInterval must not be negative, got -1s")
+	viper.Set("peer.endorser.sharding.gossipInterval", "0s")
+
+	viper.Set("peer.endorser.sharding.backupDir", "/var/backups/shards")
+	_, opts, err = endorserConfig()
+	require.NoError(t, err)
+	require.Equal(t, "/var/backups/shards", opts.BackupDir)
+	viper.Set("peer.endorser.sharding.backupDir", "")
+
+	viper.Set("peer.endorser.sharding.prepareRetry.attempts", 3)
+	viper.Set("peer.endorser.sharding.prepareRetry.backoff", "50ms")
+	conf, _, err = endorserConfig()
+	require.NoError(t, err)
+	require.Equal(t, endorser.PrepareRetryConfig{Attempts: 3, Backoff: 50 * time.Millisecond}, conf.PrepareRetry)
+
+	viper.Set("peer.endorser.sharding.prepareRetry.attempts", -1)
+	_, _, err = endorserConfig()
+	require.EqualError(t, err, "peer.endorser.sharding.prepareRetry must not be negative, got -1 attempts, backoff 50ms and maxBackoff 0s")
+	viper.Set("peer.endorser.sharding.prepareRetry.attempts", 0)
+
+	viper.Set("peer.endorser.sharding.prepareQuorum", 0.5)
+	conf, _, err = endorserConfig()
+	require.NoError(t, err)
+	require.Equal(t, 0.5, conf.PrepareQuorum)
+
+	viper.Set("peer.endorser.sharding.prepareQuorum", 2)
+	_, _, err = endorserConfig()
+	require.EqualError(t, err, "peer.endorser.sharding.prepareQuorum must be between 0 and 1, got 2")
+	viper.Set("peer.endorser.sharding.prepareQuorum", 0)
+
+	viper.Set("peer.endorser.sharding.speculative", true)
+	conf, _, err = endorserConfig()
+	require.NoError(t, err)
+	require.True(t, conf.SpeculativeEndorsement)
+	viper.Set("peer.endorser.sharding.speculative", false)
+
+	viper.Set("peer.endorser.sharding.twoPhaseCommit", true)
+	conf, _, err = endorserConfig()
+	require.NoError(t, err)
+	require.True(t, conf.TwoPhaseCommit)
+	viper.Set("peer.endorser.sharding.twoPhaseCommit", false)
+
+	viper.Set("peer.endorser.mvccPreCheck", true)
+	conf, _, err = endorserConfig()
+	require.NoError(t, err)
+	require.True(t, conf.MVCCPreCheck)
+	viper.Set("peer.endorser.mvccPreCheck", false)
+
+	viper.Set("peer.endorser.responseCache.ttl", "2s")
+	conf, _, err = endorserConfig()
+	require.NoError(t, err)
+	require.Equal(t, endorser.ResponseCacheConfig{TTL: 2 * time.Second}, conf.ResponseCache)
+
+	viper.Set("peer.endorser.responseCache.maxEntries", -1)
+	_, _, err = endorserConfig()
+	require.EqualError(t, err, "peer.endorser.responseCache must not be negative, got ttl 2s and maxEntries -1")
+	viper.Set("peer.endorser.responseCache.maxEntries", 0)
+	viper.Set("peer.endorser.responseCache.ttl", "0s")
+
+	viper.Set("peer.endorser.idempotency.ttl", "5m")
+	viper.Set("peer.endorser.idempotency.maxEntries", 100)
+	conf, _, err = endorserConfig()
+	require.NoError(t, err)
+	require.Equal(t, endorser.IdempotencyConfig{TTL: 5 * time.Minute, MaxEntries: 100}, conf.Idempotency)
+
+	viper.Set("peer.endorser.idempotency.ttl", "-1s")
+	_, _, err = endorserConfig()
+	require.EqualError(t, err, "peer.endorser.idempotency must not be negative, got ttl -1s and maxEntries 100")
+	viper.Set("peer.endorser.idempotency.maxEntries", 0)
+	viper.Set("peer.endorser.idempotency.ttl", "0s")
+
+	viper.Set("peer.endorser.rateLimit.rate", 50)
+	viper.Set("peer.endorser.rateLimit.mspRates", []string{"Org1MSP=100", "Org2MSP = 0"})
+	conf, _, err = endorserConfig()
+	require.NoError(t, err)
+	require.Equal(t, endorser.RateLimitConfig{Rate: 50, MSPRates: map[string]float64{"Org1MSP": 100, "Org2MSP": 0}}, conf.RateLimit)
+
+	viper.Set("peer.endorser.rateLimit.mspRates", []string{"Org1MSP"})
+	_, _, err = endorserConfig()
+	require.EqualError(t, err, `invalid peer.endorser.rateLimit.mspRates entry "Org1MSP", expected MSPID=rate`)
+	viper.Set("peer.endorser.rateLimit.mspRates", []string{})
+
+	viper.Set("peer.endorser.sharding.dependencyStores", []string{"bench=local", "mychannel = grpc:10.0.0.5:7060", "other=etcd:http://10.0.0.1:2379, http://10.0.0.2:2379"})
+	conf, _, err = endorserConfig()
+	require.NoError(t, err)
+	require.Equal(t, map[string]sharding.DependencyStoreConfig{
+		"bench":     {Backend: "local", TTL: conf.ExpiryDuration},
+		"mychannel": {Backend: "grpc", Endpoints: []string{"10.0.0.5:7060"}, TTL: conf.ExpiryDuration},
+		"other":     {Backend: "etcd", Endpoints: []string{"http://10.0.0.1:2379", "http://10.0.0.2:2379"}, TTL: conf.ExpiryDuration},
+	}, conf.DependencyStores)
+
+	viper.Set("peer.endorser.sharding.dependencyStores", []string{"bench"})
+	_, _, err = endorserConfig()
+	require.EqualError(t, err, `invalid peer.endorser.sharding.dependencyStores entry "bench", expected channel=backend or channel=backend:endpoints`)
+	viper.Set("peer.endorser.sharding.dependencyStores", []string{})
+
+	viper.Set("peer.endorser.sharding.shardLeaders", []string{"fabcar=peer0.org1.example.com:7051", " marbles = peer1.org1.example.com:7051 "})
+	conf, _, err = endorserConfig()
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"fabcar":  "peer0.org1.example.com:7051",
+		"marbles": "peer1.org1.example.com:7051",
+	}, conf.ShardLeaders)
+
+	viper.Set("peer.endorser.sharding.shardLeaders", []string{"fabcar="})
+	_, _, err = endorserConfig()
+	require.EqualError(t, err, `invalid peer.endorser.sharding.shardLeaders entry "fabcar=", expected chaincode=address`)
+	viper.Set("peer.endorser.sharding.shardLeaders", []string{})
+
+	viper.Set("peer.endorser.sharding.chaincodeExpiry", []string{"mychannel/fabcar=1m", " marbles = 30s ", "mychannel/*=10m"})
+	conf, _, err = endorserConfig()
+	require.NoError(t, err)
+	require.Equal(t, map[string]time.Duration{
+		"mychannel/fabcar": time.Minute,
+		"marbles":          30 * time.Second,
+		"mychannel/*":      10 * time.Minute,
+	}, conf.ChaincodeExpiry)
+
+	viper.Set("peer.endorser.sharding.chaincodeExpiry", []string{"fabcar"})
+	_, _, err = endorserConfig()
+	require.EqualError(t, err, `invalid peer.endorser.sharding.chaincodeExpiry entry "fabcar", expected scope=duration`)
+	viper.Set("peer.endorser.sharding.chaincodeExpiry", []string{"fabcar=0s"})
+	_, _, err = endorserConfig()
+	require.EqualError(t, err, `peer.endorser.sharding.chaincodeExpiry entry "fabcar=0s" must be positive`)
+	viper.Set("peer.endorser.sharding.chaincodeExpiry", []string{})
+
+	viper.Set("peer.endorser.conflictOracle.address", "oracle.example.com:7070")
+	viper.Set("peer.endorser.conflictOracle.timeout", "200ms")
+	viper.Set("peer.endorser.conflictOracle.failurePolicy", "closed")
+	conf, _, err = endorserConfig()
+	require.NoError(t, err)
+	require.Equal(t, endorser.ConflictOracleConfig{Address: "oracle.example.com:7070", Timeout: 200 * time.Millisecond, FailurePolicy: endorser.OracleFailClosed}, conf.ConflictOracle)
+
+	viper.Set("peer.endorser.conflictOracle.failurePolicy", "ajar")
+	_, _, err = endorserConfig()
+	require.EqualError(t, err, `invalid peer.endorser.conflictOracle.failurePolicy "ajar", expected open or closed`)
+	viper.Set("peer.endorser.conflictOracle.failurePolicy", "")
+	viper.Set("peer.endorser.conflictOracle.timeout", "-1s")
+	_, _, err = endorserConfig()
+	require.EqualError(t, err, "peer.endorser.conflictOracle.timeout must not be negative, got -1s")
+	viper.Set("peer.endorser.conflictOracle.timeout", "")
+	viper.Set("peer.endorser.conflictOracle.address", "")
+
+	viper.Set("peer.endorser.sharding.recoveryPolicy", "resubscribe")
+	conf, _, err = endorserConfig()
+	require.NoError(t, err)
+	require.Equal(t, endorser.RecoveryResubscribe, conf.RecoveryPolicy)
+	viper.Set("peer.endorser.sharding.recoveryPolicy", "forget")
+	_, _, err = endorserConfig()
+	require.EqualError(t, err, `invalid peer.endorser.sharding.recoveryPolicy "forget", expected abort or resubscribe`)
+	viper.Set("peer.endorser.sharding.recoveryPolicy", "")
+
+	viper.Set("peer.endorser.maxProposalBatch", 5000)
+	conf, _, err = endorserConfig()
+	require.NoError(t, err)
+	require.Equal(t, 5000, conf.MaxProposalBatch)
+	viper.Set("peer.endorser.maxProposalBatch", -1)
+	_, _, err = endorserConfig()
+	require.EqualError(t, err, "peer.endorser.maxProposalBatch must not be negative, got -1")
+	viper.Set("peer.endorser.maxProposalBatch", 0)
+
+	viper.Set("peer.endorser.rateLimit.burst", -1)
+	_, _, err = endorserConfig()
+	require.EqualError(t, err, "peer.endorser.rateLimit must not be negative, got rate 50 and burst -1")
+	viper.Set("peer.endorser.rateLimit.burst", 0)
+	viper.Set("peer.endorser.rateLimit.rate", 0)
+
+	viper.Set("peer.endorser.sharding.lanes.concurrency", 64)
+	viper.Set("peer.endorser.sharding.lanes.operators", []string{"AdminMSP"})
+	viper.Set("peer.endorser.sharding.lanes.systemChaincodes", []string{"governance"})
+	conf, _, err = endorserConfig()
+	require.NoError(t, err)
+	require.Equal(t, endorser.PrepareLanesConfig{Concurrency: 64, Operators: []string{"AdminMSP"}, SystemChaincodes: []string{"governance"}}, conf.PrepareLanes)
+
+	viper.Set("peer.endorser.sharding.lanes.concurrency", -1)
+	_, _, err = endorserConfig()
+	require.EqualError(t, err, "peer.endorser.sharding.lanes.concurrency must not be negative, got -1")
+	viper.Set("peer.endorser.sharding.lanes.concurrency", 0)
+
+	viper.Set("peer.endorser.sharding.prepareBatch.window", "2ms")
+	viper.Set("peer.endorser.sharding.prepareBatch.maxSize", 50)
+	conf, _, err = endorserConfig()
+	require.NoError(t, err)
+	require.Equal(t, endorser.PrepareBatchConfig{Window: 2 * time.Millisecond, MaxSize: 50}, conf.PrepareBatch)
+
+	viper.Set("peer.endorser.sharding.prepareBatch.maxSize", -1)
+	_, _, err = endorserConfig()
+	require.EqualError(t, err, "peer.endorser.sharding.prepareBatch must not be negative, got window 2ms and maxSize -1")
+	viper.Set("peer.endorser.sharding.prepareBatch.window", 0)
+	viper.Set("peer.endorser.sharding.prepareBatch.maxSize", 0)
+
+	viper.Set("peer.endorser.sharding.leaderElectionShard", "endorsers-mychannel")
+	conf, _, err = endorserConfig()
+	require.NoError(t, err)
+	require.Equal(t, "endorsers-mychannel", conf.LeaderElectionShard)
+
+	viper.Set("peer.endorser.sharding.leaderCandidates", []string{"peer1:7051", "peer2:7051"})
+	viper.Set("peer.endorser.sharding.leaderFailoverThreshold", 3)
+	conf, _, err = endorserConfig()
+	require.NoError(t, err)
+	require.Equal(t, []string{"peer1:7051", "peer2:7051"}, conf.LeaderCandidates)
+	require.Equal(t, 3, conf.LeaderFailoverThreshold)
+
+	viper.Set("peer.endorser.sharding.leaderFailoverThreshold", -1)
+	_, _, err = endorserConfig()
+	require.EqualError(t, err, "peer.endorser.sharding.leaderFailoverThreshold must not be negative, got -1")
+	viper.Set("peer.endorser.sharding.leaderFailoverThreshold", 0)
+
+	viper.Set("peer.endorser.sharding.role", "follower")
+	_, _, err = endorserConfig()
+	require.EqualError(t, err, `invalid peer.endorser.sharding.role: unknown endorser role "follower", expected normal or leader`)
+
+	viper.Set("peer.endorser.sharding.role", "normal")
+	viper.Set("peer.endorser.sharding.prepareTimeout", "0s")
+	_, _, err = endorserConfig()
+	require.ErrorContains(t, err, "must be positive")
+}
+
+func TestCommitterConflictDetector(t *testing.T) {
+	defer viper.Reset()
+
+	detector, err := committerConflictDetector()
+	require.NoError(t, err)
+	keyOverlap, err := committer.NewConflictDetector(committer.ConflictPolicyKeyOverlap)
+	require.NoError(t, err)
+	require.Equal(t, keyOverlap, detector)
+
+	viper.Set("peer.committer.conflictPolicy", "mvcc")
+	detector, err = committerConflictDetector()
+	require.NoError(t, err)
+	mvcc, err := committer.NewConflictDetector(committer.ConflictPolicyMVCC)
+	require.NoError(t, err)
+	require.Equal(t, mvcc, detector)
+
+	viper.Set("peer.committer.conflictPolicy", "optimistic")
+	_, err = committerConflictDetector()
+	require.EqualError(t, err, `invalid peer.committer.conflictPolicy "optimistic", expected key-overlap, mvcc or endorser-hints`)
+}
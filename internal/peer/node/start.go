@@ -49,6 +49,7 @@ import (
 	"github.com/hyperledger/fabric/core/chaincode/lifecycle"
 	"github.com/hyperledger/fabric/core/chaincode/persistence"
 	"github.com/hyperledger/fabric/core/chaincode/platforms"
+	"github.com/hyperledger/fabric/core/committer"
 	"github.com/hyperledger/fabric/core/committer/txvalidator/plugin"
 	"github.com/hyperledger/fabric/core/common/ccprovider"
 	"github.com/hyperledger/fabric/core/common/privdata"
@@ -60,6 +61,7 @@ import (
 	"github.com/hyperledger/fabric/core/dispatcher"
 	"github.com/hyperledger/fabric/core/endorser"
 	"github.com/hyperledger/fabric/core/endorser/sharding"
+	shardingprotos "github.com/hyperledger/fabric/core/endorser/sharding/protos"
 	authHandler "github.com/hyperledger/fabric/core/handlers/auth"
 	endorsement2 "github.com/hyperledger/fabric/core/handlers/endorsement/api"
 	endorsement3 "github.com/hyperledger/fabric/core/handlers/endorsement/api/identities"
@@ -299,12 +301,19 @@ func serve(args []string) error {
 
 	deliverServiceConfig := deliverservice.GlobalConfig()
 
+	conflictDetector, err := committerConflictDetector()
+	if err != nil {
+		return errors.WithMessage(err, "failed to load the committer configuration")
+	}
+
 	peerInstance := &peer.Peer{
 		ServerConfig:             serverConfig,
 		CredentialSupport:        cs,
 		StoreProvider:            transientStoreProvider,
 		CryptoProvider:           factory.GetDefault(),
 		OrdererEndpointOverrides: deliverServiceConfig.OrdererEndpointOverrides,
+		ConflictDetector:         conflictDetector,
+		CommitterMetrics:         committer.NewMetrics(metricsProvider),
 	}
 
 	identityDeserializerFactory := func(channelName string) msp.IdentityDeserializer {
@@ -753,13 +762,114 @@ func serve(args []string) error {
 	channelFetcher := endorserChannelAdapter{
 		peer: peerInstance,
 	}
+	dependencyStore, err := sharding.NewDependencyStoreFromEnv()
+	if err != nil {
+		logger.Panicf("Failed to initialize dependency store: %s", err)
+	}
+	proofVerifier, err := sharding.NewProofVerifierFromEnv()
+	if err != nil {
+		logger.Panicf("Failed to load shard replica keys: %s", err)
+	}
+	endorserConf, shardManagerOpts, err := endorserConfig()
+	if err != nil {
+		logger.Panicf("Failed to load the endorser configuration: %s", err)
+	}
+	shardTopology, err := loadShardTopology(shardManagerOpts)
+	if err != nil {
+		logger.Panicf("Failed to load shard topology: %s", err)
+	}
+	var channelDependencyStores map[string]sharding.DependencyStore
+	for channel, conf := range endorserConf.DependencyStores {
+		if conf.Backend == "grpc" {
+			if conf.TLS, err = sharding.TransportTLSFromEnv(); err != nil {
+				logger.Panicf("Failed to load the TLS configuration of the conflict service of channel %s: %s", channel, err)
+			}
+		}
+		store, err := sharding.NewDependencyStore(conf)
+		if err != nil {
+			logger.Panicf("Failed to initialize the dependency store of channel %s: %s", channel, err)
+		}
+		if channelDependencyStores == nil {
+			channelDependencyStores = make(map[string]sharding.DependencyStore)
+		}
+		channelDependencyStores[channel] = store
+	}
+	endorserMetrics := endorser.NewMetrics(metricsProvider)
 	serverEndorser := &endorser.Endorser{
 		PrivateDataDistributor: gossipService,
 		ChannelFetcher:         channelFetcher,
 		LocalMSP:               localMSP,
 		Support:                endorserSupport,
-		Metrics:                endorser.NewMetrics(metricsProvider),
-		ShardManager:           sharding.NewShardManager(nil, nil),
+		Metrics:                endorserMetrics,
+		Config:                 endorserConf,
+		ShardManager:           sharding.NewShardManagerWithOptions(nil, shardTopology, endorserMetrics, shardManagerOpts),
+		DependencyStore:        dependencyStore,
+		ProofVerifier:          proofVerifier,
+		ShardCircuitBreakers:   endorser.NewShardCircuitBreakersFromEnv(endorserMetrics),
+
+		ChannelDependencyStores: channelDependencyStores,
+	}
+	if endorserConf.ConflictOracle.Address != "" {
+		tlsConfig, err := sharding.TransportTLSFromEnv()
+		if err != nil {
+			logger.Panicf("Failed to load the TLS configuration of the conflict oracle: %s", err)
+		}
+		oracle, err := sharding.NewGRPCConflictOracle(endorserConf.ConflictOracle.Address, tlsConfig)
+		if err != nil {
+			logger.Panicf("Failed to connect to the conflict oracle: %s", err)
+		}
+		serverEndorser.ConflictOracle = oracle
+	}
+	if endorserConf.TwoPhaseCommit {
+		if dependencyStore != nil {
+			logger.Panicf("peer.endorser.sharding.twoPhaseCommit requires the embedded shards, not the %s dependency store", os.Getenv(sharding.DependencyStoreEnvVar))
+		}
+		// The decision log is kept next to the shards' state, so that the
+		// transactions in flight are recovered after a crash
+		coordinatorDir := shardManagerOpts.DataDir
+		if coordinatorDir == "" {
+			coordinatorDir = os.Getenv(sharding.ShardDataDirEnvVar)
+		}
+		if coordinatorDir != "" {
+			coordinatorDir = filepath.Join(coordinatorDir, "coordinator")
+		}
+		coordinator, err := sharding.NewCoordinator(serverEndorser.ShardManager, sharding.CoordinatorConfig{
+			Dir:         coordinatorDir,
+			GiveUpAfter: endorserConf.ExpiryDuration,
+		})
+		if err != nil {
+			logger.Panicf("Failed to start the two-phase commit coordinator: %s", err)
+		}
+		serverEndorser.Coordinator = coordinator
+	}
+	if endorserConf.RecoveryPolicy != "" {
+		// The endorsements returned are journaled next to the shards' state,
+		// so that recovery leaves the transactions in flight to the orderer
+		journalDir := shardManagerOpts.DataDir
+		if journalDir == "" {
+			journalDir = os.Getenv(sharding.ShardDataDirEnvVar)
+		}
+		if journalDir == "" {
+			logger.Warningf("Not recovering the prepares in flight, as the shards keep no data directory")
+		} else {
+			journal, err := endorser.NewEndorsementJournal(filepath.Join(journalDir, "endorsements"))
+			if err != nil {
+				logger.Panicf("Failed to open the endorsement journal: %s", err)
+			}
+			serverEndorser.Journal = journal
+			// The shards replay their logs and elect their leaders meanwhile
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), endorser.DefaultRecoveryTimeout)
+				defer cancel()
+				if _, err := serverEndorser.RecoverPrepares(ctx); err != nil {
+					logger.Warningf("Failed to recover the prepares in flight: %s", err)
+				}
+			}()
+		}
+	}
+	opsSystem.RegisterHandler(endorser.AdminPath, endorser.NewAdminHandler(serverEndorser), coreConfig.OperationsTLSEnabled)
+	if err := opsSystem.RegisterChecker("endorser", serverEndorser); err != nil {
+		logger.Panicf("failed to register endorser health check: %s", err)
 	}
 
 	// deploy system chaincodes
@@ -913,6 +1023,8 @@ func serve(args []string) error {
 	auth := authHandler.ChainFilters(serverEndorser, authFilters...)
 	// Register the Endorser server
 	pb.RegisterEndorserServer(peerServer.Server(), auth)
+	// Batches of proposals go through the same filters, one proposal at a time
+	shardingprotos.RegisterBatchEndorserServer(peerServer.Server(), endorser.NewBatchServer(serverEndorser, auth))
 
 	// register the snapshot server
 	snapshotSvc := &snapshotgrpc.SnapshotService{LedgerGetter: peerInstance, ACLProvider: aclProvider}